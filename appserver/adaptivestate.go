@@ -0,0 +1,115 @@
+package appserver
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"time"
+
+	"go-php/server"
+)
+
+// adaptiveSaveInterval is how often the adaptive state file is rewritten
+// while the learned routes change.
+const adaptiveSaveInterval = 30 * time.Second
+
+// adaptiveState is the adaptive_state_file format: the prefixes each app
+// promoted to its slow pool.
+type adaptiveState struct {
+	Saved time.Time                        `json:"saved"`
+	Apps  map[string][]server.LearnedRoute `json:"apps"`
+}
+
+// loadAdaptiveState restores the routes saved in path to every app. A
+// missing file is not an error: nothing has been learned yet.
+func loadAdaptiveState(path string, vhosts *vhostRouter) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var st adaptiveState
+	if err := json.Unmarshal(data, &st); err != nil {
+		return err
+	}
+	for _, app := range vhosts.all() {
+		if routes := st.Apps[app.name]; len(routes) > 0 {
+			app.srv.RestoreLearnedRoutes(routes)
+			log.Printf("[adaptive] %s: restored %d learned prefixes from %s", app.name, len(routes), path)
+		}
+	}
+	return nil
+}
+
+// learnedRoutes collects the promoted prefixes of every app.
+func learnedRoutes(vhosts *vhostRouter) map[string][]server.LearnedRoute {
+	apps := make(map[string][]server.LearnedRoute)
+	for _, app := range vhosts.all() {
+		if routes := app.srv.LearnedRoutes(); len(routes) > 0 {
+			apps[app.name] = routes
+		}
+	}
+	return apps
+}
+
+func saveAdaptiveState(path string, apps map[string][]server.LearnedRoute) error {
+	data, err := json.MarshalIndent(adaptiveState{Saved: time.Now(), Apps: apps}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, append(data, '\n'))
+}
+
+// startAdaptiveState loads path, then saves the learned routes to it every
+// interval if they changed, and once more when the returned func is called.
+func startAdaptiveState(path string, vhosts *vhostRouter, interval time.Duration) (func(), error) {
+	if err := loadAdaptiveState(path, vhosts); err != nil {
+		return nil, err
+	}
+
+	done, finished := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(finished)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		var last []byte
+		for {
+			stopping := false
+			select {
+			case <-ticker.C:
+			case <-done:
+				stopping = true
+			}
+
+			apps := learnedRoutes(vhosts)
+			// Compare without the times, which move with every request.
+			key, _ := json.Marshal(prefixesOf(apps))
+			if stopping || string(key) != string(last) {
+				if err := saveAdaptiveState(path, apps); err != nil {
+					log.Printf("[adaptive] save %s: %v", path, err)
+				} else {
+					last = key
+				}
+			}
+			if stopping {
+				return
+			}
+		}
+	}()
+	return func() { close(done); <-finished }, nil
+}
+
+func prefixesOf(apps map[string][]server.LearnedRoute) map[string][]string {
+	out := make(map[string][]string, len(apps))
+	for name, routes := range apps {
+		for _, r := range routes {
+			out[name] = append(out[name], r.Prefix)
+		}
+	}
+	return out
+}
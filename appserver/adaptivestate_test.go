@@ -0,0 +1,73 @@
+package appserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go-php/server"
+	"go-php/server/testkit"
+)
+
+func newAdaptiveTestServer(t *testing.T) *Server {
+	t.Helper()
+	addr := testkit.Listen(t, func(*server.RequestPayload) testkit.Reply { return testkit.Respond(http.StatusOK, "ok") })
+	srv, err := New(&AppServerConfig{
+		Root:     t.TempDir(),
+		Pools:    []PoolConfig{{Name: "fast", Workers: 1, Transport: "tcp", Address: addr}},
+		Adaptive: server.AdaptiveConfig{PromoteMs: 100, MinSamples: 2},
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	t.Cleanup(func() { _ = srv.Shutdown(t.Context()) })
+	return srv
+}
+
+func TestAdaptiveStateFileRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "adaptive.json")
+
+	first := newAdaptiveTestServer(t)
+	stop, err := startAdaptiveState(path, first.vhosts, time.Hour)
+	if err != nil {
+		t.Fatalf("start with no file: %v", err)
+	}
+	first.vhosts.def.srv.RecordLatency("/reports/daily", time.Second)
+	first.vhosts.def.srv.RecordLatency("/reports/daily", time.Second)
+	stop()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var st adaptiveState
+	if err := json.Unmarshal(data, &st); err != nil {
+		t.Fatal(err)
+	}
+	if routes := st.Apps["default"]; len(routes) != 1 || routes[0].Prefix != "/reports" {
+		t.Fatalf("saved %s", data)
+	}
+
+	second := newAdaptiveTestServer(t)
+	stop, err = startAdaptiveState(path, second.vhosts, time.Hour)
+	if err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	defer stop()
+	if got := second.vhosts.def.srv.Adaptive().Promoted; len(got) != 1 || got[0] != "/reports" {
+		t.Fatalf("promoted after restart = %v, want /reports", got)
+	}
+}
+
+func TestAdaptiveStateFileBad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "adaptive.json")
+	if err := os.WriteFile(path, []byte("{"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := startAdaptiveState(path, newAdaptiveTestServer(t).vhosts, time.Hour); err == nil {
+		t.Fatal("a corrupt state file should be reported")
+	}
+}
@@ -0,0 +1,132 @@
+package appserver
+
+import (
+	"crypto/subtle"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+)
+
+// AdminConfig protects management endpoints: everything under
+// /__baremetal/ plus the realtime publish endpoints. Requests must carry
+// "Authorization: Bearer <Token>" (Token falls back to APP_ADMIN_TOKEN);
+// without a token configured, only loopback and unix socket clients are let
+// in.
+type AdminConfig struct {
+	Token string `json:"token"`
+
+	// Public lists management paths that skip auth, typically the health
+	// and readiness probes a load balancer polls.
+	Public []string `json:"public"`
+
+	// Listen moves the management endpoints to their own listener
+	// ("127.0.0.1:9090" or "unix:/run/baremetal-admin.sock"); the public
+	// listener then answers them with 404, except for Public paths.
+	Listen string `json:"listen"`
+
+	// Pprof exposes net/http/pprof under /__baremetal/debug/pprof/.
+	Pprof bool `json:"pprof"`
+}
+
+// EffectiveToken is Token, or APP_ADMIN_TOKEN when the config leaves it empty.
+func (a AdminConfig) EffectiveToken() string {
+	if a.Token != "" {
+		return a.Token
+	}
+	return os.Getenv("APP_ADMIN_TOKEN")
+}
+
+// authorized reports whether r may use management endpoints.
+func (a AdminConfig) authorized(r *http.Request) bool {
+	if token := a.EffectiveToken(); token != "" {
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		return ok && subtle.ConstantTimeCompare([]byte(strings.TrimSpace(got)), []byte(token)) == 1
+	}
+	return isLoopback(r.RemoteAddr)
+}
+
+// requireAdmin rejects requests that aren't authorized for management use.
+func requireAdmin(a AdminConfig, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !a.authorized(r) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="baremetal-admin"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// publishPaths are the realtime publish endpoints, which let the caller push
+// arbitrary messages to every subscriber.
+var publishPaths = []string{"/__sse/publish", "/__ws/publish"}
+
+// isManagementPath reports whether path is a management endpoint.
+func isManagementPath(path string) bool {
+	return strings.HasPrefix(path, "/__baremetal/") || slices.Contains(publishPaths, path)
+}
+
+// adminGuard puts every management endpoint behind requireAdmin, except the
+// configured Public paths and the dashboard page itself (which holds no data
+// and sends the token with each of its API calls).
+func adminGuard(a AdminConfig, next http.Handler) http.Handler {
+	guarded := requireAdmin(a, next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := r.URL.Path
+		if !isManagementPath(path) || path == "/__baremetal/dashboard" || slices.Contains(a.Public, path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		guarded.ServeHTTP(w, r)
+	})
+}
+
+// splitListeners divides mux between the public listener and the
+// management listener configured in a.Listen.
+func splitListeners(a AdminConfig, mux http.Handler) (public, admin http.Handler) {
+	public = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isManagementPath(r.URL.Path) && !slices.Contains(a.Public, r.URL.Path) {
+			http.NotFound(w, r)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+
+	guarded := adminGuard(a, mux)
+	admin = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isManagementPath(r.URL.Path) {
+			http.NotFound(w, r)
+			return
+		}
+		guarded.ServeHTTP(w, r)
+	})
+	return public, admin
+}
+
+// listenAdmin opens the management listener. A "unix:" address replaces a
+// stale socket file left by a previous run.
+func listenAdmin(addr string) (net.Listener, error) {
+	if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		return net.Listen("unix", path)
+	}
+	return net.Listen("tcp", addr)
+}
+
+// isLoopback reports whether remoteAddr is a loopback address or an
+// unnamed unix socket peer, which net/http reports as "@".
+func isLoopback(remoteAddr string) bool {
+	if remoteAddr == "@" {
+		return true
+	}
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
@@ -0,0 +1,133 @@
+package appserver
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAdminGuardProtectsManagementEndpoints(t *testing.T) {
+	t.Setenv("APP_ADMIN_TOKEN", "")
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	h := adminGuard(AdminConfig{Public: []string{"/__baremetal/ready"}}, ok)
+
+	cases := []struct {
+		path, remote string
+		want         int
+	}{
+		{"/__baremetal/recycle", "203.0.113.9:5000", http.StatusUnauthorized},
+		{"/__baremetal/metrics", "203.0.113.9:5000", http.StatusUnauthorized},
+		{"/__sse/publish", "203.0.113.9:5000", http.StatusUnauthorized},
+		{"/__ws/publish", "203.0.113.9:5000", http.StatusUnauthorized},
+		{"/__baremetal/metrics", "127.0.0.1:5000", http.StatusOK},
+		{"/__baremetal/ready", "203.0.113.9:5000", http.StatusOK},
+		{"/__baremetal/dashboard", "203.0.113.9:5000", http.StatusOK},
+		{"/__sse", "203.0.113.9:5000", http.StatusOK},
+		{"/users/1", "203.0.113.9:5000", http.StatusOK},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest(http.MethodPost, c.path, nil)
+		req.RemoteAddr = c.remote
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		if rr.Code != c.want {
+			t.Errorf("%s from %s: got %d, want %d", c.path, c.remote, rr.Code, c.want)
+		}
+	}
+}
+
+func TestAdminGuardAcceptsToken(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	h := adminGuard(AdminConfig{Token: "s3cret"}, ok)
+
+	req := httptest.NewRequest(http.MethodPost, "/__baremetal/recycle", nil)
+	req.RemoteAddr = "127.0.0.1:5000"
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("with a token set, loopback alone is not enough: got %d", rr.Code)
+	}
+
+	req.Header.Set("Authorization", "Bearer s3cret")
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("with token: got %d, want 200", rr.Code)
+	}
+}
+
+func TestSplitListeners(t *testing.T) {
+	t.Setenv("APP_ADMIN_TOKEN", "")
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	public, admin := splitListeners(AdminConfig{Listen: "127.0.0.1:0", Public: []string{"/__baremetal/ready"}}, ok)
+
+	serve := func(h http.Handler, path string) int {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.RemoteAddr = "127.0.0.1:5000"
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	if got := serve(public, "/__baremetal/metrics"); got != http.StatusNotFound {
+		t.Errorf("public listener served metrics: %d", got)
+	}
+	if got := serve(public, "/__sse/publish"); got != http.StatusNotFound {
+		t.Errorf("public listener served publish: %d", got)
+	}
+	if got := serve(public, "/__baremetal/ready"); got != http.StatusOK {
+		t.Errorf("public probe path should stay on the public listener: %d", got)
+	}
+	if got := serve(public, "/"); got != http.StatusOK {
+		t.Errorf("app traffic on public listener: %d", got)
+	}
+	if got := serve(admin, "/__baremetal/metrics"); got != http.StatusOK {
+		t.Errorf("admin listener metrics: %d", got)
+	}
+	if got := serve(admin, "/"); got != http.StatusNotFound {
+		t.Errorf("admin listener should not serve the app: %d", got)
+	}
+}
+
+func TestListenAdminUnixSocket(t *testing.T) {
+	path := t.TempDir() + "/admin.sock"
+	for i := 0; i < 2; i++ { // the second run replaces the stale socket
+		ln, err := listenAdmin("unix:" + path)
+		if err != nil {
+			t.Fatalf("listenAdmin: %v", err)
+		}
+		if ln.Addr().Network() != "unix" {
+			t.Fatalf("network = %s", ln.Addr().Network())
+		}
+		if l, ok := ln.(interface{ SetUnlinkOnClose(bool) }); ok {
+			l.SetUnlinkOnClose(false)
+		}
+		_ = ln.Close()
+	}
+}
+
+func TestAdminGuardTrustsUnixSocket(t *testing.T) {
+	t.Setenv("APP_ADMIN_TOKEN", "")
+	path := t.TempDir() + "/admin.sock"
+	ln, err := listenAdmin("unix:" + path)
+	if err != nil {
+		t.Fatalf("listenAdmin: %v", err)
+	}
+	srv := &http.Server{Handler: adminGuard(AdminConfig{}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))}
+	go srv.Serve(ln)
+	t.Cleanup(func() { _ = srv.Close() })
+
+	c := &http.Client{Transport: &http.Transport{DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+		return new(net.Dialer).DialContext(ctx, "unix", path)
+	}}}
+	resp, err := c.Get("http://admin/__baremetal/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unix socket client: got %d, want 200", resp.StatusCode)
+	}
+}
@@ -0,0 +1,34 @@
+package appserver
+
+import (
+	"net/http"
+
+	"go-php/server"
+)
+
+// AffinityConfig pins a session to one PHP worker. The key is the
+// authenticated user ID (same JWT / bm_user_id rules as the realtime
+// endpoints) when User is set, otherwise the Cookie or Header value.
+type AffinityConfig struct {
+	Cookie string `json:"cookie"`
+	Header string `json:"header"`
+	User   bool   `json:"user"`
+}
+
+func (a AffinityConfig) server(auth *wsAuth) server.Affinity {
+	aff := server.Affinity{Cookie: a.Cookie, Header: a.Header}
+	if a.User {
+		aff.Key = func(req *server.RequestPayload) string { return userAffinityKey(auth, req) }
+	}
+	return aff
+}
+
+// userAffinityKey returns the authenticated user ID for req, or "".
+func userAffinityKey(auth *wsAuth, req *server.RequestPayload) string {
+	r := &http.Request{Header: http.Header(req.Headers)}
+	id, err := authenticateWS(auth, r)
+	if err != nil {
+		return ""
+	}
+	return id
+}
@@ -0,0 +1,226 @@
+package appserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// AlertConfig has the server watch its own metrics and post to a webhook
+// when a rule has been breached for long enough, and again once it clears.
+// The payload's "text" field is what Slack incoming webhooks display.
+type AlertConfig struct {
+	WebhookURL string      `json:"webhook_url"`
+	IntervalMs int         `json:"interval_ms"` // how often rules are checked (default 15000)
+	Rules      []AlertRule `json:"rules"`
+}
+
+// AlertRule fires when Metric stays above Above for ForMs.
+type AlertRule struct {
+	Name string `json:"name"`
+	// Metric is AlertErrorRate, AlertDeadWorkers or AlertP95.
+	Metric string  `json:"metric"`
+	Above  float64 `json:"above"`
+	ForMs  int     `json:"for_ms"`
+	// Pool limits the rule to one pool; empty watches all of them.
+	Pool string `json:"pool"`
+	// MinRequests is how many requests the last minute must have seen
+	// before error_rate and p95_ms are trusted (default 10).
+	MinRequests int `json:"min_requests"`
+}
+
+// Metrics an AlertRule can watch.
+const (
+	AlertErrorRate   = "error_rate"   // percent of requests failed in the last minute
+	AlertDeadWorkers = "dead_workers" // workers currently dead
+	AlertP95         = "p95_ms"       // 95th percentile latency in the last minute
+)
+
+const (
+	defaultAlertInterval    = 15 * time.Second
+	defaultAlertMinRequests = 10
+)
+
+// AlertPayload is the JSON body posted to the webhook.
+type AlertPayload struct {
+	Text      string    `json:"text"`
+	Alert     string    `json:"alert"`
+	Status    string    `json:"status"` // "firing" or "resolved"
+	Metric    string    `json:"metric"`
+	Pool      string    `json:"pool,omitempty"`
+	Value     float64   `json:"value"`
+	Threshold float64   `json:"threshold"`
+	Time      time.Time `json:"time"`
+}
+
+type alertState struct {
+	since  time.Time // when the current breach began; zero if none
+	firing bool
+}
+
+// alerter checks the rules in cfg against the server's metrics.
+type alerter struct {
+	cfg     AlertConfig
+	metrics *Metrics
+	vhosts  *vhostRouter
+	client  *http.Client
+	state   []alertState // by rule
+}
+
+func newAlerter(cfg AlertConfig, metrics *Metrics, vhosts *vhostRouter) *alerter {
+	return &alerter{
+		cfg:     cfg,
+		metrics: metrics,
+		vhosts:  vhosts,
+		client:  &http.Client{Timeout: 5 * time.Second},
+		state:   make([]alertState, len(cfg.Rules)),
+	}
+}
+
+func (a *alerter) run(done <-chan struct{}) {
+	interval := defaultAlertInterval
+	if a.cfg.IntervalMs > 0 {
+		interval = time.Duration(a.cfg.IntervalMs) * time.Millisecond
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			a.check(now)
+		case <-done:
+			return
+		}
+	}
+}
+
+// check evaluates every rule as of now, posting to the webhook for each one
+// that starts firing or resolves. A post that fails is retried on the next
+// check.
+func (a *alerter) check(now time.Time) {
+	for i, rule := range a.cfg.Rules {
+		st := &a.state[i]
+		value, ok := a.value(rule)
+		breached := ok && value > rule.Above
+		if !breached {
+			st.since = time.Time{}
+			if st.firing && a.post(rule, "resolved", value, now) {
+				st.firing = false
+			}
+			continue
+		}
+		if st.since.IsZero() {
+			st.since = now
+		}
+		if !st.firing && now.Sub(st.since) >= time.Duration(rule.ForMs)*time.Millisecond {
+			st.firing = a.post(rule, "firing", value, now)
+		}
+	}
+}
+
+// value returns rule's metric now, or false if there is too little traffic
+// to judge it.
+func (a *alerter) value(rule AlertRule) (float64, bool) {
+	if rule.Metric == AlertDeadWorkers {
+		dead := 0
+		for _, app := range a.vhosts.all() {
+			for name, p := range app.srv.Health().Pools {
+				if rule.Pool == "" || name == rule.Pool {
+					dead += p.DeadWorkers
+				}
+			}
+		}
+		return float64(dead), true
+	}
+
+	w := a.metrics.poolWindows(rule.Pool)["1m"]
+	minRequests := uint64(defaultAlertMinRequests)
+	if rule.MinRequests > 0 {
+		minRequests = uint64(rule.MinRequests)
+	}
+	if w.Requests < minRequests {
+		return 0, false
+	}
+	if rule.Metric == AlertErrorRate {
+		return w.ErrorRate * 100, true
+	}
+	return w.P95Ms, true
+}
+
+// post sends one notification and reports whether the webhook took it.
+func (a *alerter) post(rule AlertRule, status string, value float64, now time.Time) bool {
+	p := AlertPayload{
+		Alert:     rule.Name,
+		Status:    status,
+		Metric:    rule.Metric,
+		Pool:      rule.Pool,
+		Value:     value,
+		Threshold: rule.Above,
+		Time:      now,
+	}
+	scope := "all pools"
+	if rule.Pool != "" {
+		scope = "pool " + rule.Pool
+	}
+	if status == "firing" {
+		p.Text = fmt.Sprintf(":rotating_light: %s firing: %s is %.4g on %s (threshold %.4g)", rule.Name, rule.Metric, value, scope, rule.Above)
+	} else {
+		p.Text = fmt.Sprintf(":white_check_mark: %s resolved: %s is %.4g on %s", rule.Name, rule.Metric, value, scope)
+	}
+
+	body, _ := json.Marshal(p)
+	resp, err := a.client.Post(a.cfg.WebhookURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		log.Printf("[alerts] %s %s: %v", rule.Name, status, err)
+		return false
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		log.Printf("[alerts] %s %s: webhook answered %s", rule.Name, status, resp.Status)
+		return false
+	}
+	log.Printf("[alerts] %s %s (%s=%.4g)", rule.Name, status, rule.Metric, value)
+	return true
+}
+
+func validateAlerts(cfg *AppServerConfig, warn configWarnFunc) {
+	ac := cfg.Alerts
+	if ac == nil {
+		return
+	}
+	if _, err := parseUpstream(ac.WebhookURL); err != nil {
+		warn("alerts.webhook_url", "alerts.webhook_url %q must be an absolute http(s) URL, alerts disabled", ac.WebhookURL)
+		cfg.Alerts = nil
+		return
+	}
+	if ac.IntervalMs < 0 {
+		warn("alerts.interval_ms", "alerts.interval_ms=%d is invalid, using %d", ac.IntervalMs, defaultAlertInterval.Milliseconds())
+		ac.IntervalMs = 0
+	}
+	rules := ac.Rules[:0]
+	for i, r := range ac.Rules {
+		path := fmt.Sprintf("alerts.rules[%d]", i)
+		switch r.Metric {
+		case AlertErrorRate, AlertDeadWorkers, AlertP95:
+		default:
+			warn(path+".metric", "%s.metric %q is not one of %s, %s, %s; rule dropped", path, r.Metric, AlertErrorRate, AlertDeadWorkers, AlertP95)
+			continue
+		}
+		if r.Name == "" {
+			r.Name = r.Metric
+		}
+		if r.ForMs < 0 {
+			warn(path+".for_ms", "%s.for_ms=%d is invalid, firing at once", path, r.ForMs)
+			r.ForMs = 0
+		}
+		if r.MinRequests < 0 {
+			warn(path+".min_requests", "%s.min_requests=%d is invalid, using %d", path, r.MinRequests, defaultAlertMinRequests)
+			r.MinRequests = 0
+		}
+		rules = append(rules, r)
+	}
+	ac.Rules = rules
+}
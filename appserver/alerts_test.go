@@ -0,0 +1,132 @@
+package appserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// webhook records the alerts posted to it, answering with status.
+func webhook(t *testing.T, status *int) (string, func() []AlertPayload) {
+	t.Helper()
+	var mu sync.Mutex
+	var got []AlertPayload
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var p AlertPayload
+		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+			t.Errorf("decode alert: %v", err)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if *status == http.StatusOK {
+			got = append(got, p)
+		}
+		w.WriteHeader(*status)
+	}))
+	t.Cleanup(ts.Close)
+	return ts.URL, func() []AlertPayload {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]AlertPayload(nil), got...)
+	}
+}
+
+func TestAlertFiresAfterForAndResolves(t *testing.T) {
+	status := http.StatusOK
+	url, posted := webhook(t, &status)
+	m := NewMetrics()
+	a := newAlerter(AlertConfig{WebhookURL: url, Rules: []AlertRule{
+		{Name: "errors", Metric: AlertErrorRate, Above: 20, ForMs: 60_000, Pool: "fast"},
+	}}, m, nil)
+
+	for i := 0; i < 10; i++ {
+		m.StartRequest("/a")
+		m.EndPoolRequest("/a", "fast", time.Millisecond, i < 5)
+	}
+	now := time.Now()
+	a.check(now)
+	a.check(now.Add(30 * time.Second))
+	if got := posted(); len(got) != 0 {
+		t.Fatalf("posted %v before for_ms passed", got)
+	}
+
+	status = http.StatusInternalServerError
+	a.check(now.Add(time.Minute))
+	status = http.StatusOK
+	a.check(now.Add(time.Minute + time.Second))
+	got := posted()
+	if len(got) != 1 || got[0].Status != "firing" || got[0].Alert != "errors" || got[0].Value != 50 || got[0].Threshold != 20 {
+		t.Fatalf("posted %+v, want one firing alert at 50%%", got)
+	}
+	if !strings.Contains(got[0].Text, "errors firing") || !strings.Contains(got[0].Text, "pool fast") {
+		t.Fatalf("text = %q", got[0].Text)
+	}
+
+	a.check(now.Add(2 * time.Minute))
+	if got := posted(); len(got) != 1 {
+		t.Fatalf("posted %d alerts, want no repeat while still firing", len(got))
+	}
+
+	for i := 0; i < 90; i++ {
+		m.StartRequest("/a")
+		m.EndPoolRequest("/a", "fast", time.Millisecond, false)
+	}
+	a.check(now.Add(2*time.Minute + time.Second))
+	if got := posted(); len(got) != 2 || got[1].Status != "resolved" || got[1].Value != 5 {
+		t.Fatalf("posted %+v, want a resolved alert at 5%%", got)
+	}
+}
+
+func TestAlertNeedsMinRequests(t *testing.T) {
+	status := http.StatusOK
+	url, posted := webhook(t, &status)
+	m := NewMetrics()
+	a := newAlerter(AlertConfig{WebhookURL: url, Rules: []AlertRule{
+		{Name: "slow", Metric: AlertP95, Above: 100, MinRequests: 5},
+	}}, m, nil)
+
+	for i := 0; i < 4; i++ {
+		m.StartRequest("/slow")
+		m.EndPoolRequest("/slow", "slow", time.Second, false)
+	}
+	a.check(time.Now())
+	if got := posted(); len(got) != 0 {
+		t.Fatalf("posted %v on 4 requests", got)
+	}
+
+	m.StartRequest("/slow")
+	m.EndPoolRequest("/slow", "slow", time.Second, false)
+	a.check(time.Now())
+	if got := posted(); len(got) != 1 || got[0].Metric != AlertP95 || got[0].Value < 100 {
+		t.Fatalf("posted %+v, want a p95 alert", got)
+	}
+}
+
+func TestValidateAlerts(t *testing.T) {
+	cfg := &AppServerConfig{Alerts: &AlertConfig{WebhookURL: "hooks.example.com/x"}}
+	validateAlerts(cfg, logConfig.warn)
+	if cfg.Alerts != nil {
+		t.Fatal("alerts with a relative webhook URL should be disabled")
+	}
+
+	cfg = &AppServerConfig{Alerts: &AlertConfig{
+		WebhookURL: "https://hooks.example.com/x",
+		IntervalMs: -1,
+		Rules: []AlertRule{
+			{Metric: "cpu", Above: 1},
+			{Metric: AlertDeadWorkers, ForMs: -5},
+		},
+	}}
+	validateAlerts(cfg, logConfig.warn)
+	ac := cfg.Alerts
+	if ac.IntervalMs != 0 || len(ac.Rules) != 1 {
+		t.Fatalf("alerts = %+v, want the unknown metric dropped", ac)
+	}
+	if r := ac.Rules[0]; r.Name != AlertDeadWorkers || r.ForMs != 0 {
+		t.Fatalf("rule = %+v", r)
+	}
+}
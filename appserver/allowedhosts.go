@@ -0,0 +1,67 @@
+package appserver
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// requestHost returns r's Host without the port, lower-cased.
+func requestHost(r *http.Request) string {
+	host := strings.ToLower(r.Host)
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.TrimSuffix(host, ".")
+}
+
+// allowedHostsHandler refuses requests whose Host isn't in allowed (exact
+// names, or "*.example.com"), so PHP never builds URLs from a forged Host.
+// A missing Host gets 400, an unknown one 421. Management endpoints are
+// exempt so probes by IP keep working.
+func allowedHostsHandler(allowed []string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isManagementPath(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		host := requestHost(r)
+		if host == "" {
+			http.Error(w, "Bad Request: missing Host", http.StatusBadRequest)
+			return
+		}
+		if !hostMatches(allowed, host) {
+			log.Printf("[hosts] refused Host %q from %s", r.Host, r.RemoteAddr)
+			http.Error(w, "Misdirected Request", http.StatusMisdirectedRequest)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// validateAllowedHosts normalizes the list and reports apps it locks out.
+func validateAllowedHosts(cfg *AppServerConfig, warn configWarnFunc) {
+	if len(cfg.AllowedHosts) == 0 {
+		return
+	}
+	for i, h := range cfg.AllowedHosts {
+		h = strings.ToLower(strings.TrimSpace(h))
+		if host, _, err := net.SplitHostPort(h); err == nil {
+			warn(fmt.Sprintf("allowed_hosts[%d]", i), "allowed_hosts[%d]=%q has a port, which is ignored", i, h)
+			h = host
+		}
+		cfg.AllowedHosts[i] = h
+	}
+	for i, a := range cfg.Apps {
+		for _, h := range a.Hosts {
+			h = strings.ToLower(strings.TrimSpace(h))
+			// a wildcard app host counts as covered if a name under it is
+			probe := strings.Replace(h, "*", "x", 1)
+			if !hostMatches(cfg.AllowedHosts, h) && !hostMatches(cfg.AllowedHosts, probe) {
+				warn(fmt.Sprintf("apps[%d].hosts", i), "apps[%d] (%s) host %q is not in allowed_hosts and will be refused", i, a.Name, h)
+			}
+		}
+	}
+}
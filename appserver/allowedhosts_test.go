@@ -0,0 +1,50 @@
+package appserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go-php/server"
+)
+
+func TestAllowedHosts(t *testing.T) {
+	srv, err := New(&AppServerConfig{
+		Root: t.TempDir(),
+		Pools: []PoolConfig{{Name: "fast", Workers: 1, Transport: "tcp", Address: fakePHP(t, func(req *server.RequestPayload) *server.ResponsePayload {
+			return &server.ResponsePayload{Status: http.StatusOK, Body: []byte("ok")}
+		})}},
+		AllowedHosts: []string{"Example.com", "*.example.net"},
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	t.Cleanup(func() { _ = srv.Shutdown(t.Context()) })
+
+	for host, want := range map[string]int{
+		"example.com":      http.StatusOK,
+		"EXAMPLE.com:8080": http.StatusOK,
+		"example.com.":     http.StatusOK,
+		"shop.example.net": http.StatusOK,
+		"example.net":      http.StatusMisdirectedRequest,
+		"evil.test":        http.StatusMisdirectedRequest,
+		"example.com.evil": http.StatusMisdirectedRequest,
+		"":                 http.StatusBadRequest,
+	} {
+		req := httptest.NewRequest("GET", "/reset-password", nil)
+		req.Host = host
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+		if rr.Code != want {
+			t.Errorf("Host %q: got %d, want %d", host, rr.Code, want)
+		}
+	}
+
+	req := httptest.NewRequest("GET", "/__baremetal/health", nil)
+	req.Host = "10.0.0.7:8080"
+	rr := httptest.NewRecorder()
+	srv.ServeHTTP(rr, req)
+	if rr.Code == http.StatusMisdirectedRequest {
+		t.Error("health probe by IP refused")
+	}
+}
@@ -0,0 +1,2440 @@
+package appserver
+
+import (
+	"cmp"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go-php/server" // IMPORTANT: change this if your module path differs
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+type RequestLog struct {
+	Time       time.Time `json:"time"`
+	ID         string    `json:"id"`
+	Method     string    `json:"method"`
+	Path       string    `json:"path"`
+	Status     int       `json:"status"`
+	DurationMs float64   `json:"duration_ms"`
+	RemoteAddr string    `json:"remote_addr,omitempty"`
+	UserAgent  string    `json:"user_agent,omitempty"`
+	Pool       string    `json:"pool,omitempty"`
+	BytesIn    int64     `json:"bytes_in"`  // request body
+	BytesOut   int64     `json:"bytes_out"` // response body
+	QueueMs    float64   `json:"queue_ms"`  // waiting for a free worker
+	WorkerMs   float64   `json:"worker_ms"` // in the PHP worker
+	Error      string    `json:"error,omitempty"`
+}
+
+type RouteMetrics struct {
+	Count        uint64        `json:"count"`
+	TotalLatency time.Duration `json:"total_lacency_ns"`
+	BytesIn      uint64        `json:"bytes_in"`
+	BytesOut     uint64        `json:"bytes_out"`
+
+	// QueueWait and WorkerTime split the latency of requests that reached
+	// a worker into waiting for it and running on it; a saturated pool
+	// shows up in the first, a slow endpoint in the second.
+	QueueWait  time.Duration `json:"queue_wait_ns"`
+	WorkerTime time.Duration `json:"worker_time_ns"`
+
+	// Windows are the last 1m, 5m and 15m, keyed "1m", "5m" and "15m".
+	Windows map[string]WindowStats `json:"windows,omitempty"`
+}
+
+// PoolTraffic is the request and response body bytes one pool handled.
+type PoolTraffic struct {
+	Requests uint64 `json:"requests"`
+	BytesIn  uint64 `json:"bytes_in"`
+	BytesOut uint64 `json:"bytes_out"`
+
+	// Windows are the pool's last 1m, 5m and 15m, like RouteMetrics'.
+	Windows map[string]WindowStats `json:"windows,omitempty"`
+}
+
+// Metrics counts requests for /__baremetal/metrics. A live Metrics is
+// updated through its methods without a global lock: counters are atomic
+// and routes are spread over routeShards shards. Its exported fields are
+// only filled in on the copy Snapshot returns.
+type Metrics struct {
+	TotalRequests uint64                   `json:"total_requests"`
+	TotalErrors   uint64                   `json:"total_errors"`
+	PHPFatals     uint64                   `json:"php_fatals"`   // subset of TotalErrors
+	IPDenied      uint64                   `json:"ip_denied"`    // refused by ip_access, never counted as requests
+	OverLimit     uint64                   `json:"over_limit"`   // refused by limits (connections or in flight)
+	RateLimited   uint64                   `json:"rate_limited"` // refused by rate_limits
+	Panics        uint64                   `json:"panics"`       // handler panics turned into 500s
+	InFlight      uint64                   `json:"in_flight"`
+	BytesIn       uint64                   `json:"bytes_in"`  // request bodies sent to PHP
+	BytesOut      uint64                   `json:"bytes_out"` // response bodies from PHP
+	ByRoute       map[string]*RouteMetrics `json:"by_route"`
+	ByPool        map[string]*PoolTraffic  `json:"by_pool"`
+
+	// Retries is filled in from the apps' retry policies when a snapshot
+	// is served.
+	Retries server.RetryStats `json:"retries"`
+
+	// Static counts requests served by the static rules.
+	Static StaticMetrics `json:"static"`
+
+	// StaticCache is filled in from the static file cache, if enabled.
+	StaticCache *StaticCacheStats `json:"static_cache,omitempty"`
+	// ResponseCache is filled in from the response cache, if enabled.
+	ResponseCache *ResponseCacheStats `json:"response_cache,omitempty"`
+
+	// RoutesDropped counts paths folded into OtherRoute to bound ByRoute.
+	RoutesDropped uint64 `json:"routes_dropped"`
+
+	// ErrorsByCategory counts worker errors by server.ErrorCategory.
+	ErrorsByCategory map[string]uint64 `json:"errors_by_category"`
+
+	// RecentErrors holds the last maxRecentErrors failed requests, oldest first.
+	RecentErrors []ErrorEntry `json:"recent_errors"`
+
+	// Since is set on a delta snapshot: the counters are what happened
+	// after it.
+	Since *time.Time `json:"since,omitempty"`
+
+	taken time.Time // when the snapshot was taken
+
+	requests, failed       atomic.Uint64
+	inFlight               atomic.Int64
+	ipDenied, overLimit    atomic.Uint64
+	rateLimited            atomic.Uint64
+	panics                 atomic.Uint64
+	bytesIn, bytesOut      atomic.Uint64
+	static                 staticCounters
+	pools                  sync.Map // pool name -> *poolCounters
+	routes                 [routeShards]routeShard
+	routeLimits            atomic.Pointer[RouteMetricsConfig]
+	routesTracked, dropped atomic.Int64 // routes in the shards, and folded into OtherRoute
+
+	errMu        sync.Mutex // guards the fields below
+	phpFatals    uint64
+	errorsByKind map[string]uint64
+	recentErrors []ErrorEntry
+
+	deltaMu sync.Mutex // guards the fields below
+	resetAt time.Time
+	scrapes map[string]*Metrics // last delta snapshot per key
+}
+
+// poolCounters is the live form of PoolTraffic.
+type poolCounters struct {
+	requests, bytesIn, bytesOut atomic.Uint64
+
+	mu     sync.Mutex // guards window
+	window rollingWindow
+}
+
+// poolWindows returns pool's rolling windows, or those of every pool
+// together when pool is "".
+func (m *Metrics) poolWindows(pool string) map[string]WindowStats {
+	now := time.Now()
+	var sum rollingWindow
+	m.pools.Range(func(k, v any) bool {
+		if pool == "" || k == pool {
+			pc := v.(*poolCounters)
+			pc.mu.Lock()
+			sum.add(&pc.window, now)
+			pc.mu.Unlock()
+		}
+		return true
+	})
+	return sum.stats(now)
+}
+
+// pool returns name's counters, creating them.
+func (m *Metrics) pool(name string) *poolCounters {
+	v, ok := m.pools.Load(name)
+	if !ok {
+		v, _ = m.pools.LoadOrStore(name, new(poolCounters))
+	}
+	return v.(*poolCounters)
+}
+
+// ErrorEntry is a request that failed in the worker layer.
+type ErrorEntry struct {
+	Time     time.Time `json:"time"`
+	ID       string    `json:"id"`
+	Method   string    `json:"method"`
+	Path     string    `json:"path"`
+	Status   int       `json:"status"`
+	Error    string    `json:"error"`
+	Category string    `json:"category"` // server.ErrorCategory
+}
+
+const maxRecentErrors = 50
+
+// Secret for HMAC JWTs (HS256).  Set in .env
+var jwtSecret = []byte(os.Getenv("APP_JWT_SECRET"))
+
+// wsAuth holds one Server's realtime authentication settings. A nil
+// wsAuth, or a nil field, means that setting isn't configured.
+type wsAuth struct {
+	// verifier replaces the jwtSecret check when ws_auth is configured.
+	verifier *jwtVerifier
+	// cookie, when ws_cookie is configured, accepts only signed fallback
+	// cookies.
+	cookie *cookieVerifier
+	// session, when ws_session is configured, looks users up in the PHP
+	// session store.
+	session *sessionStore
+}
+
+type WSClaims struct {
+	UserID string `json:"sub"`
+	jwt.RegisteredClaims
+}
+
+// authenticateWS extracts the user ID from:
+// 1) Authorization: Bearer <jwt>, checked against ws_auth's keys, or with
+// HS256 + APP_JWT_SECRET when ws_auth isn't configured
+// 2) The PHP session, when ws_session is configured
+// 3) A session cookie (e.g. bm_user_id) as a fallback, which must be signed
+// when ws_cookie is configured
+func authenticateWS(a *wsAuth, r *http.Request) (string, error) {
+	if id, err := verifiedUser(a, r); err == nil {
+		return id, nil
+	}
+	if a == nil || a.cookie == nil {
+		// unsigned: only safe behind something that sets the cookie itself
+		if c, err := r.Cookie("bm_user_id"); err == nil && c.Value != "" {
+			return c.Value, nil
+		}
+	}
+	return "", errors.New("unauthenticated")
+}
+
+// verifiedUser is authenticateWS without the unsigned cookie fallback: it
+// only returns user IDs a client can't make up.
+func verifiedUser(a *wsAuth, r *http.Request) (string, error) {
+	if a == nil {
+		a = &wsAuth{}
+	}
+
+	// Authorization: Bearer <token>
+	auth := r.Header.Get("Authorization")
+	if v := a.verifier; v != nil {
+		if tokenStr, ok := strings.CutPrefix(auth, "Bearer "); ok {
+			claims := &WSClaims{}
+			if err := v.parse(strings.TrimSpace(tokenStr), claims); err == nil && claims.UserID != "" {
+				return claims.UserID, nil
+			}
+		}
+	} else if strings.HasPrefix(auth, "Bearer ") && len(jwtSecret) > 0 {
+		tokenStr := strings.TrimSpace(strings.TrimPrefix(auth, "Bearer "))
+		claims := &WSClaims{}
+		token, err := jwt.ParseWithClaims(tokenStr, claims, func(token *jwt.Token) (interface{}, error) {
+			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+				return nil, errors.New("unexpected signing method")
+			}
+			return jwtSecret, nil
+		})
+
+		if err == nil && token.Valid && claims.UserID != "" {
+			return claims.UserID, nil
+		}
+	}
+
+	// 2) PHP session
+	if s := a.session; s != nil {
+		if id, err := s.userID(r); err == nil {
+			return id, nil
+		}
+	}
+
+	// 3) fallback: signed session cookie containing user id
+	if v := a.cookie; v != nil {
+		if c, err := r.Cookie(v.name); err == nil && c.Value != "" {
+			if id, err := v.userID(c.Value); err == nil {
+				return id, nil
+			}
+		}
+	}
+
+	return "", errors.New("unauthenticated")
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{resetAt: time.Now()}
+}
+
+func (m *Metrics) StartRequest(route string) {
+	m.inFlight.Add(1)
+	m.requests.Add(1)
+	m.withRoute(route, func(*routeEntry) {})
+}
+
+func (m *Metrics) EndRequest(route string, latency time.Duration, err bool) {
+	m.EndPoolRequest(route, "", latency, err)
+}
+
+// EndPoolRequest is EndRequest for a request that pool served, which also
+// counts it in the pool's rolling windows.
+func (m *Metrics) EndPoolRequest(route, pool string, latency time.Duration, err bool) {
+	now := time.Now()
+	m.inFlight.Add(-1)
+	if err {
+		m.failed.Add(1)
+	}
+	m.withRoute(route, func(e *routeEntry) {
+		e.metrics.Count++
+		e.metrics.TotalLatency += latency
+		if e.window == nil {
+			e.window = new(rollingWindow)
+		}
+		e.window.record(now, latency, err)
+	})
+	if pool == "" {
+		return
+	}
+	pc := m.pool(pool)
+	pc.mu.Lock()
+	pc.window.record(now, latency, err)
+	pc.mu.Unlock()
+}
+
+// RecordTiming adds a PHP request's queue wait and worker time to its route.
+func (m *Metrics) RecordTiming(route string, queue, worker time.Duration) {
+	m.withRoute(route, func(e *routeEntry) {
+		e.metrics.QueueWait += queue
+		e.metrics.WorkerTime += worker
+	})
+}
+
+// RecordBytes adds a PHP request's body sizes to the totals, its route
+// and its pool.
+func (m *Metrics) RecordBytes(route, pool string, in, out int64) {
+	m.bytesIn.Add(uint64(in))
+	m.bytesOut.Add(uint64(out))
+	m.withRoute(route, func(e *routeEntry) {
+		e.metrics.BytesIn += uint64(in)
+		e.metrics.BytesOut += uint64(out)
+	})
+	if pool == "" {
+		return
+	}
+	pc := m.pool(pool)
+	pc.requests.Add(1)
+	pc.bytesIn.Add(uint64(in))
+	pc.bytesOut.Add(uint64(out))
+}
+
+// RecordDenied counts a request refused by the IP rules.
+func (m *Metrics) RecordDenied() {
+	m.ipDenied.Add(1)
+}
+
+// RecordOverLimit counts a connection or request refused by the limits.
+func (m *Metrics) RecordOverLimit() {
+	m.overLimit.Add(1)
+}
+
+// RecordRateLimited counts a request refused by the rate limits.
+func (m *Metrics) RecordRateLimited() {
+	m.rateLimited.Add(1)
+}
+
+// RecordPanic counts a handler panic.
+func (m *Metrics) RecordPanic() {
+	m.panics.Add(1)
+}
+
+// RecordError remembers a failed request for the dashboard.
+func (m *Metrics) RecordError(req *server.RequestPayload, status int, err error) {
+	category := server.ErrorCategory(err)
+	entry := ErrorEntry{
+		Time:     time.Now(),
+		ID:       req.ID,
+		Method:   req.Method,
+		Path:     req.Path,
+		Status:   status,
+		Error:    err.Error(),
+		Category: category,
+	}
+
+	m.errMu.Lock()
+	defer m.errMu.Unlock()
+	if errors.Is(err, server.ErrPHPFatal) {
+		m.phpFatals++
+	}
+	if m.errorsByKind == nil {
+		m.errorsByKind = make(map[string]uint64)
+	}
+	m.errorsByKind[category]++
+	m.recentErrors = append(m.recentErrors, entry)
+	if n := len(m.recentErrors); n > maxRecentErrors {
+		m.recentErrors = append(m.recentErrors[:0:0], m.recentErrors[n-maxRecentErrors:]...)
+	}
+}
+
+// Snapshot copies the counters into a Metrics for reading. Counters are
+// read one at a time, so a snapshot taken under load can be a request or
+// two apart between fields. Fields only grow between calls to Reset, which
+// sets them back to zero; a rate computed across a reset must treat a
+// smaller value as a restart.
+func (m *Metrics) Snapshot() *Metrics {
+	now := time.Now()
+	copy := Metrics{
+		taken:         now,
+		TotalRequests: m.requests.Load(),
+		TotalErrors:   m.failed.Load(),
+		IPDenied:      m.ipDenied.Load(),
+		OverLimit:     m.overLimit.Load(),
+		RateLimited:   m.rateLimited.Load(),
+		Panics:        m.panics.Load(),
+		InFlight:      uint64(max(m.inFlight.Load(), 0)),
+		Static:        m.static.snapshot(),
+		BytesIn:       m.bytesIn.Load(),
+		BytesOut:      m.bytesOut.Load(),
+		ByRoute:       m.routeSnapshot(now),
+		ByPool:        make(map[string]*PoolTraffic),
+	}
+	copy.RoutesDropped = uint64(m.dropped.Load())
+
+	m.pools.Range(func(k, v any) bool {
+		pc := v.(*poolCounters)
+		pc.mu.Lock()
+		windows := pc.window.stats(now)
+		pc.mu.Unlock()
+		copy.ByPool[k.(string)] = &PoolTraffic{
+			Requests: pc.requests.Load(),
+			BytesIn:  pc.bytesIn.Load(),
+			BytesOut: pc.bytesOut.Load(),
+			Windows:  windows,
+		}
+		return true
+	})
+
+	m.errMu.Lock()
+	defer m.errMu.Unlock()
+	copy.PHPFatals = m.phpFatals
+	copy.RecentErrors = append([]ErrorEntry(nil), m.recentErrors...)
+	copy.ErrorsByCategory = make(map[string]uint64, len(m.errorsByKind))
+	for category, n := range m.errorsByKind {
+		copy.ErrorsByCategory[category] = n
+	}
+	return &copy
+}
+
+func logRequestJSON(entry RequestLog) {
+	b, err := json.Marshal(entry)
+	if err != nil {
+		log.Printf("error marshaling log entry: %v", err)
+		return
+	}
+	log.Println(string(b))
+}
+
+// durationMs is d in milliseconds, keeping the fraction short waits need.
+func durationMs(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+//
+// -------------------------------------------------------------
+// STATIC FILE SERVING
+// -------------------------------------------------------------
+//
+
+// tryServeStatic: serves static assets based on StaticRule in config
+func tryServeStatic(w http.ResponseWriter, r *http.Request, projectRoot string, rules []StaticRule) bool {
+	return serveStatic(w, r, projectRoot, rules, nil, nil)
+}
+
+// serveStatic is tryServeStatic with an optional gzip cache for compressible
+// assets and an optional in-memory cache for small files.
+func serveStatic(w http.ResponseWriter, r *http.Request, projectRoot string, rules []StaticRule, gz *gzipCache, files *fileCache) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+
+	path := r.URL.Path
+
+	for _, rule := range rules {
+		if !strings.HasPrefix(path, rule.Prefix) {
+			continue
+		}
+
+		relPath := strings.TrimPrefix(path, rule.Prefix)
+		relPath = filepath.Clean(relPath)
+
+		baseDir := filepath.Join(projectRoot, rule.Dir)
+		fullPath := filepath.Join(baseDir, relPath)
+
+		// Prevent ../../ escapes
+		if !withinDir(fullPath, baseDir) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return true
+		}
+
+		info, err := os.Stat(fullPath)
+		if err != nil {
+			continue
+		}
+		if !symlinkAllowed(rule.Symlinks, baseDir, fullPath) {
+			log.Printf("[static] %s: refused by symlinks=%s", fullPath, rule.Symlinks)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return true
+		}
+		if info.IsDir() {
+			if rule.Autoindex && !hiddenPath(path) {
+				serveAutoindex(w, r, rule, fullPath)
+				return true
+			}
+			continue
+		}
+		ext := filepath.Ext(fullPath)
+		if !rule.allows(ext) {
+			continue
+		}
+		if cc := rule.cacheControl(); cc != "" {
+			w.Header().Set("Cache-Control", cc)
+		}
+		if ct := rule.contentType(ext); ct != "" {
+			w.Header().Set("Content-Type", ct)
+		}
+
+		if gz != nil && gz.serve(w, r, fullPath, info) {
+			return true
+		}
+		// ServeFile redirects .../index.html to .../; leave that to it
+		if files != nil && !strings.HasSuffix(path, "/index.html") && files.serve(w, r, fullPath, info) {
+			return true
+		}
+
+		http.ServeFile(w, r, fullPath)
+		return true
+	}
+
+	return false
+}
+
+//
+// -------------------------------------------------------------
+// REQUEST PAYLOAD TRANSFORM (HTTP → PHP Worker)
+// -------------------------------------------------------------
+//
+
+func BuildPayload(r *http.Request) *server.RequestPayload {
+	// Generate a request ID for logging + tracing
+	reqID := uuid.New().String()
+
+	// copy headers into map[string][]string with canonicalized names
+	headers := make(map[string][]string, len(r.Header)+3)
+
+	for name, values := range r.Header {
+		canonical := http.CanonicalHeaderKey(name)
+
+		// copy the slice so we don't share backing arrays with r.Header
+		copied := make([]string, len(values))
+		copy(copied, values)
+
+		headers[canonical] = copied
+	}
+
+	// forward the verified client certificate, never the client's claims
+	setClientCertHeaders(r, headers)
+
+	// ensure Host is present
+	host := r.Host
+	if host == "" && r.URL != nil {
+		host = r.URL.Host
+	}
+	if host != "" {
+		headers["Host"] = []string{host}
+	}
+
+	// add / extend X-Forwarded-For with the direct client IP
+	if ip, _, err := net.SplitHostPort(r.RemoteAddr); err == nil && ip != "" {
+		if existing, ok := headers["X-Forwarded-For"]; ok && len(existing) > 0 {
+			headers["X-Forwarded-For"] = []string{existing[0] + ", " + ip}
+		} else {
+			headers["X-Forwarded-For"] = []string{ip}
+		}
+	}
+
+	// Attach X-Request-Id if the client didn't send one
+	if _, ok := headers["X-Request-Id"]; !ok {
+		headers["X-Request-Id"] = []string{reqID}
+	}
+
+	bodyBytes, err := io.ReadAll(r.Body)
+	if err != nil {
+		log.Printf("[request %s] error reading body: %v", reqID, err)
+	}
+	_ = r.Body.Close()
+
+	// Preserve the full RequestURI (includes query string)
+	path := r.URL.RequestURI()
+	if path == "" {
+		path = r.URL.Path
+	}
+
+	return &server.RequestPayload{
+		ID:           reqID,
+		Method:       r.Method,
+		Path:         path,
+		Headers:      headers,
+		Body:         bodyBytes,
+		ServerParams: serverParams(r, time.Now()),
+	}
+}
+
+// mapWorkerErrorToStatus converts worker-level errors into HTTP status codes.
+func mapWorkerErrorToStatus(err error) int {
+	switch {
+	case errors.Is(err, server.ErrPHPFatal):
+		// PHP died of a fatal error; the app is broken, not the gateway
+		return http.StatusInternalServerError
+	case errors.Is(err, server.ErrDegraded):
+		// no PHP workers could be started
+		return http.StatusServiceUnavailable // 503 Service Unavailable
+	case errors.Is(err, server.ErrOverloaded):
+		// shed before queueing because the pool is saturated
+		return http.StatusServiceUnavailable
+	case errors.Is(err, server.ErrWorkerTimeout):
+		// the php worker timed out handling the request
+		return http.StatusGatewayTimeout //' 504 Gateway Timeout
+	case errors.Is(err, server.ErrWorkerCrashed),
+		errors.Is(err, server.ErrFrameTooLarge),
+		errors.Is(err, server.ErrProtocol),
+		server.IsBrokenPipe(err):
+		// Connection to the worker died mid-request, or it answered
+		// something we can't use
+		return http.StatusBadGateway // 502 Bad Gateway
+
+	default:
+		// Anything else is treated as an internal server error
+		return http.StatusInternalServerError //500
+	}
+}
+
+// writeWorkerError logs and sends an appropriate HTTP error to the client,
+// using the configured error page for the status if there is one. PHP fatal
+// errors otherwise get a JSON body, which includes the error and PHP's
+// stderr output in debug mode (debug wins over the error page).
+func writeWorkerError(w http.ResponseWriter, err error, requestID string, out errorOutput) {
+	status := mapWorkerErrorToStatus(err)
+	log.Printf("[worker] error (status=%d, category=%s): %v", status, server.ErrorCategory(err), err)
+	var overload *server.OverloadError
+	if errors.As(err, &overload) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(overload.RetryAfter.Seconds())))
+	}
+
+	var fatal *server.FatalError
+	isFatal := errors.As(err, &fatal)
+	if !(isFatal && out.debug) && out.pages.write(w, status, requestID) {
+		return
+	}
+	if isFatal {
+		body := map[string]any{"status": status, "error": "php_fatal", "request_id": requestID}
+		if out.debug {
+			body["debug"] = fatal
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.WriteHeader(status)
+		_ = json.NewEncoder(w).Encode(body)
+		return
+	}
+	http.Error(w, http.StatusText(status), status)
+}
+
+// serveMaintenancePage answers a dynamic request while PHP is unavailable,
+// using the configured maintenance page if it can be read.
+func serveMaintenancePage(w http.ResponseWriter, projectRoot, page string) {
+	w.Header().Set("Retry-After", "30")
+
+	if page != "" {
+		if !filepath.IsAbs(page) {
+			page = filepath.Join(projectRoot, page)
+		}
+		if body, err := os.ReadFile(page); err == nil {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = w.Write(body)
+			return
+		}
+		log.Printf("[degraded] maintenance page %s unreadable, using plain text", page)
+	}
+
+	http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+}
+
+//
+// -------------------------------------------------------------
+// PROJECT ROOT DISCOVERY (dir containing go.mod)
+// -------------------------------------------------------------
+//
+
+func ProjectRoot() string {
+	wd, err := os.Getwd()
+	if err != nil {
+		return "."
+	}
+
+	dir := wd
+	for {
+		if _, err := os.Stat(filepath.Join(dir, "go.mod")); err == nil {
+			return dir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return wd
+		}
+		dir = parent
+	}
+}
+
+//
+// -------------------------------------------------------------
+// MAIN SERVER SETUP
+// -------------------------------------------------------------
+//
+
+// Server is the app server as an http.Handler: static files, PHP dispatch,
+// realtime hubs and the management endpoints for one config. Mount it in
+// an existing service, or let ListenAndServe run it on its own.
+type Server struct {
+	cfg    *AppServerConfig
+	root   string
+	vhosts *vhostRouter
+
+	hooks   *hookSet
+	auth    *wsAuth // realtime authentication
+	limiter *rateLimiter
+	metrics *Metrics
+	files   *fileCache      // static_cache, or nil
+	cache   *responseCache  // response_cache, or nil
+	recent  *recentRequests // recent_requests, or nil
+	debug   *debugHeaders   // debug_headers, or nil
+
+	handler http.Handler // public routes, plus management unless admin.listen is set
+	admin   http.Handler // management routes for admin.listen, or nil
+
+	chain      atomic.Pointer[http.Handler] // handler wrapped in middleware, once Use is called
+	mwMu       sync.RWMutex
+	middleware []Middleware
+	dispatchMW []DispatchMiddleware
+
+	mu      sync.Mutex
+	started bool
+	stops   []func()
+	servers []*http.Server
+}
+
+// New starts the PHP workers described by cfg and builds the request
+// pipeline around them. cfg is normalised in place the way a config file
+// is, and a nil cfg means DefaultConfig. Relative paths resolve against
+// cfg.Root, which defaults to ProjectRoot().
+func New(cfg *AppServerConfig) (*Server, error) {
+	if cfg == nil {
+		cfg = DefaultConfig()
+	}
+	normalizeConfig(cfg, logConfig)
+
+	root := cfg.Root
+	if root == "" {
+		root = ProjectRoot()
+	}
+
+	// Load credentials and compile WASM filters first, so a bad one
+	// fails before workers spawn
+	var authRules []*basicAuth
+	for _, rule := range cfg.BasicAuth {
+		a, err := newBasicAuth(root, rule)
+		if err != nil {
+			return nil, err
+		}
+		authRules = append(authRules, a)
+	}
+	var jwtCheck *jwtAuth
+	if cfg.JWTAuth != nil {
+		var err error
+		if jwtCheck, err = newJWTAuth(root, cfg.JWTAuth); err != nil {
+			return nil, err
+		}
+	}
+	var csrf *csrfGuard
+	if cfg.CSRF != nil {
+		var err error
+		if csrf, err = newCSRFGuard(cfg.CSRF); err != nil {
+			return nil, err
+		}
+	}
+	auth := &wsAuth{}
+	if cfg.WSAuth != nil {
+		var err error
+		if auth.verifier, err = newJWTVerifier(root, *cfg.WSAuth); err != nil {
+			return nil, fmt.Errorf("ws_auth: %w", err)
+		}
+	}
+	if cfg.WSCookie != nil {
+		var err error
+		if auth.cookie, err = newCookieVerifier(*cfg.WSCookie); err != nil {
+			return nil, fmt.Errorf("ws_cookie: %w", err)
+		}
+	}
+	if cfg.WSSession != nil {
+		var err error
+		if auth.session, err = newSessionStore(root, *cfg.WSSession); err != nil {
+			return nil, fmt.Errorf("ws_session: %w", err)
+		}
+	}
+	var filters *wasmFilters
+	if len(cfg.WasmFilters) > 0 {
+		var err error
+		if filters, err = newWasmFilters(root, cfg.WasmFilters); err != nil {
+			return nil, err
+		}
+	}
+	var capture *capturer
+	if cfg.Capture != nil {
+		var err error
+		if capture, err = newCapturer(root, *cfg.Capture); err != nil {
+			if filters != nil {
+				filters.close()
+			}
+			return nil, err
+		}
+	}
+
+	// Build the default app plus any virtual hosts, each with its own pools
+	hooks := &hookSet{}
+	vhosts, err := buildVhosts(root, cfg, hooks, auth)
+	if err != nil {
+		if filters != nil {
+			filters.close()
+		}
+		if capture != nil {
+			capture.close()
+		}
+		return nil, err
+	}
+
+	s := &Server{cfg: cfg, root: root, vhosts: vhosts, hooks: hooks, auth: auth, metrics: NewMetrics(), files: newFileCache(cfg.StaticCache), debug: newDebugHeaders(cfg.DebugHeaders)}
+	s.metrics.limitRoutes(cfg.RouteMetrics)
+	rateLimits := cfg.RateLimits
+	if rateLimits == nil {
+		rateLimits = &RateLimitConfig{} // rules can still be added by PATCH
+	}
+	s.limiter = newRateLimiter(rateLimits, auth)
+	if filters != nil {
+		// Built-in, so outside whatever UseDispatch adds later.
+		s.dispatchMW = []DispatchMiddleware{filters.middleware}
+		s.stops = append(s.stops, filters.close)
+	}
+	if s.cache = newResponseCache(cfg.ResponseCache); s.cache != nil {
+		// Outside coalescing, so only misses wait on each other.
+		s.dispatchMW = append(s.dispatchMW, s.cache.middleware)
+	}
+	if cfg.Coalesce.Enabled {
+		s.dispatchMW = append(s.dispatchMW, newCoalescer(cfg.Coalesce).middleware)
+	}
+	if s.recent = newRecentRequests(cfg.RecentRequests); s.recent != nil {
+		hooks.add(s.recent)
+	}
+	if capture != nil {
+		hooks.add(capture)
+		s.stops = append(s.stops, capture.close)
+	}
+	mux := s.routes()
+
+	// Management endpoints require the admin token (or localhost)
+	if cfg.Admin.EffectiveToken() == "" {
+		log.Printf("[admin] no admin token set; management endpoints only answer localhost")
+	}
+	if cfg.Admin.Listen != "" {
+		var admin http.Handler
+		s.handler, admin = splitListeners(cfg.Admin, mux)
+		s.admin = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer s.recoverPanic(w, r)
+			admin.ServeHTTP(w, r)
+		})
+	} else {
+		s.handler = adminGuard(cfg.Admin, mux)
+	}
+	if cfg.RequestDecompression.Enabled {
+		s.handler = decompressHandler(cfg.RequestDecompression, s.handler)
+	}
+	if csrf != nil {
+		s.handler = csrfHandler(csrf, s.handler)
+	}
+	if jwtCheck != nil {
+		s.handler = jwtAuthHandler(jwtCheck, s.handler)
+	}
+	if len(authRules) > 0 {
+		s.handler = basicAuthHandler(authRules, s.handler)
+	}
+	if cfg.CORS != nil {
+		s.handler = corsHandler(cfg.CORS, s.handler)
+	}
+	s.handler = rateLimitHandler(s.limiter, s.metrics, s.handler)
+	if cfg.IPAccess != nil {
+		s.handler = ipAccessHandler(newIPAccess(cfg.IPAccess), s.metrics, s.handler)
+	}
+	if len(cfg.Rewrites) > 0 {
+		s.handler = rewriteHandler(newRewriteRules(cfg.Rewrites), s.handler)
+	}
+	if cfg.Redirects != nil {
+		s.handler = redirectHandler(newRedirector(cfg.Redirects), s.handler)
+	}
+	if len(cfg.AllowedHosts) > 0 {
+		s.handler = allowedHostsHandler(cfg.AllowedHosts, s.handler)
+	}
+	if n := cfg.Limits.MaxInFlight; n > 0 {
+		s.handler = inFlightHandler(n, cfg.Limits.RetryAfterSeconds, s.metrics, s.handler)
+	}
+	return s, nil
+}
+
+// ServeHTTP serves r the way the main listener does.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	defer s.recoverPanic(w, r)
+	if h := s.chain.Load(); h != nil {
+		(*h).ServeHTTP(w, r)
+		return
+	}
+	s.handler.ServeHTTP(w, r)
+}
+
+// AdminHandler serves the management endpoints when admin.listen moves
+// them off the main handler; it is nil otherwise.
+func (s *Server) AdminHandler() http.Handler {
+	return s.admin
+}
+
+// routes registers every endpoint on a new mux.
+func (s *Server) routes() *http.ServeMux {
+	cfg, root, vhosts := s.cfg, s.root, s.vhosts
+	errOut := errorOutput{pages: loadErrorPages(root, cfg.ErrorPages, logConfig.warn), debug: cfg.DebugErrors, override: cfg.ErrorPagesOverride}
+
+	metrics := s.metrics
+	mux := http.NewServeMux()
+
+	var assets *assetManifest
+	if cfg.AssetManifest != nil {
+		assets = newAssetManifest(root, *cfg.AssetManifest)
+		mux.HandleFunc("/__baremetal/assets", assets.handler)
+	}
+	if s.recent != nil {
+		mux.HandleFunc("/__baremetal/requests/recent", s.recent.handler)
+	}
+
+	wsHub := server.NewWSHub()
+	revocations := server.NewRevocationList()
+
+	wsUpgrader := websocket.Upgrader{
+		ReadBufferSize:  1024,
+		WriteBufferSize: 1024,
+		CheckOrigin: func(r *http.Request) bool {
+			// TODO: lighten up for production
+			return true
+		},
+	}
+
+	mux.HandleFunc("/__ws/user", func(w http.ResponseWriter, r *http.Request) {
+		userID, err := authenticateWS(s.auth, r)
+		if err != nil || userID == "" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		if revocations.IsRevoked(userID) {
+			http.Error(w, "realtime access revoked", http.StatusForbidden)
+			return
+		}
+
+		channel := server.UserChannel(userID)
+
+		conn, err := wsUpgrader.Upgrade(w, r, nil)
+		if err != nil {
+			log.Printf("[ws] upgrade error: %v", err)
+			return
+		}
+
+		defer conn.Close()
+
+		client := wsHub.Subscribe(channel)
+		defer wsHub.Unsubscribe(channel, client)
+
+		done := make(chan struct{})
+
+		// writer goroutine
+		go func() {
+			defer close(done)
+
+			for msg := range client.Send {
+				if err := conn.WriteJSON(msg); err != nil {
+					log.Printf("[ws] write error (user %s): %v", userID, err)
+					return
+				}
+			}
+
+			// Send closed by the hub (e.g. revoked): hang up so the reader exits.
+			closeRevokedWS(conn)
+		}()
+
+		// reader loop, for now, echo messages back through the hub on the same channel
+		for {
+			var incoming map[string]any
+			if err := conn.ReadJSON(&incoming); err != nil {
+				if websocket.IsCloseError(err,
+					websocket.CloseGoingAway,
+					websocket.CloseNormalClosure,
+					websocket.CloseAbnormalClosure,
+				) {
+					return
+				}
+				log.Printf("[ws] read error (user %s): %v", userID, err)
+				return
+			}
+
+			// Optional: allow client messages to be broadcast to their own channel
+			wsHub.Publish(channel, "client", incoming)
+			s.hooks.published("ws", channel, "client", incoming)
+		}
+	})
+
+	hub := server.NewSSEHub()
+
+	// streaming routes: anything under /stream/ uses DispatchStream
+	mux.HandleFunc("/stream/", func(w http.ResponseWriter, r *http.Request) {
+		// tell php worker we want streaming
+		r.Header.Set("X-Go-Stream", "1")
+		body := countBody(r)
+		payload := BuildPayload(r)
+		if cfg.Uploads.Parse {
+			if err := parseUploads(r, payload, cfg.Uploads.Dir); err != nil {
+				uploadError(w, err)
+				return
+			}
+		}
+		requestRead(w)
+		start := time.Now()
+
+		routeKey := r.URL.Path
+		if routeKey == "" {
+			routeKey = "/stream"
+		}
+
+		metrics.StartRequest(routeKey)
+
+		app := vhosts.match(r)
+		s.hooks.each(func(h Hooks) { h.OnRequest(RequestEvent{Request: r, App: app.name, Time: start}) })
+		setDocumentRoot(payload.ServerParams, app.root)
+		srv := app.srv
+		cw := &countingWriter{ResponseWriter: w}
+		if err := s.dispatchStream(cw, r, app, payload); err != nil {
+			elapsed := time.Since(start)
+			metrics.EndPoolRequest(routeKey, payload.Pool(), elapsed, true)
+			metrics.RecordError(payload, mapWorkerErrorToStatus(err), err)
+			s.workerFailed(r, app, payload, err, mapWorkerErrorToStatus(err), elapsed)
+			writeWorkerError(w, err, payload.ID, errOut)
+			log.Printf("[req %s] %s %s -> stream error: %v", payload.ID, payload.Method, payload.Path, err)
+			return
+		}
+
+		elapsed := time.Since(start)
+		metrics.EndPoolRequest(routeKey, payload.Pool(), elapsed, false)
+		metrics.RecordBytes(routeKey, payload.Pool(), body.n, cw.bytes)
+		srv.RecordLatency(payload.Path, elapsed)
+		s.responded(r, app, payload, 0, elapsed, true)
+
+		log.Printf("[req %s] %s %s -> streamed (%v)", payload.ID, payload.Method, payload.Path, elapsed)
+	})
+
+	mux.HandleFunc("/__ws", func(w http.ResponseWriter, r *http.Request) {
+		channel := r.URL.Query().Get("channel")
+		if channel == "" {
+			http.Error(w, "missing channel", http.StatusBadRequest)
+			return
+		}
+		if revocations.ChannelRevoked(channel) {
+			http.Error(w, "realtime access revoked", http.StatusForbidden)
+			return
+		}
+
+		conn, err := wsUpgrader.Upgrade(w, r, nil)
+		if err != nil {
+			log.Printf("[ws] upgrade error: %v", err)
+			return
+		}
+
+		defer conn.Close()
+
+		client := wsHub.Subscribe(channel)
+		defer wsHub.Unsubscribe(channel, client)
+
+		// Writer goroutine: send hub messages to this websocket
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for msg := range client.Send {
+				// send as JSON: {"type": "...", "data": {...} }
+				if err := conn.WriteJSON(msg); err != nil {
+					log.Printf("[ws] write error: %v", err)
+					return
+				}
+			}
+
+			closeRevokedWS(conn)
+		}()
+
+		// Reader Loop: for now, echo messages back through the hub on the same channel
+		// @todo: change semantics
+		for {
+			var incoming map[string]any
+			if err := conn.ReadJSON(&incoming); err != nil {
+				if websocket.IsCloseError(err,
+					websocket.CloseGoingAway,
+					websocket.CloseNormalClosure,
+					websocket.CloseAbnormalClosure,
+				) {
+					return
+				}
+				log.Printf("[ws] read error: %v", err)
+				return
+			}
+
+			wsHub.Publish(channel, "client", incoming)
+			s.hooks.published("ws", channel, "client", incoming)
+		}
+	})
+
+	mux.HandleFunc("/__ws/publish", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		var body struct {
+			Channel string      `json:"channel"`
+			Type    string      `json:"type"`
+			Data    interface{} `json:"data"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		if body.Channel == "" {
+			http.Error(w, "missing channel", http.StatusBadRequest)
+			return
+		}
+
+		wsHub.Publish(body.Channel, body.Type, body.Data)
+		s.hooks.published("ws", body.Channel, body.Type, body.Data)
+		w.WriteHeader(http.StatusAccepted)
+	})
+
+	// Main application handler
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		// 0) Pick the application by Host (+ prefix)
+		app := vhosts.match(r)
+		srv := app.srv
+		s.hooks.each(func(h Hooks) { h.OnRequest(RequestEvent{Request: r, App: app.name, Time: time.Now()}) })
+
+		// Prefixes proxied to another origin never reach static or PHP
+		if h := app.proxy.match(r.URL.Path); h != nil {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		// 1) Try static assets first; fingerprinted ones never change
+		immutable := assets != nil && assets.immutable(r)
+		if immutable {
+			w.Header().Set("Cache-Control", StaticRule{Immutable: true}.cacheControl())
+		}
+		if s.serveStaticCounted(w, r, app) {
+			return
+		}
+		if immutable {
+			w.Header().Del("Cache-Control")
+		}
+
+		// No PHP workers: answer with the maintenance page instead of queueing
+		if srv.Degraded() {
+			serveMaintenancePage(w, root, cfg.MaintenancePage)
+			return
+		}
+
+		// 2) Transform request → payload for PHP worker
+		debug := s.debug.wanted(r)
+		body := countBody(r)
+		payload := BuildPayload(r)
+		setDocumentRoot(payload.ServerParams, app.root)
+		if cfg.Uploads.Parse {
+			if err := parseUploads(r, payload, cfg.Uploads.Dir); err != nil {
+				uploadError(w, err)
+				return
+			}
+		}
+		requestRead(w)
+		start := time.Now()
+
+		// Metrics: per-route tracking
+		routeKey := r.URL.Path
+		if routeKey == "" {
+			routeKey = "/"
+		}
+		metrics.StartRequest(routeKey)
+
+		// Optional: streaming path (guarded by header)
+		if r.Header.Get("X-Go-Stream") == "1" {
+			cw := &countingWriter{ResponseWriter: w}
+			if debug {
+				announceStreamDebug(w.Header())
+			}
+			if err := s.dispatchStream(cw, r, app, payload); err != nil {
+				elapsed := time.Since(start)
+				if debug {
+					setStreamDebugTrailers(w.Header(), payload, cw.writes, elapsed)
+				}
+				metrics.EndPoolRequest(routeKey, payload.Pool(), elapsed, true)
+				metrics.RecordError(payload, mapWorkerErrorToStatus(err), err)
+				s.workerFailed(r, app, payload, err, mapWorkerErrorToStatus(err), elapsed)
+				writeWorkerError(w, err, payload.ID, errOut)
+				log.Printf("[req %s] %s %s -> stream error: %v", payload.ID, payload.Method, payload.Path, err)
+				return
+			}
+
+			elapsed := time.Since(start)
+			if debug {
+				setStreamDebugTrailers(w.Header(), payload, cw.writes, elapsed)
+			}
+			metrics.EndPoolRequest(routeKey, payload.Pool(), elapsed, false)
+			metrics.RecordBytes(routeKey, payload.Pool(), body.n, cw.bytes)
+			srv.RecordLatency(payload.Path, elapsed)
+			s.responded(r, app, payload, 0, elapsed, true)
+			log.Printf("[req %s] %s %s -> streamed (%v)", payload.ID, payload.Method, payload.Path, elapsed)
+			return
+		}
+
+		// 3) Normal non-streaming path
+		payload.RelayOver = cfg.Relay.relayOver(app)
+		resp, err := s.dispatch(r, app, payload)
+		queueWait, workerTime := payload.Timing()
+		metrics.RecordTiming(routeKey, queueWait, workerTime)
+		if err != nil {
+			elapsed := time.Since(start)
+			if debug {
+				setDebugHeaders(w.Header(), payload, elapsed)
+			}
+			metrics.EndPoolRequest(routeKey, payload.Pool(), elapsed, true)
+			metrics.RecordError(payload, mapWorkerErrorToStatus(err), err)
+			s.workerFailed(r, app, payload, err, mapWorkerErrorToStatus(err), elapsed)
+			writeWorkerError(w, err, payload.ID, errOut)
+			log.Printf("[req %s] %s %s -> worker error: %v", payload.ID, payload.Method, payload.Path, err)
+			return
+		}
+
+		// If PHP returns 404, give static another chance
+		if resp.Status == http.StatusNotFound {
+			if s.serveStaticCounted(w, r, app) {
+				resp.Discard()
+				elapsed := time.Since(start)
+				metrics.EndPoolRequest(routeKey, payload.Pool(), elapsed, false)
+				return
+			}
+		}
+
+		// Then the configured 404/50x page, if PHP sent no body of its own
+		if errOut.phpError(w, resp) {
+			elapsed := time.Since(start)
+			metrics.EndPoolRequest(routeKey, payload.Pool(), elapsed, false)
+			s.responded(r, app, payload, resp.Status, elapsed, false)
+			return
+		}
+
+		// Resolve <esi:include> fragments before the page goes out
+		if app.esi != nil {
+			if err := app.esi.process(payload, resp); err != nil {
+				elapsed := time.Since(start)
+				metrics.EndPoolRequest(routeKey, payload.Pool(), elapsed, true)
+				metrics.RecordError(payload, http.StatusBadGateway, err)
+				s.workerFailed(r, app, payload, err, http.StatusBadGateway, elapsed)
+				errOut.pages.error(w, http.StatusBadGateway, payload.ID)
+				log.Printf("[req %s] %s %s -> %v", payload.ID, payload.Method, payload.Path, err)
+				return
+			}
+		}
+
+		// Copy headers
+		for k, v := range resp.Headers {
+			w.Header().Set(k, v)
+		}
+		for _, c := range resp.Cookies {
+			w.Header().Add("Set-Cookie", c)
+		}
+
+		// Write status + body (large bodies are relayed or spooled to disk if configured)
+		status := resp.Status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		if cfg.ServerTiming.applies(r.URL.Path) {
+			addServerTiming(w, payload, time.Since(start))
+		}
+		if debug {
+			setDebugHeaders(w.Header(), payload, time.Since(start))
+		}
+		bytesOut := writeResponseBody(w, status, resp, cfg.Spool)
+
+		// Final metrics + structured log
+		elapsed := time.Since(start)
+		metrics.EndPoolRequest(routeKey, payload.Pool(), elapsed, false)
+		metrics.RecordBytes(routeKey, payload.Pool(), body.n, bytesOut)
+		s.responded(r, app, payload, status, elapsed, false)
+
+		entry := RequestLog{
+			Time:       time.Now(),
+			ID:         payload.ID,
+			Method:     payload.Method,
+			Path:       payload.Path,
+			Status:     status,
+			DurationMs: float64(elapsed.Milliseconds()),
+			RemoteAddr: r.RemoteAddr,
+			UserAgent:  r.UserAgent(),
+			Pool:       payload.Pool(),
+			BytesIn:    body.n,
+			BytesOut:   bytesOut,
+			QueueMs:    durationMs(queueWait),
+			WorkerMs:   durationMs(workerTime),
+		}
+		logRequestJSON(entry)
+	})
+
+	// Health summary: worker pools etc.
+	mux.HandleFunc("/__baremetal/health", func(w http.ResponseWriter, r *http.Request) {
+		summary := vhosts.byName(r.URL.Query().Get("app")).srv.Health()
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(summary); err != nil {
+			http.Error(w, "Failed to encode health summary", http.StatusInternalServerError)
+			return
+		}
+	})
+
+	// Readiness: 503 while degraded so load balancers route around us
+	mux.HandleFunc("/__baremetal/ready", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if vhosts.anyDegraded() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_ = json.NewEncoder(w).Encode(map[string]any{"ready": false, "reason": "php workers unavailable"})
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"ready": true})
+	})
+
+	// Force recycle: mark all workers dead so they respawn on next requests
+	mux.HandleFunc("/__baremetal/recycle", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		for _, app := range vhosts.all() {
+			app.srv.ForceRecycleWorkers()
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"status": "ok",
+			"note":   "all workers marked dead; will respawn on next requests",
+		})
+	})
+
+	// Scoreboard: per-slot worker state (JSON, or ?format=text for the compact view)
+	mux.HandleFunc("/__baremetal/scoreboard", func(w http.ResponseWriter, r *http.Request) {
+		slots := vhosts.byName(r.URL.Query().Get("app")).srv.Scoreboard()
+		if r.URL.Query().Get("format") == "text" {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			_, _ = w.Write([]byte(server.FormatScoreboard(slots)))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(slots); err != nil {
+			http.Error(w, "failed to encode scoreboard", http.StatusInternalServerError)
+		}
+	})
+
+	// Metrics endpoint
+	// ?delta=KEY returns only what changed since the last scrape with KEY
+	mux.HandleFunc("/__baremetal/metrics", func(w http.ResponseWriter, r *http.Request) {
+		snap := metrics.Snapshot()
+		snap.Retries = vhosts.retryStats()
+		snap.StaticCache = s.files.stats()
+		if snap.StaticCache != nil {
+			snap.Static.CacheHits = snap.StaticCache.Hits
+		}
+		snap.ResponseCache = s.cache.stats()
+		if key := r.URL.Query().Get("delta"); key != "" {
+			snap = metrics.since(key, snap)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(snap); err != nil {
+			http.Error(w, "failed to encode metrics", http.StatusInternalServerError)
+		}
+	})
+
+	// Reset: zero the metrics, e.g. before a load test
+	mux.HandleFunc("/__baremetal/metrics/reset", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		metrics.Reset()
+		vhosts.resetRetryStats()
+		s.files.resetStats()
+		s.cache.resetStats()
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+	})
+
+	mux.HandleFunc("/__sse", func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+			return
+		}
+
+		channel := r.URL.Query().Get("channel")
+		if channel == "" {
+			http.Error(w, "missing channel", http.StatusBadRequest)
+			return
+		}
+		if revocations.ChannelRevoked(channel) {
+			http.Error(w, "realtime access revoked", http.StatusForbidden)
+			return
+		}
+
+		client := hub.Subscribe(channel)
+		defer hub.Unsubscribe(channel, client)
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		streaming(w)
+
+		// initial comment so EventSource opens
+		_, _ = w.Write([]byte(": connected\n\n"))
+		flusher.Flush()
+
+		for {
+			select {
+			case ev := <-client.Ch():
+				if ev.Event != "" {
+					_, _ = w.Write([]byte("event: " + ev.Event + "\n"))
+				}
+				_, _ = w.Write([]byte("data: "))
+				_, _ = w.Write(ev.Data)
+				_, _ = w.Write([]byte("\n\n"))
+				flusher.Flush()
+			case <-r.Context().Done():
+				return
+			case <-client.Done():
+				return
+			}
+		}
+	})
+
+	// Realtime revocation: cut a user's WS/SSE feeds and block reconnects
+	mux.HandleFunc("/__baremetal/realtime/revoke", revokeHandler(revocations, wsHub, hub))
+
+	// Adaptive routing: prefixes promoted to the slow pool and recent changes
+	mux.HandleFunc("/__baremetal/adaptive", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(vhosts.byName(r.URL.Query().Get("app")).srv.Adaptive())
+	})
+
+	// Canary: view or shift the stable/canary traffic split
+	mux.HandleFunc("/__baremetal/canary", canaryHandler(vhosts))
+
+	// Shadow: mirrored-traffic stats, or change the mirrored percentage
+	mux.HandleFunc("/__baremetal/shadow", shadowHandler(vhosts))
+
+	// expvar: runtime, pool and hub stats for generic collectors
+	publishVars(vhosts, metrics, wsHub, hub)
+	mux.Handle("/__baremetal/vars", expvar.Handler())
+
+	// Live config: view or patch runtime-adjustable settings
+	mux.HandleFunc("/__baremetal/config", configHandler(vhosts, s.limiter))
+
+	// Cache purge: drop cached responses by URL, prefix, wildcard or tag
+	mux.HandleFunc("POST /__baremetal/cache/purge", cachePurgeHandler(s.cache))
+
+	// Pool resize: grow or shrink a pool without a restart
+	mux.HandleFunc("POST /__baremetal/pools/{name}/resize", poolResizeHandler(vhosts))
+
+	// Worker stderr: last lines captured from each php process
+	mux.Handle("GET /__baremetal/workers/{id}/logs", workerLogsHandler(vhosts))
+
+	// Per-worker recycle / drain, instead of cycling every worker at once
+	mux.HandleFunc("POST /__baremetal/workers/{id}/recycle", workerActionHandler(vhosts, "recycle"))
+	mux.HandleFunc("POST /__baremetal/workers/{id}/drain", workerActionHandler(vhosts, "drain"))
+
+	// Operator dashboard (polls the JSON endpoints above)
+	mux.HandleFunc("/__baremetal/dashboard", dashboardHandler)
+
+	// Go profiler (off by default; admin auth required)
+	if cfg.Admin.Pprof {
+		registerPprof(mux, cfg.Admin)
+		log.Printf("[admin] pprof enabled at %s", pprofPrefix)
+	}
+
+	// SSE publish endpoint: POST /__sse/publish
+	// Body: { "channel": "foo", "event", "update", "data": { ... } }
+	mux.HandleFunc("/__sse/publish", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		var body struct {
+			Channel string      `json:"channel"`
+			Event   string      `json:"event"`
+			Data    interface{} `json:"data"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, "invalid JSON", http.StatusBadRequest)
+			return
+		}
+
+		if body.Channel == "" {
+			http.Error(w, "missing channel", http.StatusBadRequest)
+			return
+		}
+
+		hub.Publish(body.Channel, body.Event, body.Data)
+		s.hooks.published("sse", body.Channel, body.Event, body.Data)
+		w.WriteHeader(http.StatusAccepted)
+	})
+
+	return mux
+}
+
+// Start begins the background work for cfg: the worker memory guard,
+// alerts, hot reload, the config watcher, the adaptive state file and the
+// scoreboard file. Calls after the first do nothing.
+func (s *Server) Start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.started {
+		return
+	}
+	s.started = true
+	cfg, root, vhosts := s.cfg, s.root, s.vhosts
+
+	done := make(chan struct{})
+	s.stops = append(s.stops, func() { close(done) })
+	go watchWorkerMemory(vhosts, cfg.WorkerGuard, time.Minute, done)
+	if cfg.Alerts != nil && len(cfg.Alerts.Rules) > 0 {
+		go newAlerter(*cfg.Alerts, s.metrics, vhosts).run(done)
+	}
+
+	// Hot reload (if enabled)
+	if cfg.HotReload {
+		for _, app := range vhosts.all() {
+			if err := app.srv.EnableHotReload(app.root); err != nil {
+				log.Printf("Hot reload disabled for %s: %v", app.name, err)
+			} else {
+				log.Printf("Hot reload enabled for %s", app.name)
+			}
+		}
+	}
+
+	// Config file watch (if enabled)
+	if cfg.WatchConfig || cfg.HotReload {
+		if stop, err := watchConfig(root, cfg, vhosts); err != nil {
+			log.Printf("[config] watch disabled: %v", err)
+		} else {
+			s.stops = append(s.stops, stop)
+		}
+	}
+
+	// Adaptive state file (if enabled)
+	if cfg.AdaptiveStateFile != "" {
+		path := cfg.AdaptiveStateFile
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(root, path)
+		}
+		if stop, err := startAdaptiveState(path, vhosts, adaptiveSaveInterval); err != nil {
+			log.Printf("[adaptive] state file disabled: %v", err)
+		} else {
+			s.stops = append(s.stops, stop)
+		}
+	}
+
+	// Scoreboard file (if enabled)
+	if cfg.ScoreboardFile != "" {
+		path := cfg.ScoreboardFile
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(root, path)
+		}
+		for _, app := range vhosts.all() {
+			file := app.scoreboardFile(path, app == vhosts.def)
+			if stop, err := app.srv.StartScoreboardFile(file, time.Second); err != nil {
+				log.Printf("[scoreboard] %s disabled: %v", app.name, err)
+			} else {
+				s.stops = append(s.stops, stop)
+				log.Printf("[scoreboard] writing %s", file)
+			}
+		}
+	}
+}
+
+// ListenAndServe starts s and serves it on addr, plus the management
+// listener if admin.listen is set, until Shutdown.
+func (s *Server) ListenAndServe(addr string) error {
+	cfg, vhosts := s.cfg, s.vhosts
+	s.Start()
+
+	httpSrv := &http.Server{
+		Addr:    addr,
+		Handler: s,
+	}
+	cfg.ClientTimeouts.apply(httpSrv)
+	cfg.HTTPServer.apply(httpSrv)
+
+	// Management endpoints on their own listener (if configured)
+	var adminSrv *http.Server
+	if s.admin != nil {
+		ln, err := listenAdmin(cfg.Admin.Listen)
+		if err != nil {
+			return fmt.Errorf("admin listen %s: %w", cfg.Admin.Listen, err)
+		}
+		adminSrv = &http.Server{Handler: s.admin}
+		cfg.ClientTimeouts.apply(adminSrv)
+		go func() {
+			if err := adminSrv.Serve(ln); err != nil && err != http.ErrServerClosed {
+				log.Printf("[admin] serve error: %v", err)
+			}
+		}()
+		log.Printf("[admin] management endpoints on %s", cfg.Admin.Listen)
+	}
+
+	// Plain-HTTP listener that only redirects to HTTPS (if configured)
+	var plainSrv *http.Server
+	if rc := cfg.Redirects; rc != nil && rc.HTTPListen != "" {
+		ln, err := net.Listen("tcp", rc.HTTPListen)
+		if err != nil {
+			return fmt.Errorf("redirects http_listen %s: %w", rc.HTTPListen, err)
+		}
+		force := *rc
+		force.HTTPS, force.TrustForwardedProto = true, false
+		force.Rules = slices.Clone(rc.Rules)
+		plainSrv = &http.Server{Handler: redirectHandler(newRedirector(&force), s)}
+		cfg.ClientTimeouts.apply(plainSrv)
+		go func() {
+			if err := plainSrv.Serve(ln); err != nil && err != http.ErrServerClosed {
+				log.Printf("[redirects] serve error: %v", err)
+			}
+		}()
+		log.Printf("[redirects] redirecting http://%s to HTTPS", rc.HTTPListen)
+	}
+
+	s.mu.Lock()
+	s.servers = append(s.servers, httpSrv)
+	if adminSrv != nil {
+		s.servers = append(s.servers, adminSrv)
+	}
+	if plainSrv != nil {
+		s.servers = append(s.servers, plainSrv)
+	}
+	s.mu.Unlock()
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	if n := cfg.Limits.MaxConnections; n > 0 {
+		reply := fmt.Sprintf(overLimitResponse, cfg.Limits.RetryAfterSeconds)
+		if cfg.TLS.enabled() {
+			reply = ""
+		}
+		ln = newConnLimitListener(ln, n, reply, s.metrics)
+	}
+	if cfg.ProxyProtocol.Enabled {
+		ln = newProxyProtoListener(ln, cfg.ProxyProtocol)
+	}
+	if cfg.TLS.enabled() {
+		if httpSrv.TLSConfig, err = cfg.TLS.serverTLS(s.root); err != nil {
+			ln.Close()
+			return err
+		}
+		warnUncoveredHosts(httpSrv.TLSConfig.Certificates, vhosts)
+		if every := time.Duration(cfg.TLS.SessionTicketRotationMinutes) * time.Minute; every > 0 && !cfg.TLS.DisableSessionTickets {
+			stop := rotateTicketKeys(httpSrv.TLSConfig, every)
+			s.mu.Lock()
+			s.stops = append(s.stops, stop)
+			s.mu.Unlock()
+		}
+	}
+
+	// Startup banner / config summary
+	log.Println("=============================================")
+	log.Printf(" BareMetalPHP Go App Server listening on %s", addr)
+	log.Println("=============================================")
+	for _, app := range vhosts.apps {
+		log.Printf(" App %q: hosts=%v prefix=%s root=%s", app.name, app.hosts, app.prefix, app.root)
+	}
+	log.Printf(" Timeout: %dms", cfg.RequestTimeoutMs)
+	log.Printf(" Max requests/worker: %d", cfg.MaxRequestsPerWorker)
+	if cfg.ProxyProtocol.Enabled {
+		log.Printf(" PROXY protocol from: %v", cfg.ProxyProtocol.Trusted)
+	}
+	if cfg.TLS.enabled() {
+		log.Printf(" TLS: %d certificate(s) (client certificates: %s)", len(httpSrv.TLSConfig.Certificates), cmp.Or(cfg.TLS.ClientAuth, "none"))
+	}
+	log.Println(" Static rules:")
+	for _, app := range vhosts.all() {
+		for _, rule := range app.staticRules() {
+			log.Printf("   [%s] %s → %s", app.name, rule.Prefix, filepath.Join(app.root, rule.Dir))
+		}
+	}
+	log.Println("=============================================")
+
+	// Not ServeTLS: it would serve a copy of TLSConfig, which ticket key
+	// rotation can't reach.
+	if n := cfg.HTTPServer.MaxTLSHandshakes; httpSrv.TLSConfig != nil && n > 0 {
+		ln = newHandshakeListener(ln, httpSrv.TLSConfig, n, httpSrv.ReadHeaderTimeout)
+	} else if httpSrv.TLSConfig != nil {
+		ln = tls.NewListener(ln, httpSrv.TLSConfig)
+	}
+
+	// Blocks until shutdown
+	if err := httpSrv.Serve(ln); err != nil && err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// Shutdown drains the PHP workers, stops the background work and shuts
+// down the listeners ListenAndServe opened, waiting for in-flight requests
+// until ctx is done. An embedder that mounted s in its own http.Server
+// shuts that down itself.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	stops, servers := s.stops, s.servers
+	s.stops, s.servers = nil, nil
+	s.mu.Unlock()
+
+	// tell PHP workers to drain (no new jobs, finish in-flight)
+	for _, app := range s.vhosts.all() {
+		app.srv.DrainWorkers()
+	}
+	for _, stop := range stops {
+		stop()
+	}
+
+	var err error
+	for _, srv := range servers {
+		err = errors.Join(err, srv.Shutdown(ctx))
+	}
+	return err
+}
+
+type StaticRule struct {
+	Prefix string `json:"prefix"`
+	Dir    string `json:"dir"`
+
+	// MaxAge and SMaxAge set Cache-Control max-age and s-maxage (seconds)
+	// on files served by this rule; 0 leaves them out. Immutable marks
+	// fingerprinted assets that never change under the same URL, and
+	// defaults MaxAge to a year.
+	MaxAge    int  `json:"max_age"`
+	SMaxAge   int  `json:"s_maxage"`
+	Immutable bool `json:"immutable"`
+
+	// MimeTypes overrides the Content-Type by extension, e.g.
+	// {".wasm": "application/wasm"}.
+	MimeTypes map[string]string `json:"mime_types"`
+	// Extensions, if set, is the only file extensions served; anything
+	// else (a stray .php or .env) is left to PHP as if it didn't exist.
+	Extensions []string `json:"extensions"`
+
+	// Autoindex lists directories that have no index of their own. Dot
+	// files, and files Extensions wouldn't serve, are left out.
+	Autoindex bool `json:"autoindex"`
+
+	// Symlinks is "follow" (default: serve wherever a link points),
+	// "within" (only if the resolved file is still under Dir) or "deny"
+	// (never through a link below Dir).
+	Symlinks string `json:"symlinks"`
+}
+
+// normExt lower-cases an extension and makes sure it starts with a dot.
+func normExt(ext string) string {
+	return "." + strings.TrimPrefix(strings.ToLower(ext), ".")
+}
+
+// allows reports whether rule may serve a file with extension ext.
+func (rule StaticRule) allows(ext string) bool {
+	if len(rule.Extensions) == 0 {
+		return true
+	}
+	if ext == "" {
+		return false
+	}
+	return slices.ContainsFunc(rule.Extensions, func(e string) bool { return normExt(e) == normExt(ext) })
+}
+
+// contentType returns the overridden Content-Type for ext, or "".
+func (rule StaticRule) contentType(ext string) string {
+	if ext == "" {
+		return ""
+	}
+	for e, ct := range rule.MimeTypes {
+		if normExt(e) == normExt(ext) {
+			return ct
+		}
+	}
+	return ""
+}
+
+const oneYear = 365 * 24 * 60 * 60
+
+// cacheControl returns the Cache-Control value for files served by rule,
+// or "" to send none.
+func (rule StaticRule) cacheControl() string {
+	maxAge := rule.MaxAge
+	if rule.Immutable && maxAge == 0 {
+		maxAge = oneYear
+	}
+	var parts []string
+	if maxAge > 0 {
+		parts = append(parts, "public", "max-age="+strconv.Itoa(maxAge))
+	}
+	if rule.SMaxAge > 0 {
+		if len(parts) == 0 {
+			parts = append(parts, "public")
+		}
+		parts = append(parts, "s-maxage="+strconv.Itoa(rule.SMaxAge))
+	}
+	if rule.Immutable {
+		parts = append(parts, "immutable")
+	}
+	return strings.Join(parts, ", ")
+}
+
+// validateStaticRules drops negative cache lifetimes and unknown symlink
+// policies.
+func validateStaticRules(path string, rules []StaticRule, warn configWarnFunc) {
+	for i := range rules {
+		rule := &rules[i]
+		if rule.MaxAge < 0 {
+			warn(fmt.Sprintf("%s[%d].max_age", path, i), "%s[%d].max_age=%d is negative, ignoring it", path, i, rule.MaxAge)
+			rule.MaxAge = 0
+		}
+		if rule.SMaxAge < 0 {
+			warn(fmt.Sprintf("%s[%d].s_maxage", path, i), "%s[%d].s_maxage=%d is negative, ignoring it", path, i, rule.SMaxAge)
+			rule.SMaxAge = 0
+		}
+		if !validSymlinkPolicy(rule.Symlinks) {
+			warn(fmt.Sprintf("%s[%d].symlinks", path, i), "%s[%d].symlinks=%q is not follow, within or deny; using within", path, i, rule.Symlinks)
+			rule.Symlinks = symlinksWithin
+		}
+	}
+}
+
+// PoolConfig describes a named worker pool. Zero values inherit the
+// top-level request_timeout_ms / max_requests_per_worker.
+type PoolConfig struct {
+	Name                 string            `json:"name"`
+	Workers              int               `json:"workers"`
+	RequestTimeoutMs     int               `json:"request_timeout_ms"`
+	MaxRequestsPerWorker int               `json:"max_requests_per_worker"`
+	PHPBinary            string            `json:"php_binary"`
+	PHPIni               map[string]string `json:"php_ini"`
+	WorkerScript         string            `json:"worker_script"`
+	Root                 string            `json:"root"` // code checkout for this pool, relative to the app root
+
+	// Transport is "stdio" (default: spawn php), "unix"/"tcp" (external
+	// worker at Address) or "fastcgi" (php-fpm at Address).
+	Transport string `json:"transport"`
+	Address   string `json:"address"`
+
+	// TextBodies sends request bodies as plain strings even when they
+	// aren't UTF-8, for workers that don't read "body_base64".
+	TextBodies bool `json:"text_bodies"`
+
+	// Balancer and Affinity override the top-level settings for this pool.
+	Balancer string          `json:"balancer"`
+	Affinity *AffinityConfig `json:"affinity"`
+}
+
+// PoolRoute pins a path prefix (optionally only for some methods, or only
+// when a header / cookie / query parameter is set to value) to a pool.
+type PoolRoute struct {
+	Prefix  string   `json:"prefix"`
+	Methods []string `json:"methods"`
+	Header  string   `json:"header"`
+	Cookie  string   `json:"cookie"`
+	Query   string   `json:"query"`
+	Value   string   `json:"value"`
+	Pool    string   `json:"pool"`
+}
+
+type AppServerConfig struct {
+	// Root is the project directory: app code, public/ and relative paths
+	// in the config resolve against it. It is not read from the file;
+	// New defaults it to ProjectRoot().
+	Root string `json:"-"`
+
+	FastWorkers          int          `json:"fast_workers"`
+	SlowWorkers          int          `json:"slow_workers"`
+	HotReload            bool         `json:"hot_reload"`
+	WatchConfig          bool         `json:"watch_config"` // reload go_appserver.json on change (implied by hot_reload)
+	Strict               bool         `json:"strict"`       // refuse to start on unknown keys or invalid values
+	RequestTimeoutMs     int          `json:"request_timeout_ms"`
+	MaxRequestsPerWorker int          `json:"max_requests_per_worker"`
+	Static               []StaticRule `json:"static"`
+
+	SlowRoutes        []string `json:"slow_routes"`
+	SlowMethods       []string `json:"slow_methods"`
+	SlowBodyThreshold int      `json:"slow_body_threshold"`
+
+	// Adaptive tunes automatic promotion of slow prefixes to the slow pool
+	// (and their demotion once they recover).
+	Adaptive server.AdaptiveConfig `json:"adaptive"`
+
+	// ScoreboardFile, if set, is rewritten every second with the compact
+	// worker scoreboard (relative paths are resolved against the project root).
+	ScoreboardFile string `json:"scoreboard_file"`
+
+	// AdaptiveStateFile, if set, keeps the prefixes adaptive routing
+	// promoted across restarts (relative paths are resolved against the
+	// project root).
+	AdaptiveStateFile string `json:"adaptive_state_file"`
+
+	// ErrorPages maps status codes ("404", "500", "502", ...) or "50x" to
+	// pages served instead of the plain-text error for worker failures, and
+	// for 404/5xx responses from PHP with an empty body.
+	ErrorPages map[string]string `json:"error_pages"`
+
+	// ErrorPagesOverride serves ErrorPages for PHP's 404/5xx responses even
+	// when PHP sent a body.
+	ErrorPagesOverride bool `json:"error_pages_override"`
+
+	// DebugErrors includes PHP fatal error details (message, file, line,
+	// stderr) in 500 responses. Development only.
+	DebugErrors bool `json:"debug_errors"`
+
+	// DegradedStart keeps the server up (static assets + MaintenancePage)
+	// when PHP workers can't be spawned at boot, retrying in the background.
+	DegradedStart   bool   `json:"degraded_start"`
+	MaintenancePage string `json:"maintenance_page"`
+
+	// Balancer picks workers within a pool: "round_robin" (default),
+	// "least_outstanding" or "ewma".
+	Balancer string `json:"balancer"`
+
+	// Affinity keeps each session on the same worker (by cookie, header or
+	// authenticated user) for better in-process cache locality.
+	Affinity *AffinityConfig `json:"affinity"`
+
+	// Pools replaces the fast/slow pair with arbitrary named pools; when empty
+	// fast_workers/slow_workers are used. PoolRoutes pin prefixes to pools.
+	Pools      []PoolConfig `json:"pools"`
+	PoolRoutes []PoolRoute  `json:"pool_routes"`
+
+	// Canary diverts a share of one pool's traffic to another (e.g. a new
+	// release checked out under a different pool root).
+	Canary *server.Canary `json:"canary"`
+
+	// Shadow mirrors a sample of live traffic to another pool and records
+	// how it performs, discarding its responses.
+	Shadow *server.Shadow `json:"shadow"`
+
+	// Retry re-runs idempotent requests whose worker died, on another worker
+	// (or fallback pool).
+	Retry *server.RetryPolicy `json:"retry"`
+
+	// LoadShed answers 503 + Retry-After up front when a pool is saturated.
+	LoadShed *server.LoadShed `json:"load_shed"`
+
+	// Chaos injects worker latency, broken pipes and dropped stream frames,
+	// for resilience testing outside production.
+	Chaos *server.Chaos `json:"chaos"`
+
+	// Priorities ranks requests (high/normal/low) for queueing and shedding.
+	Priorities *server.Priorities `json:"priorities"`
+
+	// Admin configures access to management endpoints.
+	Admin AdminConfig `json:"admin"`
+
+	// Apps are additional PHP applications selected by Host (+ prefix).
+	Apps []AppConfig `json:"apps"`
+
+	// StaticGzip compresses text assets once and serves the cached .gz.
+	StaticGzip StaticGzipConfig `json:"static_gzip"`
+
+	// AssetManifest serves the files a Vite/Mix manifest lists as immutable
+	// and resolves logical asset names at /__baremetal/assets.
+	AssetManifest *AssetManifestConfig `json:"asset_manifest"`
+
+	// StaticCache keeps small, hot static files in memory.
+	StaticCache StaticCacheConfig `json:"static_cache"`
+
+	// Proxy forwards selected prefixes to upstream HTTP(S) origins.
+	Proxy []ProxyRule `json:"proxy"`
+
+	// ESI resolves <esi:include src="..."/> in PHP responses via subrequests.
+	ESI ESIConfig `json:"esi"`
+
+	// WorkerGuard checks pool sizes against available memory.
+	WorkerGuard WorkerGuardConfig `json:"worker_guard"`
+
+	// Spool large buffered responses to disk instead of holding them in memory.
+	Spool SpoolConfig `json:"response_spool"`
+
+	// Relay large buffered responses to the client as the worker sends them.
+	Relay RelayConfig `json:"response_relay"`
+
+	// Decode multipart uploads for the worker instead of passing the raw body.
+	Uploads UploadConfig `json:"uploads"`
+
+	// WasmFilters run WebAssembly modules on requests before they reach PHP.
+	WasmFilters []WasmFilter `json:"wasm_filters"`
+
+	// CORS answers cross-origin requests and preflights without PHP.
+	CORS *CORSConfig `json:"cors"`
+
+	// IPAccess allows or denies clients by address, globally and per prefix.
+	IPAccess *IPAccessConfig `json:"ip_access"`
+
+	// ProxyProtocol takes the client address from PROXY protocol headers
+	// sent by trusted TCP load balancers.
+	ProxyProtocol ProxyProtocolConfig `json:"proxy_protocol"`
+
+	// TLS serves the main listener over HTTPS, with optional client
+	// certificates.
+	TLS TLSConfig `json:"tls"`
+
+	// BasicAuth puts prefixes (a staging site, the dashboard) behind HTTP
+	// Basic auth.
+	BasicAuth []BasicAuthRule `json:"basic_auth"`
+
+	// JWTAuth requires a bearer token on API prefixes.
+	JWTAuth *JWTAuthConfig `json:"jwt_auth"`
+
+	// ClientTimeouts protects the listeners from slow clients.
+	ClientTimeouts ClientTimeouts `json:"client_timeouts"`
+
+	// HTTPServer tunes header limits, keep-alives and TLS handshakes.
+	HTTPServer HTTPServerConfig `json:"http_server"`
+
+	// RequestDecompression decodes gzip/deflate request bodies for PHP.
+	RequestDecompression RequestDecompressionConfig `json:"request_decompression"`
+
+	// Coalesce shares one PHP dispatch among identical concurrent GETs.
+	Coalesce CoalesceConfig `json:"coalesce"`
+
+	// ResponseCache answers GETs from cacheable PHP responses, serving
+	// stale ones while revalidating or when PHP fails.
+	ResponseCache ResponseCacheConfig `json:"response_cache"`
+
+	// AllowedHosts refuses requests for any other Host (exact names or
+	// "*.example.com"). Empty allows every Host.
+	AllowedHosts []string `json:"allowed_hosts"`
+
+	// Redirects answers HTTPS, canonical host and path redirects without
+	// running PHP.
+	Redirects *RedirectConfig `json:"redirects"`
+
+	// Rewrites change request paths before static matching and dispatch.
+	Rewrites []RewriteRule `json:"rewrites"`
+
+	// ServerTiming reports queue, PHP and Go time in a Server-Timing header.
+	ServerTiming ServerTimingConfig `json:"server_timing"`
+
+	// RecentRequests is how many requests /__baremetal/requests/recent
+	// keeps (default 100; negative turns it off).
+	RecentRequests int `json:"recent_requests"`
+
+	// RouteMetrics bounds how many paths the metrics track under by_route.
+	RouteMetrics RouteMetricsConfig `json:"route_metrics"`
+
+	// RateLimits caps request rates per client on path prefixes.
+	RateLimits *RateLimitConfig `json:"rate_limits"`
+
+	// Alerts posts to a webhook when error rate, dead workers or p95
+	// latency stay over a threshold.
+	Alerts *AlertConfig `json:"alerts"`
+
+	// Capture writes a sample of PHP requests to disk for "server replay".
+	Capture *CaptureConfig `json:"capture"`
+
+	// DebugHeaders adds X-Debug-* diagnostics to chosen requests' responses.
+	DebugHeaders *DebugHeadersConfig `json:"debug_headers"`
+
+	// Limits caps open connections and requests in flight.
+	Limits LimitsConfig `json:"limits"`
+
+	// CSRF rejects cross-site form posts and other mutating requests.
+	CSRF *CSRFConfig `json:"csrf"`
+
+	// WSAuth sets the keys /ws bearer tokens are checked against, e.g. an
+	// IdP's JWKS. Without it they're HS256 with APP_JWT_SECRET.
+	WSAuth *JWTVerifyConfig `json:"ws_auth"`
+
+	// WSCookie requires the user ID cookie fallback to be signed.
+	WSCookie *WSCookieConfig `json:"ws_cookie"`
+
+	// WSSession authenticates realtime connections by the PHP session.
+	WSSession *PHPSessionConfig `json:"ws_session"`
+}
+
+// serverPools converts the pool configuration for server.NewServerWithPools,
+// falling back to the classic fast/slow layout. auth identifies users for
+// user affinity.
+func (c *AppServerConfig) serverPools(auth *wsAuth) []server.PoolConfig {
+	timeout := time.Duration(c.RequestTimeoutMs) * time.Millisecond
+
+	var affinity server.Affinity
+	if c.Affinity != nil {
+		affinity = c.Affinity.server(auth)
+	}
+
+	if len(c.Pools) == 0 {
+		return []server.PoolConfig{
+			{Name: server.FastPool, Workers: c.FastWorkers, MaxRequests: c.MaxRequestsPerWorker, RequestTimeout: timeout, Balancer: c.Balancer, Affinity: affinity},
+			{Name: server.SlowPool, Workers: c.SlowWorkers, MaxRequests: c.MaxRequestsPerWorker, RequestTimeout: timeout, Balancer: c.Balancer, Affinity: affinity},
+		}
+	}
+
+	pools := make([]server.PoolConfig, 0, len(c.Pools))
+	for _, p := range c.Pools {
+		poolAffinity := affinity
+		if p.Affinity != nil {
+			poolAffinity = p.Affinity.server(auth)
+		}
+		pools = append(pools, server.PoolConfig{
+			Name:           p.Name,
+			Workers:        p.Workers,
+			MaxRequests:    p.MaxRequestsPerWorker,
+			RequestTimeout: time.Duration(p.RequestTimeoutMs) * time.Millisecond,
+			PHPBinary:      p.PHPBinary,
+			PHPIni:         p.PHPIni,
+			WorkerScript:   p.WorkerScript,
+			ProjectRoot:    p.Root,
+			Transport:      p.Transport,
+			Address:        p.Address,
+			TextBodies:     p.TextBodies,
+			Balancer:       p.Balancer,
+			Affinity:       poolAffinity,
+		})
+	}
+	return pools
+}
+
+func (c *AppServerConfig) serverPoolRoutes() []server.PoolRoute {
+	routes := make([]server.PoolRoute, 0, len(c.PoolRoutes))
+	for _, rt := range c.PoolRoutes {
+		routes = append(routes, server.PoolRoute{
+			Prefix:  rt.Prefix,
+			Methods: rt.Methods,
+			Header:  rt.Header,
+			Cookie:  rt.Cookie,
+			Query:   rt.Query,
+			Value:   rt.Value,
+			Pool:    rt.Pool,
+		})
+	}
+	return routes
+}
+
+// DefaultConfig returns sane defaults when go_appserver.json
+// is missing or invalid.
+func DefaultConfig() *AppServerConfig {
+	return &AppServerConfig{
+		FastWorkers:          4,
+		SlowWorkers:          2,
+		HotReload:            false,
+		RequestTimeoutMs:     10000, // 10s
+		MaxRequestsPerWorker: 1000,
+		Static: []StaticRule{
+			{Prefix: "/assets/", Dir: "public/assets"},
+			{Prefix: "/build/", Dir: "public/build"},
+			{Prefix: "/css/", Dir: "public/css"},
+			{Prefix: "/js/", Dir: "public/js"},
+			{Prefix: "/images/", Dir: "public/images"},
+			{Prefix: "/img/", Dir: "public/img"},
+		},
+		SlowRoutes:        []string{"/reports/", "/admin/analytics"},
+		SlowMethods:       []string{"PUT", "DELETE"},
+		SlowBodyThreshold: 2_000_000,
+	}
+}
+
+// ConfigPath is where LoadConfig (and the config watcher) look for the
+// config file: the first of configNames that exists in projectRoot, or
+// go_appserver.json.
+func ConfigPath(projectRoot string) string {
+	for _, name := range configNames {
+		path := filepath.Join(projectRoot, name)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return filepath.Join(projectRoot, configNames[0])
+}
+
+// LoadConfig tries to read go_appserver.json (or .yaml / .toml) from
+// projectRoot; falls back to defaults on any error.
+func LoadConfig(projectRoot string) *AppServerConfig {
+	cfgPath := ConfigPath(projectRoot)
+
+	if _, err := os.Stat(cfgPath); err != nil {
+		log.Printf("[config] no go_appserver.json found at %s, using defaults: %v", cfgPath, err)
+		return DefaultConfig()
+	}
+
+	cfg, err := readConfig(cfgPath, logConfig)
+	if err != nil {
+		log.Printf("[config] invalid %s, using defaults: %v", cfgPath, err)
+		return DefaultConfig()
+	}
+	return cfg
+}
+
+// parseConfig decodes a config file and normalises it, falling back to
+// defaults for invalid values.
+func parseConfig(data []byte, rep configReport) (*AppServerConfig, error) {
+	var cfg AppServerConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, err
+	}
+	normalizeConfig(&cfg, rep)
+	return &cfg, nil
+}
+
+// normalizeConfig fills in defaults for unset values and fixes up or drops
+// invalid ones, reporting each to rep.
+func normalizeConfig(cfg *AppServerConfig, rep configReport) {
+	warn := rep.warn
+
+	// Pull a copy of defaults for use below
+	def := DefaultConfig()
+
+	//
+	// -------------------------
+	// Core config validation
+	// -------------------------
+	//
+
+	// Zero means "not set" and quietly takes the default; negative values
+	// are reported.
+	if cfg.FastWorkers < 0 {
+		warn("fast_workers", "fast_workers=%d is invalid, falling back to %d", cfg.FastWorkers, def.FastWorkers)
+	}
+	if cfg.FastWorkers <= 0 {
+		cfg.FastWorkers = def.FastWorkers
+	}
+
+	if cfg.SlowWorkers < 0 {
+		warn("slow_workers", "slow_workers=%d is invalid, falling back to %d", cfg.SlowWorkers, def.SlowWorkers)
+		cfg.SlowWorkers = def.SlowWorkers
+	}
+
+	if cfg.RequestTimeoutMs < 0 {
+		warn("request_timeout_ms", "request_timeout_ms=%d is invalid, falling back to %dms", cfg.RequestTimeoutMs, def.RequestTimeoutMs)
+	}
+	if cfg.RequestTimeoutMs <= 0 {
+		cfg.RequestTimeoutMs = def.RequestTimeoutMs
+	}
+
+	if cfg.MaxRequestsPerWorker < 0 {
+		warn("max_requests_per_worker", "max_requests_per_worker=%d is invalid, falling back to %d", cfg.MaxRequestsPerWorker, def.MaxRequestsPerWorker)
+	}
+	if cfg.MaxRequestsPerWorker <= 0 {
+		cfg.MaxRequestsPerWorker = def.MaxRequestsPerWorker
+	}
+
+	//
+	// -------------------------
+	// Static rules validation
+	// -------------------------
+	//
+	if len(cfg.Static) == 0 {
+		rep.note("[config] no static rules configured, using default static rules")
+		cfg.Static = DefaultConfig().Static
+	} else {
+		for i, rule := range cfg.Static {
+			if !strings.HasPrefix(rule.Prefix, "/") {
+				warn(fmt.Sprintf("static[%d].prefix", i), "static[%d].prefix=%q does not start with '/', fixing", i, rule.Prefix)
+				cfg.Static[i].Prefix = "/" + rule.Prefix
+			}
+
+			if rule.Dir == "" {
+				warn(fmt.Sprintf("static[%d].dir", i), "static[%d].dir is empty, this rule will be ignored at runtime.", i)
+			}
+		}
+	}
+
+	//
+	// -------------------------
+	// Slow-request config
+	// -------------------------
+	//
+
+	// Route prefixes
+	if len(cfg.SlowRoutes) == 0 {
+		cfg.SlowRoutes = def.SlowRoutes
+		rep.note("[config] slow_routes missing, using defaults: %v", cfg.SlowRoutes)
+	}
+
+	// Methods to treat as slow
+	if len(cfg.SlowMethods) == 0 {
+		cfg.SlowMethods = def.SlowMethods
+		rep.note("[config] slow_methods missing, using defaults: %v", cfg.SlowMethods)
+	}
+
+	// Body size threshold
+	if cfg.SlowBodyThreshold < 0 {
+		warn("slow_body_threshold", "slow_body_threshold=%d is invalid, using default: %d bytes", cfg.SlowBodyThreshold, def.SlowBodyThreshold)
+	}
+	if cfg.SlowBodyThreshold <= 0 {
+		cfg.SlowBodyThreshold = def.SlowBodyThreshold
+	}
+
+	// Adaptive promotion/demotion
+	if a := cfg.Adaptive; a.DemoteMs > 0 && a.PromoteMs > 0 && a.DemoteMs > a.PromoteMs {
+		warn("adaptive.demote_ms", "adaptive.demote_ms=%d is above promote_ms=%d, using %d", a.DemoteMs, a.PromoteMs, a.PromoteMs)
+		cfg.Adaptive.DemoteMs = a.PromoteMs
+	}
+	if d := cfg.Adaptive.Decay; d < 0 || d > 1 {
+		warn("adaptive.decay", "adaptive.decay=%v is outside 0-1, using default 0.5", d)
+		cfg.Adaptive.Decay = 0
+	}
+
+	validatePools(cfg, warn)
+	validateProxies(cfg, warn)
+	validateApps(cfg, warn)
+	validateStaticRules("static", cfg.Static, warn)
+	validateAssetManifest(cfg, warn)
+	for i := range cfg.Apps {
+		validateStaticRules(fmt.Sprintf("apps[%d].static", i), cfg.Apps[i].Static, warn)
+	}
+	validateWasmFilters(cfg, warn)
+	validateCORS(cfg, warn)
+	validateIPAccess(cfg, warn)
+	validateDebugHeaders(cfg, warn)
+	validateCapture(cfg, warn)
+	validateChaos(cfg, warn)
+	validateRelay(cfg, warn)
+	validateRouteMetrics(cfg, warn)
+	validateAlerts(cfg, warn)
+	validateRateLimits(cfg, warn)
+	validateProxyProtocol(cfg, warn)
+	validateTLS(cfg, warn)
+	validateBasicAuth(cfg, warn)
+	validateClientTimeouts(cfg, warn)
+	validateHTTPServer(cfg, warn)
+	validateLimits(cfg, warn)
+	validateAllowedHosts(cfg, warn)
+	validateRedirects(cfg, warn)
+	validateRewrites(cfg, warn)
+	validateCoalesce(cfg, warn)
+	validateResponseCache(cfg, warn)
+	validateRequestDecompression(cfg, warn)
+	validateJWTAuth(cfg, warn)
+	validateCSRF(cfg, warn)
+	if cfg.WSAuth != nil {
+		validateJWTVerify("ws_auth", cfg.WSAuth, warn)
+	}
+	validateWSCookie(cfg, warn)
+	validateWSSession(cfg, warn)
+
+	if cfg.Spool.ThresholdBytes < 0 {
+		warn("response_spool.threshold_bytes", "response_spool.threshold_bytes=%d is invalid, spooling disabled", cfg.Spool.ThresholdBytes)
+		cfg.Spool.ThresholdBytes = 0
+	}
+}
+
+// validatePools drops unusable pool definitions and routes, and fills pool
+// timeouts/limits from the top-level settings.
+func validatePools(cfg *AppServerConfig, warn configWarnFunc) {
+	if _, err := server.NewBalancer(cfg.Balancer); err != nil {
+		warn("balancer", "%v, falling back to round_robin", err)
+		cfg.Balancer = server.BalanceRoundRobin
+	}
+
+	known := make(map[string]bool, len(cfg.Pools))
+	pools := cfg.Pools[:0]
+	for i, p := range cfg.Pools {
+		if p.Name == "" {
+			warn(fmt.Sprintf("pools[%d].name", i), "pools[%d] has no name, ignoring it", i)
+			continue
+		}
+		if known[p.Name] {
+			warn(fmt.Sprintf("pools[%d].name", i), "pools[%d] duplicates pool %q, ignoring it", i, p.Name)
+			continue
+		}
+		if p.Workers < 0 {
+			warn(fmt.Sprintf("pools[%d].workers", i), "pools[%d].workers=%d is invalid, falling back to 1", i, p.Workers)
+		}
+		if p.Workers <= 0 {
+			p.Workers = 1
+		}
+		if p.RequestTimeoutMs <= 0 {
+			p.RequestTimeoutMs = cfg.RequestTimeoutMs
+		}
+		if p.MaxRequestsPerWorker <= 0 {
+			p.MaxRequestsPerWorker = cfg.MaxRequestsPerWorker
+		}
+		if p.Balancer == "" {
+			p.Balancer = cfg.Balancer
+		}
+		if _, err := server.NewBalancer(p.Balancer); err != nil {
+			warn(fmt.Sprintf("pools[%d].balancer", i), "pools[%d]: %v, falling back to round_robin", i, err)
+			p.Balancer = server.BalanceRoundRobin
+		}
+		known[p.Name] = true
+		pools = append(pools, p)
+	}
+	if len(cfg.Pools) > 0 {
+		cfg.Pools = pools
+	}
+	if len(cfg.Pools) == 0 {
+		known[server.FastPool] = true
+		known[server.SlowPool] = true
+	}
+
+	routes := cfg.PoolRoutes[:0]
+	for i, rt := range cfg.PoolRoutes {
+		if !known[rt.Pool] {
+			warn(fmt.Sprintf("pool_routes[%d].pool", i), "pool_routes[%d] targets unknown pool %q, ignoring it", i, rt.Pool)
+			continue
+		}
+		if !strings.HasPrefix(rt.Prefix, "/") {
+			warn(fmt.Sprintf("pool_routes[%d].prefix", i), "pool_routes[%d].prefix=%q does not start with '/', fixing", i, rt.Prefix)
+			rt.Prefix = "/" + rt.Prefix
+		}
+		routes = append(routes, rt)
+	}
+	cfg.PoolRoutes = routes
+}
@@ -1,10 +1,11 @@
-// cmd/server/main_test.go
-package main
+// appserver/appserver_test.go
+package appserver
 
 import (
 	"bytes"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"net"
 	"net/http"
@@ -15,6 +16,8 @@ import (
 	"testing"
 	"time"
 
+	"go-php/server"
+
 	"github.com/golang-jwt/jwt/v5"
 )
 
@@ -96,6 +99,74 @@ func TestTryServeStaticNotFound(t *testing.T) {
 	}
 }
 
+func TestTryServeStaticCacheControl(t *testing.T) {
+	root := t.TempDir()
+	for _, dir := range []string{"build", "images", "misc"} {
+		if err := os.MkdirAll(filepath.Join(root, "public", dir), 0o755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(root, "public", dir, "f.txt"), []byte("x"), 0o644); err != nil {
+			t.Fatalf("write file: %v", err)
+		}
+	}
+	rules := []StaticRule{
+		{Prefix: "/build/", Dir: "public/build", Immutable: true},
+		{Prefix: "/images/", Dir: "public/images", MaxAge: 300, SMaxAge: 3600},
+		{Prefix: "/misc/", Dir: "public/misc"},
+	}
+
+	for path, want := range map[string]string{
+		"/build/f.txt":  "public, max-age=31536000, immutable",
+		"/images/f.txt": "public, max-age=300, s-maxage=3600",
+		"/misc/f.txt":   "",
+	} {
+		w := httptest.NewRecorder()
+		if !tryServeStatic(w, httptest.NewRequest(http.MethodGet, path, nil), root, rules) {
+			t.Fatalf("%s not served", path)
+		}
+		if got := w.Header().Get("Cache-Control"); got != want {
+			t.Errorf("%s: Cache-Control %q, want %q", path, got, want)
+		}
+	}
+}
+
+func TestTryServeStaticMimeTypesAndExtensions(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "public")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	for _, name := range []string{"app.wasm", "main.MJS", "index.php", ".env", "README"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("x"), 0o644); err != nil {
+			t.Fatalf("write file: %v", err)
+		}
+	}
+	rules := []StaticRule{{
+		Prefix:     "/",
+		Dir:        "public",
+		MimeTypes:  map[string]string{"wasm": "application/wasm", ".mjs": "text/javascript; charset=utf-8"},
+		Extensions: []string{".wasm", "mjs"},
+	}}
+
+	for path, want := range map[string]string{
+		"/app.wasm":  "application/wasm",
+		"/main.MJS":  "text/javascript; charset=utf-8",
+		"/index.php": "",
+		"/.env":      "",
+		"/README":    "",
+	} {
+		w := httptest.NewRecorder()
+		served := tryServeStatic(w, httptest.NewRequest(http.MethodGet, path, nil), root, rules)
+		if served != (want != "") {
+			t.Errorf("%s: served=%v", path, served)
+			continue
+		}
+		if got := w.Header().Get("Content-Type"); served && got != want {
+			t.Errorf("%s: Content-Type %q, want %q", path, got, want)
+		}
+	}
+}
+
 func TestBuildPayloadCopiesHeadersAndRequestURI(t *testing.T) {
 	body := bytes.NewBufferString("payload")
 	r := httptest.NewRequest(http.MethodPost, "/foo/bar?x=1", body)
@@ -109,7 +180,7 @@ func TestBuildPayloadCopiesHeadersAndRequestURI(t *testing.T) {
 	if payload.Path != "/foo/bar?x=1" {
 		t.Fatalf("expected full RequestURI, got %q", payload.Path)
 	}
-	if payload.Body != "payload" {
+	if string(payload.Body) != "payload" {
 		t.Fatalf("unexpected body: %q", payload.Body)
 	}
 	if payload.Headers["X-Custom"][0] != "val" {
@@ -169,7 +240,7 @@ func TestGetProjectRootFindsGoMod(t *testing.T) {
 		t.Fatalf("chdir: %v", err)
 	}
 
-	root := getProjectRoot()
+	root := ProjectRoot()
 
 	// macOS /var is a symlink to /private/var, which breaks the equality check.
 	resolvedRoot, err := filepath.EvalSymlinks(root)
@@ -187,13 +258,13 @@ func TestGetProjectRootFindsGoMod(t *testing.T) {
 
 func TestDefaultConfigAndLoadConfigFallback(t *testing.T) {
 	tmp := t.TempDir()
-	cfg := loadConfig(tmp) // no go_appserver.json → defaults
-	def := defaultConfig()
+	cfg := LoadConfig(tmp) // no go_appserver.json → defaults
+	def := DefaultConfig()
 
 	if cfg.FastWorkers != def.FastWorkers ||
 		cfg.SlowWorkers != def.SlowWorkers ||
 		cfg.RequestTimeoutMs != def.RequestTimeoutMs {
-		t.Fatalf("loadConfig did not fall back to defaults correctly: %#v", cfg)
+		t.Fatalf("LoadConfig did not fall back to defaults correctly: %#v", cfg)
 	}
 }
 
@@ -219,7 +290,7 @@ func TestLoadConfigValidationAndDefaults(t *testing.T) {
 		t.Fatalf("write config: %v", err)
 	}
 
-	cfg := loadConfig(tmp)
+	cfg := LoadConfig(tmp)
 	if cfg.FastWorkers <= 0 {
 		t.Fatalf("FastWorkers not fixed up: %d", cfg.FastWorkers)
 	}
@@ -259,34 +330,38 @@ func TestLoadConfigInvalidJSON(t *testing.T) {
 		t.Fatalf("write config: %v", err)
 	}
 
-	cfg := loadConfig(tmp)
-	def := defaultConfig()
+	cfg := LoadConfig(tmp)
+	def := DefaultConfig()
 	if cfg.FastWorkers != def.FastWorkers {
 		t.Fatalf("expected fallback to defaults on invalid JSON")
 	}
 }
 
 func TestMapWorkerErrorToStatus(t *testing.T) {
-	if got := mapWorkerErrorToStatus(errors.New("timeout")); got != http.StatusGatewayTimeout {
-		t.Fatalf("timeout → %d, want %d", got, http.StatusGatewayTimeout)
-	}
-	if got := mapWorkerErrorToStatus(errors.New("broken pipe")); got != http.StatusBadGateway {
-		t.Fatalf("broken pipe → %d, want %d", got, http.StatusBadGateway)
-	}
-	if got := mapWorkerErrorToStatus(errors.New("unexpected EOF")); got != http.StatusBadGateway {
-		t.Fatalf("unexpected EOF → %d, want %d", got, http.StatusBadGateway)
-	}
-	if got := mapWorkerErrorToStatus(errors.New("connection reset")); got != http.StatusBadGateway {
-		t.Fatalf("connection reset → %d, want %d", got, http.StatusBadGateway)
-	}
-	if got := mapWorkerErrorToStatus(errors.New("something else")); got != http.StatusInternalServerError {
-		t.Fatalf("other error → %d, want %d", got, http.StatusInternalServerError)
+	for _, tc := range []struct {
+		err  error
+		want int
+	}{
+		{fmt.Errorf("dispatch: %w", server.ErrWorkerTimeout), http.StatusGatewayTimeout},
+		{server.ErrWorkerCrashed, http.StatusBadGateway},
+		{server.ErrFrameTooLarge, http.StatusBadGateway},
+		{server.ErrProtocol, http.StatusBadGateway},
+		{io.ErrUnexpectedEOF, http.StatusBadGateway},
+		{&net.OpError{Op: "read", Net: "tcp", Err: io.EOF}, http.StatusBadGateway},
+		// messages alone don't decide anything
+		{errors.New("timeout"), http.StatusInternalServerError},
+		{errors.New("broken pipe"), http.StatusInternalServerError},
+		{errors.New("something else"), http.StatusInternalServerError},
+	} {
+		if got := mapWorkerErrorToStatus(tc.err); got != tc.want {
+			t.Errorf("%v → %d, want %d", tc.err, got, tc.want)
+		}
 	}
 }
 
 func TestWriteWorkerErrorWritesStatus(t *testing.T) {
 	rr := httptest.NewRecorder()
-	writeWorkerError(rr, errors.New("timeout"))
+	writeWorkerError(rr, server.ErrWorkerTimeout, "", errorOutput{})
 	resp := rr.Result()
 	if resp.StatusCode != http.StatusGatewayTimeout {
 		t.Fatalf("expected 504, got %d", resp.StatusCode)
@@ -374,7 +449,7 @@ func TestAuthenticateWSWithJWT(t *testing.T) {
 	r := httptest.NewRequest(http.MethodGet, "/", nil)
 	r.Header.Set("Authorization", "Bearer "+tokenString)
 
-	userID, err := authenticateWS(r)
+	userID, err := authenticateWS(nil, r)
 	if err != nil {
 		t.Fatalf("expected no error, got %v", err)
 	}
@@ -391,7 +466,7 @@ func TestAuthenticateWSWithInvalidJWT(t *testing.T) {
 	r := httptest.NewRequest(http.MethodGet, "/", nil)
 	r.Header.Set("Authorization", "Bearer invalid-token")
 
-	_, err := authenticateWS(r)
+	_, err := authenticateWS(nil, r)
 	if err == nil {
 		t.Fatalf("expected error for invalid token")
 	}
@@ -413,7 +488,7 @@ func TestAuthenticateWSWithWrongSigningMethod(t *testing.T) {
 	r := httptest.NewRequest(http.MethodGet, "/", nil)
 	r.Header.Set("Authorization", "Bearer "+tokenString)
 
-	_, err := authenticateWS(r)
+	_, err := authenticateWS(nil, r)
 	// Should fail due to wrong signing method
 	if err == nil {
 		t.Fatalf("expected error for wrong signing method")
@@ -428,7 +503,7 @@ func TestAuthenticateWSWithCookie(t *testing.T) {
 	r := httptest.NewRequest(http.MethodGet, "/", nil)
 	r.AddCookie(&http.Cookie{Name: "bm_user_id", Value: "cookie-user-123"})
 
-	userID, err := authenticateWS(r)
+	userID, err := authenticateWS(nil, r)
 	if err != nil {
 		t.Fatalf("expected no error, got %v", err)
 	}
@@ -445,7 +520,7 @@ func TestAuthenticateWSWithEmptyCookie(t *testing.T) {
 	r := httptest.NewRequest(http.MethodGet, "/", nil)
 	r.AddCookie(&http.Cookie{Name: "bm_user_id", Value: ""})
 
-	_, err := authenticateWS(r)
+	_, err := authenticateWS(nil, r)
 	if err == nil {
 		t.Fatalf("expected error for empty cookie")
 	}
@@ -458,7 +533,7 @@ func TestAuthenticateWSUnauthenticated(t *testing.T) {
 
 	r := httptest.NewRequest(http.MethodGet, "/", nil)
 
-	_, err := authenticateWS(r)
+	_, err := authenticateWS(nil, r)
 	if err == nil {
 		t.Fatalf("expected error for unauthenticated request")
 	}
@@ -482,8 +557,247 @@ func TestAuthenticateWSWithJWTButEmptyUserID(t *testing.T) {
 	r := httptest.NewRequest(http.MethodGet, "/", nil)
 	r.Header.Set("Authorization", "Bearer "+tokenString)
 
-	_, err = authenticateWS(r)
+	_, err = authenticateWS(nil, r)
 	if err == nil {
 		t.Fatalf("expected error for empty user ID")
 	}
 }
+
+func TestMapWorkerErrorToStatusDegraded(t *testing.T) {
+	err := fmt.Errorf("dispatch: %w", server.ErrDegraded)
+	if got := mapWorkerErrorToStatus(err); got != http.StatusServiceUnavailable {
+		t.Fatalf("degraded → %d, want %d", got, http.StatusServiceUnavailable)
+	}
+}
+
+func TestServeMaintenancePage(t *testing.T) {
+	root := t.TempDir()
+	const page = "<h1>Back soon</h1>"
+	if err := os.WriteFile(filepath.Join(root, "maintenance.html"), []byte(page), 0o644); err != nil {
+		t.Fatalf("write page: %v", err)
+	}
+
+	rr := httptest.NewRecorder()
+	serveMaintenancePage(rr, root, "maintenance.html")
+
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503, got %d", rr.Code)
+	}
+	if rr.Body.String() != page {
+		t.Fatalf("unexpected body: %q", rr.Body.String())
+	}
+	if rr.Header().Get("Retry-After") == "" {
+		t.Fatalf("expected Retry-After header")
+	}
+
+	// Missing page falls back to plain text 503.
+	rr = httptest.NewRecorder()
+	serveMaintenancePage(rr, root, "missing.html")
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 for missing page, got %d", rr.Code)
+	}
+}
+
+func TestLoadConfigNamedPools(t *testing.T) {
+	tmp := t.TempDir()
+	raw := `{
+		"request_timeout_ms": 5000,
+		"max_requests_per_worker": 200,
+		"pools": [
+			{"name": "web", "workers": 3},
+			{"name": "reports", "workers": 0, "request_timeout_ms": 60000, "php_ini": {"memory_limit": "1G"}},
+			{"name": "web", "workers": 9},
+			{"workers": 1}
+		],
+		"pool_routes": [
+			{"prefix": "reports/", "pool": "reports"},
+			{"prefix": "/x", "pool": "missing"}
+		]
+	}`
+	if err := os.WriteFile(filepath.Join(tmp, "go_appserver.json"), []byte(raw), 0o644); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+
+	cfg := LoadConfig(tmp)
+
+	if len(cfg.Pools) != 2 {
+		t.Fatalf("expected duplicate and unnamed pools to be dropped, got %+v", cfg.Pools)
+	}
+	web, reports := cfg.Pools[0], cfg.Pools[1]
+	if web.Workers != 3 || web.RequestTimeoutMs != 5000 || web.MaxRequestsPerWorker != 200 {
+		t.Fatalf("web pool did not inherit defaults: %+v", web)
+	}
+	if reports.Workers != 1 || reports.RequestTimeoutMs != 60000 {
+		t.Fatalf("reports pool not validated: %+v", reports)
+	}
+
+	if len(cfg.PoolRoutes) != 1 || cfg.PoolRoutes[0].Prefix != "/reports/" {
+		t.Fatalf("unexpected pool routes: %+v", cfg.PoolRoutes)
+	}
+
+	pools := cfg.serverPools(nil)
+	if len(pools) != 2 || pools[1].PHPIni["memory_limit"] != "1G" || pools[1].RequestTimeout != time.Minute {
+		t.Fatalf("unexpected server pools: %+v", pools)
+	}
+}
+
+func TestServerPoolsLegacyLayout(t *testing.T) {
+	cfg := DefaultConfig()
+	pools := cfg.serverPools(nil)
+
+	if len(pools) != 2 || pools[0].Name != server.FastPool || pools[1].Name != server.SlowPool {
+		t.Fatalf("expected fast/slow pools, got %+v", pools)
+	}
+	if pools[0].Workers != cfg.FastWorkers || pools[1].Workers != cfg.SlowWorkers {
+		t.Fatalf("unexpected worker counts: %+v", pools)
+	}
+}
+
+func TestServerPoolRoutesCarryHeaderAndCookie(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.PoolRoutes = []PoolRoute{
+		{Prefix: "/", Header: "X-Experiment", Value: "b", Pool: server.SlowPool},
+		{Prefix: "/", Cookie: "staff", Pool: server.FastPool},
+	}
+
+	routes := cfg.serverPoolRoutes()
+	if routes[0].Header != "X-Experiment" || routes[0].Value != "b" || routes[1].Cookie != "staff" {
+		t.Fatalf("header/cookie matchers not carried over: %+v", routes)
+	}
+}
+
+func TestValidatePoolsBalancer(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Balancer = "ewma"
+	cfg.Pools = []PoolConfig{
+		{Name: "web", Workers: 2},
+		{Name: "api", Workers: 2, Balancer: "least_outstanding"},
+		{Name: "bad", Workers: 1, Balancer: "random"},
+	}
+	validatePools(cfg, logConfig.warn)
+
+	got := []string{cfg.Pools[0].Balancer, cfg.Pools[1].Balancer, cfg.Pools[2].Balancer}
+	want := []string{"ewma", "least_outstanding", "round_robin"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("pool %d balancer = %q, want %q", i, got[i], want[i])
+		}
+	}
+}
+
+func TestServerPoolsAffinity(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Affinity = &AffinityConfig{Cookie: "PHPSESSID"}
+	cfg.Pools = []PoolConfig{
+		{Name: "web", Workers: 2},
+		{Name: "api", Workers: 2, Affinity: &AffinityConfig{User: true}},
+		{Name: "jobs", Workers: 1, Affinity: &AffinityConfig{}},
+	}
+
+	pools := cfg.serverPools(nil)
+	if pools[0].Affinity.Cookie != "PHPSESSID" {
+		t.Fatalf("web pool should inherit the top-level affinity, got %+v", pools[0].Affinity)
+	}
+	if pools[1].Affinity.Key == nil || pools[1].Affinity.Cookie != "" {
+		t.Fatalf("api pool should pin by user, got %+v", pools[1].Affinity)
+	}
+	if a := pools[2].Affinity; a.Cookie != "" || a.Header != "" || a.Key != nil {
+		t.Fatalf("jobs pool should have affinity turned off, got %+v", a)
+	}
+
+	req := &server.RequestPayload{Headers: map[string][]string{"Cookie": {"bm_user_id=7"}}}
+	if got := pools[1].Affinity.Key(req); got != "7" {
+		t.Fatalf("user affinity key = %q, want 7", got)
+	}
+}
+
+func TestWriteWorkerErrorOverloaded(t *testing.T) {
+	rr := httptest.NewRecorder()
+	writeWorkerError(rr, &server.OverloadError{Pool: "fast", Reason: "busy", RetryAfter: 3 * time.Second}, "", errorOutput{})
+
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503, got %d", rr.Code)
+	}
+	if got := rr.Header().Get("Retry-After"); got != "3" {
+		t.Fatalf("Retry-After = %q, want 3", got)
+	}
+}
+
+func TestWriteWorkerErrorPHPFatal(t *testing.T) {
+	fatal := &server.FatalError{Message: "Uncaught Exception: boom", File: "/app/x.php", Line: 3}
+
+	rr := httptest.NewRecorder()
+	writeWorkerError(rr, fatal, "", errorOutput{})
+	if rr.Code != http.StatusInternalServerError {
+		t.Fatalf("expected 500, got %d", rr.Code)
+	}
+	if ct := rr.Header().Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("Content-Type = %q", ct)
+	}
+	if strings.Contains(rr.Body.String(), "boom") {
+		t.Fatalf("details must not leak without debug: %s", rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	writeWorkerError(rr, fatal, "", errorOutput{debug: true})
+	if !strings.Contains(rr.Body.String(), `"file":"/app/x.php"`) {
+		t.Fatalf("debug body should include the fatal details: %s", rr.Body.String())
+	}
+
+	m := NewMetrics()
+	m.RecordError(&server.RequestPayload{Method: "GET", Path: "/"}, http.StatusInternalServerError, fatal)
+	m.RecordError(&server.RequestPayload{Method: "GET", Path: "/"}, http.StatusBadGateway, io.ErrUnexpectedEOF)
+	if got := m.Snapshot().PHPFatals; got != 1 {
+		t.Fatalf("PHPFatals = %d, want 1", got)
+	}
+}
+
+func TestNewServesStaticAndManagement(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "public", "assets"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "public", "assets", "app.css"), []byte("body{}"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	pools := []PoolConfig{{Name: "fast", Workers: 1, PHPBinary: filepath.Join(root, "no-php")}}
+	if _, err := New(&AppServerConfig{Root: root, Pools: pools}); err == nil {
+		t.Fatal("New should fail when workers can't start and degraded_start is off")
+	}
+
+	srv, err := New(&AppServerConfig{
+		Root:          root,
+		Pools:         pools,
+		DegradedStart: true,
+		Admin:         AdminConfig{Token: "secret"},
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	srv.Start()
+	defer srv.Shutdown(t.Context())
+
+	rr := httptest.NewRecorder()
+	srv.ServeHTTP(rr, httptest.NewRequest("GET", "/assets/app.css", nil))
+	if rr.Code != http.StatusOK || rr.Body.String() != "body{}" {
+		t.Fatalf("static: %d %q", rr.Code, rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	srv.ServeHTTP(rr, httptest.NewRequest("GET", "/__baremetal/ready", nil))
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("management endpoint without token: %d", rr.Code)
+	}
+
+	req := httptest.NewRequest("GET", "/__baremetal/ready", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	rr = httptest.NewRecorder()
+	srv.ServeHTTP(rr, req)
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("ready while degraded: %d", rr.Code)
+	}
+	if srv.AdminHandler() != nil {
+		t.Fatal("AdminHandler should be nil without admin.listen")
+	}
+}
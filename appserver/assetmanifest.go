@@ -0,0 +1,206 @@
+package appserver
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AssetManifestConfig reads a Vite or Laravel Mix manifest, so the
+// fingerprinted files it lists are served with immutable caching and PHP
+// (or anything else) can resolve logical names at /__baremetal/assets.
+type AssetManifestConfig struct {
+	// Path is the manifest file, e.g. "public/build/manifest.json" or
+	// "public/mix-manifest.json".
+	Path string `json:"path"`
+	// Format is "vite", "mix" or "" to tell from the file.
+	Format string `json:"format"`
+	// Prefix is the URL Vite's "file" entries live under (default
+	// "/build/"). Mix entries are URLs already.
+	Prefix string `json:"prefix"`
+}
+
+// assetEntry is one logical asset as served by the endpoint.
+type assetEntry struct {
+	URL string   `json:"url"`
+	CSS []string `json:"css,omitempty"`
+}
+
+type viteChunk struct {
+	File   string   `json:"file"`
+	CSS    []string `json:"css"`
+	Assets []string `json:"assets"`
+}
+
+// assetManifest is the parsed manifest, reloaded when the file changes.
+type assetManifest struct {
+	cfg AssetManifestConfig
+
+	mu      sync.Mutex
+	modTime time.Time
+	checked time.Time
+	entries map[string]assetEntry
+	hashed  map[string]bool // request URIs of fingerprinted files
+}
+
+// assetRecheck is how often the manifest's mtime is looked at.
+const assetRecheck = time.Second
+
+func newAssetManifest(root string, cfg AssetManifestConfig) *assetManifest {
+	if !filepath.IsAbs(cfg.Path) {
+		cfg.Path = filepath.Join(root, cfg.Path)
+	}
+	m := &assetManifest{cfg: cfg}
+	if err := m.reload(); err != nil {
+		// a fresh checkout may not have built its assets yet
+		log.Printf("[assets] %v", err)
+	}
+	return m
+}
+
+// current returns the manifest, reloading it if the file changed.
+func (m *assetManifest) current() (map[string]assetEntry, map[string]bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if time.Since(m.checked) >= assetRecheck {
+		m.checked = time.Now()
+		if info, err := os.Stat(m.cfg.Path); err == nil && !info.ModTime().Equal(m.modTime) {
+			if err := m.reloadLocked(); err != nil {
+				log.Printf("[assets] %v; keeping the previous manifest", err)
+			}
+		}
+	}
+	return m.entries, m.hashed
+}
+
+func (m *assetManifest) reload() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.checked = time.Now()
+	return m.reloadLocked()
+}
+
+func (m *assetManifest) reloadLocked() error {
+	info, err := os.Stat(m.cfg.Path)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(m.cfg.Path)
+	if err != nil {
+		return err
+	}
+	entries, hashed, err := parseAssetManifest(data, m.cfg.Format, m.cfg.Prefix)
+	if err != nil {
+		return errors.New(m.cfg.Path + ": " + err.Error())
+	}
+	m.modTime, m.entries, m.hashed = info.ModTime(), entries, hashed
+	return nil
+}
+
+// parseAssetManifest reads a Vite manifest ({"src/app.js": {"file": ...}})
+// or a Mix one ({"/js/app.js": "/js/app.js?id=..."}).
+func parseAssetManifest(data []byte, format, prefix string) (map[string]assetEntry, map[string]bool, error) {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, nil, err
+	}
+	if format == "" {
+		format = "vite"
+		for _, v := range raw {
+			if len(v) > 0 && v[0] == '"' {
+				format = "mix"
+			}
+			break
+		}
+	}
+
+	entries := make(map[string]assetEntry, len(raw))
+	hashed := make(map[string]bool, len(raw))
+	for name, v := range raw {
+		switch format {
+		case "mix":
+			var u string
+			if err := json.Unmarshal(v, &u); err != nil {
+				return nil, nil, errors.New("mix manifest entry " + name + ": " + err.Error())
+			}
+			entries[name] = assetEntry{URL: u}
+			// /js/app.js?id=abc is immutable only with its id attached
+			if strings.Contains(u, "?") {
+				hashed[u] = true
+			}
+		default:
+			var c viteChunk
+			if err := json.Unmarshal(v, &c); err != nil {
+				return nil, nil, errors.New("vite manifest entry " + name + ": " + err.Error())
+			}
+			e := assetEntry{URL: prefix + c.File}
+			hashed[e.URL] = true
+			for _, css := range c.CSS {
+				e.CSS = append(e.CSS, prefix+css)
+				hashed[prefix+css] = true
+			}
+			for _, a := range c.Assets {
+				hashed[prefix+a] = true
+			}
+			entries[name] = e
+		}
+	}
+	return entries, hashed, nil
+}
+
+// immutable reports whether r asks for a fingerprinted file.
+func (m *assetManifest) immutable(r *http.Request) bool {
+	_, hashed := m.current()
+	return hashed[r.URL.RequestURI()]
+}
+
+// handler serves the whole manifest, or one entry with ?name=.
+func (m *assetManifest) handler(w http.ResponseWriter, r *http.Request) {
+	entries, _ := m.current()
+	w.Header().Set("Content-Type", "application/json")
+	if name := r.URL.Query().Get("name"); name != "" {
+		e, ok := entries[name]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "unknown asset"})
+			return
+		}
+		_ = json.NewEncoder(w).Encode(e)
+		return
+	}
+	if entries == nil {
+		entries = map[string]assetEntry{}
+	}
+	_ = json.NewEncoder(w).Encode(entries)
+}
+
+// validateAssetManifest fills in defaults.
+func validateAssetManifest(cfg *AppServerConfig, warn configWarnFunc) {
+	a := cfg.AssetManifest
+	if a == nil {
+		return
+	}
+	if a.Path == "" {
+		warn("asset_manifest.path", "asset_manifest.path is empty; asset manifest disabled")
+		cfg.AssetManifest = nil
+		return
+	}
+	switch a.Format {
+	case "", "vite", "mix":
+	default:
+		warn("asset_manifest.format", "asset_manifest.format=%q is not vite or mix; detecting it", a.Format)
+		a.Format = ""
+	}
+	if a.Prefix == "" {
+		a.Prefix = "/build/"
+	}
+	if !strings.HasSuffix(a.Prefix, "/") {
+		a.Prefix += "/"
+	}
+}
@@ -0,0 +1,100 @@
+package appserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go-php/server"
+)
+
+func TestAssetManifest(t *testing.T) {
+	root := t.TempDir()
+	build := filepath.Join(root, "public/build")
+	if err := os.MkdirAll(filepath.Join(build, "assets"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"assets/app-4f2a.js":  "js",
+		"assets/app-91bc.css": "css",
+		"robots.txt":          "txt",
+		"manifest.json": `{
+			"resources/js/app.js": {"file": "assets/app-4f2a.js", "css": ["assets/app-91bc.css"], "isEntry": true}
+		}`,
+	}
+	for name, body := range files {
+		if err := os.WriteFile(filepath.Join(build, name), []byte(body), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	srv, err := New(&AppServerConfig{
+		Root: root,
+		Pools: []PoolConfig{{Name: "fast", Workers: 1, Transport: "tcp", Address: fakePHP(t, func(req *server.RequestPayload) *server.ResponsePayload {
+			return &server.ResponsePayload{Status: http.StatusNotFound}
+		})}},
+		Static:        []StaticRule{{Prefix: "/build/", Dir: "public/build"}},
+		AssetManifest: &AssetManifestConfig{Path: "public/build/manifest.json"},
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	t.Cleanup(func() { _ = srv.Shutdown(t.Context()) })
+
+	for path, want := range map[string]string{
+		"/build/assets/app-4f2a.js":  "public, max-age=31536000, immutable",
+		"/build/assets/app-91bc.css": "public, max-age=31536000, immutable",
+		"/build/robots.txt":          "",
+		"/build/assets/gone-0000.js": "",
+	} {
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, httptest.NewRequest("GET", path, nil))
+		if got := rr.Header().Get("Cache-Control"); got != want {
+			t.Errorf("%s: Cache-Control %q, want %q", path, got, want)
+		}
+	}
+
+	lookup := func(name string) (int, assetEntry) {
+		req := httptest.NewRequest("GET", "/__baremetal/assets?name="+name, nil)
+		req.RemoteAddr = "127.0.0.1:1234"
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+		var e assetEntry
+		_ = json.Unmarshal(rr.Body.Bytes(), &e)
+		return rr.Code, e
+	}
+	if code, e := lookup("resources/js/app.js"); code != http.StatusOK || e.URL != "/build/assets/app-4f2a.js" || len(e.CSS) != 1 || e.CSS[0] != "/build/assets/app-91bc.css" {
+		t.Errorf("lookup: %d %+v", code, e)
+	}
+	if code, _ := lookup("missing.js"); code != http.StatusNotFound {
+		t.Errorf("unknown asset: %d", code)
+	}
+
+	// a rebuild is picked up without a restart
+	manifest := filepath.Join(build, "manifest.json")
+	if err := os.WriteFile(manifest, []byte(`{"resources/js/app.js": {"file": "assets/app-77aa.js"}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	_ = os.Chtimes(manifest, later, later)
+	time.Sleep(assetRecheck)
+	if _, e := lookup("resources/js/app.js"); e.URL != "/build/assets/app-77aa.js" {
+		t.Errorf("after rebuild: %+v", e)
+	}
+}
+
+func TestParseMixManifest(t *testing.T) {
+	entries, hashed, err := parseAssetManifest([]byte(`{"/js/app.js": "/js/app.js?id=8f3e", "/img/logo.svg": "/img/logo.svg"}`), "", "/build/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entries["/js/app.js"].URL != "/js/app.js?id=8f3e" {
+		t.Errorf("entries: %+v", entries)
+	}
+	if !hashed["/js/app.js?id=8f3e"] || hashed["/js/app.js"] || hashed["/img/logo.svg"] {
+		t.Errorf("hashed: %v", hashed)
+	}
+}
@@ -0,0 +1,128 @@
+package appserver
+
+import (
+	"cmp"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// autoindexPage lists one directory for a static rule with autoindex set.
+var autoindexPage = template.Must(template.New("autoindex").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>Index of {{.Path}}</title>
+<style>body{font-family:sans-serif}td{padding:2px 12px}td.n{text-align:right}</style>
+</head><body>
+<h1>Index of {{.Path}}</h1>
+<table>
+<tr><th><a href="?sort=name">Name</a></th><th><a href="?sort=size&amp;order=desc">Size</a></th><th><a href="?sort=mtime&amp;order=desc">Modified</a></th></tr>
+{{if ne .Path "/"}}<tr><td><a href="../">../</a></td><td></td><td></td></tr>
+{{end}}{{range .Entries}}<tr><td><a href="{{.Href}}">{{.Name}}</a></td><td class="n">{{if not .Dir}}{{.Size}}{{end}}</td><td>{{.ModTime.UTC.Format "2006-01-02 15:04:05"}}</td></tr>
+{{end}}</table>
+</body></html>
+`))
+
+type autoindexEntry struct {
+	Name    string
+	Href    string
+	Dir     bool
+	Size    int64
+	ModTime time.Time
+}
+
+// hiddenPath reports whether any segment of the URL path starts with a dot.
+func hiddenPath(p string) bool {
+	for _, seg := range strings.Split(p, "/") {
+		if strings.HasPrefix(seg, ".") && seg != "." && seg != ".." {
+			return true
+		}
+	}
+	return false
+}
+
+// serveAutoindex serves dir's index.html, or else a listing of dir that
+// leaves out dot files and files the rule's extension allowlist wouldn't
+// serve. ?sort=name|size|mtime and ?order=desc pick the order; directories
+// always come first.
+func serveAutoindex(w http.ResponseWriter, r *http.Request, rule StaticRule, dir string) {
+	if !strings.HasSuffix(r.URL.Path, "/") {
+		target := r.URL.Path + "/"
+		if r.URL.RawQuery != "" {
+			target += "?" + r.URL.RawQuery
+		}
+		http.Redirect(w, r, target, http.StatusMovedPermanently)
+		return
+	}
+
+	index := filepath.Join(dir, "index.html")
+	if info, err := os.Stat(index); err == nil && !info.IsDir() {
+		http.ServeFile(w, r, index)
+		return
+	}
+
+	des, err := os.ReadDir(dir)
+	if err != nil {
+		log.Printf("[static] autoindex %s: %v", dir, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	entries := make([]autoindexEntry, 0, len(des))
+	for _, de := range des {
+		name := de.Name()
+		if strings.HasPrefix(name, ".") {
+			continue
+		}
+		info, err := de.Info()
+		if err != nil {
+			continue
+		}
+		if !info.IsDir() && !rule.allows(filepath.Ext(name)) {
+			continue
+		}
+		e := autoindexEntry{Name: name, Href: url.PathEscape(name), Dir: info.IsDir(), Size: info.Size(), ModTime: info.ModTime()}
+		if e.Dir {
+			e.Name += "/"
+			e.Href += "/"
+		}
+		entries = append(entries, e)
+	}
+
+	q := r.URL.Query()
+	desc := q.Get("order") == "desc"
+	slices.SortFunc(entries, func(a, b autoindexEntry) int {
+		if a.Dir != b.Dir {
+			if a.Dir {
+				return -1
+			}
+			return 1
+		}
+		var c int
+		switch q.Get("sort") {
+		case "size":
+			c = cmp.Compare(a.Size, b.Size)
+		case "mtime":
+			c = a.ModTime.Compare(b.ModTime)
+		}
+		if c == 0 {
+			c = strings.Compare(a.Name, b.Name)
+		}
+		if desc {
+			c = -c
+		}
+		return c
+	})
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	if err := autoindexPage.Execute(w, struct {
+		Path    string
+		Entries []autoindexEntry
+	}{r.URL.Path, entries}); err != nil {
+		log.Printf("[static] autoindex %s: %v", dir, err)
+	}
+}
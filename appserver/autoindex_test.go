@@ -0,0 +1,79 @@
+package appserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAutoindex(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "artifacts")
+	for _, d := range []string{"v1", "site", ".git"} {
+		if err := os.MkdirAll(filepath.Join(dir, d), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	now := time.Now()
+	for name, body := range map[string]string{
+		"small.zip":       "x",
+		"big.zip":         "xxxxxxxxxx",
+		".secret":         "x",
+		"notes.php":       "x",
+		"site/index.html": "<p>site</p>",
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(body), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		_ = os.Chtimes(path, now, now)
+	}
+	rules := []StaticRule{{Prefix: "/artifacts/", Dir: "artifacts", Autoindex: true, Extensions: []string{".zip", ".html"}}}
+	get := func(target string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		if !tryServeStatic(w, httptest.NewRequest(http.MethodGet, target, nil), root, rules) {
+			t.Fatalf("%s not served", target)
+		}
+		return w
+	}
+
+	body := get("/artifacts/").Body.String()
+	for _, want := range []string{`href="v1/"`, `href="big.zip"`, `href="small.zip"`} {
+		if !strings.Contains(body, want) {
+			t.Errorf("listing lacks %s:\n%s", want, body)
+		}
+	}
+	for _, hidden := range []string{".secret", ".git", "notes.php"} {
+		if strings.Contains(body, hidden) {
+			t.Errorf("listing shows %s", hidden)
+		}
+	}
+	// directories first, then by name
+	if !(strings.Index(body, "v1/") < strings.Index(body, "big.zip") && strings.Index(body, "big.zip") < strings.Index(body, "small.zip")) {
+		t.Errorf("wrong default order:\n%s", body)
+	}
+	body = get("/artifacts/?sort=size").Body.String()
+	if strings.Index(body, "small.zip") > strings.Index(body, "big.zip") {
+		t.Errorf("sort=size: big.zip before small.zip")
+	}
+
+	if w := get("/artifacts/v1"); w.Code != http.StatusMovedPermanently || w.Header().Get("Location") != "/artifacts/v1/" {
+		t.Errorf("no trailing slash: got %d %q", w.Code, w.Header().Get("Location"))
+	}
+	if w := get("/artifacts/site/"); w.Body.String() != "<p>site</p>" {
+		t.Errorf("index.html not served: %q", w.Body.String())
+	}
+
+	w := httptest.NewRecorder()
+	if tryServeStatic(w, httptest.NewRequest(http.MethodGet, "/artifacts/.git/", nil), root, rules) {
+		t.Error("hidden directory listed")
+	}
+	rules[0].Autoindex = false
+	if tryServeStatic(w, httptest.NewRequest(http.MethodGet, "/artifacts/", nil), root, rules) {
+		t.Error("listing without autoindex")
+	}
+}
@@ -0,0 +1,176 @@
+package appserver
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// BasicAuthRule puts requests under Prefix behind HTTP Basic auth. Users
+// come from an htpasswd file (bcrypt entries, as written by htpasswd -B)
+// and/or inline user → bcrypt hash pairs.
+type BasicAuthRule struct {
+	Prefix       string            `json:"prefix"`
+	Realm        string            `json:"realm"`
+	HtpasswdFile string            `json:"htpasswd_file"`
+	Users        map[string]string `json:"users"`
+}
+
+// maxAuthCache bounds the remembered good credentials.
+const maxAuthCache = 1024
+
+// basicAuth checks one rule's credentials. bcrypt is slow on purpose, so
+// credentials that checked out are remembered (as a hash) for later
+// requests in the same process.
+type basicAuth struct {
+	prefix string
+	realm  string
+	users  map[string][]byte // user → bcrypt hash
+
+	mu   sync.Mutex
+	good map[[32]byte]bool
+}
+
+// dummyHash keeps unknown users as slow as wrong passwords.
+var dummyHash = sync.OnceValue(func() []byte {
+	h, _ := bcrypt.GenerateFromPassword([]byte("go-php"), bcrypt.DefaultCost)
+	return h
+})
+
+func newBasicAuth(root string, rule BasicAuthRule) (*basicAuth, error) {
+	a := &basicAuth{
+		prefix: rule.Prefix,
+		realm:  rule.Realm,
+		users:  map[string][]byte{},
+		good:   map[[32]byte]bool{},
+	}
+	if a.realm == "" {
+		a.realm = "Restricted"
+	}
+	if rule.HtpasswdFile != "" {
+		path := rule.HtpasswdFile
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(root, path)
+		}
+		if err := a.loadHtpasswd(path); err != nil {
+			return nil, fmt.Errorf("basic_auth %s: %w", rule.Prefix, err)
+		}
+	}
+	for user, hash := range rule.Users {
+		if isBcrypt(hash) {
+			a.users[user] = []byte(hash)
+		}
+	}
+	return a, nil
+}
+
+func isBcrypt(hash string) bool {
+	_, err := bcrypt.Cost([]byte(hash))
+	return err == nil
+}
+
+// loadHtpasswd reads user:hash lines, skipping entries that aren't bcrypt
+// (htpasswd's MD5 and SHA1 formats are too weak to accept).
+func (a *basicAuth) loadHtpasswd(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		user, hash, ok := strings.Cut(line, ":")
+		if !ok || !isBcrypt(hash) {
+			log.Printf("[auth] %s:%d is not a bcrypt entry, skipping it", path, n)
+			continue
+		}
+		a.users[user] = []byte(hash)
+	}
+	return sc.Err()
+}
+
+func (a *basicAuth) check(user, pass string) bool {
+	key := sha256.Sum256([]byte(user + "\x00" + pass))
+	a.mu.Lock()
+	ok := a.good[key]
+	a.mu.Unlock()
+	if ok {
+		return true
+	}
+
+	hash, known := a.users[user]
+	if !known {
+		_ = bcrypt.CompareHashAndPassword(dummyHash(), []byte(pass))
+		return false
+	}
+	if bcrypt.CompareHashAndPassword(hash, []byte(pass)) != nil {
+		return false
+	}
+
+	a.mu.Lock()
+	if len(a.good) >= maxAuthCache {
+		clear(a.good)
+	}
+	a.good[key] = true
+	a.mu.Unlock()
+	return true
+}
+
+// basicAuthHandler asks for credentials on requests under a rule's prefix
+// (the longest matching one).
+func basicAuthHandler(rules []*basicAuth, next http.Handler) http.Handler {
+	sort.SliceStable(rules, func(i, j int) bool {
+		return len(rules[i].prefix) > len(rules[j].prefix)
+	})
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, a := range rules {
+			if !strings.HasPrefix(r.URL.Path, a.prefix) {
+				continue
+			}
+			user, pass, ok := r.BasicAuth()
+			if !ok || !a.check(user, pass) {
+				if ok {
+					log.Printf("[auth] bad credentials for %q from %s on %s", user, r.RemoteAddr, r.URL.Path)
+				}
+				w.Header().Set("WWW-Authenticate", fmt.Sprintf("Basic realm=%q, charset=\"UTF-8\"", a.realm))
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			break
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// validateBasicAuth reports rules that can't let anyone in and fixes
+// prefixes.
+func validateBasicAuth(cfg *AppServerConfig, warn configWarnFunc) {
+	for i, rule := range cfg.BasicAuth {
+		if !strings.HasPrefix(rule.Prefix, "/") {
+			warn(fmt.Sprintf("basic_auth[%d].prefix", i), "basic_auth[%d].prefix=%q does not start with '/', fixing", i, rule.Prefix)
+			cfg.BasicAuth[i].Prefix = "/" + rule.Prefix
+		}
+		if rule.HtpasswdFile == "" && len(rule.Users) == 0 {
+			warn(fmt.Sprintf("basic_auth[%d]", i), "basic_auth[%d] has no htpasswd_file or users; nobody can log in", i)
+		}
+		for user, hash := range rule.Users {
+			if !isBcrypt(hash) {
+				warn(fmt.Sprintf("basic_auth[%d].users.%s", i, user), "basic_auth[%d].users.%s is not a bcrypt hash, ignoring it (use htpasswd -nbB)", i, user)
+			}
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package appserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"go-php/server"
+)
+
+func TestBasicAuthPrefixes(t *testing.T) {
+	hash := func(pw string) string {
+		h, err := bcrypt.GenerateFromPassword([]byte(pw), bcrypt.MinCost)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(h)
+	}
+	root := t.TempDir()
+	htpasswd := "# staging users\nalice:" + hash("wonderland") + "\nbob:$apr1$weak$hash\n"
+	if err := os.WriteFile(filepath.Join(root, ".htpasswd"), []byte(htpasswd), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	srv, err := New(&AppServerConfig{
+		Root: root,
+		Pools: []PoolConfig{{Name: "fast", Workers: 1, Transport: "tcp", Address: fakePHP(t, func(*server.RequestPayload) *server.ResponsePayload {
+			return &server.ResponsePayload{Status: http.StatusOK, Body: []byte("php")}
+		})}},
+		BasicAuth: []BasicAuthRule{
+			{Prefix: "/", Realm: "Staging", HtpasswdFile: ".htpasswd"},
+			{Prefix: "/__baremetal/dashboard", Users: map[string]string{"ops": hash("s3cret")}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	t.Cleanup(func() { _ = srv.Shutdown(t.Context()) })
+
+	get := func(path, user, pass string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		req.RemoteAddr = "127.0.0.1:1234"
+		if user != "" {
+			req.SetBasicAuth(user, pass)
+		}
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := get("/home", "", "")
+	if rr.Code != http.StatusUnauthorized || rr.Header().Get("WWW-Authenticate") != `Basic realm="Staging", charset="UTF-8"` {
+		t.Fatalf("no credentials: %d %v", rr.Code, rr.Header())
+	}
+	for _, c := range []struct{ user, pass string }{{"alice", "nope"}, {"bob", "anything"}, {"mallory", "wonderland"}} {
+		if rr := get("/home", c.user, c.pass); rr.Code != http.StatusUnauthorized {
+			t.Fatalf("%s/%s: status %d, want 401", c.user, c.pass, rr.Code)
+		}
+	}
+	for range 2 { // the second check is served from the cache
+		if rr := get("/home", "alice", "wonderland"); rr.Code != http.StatusOK || rr.Body.String() != "php" {
+			t.Fatalf("alice: %d %q", rr.Code, rr.Body.String())
+		}
+	}
+
+	// The longest prefix wins: the dashboard has its own users.
+	if rr := get("/__baremetal/dashboard", "alice", "wonderland"); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("dashboard with staging credentials: %d", rr.Code)
+	}
+	if rr := get("/__baremetal/dashboard", "ops", "s3cret"); rr.Code != http.StatusOK {
+		t.Fatalf("dashboard with ops credentials: %d", rr.Code)
+	}
+}
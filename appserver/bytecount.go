@@ -0,0 +1,78 @@
+package appserver
+
+import (
+	"io"
+	"net/http"
+)
+
+// countingWriter notes the status and body size of a response. It keeps
+// ReadFrom, so http.ServeFile can still use sendfile, and Flush, for
+// streamed responses.
+type countingWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+	writes int // one per streamed frame that carried data
+}
+
+func (cw *countingWriter) WriteHeader(code int) {
+	if cw.status == 0 {
+		cw.status = code
+	}
+	cw.ResponseWriter.WriteHeader(code)
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+	n, err := cw.ResponseWriter.Write(p)
+	cw.bytes += int64(n)
+	cw.writes++
+	return n, err
+}
+
+func (cw *countingWriter) ReadFrom(src io.Reader) (int64, error) {
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+	var n int64
+	var err error
+	if rf, ok := cw.ResponseWriter.(io.ReaderFrom); ok {
+		n, err = rf.ReadFrom(src)
+	} else {
+		n, err = io.Copy(cw.ResponseWriter, src)
+	}
+	cw.bytes += n
+	return n, err
+}
+
+func (cw *countingWriter) Unwrap() http.ResponseWriter { return cw.ResponseWriter }
+
+func (cw *countingWriter) Flush() {
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// countingReader counts the bytes read from a request body.
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.ReadCloser.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
+
+// countBody wraps r.Body so the bytes read from it can be counted.
+func countBody(r *http.Request) *countingReader {
+	cr := &countingReader{ReadCloser: r.Body}
+	if r.Body == nil {
+		cr.ReadCloser = http.NoBody
+	}
+	r.Body = cr
+	return cr
+}
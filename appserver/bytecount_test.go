@@ -0,0 +1,57 @@
+package appserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go-php/server"
+)
+
+func TestBytesAccounting(t *testing.T) {
+	srv, err := New(&AppServerConfig{
+		Root: t.TempDir(),
+		Pools: []PoolConfig{{Name: "fast", Workers: 1, Transport: "tcp", Address: fakePHP(t, func(req *server.RequestPayload) *server.ResponsePayload {
+			return &server.ResponsePayload{Status: http.StatusOK, Body: []byte(strings.Repeat("x", 3*len(req.Body)))}
+		})}},
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	t.Cleanup(func() { _ = srv.Shutdown(t.Context()) })
+
+	var logs bytes.Buffer
+	prev := log.Writer()
+	log.SetOutput(&logs)
+	t.Cleanup(func() { log.SetOutput(prev) })
+
+	for _, body := range []string{"hello", "0123456789"} {
+		srv.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/upload", strings.NewReader(body)))
+	}
+	log.SetOutput(prev)
+
+	snap := srv.metrics.Snapshot()
+	if snap.BytesIn != 15 || snap.BytesOut != 45 {
+		t.Errorf("totals: in=%d out=%d, want 15/45", snap.BytesIn, snap.BytesOut)
+	}
+	if rm := snap.ByRoute["/upload"]; rm == nil || rm.BytesIn != 15 || rm.BytesOut != 45 {
+		t.Errorf("by_route: %+v", rm)
+	}
+	if pt := snap.ByPool["fast"]; pt == nil || pt.Requests != 2 || pt.BytesIn != 15 || pt.BytesOut != 45 {
+		t.Errorf("by_pool: %+v", snap.ByPool)
+	}
+
+	var entry RequestLog
+	for _, line := range strings.Split(logs.String(), "\n") {
+		if i := strings.Index(line, `{"time"`); i >= 0 {
+			_ = json.Unmarshal([]byte(line[i:]), &entry)
+		}
+	}
+	if entry.BytesIn != 10 || entry.BytesOut != 30 || entry.Pool != "fast" {
+		t.Errorf("request log: %+v", entry)
+	}
+}
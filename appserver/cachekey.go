@@ -0,0 +1,101 @@
+package appserver
+
+import (
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"go-php/server"
+)
+
+// CacheKeyConfig decides which parts of a request tell cached responses
+// apart, so that marketing query parameters and analytics cookies don't
+// split one page into many entries. Ignored parameters and cookies still
+// reach PHP on a miss; they mustn't change the page.
+type CacheKeyConfig struct {
+	// Vary lists request headers whose values are part of the key.
+	Vary []string `json:"vary"`
+
+	// IgnoreQuery leaves the whole query string out of the key. Otherwise
+	// QueryAllow, if set, keeps only the parameters it names, and
+	// QueryDeny drops the ones it names; both take "*" wildcards
+	// ("utm_*"). Kept parameters are sorted by name.
+	IgnoreQuery bool     `json:"ignore_query"`
+	QueryAllow  []string `json:"query_allow"`
+	QueryDeny   []string `json:"query_deny"`
+
+	// IgnoreCookies names cookies ("_ga*", "_gid") that neither stop a
+	// request from being cached nor, with Cookie in Vary, count in the key.
+	IgnoreCookies []string `json:"ignore_cookies"`
+}
+
+// key returns req's cache key and the path and query it stands for, or ""
+// if req mustn't be cached.
+func (c *responseCache) key(r *http.Request, req *server.RequestPayload) (key, uri string) {
+	k := &c.cfg.Key
+	uri = k.uri(req.Path)
+	if len(k.IgnoreCookies) > 0 && len(req.Headers["Cookie"]) > 0 {
+		kreq := *req
+		kreq.Headers = k.withoutIgnoredCookies(req.Headers)
+		req = &kreq
+	}
+	return sharedKey(r, req, uri, c.cfg.Prefixes, k.Vary), uri
+}
+
+// uri returns path with the query cut down to the parameters in the key.
+func (k *CacheKeyConfig) uri(path string) string {
+	if !k.IgnoreQuery && len(k.QueryAllow) == 0 && len(k.QueryDeny) == 0 {
+		return path
+	}
+	p, query, ok := strings.Cut(path, "?")
+	if !ok || k.IgnoreQuery {
+		return p
+	}
+	var kept []string
+	for _, param := range strings.Split(query, "&") {
+		name, _, _ := strings.Cut(param, "=")
+		if n, err := url.QueryUnescape(name); err == nil {
+			name = n
+		}
+		if param == "" || len(k.QueryAllow) > 0 && !matchesAnyWildcard(k.QueryAllow, name) || matchesAnyWildcard(k.QueryDeny, name) {
+			continue
+		}
+		kept = append(kept, param)
+	}
+	if len(kept) == 0 {
+		return p
+	}
+	slices.SortStableFunc(kept, func(a, b string) int {
+		a, _, _ = strings.Cut(a, "=")
+		b, _, _ = strings.Cut(b, "=")
+		return strings.Compare(a, b)
+	})
+	return p + "?" + strings.Join(kept, "&")
+}
+
+// withoutIgnoredCookies returns a copy of headers without the ignored
+// cookies, dropping Cookie altogether if none are left.
+func (k *CacheKeyConfig) withoutIgnoredCookies(headers map[string][]string) map[string][]string {
+	var kept []string
+	for _, line := range headers["Cookie"] {
+		for _, c := range strings.Split(line, ";") {
+			c = strings.TrimSpace(c)
+			name, _, _ := strings.Cut(c, "=")
+			if c != "" && !matchesAnyWildcard(k.IgnoreCookies, name) {
+				kept = append(kept, c)
+			}
+		}
+	}
+	out := maps.Clone(headers)
+	delete(out, "Cookie")
+	if len(kept) > 0 {
+		out["Cookie"] = []string{strings.Join(kept, "; ")}
+	}
+	return out
+}
+
+func matchesAnyWildcard(patterns []string, s string) bool {
+	return slices.ContainsFunc(patterns, func(p string) bool { return wildcardMatch(p, s) })
+}
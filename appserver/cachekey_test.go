@@ -0,0 +1,70 @@
+package appserver
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"go-php/server"
+)
+
+func TestCacheKeyQuery(t *testing.T) {
+	for _, tc := range []struct {
+		key        CacheKeyConfig
+		path, want string
+	}{
+		{CacheKeyConfig{}, "/p?b=2&a=1", "/p?b=2&a=1"},
+		{CacheKeyConfig{IgnoreQuery: true}, "/p?b=2&a=1", "/p"},
+		{CacheKeyConfig{QueryDeny: []string{"utm_*", "gclid"}}, "/p?utm_source=x&page=2&gclid=y&utm_medium=z", "/p?page=2"},
+		{CacheKeyConfig{QueryDeny: []string{"utm_*"}}, "/p?utm_source=x", "/p"},
+		{CacheKeyConfig{QueryDeny: []string{"utm_*"}}, "/p?sort=asc&q=go&q=php", "/p?q=go&q=php&sort=asc"},
+		{CacheKeyConfig{QueryAllow: []string{"page", "q"}}, "/p?q=a+b&ref=mail&page=3", "/p?page=3&q=a+b"},
+		{CacheKeyConfig{QueryAllow: []string{"page"}, QueryDeny: []string{"page"}}, "/p?page=3", "/p"},
+		{CacheKeyConfig{QueryAllow: []string{"utm source"}}, "/p?utm%20source=x&&", "/p?utm%20source=x"},
+	} {
+		if got := tc.key.uri(tc.path); got != tc.want {
+			t.Errorf("%+v: uri(%q) = %q, want %q", tc.key, tc.path, got, tc.want)
+		}
+	}
+}
+
+func TestCacheKeyScheme(t *testing.T) {
+	c := newResponseCache(ResponseCacheConfig{Enabled: true})
+	req := &server.RequestPayload{Method: "GET", Path: "/p"}
+	plain, _ := c.key(httptest.NewRequest("GET", "http://example.com/p", nil), req)
+	secure, _ := c.key(httptest.NewRequest("GET", "https://example.com/p", nil), req)
+	if plain == "" || plain == secure {
+		t.Fatalf("keys = %q, %q; want http and https apart", plain, secure)
+	}
+}
+
+func TestCacheKeyIgnoresCookies(t *testing.T) {
+	key := func(cfg CacheKeyConfig, cookie string) string {
+		c := newResponseCache(ResponseCacheConfig{Enabled: true, Key: cfg})
+		r := httptest.NewRequest("GET", "/p?utm_source=x", nil)
+		req := &server.RequestPayload{Method: "GET", Path: "/p?utm_source=x", Headers: map[string][]string{"Cookie": {cookie}}}
+		k, _ := c.key(r, req)
+		if got := req.Headers["Cookie"][0]; got != cookie {
+			t.Fatalf("key changed the request's cookies to %q", got)
+		}
+		return k
+	}
+
+	ignore := CacheKeyConfig{IgnoreCookies: []string{"_ga*", "_gid"}}
+	if k := key(CacheKeyConfig{}, "_ga=1"); k != "" {
+		t.Fatalf("key = %q for a request with cookies, want it uncached", k)
+	}
+	if a, b := key(ignore, "_ga=1; _ga_XYZ=2"), key(ignore, "_gid=3"); a == "" || a != b {
+		t.Fatalf("keys = %q, %q; want analytics cookies ignored", a, b)
+	}
+	if k := key(ignore, "_ga=1; session=abc"); k != "" {
+		t.Fatalf("key = %q with a session cookie, want it uncached", k)
+	}
+
+	ignore.Vary = []string{"Cookie"}
+	if a, b := key(ignore, "_ga=1; lang=en"), key(ignore, "lang=en; _gid=2"); a == "" || a != b {
+		t.Fatalf("keys = %q, %q; want only lang in the key", a, b)
+	}
+	if a, b := key(ignore, "lang=en"), key(ignore, "lang=fr"); a == b {
+		t.Fatalf("keys for different languages are both %q", a)
+	}
+}
@@ -0,0 +1,151 @@
+package appserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// CachePurgeRequest is the body of POST /__baremetal/cache/purge. Each
+// entry is either a path ("/blog/post?page=2", or "*.css" for wildcards),
+// which matches on every host, or an absolute URL
+// ("https://example.com/blog/post"), which matches on its host only;
+// "*.example.com" covers subdomains. Every cached variant of a matching URL
+// is dropped, as is every response tagged with one of Tags.
+type CachePurgeRequest struct {
+	URLs      []string `json:"urls"`      // exact path and query
+	Prefixes  []string `json:"prefixes"`  // path prefixes
+	Wildcards []string `json:"wildcards"` // "*" matches any run of characters
+	Tags      []string `json:"tags"`      // Surrogate-Key tags
+}
+
+// purgeMatcher is one parsed entry of a CachePurgeRequest.
+type purgeMatcher struct {
+	host    string // "" for every host
+	pattern string
+	match   func(pattern, uri string) bool
+}
+
+func (m purgeMatcher) matches(e *cachedResponse) bool {
+	return (m.host == "" || hostMatches([]string{m.host}, e.host)) && m.match(m.pattern, e.uri)
+}
+
+func (p CachePurgeRequest) matchers() ([]purgeMatcher, error) {
+	var ms []purgeMatcher
+	for _, set := range []struct {
+		urls  []string
+		match func(pattern, uri string) bool
+	}{
+		{p.URLs, func(pattern, uri string) bool { return uri == pattern }},
+		{p.Prefixes, func(pattern, uri string) bool { return strings.HasPrefix(uri, pattern) }},
+		{p.Wildcards, wildcardMatch},
+	} {
+		for _, raw := range set.urls {
+			m := purgeMatcher{pattern: raw, match: set.match}
+			switch {
+			case strings.Contains(raw, "://"):
+				u, err := url.Parse(raw)
+				if err != nil || u.Host == "" {
+					return nil, fmt.Errorf("%q is not a valid URL", raw)
+				}
+				m.host = strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+				m.pattern = u.RequestURI()
+			case !strings.HasPrefix(raw, "/") && !strings.HasPrefix(raw, "*"):
+				return nil, fmt.Errorf("%q is neither a path nor an absolute URL", raw)
+			}
+			ms = append(ms, m)
+		}
+	}
+	return ms, nil
+}
+
+// wildcardMatch reports whether s matches pattern, where "*" stands for
+// any run of characters, slashes included.
+func wildcardMatch(pattern, s string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return s == pattern
+	}
+	if !strings.HasPrefix(s, parts[0]) {
+		return false
+	}
+	s = s[len(parts[0]):]
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(s, part)
+		if i < 0 {
+			return false
+		}
+		s = s[i+len(part):]
+	}
+	return strings.HasSuffix(s, parts[len(parts)-1])
+}
+
+// purge drops every entry matching one of ms and returns how many it
+// dropped.
+func (c *responseCache) purge(ms []purgeMatcher) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for _, el := range c.entries {
+		e := el.Value.(*cachedResponse)
+		for _, m := range ms {
+			if m.matches(e) {
+				c.remove(el)
+				n++
+				break
+			}
+		}
+	}
+	return n
+}
+
+// purgeTags drops every entry tagged with one of tags and returns how many
+// it dropped.
+func (c *responseCache) purgeTags(tags []string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for _, tag := range tags {
+		for key := range c.tags[tag] {
+			c.remove(c.entries[key])
+			n++
+		}
+	}
+	return n
+}
+
+// cachePurgeHandler invalidates cached responses, e.g. from a deploy script
+// after content changes.
+func cachePurgeHandler(c *responseCache) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if c == nil {
+			http.Error(w, "response cache is disabled", http.StatusNotFound)
+			return
+		}
+		var body CachePurgeRequest
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, `expected {"urls": [...], "prefixes": [...], "wildcards": [...], "tags": [...]}`, http.StatusBadRequest)
+			return
+		}
+		ms, err := body.matchers()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if len(ms) == 0 && len(body.Tags) == 0 {
+			http.Error(w, "nothing to purge", http.StatusBadRequest)
+			return
+		}
+
+		n := c.purgeTags(body.Tags)
+		if len(ms) > 0 {
+			n += c.purge(ms)
+		}
+		log.Printf("[cache] purged %d responses", n)
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]int{"purged": n})
+	}
+}
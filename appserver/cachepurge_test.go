@@ -0,0 +1,150 @@
+package appserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"go-php/server"
+)
+
+func TestWildcardMatch(t *testing.T) {
+	for _, tc := range []struct {
+		pattern, s string
+		want       bool
+	}{
+		{"/blog/*", "/blog/a/b?page=2", true},
+		{"/blog/*/comments", "/blog/post/comments", true},
+		{"/blog/*/comments", "/blog/post/comments/2", false},
+		{"*.css", "/assets/site.css", true},
+		{"/a*b*c", "/abc", true},
+		{"/a*b*c", "/acb", false},
+		{"/exact", "/exact", true},
+		{"/exact", "/exact/", false},
+	} {
+		if got := wildcardMatch(tc.pattern, tc.s); got != tc.want {
+			t.Errorf("wildcardMatch(%q, %q) = %v, want %v", tc.pattern, tc.s, got, tc.want)
+		}
+	}
+}
+
+func TestCachePurgeHandler(t *testing.T) {
+	c := newResponseCache(ResponseCacheConfig{Enabled: true})
+	fill := func() {
+		for _, u := range []string{
+			"http://example.com/",
+			"http://example.com/blog/one",
+			"http://example.com/blog/two?page=2",
+			"http://example.com/shop/item/comments",
+			"http://shop.example.com:8080/blog/one",
+			"http://other.test/blog/one",
+		} {
+			r := httptest.NewRequest("GET", u, nil)
+			req := &server.RequestPayload{Method: "GET", Path: r.URL.RequestURI()}
+			resp := &server.ResponsePayload{Status: http.StatusOK, Headers: map[string]string{"Cache-Control": "max-age=60"}}
+			key, uri := c.key(r, req)
+			c.store(key, uri, r, resp, time.Now())
+		}
+	}
+	remaining := func() []string {
+		var got []string
+		for _, el := range c.entries {
+			e := el.Value.(*cachedResponse)
+			got = append(got, e.host+e.uri)
+		}
+		slices.Sort(got)
+		return got
+	}
+	purge := func(body string) (int, int) {
+		rr := httptest.NewRecorder()
+		cachePurgeHandler(c)(rr, httptest.NewRequest(http.MethodPost, "/__baremetal/cache/purge", strings.NewReader(body)))
+		var out struct{ Purged int }
+		_ = json.Unmarshal(rr.Body.Bytes(), &out)
+		return rr.Code, out.Purged
+	}
+
+	for _, tc := range []struct {
+		body string
+		want []string
+	}{
+		{`{"urls": ["/blog/one"]}`, []string{"example.com/", "example.com/blog/two?page=2", "example.com/shop/item/comments"}},
+		{`{"urls": ["https://example.com/blog/two"]}`, nil},
+		{`{"prefixes": ["https://*.example.com/blog/"]}`, []string{"example.com/", "example.com/blog/one", "example.com/blog/two?page=2", "example.com/shop/item/comments", "other.test/blog/one"}},
+		{`{"prefixes": ["http://example.com/blog/"]}`, []string{"example.com/", "example.com/shop/item/comments", "other.test/blog/one", "shop.example.com/blog/one"}},
+		{`{"wildcards": ["*/comments", "http://other.test/*"]}`, []string{"example.com/", "example.com/blog/one", "example.com/blog/two?page=2", "shop.example.com/blog/one"}},
+	} {
+		c.purge([]purgeMatcher{{pattern: "*", match: wildcardMatch}})
+		fill()
+		code, n := purge(tc.body)
+		if tc.want == nil {
+			// An exact URL doesn't match other queries.
+			if code != http.StatusOK || n != 0 {
+				t.Fatalf("%s = %d, purged %d; want nothing", tc.body, code, n)
+			}
+			continue
+		}
+		if got := remaining(); code != http.StatusOK || n != 6-len(tc.want) || !slices.Equal(got, tc.want) {
+			t.Fatalf("%s = %d, purged %d, left %v; want %v", tc.body, code, n, got, tc.want)
+		}
+	}
+
+	if code, _ := purge(`{}`); code != http.StatusBadRequest {
+		t.Fatalf("empty purge = %d, want 400", code)
+	}
+	if code, _ := purge(`{"urls": ["example.com/x"]}`); code != http.StatusBadRequest {
+		t.Fatalf("relative URL = %d, want 400", code)
+	}
+	rr := httptest.NewRecorder()
+	cachePurgeHandler(nil)(rr, httptest.NewRequest(http.MethodPost, "/__baremetal/cache/purge", strings.NewReader(`{"urls": ["/"]}`)))
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("disabled cache = %d, want 404", rr.Code)
+	}
+}
+
+func TestCachePurgeTags(t *testing.T) {
+	c := newResponseCache(ResponseCacheConfig{Enabled: true})
+	for path, tags := range map[string]string{
+		"/articles/123":     "article:123 section:news",
+		"/":                 "section:news  article:123 article:9",
+		"/articles/9":       "article:9",
+		"/about":            "",
+		"/articles/123.rss": "article:123",
+	} {
+		r := httptest.NewRequest("GET", path, nil)
+		req := &server.RequestPayload{Method: "GET", Path: path}
+		resp := &server.ResponsePayload{Status: http.StatusOK, Headers: map[string]string{
+			"Cache-Control": "max-age=60",
+			"surrogate-key": tags,
+		}}
+		key, uri := c.key(r, req)
+		c.store(key, uri, r, resp, time.Now())
+		if _, ok := resp.Headers["surrogate-key"]; ok {
+			t.Fatalf("Surrogate-Key left on the response to %s", path)
+		}
+	}
+
+	rr := httptest.NewRecorder()
+	cachePurgeHandler(c)(rr, httptest.NewRequest(http.MethodPost, "/__baremetal/cache/purge", strings.NewReader(`{"tags": ["article:123", "unknown"]}`)))
+	if rr.Code != http.StatusOK || strings.TrimSpace(rr.Body.String()) != `{"purged":3}` {
+		t.Fatalf("purge = %d %s, want 3 purged", rr.Code, rr.Body)
+	}
+	if c.lookup("GET http://example.com /articles/9") == nil || c.lookup("GET http://example.com /about") == nil || c.lookup("GET http://example.com /") != nil {
+		t.Fatal("purged the wrong entries")
+	}
+	if _, ok := c.tags["section:news"]; ok || len(c.tags["article:9"]) != 1 {
+		t.Fatalf("tag index = %v, want only article:9 left", c.tags)
+	}
+
+	// Replacing an entry drops its old tags.
+	r := httptest.NewRequest("GET", "/articles/9", nil)
+	req := &server.RequestPayload{Method: "GET", Path: "/articles/9"}
+	key, uri := c.key(r, req)
+	c.store(key, uri, r, &server.ResponsePayload{Status: http.StatusOK, Headers: map[string]string{"Cache-Control": "max-age=60"}}, time.Now())
+	if len(c.tags) != 0 {
+		t.Fatalf("tag index = %v after the entry lost its tags", c.tags)
+	}
+}
@@ -0,0 +1,43 @@
+package appserver
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// canaryHandler shows or shifts an app's canary split (?app= selects the app):
+//
+//	GET                   current {"stable", "canary", "weight"}
+//	POST {"weight": 25}   send 25% of the stable pool's traffic to the canary
+func canaryHandler(vhosts *vhostRouter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		srv := vhosts.byName(r.URL.Query().Get("app")).srv
+
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost:
+			var body struct {
+				Weight *int `json:"weight"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Weight == nil {
+				http.Error(w, "expected {\"weight\": 0-100}", http.StatusBadRequest)
+				return
+			}
+			if err := srv.SetCanaryWeight(*body.Weight); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		c, ok := srv.Canary()
+		if !ok {
+			http.Error(w, "no canary configured", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(c)
+	}
+}
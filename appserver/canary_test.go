@@ -0,0 +1,67 @@
+package appserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go-php/server"
+)
+
+// newCanaryTestServer builds stable/canary pools without spawning PHP.
+func newCanaryTestServer(t *testing.T) *server.Server {
+	t.Helper()
+	pools := []server.PoolConfig{
+		{Name: "stable", Workers: 1, PHPBinary: "/nonexistent/php"},
+		{Name: "canary", Workers: 1, PHPBinary: "/nonexistent/php"},
+	}
+	srv, err := server.NewDegradedServer(pools, nil, server.SlowRequestConfig{}, time.Hour)
+	if err != nil {
+		t.Fatalf("NewDegradedServer: %v", err)
+	}
+	t.Cleanup(srv.DrainWorkers)
+	return srv
+}
+
+func TestCanaryHandlerShiftsWeight(t *testing.T) {
+	srv := newCanaryTestServer(t)
+	if err := srv.SetCanary(server.Canary{Stable: "stable", Canary: "canary", Weight: 5}); err != nil {
+		t.Fatalf("SetCanary: %v", err)
+	}
+	h := canaryHandler(&vhostRouter{def: &vhost{name: "default", srv: srv}})
+
+	req := httptest.NewRequest(http.MethodPost, "/__baremetal/canary", strings.NewReader(`{"weight": 40}`))
+	rr := httptest.NewRecorder()
+	h(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	var got server.Canary
+	if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if got.Weight != 40 || got.Stable != "stable" || got.Canary != "canary" {
+		t.Fatalf("unexpected canary: %+v", got)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/__baremetal/canary", strings.NewReader(`{"weight": 150}`))
+	rr = httptest.NewRecorder()
+	h(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for out-of-range weight, got %d", rr.Code)
+	}
+}
+
+func TestCanaryHandlerWithoutCanary(t *testing.T) {
+	h := canaryHandler(&vhostRouter{def: &vhost{name: "default", srv: newCanaryTestServer(t)}})
+
+	rr := httptest.NewRecorder()
+	h(rr, httptest.NewRequest(http.MethodGet, "/__baremetal/canary", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("expected 404, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,205 @@
+package appserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"go-php/server"
+)
+
+// CaptureConfig writes a sample of PHP requests, bodies included, to Dir
+// so they can be fed back to a worker with "server replay". Files hold
+// whatever the client sent, cookies and tokens too, and are written 0600.
+type CaptureConfig struct {
+	Dir        string   `json:"dir"`
+	Percent    int      `json:"percent"`     // 1-100 (default 100)
+	ErrorsOnly bool     `json:"errors_only"` // only worker errors and 5xx
+	Prefixes   []string `json:"prefixes"`    // default: every path
+	MaxFiles   int      `json:"max_files"`   // the oldest are deleted (default 1000)
+}
+
+const defaultCaptureMaxFiles = 1000
+
+// CapturedRequest is one file written by capture.
+type CapturedRequest struct {
+	Time    time.Time              `json:"time"`
+	App     string                 `json:"app,omitempty"`
+	Pool    string                 `json:"pool,omitempty"`
+	Status  int                    `json:"status,omitempty"`
+	Error   string                 `json:"error,omitempty"`
+	Payload *server.RequestPayload `json:"payload"`
+}
+
+// captureQueue is how many encoded requests may wait for the disk before
+// new ones are dropped.
+const captureQueue = 64
+
+// capturer writes requests as Hooks. Encoding happens on the request's
+// goroutine, since hooks mustn't keep payloads; writing doesn't.
+type capturer struct {
+	NopHooks
+	cfg   CaptureConfig
+	queue chan capturedFile
+	stop  chan struct{}
+	done  chan struct{}
+	files []string // written, oldest first; used by the writer only
+}
+
+type capturedFile struct {
+	name string
+	data []byte
+}
+
+func newCapturer(root string, cfg CaptureConfig) (*capturer, error) {
+	if !filepath.IsAbs(cfg.Dir) {
+		cfg.Dir = filepath.Join(root, cfg.Dir)
+	}
+	if err := os.MkdirAll(cfg.Dir, 0o700); err != nil {
+		return nil, fmt.Errorf("capture: %w", err)
+	}
+	c := &capturer{cfg: cfg, queue: make(chan capturedFile, captureQueue), stop: make(chan struct{}), done: make(chan struct{})}
+	// pick up after a restart, so max_files holds across runs
+	if names, err := filepath.Glob(filepath.Join(cfg.Dir, "*.json")); err == nil {
+		slices.Sort(names)
+		c.files = names
+	}
+	go c.write()
+	return c, nil
+}
+
+func (c *capturer) OnResponse(ev ResponseEvent) {
+	c.capture(ev.App, ev.Payload, ev.Status, nil)
+}
+
+func (c *capturer) OnWorkerError(ev WorkerErrorEvent) {
+	c.capture(ev.App, ev.Payload, ev.Status, ev.Err)
+}
+
+func (c *capturer) capture(app string, payload *server.RequestPayload, status int, err error) {
+	if c.cfg.ErrorsOnly && err == nil && status < 500 {
+		return
+	}
+	if len(c.cfg.Prefixes) > 0 && !slices.ContainsFunc(c.cfg.Prefixes, func(p string) bool { return strings.HasPrefix(payload.Path, p) }) {
+		return
+	}
+	if c.cfg.Percent < 100 && rand.IntN(100) >= c.cfg.Percent {
+		return
+	}
+
+	rec := CapturedRequest{Time: time.Now(), App: app, Pool: payload.Pool(), Status: status, Payload: payload}
+	if err != nil {
+		rec.Error = err.Error()
+	}
+	data, mErr := json.MarshalIndent(rec, "", "  ")
+	if mErr != nil {
+		log.Printf("[capture] %s: %v", payload.ID, mErr)
+		return
+	}
+	name := rec.Time.UTC().Format("20060102T150405.000000000") + "-" + filepath.Base(payload.ID) + ".json"
+	select {
+	case <-c.stop:
+	case c.queue <- capturedFile{name: filepath.Join(c.cfg.Dir, name), data: data}:
+	default:
+		// the disk can't keep up; dropping beats slowing requests down
+	}
+}
+
+func (c *capturer) write() {
+	defer close(c.done)
+	for {
+		select {
+		case f := <-c.queue:
+			c.writeFile(f)
+		case <-c.stop:
+			for {
+				select {
+				case f := <-c.queue:
+					c.writeFile(f)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (c *capturer) writeFile(f capturedFile) {
+	if err := os.WriteFile(f.name, f.data, 0o600); err != nil {
+		log.Printf("[capture] %v", err)
+		return
+	}
+	c.files = append(c.files, f.name)
+	for len(c.files) > c.cfg.MaxFiles {
+		_ = os.Remove(c.files[0])
+		c.files = c.files[1:]
+	}
+}
+
+// close writes out what is queued. Requests still finishing afterwards
+// aren't captured.
+func (c *capturer) close() {
+	close(c.stop)
+	<-c.done
+}
+
+// ReadCapture reads a file written by capture.
+func ReadCapture(path string) (*CapturedRequest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var rec CapturedRequest
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if rec.Payload == nil {
+		return nil, fmt.Errorf("%s: no payload", path)
+	}
+	return &rec, nil
+}
+
+// Replay sends a captured request to app's PHP workers: to pool if it is
+// set, else to whichever pool the routes pick. Hooks, metrics and dispatch
+// middleware are bypassed.
+func (s *Server) Replay(app, pool string, req *server.RequestPayload) (*server.ResponsePayload, error) {
+	v := s.vhosts.byName(app)
+	if pool == "" {
+		return v.srv.Dispatch(req)
+	}
+	p := v.srv.Pool(pool)
+	if p == nil {
+		return nil, errors.New("unknown pool " + pool)
+	}
+	return p.Dispatch(req)
+}
+
+// validateCapture fills in defaults.
+func validateCapture(cfg *AppServerConfig, warn configWarnFunc) {
+	c := cfg.Capture
+	if c == nil {
+		return
+	}
+	if c.Dir == "" {
+		warn("capture.dir", "capture.dir is empty; capture disabled")
+		cfg.Capture = nil
+		return
+	}
+	if c.Percent == 0 {
+		c.Percent = 100
+	}
+	if c.Percent < 0 || c.Percent > 100 {
+		warn("capture.percent", "capture.percent=%d is not 1-100; using 100", c.Percent)
+		c.Percent = 100
+	}
+	if c.MaxFiles <= 0 {
+		c.MaxFiles = defaultCaptureMaxFiles
+	}
+}
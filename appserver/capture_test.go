@@ -0,0 +1,73 @@
+package appserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go-php/server"
+)
+
+func TestCaptureAndReplay(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "captures")
+	bodies := make(chan string, 10)
+	addr := fakePHP(t, func(req *server.RequestPayload) *server.ResponsePayload {
+		bodies <- string(req.Body)
+		if req.Path == "/crash" {
+			return &server.ResponsePayload{Status: http.StatusInternalServerError, Body: []byte("boom")}
+		}
+		return &server.ResponsePayload{Status: http.StatusOK, Body: []byte("ok")}
+	})
+
+	srv, err := New(&AppServerConfig{
+		Root:    t.TempDir(),
+		Pools:   []PoolConfig{{Name: "fast", Workers: 1, Transport: "tcp", Address: addr}},
+		Capture: &CaptureConfig{Dir: dir, ErrorsOnly: true, MaxFiles: 2},
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	for _, p := range []string{"/fine", "/crash", "/crash", "/crash"} {
+		srv.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", p, strings.NewReader("order=42")))
+		<-bodies
+	}
+	_ = srv.Shutdown(t.Context()) // writes out the queue
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+	if len(files) != 2 {
+		t.Fatalf("captured %d files, want max_files=2 of the 3 failures", len(files))
+	}
+	if info, err := os.Stat(files[0]); err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("capture file mode: %v %v", info.Mode(), err)
+	}
+	rec, err := ReadCapture(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Status != http.StatusInternalServerError || rec.Pool != "fast" || rec.Payload.Path != "/crash" || string(rec.Payload.Body) != "order=42" {
+		t.Fatalf("captured %+v, payload %+v", rec, rec.Payload)
+	}
+
+	// replay against a fresh server, as "server replay" does
+	replay, err := New(&AppServerConfig{
+		Root:  t.TempDir(),
+		Pools: []PoolConfig{{Name: "fast", Workers: 1, Transport: "tcp", Address: addr}},
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	t.Cleanup(func() { _ = replay.Shutdown(t.Context()) })
+	resp, err := replay.Replay(rec.App, "fast", rec.Payload)
+	if err != nil || resp.Status != http.StatusInternalServerError {
+		t.Fatalf("replay = %+v, %v", resp, err)
+	}
+	if got := <-bodies; got != "order=42" {
+		t.Errorf("replayed body = %q", got)
+	}
+	if _, err := replay.Replay("", "nope", rec.Payload); err == nil {
+		t.Error("replay to an unknown pool succeeded")
+	}
+}
@@ -0,0 +1,25 @@
+package appserver
+
+import (
+	"os"
+	"strings"
+)
+
+// chaosAllowed reports whether fault injection may run here. It never does
+// where APP_ENV (as Laravel and Symfony set it) says production.
+func chaosAllowed() bool {
+	switch strings.ToLower(os.Getenv("APP_ENV")) {
+	case "production", "prod":
+		return false
+	}
+	return true
+}
+
+// validateChaos turns chaos off in production. Rates are checked when
+// they are applied.
+func validateChaos(cfg *AppServerConfig, warn configWarnFunc) {
+	if cfg.Chaos != nil && !chaosAllowed() {
+		warn("chaos", "chaos is set but APP_ENV=%s; not injecting faults in production", os.Getenv("APP_ENV"))
+		cfg.Chaos = nil
+	}
+}
@@ -0,0 +1,37 @@
+package appserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go-php/server"
+)
+
+func TestChaosConfig(t *testing.T) {
+	addr := fakePHP(t, func(req *server.RequestPayload) *server.ResponsePayload {
+		return &server.ResponsePayload{Status: http.StatusOK, Body: []byte("ok")}
+	})
+	get := func(env string) int {
+		t.Setenv("APP_ENV", env)
+		srv, err := New(&AppServerConfig{
+			Root:  t.TempDir(),
+			Pools: []PoolConfig{{Name: "fast", Workers: 1, Transport: "tcp", Address: addr}},
+			Chaos: &server.Chaos{BrokenPipePercent: 100},
+		})
+		if err != nil {
+			t.Fatalf("New: %v", err)
+		}
+		defer func() { _ = srv.Shutdown(t.Context()) }()
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+		return rr.Code
+	}
+
+	if code := get("staging"); code != http.StatusBadGateway {
+		t.Errorf("staging: got %d, want an injected broken pipe's 502", code)
+	}
+	if code := get("production"); code != http.StatusOK {
+		t.Errorf("production: got %d, want chaos refused", code)
+	}
+}
@@ -0,0 +1,182 @@
+package appserver
+
+import (
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+
+	"go-php/server"
+)
+
+// CoalesceConfig sends identical GET and HEAD requests arriving while one
+// is already at PHP to that one dispatch, and hands its response to all of
+// them. It cuts the stampede on an uncached page after a deploy or cache
+// flush. Requests with an Authorization header, or a Cookie header not
+// listed in Vary, are never coalesced.
+type CoalesceConfig struct {
+	Enabled  bool     `json:"enabled"`
+	Prefixes []string `json:"prefixes"` // default: every path
+	// Vary lists request headers whose values are part of the key, e.g.
+	// Accept-Encoding or Accept-Language.
+	Vary []string `json:"vary"`
+	// MaxBodyBytes is the largest response shared (default 1 MiB). Waiters
+	// on a larger one dispatch on their own.
+	MaxBodyBytes int `json:"max_body_bytes"`
+}
+
+// coalescedCall is a dispatch in flight that later requests wait on.
+type coalescedCall struct {
+	done   chan struct{}
+	resp   *server.ResponsePayload
+	err    error
+	shared bool // resp may be handed to waiters
+}
+
+type coalescer struct {
+	cfg CoalesceConfig
+
+	mu    sync.Mutex
+	calls map[string]*coalescedCall
+}
+
+func newCoalescer(cfg CoalesceConfig) *coalescer {
+	if cfg.MaxBodyBytes <= 0 {
+		cfg.MaxBodyBytes = 1 << 20
+	}
+	return &coalescer{cfg: cfg, calls: map[string]*coalescedCall{}}
+}
+
+// key returns the coalescing key for req, or "" if it mustn't be shared.
+func (c *coalescer) key(r *http.Request, req *server.RequestPayload) string {
+	return sharedKey(r, req, req.Path, c.cfg.Prefixes, c.cfg.Vary)
+}
+
+// sharedKey returns the key under which req's response may be shared with
+// identical requests for uri, or "" if it mustn't be: only GET and HEAD
+// requests under prefixes (if any), without credentials, are shared.
+func sharedKey(r *http.Request, req *server.RequestPayload, uri string, prefixes, vary []string) string {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return ""
+	}
+	if len(prefixes) > 0 && !slices.ContainsFunc(prefixes, func(p string) bool { return strings.HasPrefix(r.URL.Path, p) }) {
+		return ""
+	}
+	h := http.Header(req.Headers)
+	if h.Get("Authorization") != "" || h.Get("X-Go-Stream") == "1" {
+		return ""
+	}
+	if h.Get("Cookie") != "" && !slices.ContainsFunc(vary, func(v string) bool { return strings.EqualFold(v, "Cookie") }) {
+		return ""
+	}
+
+	var b strings.Builder
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	b.WriteString(req.Method + " " + scheme + "://" + r.Host + " " + uri)
+	for _, v := range vary {
+		b.WriteString("\x00" + strings.Join(h.Values(v), ","))
+	}
+	return b.String()
+}
+
+// shareable reports whether resp may go to clients other than the one
+// whose request produced it.
+func (c *coalescer) shareable(resp *server.ResponsePayload) bool {
+	return sharedResponse(resp, c.cfg.MaxBodyBytes, c.cfg.Vary)
+}
+
+// sharedResponse reports whether resp, up to maxBody bytes long, may go to
+// clients other than the one whose request produced it, when requests are
+// keyed by the vary headers. A response that varies on anything else
+// (or on "*") might not suit the other clients.
+func sharedResponse(resp *server.ResponsePayload, maxBody int, vary []string) bool {
+	if resp == nil || resp.Relayed() || len(resp.Cookies) > 0 || len(resp.Body) > maxBody {
+		return false
+	}
+	for k, v := range resp.Headers {
+		if strings.EqualFold(k, "Set-Cookie") {
+			return false
+		}
+		if strings.EqualFold(k, "Vary") {
+			for _, h := range strings.Split(v, ",") {
+				h = strings.TrimSpace(h)
+				if h == "*" || h != "" && !slices.ContainsFunc(vary, func(v string) bool { return strings.EqualFold(v, h) }) {
+					return false
+				}
+			}
+		}
+		if strings.EqualFold(k, "Cache-Control") {
+			v = strings.ToLower(v)
+			if strings.Contains(v, "private") || strings.Contains(v, "no-store") {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// middleware coalesces matching requests around the hand-off to PHP.
+func (c *coalescer) middleware(next DispatchFunc) DispatchFunc {
+	return func(r *http.Request, req *server.RequestPayload) (*server.ResponsePayload, error) {
+		key := c.key(r, req)
+		if key == "" {
+			return next(r, req)
+		}
+
+		c.mu.Lock()
+		if call, ok := c.calls[key]; ok {
+			c.mu.Unlock()
+			<-call.done
+			if !call.shared {
+				return next(r, req)
+			}
+			if call.err != nil {
+				return nil, call.err
+			}
+			return copyResponse(call.resp, req.ID), nil
+		}
+		call := &coalescedCall{done: make(chan struct{})}
+		c.calls[key] = call
+		c.mu.Unlock()
+
+		defer func() {
+			c.mu.Lock()
+			delete(c.calls, key)
+			c.mu.Unlock()
+			close(call.done)
+		}()
+		resp, err := next(r, req)
+		// Worker errors (overload, timeouts) are shared too: retrying them
+		// all at once is the stampede this is here to stop.
+		call.err = err
+		call.shared = err != nil || c.shareable(resp)
+		if err == nil && call.shared {
+			// The leader's copy may be changed on its way out.
+			call.resp = copyResponse(resp, resp.ID)
+		}
+		return resp, err
+	}
+}
+
+// copyResponse returns a copy of resp for the request with the given ID.
+// The copies share one Body: nothing writes into a response body in place,
+// code that changes it (ESI, spooling) assigns a new one.
+func copyResponse(resp *server.ResponsePayload, id string) *server.ResponsePayload {
+	cp := *resp
+	cp.ID = id
+	cp.Headers = maps.Clone(resp.Headers)
+	return &cp
+}
+
+// validateCoalesce reports invalid coalescing settings.
+func validateCoalesce(cfg *AppServerConfig, warn configWarnFunc) {
+	c := &cfg.Coalesce
+	if c.MaxBodyBytes < 0 {
+		warn("coalesce.max_body_bytes", "coalesce.max_body_bytes=%d is invalid, using 1 MiB", c.MaxBodyBytes)
+		c.MaxBodyBytes = 0
+	}
+}
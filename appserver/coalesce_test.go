@@ -0,0 +1,82 @@
+package appserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go-php/server"
+)
+
+func TestCoalesceSharesOneDispatch(t *testing.T) {
+	var calls atomic.Int32
+	release := make(chan struct{})
+	srv, err := New(&AppServerConfig{
+		Root: t.TempDir(),
+		Pools: []PoolConfig{{Name: "fast", Workers: 4, Transport: "tcp", Address: fakePHP(t, func(req *server.RequestPayload) *server.ResponsePayload {
+			calls.Add(1)
+			<-release
+			resp := &server.ResponsePayload{Status: http.StatusOK, Headers: map[string]string{"Content-Type": "text/plain"}, Body: []byte("page " + req.Path)}
+			switch req.Path {
+			case "/personal":
+				resp.Cookies = []string{"seen=1"}
+			case "/negotiated":
+				resp.Headers["Vary"] = "Accept-Encoding"
+			}
+			return resp
+		})}},
+		Coalesce: CoalesceConfig{Enabled: true},
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	t.Cleanup(func() { _ = srv.Shutdown(t.Context()) })
+
+	burst := func(path string, n int, cookie string) []*httptest.ResponseRecorder {
+		calls.Store(0)
+		release = make(chan struct{})
+		rrs := make([]*httptest.ResponseRecorder, n)
+		var wg sync.WaitGroup
+		for i := range rrs {
+			rrs[i] = httptest.NewRecorder()
+			wg.Go(func() {
+				req := httptest.NewRequest("GET", path, nil)
+				if cookie != "" {
+					req.Header.Set("Cookie", cookie)
+				}
+				srv.ServeHTTP(rrs[i], req)
+			})
+		}
+		time.Sleep(100 * time.Millisecond) // let them all queue behind the first
+		close(release)
+		wg.Wait()
+		return rrs
+	}
+
+	for i, rr := range burst("/home?x=1", 8, "") {
+		if rr.Code != http.StatusOK || rr.Body.String() != "page /home?x=1" {
+			t.Errorf("response %d: %d %q", i, rr.Code, rr.Body.String())
+		}
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("coalesced burst reached PHP %d times, want 1", n)
+	}
+
+	// A response that sets a cookie isn't handed to other clients, and
+	// requests with cookies aren't coalesced at all.
+	burst("/personal", 3, "")
+	if n := calls.Load(); n != 3 {
+		t.Errorf("Set-Cookie response: PHP saw %d requests, want 3", n)
+	}
+	burst("/negotiated", 3, "")
+	if n := calls.Load(); n != 3 {
+		t.Errorf("Vary outside the key: PHP saw %d requests, want 3", n)
+	}
+	burst("/home", 3, "session=abc")
+	if n := calls.Load(); n != 3 {
+		t.Errorf("requests with cookies: PHP saw %d requests, want 3", n)
+	}
+}
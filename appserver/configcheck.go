@@ -0,0 +1,65 @@
+package appserver
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+)
+
+// configWarnFunc reports a config problem that normalizing patched over,
+// such as an invalid value replaced by its default, under the path of the
+// offending key.
+type configWarnFunc func(path, format string, args ...any)
+
+// configReport receives what normalizing a config finds: problems, and
+// notes about defaults filled in for unset keys.
+type configReport struct {
+	warn configWarnFunc
+	note func(format string, args ...any)
+}
+
+// logConfig writes both to the log, as LoadConfig and reloads do.
+var logConfig = configReport{
+	warn: func(_, format string, args ...any) { log.Printf("[config] "+format, args...) },
+	note: log.Printf,
+}
+
+// quietConfig drops both.
+var quietConfig = configReport{
+	warn: func(string, string, ...any) {},
+	note: func(string, ...any) {},
+}
+
+// ConfigError is one problem found in a config file.
+type ConfigError struct {
+	Path    string `json:"path,omitempty"` // e.g. "pools[1].workers"; empty for syntax errors
+	Message string `json:"message"`
+}
+
+// CheckConfig loads the config at path and returns every problem with it:
+// syntax errors, unknown keys and the invalid values LoadConfig would
+// patch over.
+func CheckConfig(path string) []ConfigError {
+	data, err := readConfigJSON(path)
+	if err != nil {
+		return []ConfigError{{Message: err.Error()}}
+	}
+	problems := unknownKeys(data)
+
+	rep := quietConfig
+	rep.warn = func(path, format string, args ...any) {
+		problems = append(problems, ConfigError{Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+	cfg, err := parseConfig(data, rep)
+	if err != nil {
+		return append(problems, ConfigError{Message: err.Error()})
+	}
+	loadErrorPages(filepath.Dir(path), cfg.ErrorPages, rep.warn)
+	return problems
+}
+
+// ReadConfig reads the config file at path without reporting the problems
+// it patches over; use CheckConfig to list those.
+func ReadConfig(path string) (*AppServerConfig, error) {
+	return readConfig(path, quietConfig)
+}
@@ -0,0 +1,75 @@
+package appserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckConfigUnknownKeys(t *testing.T) {
+	tmp := t.TempDir()
+	path := filepath.Join(tmp, "go_appserver.json")
+	config := `{
+		"$schema": "./go_appserver.schema.json",
+		"fast_worker": 4,
+		"Slow_Workers": 1,
+		"pools": [{"name": "fast", "wrokers": 2}],
+		"apps": [{"name": "blog", "hosts": ["blog.test"], "root": "/srv/blog", "pools": [{"name": "fast", "workers": -2}]}]
+	}`
+	if err := os.WriteFile(path, []byte(config), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	got := map[string]string{}
+	for _, p := range CheckConfig(path) {
+		got[p.Path] = p.Message
+	}
+	log.Print("after")
+	if strings.Contains(logged.String(), "[config]") || !strings.Contains(logged.String(), "after") {
+		t.Errorf("CheckConfig touched the log: %q", logged.String())
+	}
+	if msg := got["fast_worker"]; !strings.Contains(msg, `did you mean "fast_workers"`) {
+		t.Errorf("fast_worker: %q", msg)
+	}
+	if msg := got["pools[0].wrokers"]; !strings.Contains(msg, "unknown key") {
+		t.Errorf("pools[0].wrokers: %q", msg)
+	}
+	if msg, ok := got["apps[0].pools[0].workers"]; !ok || !strings.Contains(msg, "blog") {
+		t.Errorf("apps[0].pools[0].workers: %q (all: %v)", msg, got)
+	}
+	if _, ok := got["Slow_Workers"]; ok {
+		t.Errorf("keys are matched case-insensitively, like encoding/json does")
+	}
+	if len(got) != 3 {
+		t.Errorf("got %d problems, want 3: %v", len(got), got)
+	}
+}
+
+func TestConfigSchemaCoversConfig(t *testing.T) {
+	// Everything the server itself writes must be accepted by both the
+	// unknown-key check and the schema.
+	data, err := json.Marshal(DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if problems := unknownKeys(data); len(problems) > 0 {
+		t.Fatalf("default config has unknown keys: %v", problems)
+	}
+
+	var doc map[string]any
+	_ = json.Unmarshal(data, &doc)
+	props := Schema()["properties"].(map[string]any)
+	for k := range doc {
+		if _, ok := props[k]; !ok {
+			t.Errorf("schema is missing %q", k)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package appserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// envRef matches ${VAR}, ${VAR:-default} and the $$ escape for a literal $.
+var envRef = regexp.MustCompile(`\$\$|\$\{([A-Za-z_][A-Za-z0-9_]*)(?::-([^}]*))?\}`)
+
+// expandEnv replaces ${VAR} references in a config file with values from
+// the environment. ${VAR:-default} uses default when VAR is unset or empty;
+// a plain ${VAR} that is unset is an error rather than a silent "". Values
+// are inserted as text, so `"fast_workers": ${WORKERS:-4}` yields a number.
+// In JSON files they are escaped so quotes and backslashes stay inside the
+// string they were written in. YAML and TOML comments are left alone, so
+// a commented-out ${VAR} doesn't have to be set.
+func expandEnv(path string, data []byte, lookup func(string) (string, bool)) ([]byte, error) {
+	escape := func(s string) string { return s }
+	comments := false
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		escape = jsonEscape
+	case ".yaml", ".yml", ".toml":
+		comments = true
+	}
+
+	var missing []string
+	expand := func(b []byte) []byte {
+		return envRef.ReplaceAllFunc(b, func(ref []byte) []byte {
+			if string(ref) == "$$" {
+				return []byte("$")
+			}
+			m := envRef.FindSubmatch(ref)
+			name, hasDefault := string(m[1]), strings.Contains(string(ref), ":-")
+			val, ok := lookup(name)
+			switch {
+			case val != "":
+			case hasDefault:
+				val = string(m[2])
+			case !ok:
+				missing = append(missing, name)
+			}
+			return []byte(escape(val))
+		})
+	}
+
+	var out []byte
+	if !comments {
+		out = expand(data)
+	} else {
+		for _, line := range bytes.SplitAfter(data, []byte("\n")) {
+			i := commentStart(line)
+			out = append(append(out, expand(line[:i])...), line[i:]...)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("environment variable %s is not set (use ${%s:-default} to allow that)", strings.Join(missing, ", "), missing[0])
+	}
+	return out, nil
+}
+
+// commentStart returns where the # comment on a YAML or TOML line starts,
+// or len(line). A # inside a quoted string, or (as YAML has it) straight
+// after other text as in "http://host/#top", doesn't start one.
+func commentStart(line []byte) int {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		tokenStart := i == 0 || strings.IndexByte(" \t:=,[{", line[i-1]) >= 0
+		switch {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case (c == '"' || c == '\'') && tokenStart:
+			quote = c
+		case c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return i
+		}
+	}
+	return len(line)
+}
+
+// jsonEscape escapes s for use inside a JSON string, without the quotes.
+func jsonEscape(s string) string {
+	b, _ := json.Marshal(s)
+	return string(b[1 : len(b)-1])
+}
@@ -0,0 +1,71 @@
+package appserver
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExpandEnv(t *testing.T) {
+	env := map[string]string{"PORT": "9090", "EMPTY": "", "DIR": `C:\php "x"`}
+	lookup := func(k string) (string, bool) { v, ok := env[k]; return v, ok }
+
+	cases := []struct{ in, want string }{
+		{`{"listen": ":${PORT}"}`, `{"listen": ":9090"}`},
+		{`{"listen": ":${PORT:-8080}"}`, `{"listen": ":9090"}`},
+		{`{"fast_workers": ${WORKERS:-4}}`, `{"fast_workers": 4}`},
+		{`{"x": "${EMPTY:-fallback}"}`, `{"x": "fallback"}`},
+		{`{"x": "${EMPTY}"}`, `{"x": ""}`},
+		{`{"x": "$${PORT}"}`, `{"x": "${PORT}"}`},
+		{`{"dir": "${DIR}"}`, `{"dir": "C:\\php \"x\""}`},
+	}
+	for _, c := range cases {
+		got, err := expandEnv("go_appserver.json", []byte(c.in), lookup)
+		if err != nil {
+			t.Fatalf("expandEnv(%s): %v", c.in, err)
+		}
+		if string(got) != c.want {
+			t.Errorf("expandEnv(%s) = %s, want %s", c.in, got, c.want)
+		}
+	}
+
+	if got, _ := expandEnv("go_appserver.yaml", []byte(`dir: '${DIR}'`), lookup); string(got) != `dir: 'C:\php "x"'` {
+		t.Errorf("yaml values should be inserted verbatim, got %s", got)
+	}
+
+	for path, in := range map[string]string{
+		"go_appserver.yaml": "# listen: ${NOPE}\nlisten: \":${PORT}\" # was ${NOPE}\nurl: http://x/#${PORT} 'a # ${PORT}'\n",
+		"go_appserver.toml": "listen = ':${PORT}' # ${NOPE}\nname = \"a # ${PORT}\"\n",
+	} {
+		got, err := expandEnv(path, []byte(in), lookup)
+		if err != nil {
+			t.Fatalf("%s: a reference in a comment must not be expanded: %v", path, err)
+		}
+		if strings.Contains(string(got), "${PORT}") || !strings.Contains(string(got), "${NOPE}") {
+			t.Errorf("%s: expanded to %q", path, got)
+		}
+	}
+
+	_, err := expandEnv("go_appserver.json", []byte(`{"listen": ":${NOPE}"}`), lookup)
+	if err == nil || !strings.Contains(err.Error(), "NOPE") {
+		t.Fatalf("unset variable without default: err = %v", err)
+	}
+}
+
+func TestLoadConfigExpandsEnv(t *testing.T) {
+	t.Setenv("APP_FAST_WORKERS", "7")
+	tmp := t.TempDir()
+	data := `{"fast_workers": ${APP_FAST_WORKERS}, "static": [{"prefix": "/assets/", "dir": "${ASSET_DIR:-public/assets}"}]}`
+	if err := os.WriteFile(filepath.Join(tmp, "go_appserver.json"), []byte(data), 0o644); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+
+	cfg := LoadConfig(tmp)
+	if cfg.FastWorkers != 7 {
+		t.Fatalf("FastWorkers = %d, want 7", cfg.FastWorkers)
+	}
+	if len(cfg.Static) != 1 || cfg.Static[0].Dir != "public/assets" {
+		t.Fatalf("Static = %+v", cfg.Static)
+	}
+}
@@ -0,0 +1,106 @@
+package appserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// configNames are the config files LoadConfig looks for, in order of
+// preference. All formats share the JSON field names.
+var configNames = []string{
+	"go_appserver.json",
+	"go_appserver.yaml",
+	"go_appserver.yml",
+	"go_appserver.toml",
+}
+
+func isConfigName(name string) bool {
+	for _, n := range configNames {
+		if name == n {
+			return true
+		}
+	}
+	return false
+}
+
+// configJSON converts a YAML or TOML config file to JSON, picking the
+// format by extension. JSON files are returned unchanged.
+func configJSON(path string, data []byte) ([]byte, error) {
+	var doc any
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return nil, err
+		}
+	case ".toml":
+		var m map[string]any
+		if err := toml.Unmarshal(data, &m); err != nil {
+			return nil, err
+		}
+		doc = m
+	default:
+		return data, nil
+	}
+	if doc == nil { // empty file
+		return []byte("{}"), nil
+	}
+	return json.Marshal(jsonCompatible(doc))
+}
+
+// jsonCompatible turns the map[any]any YAML produces for non-string keys
+// (e.g. error_pages: {404: ...}) into something encoding/json accepts.
+func jsonCompatible(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, e := range v {
+			v[k] = jsonCompatible(e)
+		}
+		return v
+	case map[any]any:
+		m := make(map[string]any, len(v))
+		for k, e := range v {
+			m[fmt.Sprint(k)] = jsonCompatible(e)
+		}
+		return m
+	case []any:
+		for i, e := range v {
+			v[i] = jsonCompatible(e)
+		}
+		return v
+	case []map[string]any:
+		for i, e := range v {
+			v[i] = jsonCompatible(e).(map[string]any)
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+// readConfig reads and decodes the config file at path.
+func readConfig(path string, rep configReport) (*AppServerConfig, error) {
+	data, err := readConfigJSON(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseConfig(data, rep)
+}
+
+// readConfigJSON reads the config file at path as JSON, expanding ${VAR}
+// references first.
+func readConfigJSON(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if data, err = expandEnv(path, data, os.LookupEnv); err != nil {
+		return nil, err
+	}
+	return configJSON(path, data)
+}
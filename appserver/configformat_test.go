@@ -0,0 +1,79 @@
+package appserver
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadConfigYAML(t *testing.T) {
+	tmp := t.TempDir()
+	yml := `# generated by our deploy templates
+fast_workers: 6
+slow_routes: [/reports/]
+error_pages:
+  503: errors/busy.html
+pools:
+  - name: fast
+    workers: 6
+  - name: slow
+    workers: 2
+`
+	if err := os.WriteFile(filepath.Join(tmp, "go_appserver.yaml"), []byte(yml), 0o644); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+
+	cfg := LoadConfig(tmp)
+	if cfg.FastWorkers != 6 {
+		t.Fatalf("FastWorkers = %d, want 6", cfg.FastWorkers)
+	}
+	if len(cfg.SlowRoutes) != 1 || cfg.SlowRoutes[0] != "/reports/" {
+		t.Fatalf("SlowRoutes = %v", cfg.SlowRoutes)
+	}
+	if cfg.ErrorPages["503"] != "errors/busy.html" {
+		t.Fatalf("ErrorPages = %v", cfg.ErrorPages)
+	}
+	if len(cfg.Pools) != 2 || cfg.Pools[1].Name != "slow" || cfg.Pools[1].Workers != 2 {
+		t.Fatalf("Pools = %+v", cfg.Pools)
+	}
+}
+
+func TestLoadConfigTOML(t *testing.T) {
+	tmp := t.TempDir()
+	tml := `fast_workers = 3
+slow_methods = ["PUT"]
+
+[[static]]
+prefix = "/assets/"
+dir = "public/assets"
+`
+	if err := os.WriteFile(filepath.Join(tmp, "go_appserver.toml"), []byte(tml), 0o644); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+
+	cfg := LoadConfig(tmp)
+	if cfg.FastWorkers != 3 {
+		t.Fatalf("FastWorkers = %d, want 3", cfg.FastWorkers)
+	}
+	if len(cfg.SlowMethods) != 1 || cfg.SlowMethods[0] != "PUT" {
+		t.Fatalf("SlowMethods = %v", cfg.SlowMethods)
+	}
+	if len(cfg.Static) != 1 || cfg.Static[0].Prefix != "/assets/" {
+		t.Fatalf("Static = %+v", cfg.Static)
+	}
+}
+
+func TestConfigPathPrefersJSON(t *testing.T) {
+	tmp := t.TempDir()
+	if got := ConfigPath(tmp); filepath.Base(got) != "go_appserver.json" {
+		t.Fatalf("default config path = %s", got)
+	}
+	for _, name := range []string{"go_appserver.toml", "go_appserver.json"} {
+		if err := os.WriteFile(filepath.Join(tmp, name), []byte("{}"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := ConfigPath(tmp); filepath.Base(got) != "go_appserver.json" {
+		t.Fatalf("config path = %s, want the JSON file", got)
+	}
+}
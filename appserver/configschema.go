@@ -0,0 +1,189 @@
+package appserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// jsonField is a struct field as encoding/json sees it.
+type jsonField struct {
+	name string
+	typ  reflect.Type
+}
+
+// jsonFields lists the JSON keys of struct type t, including those of
+// embedded structs.
+func jsonFields(t reflect.Type) []jsonField {
+	var fields []jsonField
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				fields = append(fields, jsonFields(ft)...)
+				continue
+			}
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields = append(fields, jsonField{name: name, typ: f.Type})
+	}
+	return fields
+}
+
+// unknownKeys reports keys in a JSON config that AppServerConfig has no
+// field for, which encoding/json would otherwise drop without a word.
+func unknownKeys(data []byte) []ConfigError {
+	var doc any
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil // parseConfig reports syntax errors
+	}
+	if m, ok := doc.(map[string]any); ok {
+		delete(m, "$schema") // editors use it to find the schema
+	}
+	return unknownIn("", doc, reflect.TypeOf(AppServerConfig{}))
+}
+
+func unknownIn(path string, v any, t reflect.Type) []ConfigError {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	var problems []ConfigError
+	switch t.Kind() {
+	case reflect.Struct:
+		obj, ok := v.(map[string]any)
+		if !ok {
+			return nil // a type error, which parseConfig reports
+		}
+		fields := jsonFields(t)
+		keys := make([]string, 0, len(obj))
+		for k := range obj {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+	keys:
+		for _, k := range keys {
+			p := joinPath(path, k)
+			for _, f := range fields {
+				// encoding/json matches keys case-insensitively
+				if strings.EqualFold(f.name, k) {
+					problems = append(problems, unknownIn(p, obj[k], f.typ)...)
+					continue keys
+				}
+			}
+			msg := fmt.Sprintf("unknown key %q", p)
+			if s := closestField(k, fields); s != "" {
+				msg += fmt.Sprintf(" (did you mean %q?)", s)
+			}
+			problems = append(problems, ConfigError{Path: p, Message: msg})
+		}
+	case reflect.Slice, reflect.Array:
+		if list, ok := v.([]any); ok {
+			for i, e := range list {
+				problems = append(problems, unknownIn(fmt.Sprintf("%s[%d]", path, i), e, t.Elem())...)
+			}
+		}
+	case reflect.Map:
+		if obj, ok := v.(map[string]any); ok {
+			for k, e := range obj {
+				problems = append(problems, unknownIn(joinPath(path, k), e, t.Elem())...)
+			}
+		}
+	}
+	return problems
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// closestField suggests the field a misspelt key was probably meant to be.
+func closestField(key string, fields []jsonField) string {
+	best, bestDist := "", 3 // more than two edits is not a typo
+	for _, f := range fields {
+		if d := editDistance(strings.ToLower(key), f.name); d < bestDist {
+			best, bestDist = f.name, d
+		}
+	}
+	return best
+}
+
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+// Schema describes go_appserver.json as a JSON Schema, derived from
+// the config structs so it can't drift from what the server accepts.
+func Schema() map[string]any {
+	schema := schemaFor(reflect.TypeOf(AppServerConfig{}))
+	schema["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	schema["title"] = "go_appserver.json"
+	schema["properties"].(map[string]any)["$schema"] = map[string]any{"type": "string"}
+	return schema
+}
+
+func schemaFor(t reflect.Type) map[string]any {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]any{"type": "integer"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer", "minimum": 0}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": schemaFor(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": schemaFor(t.Elem())}
+	case reflect.Struct:
+		props := map[string]any{}
+		for _, f := range jsonFields(t) {
+			props[f.name] = schemaFor(f.typ)
+		}
+		return map[string]any{"type": "object", "properties": props, "additionalProperties": false}
+	default:
+		return map[string]any{}
+	}
+}
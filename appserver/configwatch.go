@@ -0,0 +1,203 @@
+package appserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+
+	"go-php/server"
+)
+
+// configDebounce coalesces the bursts of events editors produce on save.
+const configDebounce = 250 * time.Millisecond
+
+// reloadSafe lists the top-level settings a config reload applies in place.
+// Pool request timeouts are reloaded too; every other change needs a
+// restart.
+var reloadSafe = map[string]bool{
+	"static":              true,
+	"slow_routes":         true,
+	"slow_methods":        true,
+	"slow_body_threshold": true,
+	"request_timeout_ms":  true,
+	"pool_routes":         true,
+	"retry":               true,
+	"load_shed":           true,
+	"priorities":          true,
+	"chaos":               true,
+}
+
+// watchConfig reloads go_appserver.json when it changes, applying the
+// reload-safe settings to every running app and logging the ones that
+// need a restart. The directory is watched rather than the file, since
+// editors often save by replacing it. The returned func stops watching.
+func watchConfig(projectRoot string, cfg *AppServerConfig, vhosts *vhostRouter) (func(), error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	if err := watcher.Add(projectRoot); err != nil {
+		_ = watcher.Close()
+		return nil, err
+	}
+
+	go func() {
+		current := cfg
+		var debounce <-chan time.Time
+		for {
+			select {
+			case ev, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if filepath.Dir(ev.Name) == filepath.Clean(projectRoot) && isConfigName(filepath.Base(ev.Name)) && ev.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) != 0 {
+					debounce = time.After(configDebounce)
+				}
+
+			case <-debounce:
+				debounce = nil
+				path := ConfigPath(projectRoot)
+				next, err := readConfig(path, logConfig)
+				if err != nil {
+					log.Printf("[config] reload: invalid %s: %v; keeping current settings", filepath.Base(path), err)
+					continue
+				}
+				applyConfigReload(current, next, vhosts)
+				current = next
+
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Println("[config] watcher error:", err)
+			}
+		}
+	}()
+
+	log.Printf("[config] watching %s for changes", ConfigPath(projectRoot))
+	return func() { _ = watcher.Close() }, nil
+}
+
+// applyConfigReload moves every app to the reload-safe settings in next
+// and reports what else changed since old.
+func applyConfigReload(old, next *AppServerConfig, vhosts *vhostRouter) {
+	if err := reloadApp(vhosts.def, next); err != nil {
+		log.Printf("[config] reload: app %q: %v", vhosts.def.name, err)
+	}
+	for _, app := range vhosts.apps {
+		for _, a := range next.Apps {
+			if a.Name != app.name {
+				continue
+			}
+			if err := reloadApp(app, a.appConfig(next)); err != nil {
+				log.Printf("[config] reload: app %q: %v", app.name, err)
+			}
+		}
+	}
+
+	log.Printf("[config] reloaded config (static rules, routing, timeouts and limits)")
+	for _, field := range restartRequired(old, next) {
+		log.Printf("[config] %q changed; restart the server to apply it", field)
+	}
+}
+
+// reloadApp applies cfg's reload-safe settings to app, all or nothing for
+// the parts that can be validated up front.
+func reloadApp(app *vhost, cfg *AppServerConfig) error {
+	patch := configPatch{
+		Static:            &cfg.Static,
+		SlowRoutes:        &cfg.SlowRoutes,
+		SlowMethods:       &cfg.SlowMethods,
+		SlowBodyThreshold: &cfg.SlowBodyThreshold,
+		LoadShed:          &server.LoadShed{},
+	}
+	if cfg.LoadShed != nil {
+		patch.LoadShed = cfg.LoadShed
+	}
+	for _, p := range cfg.serverPools(nil) {
+		if app.srv.Pool(p.Name) == nil {
+			continue // added pools need a restart
+		}
+		ms := int(p.RequestTimeout / time.Millisecond)
+		patch.Pools = append(patch.Pools, poolPatch{Name: p.Name, RequestTimeoutMs: &ms})
+	}
+
+	liveConfigMu.Lock()
+	defer liveConfigMu.Unlock()
+
+	if err := patch.validate(app); err != nil {
+		return err
+	}
+	if err := app.srv.SetPoolRoutes(cfg.serverPoolRoutes()); err != nil {
+		return err
+	}
+	if err := patch.apply(app, nil); err != nil {
+		return err
+	}
+
+	retry := server.RetryPolicy{}
+	if cfg.Retry != nil {
+		retry = *cfg.Retry
+	}
+	if err := app.srv.SetRetryPolicy(retry); err != nil {
+		return fmt.Errorf("retry: %w", err)
+	}
+	chaos := server.Chaos{}
+	if cfg.Chaos != nil {
+		chaos = *cfg.Chaos
+	}
+	if err := app.srv.SetChaos(chaos); err != nil {
+		return fmt.Errorf("chaos: %w", err)
+	}
+	priorities := server.Priorities{}
+	if cfg.Priorities != nil {
+		priorities = *cfg.Priorities
+	}
+	return app.srv.SetPriorities(priorities)
+}
+
+// restartRequired names the top-level settings that differ between old and
+// next in ways a reload can't apply.
+func restartRequired(old, next *AppServerConfig) []string {
+	a, b := reflect.ValueOf(*withoutReloadable(old)), reflect.ValueOf(*withoutReloadable(next))
+	t := a.Type()
+
+	var changed []string
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name == "" || name == "-" || reloadSafe[name] {
+			continue
+		}
+		if !reflect.DeepEqual(a.Field(i).Interface(), b.Field(i).Interface()) {
+			changed = append(changed, name)
+		}
+	}
+	return changed
+}
+
+// withoutReloadable returns a deep copy of cfg with the pool timeouts and
+// per-app reload-safe settings cleared, so only restart-only settings are
+// left to compare.
+func withoutReloadable(cfg *AppServerConfig) *AppServerConfig {
+	data, _ := json.Marshal(cfg)
+	var c AppServerConfig
+	_ = json.Unmarshal(data, &c)
+
+	for i := range c.Pools {
+		c.Pools[i].RequestTimeoutMs = 0
+	}
+	for i := range c.Apps {
+		a := &c.Apps[i]
+		a.Static, a.PoolRoutes, a.Retry, a.LoadShed, a.Priorities = nil, nil, nil, nil, nil
+		for j := range a.Pools {
+			a.Pools[j].RequestTimeoutMs = 0
+		}
+	}
+	return &c
+}
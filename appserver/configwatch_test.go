@@ -0,0 +1,81 @@
+package appserver
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestRestartRequired(t *testing.T) {
+	old, err := parseConfig([]byte(`{"fast_workers": 2, "slow_routes": ["/a"], "pools": [{"name": "fast", "workers": 2, "request_timeout_ms": 1000}]}`), logConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	next, err := parseConfig([]byte(`{"fast_workers": 4, "slow_routes": ["/b"], "pools": [{"name": "fast", "workers": 2, "request_timeout_ms": 5000}]}`), logConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := restartRequired(old, next); !slices.Equal(got, []string{"fast_workers"}) {
+		t.Fatalf("restartRequired = %v, want [fast_workers]", got)
+	}
+
+	next.Pools[0].Workers = 3
+	if got := restartRequired(old, next); !slices.Contains(got, "pools") {
+		t.Fatalf("pool size changes need a restart, got %v", got)
+	}
+}
+
+func TestWatchConfigAppliesReloadSafeSettings(t *testing.T) {
+	root := t.TempDir()
+	path := ConfigPath(root)
+	write := func(body string) {
+		t.Helper()
+		tmp := filepath.Join(root, "tmp.json")
+		if err := os.WriteFile(tmp, []byte(body), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Rename(tmp, path); err != nil { // how editors save
+			t.Fatal(err)
+		}
+	}
+	write(`{"pools": [{"name": "stable"}, {"name": "canary"}]}`)
+	cfg := LoadConfig(root)
+
+	app := &vhost{name: "default", srv: newCanaryTestServer(t)}
+	app.setStatic(cfg.Static)
+	stop, err := watchConfig(root, cfg, &vhostRouter{def: app})
+	if err != nil {
+		t.Fatalf("watchConfig: %v", err)
+	}
+	defer stop()
+
+	write(`{
+		"pools": [{"name": "stable"}, {"name": "canary", "request_timeout_ms": 1234}],
+		"slow_routes": ["/reports"],
+		"static": [{"prefix": "/build/", "dir": "public/build"}],
+		"pool_routes": [{"prefix": "/beta", "pool": "canary"}]
+	}`)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		routes := app.srv.SlowRouting().Routes
+		if slices.Equal(routes, []string{"/reports"}) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("config change not applied, slow routes = %v", routes)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if rules := app.staticRules(); len(rules) != 1 || rules[0].Prefix != "/build/" {
+		t.Fatalf("static rules not reloaded: %+v", rules)
+	}
+	if got := app.srv.Pool("canary").RequestTimeout(); got != 1234*time.Millisecond {
+		t.Fatalf("canary timeout = %v", got)
+	}
+	if routes := app.srv.PoolRoutes(); len(routes) != 1 || routes[0].Pool != "canary" {
+		t.Fatalf("pool routes not reloaded: %+v", routes)
+	}
+}
@@ -0,0 +1,142 @@
+package appserver
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// CORSConfig answers cross-origin requests at the Go layer. Preflight
+// requests are answered here and never reach PHP.
+type CORSConfig struct {
+	// AllowedOrigins are exact origins ("https://app.example.com"), "*" for
+	// any origin, or a wildcard subdomain ("https://*.example.com").
+	AllowedOrigins []string `json:"allowed_origins"`
+	// AllowedMethods defaults to GET, HEAD and POST.
+	AllowedMethods []string `json:"allowed_methods"`
+	// AllowedHeaders may include "*" to allow whatever the browser asks for.
+	AllowedHeaders   []string `json:"allowed_headers"`
+	ExposedHeaders   []string `json:"exposed_headers"`
+	AllowCredentials bool     `json:"allow_credentials"`
+	MaxAge           int      `json:"max_age"` // seconds browsers may cache a preflight
+}
+
+// allowsOrigin reports whether origin may make cross-origin requests.
+func (c *CORSConfig) allowsOrigin(origin string) bool {
+	for _, o := range c.AllowedOrigins {
+		if o == "*" || strings.EqualFold(o, origin) {
+			return true
+		}
+		if scheme, host, ok := strings.Cut(o, "://*."); ok {
+			prefix := scheme + "://"
+			rest, found := strings.CutPrefix(strings.ToLower(origin), strings.ToLower(prefix))
+			if found && strings.HasSuffix(rest, "."+strings.ToLower(host)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (c *CORSConfig) allowsHeaders(requested string) bool {
+	if slices.Contains(c.AllowedHeaders, "*") {
+		return true
+	}
+	for h := range strings.SplitSeq(requested, ",") {
+		h = strings.TrimSpace(h)
+		if h == "" {
+			continue
+		}
+		if !slices.ContainsFunc(c.AllowedHeaders, func(a string) bool { return strings.EqualFold(a, h) }) {
+			return false
+		}
+	}
+	return true
+}
+
+// setOrigin writes the headers common to preflight and actual responses.
+func (c *CORSConfig) setOrigin(h http.Header, origin string) {
+	if slices.Contains(c.AllowedOrigins, "*") {
+		h.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		h.Set("Access-Control-Allow-Origin", origin)
+	}
+	if c.AllowCredentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+// corsHandler applies cfg to requests for next, leaving management
+// endpoints alone.
+func corsHandler(cfg *CORSConfig, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" || isManagementPath(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		h := w.Header()
+		h.Add("Vary", "Origin")
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			h.Add("Vary", "Access-Control-Request-Method")
+			h.Add("Vary", "Access-Control-Request-Headers")
+			method := r.Header.Get("Access-Control-Request-Method")
+			requested := r.Header.Get("Access-Control-Request-Headers")
+			// A refused preflight gets no CORS headers; the browser then
+			// blocks the actual request.
+			if cfg.allowsOrigin(origin) && slices.Contains(cfg.AllowedMethods, method) && cfg.allowsHeaders(requested) {
+				cfg.setOrigin(h, origin)
+				h.Set("Access-Control-Allow-Methods", strings.Join(cfg.AllowedMethods, ", "))
+				if requested != "" {
+					h.Set("Access-Control-Allow-Headers", requested)
+				}
+				if cfg.MaxAge > 0 {
+					h.Set("Access-Control-Max-Age", strconv.Itoa(cfg.MaxAge))
+				}
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		if cfg.allowsOrigin(origin) {
+			cfg.setOrigin(h, origin)
+			if len(cfg.ExposedHeaders) > 0 {
+				h.Set("Access-Control-Expose-Headers", strings.Join(cfg.ExposedHeaders, ", "))
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// validateCORS fills in default methods and normalises the lists.
+func validateCORS(cfg *AppServerConfig, warn configWarnFunc) {
+	c := cfg.CORS
+	if c == nil {
+		return
+	}
+	if len(c.AllowedOrigins) == 0 {
+		warn("cors.allowed_origins", "cors.allowed_origins is empty, no origin will be allowed")
+	}
+	for i, o := range c.AllowedOrigins {
+		c.AllowedOrigins[i] = strings.TrimSuffix(o, "/")
+	}
+	if slices.Contains(c.AllowedOrigins, "*") && c.AllowCredentials {
+		// echoing every Origin back with credentials would let any site
+		// read responses made with the user's cookies
+		warn("cors.allow_credentials", "cors.allow_credentials can't be combined with allowed_origins \"*\", not allowing credentials")
+		c.AllowCredentials = false
+	}
+	if len(c.AllowedMethods) == 0 {
+		c.AllowedMethods = []string{http.MethodGet, http.MethodHead, http.MethodPost}
+	}
+	for i, m := range c.AllowedMethods {
+		c.AllowedMethods[i] = strings.ToUpper(m)
+	}
+	if c.MaxAge < 0 {
+		warn("cors.max_age", "cors.max_age=%d is invalid, not caching preflights", c.MaxAge)
+		c.MaxAge = 0
+	}
+}
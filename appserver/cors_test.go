@@ -0,0 +1,102 @@
+package appserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"go-php/server"
+)
+
+func TestCORSPreflightSkipsPHP(t *testing.T) {
+	var phpCalls atomic.Int32
+	cfg := &AppServerConfig{
+		Root: t.TempDir(),
+		Pools: []PoolConfig{{Name: "fast", Workers: 1, Transport: "tcp", Address: fakePHP(t, func(req *server.RequestPayload) *server.ResponsePayload {
+			phpCalls.Add(1)
+			return &server.ResponsePayload{Status: http.StatusOK, Body: []byte("ok")}
+		})}},
+		CORS: &CORSConfig{
+			AllowedOrigins:   []string{"https://app.example.com", "https://*.example.org"},
+			AllowedMethods:   []string{"get", "put"},
+			AllowedHeaders:   []string{"Content-Type", "X-Requested-With"},
+			ExposedHeaders:   []string{"X-Request-Id"},
+			AllowCredentials: true,
+			MaxAge:           600,
+		},
+	}
+	srv, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	t.Cleanup(func() { _ = srv.Shutdown(t.Context()) })
+
+	preflight := func(origin, method, headers string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodOptions, "/api/items", nil)
+		req.Header.Set("Origin", origin)
+		req.Header.Set("Access-Control-Request-Method", method)
+		if headers != "" {
+			req.Header.Set("Access-Control-Request-Headers", headers)
+		}
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := preflight("https://app.example.com", "PUT", "content-type")
+	h := rr.Header()
+	if rr.Code != http.StatusNoContent || h.Get("Access-Control-Allow-Origin") != "https://app.example.com" ||
+		h.Get("Access-Control-Allow-Methods") != "GET, PUT" || h.Get("Access-Control-Allow-Headers") != "content-type" ||
+		h.Get("Access-Control-Max-Age") != "600" || h.Get("Access-Control-Allow-Credentials") != "true" {
+		t.Fatalf("allowed preflight: %d %v", rr.Code, h)
+	}
+
+	for _, c := range []struct{ origin, method, headers string }{
+		{"https://evil.example.com", "PUT", ""},
+		{"https://app.example.com", "DELETE", ""},
+		{"https://app.example.com", "PUT", "Authorization"},
+	} {
+		rr := preflight(c.origin, c.method, c.headers)
+		if rr.Code != http.StatusNoContent || rr.Header().Get("Access-Control-Allow-Origin") != "" {
+			t.Fatalf("refused preflight %+v: %d %v", c, rr.Code, rr.Header())
+		}
+	}
+	if n := phpCalls.Load(); n != 0 {
+		t.Fatalf("preflights reached PHP %d times", n)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/items", nil)
+	req.Header.Set("Origin", "https://shop.example.org")
+	rr = httptest.NewRecorder()
+	srv.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK || rr.Header().Get("Access-Control-Allow-Origin") != "https://shop.example.org" ||
+		rr.Header().Get("Access-Control-Expose-Headers") != "X-Request-Id" || rr.Header().Get("Vary") != "Origin" {
+		t.Fatalf("actual request: %d %v", rr.Code, rr.Header())
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/items", nil)
+	req.Header.Set("Origin", "https://example.org.evil.com")
+	rr = httptest.NewRecorder()
+	srv.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK || rr.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Fatalf("foreign origin got CORS headers: %v", rr.Header())
+	}
+}
+
+func TestValidateCORSRefusesCredentialsFromAnyOrigin(t *testing.T) {
+	var warned []string
+	cfg := &AppServerConfig{CORS: &CORSConfig{AllowedOrigins: []string{"*"}, AllowCredentials: true}}
+	validateCORS(cfg, func(path, format string, args ...any) { warned = append(warned, path) })
+	if cfg.CORS.AllowCredentials || len(warned) != 1 || warned[0] != "cors.allow_credentials" {
+		t.Fatalf("credentials = %v, warnings = %v; want credentials refused with a warning", cfg.CORS.AllowCredentials, warned)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/items", nil)
+	req.Header.Set("Origin", "https://evil.example")
+	rr := httptest.NewRecorder()
+	corsHandler(cfg.CORS, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(rr, req)
+	if rr.Header().Get("Access-Control-Allow-Origin") != "*" || rr.Header().Get("Access-Control-Allow-Credentials") != "" {
+		t.Fatalf("headers = %v", rr.Header())
+	}
+}
@@ -0,0 +1,147 @@
+package appserver
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// CSRFConfig rejects cross-site POST, PUT, PATCH and DELETE requests under
+// Prefixes before they reach PHP. Requests with a bearer token are exempt:
+// browsers never attach one on their own, so they can't be forged.
+type CSRFConfig struct {
+	Prefixes []string `json:"prefixes"` // default ["/"]
+	Except   []string `json:"except"`   // e.g. webhook endpoints
+
+	// Mode "origin" (default) rejects requests whose Sec-Fetch-Site or
+	// Origin says they came from another site. "double_submit" requires
+	// the CSRF cookie's value to be echoed in the CSRF header.
+	Mode string `json:"mode"`
+
+	// TrustedOrigins may post cross-origin in origin mode, e.g.
+	// "https://admin.example.com".
+	TrustedOrigins []string `json:"trusted_origins"`
+
+	CookieName string `json:"cookie_name"` // default XSRF-TOKEN
+	HeaderName string `json:"header_name"` // default X-XSRF-TOKEN
+	// IssueCookie sets a random CSRF cookie on safe requests that don't
+	// carry one, for apps that don't set it themselves.
+	IssueCookie bool `json:"issue_cookie"`
+}
+
+type csrfGuard struct {
+	cfg    *CSRFConfig
+	origin *http.CrossOriginProtection
+}
+
+func newCSRFGuard(cfg *CSRFConfig) (*csrfGuard, error) {
+	g := &csrfGuard{cfg: cfg, origin: http.NewCrossOriginProtection()}
+	for _, o := range cfg.TrustedOrigins {
+		if err := g.origin.AddTrustedOrigin(o); err != nil {
+			return nil, fmt.Errorf("csrf: %w", err)
+		}
+	}
+	return g, nil
+}
+
+func (g *csrfGuard) protects(path string) bool {
+	if isManagementPath(path) {
+		return false
+	}
+	under := func(p string) bool { return underPrefix(path, p) }
+	if len(g.cfg.Prefixes) > 0 && !slices.ContainsFunc(g.cfg.Prefixes, under) {
+		return false
+	}
+	return !slices.ContainsFunc(g.cfg.Except, under)
+}
+
+// check returns why r looks forged, or nil.
+func (g *csrfGuard) check(r *http.Request) error {
+	if g.cfg.Mode != "double_submit" {
+		return g.origin.Check(r)
+	}
+	c, err := r.Cookie(g.cfg.CookieName)
+	if err != nil || c.Value == "" {
+		return errors.New("no CSRF cookie")
+	}
+	sent := r.Header.Get(g.cfg.HeaderName)
+	if subtle.ConstantTimeCompare([]byte(sent), []byte(c.Value)) != 1 {
+		return errors.New("CSRF header doesn't match the cookie")
+	}
+	return nil
+}
+
+func isSafeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// csrfHandler answers forged requests under g's prefixes with 403.
+func csrfHandler(g *csrfGuard, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !g.protects(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if isSafeMethod(r.Method) {
+			if g.cfg.IssueCookie && g.cfg.Mode == "double_submit" {
+				if _, err := r.Cookie(g.cfg.CookieName); err != nil {
+					http.SetCookie(w, &http.Cookie{
+						Name:     g.cfg.CookieName,
+						Value:    rand.Text(),
+						Path:     "/",
+						Secure:   r.TLS != nil,
+						SameSite: http.SameSiteLaxMode,
+					})
+				}
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+		if strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if err := g.check(r); err != nil {
+			log.Printf("[csrf] %s %s from %s: %v", r.Method, r.URL.Path, r.RemoteAddr, err)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// validateCSRF fills in defaults and fixes prefixes.
+func validateCSRF(cfg *AppServerConfig, warn configWarnFunc) {
+	c := cfg.CSRF
+	if c == nil {
+		return
+	}
+	switch c.Mode {
+	case "", "origin", "double_submit":
+	default:
+		warn("csrf.mode", "csrf.mode=%q is not origin or double_submit, using origin", c.Mode)
+		c.Mode = "origin"
+	}
+	if c.CookieName == "" {
+		c.CookieName = "XSRF-TOKEN"
+	}
+	if c.HeaderName == "" {
+		c.HeaderName = "X-XSRF-TOKEN"
+	}
+	for i, p := range c.Prefixes {
+		if !strings.HasPrefix(p, "/") {
+			warn(fmt.Sprintf("csrf.prefixes[%d]", i), "csrf.prefixes[%d]=%q does not start with '/', fixing", i, p)
+			c.Prefixes[i] = "/" + p
+		}
+	}
+	for i, o := range c.TrustedOrigins {
+		if err := http.NewCrossOriginProtection().AddTrustedOrigin(o); err != nil {
+			warn(fmt.Sprintf("csrf.trusted_origins[%d]", i), "csrf.trusted_origins[%d]: %v", i, err)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package appserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go-php/server"
+)
+
+func TestCSRFRejectsCrossSiteWrites(t *testing.T) {
+	newServer := func(c *CSRFConfig) *Server {
+		t.Helper()
+		srv, err := New(&AppServerConfig{
+			Root: t.TempDir(),
+			Pools: []PoolConfig{{Name: "fast", Workers: 1, Transport: "tcp", Address: fakePHP(t, func(req *server.RequestPayload) *server.ResponsePayload {
+				return &server.ResponsePayload{Status: http.StatusOK, Body: []byte("ok")}
+			})}},
+			CSRF: c,
+		})
+		if err != nil {
+			t.Fatalf("New: %v", err)
+		}
+		t.Cleanup(func() { _ = srv.Shutdown(t.Context()) })
+		return srv
+	}
+	do := func(srv *Server, method, path string, headers map[string]string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "http://shop.example.com"+path, nil)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+		return rr
+	}
+
+	srv := newServer(&CSRFConfig{
+		Except:         []string{"/webhooks/", "/hooks/stripe"},
+		TrustedOrigins: []string{"https://admin.example.com"},
+	})
+	for _, c := range []struct {
+		name    string
+		method  string
+		path    string
+		headers map[string]string
+		want    int
+	}{
+		{"cross-site post", "POST", "/cart", map[string]string{"Sec-Fetch-Site": "cross-site"}, http.StatusForbidden},
+		{"foreign origin", "DELETE", "/cart", map[string]string{"Origin": "https://evil.example.net"}, http.StatusForbidden},
+		{"same-origin post", "POST", "/cart", map[string]string{"Sec-Fetch-Site": "same-origin"}, http.StatusOK},
+		{"own origin", "POST", "/cart", map[string]string{"Origin": "http://shop.example.com"}, http.StatusOK},
+		{"trusted origin", "POST", "/cart", map[string]string{"Origin": "https://admin.example.com", "Sec-Fetch-Site": "same-site"}, http.StatusOK},
+		{"bearer token", "POST", "/api/cart", map[string]string{"Sec-Fetch-Site": "cross-site", "Authorization": "Bearer abc"}, http.StatusOK},
+		{"exempt prefix", "POST", "/webhooks/stripe", map[string]string{"Sec-Fetch-Site": "cross-site"}, http.StatusOK},
+		{"exempt path", "POST", "/hooks/stripe/events", map[string]string{"Sec-Fetch-Site": "cross-site"}, http.StatusOK},
+		{"sibling of exempt path", "POST", "/hooks/stripe-admin/refund", map[string]string{"Sec-Fetch-Site": "cross-site"}, http.StatusForbidden},
+		{"cross-site get", "GET", "/cart", map[string]string{"Sec-Fetch-Site": "cross-site"}, http.StatusOK},
+		{"no browser headers", "POST", "/cart", nil, http.StatusOK},
+	} {
+		if rr := do(srv, c.method, c.path, c.headers); rr.Code != c.want {
+			t.Errorf("%s: got %d, want %d", c.name, rr.Code, c.want)
+		}
+	}
+
+	srv = newServer(&CSRFConfig{Prefixes: []string{"/account/"}, Mode: "double_submit", IssueCookie: true})
+	rr := do(srv, "GET", "/account/profile", nil)
+	var token *http.Cookie
+	for _, c := range rr.Result().Cookies() {
+		if c.Name == "XSRF-TOKEN" {
+			token = c
+		}
+	}
+	if token == nil || token.Value == "" {
+		t.Fatalf("no XSRF-TOKEN cookie issued: %v", rr.Header())
+	}
+	if rr := do(srv, "POST", "/account/profile", map[string]string{"X-XSRF-TOKEN": token.Value}, token); rr.Code != http.StatusOK {
+		t.Errorf("matching token: got %d", rr.Code)
+	}
+	if rr := do(srv, "POST", "/account/profile", map[string]string{"X-XSRF-TOKEN": "guess"}, token); rr.Code != http.StatusForbidden {
+		t.Errorf("wrong token: got %d", rr.Code)
+	}
+	if rr := do(srv, "POST", "/account/profile", nil); rr.Code != http.StatusForbidden {
+		t.Errorf("no cookie: got %d", rr.Code)
+	}
+	if rr := do(srv, "POST", "/public/form", nil); rr.Code != http.StatusOK {
+		t.Errorf("outside prefixes: got %d", rr.Code)
+	}
+}
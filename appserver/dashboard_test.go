@@ -0,0 +1,64 @@
+package appserver
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"go-php/server"
+)
+
+func TestDashboardHandler(t *testing.T) {
+	rr := httptest.NewRecorder()
+	dashboardHandler(rr, httptest.NewRequest(http.MethodGet, "/__baremetal/dashboard", nil))
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rr.Code)
+	}
+	if ct := rr.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+		t.Fatalf("Content-Type = %q", ct)
+	}
+	for _, endpoint := range []string{"/vars", "/metrics", "/scoreboard"} {
+		if !strings.Contains(rr.Body.String(), endpoint) {
+			t.Fatalf("dashboard does not poll %s", endpoint)
+		}
+	}
+}
+
+func TestMetricsRecentErrors(t *testing.T) {
+	m := NewMetrics()
+	for i := 0; i < maxRecentErrors+5; i++ {
+		m.RecordError(&server.RequestPayload{ID: "r", Method: "GET", Path: "/boom"}, http.StatusBadGateway, errors.New("broken pipe"))
+	}
+
+	snap := m.Snapshot()
+	if len(snap.RecentErrors) != maxRecentErrors {
+		t.Fatalf("kept %d errors, want %d", len(snap.RecentErrors), maxRecentErrors)
+	}
+	if e := snap.RecentErrors[0]; e.Path != "/boom" || e.Status != http.StatusBadGateway || e.Error != "broken pipe" {
+		t.Fatalf("unexpected entry: %+v", e)
+	}
+}
+
+func TestMetricsErrorsByCategory(t *testing.T) {
+	m := NewMetrics()
+	req := &server.RequestPayload{ID: "r", Method: "GET", Path: "/boom"}
+	m.RecordError(req, http.StatusGatewayTimeout, fmt.Errorf("dispatch: %w", server.ErrWorkerTimeout))
+	m.RecordError(req, http.StatusBadGateway, server.ErrWorkerCrashed)
+	m.RecordError(req, http.StatusBadGateway, io.ErrUnexpectedEOF)
+	m.RecordError(req, http.StatusInternalServerError, errors.New("worker timeout, or so it says"))
+
+	snap := m.Snapshot()
+	want := map[string]uint64{server.CategoryTimeout: 1, server.CategoryCrashed: 2, server.CategoryOther: 1}
+	if !reflect.DeepEqual(snap.ErrorsByCategory, want) {
+		t.Fatalf("ErrorsByCategory = %v, want %v", snap.ErrorsByCategory, want)
+	}
+	if got := snap.RecentErrors[0].Category; got != server.CategoryTimeout {
+		t.Fatalf("first entry's category = %q, want %q", got, server.CategoryTimeout)
+	}
+}
@@ -0,0 +1,112 @@
+package appserver
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"go-php/server"
+)
+
+// DebugHeadersConfig adds X-Debug-* diagnostics to the PHP responses of
+// chosen requests: ones carrying "X-Baremetal-Debug: <Token>", or coming
+// from an address in IPs. Other requests are unaffected, so one slow
+// endpoint can be looked at in production without turning up logging.
+type DebugHeadersConfig struct {
+	Token string   `json:"token"` // falls back to APP_DEBUG_TOKEN
+	IPs   []string `json:"ips"`   // addresses or CIDRs
+}
+
+// debugRequestHeader asks for diagnostics. It is never passed on to PHP.
+const debugRequestHeader = "X-Baremetal-Debug"
+
+// debugHeaders is a compiled DebugHeadersConfig.
+type debugHeaders struct {
+	token string
+	ips   []netip.Prefix
+}
+
+func newDebugHeaders(cfg *DebugHeadersConfig) *debugHeaders {
+	if cfg == nil {
+		return nil
+	}
+	d := &debugHeaders{token: cfg.Token}
+	if d.token == "" {
+		d.token = os.Getenv("APP_DEBUG_TOKEN")
+	}
+	for _, s := range cfg.IPs {
+		if p, err := parseIPRule(s); err == nil {
+			d.ips = append(d.ips, p)
+		}
+	}
+	return d
+}
+
+// wanted reports whether r gets diagnostics, and strips its debug header.
+func (d *debugHeaders) wanted(r *http.Request) bool {
+	if d == nil {
+		return false
+	}
+	sent := r.Header.Get(debugRequestHeader)
+	r.Header.Del(debugRequestHeader)
+	if d.token != "" && sent != "" && subtle.ConstantTimeCompare([]byte(sent), []byte(d.token)) == 1 {
+		return true
+	}
+	return containsAddr(d.ips, clientAddr(r))
+}
+
+// setDebugHeaders describes how a buffered request was served. total is the
+// time since the request was read.
+func setDebugHeaders(h http.Header, payload *server.RequestPayload, total time.Duration) {
+	queue, worker := payload.Timing()
+	h.Set("X-Debug-Request-Id", payload.ID)
+	h.Set("X-Debug-Pool", payload.Pool())
+	if id := payload.Worker(); id != 0 {
+		h.Set("X-Debug-Worker", strconv.FormatUint(id, 10))
+		h.Set("X-Debug-Worker-Restarts", strconv.FormatUint(payload.WorkerRestarts(), 10))
+	}
+	h.Set("X-Debug-Retries", strconv.Itoa(max(payload.Attempts()-1, 0)))
+	h.Set("X-Debug-Queue-Ms", fmt.Sprintf("%.3f", durationMs(queue)))
+	h.Set("X-Debug-Worker-Ms", fmt.Sprintf("%.3f", durationMs(worker)))
+	h.Set("X-Debug-Total-Ms", fmt.Sprintf("%.3f", durationMs(total)))
+}
+
+// streamDebugTrailers are sent after a streamed response, whose headers go
+// out before any of this is known.
+var streamDebugTrailers = []string{"X-Debug-Request-Id", "X-Debug-Pool", "X-Debug-Frames", "X-Debug-Total-Ms"}
+
+// announceStreamDebug declares streamDebugTrailers; it must run before the
+// stream writes its headers.
+func announceStreamDebug(h http.Header) {
+	h.Set("Trailer", strings.Join(streamDebugTrailers, ", "))
+}
+
+// setStreamDebugTrailers fills in the trailers announceStreamDebug declared.
+func setStreamDebugTrailers(h http.Header, payload *server.RequestPayload, frames int, total time.Duration) {
+	h.Set("X-Debug-Request-Id", payload.ID)
+	h.Set("X-Debug-Pool", payload.Pool())
+	h.Set("X-Debug-Frames", strconv.Itoa(frames))
+	h.Set("X-Debug-Total-Ms", fmt.Sprintf("%.3f", durationMs(total)))
+}
+
+// validateDebugHeaders reports addresses that don't parse (they are skipped)
+// and a config that can never match.
+func validateDebugHeaders(cfg *AppServerConfig, warn configWarnFunc) {
+	d := cfg.DebugHeaders
+	if d == nil {
+		return
+	}
+	for i, s := range d.IPs {
+		if _, err := parseIPRule(s); err != nil {
+			warn(fmt.Sprintf("debug_headers.ips[%d]", i), "debug_headers.ips[%d]=%q is not an address or CIDR, ignoring it", i, s)
+		}
+	}
+	if d.Token == "" && len(d.IPs) == 0 && os.Getenv("APP_DEBUG_TOKEN") == "" {
+		warn("debug_headers", "debug_headers has no token and no ips; no request will get diagnostics")
+	}
+}
@@ -0,0 +1,58 @@
+package appserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go-php/server"
+)
+
+func TestDebugHeaders(t *testing.T) {
+	srv, err := New(&AppServerConfig{
+		Root: t.TempDir(),
+		Pools: []PoolConfig{{Name: "fast", Workers: 1, Transport: "tcp", Address: fakePHP(t, func(req *server.RequestPayload) *server.ResponsePayload {
+			return &server.ResponsePayload{Status: http.StatusOK, Body: []byte(strings.Join(req.Headers[debugRequestHeader], ","))}
+		})}},
+		DebugHeaders: &DebugHeadersConfig{Token: "s3cret", IPs: []string{"10.1.0.0/16"}},
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	t.Cleanup(func() { _ = srv.Shutdown(t.Context()) })
+
+	get := func(remote, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/page", nil)
+		req.RemoteAddr = remote
+		if token != "" {
+			req.Header.Set(debugRequestHeader, token)
+		}
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := get("192.0.2.1:1234", "s3cret")
+	if rr.Body.String() != "" {
+		t.Errorf("PHP saw the debug header: %q", rr.Body)
+	}
+	h := rr.Header()
+	if h.Get("X-Debug-Pool") != "fast" || h.Get("X-Debug-Worker") == "" || h.Get("X-Debug-Worker-Restarts") != "0" ||
+		h.Get("X-Debug-Retries") != "0" || h.Get("X-Debug-Queue-Ms") == "" || h.Get("X-Debug-Worker-Ms") == "" ||
+		h.Get("X-Debug-Request-Id") == "" || h.Get("X-Debug-Total-Ms") == "" {
+		t.Errorf("debug headers missing: %v", h)
+	}
+
+	if rr := get("10.1.2.3:1234", ""); rr.Header().Get("X-Debug-Worker") == "" {
+		t.Errorf("allowlisted address got no debug headers: %v", rr.Header())
+	}
+	for _, tc := range []struct{ remote, token string }{
+		{"192.0.2.1:1234", ""},
+		{"192.0.2.1:1234", "wrong"},
+	} {
+		if rr := get(tc.remote, tc.token); rr.Header().Get("X-Debug-Worker") != "" {
+			t.Errorf("%s with token %q got debug headers", tc.remote, tc.token)
+		}
+	}
+}
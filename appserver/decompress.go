@@ -0,0 +1,111 @@
+package appserver
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// RequestDecompressionConfig decodes request bodies sent with
+// Content-Encoding gzip or deflate, so PHP receives them as plain bytes.
+type RequestDecompressionConfig struct {
+	Enabled bool `json:"enabled"`
+	// MaxBytes caps the decompressed size (default 10 MiB); larger
+	// bodies get 413, which also stops decompression bombs.
+	MaxBytes int64 `json:"max_bytes"`
+}
+
+// errBodyTooLarge reports a body that decompresses past the limit.
+var errBodyTooLarge = errors.New("decompressed body too large")
+
+// decompressBody returns the decoded body of r, at most limit bytes.
+func decompressBody(r *http.Request, encoding string, limit int64) ([]byte, error) {
+	var zr io.Reader
+	switch encoding {
+	case "gzip", "x-gzip":
+		gr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			return nil, err
+		}
+		defer gr.Close()
+		zr = gr
+	case "deflate":
+		// "deflate" should be zlib-wrapped, but some clients send raw
+		// deflate data
+		br := bufio.NewReader(r.Body)
+		if hdr, err := br.Peek(2); err == nil && (uint16(hdr[0])<<8|uint16(hdr[1]))%31 == 0 && hdr[0]&0x0f == 8 {
+			zl, err := zlib.NewReader(br)
+			if err != nil {
+				return nil, err
+			}
+			defer zl.Close()
+			zr = zl
+		} else {
+			fr := flate.NewReader(br)
+			defer fr.Close()
+			zr = fr
+		}
+	default:
+		return nil, fmt.Errorf("unsupported Content-Encoding %q", encoding)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(zr, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > limit {
+		return nil, errBodyTooLarge
+	}
+	return body, nil
+}
+
+// decompressHandler swaps a compressed request body for its decoded bytes
+// before the request reaches BuildPayload. Other encodings are left alone.
+func decompressHandler(cfg RequestDecompressionConfig, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encoding := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding")))
+		if encoding != "gzip" && encoding != "x-gzip" && encoding != "deflate" || isManagementPath(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		body, err := decompressBody(r, encoding, cfg.MaxBytes)
+		_ = r.Body.Close()
+		if errors.Is(err, errBodyTooLarge) {
+			http.Error(w, "Request Entity Too Large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err != nil {
+			log.Printf("[decompress] %s %s: %v", r.Method, r.URL.Path, err)
+			http.Error(w, "Bad Request: malformed "+encoding+" body", http.StatusBadRequest)
+			return
+		}
+
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		r.ContentLength = int64(len(body))
+		r.Header.Del("Content-Encoding")
+		r.Header.Set("Content-Length", strconv.Itoa(len(body)))
+		next.ServeHTTP(w, r)
+	})
+}
+
+// validateRequestDecompression fills in the size limit.
+func validateRequestDecompression(cfg *AppServerConfig, warn configWarnFunc) {
+	d := &cfg.RequestDecompression
+	if d.MaxBytes < 0 {
+		warn("request_decompression.max_bytes", "request_decompression.max_bytes=%d is invalid, using 10 MiB", d.MaxBytes)
+		d.MaxBytes = 0
+	}
+	if d.MaxBytes == 0 {
+		d.MaxBytes = 10 << 20
+	}
+}
@@ -0,0 +1,75 @@
+package appserver
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go-php/server"
+)
+
+func TestRequestDecompression(t *testing.T) {
+	srv, err := New(&AppServerConfig{
+		Root: t.TempDir(),
+		Pools: []PoolConfig{{Name: "fast", Workers: 1, Transport: "tcp", Address: fakePHP(t, func(req *server.RequestPayload) *server.ResponsePayload {
+			got := string(req.Body) + "|" + strings.Join(req.Headers["Content-Encoding"], ",") + "|" + strings.Join(req.Headers["Content-Length"], ",")
+			return &server.ResponsePayload{Status: http.StatusOK, Body: []byte(got)}
+		})}},
+		RequestDecompression: RequestDecompressionConfig{Enabled: true, MaxBytes: 1024},
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	t.Cleanup(func() { _ = srv.Shutdown(t.Context()) })
+
+	compress := func(newWriter func(io.Writer) io.WriteCloser, data string) []byte {
+		var b bytes.Buffer
+		w := newWriter(&b)
+		io.WriteString(w, data)
+		w.Close()
+		return b.Bytes()
+	}
+	gz := func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) }
+	zl := func(w io.Writer) io.WriteCloser { return zlib.NewWriter(w) }
+	raw := func(w io.Writer) io.WriteCloser { fw, _ := flate.NewWriter(w, flate.DefaultCompression); return fw }
+
+	post := func(encoding string, body []byte) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/sync", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Content-Encoding", encoding)
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+		return rr
+	}
+
+	const doc = `{"items":[1,2,3]}`
+	for _, c := range []struct {
+		encoding string
+		body     []byte
+	}{
+		{"gzip", compress(gz, doc)},
+		{"deflate", compress(zl, doc)},
+		{"deflate", compress(raw, doc)},
+	} {
+		rr := post(c.encoding, c.body)
+		if want := doc + "||17"; rr.Code != http.StatusOK || rr.Body.String() != want {
+			t.Errorf("%s: got %d %q, want %q", c.encoding, rr.Code, rr.Body.String(), want)
+		}
+	}
+
+	if rr := post("gzip", compress(gz, strings.Repeat("a", 4096))); rr.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("oversized body: got %d", rr.Code)
+	}
+	if rr := post("gzip", []byte("not gzip")); rr.Code != http.StatusBadRequest {
+		t.Errorf("corrupt body: got %d", rr.Code)
+	}
+	if rr := post("br", []byte("opaque")); rr.Code != http.StatusOK || rr.Body.String() != "opaque|br|" {
+		t.Errorf("unknown encoding: got %d %q", rr.Code, rr.Body.String())
+	}
+}
@@ -0,0 +1,119 @@
+package appserver
+
+import (
+	"html"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"go-php/server"
+)
+
+// errorPages holds the "error_pages" templates by status code. Templates are
+// usually HTML; {{status}}, {{status_text}} and {{request_id}} are replaced
+// (HTML-escaped) when a page is served. The "50x" page is kept under
+// anyServerError and used for 5xx statuses without a page of their own.
+type errorPages map[int]errorPage
+
+// errorPage is a template and the Content-Type its file extension implies.
+type errorPage struct {
+	body        string
+	contentType string
+}
+
+const anyServerError = 0
+
+// loadErrorPages reads the configured templates, resolving relative paths
+// against projectRoot. Bad entries are logged and skipped, so a missing file
+// only costs the branding, not the error response.
+func loadErrorPages(projectRoot string, paths map[string]string, warn configWarnFunc) errorPages {
+	pages := make(errorPages, len(paths))
+	for code, path := range paths {
+		status, err := strconv.Atoi(code)
+		if code == "50x" {
+			status, err = anyServerError, nil
+		} else if err != nil || status < 400 || status > 599 {
+			warn("error_pages."+code, "error_pages: %q is not an error status, ignoring", code)
+			continue
+		}
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(projectRoot, path)
+		}
+		body, err := os.ReadFile(path)
+		if err != nil {
+			warn("error_pages."+code, "error_pages: %s: %v; using plain text", code, err)
+			continue
+		}
+		ct := mime.TypeByExtension(filepath.Ext(path))
+		if ct == "" {
+			ct = "text/html; charset=utf-8"
+		}
+		pages[status] = errorPage{body: string(body), contentType: ct}
+	}
+	return pages
+}
+
+// lookup returns the page for status, falling back to "50x" for 5xx.
+func (p errorPages) lookup(status int) (errorPage, bool) {
+	page, ok := p[status]
+	if !ok && status >= 500 {
+		page, ok = p[anyServerError]
+	}
+	return page, ok
+}
+
+// write serves the page for status, if one is configured.
+func (p errorPages) write(w http.ResponseWriter, status int, requestID string) bool {
+	page, ok := p.lookup(status)
+	if !ok {
+		return false
+	}
+	body := strings.NewReplacer(
+		"{{status}}", strconv.Itoa(status),
+		"{{status_text}}", html.EscapeString(http.StatusText(status)),
+		"{{request_id}}", html.EscapeString(requestID),
+	).Replace(page.body)
+
+	w.Header().Set("Content-Type", page.contentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	_, _ = w.Write([]byte(body))
+	return true
+}
+
+// error serves the page for status, or falls back to http.Error.
+func (p errorPages) error(w http.ResponseWriter, status int, requestID string) {
+	if !p.write(w, status, requestID) {
+		http.Error(w, http.StatusText(status), status)
+	}
+}
+
+// errorOutput controls how worker errors are rendered to clients.
+type errorOutput struct {
+	pages    errorPages
+	debug    bool // include PHP fatal details (development only)
+	override bool // replace PHP's own error bodies too, not just empty ones
+}
+
+// phpError serves the configured page in place of a 404 or 5xx response
+// from PHP, keeping its cookies. Without override, only responses with an
+// empty body are replaced; a relayed body is never empty.
+func (out errorOutput) phpError(w http.ResponseWriter, resp *server.ResponsePayload) bool {
+	if resp.Status != http.StatusNotFound && resp.Status < 500 {
+		return false
+	}
+	if (len(resp.Body) > 0 || resp.Relayed()) && !out.override {
+		return false
+	}
+	if _, ok := out.pages.lookup(resp.Status); !ok {
+		return false
+	}
+	for _, c := range resp.Cookies {
+		w.Header().Add("Set-Cookie", c)
+	}
+	resp.Discard()
+	return out.pages.write(w, resp.Status, resp.ID)
+}
@@ -0,0 +1,102 @@
+package appserver
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go-php/server"
+)
+
+func TestErrorPagesRenderPlaceholders(t *testing.T) {
+	root := t.TempDir()
+	tmpl := `<h1>{{status}} {{status_text}}</h1><p>Reference: {{request_id}}</p>`
+	if err := os.WriteFile(filepath.Join(root, "502.html"), []byte(tmpl), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	pages := loadErrorPages(root, map[string]string{"502": "502.html", "504": "missing.html", "ok": "x.html"}, logConfig.warn)
+	if len(pages) != 1 {
+		t.Fatalf("expected only the readable 502 page, got %d pages", len(pages))
+	}
+
+	rr := httptest.NewRecorder()
+	writeWorkerError(rr, io.ErrUnexpectedEOF, "req-<1>", errorOutput{pages: pages})
+	if rr.Code != http.StatusBadGateway {
+		t.Fatalf("expected 502, got %d", rr.Code)
+	}
+	if ct := rr.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+		t.Fatalf("Content-Type = %q", ct)
+	}
+	want := `<h1>502 Bad Gateway</h1><p>Reference: req-&lt;1&gt;</p>`
+	if rr.Body.String() != want {
+		t.Fatalf("body = %q, want %q", rr.Body.String(), want)
+	}
+
+	// no page for 504: plain text as before
+	rr = httptest.NewRecorder()
+	writeWorkerError(rr, server.ErrWorkerTimeout, "req-2", errorOutput{pages: pages})
+	if rr.Code != http.StatusGatewayTimeout || !strings.Contains(rr.Body.String(), "Gateway Timeout") {
+		t.Fatalf("expected plain 504, got %d %q", rr.Code, rr.Body.String())
+	}
+}
+
+func TestErrorPagesForPHPResponses(t *testing.T) {
+	root := t.TempDir()
+	for name, body := range map[string]string{
+		"404.html": `<h1>{{status}} not here</h1>`,
+		"50x.json": `{"status": {{status}}}`,
+	} {
+		if err := os.WriteFile(filepath.Join(root, name), []byte(body), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	responses := map[string]*server.ResponsePayload{
+		"/empty-404": {Status: http.StatusNotFound, Cookies: []string{"a=1"}},
+		"/php-404":   {Status: http.StatusNotFound, Body: []byte("PHP says no")},
+		"/empty-503": {Status: http.StatusServiceUnavailable},
+		"/empty-403": {Status: http.StatusForbidden},
+	}
+	newServer := func(override bool) *Server {
+		srv, err := New(&AppServerConfig{
+			Root: root,
+			Pools: []PoolConfig{{Name: "fast", Workers: 1, Transport: "tcp", Address: fakePHP(t, func(req *server.RequestPayload) *server.ResponsePayload {
+				return responses[req.Path]
+			})}},
+			ErrorPages:         map[string]string{"404": "404.html", "50x": "50x.json"},
+			ErrorPagesOverride: override,
+		})
+		if err != nil {
+			t.Fatalf("New: %v", err)
+		}
+		t.Cleanup(func() { _ = srv.Shutdown(t.Context()) })
+		return srv
+	}
+
+	for _, tc := range []struct {
+		override       bool
+		path, ct, body string
+		status         int
+	}{
+		{false, "/empty-404", "text/html; charset=utf-8", "<h1>404 not here</h1>", 404},
+		{false, "/php-404", "", "PHP says no", 404},
+		{true, "/php-404", "text/html; charset=utf-8", "<h1>404 not here</h1>", 404},
+		{false, "/empty-503", "application/json", `{"status": 503}`, 503},
+		{false, "/empty-403", "", "", 403},
+	} {
+		rr := httptest.NewRecorder()
+		newServer(tc.override).ServeHTTP(rr, httptest.NewRequest("GET", tc.path, nil))
+		if rr.Code != tc.status || rr.Body.String() != tc.body {
+			t.Errorf("%s (override=%v): got %d %q, want %d %q", tc.path, tc.override, rr.Code, rr.Body.String(), tc.status, tc.body)
+		}
+		if tc.ct != "" && rr.Header().Get("Content-Type") != tc.ct {
+			t.Errorf("%s: Content-Type %q, want %q", tc.path, rr.Header().Get("Content-Type"), tc.ct)
+		}
+		if tc.path == "/empty-404" && rr.Header().Get("Set-Cookie") != "a=1" {
+			t.Errorf("%s: PHP's cookie dropped", tc.path)
+		}
+	}
+}
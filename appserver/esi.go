@@ -0,0 +1,282 @@
+package appserver
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go-php/server"
+)
+
+// ESIConfig enables Edge-Side-Include processing of buffered PHP responses:
+// <esi:include src="/fragment"/> markers are replaced with the body of a
+// subrequest to src, dispatched to the same app in parallel. Fragments that
+// answer with a public Cache-Control max-age are cached for that long.
+type ESIConfig struct {
+	Enabled     bool `json:"enabled"`
+	MaxIncludes int  `json:"max_includes"` // per response (default 32)
+	TimeoutMs   int  `json:"timeout_ms"`   // for all fragments of a response (default 5000)
+	CacheSize   int  `json:"cache_size"`   // max cached fragments (default 1024)
+}
+
+var (
+	esiIncludeRe = regexp.MustCompile(`(?s)<esi:include\s+([^>]*?)\s*/?>(?:\s*</esi:include>)?`)
+	esiRemoveRe  = regexp.MustCompile(`(?s)<esi:remove>.*?</esi:remove>`)
+	esiAttrRe    = regexp.MustCompile(`(\w+)\s*=\s*"([^"]*)"`)
+)
+
+type dispatchFunc func(*server.RequestPayload) (*server.ResponsePayload, error)
+
+// esiProcessor resolves includes for one app.
+type esiProcessor struct {
+	cfg      ESIConfig
+	dispatch dispatchFunc
+	cache    *fragmentCache
+}
+
+func newESIProcessor(cfg ESIConfig, dispatch dispatchFunc) *esiProcessor {
+	if !cfg.Enabled {
+		return nil
+	}
+	if cfg.MaxIncludes <= 0 {
+		cfg.MaxIncludes = 32
+	}
+	if cfg.TimeoutMs <= 0 {
+		cfg.TimeoutMs = 5000
+	}
+	if cfg.CacheSize <= 0 {
+		cfg.CacheSize = 1024
+	}
+	return &esiProcessor{
+		cfg:      cfg,
+		dispatch: dispatch,
+		cache:    &fragmentCache{max: cfg.CacheSize, entries: make(map[string]fragment)},
+	}
+}
+
+// esiInclude is one parsed <esi:include> tag.
+type esiInclude struct {
+	src, alt string
+	cont     bool // onerror="continue"
+	start    int
+	end      int
+}
+
+// process replaces every include in resp.Body. An include that fails (and
+// has no working alt) fails the whole response unless it says
+// onerror="continue", in which case it renders as nothing.
+func (p *esiProcessor) process(parent *server.RequestPayload, resp *server.ResponsePayload) error {
+	if !bytes.Contains(resp.Body, []byte("<esi:")) {
+		return nil
+	}
+	body := esiRemoveRe.ReplaceAllString(string(resp.Body), "")
+
+	var includes []esiInclude
+	for _, m := range esiIncludeRe.FindAllStringSubmatchIndex(body, -1) {
+		inc := esiInclude{start: m[0], end: m[1]}
+		for _, a := range esiAttrRe.FindAllStringSubmatch(body[m[2]:m[3]], -1) {
+			switch strings.ToLower(a[1]) {
+			case "src":
+				inc.src = a[2]
+			case "alt":
+				inc.alt = a[2]
+			case "onerror":
+				inc.cont = a[2] == "continue"
+			}
+		}
+		includes = append(includes, inc)
+	}
+	if len(includes) > p.cfg.MaxIncludes {
+		return fmt.Errorf("esi: %d includes exceeds max_includes=%d", len(includes), p.cfg.MaxIncludes)
+	}
+
+	results := make([]string, len(includes))
+	errs := make([]error, len(includes))
+	var wg sync.WaitGroup
+	for i, inc := range includes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i], errs[i] = p.resolve(parent, i, inc)
+		}()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Duration(p.cfg.TimeoutMs) * time.Millisecond):
+		return fmt.Errorf("esi: fragments did not complete within %dms", p.cfg.TimeoutMs)
+	}
+
+	var out strings.Builder
+	last := 0
+	for i, inc := range includes {
+		if errs[i] != nil {
+			if !inc.cont {
+				return errs[i]
+			}
+			log.Printf("[esi] %s: %v (onerror=continue)", inc.src, errs[i])
+		}
+		out.WriteString(body[last:inc.start])
+		out.WriteString(results[i])
+		last = inc.end
+	}
+	out.WriteString(body[last:])
+
+	resp.Body = []byte(out.String())
+	deleteHeaderFold(resp.Headers, "Content-Length")
+	deleteHeaderFold(resp.Headers, "Surrogate-Control")
+	return nil
+}
+
+// resolve fetches src (falling back to alt), using the fragment cache.
+func (p *esiProcessor) resolve(parent *server.RequestPayload, n int, inc esiInclude) (string, error) {
+	body, err := p.fetch(parent, n, inc.src)
+	if err != nil && inc.alt != "" {
+		body, err = p.fetch(parent, n, inc.alt)
+	}
+	return body, err
+}
+
+func (p *esiProcessor) fetch(parent *server.RequestPayload, n int, src string) (string, error) {
+	if !strings.HasPrefix(src, "/") {
+		return "", fmt.Errorf("esi: src %q must be a local path", src)
+	}
+	if body, ok := p.cache.get(src); ok {
+		return body, nil
+	}
+
+	// Fragments see the parent's headers (cookies, auth) so they can be
+	// personalised; only fragments marked public are cached.
+	headers := make(map[string][]string, len(parent.Headers)+1)
+	for k, v := range parent.Headers {
+		if k == "Content-Length" || k == "Content-Type" {
+			continue
+		}
+		headers[k] = v
+	}
+	headers["X-Esi-Subrequest"] = []string{"1"}
+
+	resp, err := p.dispatch(&server.RequestPayload{
+		ID:      fmt.Sprintf("%s-esi-%d", parent.ID, n),
+		Method:  http.MethodGet,
+		Path:    src,
+		Headers: headers,
+	})
+	if err != nil {
+		return "", fmt.Errorf("esi: %s: %w", src, err)
+	}
+	if resp.Status >= 400 {
+		return "", fmt.Errorf("esi: %s: status %d", src, resp.Status)
+	}
+
+	if ttl := fragmentTTL(resp.Headers); ttl > 0 {
+		p.cache.put(src, string(resp.Body), ttl)
+	}
+	return string(resp.Body), nil
+}
+
+// fragmentTTL returns how long a fragment may be shared: s-maxage or
+// max-age from a public Cache-Control, and never for private responses or
+// ones that set cookies.
+func fragmentTTL(headers map[string]string) time.Duration {
+	cc := ""
+	for k, v := range headers {
+		switch strings.ToLower(k) {
+		case "cache-control":
+			cc = strings.ToLower(v)
+		case "set-cookie":
+			return 0
+		}
+	}
+
+	var maxAge, sMaxAge = -1, -1
+	public := false
+	for _, d := range strings.Split(cc, ",") {
+		name, val, _ := strings.Cut(strings.TrimSpace(d), "=")
+		switch name {
+		case "private", "no-store", "no-cache":
+			return 0
+		case "public":
+			public = true
+		case "max-age":
+			maxAge, _ = strconv.Atoi(val)
+		case "s-maxage":
+			sMaxAge, _ = strconv.Atoi(val)
+			public = true
+		}
+	}
+	if !public {
+		return 0
+	}
+	if sMaxAge > 0 {
+		return time.Duration(sMaxAge) * time.Second
+	}
+	if maxAge > 0 {
+		return time.Duration(maxAge) * time.Second
+	}
+	return 0
+}
+
+func deleteHeaderFold(headers map[string]string, name string) {
+	for k := range headers {
+		if strings.EqualFold(k, name) {
+			delete(headers, k)
+		}
+	}
+}
+
+type fragment struct {
+	body    string
+	expires time.Time
+}
+
+// fragmentCache is a small TTL cache of shared fragment bodies keyed by src.
+type fragmentCache struct {
+	mu      sync.Mutex
+	max     int
+	entries map[string]fragment
+}
+
+func (c *fragmentCache) get(src string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	f, ok := c.entries[src]
+	if !ok {
+		return "", false
+	}
+	if time.Now().After(f.expires) {
+		delete(c.entries, src)
+		return "", false
+	}
+	return f.body, true
+}
+
+func (c *fragmentCache) put(src, body string, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if len(c.entries) >= c.max {
+		for k, f := range c.entries {
+			if now.After(f.expires) {
+				delete(c.entries, k)
+			}
+		}
+		if len(c.entries) >= c.max {
+			return
+		}
+	}
+	c.entries[src] = fragment{body: body, expires: now.Add(ttl)}
+}
@@ -0,0 +1,114 @@
+package appserver
+
+import (
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go-php/server"
+)
+
+func TestESIProcessStitchesFragments(t *testing.T) {
+	var calls atomic.Int32
+	dispatch := func(req *server.RequestPayload) (*server.ResponsePayload, error) {
+		calls.Add(1)
+		if req.Headers["X-Esi-Subrequest"][0] != "1" || req.Headers["Cookie"][0] != "sid=abc" {
+			t.Errorf("subrequest missing forwarded headers: %v", req.Headers)
+		}
+		switch req.Path {
+		case "/nav":
+			return &server.ResponsePayload{Status: 200, Body: []byte("<nav/>"), Headers: map[string]string{"Cache-Control": "public, max-age=60"}}, nil
+		case "/cart":
+			return &server.ResponsePayload{Status: 200, Body: []byte("3 items"), Headers: map[string]string{"Cache-Control": "private"}}, nil
+		}
+		return &server.ResponsePayload{Status: 404}, nil
+	}
+	p := newESIProcessor(ESIConfig{Enabled: true}, dispatch)
+
+	parent := &server.RequestPayload{ID: "r1", Headers: map[string][]string{"Cookie": {"sid=abc"}}}
+	page := `<html><esi:include src="/nav"/> | <esi:include src="/cart"></esi:include>` +
+		`<esi:remove>fallback</esi:remove> | <esi:include src="/gone" onerror="continue"/></html>`
+
+	for i := 0; i < 2; i++ {
+		resp := &server.ResponsePayload{Body: []byte(page), Headers: map[string]string{"Surrogate-Control": `content="ESI/1.0"`}}
+		if err := p.process(parent, resp); err != nil {
+			t.Fatalf("process: %v", err)
+		}
+		if string(resp.Body) != "<html><nav/> | 3 items | </html>" {
+			t.Fatalf("unexpected body: %q", resp.Body)
+		}
+		if _, ok := resp.Headers["Surrogate-Control"]; ok {
+			t.Fatalf("Surrogate-Control should be stripped")
+		}
+	}
+
+	// /nav is cached after the first page; /cart (private) and /gone are not.
+	if got := calls.Load(); got != 5 {
+		t.Fatalf("expected 5 subrequests, got %d", got)
+	}
+}
+
+func TestESIProcessFailures(t *testing.T) {
+	dispatch := func(req *server.RequestPayload) (*server.ResponsePayload, error) {
+		if req.Path == "/ok" {
+			return &server.ResponsePayload{Status: 200, Body: []byte("alt")}, nil
+		}
+		return nil, errors.New("boom")
+	}
+	p := newESIProcessor(ESIConfig{Enabled: true, MaxIncludes: 2}, dispatch)
+	parent := &server.RequestPayload{ID: "r1"}
+
+	resp := &server.ResponsePayload{Body: []byte(`<esi:include src="/bad" alt="/ok"/>`)}
+	if err := p.process(parent, resp); err != nil || string(resp.Body) != "alt" {
+		t.Fatalf("expected alt fallback, got %q, %v", resp.Body, err)
+	}
+
+	resp = &server.ResponsePayload{Body: []byte(`a<esi:include src="/bad"/>b`)}
+	if err := p.process(parent, resp); err == nil {
+		t.Fatalf("expected failing include to fail the response")
+	}
+
+	resp = &server.ResponsePayload{Body: []byte(`<esi:include src="http://evil/"/>`)}
+	if err := p.process(parent, resp); err == nil {
+		t.Fatalf("expected absolute src to be rejected")
+	}
+
+	resp = &server.ResponsePayload{Body: []byte(strings.Repeat(`<esi:include src="/ok"/>`, 3))}
+	if err := p.process(parent, resp); err == nil {
+		t.Fatalf("expected max_includes to be enforced")
+	}
+}
+
+func TestESIProcessTimeout(t *testing.T) {
+	dispatch := func(req *server.RequestPayload) (*server.ResponsePayload, error) {
+		time.Sleep(200 * time.Millisecond)
+		return &server.ResponsePayload{Status: 200}, nil
+	}
+	p := newESIProcessor(ESIConfig{Enabled: true, TimeoutMs: 20}, dispatch)
+
+	resp := &server.ResponsePayload{Body: []byte(`<esi:include src="/slow"/>`)}
+	if err := p.process(&server.RequestPayload{}, resp); err == nil {
+		t.Fatalf("expected timeout error")
+	}
+}
+
+func TestFragmentTTL(t *testing.T) {
+	cases := []struct {
+		headers map[string]string
+		want    time.Duration
+	}{
+		{map[string]string{"Cache-Control": "public, max-age=30"}, 30 * time.Second},
+		{map[string]string{"cache-control": "s-maxage=10, max-age=0"}, 10 * time.Second},
+		{map[string]string{"Cache-Control": "max-age=30"}, 0},
+		{map[string]string{"Cache-Control": "public, max-age=30", "Set-Cookie": "a=1"}, 0},
+		{map[string]string{"Cache-Control": "public, no-store, max-age=30"}, 0},
+		{nil, 0},
+	}
+	for _, c := range cases {
+		if got := fragmentTTL(c.headers); got != c.want {
+			t.Fatalf("fragmentTTL(%v) = %v, want %v", c.headers, got, c.want)
+		}
+	}
+}
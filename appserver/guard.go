@@ -0,0 +1,108 @@
+package appserver
+
+import (
+	"log"
+	"time"
+
+	"go-php/server"
+)
+
+// WorkerGuardConfig guards against configuring more PHP workers than the
+// machine has memory for. At startup the configured total is checked
+// against available memory; Cap scales every pool down to fit instead of
+// only warning. While running, measured worker RSS is re-checked every
+// minute and over-provisioning is logged.
+type WorkerGuardConfig struct {
+	Cap                 bool `json:"cap"`
+	WorkerRSSEstimateMB int  `json:"worker_rss_estimate_mb"` // assumed per-worker size before workers are measured (default 64)
+}
+
+func (g WorkerGuardConfig) estimate() uint64 {
+	if g.WorkerRSSEstimateMB > 0 {
+		return uint64(g.WorkerRSSEstimateMB) << 20
+	}
+	return server.DefaultWorkerRSSEstimate
+}
+
+// workerScale returns the factor to apply to every pool size: 1 unless the
+// configured total exceeds the recommendation and capping is on.
+func workerScale(total int, g WorkerGuardConfig) float64 {
+	h, err := server.ComputeHeadroom(total, 0, 0, g.estimate())
+	if err != nil {
+		log.Printf("[guard] skipping worker memory check: %v", err)
+		return 1
+	}
+	if !h.OverProvisioned {
+		log.Printf("[guard] %d workers configured, memory allows ~%d (%d MiB available, %d MiB/worker)",
+			total, h.RecommendedMaxWorkers, h.AvailableBytes>>20, h.PerWorkerBytes>>20)
+		return 1
+	}
+
+	log.Printf("[guard] WARNING: %d workers configured but memory only allows ~%d (%d MiB available, %d MiB/worker)",
+		total, h.RecommendedMaxWorkers, h.AvailableBytes>>20, h.PerWorkerBytes>>20)
+	if !g.Cap {
+		return 1
+	}
+
+	scale := float64(max(h.RecommendedMaxWorkers, 1)) / float64(total)
+	log.Printf("[guard] capping pools to %.0f%% of their configured size", scale*100)
+	return scale
+}
+
+// scaleWorkers applies a guard scale factor, never going below one worker.
+func scaleWorkers(n int, scale float64) int {
+	if scale >= 1 {
+		return n
+	}
+	return max(int(float64(n)*scale), 1)
+}
+
+// configuredWorkers counts workers across the default app and every vhost.
+func configuredWorkers(cfg *AppServerConfig) int {
+	total := 0
+	for _, p := range cfg.serverPools(nil) {
+		total += p.Workers
+	}
+	for _, a := range cfg.Apps {
+		for _, p := range a.appConfig(cfg).serverPools(nil) {
+			total += p.Workers
+		}
+	}
+	return total
+}
+
+// watchWorkerMemory re-checks measured worker memory across all apps and
+// logs when the pools no longer fit, until done is closed.
+func watchWorkerMemory(vhosts *vhostRouter, g WorkerGuardConfig, interval time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	warned := false
+	for {
+		select {
+		case <-ticker.C:
+		case <-done:
+			return
+		}
+
+		var rss uint64
+		var sampled, workers int
+		for _, app := range vhosts.all() {
+			r, s, w := app.srv.WorkerMemory()
+			rss, sampled, workers = rss+r, sampled+s, workers+w
+		}
+
+		h, err := server.ComputeHeadroom(workers, rss, sampled, g.estimate())
+		if err != nil {
+			return
+		}
+		switch {
+		case h.OverProvisioned && !warned:
+			log.Printf("[guard] WARNING: %d workers at ~%d MiB each exceed the ~%d that fit in memory (%d MiB available)",
+				workers, h.PerWorkerBytes>>20, h.RecommendedMaxWorkers, h.AvailableBytes>>20)
+		case !h.OverProvisioned && warned:
+			log.Printf("[guard] worker memory back within limits (%d of ~%d workers)", workers, h.RecommendedMaxWorkers)
+		}
+		warned = h.OverProvisioned
+	}
+}
@@ -0,0 +1,57 @@
+package appserver
+
+import (
+	"runtime"
+	"testing"
+)
+
+func TestScaleWorkers(t *testing.T) {
+	cases := []struct {
+		n     int
+		scale float64
+		want  int
+	}{
+		{8, 1, 8},
+		{8, 0.5, 4},
+		{3, 0.1, 1},
+	}
+	for _, c := range cases {
+		if got := scaleWorkers(c.n, c.scale); got != c.want {
+			t.Fatalf("scaleWorkers(%d, %v) = %d, want %d", c.n, c.scale, got, c.want)
+		}
+	}
+}
+
+func TestConfiguredWorkersIncludesApps(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.FastWorkers, cfg.SlowWorkers = 4, 2
+	cfg.Apps = []AppConfig{
+		{Name: "shop", Hosts: []string{"shop.test"}, Root: "shop", Pools: []PoolConfig{{Name: "web", Workers: 3}}},
+		{Name: "blog", Hosts: []string{"blog.test"}, Root: "blog"}, // inherits 4+2
+	}
+
+	if got := configuredWorkers(cfg); got != 15 {
+		t.Fatalf("configuredWorkers = %d, want 15", got)
+	}
+}
+
+func TestWorkerScaleCapsWhenOverProvisioned(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("memory stats only available on linux")
+	}
+
+	// 1 TiB per worker never fits, so capping kicks in; without Cap it only warns.
+	huge := WorkerGuardConfig{WorkerRSSEstimateMB: 1 << 20}
+	if got := workerScale(10, huge); got != 1 {
+		t.Fatalf("workerScale without cap = %v, want 1", got)
+	}
+
+	huge.Cap = true
+	if got := workerScale(10, huge); got >= 1 {
+		t.Fatalf("workerScale with cap = %v, want < 1", got)
+	}
+
+	if got := workerScale(1, WorkerGuardConfig{WorkerRSSEstimateMB: 1, Cap: true}); got != 1 {
+		t.Fatalf("workerScale for a tiny pool = %v, want 1", got)
+	}
+}
@@ -0,0 +1,242 @@
+package appserver
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// StaticGzipConfig compresses static assets on the fly for clients that
+// accept gzip. Each file is compressed once and cached (in memory, or under
+// CacheDir if set) until its mtime or size changes.
+type StaticGzipConfig struct {
+	Enabled       bool   `json:"enabled"`
+	MinBytes      int64  `json:"min_bytes"`       // smaller files aren't worth compressing (default 1 KiB)
+	MaxBytes      int64  `json:"max_bytes"`       // larger files are served as-is (default 10 MiB)
+	CacheDir      string `json:"cache_dir"`       // keep compressed files on disk instead of in memory
+	MaxCacheBytes int64  `json:"max_cache_bytes"` // in-memory budget (default 64 MiB)
+}
+
+const (
+	defaultGzipMinBytes      = 1 << 10
+	defaultGzipMaxBytes      = 10 << 20
+	defaultGzipMaxCacheBytes = 64 << 20
+)
+
+// gzipEntry is one compressed artifact, valid while the source file keeps
+// the same modTime and size.
+type gzipEntry struct {
+	modTime time.Time
+	size    int64
+	data    []byte // in-memory artifact
+	file    string // on-disk artifact (CacheDir mode)
+}
+
+type gzipCache struct {
+	cfg StaticGzipConfig
+
+	mu      sync.Mutex
+	entries map[string]*gzipEntry // keyed by source path
+	used    int64                 // bytes held in memory
+}
+
+// newGzipCache returns nil when compression is disabled, which serveStatic
+// treats as "serve files as-is". A relative CacheDir is resolved against root.
+func newGzipCache(cfg StaticGzipConfig, root string) *gzipCache {
+	if !cfg.Enabled {
+		return nil
+	}
+	if cfg.CacheDir != "" && !filepath.IsAbs(cfg.CacheDir) {
+		cfg.CacheDir = filepath.Join(root, cfg.CacheDir)
+	}
+	if cfg.MinBytes <= 0 {
+		cfg.MinBytes = defaultGzipMinBytes
+	}
+	if cfg.MaxBytes <= 0 {
+		cfg.MaxBytes = defaultGzipMaxBytes
+	}
+	if cfg.MaxCacheBytes <= 0 {
+		cfg.MaxCacheBytes = defaultGzipMaxCacheBytes
+	}
+	if cfg.CacheDir != "" {
+		if err := os.MkdirAll(cfg.CacheDir, 0o755); err != nil {
+			log.Printf("[gzip] cache_dir %s unusable (%v), caching in memory", cfg.CacheDir, err)
+			cfg.CacheDir = ""
+		}
+	}
+	return &gzipCache{cfg: cfg, entries: make(map[string]*gzipEntry)}
+}
+
+// compressible reports whether the file's type benefits from gzip.
+func compressible(path string) bool {
+	ct := mime.TypeByExtension(filepath.Ext(path))
+	if ct == "" {
+		return false
+	}
+	ct, _, _ = strings.Cut(ct, ";")
+	switch {
+	case strings.HasPrefix(ct, "text/"):
+		return true
+	case strings.HasSuffix(ct, "+xml"), strings.HasSuffix(ct, "+json"):
+		return true
+	}
+	switch ct {
+	case "application/javascript", "application/json", "application/xml",
+		"application/wasm", "application/manifest+json", "font/ttf", "font/otf":
+		return true
+	}
+	return false
+}
+
+// acceptsGzip parses Accept-Encoding, honouring "gzip;q=0".
+func acceptsGzip(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if !strings.EqualFold(coding, "gzip") && coding != "*" {
+			continue
+		}
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if q, err := strconv.ParseFloat(v, 64); err == nil && q == 0 {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// serve writes the gzip variant of path if the request and file qualify,
+// returning false when the caller should serve the file as-is.
+func (c *gzipCache) serve(w http.ResponseWriter, r *http.Request, path string, info os.FileInfo) bool {
+	if !compressible(path) {
+		return false
+	}
+	// Either way the response depends on Accept-Encoding.
+	w.Header().Add("Vary", "Accept-Encoding")
+
+	if info.Size() < c.cfg.MinBytes || info.Size() > c.cfg.MaxBytes {
+		return false
+	}
+	// Byte ranges of a compressed body confuse most clients; serve identity.
+	if r.Header.Get("Range") != "" || !acceptsGzip(r) {
+		return false
+	}
+
+	entry, err := c.get(path, info)
+	if err != nil {
+		log.Printf("[gzip] %s: %v", path, err)
+		return false
+	}
+
+	var content io.ReadSeeker
+	if entry.file != "" {
+		f, err := os.Open(entry.file)
+		if err != nil {
+			log.Printf("[gzip] %s: %v", entry.file, err)
+			return false
+		}
+		defer f.Close()
+		content = f
+	} else {
+		content = bytes.NewReader(entry.data)
+	}
+
+	// Set the type up front (unless a static rule did) so ServeContent
+	// doesn't sniff compressed bytes.
+	if w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", mime.TypeByExtension(filepath.Ext(path)))
+	}
+	w.Header().Set("Content-Encoding", "gzip")
+	http.ServeContent(w, r, path, info.ModTime(), content)
+	return true
+}
+
+// get returns a fresh compressed artifact for path, compressing it if the
+// cached one is missing or stale.
+func (c *gzipCache) get(path string, info os.FileInfo) (*gzipEntry, error) {
+	c.mu.Lock()
+	entry := c.entries[path]
+	c.mu.Unlock()
+	if entry != nil && entry.modTime.Equal(info.ModTime()) && entry.size == info.Size() {
+		return entry, nil
+	}
+
+	// Compress outside the lock; two concurrent misses just do the work twice.
+	data, err := gzipFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	entry = &gzipEntry{modTime: info.ModTime(), size: info.Size()}
+	if c.cfg.CacheDir != "" {
+		sum := sha256.Sum256([]byte(path))
+		entry.file = filepath.Join(c.cfg.CacheDir, hex.EncodeToString(sum[:])+".gz")
+		if err := writeFileAtomic(entry.file, data); err != nil {
+			return nil, err
+		}
+	} else {
+		entry.data = data
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if old := c.entries[path]; old != nil {
+		c.used -= int64(len(old.data))
+		delete(c.entries, path)
+	}
+	if c.used+int64(len(entry.data)) > c.cfg.MaxCacheBytes {
+		// Over budget: still serve this response compressed, just don't keep it.
+		return entry, nil
+	}
+	c.entries[path] = entry
+	c.used += int64(len(entry.data))
+	return entry, nil
+}
+
+func gzipFile(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var buf bytes.Buffer
+	zw, _ := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	if _, err := io.Copy(zw, f); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// writeFileAtomic writes via a temp file + rename so concurrent readers never
+// see a partial artifact.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
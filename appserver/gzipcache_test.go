@@ -0,0 +1,130 @@
+package appserver
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeAsset(t *testing.T, root, name, content string) string {
+	t.Helper()
+	dir := filepath.Join(root, "public")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	return path
+}
+
+func gunzip(t *testing.T, b []byte) string {
+	t.Helper()
+	zr, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		t.Fatalf("gzip reader: %v", err)
+	}
+	out, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("gunzip: %v", err)
+	}
+	return string(out)
+}
+
+func TestServeStaticGzipCachesAndRefreshes(t *testing.T) {
+	for _, mode := range []string{"memory", "disk"} {
+		t.Run(mode, func(t *testing.T) {
+			root := t.TempDir()
+			cfg := StaticGzipConfig{Enabled: true, MinBytes: 10}
+			if mode == "disk" {
+				cfg.CacheDir = "storage/gzip"
+			}
+			gz := newGzipCache(cfg, root)
+			rules := []StaticRule{{Prefix: "/", Dir: "public"}}
+
+			js := strings.Repeat("console.log('hello');\n", 100)
+			path := writeAsset(t, root, "app.js", js)
+
+			get := func(acceptEncoding string) *httptest.ResponseRecorder {
+				r := httptest.NewRequest(http.MethodGet, "/app.js", nil)
+				r.Header.Set("Accept-Encoding", acceptEncoding)
+				w := httptest.NewRecorder()
+				if !serveStatic(w, r, root, rules, gz, nil) {
+					t.Fatalf("expected static hit")
+				}
+				return w
+			}
+
+			w := get("br, gzip")
+			if w.Header().Get("Content-Encoding") != "gzip" || !strings.Contains(w.Header().Get("Content-Type"), "javascript") {
+				t.Fatalf("unexpected headers: %v", w.Header())
+			}
+			if w.Header().Get("Vary") != "Accept-Encoding" {
+				t.Fatalf("expected Vary: Accept-Encoding, got %q", w.Header().Get("Vary"))
+			}
+			if got := gunzip(t, w.Body.Bytes()); got != js {
+				t.Fatalf("round trip mismatch")
+			}
+			if w.Body.Len() >= len(js) {
+				t.Fatalf("expected compressed body to be smaller (%d >= %d)", w.Body.Len(), len(js))
+			}
+			if mode == "disk" {
+				files, _ := filepath.Glob(filepath.Join(root, "storage/gzip", "*.gz"))
+				if len(files) != 1 {
+					t.Fatalf("expected one cached artifact on disk, got %v", files)
+				}
+			}
+
+			// Changing the file (new mtime) invalidates the cached artifact.
+			js2 := strings.Repeat("console.log('changed');\n", 100)
+			if err := os.WriteFile(path, []byte(js2), 0o644); err != nil {
+				t.Fatalf("rewrite: %v", err)
+			}
+			later := time.Now().Add(time.Minute)
+			_ = os.Chtimes(path, later, later)
+
+			if got := gunzip(t, get("gzip").Body.Bytes()); got != js2 {
+				t.Fatalf("expected refreshed artifact after mtime change")
+			}
+
+			// Clients that don't accept gzip get the original bytes.
+			w = get("gzip;q=0, identity")
+			if w.Header().Get("Content-Encoding") != "" || w.Body.String() != js2 {
+				t.Fatalf("expected identity response, got encoding %q", w.Header().Get("Content-Encoding"))
+			}
+		})
+	}
+}
+
+func TestServeStaticGzipSkipsSmallAndBinary(t *testing.T) {
+	root := t.TempDir()
+	gz := newGzipCache(StaticGzipConfig{Enabled: true}, root)
+	rules := []StaticRule{{Prefix: "/", Dir: "public"}}
+
+	writeAsset(t, root, "tiny.css", "a{}")
+	writeAsset(t, root, "photo.png", strings.Repeat("x", 4096))
+
+	for _, name := range []string{"/tiny.css", "/photo.png"} {
+		r := httptest.NewRequest(http.MethodGet, name, nil)
+		r.Header.Set("Accept-Encoding", "gzip")
+		w := httptest.NewRecorder()
+		serveStatic(w, r, root, rules, gz, nil)
+		if w.Header().Get("Content-Encoding") != "" {
+			t.Fatalf("%s should not be compressed", name)
+		}
+	}
+}
+
+func TestNewGzipCacheDisabled(t *testing.T) {
+	if newGzipCache(StaticGzipConfig{}, t.TempDir()) != nil {
+		t.Fatalf("expected nil cache when disabled")
+	}
+}
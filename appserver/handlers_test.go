@@ -1,5 +1,5 @@
-// cmd/server/handlers_test.go
-package main
+// appserver/handlers_test.go
+package appserver
 
 import (
 	"bytes"
@@ -151,7 +151,7 @@ func setupTestServer(t *testing.T) (*httptest.Server, *server.Server) {
 
 	// WebSocket user endpoint
 	mux.HandleFunc("/__ws/user", func(w http.ResponseWriter, r *http.Request) {
-		userID, err := authenticateWS(r)
+		userID, err := authenticateWS(nil, r)
 		if err != nil || userID == "" {
 			http.Error(w, "unauthorized", http.StatusUnauthorized)
 			return
@@ -0,0 +1,144 @@
+package appserver
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"go-php/server"
+)
+
+// Hooks receives request lifecycle events, for accounting and alerting
+// compiled into your own binary. Embed NopHooks to implement only some of
+// them. Callbacks run synchronously on the goroutine that caused the event,
+// so they should be quick and must not keep the payloads they are given.
+type Hooks interface {
+	// OnRequest is called when a request reaches an app, before static
+	// files, proxies or PHP.
+	OnRequest(RequestEvent)
+	// OnDispatch is called just before a request is handed to PHP, after
+	// dispatch middleware.
+	OnDispatch(DispatchEvent)
+	// OnWorkerError is called when PHP could not produce a response.
+	OnWorkerError(WorkerErrorEvent)
+	// OnResponse is called once a PHP response has been written.
+	OnResponse(ResponseEvent)
+	// OnWorkerRestart is called when a worker gets a fresh process.
+	OnWorkerRestart(WorkerRestartEvent)
+	// OnHubPublish is called for every message published to a WebSocket or
+	// SSE channel.
+	OnHubPublish(PublishEvent)
+}
+
+// RequestEvent is passed to Hooks.OnRequest.
+type RequestEvent struct {
+	Request *http.Request
+	App     string
+	Time    time.Time
+}
+
+// DispatchEvent is passed to Hooks.OnDispatch.
+type DispatchEvent struct {
+	Request *http.Request
+	App     string
+	Payload *server.RequestPayload
+	Stream  bool
+}
+
+// WorkerErrorEvent is passed to Hooks.OnWorkerError.
+type WorkerErrorEvent struct {
+	Request  *http.Request
+	App      string
+	Payload  *server.RequestPayload
+	Err      error
+	Status   int // what the client was sent
+	Duration time.Duration
+}
+
+// ResponseEvent is passed to Hooks.OnResponse.
+type ResponseEvent struct {
+	Request  *http.Request
+	App      string
+	Payload  *server.RequestPayload
+	Status   int // 0 for streamed responses, whose status the worker sent
+	Duration time.Duration
+	Stream   bool
+}
+
+// WorkerRestartEvent is passed to Hooks.OnWorkerRestart.
+type WorkerRestartEvent struct {
+	App string
+	server.WorkerRestart
+}
+
+// PublishEvent is passed to Hooks.OnHubPublish.
+type PublishEvent struct {
+	Hub     string // "ws" or "sse"
+	Channel string
+	Type    string // the WebSocket message type or SSE event name
+	Data    any
+}
+
+// NopHooks implements Hooks with callbacks that do nothing.
+type NopHooks struct{}
+
+func (NopHooks) OnRequest(RequestEvent)             {}
+func (NopHooks) OnDispatch(DispatchEvent)           {}
+func (NopHooks) OnWorkerError(WorkerErrorEvent)     {}
+func (NopHooks) OnResponse(ResponseEvent)           {}
+func (NopHooks) OnWorkerRestart(WorkerRestartEvent) {}
+func (NopHooks) OnHubPublish(PublishEvent)          {}
+
+// AddHooks registers h for lifecycle events. Hooks run in the order they
+// were added.
+func (s *Server) AddHooks(h Hooks) {
+	s.hooks.add(h)
+}
+
+// hookSet is the registered Hooks. It exists before the Server does, so
+// the worker pools can report restarts to it.
+type hookSet struct {
+	mu    sync.RWMutex
+	hooks []Hooks
+}
+
+func (hs *hookSet) add(h Hooks) {
+	hs.mu.Lock()
+	hs.hooks = append(hs.hooks[:len(hs.hooks):len(hs.hooks)], h)
+	hs.mu.Unlock()
+}
+
+func (hs *hookSet) each(fn func(Hooks)) {
+	hs.mu.RLock()
+	hooks := hs.hooks
+	hs.mu.RUnlock()
+	for _, h := range hooks {
+		fn(h)
+	}
+}
+
+// workerFailed reports a request PHP could not answer.
+func (s *Server) workerFailed(r *http.Request, app *vhost, payload *server.RequestPayload, err error, status int, d time.Duration) {
+	s.hooks.each(func(h Hooks) {
+		h.OnWorkerError(WorkerErrorEvent{Request: r, App: app.name, Payload: payload, Err: err, Status: status, Duration: d})
+	})
+}
+
+// responded reports a PHP response that was written to the client.
+func (s *Server) responded(r *http.Request, app *vhost, payload *server.RequestPayload, status int, d time.Duration, stream bool) {
+	s.hooks.each(func(h Hooks) {
+		h.OnResponse(ResponseEvent{Request: r, App: app.name, Payload: payload, Status: status, Duration: d, Stream: stream})
+	})
+}
+
+// workerRestarted reports restarts in app's pools.
+func (hs *hookSet) workerRestarted(app string) func(server.WorkerRestart) {
+	return func(ev server.WorkerRestart) {
+		hs.each(func(h Hooks) { h.OnWorkerRestart(WorkerRestartEvent{App: app, WorkerRestart: ev}) })
+	}
+}
+
+// published reports a hub message.
+func (hs *hookSet) published(hub, channel, typ string, data any) {
+	hs.each(func(h Hooks) { h.OnHubPublish(PublishEvent{Hub: hub, Channel: channel, Type: typ, Data: data}) })
+}
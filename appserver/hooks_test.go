@@ -0,0 +1,61 @@
+package appserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"go-php/server"
+)
+
+type recordingHooks struct {
+	NopHooks
+	mu     sync.Mutex
+	events []string
+}
+
+func (h *recordingHooks) record(ev string) {
+	h.mu.Lock()
+	h.events = append(h.events, ev)
+	h.mu.Unlock()
+}
+
+func (h *recordingHooks) OnRequest(ev RequestEvent) { h.record("request " + ev.App) }
+func (h *recordingHooks) OnDispatch(ev DispatchEvent) {
+	h.record("dispatch " + ev.Payload.Path)
+}
+func (h *recordingHooks) OnResponse(ev ResponseEvent) {
+	h.record("response " + http.StatusText(ev.Status))
+}
+func (h *recordingHooks) OnHubPublish(ev PublishEvent) {
+	h.record("publish " + ev.Hub + " " + ev.Channel + " " + ev.Type)
+}
+
+func TestHooksSeeRequestLifecycle(t *testing.T) {
+	srv := newFakePHPServer(t, func(req *server.RequestPayload) *server.ResponsePayload {
+		return &server.ResponsePayload{Status: http.StatusCreated, Body: []byte("ok")}
+	})
+	hooks := &recordingHooks{}
+	srv.AddHooks(hooks)
+
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/orders", nil))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("status = %d, want 201", rec.Code)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/__sse/publish", strings.NewReader(`{"channel":"news","event":"update","data":1}`))
+	req.RemoteAddr = "127.0.0.1:4000"
+	rec = httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("publish status = %d, want 202", rec.Code)
+	}
+
+	want := "request default,dispatch /orders,response Created,publish sse news update"
+	if got := strings.Join(hooks.events, ","); got != want {
+		t.Fatalf("events = %q, want %q", got, want)
+	}
+}
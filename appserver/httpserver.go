@@ -0,0 +1,149 @@
+package appserver
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// HTTPServerConfig tunes the main listener's connection handling. Idle
+// keep-alive connections are bounded by client_timeouts.idle_ms.
+type HTTPServerConfig struct {
+	// MaxHeaderBytes caps request line plus headers (default 1 MiB).
+	MaxHeaderBytes int `json:"max_header_bytes"`
+	// DisableKeepAlives closes every connection after one request.
+	DisableKeepAlives bool `json:"disable_keep_alives"`
+	// MaxTLSHandshakes caps TLS handshakes in progress at once, so a burst
+	// of new connections can't take all the CPU from requests in flight.
+	// 0 means no limit.
+	MaxTLSHandshakes int `json:"max_tls_handshakes"`
+}
+
+// apply sets c's limits on srv.
+func (c HTTPServerConfig) apply(srv *http.Server) {
+	srv.MaxHeaderBytes = c.MaxHeaderBytes
+	if c.DisableKeepAlives {
+		srv.SetKeepAlivesEnabled(false)
+	}
+}
+
+// handshakeBacklog is how many connections may wait per handshake slot.
+const handshakeBacklog = 4
+
+// handshakeListener completes TLS handshakes before handing connections
+// to http.Server, with at most cap(slots) running at a time. Connections
+// wait up to the handshake timeout for a slot, and those beyond
+// handshakeBacklog per slot are closed at once, so a flood can't pile up
+// sockets and goroutines.
+type handshakeListener struct {
+	net.Listener
+	cfg        *tls.Config
+	timeout    time.Duration
+	slots      chan struct{}
+	pending    atomic.Int64 // waiting for a slot or handshaking
+	maxPending int64
+	conns      chan net.Conn
+	done       chan struct{}
+	err        error // why Accept stopped; set before done is closed
+}
+
+func newHandshakeListener(ln net.Listener, cfg *tls.Config, max int, timeout time.Duration) net.Listener {
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	hl := &handshakeListener{
+		Listener:   ln,
+		cfg:        cfg,
+		timeout:    timeout,
+		slots:      make(chan struct{}, max),
+		maxPending: int64(max * (1 + handshakeBacklog)),
+		conns:      make(chan net.Conn),
+		done:       make(chan struct{}),
+	}
+	go hl.acceptLoop()
+	return hl
+}
+
+func (hl *handshakeListener) acceptLoop() {
+	for {
+		c, err := hl.Listener.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				continue
+			}
+			hl.err = err
+			close(hl.done)
+			return
+		}
+		go hl.handshake(c)
+	}
+}
+
+func (hl *handshakeListener) handshake(c net.Conn) {
+	n := hl.pending.Add(1)
+	defer hl.pending.Add(-1)
+	if n > hl.maxPending {
+		c.Close()
+		return
+	}
+
+	wait := time.NewTimer(hl.timeout)
+	select {
+	case hl.slots <- struct{}{}:
+		wait.Stop()
+	case <-wait.C:
+		c.Close()
+		return
+	case <-hl.done:
+		wait.Stop()
+		c.Close()
+		return
+	}
+	tc := tls.Server(c, hl.cfg)
+	_ = c.SetDeadline(time.Now().Add(hl.timeout))
+	err := tc.Handshake()
+	<-hl.slots
+	if err != nil {
+		c.Close()
+		return
+	}
+	_ = c.SetDeadline(time.Time{})
+
+	select {
+	case hl.conns <- tc:
+	case <-hl.done:
+		tc.Close()
+	}
+}
+
+func (hl *handshakeListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-hl.conns:
+		return c, nil
+	case <-hl.done:
+		if hl.err == nil {
+			return nil, net.ErrClosed
+		}
+		return nil, hl.err
+	}
+}
+
+// validateHTTPServer reports limits http.Server can't use.
+func validateHTTPServer(cfg *AppServerConfig, warn configWarnFunc) {
+	h := &cfg.HTTPServer
+	if h.MaxHeaderBytes < 0 {
+		warn("http_server.max_header_bytes", "http_server.max_header_bytes=%d is invalid, using 1 MiB", h.MaxHeaderBytes)
+		h.MaxHeaderBytes = 0
+	} else if h.MaxHeaderBytes > 0 && h.MaxHeaderBytes < 4096 {
+		warn("http_server.max_header_bytes", "http_server.max_header_bytes=%d is small enough to reject ordinary requests with cookies", h.MaxHeaderBytes)
+	}
+	if h.MaxTLSHandshakes < 0 {
+		warn("http_server.max_tls_handshakes", "http_server.max_tls_handshakes=%d is invalid, using no limit", h.MaxTLSHandshakes)
+		h.MaxTLSHandshakes = 0
+	}
+	if h.MaxTLSHandshakes > 0 && !cfg.TLS.enabled() {
+		warn("http_server.max_tls_handshakes", "http_server.max_tls_handshakes has no effect without tls")
+	}
+}
@@ -0,0 +1,124 @@
+package appserver
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHandshakeListenerLimitsConcurrentHandshakes(t *testing.T) {
+	cert := issueCert(t, &x509.Certificate{Subject: pkix.Name{CommonName: "localhost"}, IPAddresses: []net.IP{net.IPv4(127, 0, 0, 1)}}, nil)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	const timeout = 300 * time.Millisecond
+	hl := newHandshakeListener(ln, &tls.Config{Certificates: []tls.Certificate{cert.tls()}}, 1, timeout)
+
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS == nil {
+			t.Error("request has no TLS state")
+		}
+		io.WriteString(w, "ok")
+	})}
+	HTTPServerConfig{MaxHeaderBytes: 4096}.apply(srv)
+	go srv.Serve(hl)
+	defer srv.Close()
+
+	// A client that connects and never says hello holds the only slot
+	// until the handshake times out.
+	stalled, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stalled.Close()
+	time.Sleep(50 * time.Millisecond)
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+	start := time.Now()
+	resp, err := client.Get("https://" + ln.Addr().String() + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "ok" {
+		t.Fatalf("body = %q", body)
+	}
+	if waited := time.Since(start); waited < timeout/2 {
+		t.Errorf("handshake ran alongside the stalled one (took %v)", waited)
+	}
+
+	req, _ := http.NewRequest("GET", "https://"+ln.Addr().String()+"/", nil)
+	req.Header.Set("X-Big", strings.Repeat("a", 16<<10))
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusRequestHeaderFieldsTooLarge {
+		t.Errorf("oversized headers: got %d, want 431", resp.StatusCode)
+	}
+}
+
+func TestHandshakeListenerBoundsQueue(t *testing.T) {
+	cert := issueCert(t, &x509.Certificate{Subject: pkix.Name{CommonName: "localhost"}}, nil)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	const timeout = 500 * time.Millisecond
+	hl := newHandshakeListener(ln, &tls.Config{Certificates: []tls.Certificate{cert.tls()}}, 1, timeout)
+	go func() {
+		for {
+			c, err := hl.Accept()
+			if err != nil {
+				return
+			}
+			c.Close()
+		}
+	}()
+	defer hl.Close()
+
+	// One connection holds the slot and four may wait for it; of six
+	// silent clients, exactly one is closed at once.
+	var conns []net.Conn
+	for range 1 + 1 + handshakeBacklog {
+		c, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		conns = append(conns, c)
+	}
+	time.Sleep(timeout / 5)
+	closed := 0
+	for _, c := range conns {
+		_ = c.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+		if _, err := c.Read(make([]byte, 1)); err == io.EOF {
+			closed++
+		}
+	}
+	if closed != 1 {
+		t.Fatalf("%d connections closed early, want 1", closed)
+	}
+
+	// The rest are dropped once they've waited out the timeout for a slot
+	// or for the handshake, well before they'd all have had a turn.
+	start := time.Now()
+	for _, c := range conns {
+		_ = c.SetReadDeadline(start.Add(3 * timeout))
+		if _, err := c.Read(make([]byte, 1)); err != io.EOF {
+			t.Fatalf("read = %v, want the connection closed", err)
+		}
+	}
+	if waited := time.Since(start); waited > 2*timeout {
+		t.Errorf("queued connections took %v to be dropped", waited)
+	}
+}
@@ -0,0 +1,174 @@
+package appserver
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"sort"
+	"strings"
+)
+
+// IPRules allow or deny clients by address. Entries are CIDRs
+// ("10.0.0.0/8") or single addresses. Deny wins; a non-empty Allow admits
+// only the addresses it lists.
+type IPRules struct {
+	Allow []string `json:"allow"`
+	Deny  []string `json:"deny"`
+}
+
+// IPPathRules applies IPRules to requests under Prefix.
+type IPPathRules struct {
+	Prefix string `json:"prefix"`
+	IPRules
+}
+
+// IPAccessConfig holds rules for every request plus stricter ones for path
+// prefixes (e.g. /admin/ only from office ranges). A request must pass the
+// global rules and the longest matching prefix's rules.
+type IPAccessConfig struct {
+	IPRules
+	Paths []IPPathRules `json:"paths"`
+}
+
+func parseIPRule(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		p, err := netip.ParsePrefix(s)
+		return p.Masked(), err
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// ipMatcher is a compiled IPRules.
+type ipMatcher struct {
+	allow, deny []netip.Prefix
+	// restricted is set when an allow list was configured, even if none of
+	// its entries parsed, so a typo can't open the gates.
+	restricted bool
+}
+
+func newIPMatcher(rules IPRules) ipMatcher {
+	m := ipMatcher{restricted: len(rules.Allow) > 0}
+	for _, s := range rules.Allow {
+		if p, err := parseIPRule(s); err == nil {
+			m.allow = append(m.allow, p)
+		}
+	}
+	for _, s := range rules.Deny {
+		if p, err := parseIPRule(s); err == nil {
+			m.deny = append(m.deny, p)
+		}
+	}
+	return m
+}
+
+func containsAddr(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, p := range prefixes {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m ipMatcher) allowed(addr netip.Addr) bool {
+	if containsAddr(m.deny, addr) {
+		return false
+	}
+	return !m.restricted || containsAddr(m.allow, addr)
+}
+
+type ipPathMatcher struct {
+	prefix string
+	ipMatcher
+}
+
+// ipAccess is a compiled IPAccessConfig.
+type ipAccess struct {
+	global ipMatcher
+	paths  []ipPathMatcher // longest prefix first
+}
+
+func newIPAccess(cfg *IPAccessConfig) *ipAccess {
+	a := &ipAccess{global: newIPMatcher(cfg.IPRules)}
+	for _, p := range cfg.Paths {
+		a.paths = append(a.paths, ipPathMatcher{prefix: p.Prefix, ipMatcher: newIPMatcher(p.IPRules)})
+	}
+	sort.SliceStable(a.paths, func(i, j int) bool {
+		return len(a.paths[i].prefix) > len(a.paths[j].prefix)
+	})
+	return a
+}
+
+// allowed reports whether a client at addr may request path.
+func (a *ipAccess) allowed(addr netip.Addr, path string) bool {
+	if !a.global.allowed(addr) {
+		return false
+	}
+	for _, p := range a.paths {
+		if strings.HasPrefix(path, p.prefix) {
+			return p.allowed(addr)
+		}
+	}
+	return true
+}
+
+// clientAddr is the address r came from. An address that can't be parsed
+// (a unix socket peer, say) is the zero Addr, which no rule contains.
+func clientAddr(r *http.Request) netip.Addr {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, _ := netip.ParseAddr(host)
+	return addr.Unmap()
+}
+
+// ipAccessHandler refuses requests the rules deny with 403, before static
+// files, proxies or PHP see them, and counts them in metrics.
+func ipAccessHandler(a *ipAccess, metrics *Metrics, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		addr := clientAddr(r)
+		if !a.allowed(addr, r.URL.Path) {
+			metrics.RecordDenied()
+			log.Printf("[ip] denied %s %s %s", r.RemoteAddr, r.Method, r.URL.Path)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// validateIPAccess reports entries that don't parse (they are skipped) and
+// fixes path prefixes.
+func validateIPAccess(cfg *AppServerConfig, warn configWarnFunc) {
+	a := cfg.IPAccess
+	if a == nil {
+		return
+	}
+	check := func(path string, rules IPRules) {
+		for i, s := range rules.Allow {
+			if _, err := parseIPRule(s); err != nil {
+				warn(fmt.Sprintf("%s.allow[%d]", path, i), "%s.allow[%d]=%q is not an address or CIDR, ignoring it", path, i, s)
+			}
+		}
+		for i, s := range rules.Deny {
+			if _, err := parseIPRule(s); err != nil {
+				warn(fmt.Sprintf("%s.deny[%d]", path, i), "%s.deny[%d]=%q is not an address or CIDR, ignoring it", path, i, s)
+			}
+		}
+	}
+	check("ip_access", a.IPRules)
+	for i, p := range a.Paths {
+		if !strings.HasPrefix(p.Prefix, "/") {
+			warn(fmt.Sprintf("ip_access.paths[%d].prefix", i), "ip_access.paths[%d].prefix=%q does not start with '/', fixing", i, p.Prefix)
+			a.Paths[i].Prefix = "/" + p.Prefix
+		}
+		check(fmt.Sprintf("ip_access.paths[%d]", i), p.IPRules)
+	}
+}
@@ -0,0 +1,76 @@
+package appserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"go-php/server"
+)
+
+func TestIPAccessRules(t *testing.T) {
+	a := newIPAccess(&IPAccessConfig{
+		IPRules: IPRules{Deny: []string{"203.0.113.7", "2001:db8::/32"}},
+		Paths: []IPPathRules{
+			{Prefix: "/admin/", IPRules: IPRules{Allow: []string{"10.0.0.0/8", "192.168.1.10"}}},
+			{Prefix: "/admin/public/", IPRules: IPRules{}},
+			{Prefix: "/typo/", IPRules: IPRules{Allow: []string{"10.0.0.0/33"}}},
+		},
+	})
+
+	cases := []struct {
+		addr, path string
+		want       bool
+	}{
+		{"198.51.100.1", "/", true},
+		{"203.0.113.7", "/", false},
+		{"2001:db8::1", "/", false},
+		{"10.1.2.3", "/admin/users", true},
+		{"192.168.1.10", "/admin/", true},
+		{"198.51.100.1", "/admin/users", false},
+		{"203.0.113.7", "/admin/public/logo.png", false},
+		{"198.51.100.1", "/admin/public/logo.png", true},
+		{"10.1.2.3", "/typo/x", false},
+	}
+	for _, c := range cases {
+		if got := a.allowed(netip.MustParseAddr(c.addr), c.path); got != c.want {
+			t.Errorf("allowed(%s, %s) = %v, want %v", c.addr, c.path, got, c.want)
+		}
+	}
+}
+
+func TestIPAccessDeniesBeforeDispatch(t *testing.T) {
+	srv, err := New(&AppServerConfig{
+		Root: t.TempDir(),
+		Pools: []PoolConfig{{Name: "fast", Workers: 1, Transport: "tcp", Address: fakePHP(t, func(*server.RequestPayload) *server.ResponsePayload {
+			return &server.ResponsePayload{Status: http.StatusOK, Body: []byte("ok")}
+		})}},
+		IPAccess: &IPAccessConfig{Paths: []IPPathRules{{Prefix: "/admin/", IPRules: IPRules{Allow: []string{"10.0.0.0/8"}}}}},
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	t.Cleanup(func() { _ = srv.Shutdown(t.Context()) })
+
+	req := httptest.NewRequest("GET", "/admin/users", nil)
+	req.RemoteAddr = "198.51.100.1:5000"
+	rr := httptest.NewRecorder()
+	srv.ServeHTTP(rr, req)
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("outside office range: status %d, want 403", rr.Code)
+	}
+
+	req = httptest.NewRequest("GET", "/admin/users", nil)
+	req.RemoteAddr = "[::ffff:10.0.0.5]:5000"
+	rr = httptest.NewRecorder()
+	srv.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK || rr.Body.String() != "ok" {
+		t.Fatalf("office range: %d %q", rr.Code, rr.Body.String())
+	}
+
+	snap := srv.metrics.Snapshot()
+	if snap.IPDenied != 1 || snap.TotalRequests != 1 {
+		t.Fatalf("ip_denied = %d, total_requests = %d; want 1 and 1", snap.IPDenied, snap.TotalRequests)
+	}
+}
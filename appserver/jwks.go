@@ -0,0 +1,384 @@
+package appserver
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// JWTVerifyConfig says which tokens to trust: HMAC tokens signed with
+// Secret, and RS256/ES256/EdDSA (etc.) tokens signed by the PEM public
+// keys or by the keys an IdP publishes at JWKSURL.
+type JWTVerifyConfig struct {
+	// Secret verifies HMAC-signed tokens. Without any key configured it
+	// defaults to APP_JWT_SECRET.
+	Secret         string   `json:"secret"`
+	PublicKeyFiles []string `json:"public_key_files"`
+
+	// JWKSURL is fetched at startup and again every JWKSRefreshSeconds
+	// (default 600), or sooner when a token names an unknown key.
+	JWKSURL            string `json:"jwks_url"`
+	JWKSRefreshSeconds int    `json:"jwks_refresh_seconds"`
+
+	// Algorithms defaults to HS256 with a secret and RS256, ES256 and
+	// EdDSA with public keys.
+	Algorithms []string `json:"algorithms"`
+
+	Issuer        string `json:"issuer"`
+	Audience      string `json:"audience"`
+	LeewaySeconds int    `json:"leeway_seconds"`
+}
+
+// jwtVerifier checks token signatures and registered claims.
+type jwtVerifier struct {
+	parser *jwt.Parser
+	secret []byte
+	keys   []crypto.PublicKey // from PublicKeyFiles
+	jwks   *jwksCache
+}
+
+func newJWTVerifier(root string, cfg JWTVerifyConfig) (*jwtVerifier, error) {
+	v := &jwtVerifier{secret: []byte(cfg.Secret)}
+	for _, path := range cfg.PublicKeyFiles {
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(root, path)
+		}
+		key, err := loadPublicKey(path)
+		if err != nil {
+			return nil, err
+		}
+		v.keys = append(v.keys, key)
+	}
+	if cfg.JWKSURL != "" {
+		refresh := time.Duration(cfg.JWKSRefreshSeconds) * time.Second
+		if refresh <= 0 {
+			refresh = 10 * time.Minute
+		}
+		v.jwks = &jwksCache{url: cfg.JWKSURL, refresh: refresh, client: &http.Client{Timeout: 5 * time.Second}}
+		// An IdP that's down shouldn't keep the server from starting;
+		// the keys are fetched again when a token needs them.
+		if err := v.jwks.fetch(); err != nil {
+			log.Printf("[jwt] %s: %v", cfg.JWKSURL, err)
+		}
+	}
+	asymmetric := len(v.keys) > 0 || v.jwks != nil
+	if len(v.secret) == 0 && !asymmetric {
+		v.secret = []byte(os.Getenv("APP_JWT_SECRET"))
+	}
+	if len(v.secret) == 0 && !asymmetric {
+		return nil, errors.New("no secret, public_key_files or jwks_url (and APP_JWT_SECRET is not set)")
+	}
+
+	algs := cfg.Algorithms
+	if len(algs) == 0 {
+		if len(v.secret) > 0 {
+			algs = append(algs, "HS256")
+		}
+		if asymmetric {
+			algs = append(algs, "RS256", "ES256", "EdDSA")
+		}
+	}
+	opts := []jwt.ParserOption{jwt.WithValidMethods(algs), jwt.WithLeeway(time.Duration(cfg.LeewaySeconds) * time.Second)}
+	if cfg.Issuer != "" {
+		opts = append(opts, jwt.WithIssuer(cfg.Issuer))
+	}
+	if cfg.Audience != "" {
+		opts = append(opts, jwt.WithAudience(cfg.Audience))
+	}
+	v.parser = jwt.NewParser(opts...)
+	return v, nil
+}
+
+// parse verifies raw and decodes its claims into claims.
+func (v *jwtVerifier) parse(raw string, claims jwt.Claims) error {
+	_, err := v.parser.ParseWithClaims(raw, claims, v.keyFunc)
+	return err
+}
+
+// keyFunc picks the key for t by its algorithm family, so a public key is
+// never used as an HMAC secret.
+func (v *jwtVerifier) keyFunc(t *jwt.Token) (any, error) {
+	if _, ok := t.Method.(*jwt.SigningMethodHMAC); ok {
+		if len(v.secret) == 0 {
+			return nil, errors.New("no secret for HMAC tokens")
+		}
+		return v.secret, nil
+	}
+
+	kid, _ := t.Header["kid"].(string)
+	var keys []crypto.PublicKey
+	if v.jwks != nil {
+		keys = v.jwks.lookup(kid)
+	}
+	if kid == "" || len(keys) == 0 {
+		keys = append(keys, v.keys...)
+	}
+
+	var set jwt.VerificationKeySet
+	for _, k := range keys {
+		if keyMatches(t.Method, k) {
+			set.Keys = append(set.Keys, k)
+		}
+	}
+	if len(set.Keys) == 0 {
+		return nil, fmt.Errorf("no %s key for kid %q", t.Method.Alg(), kid)
+	}
+	return set, nil
+}
+
+func keyMatches(m jwt.SigningMethod, key crypto.PublicKey) bool {
+	switch m.(type) {
+	case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS:
+		_, ok := key.(*rsa.PublicKey)
+		return ok
+	case *jwt.SigningMethodECDSA:
+		_, ok := key.(*ecdsa.PublicKey)
+		return ok
+	case *jwt.SigningMethodEd25519:
+		_, ok := key.(ed25519.PublicKey)
+		return ok
+	}
+	return false
+}
+
+// loadPublicKey reads a PEM public key (PKIX "PUBLIC KEY", PKCS#1 "RSA
+// PUBLIC KEY", or the key of a certificate).
+func loadPublicKey(path string) (crypto.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM data", path)
+	}
+	switch block.Type {
+	case "PUBLIC KEY":
+		return x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	case "CERTIFICATE":
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return cert.PublicKey, nil
+	}
+	return nil, fmt.Errorf("%s: unsupported PEM block %q", path, block.Type)
+}
+
+// jwksMinRefetch limits refetches triggered by unknown key IDs, so junk
+// tokens can't hammer the IdP.
+const jwksMinRefetch = 30 * time.Second
+
+// jwksCache holds the keys published at url.
+type jwksCache struct {
+	url     string
+	refresh time.Duration
+	client  *http.Client
+
+	mu       sync.Mutex
+	keys     map[string][]crypto.PublicKey // by kid; "" holds keys without one
+	fetched  time.Time                     // last attempt
+	inflight chan struct{}                 // closed when the running fetch ends; nil if none
+}
+
+// lookup returns the keys for kid (all keys if kid is empty), fetching
+// the set again when it is stale or doesn't know kid. Only lookups that
+// need the new set wait for a fetch; the rest use the keys they have.
+func (c *jwksCache) lookup(kid string) []crypto.PublicKey {
+	c.mu.Lock()
+	since := time.Since(c.fetched)
+	_, known := c.keys[kid]
+	wait := c.inflight
+	c.mu.Unlock()
+
+	switch {
+	case wait != nil && kid != "" && !known:
+		<-wait
+	case wait == nil && (since > c.refresh || (kid != "" && !known && since > jwksMinRefetch)):
+		if err := c.fetch(); err != nil {
+			log.Printf("[jwt] %s: %v", c.url, err)
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if kid != "" {
+		return c.keys[kid]
+	}
+	var all []crypto.PublicKey
+	for _, ks := range c.keys {
+		all = append(all, ks...)
+	}
+	return all
+}
+
+// fetch replaces the keys with the published set, or waits for the fetch
+// already running. The request runs without holding mu. On failure the
+// old keys stay in use.
+func (c *jwksCache) fetch() error {
+	c.mu.Lock()
+	if wait := c.inflight; wait != nil {
+		c.mu.Unlock()
+		<-wait
+		return nil
+	}
+	done := make(chan struct{})
+	c.inflight, c.fetched = done, time.Now()
+	c.mu.Unlock()
+
+	keys, err := c.download()
+
+	c.mu.Lock()
+	if err == nil {
+		c.keys = keys
+	}
+	c.inflight = nil
+	c.mu.Unlock()
+	close(done)
+	return err
+}
+
+// download fetches and parses the published key set.
+func (c *jwksCache) download() (map[string][]crypto.PublicKey, error) {
+	resp, err := c.client.Get(c.url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status %s", resp.Status)
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, err
+	}
+	keys := map[string][]crypto.PublicKey{}
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			log.Printf("[jwt] %s: key %q: %v", c.url, k.Kid, err)
+			continue
+		}
+		keys[k.Kid] = append(keys[k.Kid], key)
+	}
+	return keys, nil
+}
+
+// jwk is a JSON Web Key (RFC 7517), public parts only.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	b64 := base64.RawURLEncoding.DecodeString
+	switch k.Kty {
+	case "RSA":
+		n, err := b64(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := b64(k.E)
+		if err != nil {
+			return nil, err
+		}
+		exp := new(big.Int).SetBytes(e)
+		if !exp.IsInt64() || exp.Int64() > 1<<31-1 {
+			return nil, errors.New("RSA exponent out of range")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exp.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := b64(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := b64(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		size := (curve.Params().BitSize + 7) / 8
+		if len(x) > size || len(y) > size {
+			return nil, errors.New("EC coordinates too long")
+		}
+		point := make([]byte, 1+2*size)
+		point[0] = 4 // uncompressed
+		copy(point[1+size-len(x):], x)
+		copy(point[1+2*size-len(y):], y)
+		return ecdsa.ParseUncompressedPublicKey(curve, point)
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := b64(k.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("bad Ed25519 key length")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+// validateJWTVerify reports key settings that can't verify anything.
+func validateJWTVerify(path string, v *JWTVerifyConfig, warn configWarnFunc) {
+	if v.Secret == "" && len(v.PublicKeyFiles) == 0 && v.JWKSURL == "" && os.Getenv("APP_JWT_SECRET") == "" {
+		warn(path+".secret", "%s has no secret, public_key_files or jwks_url and APP_JWT_SECRET is not set", path)
+	}
+	for i, alg := range v.Algorithms {
+		if jwt.GetSigningMethod(alg) == nil || alg == "none" {
+			warn(fmt.Sprintf("%s.algorithms[%d]", path, i), "%s.algorithms[%d]=%q is not a supported algorithm", path, i, alg)
+		}
+	}
+	if v.LeewaySeconds < 0 {
+		warn(path+".leeway_seconds", "%s.leeway_seconds=%d is invalid, using 0", path, v.LeewaySeconds)
+		v.LeewaySeconds = 0
+	}
+	if v.JWKSRefreshSeconds < 0 {
+		warn(path+".jwks_refresh_seconds", "%s.jwks_refresh_seconds=%d is invalid, using 600", path, v.JWKSRefreshSeconds)
+		v.JWKSRefreshSeconds = 0
+	}
+}
@@ -0,0 +1,199 @@
+package appserver
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestJWKSAndPEMKeysVerifyWSTokens(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edPub, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pemKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b64 := base64.RawURLEncoding.EncodeToString
+	ecPoint, err := ecKey.PublicKey.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	published := []map[string]string{
+		{"kty": "RSA", "kid": "rsa-1", "use": "sig", "n": b64(rsaKey.N.Bytes()), "e": b64(big.NewInt(int64(rsaKey.E)).Bytes())},
+		{"kty": "EC", "kid": "ec-1", "crv": "P-256", "x": b64(ecPoint[1:33]), "y": b64(ecPoint[33:])},
+	}
+	var mu sync.Mutex
+	fetches := 0
+	idp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		fetches++
+		_ = json.NewEncoder(w).Encode(map[string]any{"keys": published})
+	}))
+	defer idp.Close()
+
+	root := t.TempDir()
+	der, err := x509.MarshalPKIXPublicKey(&pemKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePEM(t, filepath.Join(root, "signer.pem"), "PUBLIC KEY", der)
+
+	srv, err := New(&AppServerConfig{
+		Root:  root,
+		Pools: []PoolConfig{{Name: "fast", Workers: 1, Transport: "tcp", Address: fakePHP(t, nil)}},
+		WSAuth: &JWTVerifyConfig{
+			JWKSURL:        idp.URL,
+			PublicKeyFiles: []string{"signer.pem"},
+			Algorithms:     []string{"RS256", "ES256", "ES384", "EdDSA"},
+			Issuer:         "https://auth.example.com",
+		},
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = srv.Shutdown(t.Context())
+	})
+
+	sign := func(m jwt.SigningMethod, kid string, key crypto.PrivateKey, iss string) string {
+		tok := jwt.NewWithClaims(m, jwt.MapClaims{"sub": "user-7", "iss": iss, "exp": time.Now().Add(time.Minute).Unix()})
+		if kid != "" {
+			tok.Header["kid"] = kid
+		}
+		s, err := tok.SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	auth := func(token string) (string, error) {
+		r := httptest.NewRequest("GET", "/ws", nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		return authenticateWS(srv.auth, r)
+	}
+
+	const iss = "https://auth.example.com"
+	for name, token := range map[string]string{
+		"RS256 from JWKS":     sign(jwt.SigningMethodRS256, "rsa-1", rsaKey, iss),
+		"ES256 from JWKS":     sign(jwt.SigningMethodES256, "ec-1", ecKey, iss),
+		"ES384 from PEM file": sign(jwt.SigningMethodES384, "", pemKey, iss),
+	} {
+		if id, err := auth(token); err != nil || id != "user-7" {
+			t.Errorf("%s: got %q, %v", name, id, err)
+		}
+	}
+
+	for name, token := range map[string]string{
+		"wrong issuer":        sign(jwt.SigningMethodRS256, "rsa-1", rsaKey, "https://evil.example.com"),
+		"key under other kid": sign(jwt.SigningMethodRS256, "ec-1", rsaKey, iss),
+		"HS256":               sign(jwt.SigningMethodHS256, "", []byte("guess"), iss),
+		"unknown EdDSA key":   sign(jwt.SigningMethodEdDSA, "ed-1", edKey, iss),
+	} {
+		if _, err := auth(token); err == nil {
+			t.Errorf("%s: accepted", name)
+		}
+	}
+
+	// The IdP rotates in a new key; its kid triggers a refetch once the
+	// rate limit allows.
+	mu.Lock()
+	published = append(published, map[string]string{"kty": "OKP", "kid": "ed-1", "crv": "Ed25519", "x": b64(edPub)})
+	mu.Unlock()
+	c := srv.auth.verifier.jwks
+	c.mu.Lock()
+	c.fetched = time.Now().Add(-jwksMinRefetch - time.Second)
+	c.mu.Unlock()
+	if id, err := auth(sign(jwt.SigningMethodEdDSA, "ed-1", edKey, iss)); err != nil || id != "user-7" {
+		t.Errorf("rotated EdDSA key: got %q, %v", id, err)
+	}
+	mu.Lock()
+	if fetches != 2 {
+		t.Errorf("JWKS fetched %d times, want 2", fetches)
+	}
+	mu.Unlock()
+}
+
+func TestJWKSFetchDoesNotBlockLookups(t *testing.T) {
+	edPub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b64 := base64.RawURLEncoding.EncodeToString
+	release := make(chan struct{})
+	var fetches sync.WaitGroup
+	fetched := 0
+	idp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetched++
+		fetches.Done()
+		<-release
+		_ = json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{
+			{"kty": "OKP", "kid": "new", "crv": "Ed25519", "x": b64(edPub)},
+		}})
+	}))
+	defer idp.Close()
+
+	c := &jwksCache{
+		url:     idp.URL,
+		refresh: time.Hour,
+		client:  idp.Client(),
+		keys:    map[string][]crypto.PublicKey{"old": {edPub}},
+		fetched: time.Now().Add(-time.Minute),
+	}
+
+	// An unknown kid starts a fetch, which the IdP holds open.
+	fetches.Add(1)
+	results := make(chan []crypto.PublicKey, 2)
+	go func() { results <- c.lookup("new") }()
+	fetches.Wait()
+
+	// Meanwhile a known kid is answered at once, and a second lookup of
+	// the unknown kid waits for the same fetch instead of starting one.
+	got := make(chan []crypto.PublicKey, 1)
+	go func() { got <- c.lookup("old") }()
+	select {
+	case keys := <-got:
+		if len(keys) != 1 {
+			t.Fatalf("lookup(old) = %v", keys)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("lookup of a known kid waited for the fetch")
+	}
+	go func() { results <- c.lookup("new") }()
+
+	close(release)
+	for range 2 {
+		if keys := <-results; len(keys) != 1 {
+			t.Fatalf("lookup(new) = %v, want the fetched key", keys)
+		}
+	}
+	if fetched != 1 {
+		t.Fatalf("IdP fetched %d times, want 1", fetched)
+	}
+}
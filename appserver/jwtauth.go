@@ -0,0 +1,139 @@
+package appserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// JWTAuthConfig requires a valid bearer token on API prefixes, checked in
+// Go so unauthenticated requests never take a worker. The token's claims
+// are forwarded to PHP as headers.
+type JWTAuthConfig struct {
+	Prefixes []string `json:"prefixes"`
+	Except   []string `json:"except"` // prefixes left open inside Prefixes, e.g. /api/login
+
+	// The keys and claims tokens are checked against.
+	JWTVerifyConfig
+
+	// ClaimHeaders forwards single claims under headers of their own, e.g.
+	// {"tenant_id": "X-Tenant-Id"}, besides X-Auth-User and X-Auth-Claims.
+	ClaimHeaders map[string]string `json:"claim_headers"`
+}
+
+// Headers carrying a verified token to PHP. Clients' own values are always
+// dropped.
+const (
+	authUserHeader   = "X-Auth-User"   // the sub claim
+	authClaimsHeader = "X-Auth-Claims" // every claim, as JSON
+)
+
+type jwtAuth struct {
+	cfg      *JWTAuthConfig
+	verifier *jwtVerifier
+	strip    []string // headers to drop from every request
+}
+
+func newJWTAuth(root string, cfg *JWTAuthConfig) (*jwtAuth, error) {
+	v, err := newJWTVerifier(root, cfg.JWTVerifyConfig)
+	if err != nil {
+		return nil, fmt.Errorf("jwt_auth: %w", err)
+	}
+	a := &jwtAuth{
+		cfg:      cfg,
+		verifier: v,
+		strip:    []string{authUserHeader, authClaimsHeader},
+	}
+	for _, h := range cfg.ClaimHeaders {
+		a.strip = append(a.strip, http.CanonicalHeaderKey(h))
+	}
+	return a, nil
+}
+
+func (a *jwtAuth) protects(path string) bool {
+	under := func(p string) bool { return underPrefix(path, p) }
+	return slices.ContainsFunc(a.cfg.Prefixes, under) && !slices.ContainsFunc(a.cfg.Except, under)
+}
+
+// verify returns the claims of r's bearer token.
+func (a *jwtAuth) verify(r *http.Request) (jwt.MapClaims, error) {
+	raw, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || strings.TrimSpace(raw) == "" {
+		return nil, errors.New("missing bearer token")
+	}
+	claims := jwt.MapClaims{}
+	if err := a.verifier.parse(strings.TrimSpace(raw), claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// forward puts the verified claims on r for BuildPayload to copy.
+func (a *jwtAuth) forward(r *http.Request, claims jwt.MapClaims) {
+	if sub, err := claims.GetSubject(); err == nil && sub != "" {
+		r.Header.Set(authUserHeader, sub)
+	}
+	if b, err := json.Marshal(claims); err == nil {
+		r.Header.Set(authClaimsHeader, string(b))
+	}
+	for claim, header := range a.cfg.ClaimHeaders {
+		switch v := claims[claim].(type) {
+		case nil:
+		case string:
+			r.Header.Set(header, v)
+		default:
+			if b, err := json.Marshal(v); err == nil {
+				r.Header.Set(header, string(b))
+			}
+		}
+	}
+}
+
+// jwtAuthHandler rejects requests to protected prefixes without a valid
+// token with 401, and forwards the claims of valid ones.
+func jwtAuthHandler(a *jwtAuth, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, h := range a.strip {
+			r.Header.Del(h)
+		}
+		if !a.protects(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		claims, err := a.verify(r)
+		if err != nil {
+			log.Printf("[jwt] %s %s from %s: %v", r.Method, r.URL.Path, r.RemoteAddr, err)
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		a.forward(r, claims)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// validateJWTAuth fixes prefixes and reports settings that lock everyone
+// out.
+func validateJWTAuth(cfg *AppServerConfig, warn configWarnFunc) {
+	j := cfg.JWTAuth
+	if j == nil {
+		return
+	}
+	if len(j.Prefixes) == 0 {
+		warn("jwt_auth.prefixes", "jwt_auth.prefixes is empty, no route is protected")
+	}
+	for i, p := range j.Prefixes {
+		if !strings.HasPrefix(p, "/") {
+			warn(fmt.Sprintf("jwt_auth.prefixes[%d]", i), "jwt_auth.prefixes[%d]=%q does not start with '/', fixing", i, p)
+			j.Prefixes[i] = "/" + p
+		}
+	}
+	validateJWTVerify("jwt_auth", &j.JWTVerifyConfig, warn)
+}
@@ -0,0 +1,96 @@
+package appserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"go-php/server"
+)
+
+func TestJWTAuthProtectsPrefixes(t *testing.T) {
+	var seen map[string][]string
+	srv, err := New(&AppServerConfig{
+		Root: t.TempDir(),
+		Pools: []PoolConfig{{Name: "fast", Workers: 1, Transport: "tcp", Address: fakePHP(t, func(req *server.RequestPayload) *server.ResponsePayload {
+			seen = req.Headers
+			return &server.ResponsePayload{Status: http.StatusOK, Body: []byte("ok")}
+		})}},
+		JWTAuth: &JWTAuthConfig{
+			Prefixes: []string{"/api/"},
+			Except:   []string{"/api/login"},
+			JWTVerifyConfig: JWTVerifyConfig{
+				Secret: "test-secret",
+				Issuer: "https://auth.example.com",
+			},
+			ClaimHeaders: map[string]string{"tenant_id": "X-Tenant-Id"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	t.Cleanup(func() { _ = srv.Shutdown(t.Context()) })
+
+	sign := func(secret string, claims jwt.MapClaims) string {
+		s, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	get := func(path, token string) *httptest.ResponseRecorder {
+		seen = nil
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("X-Auth-User", "forged")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+		return rr
+	}
+	exp := time.Now().Add(time.Hour).Unix()
+	good := jwt.MapClaims{"sub": "42", "iss": "https://auth.example.com", "exp": exp, "tenant_id": "acme"}
+
+	for name, token := range map[string]string{
+		"missing":      "",
+		"bad secret":   sign("other", good),
+		"wrong issuer": sign("test-secret", jwt.MapClaims{"sub": "42", "iss": "https://evil", "exp": exp}),
+		"expired":      sign("test-secret", jwt.MapClaims{"sub": "42", "iss": "https://auth.example.com", "exp": time.Now().Add(-time.Hour).Unix()}),
+		"alg none":     "eyJhbGciOiJub25lIn0.eyJzdWIiOiI0MiJ9.",
+	} {
+		rr := get("/api/orders", token)
+		if rr.Code != http.StatusUnauthorized || seen != nil {
+			t.Errorf("%s: status %d (reached PHP: %v)", name, rr.Code, seen != nil)
+		}
+	}
+
+	rr := get("/api/orders", sign("test-secret", good))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("valid token: status %d", rr.Code)
+	}
+	if seen["X-Auth-User"][0] != "42" || seen["X-Tenant-Id"][0] != "acme" {
+		t.Fatalf("claims not forwarded: %v", seen)
+	}
+	var claims map[string]any
+	if err := json.Unmarshal([]byte(seen["X-Auth-Claims"][0]), &claims); err != nil || claims["tenant_id"] != "acme" {
+		t.Fatalf("X-Auth-Claims = %q (%v)", seen["X-Auth-Claims"], err)
+	}
+
+	for _, path := range []string{"/api/login", "/api/login/sso", "/home"} {
+		if rr := get(path, ""); rr.Code != http.StatusOK || seen["X-Auth-User"] != nil {
+			t.Fatalf("%s: status %d, X-Auth-User %v", path, rr.Code, seen["X-Auth-User"])
+		}
+	}
+	// An exception covers its own path segment, not siblings that share
+	// its spelling.
+	for _, path := range []string{"/api/login-admin", "/api/loginx/users"} {
+		if rr := get(path, ""); rr.Code != http.StatusUnauthorized || seen != nil {
+			t.Fatalf("%s: status %d (reached PHP: %v), want 401", path, rr.Code, seen != nil)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package appserver
+
+import (
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// LimitsConfig caps open connections and requests being handled at once,
+// independent of worker counts. Over either cap the server answers 503
+// right away, so a spike doesn't pile up goroutines and buffered bodies.
+type LimitsConfig struct {
+	MaxConnections    int `json:"max_connections"`     // 0 = no limit
+	MaxInFlight       int `json:"max_in_flight"`       // 0 = no limit
+	RetryAfterSeconds int `json:"retry_after_seconds"` // default 1
+}
+
+// overLimitResponse is written to connections over max_connections before
+// they're closed; the HTTP server never sees them.
+const overLimitResponse = "HTTP/1.1 503 Service Unavailable\r\nContent-Type: text/plain; charset=utf-8\r\nConnection: close\r\nContent-Length: 20\r\nRetry-After: %d\r\n\r\nService Unavailable\n"
+
+// connLimitListener closes connections accepted while max are open.
+type connLimitListener struct {
+	net.Listener
+	max     int64
+	open    atomic.Int64
+	reply   string // written before closing; empty under TLS
+	metrics *Metrics
+}
+
+func newConnLimitListener(ln net.Listener, max int, reply string, metrics *Metrics) net.Listener {
+	return &connLimitListener{Listener: ln, max: int64(max), reply: reply, metrics: metrics}
+}
+
+func (l *connLimitListener) Accept() (net.Conn, error) {
+	for {
+		c, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		if l.open.Add(1) <= l.max {
+			return &limitedConn{Conn: c, release: func() { l.open.Add(-1) }}, nil
+		}
+		l.open.Add(-1)
+		l.metrics.RecordOverLimit()
+		go func() {
+			if l.reply != "" {
+				_ = c.SetWriteDeadline(time.Now().Add(time.Second))
+				_, _ = io.WriteString(c, l.reply)
+			}
+			c.Close()
+		}()
+	}
+}
+
+// limitedConn gives its slot back once, when closed.
+type limitedConn struct {
+	net.Conn
+	once    sync.Once
+	release func()
+}
+
+func (c *limitedConn) Close() error {
+	c.once.Do(c.release)
+	return c.Conn.Close()
+}
+
+// longLivedPaths are the routes that hold their handler for the life of a
+// WebSocket or event stream; they don't count as in flight. They're matched
+// by route, not by headers a client could add to any request.
+var longLivedPaths = []string{"/__sse", "/__ws", "/__ws/user"}
+
+func longLived(r *http.Request) bool {
+	return slices.Contains(longLivedPaths, r.URL.Path)
+}
+
+// inFlightHandler answers 503 while max requests are being handled.
+// Management endpoints stay reachable.
+func inFlightHandler(max, retryAfter int, metrics *Metrics, next http.Handler) http.Handler {
+	slots := make(chan struct{}, max)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isManagementPath(r.URL.Path) || longLived(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		select {
+		case slots <- struct{}{}:
+		default:
+			metrics.RecordOverLimit()
+			log.Printf("[limits] %d requests in flight, refusing %s %s", max, r.Method, r.URL.Path)
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+			http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		defer func() { <-slots }()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// validateLimits fills in defaults and reports invalid caps.
+func validateLimits(cfg *AppServerConfig, warn configWarnFunc) {
+	l := &cfg.Limits
+	if l.MaxConnections < 0 {
+		warn("limits.max_connections", "limits.max_connections=%d is invalid, using no limit", l.MaxConnections)
+		l.MaxConnections = 0
+	}
+	if l.MaxInFlight < 0 {
+		warn("limits.max_in_flight", "limits.max_in_flight=%d is invalid, using no limit", l.MaxInFlight)
+		l.MaxInFlight = 0
+	}
+	if l.RetryAfterSeconds < 0 {
+		warn("limits.retry_after_seconds", "limits.retry_after_seconds=%d is invalid, using 1", l.RetryAfterSeconds)
+		l.RetryAfterSeconds = 0
+	}
+	if l.RetryAfterSeconds == 0 {
+		l.RetryAfterSeconds = 1
+	}
+	if l.MaxConnections > 0 && l.MaxInFlight > l.MaxConnections && !cfg.TLS.enabled() {
+		// HTTP/1.1 carries one request at a time per connection
+		warn("limits.max_in_flight", "limits.max_in_flight=%d can't be reached with max_connections=%d", l.MaxInFlight, l.MaxConnections)
+	}
+}
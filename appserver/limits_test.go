@@ -0,0 +1,116 @@
+package appserver
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go-php/server"
+)
+
+func TestInFlightLimit(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{}, 1)
+	srv, err := New(&AppServerConfig{
+		Root: t.TempDir(),
+		Pools: []PoolConfig{{Name: "fast", Workers: 2, Transport: "tcp", Address: fakePHP(t, func(req *server.RequestPayload) *server.ResponsePayload {
+			started <- struct{}{}
+			<-release
+			return &server.ResponsePayload{Status: http.StatusOK, Body: []byte("ok")}
+		})}},
+		Limits: LimitsConfig{MaxInFlight: 1, RetryAfterSeconds: 2},
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	t.Cleanup(func() { _ = srv.Shutdown(t.Context()) })
+
+	done := make(chan int)
+	go func() {
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, httptest.NewRequest("GET", "/slow", nil))
+		done <- rr.Code
+	}()
+	<-started
+
+	rr := httptest.NewRecorder()
+	srv.ServeHTTP(rr, httptest.NewRequest("GET", "/other", nil))
+	if rr.Code != http.StatusServiceUnavailable || rr.Header().Get("Retry-After") != "2" {
+		t.Errorf("over the limit: got %d, Retry-After %q", rr.Code, rr.Header().Get("Retry-After"))
+	}
+	rr = httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/other", nil)
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Accept", "text/event-stream")
+	srv.ServeHTTP(rr, req)
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("streaming headers on a PHP route: got %d, want 503", rr.Code)
+	}
+	rr = httptest.NewRecorder()
+	srv.ServeHTTP(rr, httptest.NewRequest("GET", "/__baremetal/health", nil))
+	if rr.Code == http.StatusServiceUnavailable {
+		t.Error("management endpoint refused")
+	}
+
+	close(release)
+	if code := <-done; code != http.StatusOK {
+		t.Errorf("first request: got %d", code)
+	}
+	if got := srv.metrics.Snapshot().OverLimit; got != 2 {
+		t.Errorf("over_limit = %d, want 2", got)
+	}
+}
+
+func TestConnectionLimit(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	metrics := NewMetrics()
+	ln = newConnLimitListener(ln, 1, fmt.Sprintf(overLimitResponse, 5), metrics)
+	hs := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { io.WriteString(w, "ok") })}
+	go hs.Serve(ln)
+	defer hs.Close()
+
+	get := func(conn net.Conn) (*http.Response, error) {
+		io.WriteString(conn, "GET / HTTP/1.1\r\nHost: x\r\n\r\n")
+		return http.ReadResponse(bufio.NewReader(conn), nil)
+	}
+
+	first, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp, err := get(first); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("first connection: %v %v", resp, err)
+	}
+
+	second, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+	resp, err := get(second)
+	if err != nil || resp.StatusCode != http.StatusServiceUnavailable || resp.Header.Get("Retry-After") != "5" {
+		t.Fatalf("second connection: %v %v", resp, err)
+	}
+
+	// Closing the first frees its slot.
+	first.Close()
+	for range 100 {
+		third, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := get(third)
+		third.Close()
+		if err == nil && resp.StatusCode == http.StatusOK {
+			return
+		}
+	}
+	t.Error("slot not released after the connection closed")
+}
@@ -0,0 +1,199 @@
+package appserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"go-php/server"
+)
+
+// liveConfigMu serialises runtime config changes (PATCH and file reloads).
+var liveConfigMu sync.Mutex
+
+// configPatch is the subset of AppServerConfig that can change at runtime.
+// Omitted fields are left alone.
+type configPatch struct {
+	Static            *[]StaticRule    `json:"static"`
+	SlowRoutes        *[]string        `json:"slow_routes"`
+	SlowMethods       *[]string        `json:"slow_methods"`
+	SlowBodyThreshold *int             `json:"slow_body_threshold"`
+	RequestTimeoutMs  *int             `json:"request_timeout_ms"` // every pool
+	Pools             []poolPatch      `json:"pools"`
+	LoadShed          *server.LoadShed `json:"load_shed"`
+	RateLimits        *rateLimitsPatch `json:"rate_limits"` // every app
+}
+
+// rateLimitsPatch replaces the rate limit rules. The store can't change at
+// runtime.
+type rateLimitsPatch struct {
+	Rules []RateLimitRule `json:"rules"`
+}
+
+type poolPatch struct {
+	Name             string `json:"name"`
+	RequestTimeoutMs *int   `json:"request_timeout_ms"`
+}
+
+// liveConfig is the effective runtime configuration of one app.
+type liveConfig struct {
+	Static []StaticRule `json:"static"`
+	server.SlowRouting
+	Pools      []poolPatch     `json:"pools"`
+	LoadShed   server.LoadShed `json:"load_shed"`
+	RateLimits rateLimitsPatch `json:"rate_limits"`
+}
+
+func currentConfig(app *vhost, rl *rateLimiter) liveConfig {
+	lc := liveConfig{
+		Static:      app.staticRules(),
+		SlowRouting: app.srv.SlowRouting(),
+		LoadShed:    app.srv.LoadShed(),
+		RateLimits:  rateLimitsPatch{Rules: rl.currentRules()},
+	}
+	for _, name := range app.srv.PoolNames() {
+		ms := int(app.srv.Pool(name).RequestTimeout() / time.Millisecond)
+		lc.Pools = append(lc.Pools, poolPatch{Name: name, RequestTimeoutMs: &ms})
+	}
+	return lc
+}
+
+// validate checks the whole patch against app before anything is applied,
+// so that apply can't fail halfway. Rate limit rules come back with their
+// defaults filled in.
+func (p *configPatch) validate(app *vhost) error {
+	if p.Static != nil {
+		for i, rule := range *p.Static {
+			if !strings.HasPrefix(rule.Prefix, "/") {
+				return fmt.Errorf("static[%d].prefix must start with '/'", i)
+			}
+			if rule.Dir == "" {
+				return fmt.Errorf("static[%d].dir is empty", i)
+			}
+			if rule.MaxAge < 0 || rule.SMaxAge < 0 {
+				return fmt.Errorf("static[%d]: cache lifetimes must not be negative", i)
+			}
+			if !validSymlinkPolicy(rule.Symlinks) {
+				return fmt.Errorf("static[%d].symlinks must be follow, within or deny", i)
+			}
+		}
+	}
+	if p.SlowMethods != nil {
+		for _, m := range *p.SlowMethods {
+			if strings.TrimSpace(m) == "" {
+				return errors.New("slow_methods: empty method")
+			}
+		}
+	}
+	if p.SlowBodyThreshold != nil && *p.SlowBodyThreshold < 0 {
+		return errors.New("slow_body_threshold must not be negative")
+	}
+	if p.RequestTimeoutMs != nil && *p.RequestTimeoutMs < 0 {
+		return errors.New("request_timeout_ms must not be negative")
+	}
+	for i, pp := range p.Pools {
+		if app.srv.Pool(pp.Name) == nil {
+			return fmt.Errorf("pools[%d]: unknown pool %q", i, pp.Name)
+		}
+		if pp.RequestTimeoutMs != nil && *pp.RequestTimeoutMs < 0 {
+			return fmt.Errorf("pools[%d].request_timeout_ms must not be negative", i)
+		}
+	}
+	if p.LoadShed != nil && (p.LoadShed.MaxInFlightPerWorker < 0 || p.LoadShed.MaxQueueWaitMs < 0) {
+		return errors.New("load_shed: limits must not be negative")
+	}
+	if p.RateLimits != nil {
+		rules, err := checkRateLimitRules(p.RateLimits.Rules)
+		if err != nil {
+			return err
+		}
+		p.RateLimits.Rules = rules
+	}
+	return nil
+}
+
+// apply installs a validated patch.
+func (p *configPatch) apply(app *vhost, rl *rateLimiter) error {
+	if p.RateLimits != nil {
+		rl.setRules(p.RateLimits.Rules)
+	}
+	if p.Static != nil {
+		app.setStatic(*p.Static)
+	}
+
+	if p.SlowRoutes != nil || p.SlowMethods != nil || p.SlowBodyThreshold != nil {
+		sr := app.srv.SlowRouting()
+		if p.SlowRoutes != nil {
+			sr.Routes = *p.SlowRoutes
+		}
+		if p.SlowMethods != nil {
+			sr.Methods = *p.SlowMethods
+		}
+		if p.SlowBodyThreshold != nil {
+			sr.BodyThreshold = *p.SlowBodyThreshold
+		}
+		if err := app.srv.SetSlowRouting(sr); err != nil {
+			return err
+		}
+	}
+
+	if p.RequestTimeoutMs != nil {
+		for _, name := range app.srv.PoolNames() {
+			app.srv.Pool(name).SetRequestTimeout(time.Duration(*p.RequestTimeoutMs) * time.Millisecond)
+		}
+	}
+	for _, pp := range p.Pools {
+		if pp.RequestTimeoutMs != nil {
+			app.srv.Pool(pp.Name).SetRequestTimeout(time.Duration(*pp.RequestTimeoutMs) * time.Millisecond)
+		}
+	}
+
+	if p.LoadShed != nil {
+		return app.srv.SetLoadShed(*p.LoadShed)
+	}
+	return nil
+}
+
+// configHandler serves /__baremetal/config: GET returns the effective
+// runtime settings of an app (?app= for virtual hosts), PATCH changes some
+// of them without a restart. A patch is validated as a whole before any of
+// it is applied. Rate limits are shared by every app. Changes are not
+// written back to go_appserver.json.
+func configHandler(vhosts *vhostRouter, rl *rateLimiter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		app := vhosts.byName(r.URL.Query().Get("app"))
+
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPatch:
+			var patch configPatch
+			dec := json.NewDecoder(r.Body)
+			dec.DisallowUnknownFields()
+			if err := dec.Decode(&patch); err != nil {
+				http.Error(w, "invalid patch: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			liveConfigMu.Lock()
+			err := patch.validate(app)
+			if err == nil {
+				err = patch.apply(app, rl)
+			}
+			liveConfigMu.Unlock()
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(currentConfig(app, rl))
+	}
+}
@@ -0,0 +1,89 @@
+package appserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go-php/server"
+)
+
+func TestConfigHandlerPatch(t *testing.T) {
+	app := &vhost{name: "default", srv: newCanaryTestServer(t)}
+	app.setStatic([]StaticRule{{Prefix: "/assets/", Dir: "public/assets"}})
+	rl := newRateLimiter(&RateLimitConfig{}, nil)
+	h := configHandler(&vhostRouter{def: app}, rl)
+
+	patch := func(body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		h(rr, httptest.NewRequest(http.MethodPatch, "/__baremetal/config", strings.NewReader(body)))
+		return rr
+	}
+
+	rr := patch(`{
+		"static": [{"prefix": "/build/", "dir": "public/build"}],
+		"slow_routes": ["/reports"],
+		"pools": [{"name": "canary", "request_timeout_ms": 2500}],
+		"load_shed": {"max_in_flight_per_worker": 4},
+		"rate_limits": {"rules": [{"prefix": "/api", "rps": 5}]}
+	}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var got liveConfig
+	if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Static) != 1 || got.Static[0].Prefix != "/build/" {
+		t.Fatalf("static not applied: %+v", got.Static)
+	}
+	if len(got.Routes) != 1 || got.Routes[0] != "/reports" {
+		t.Fatalf("slow_routes not applied: %+v", got.Routes)
+	}
+	if got.LoadShed.MaxInFlightPerWorker != 4 {
+		t.Fatalf("load_shed not applied: %+v", got.LoadShed)
+	}
+	if rules := got.RateLimits.Rules; len(rules) != 1 || rules[0].Burst != 5 || rules[0].Key != RateKeyIP {
+		t.Fatalf("rate_limits not applied with defaults: %+v", rules)
+	}
+	for _, p := range got.Pools {
+		if p.Name == "canary" && *p.RequestTimeoutMs != 2500 {
+			t.Fatalf("canary timeout = %d", *p.RequestTimeoutMs)
+		}
+	}
+	if !app.srv.IsSlowRequest(&server.RequestPayload{Method: "GET", Path: "/reports/2024"}) {
+		t.Fatalf("new slow route should take effect")
+	}
+
+	// a patch with one bad part changes nothing
+	rr = patch(`{"static": [{"prefix": "/x/", "dir": "x"}], "pools": [{"name": "nope", "request_timeout_ms": 1}]}`)
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", rr.Code)
+	}
+	if rules := app.staticRules(); rules[0].Prefix != "/build/" {
+		t.Fatalf("rejected patch must not be partially applied: %+v", rules)
+	}
+	for _, body := range []string{
+		`{"static": [{"prefix": "/x/", "dir": "x"}], "slow_methods": [" "]}`,
+		`{"static": [{"prefix": "/x/", "dir": "x"}], "rate_limits": {"rules": [{"prefix": "/api", "rps": 0}]}}`,
+	} {
+		if rr := patch(body); rr.Code != http.StatusBadRequest {
+			t.Fatalf("%s: expected 400, got %d", body, rr.Code)
+		}
+		if rules := app.staticRules(); rules[0].Prefix != "/build/" {
+			t.Fatalf("%s: rejected patch must not be partially applied: %+v", body, rules)
+		}
+	}
+	if rules := rl.currentRules(); len(rules) != 1 || rules[0].RPS != 5 {
+		t.Fatalf("rejected rate limits replaced the rules: %+v", rules)
+	}
+	if rr := patch(`{"rate_limits": {"store": "redis"}}`); rr.Code != http.StatusBadRequest {
+		t.Fatalf("the rate limit store can't change at runtime, got %d", rr.Code)
+	}
+
+	if rr := patch(`{"fast_workers": 9}`); rr.Code != http.StatusBadRequest {
+		t.Fatalf("fields that can't change at runtime should be rejected, got %d", rr.Code)
+	}
+}
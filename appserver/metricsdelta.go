@@ -0,0 +1,177 @@
+package appserver
+
+import (
+	"time"
+
+	"go-php/server"
+)
+
+// maxDeltaKeys bounds how many pollers can keep their own delta baseline.
+const maxDeltaKeys = 16
+
+// Reset zeroes every counter, as if the server had just started. Requests
+// in flight are still counted when they end, and delta baselines are
+// dropped.
+func (m *Metrics) Reset() {
+	m.requests.Store(0)
+	m.failed.Store(0)
+	m.ipDenied.Store(0)
+	m.overLimit.Store(0)
+	m.rateLimited.Store(0)
+	m.panics.Store(0)
+	m.bytesIn.Store(0)
+	m.bytesOut.Store(0)
+	m.static.reset()
+	m.pools.Clear()
+	for i := range m.routes {
+		sh := &m.routes[i]
+		sh.mu.Lock()
+		m.routesTracked.Add(-int64(len(sh.routes)))
+		sh.routes, sh.lru, sh.other = nil, nil, RouteMetrics{}
+		sh.mu.Unlock()
+	}
+	m.dropped.Store(0)
+
+	m.errMu.Lock()
+	m.phpFatals, m.errorsByKind, m.recentErrors = 0, nil, nil
+	m.errMu.Unlock()
+
+	m.deltaMu.Lock()
+	m.resetAt, m.scrapes = time.Now(), nil
+	m.deltaMu.Unlock()
+}
+
+// since returns what changed in cur, a snapshot, since the last one taken
+// for key, or since the last reset on the first call for key. cur becomes
+// key's new baseline, so each poller should use a key of its own.
+func (m *Metrics) since(key string, cur *Metrics) *Metrics {
+	m.deltaMu.Lock()
+	prev := m.scrapes[key]
+	if m.scrapes == nil {
+		m.scrapes = make(map[string]*Metrics)
+	}
+	if prev == nil && len(m.scrapes) >= maxDeltaKeys {
+		for k := range m.scrapes {
+			delete(m.scrapes, k)
+			break
+		}
+	}
+	m.scrapes[key] = cur
+	start := m.resetAt
+	m.deltaMu.Unlock()
+
+	if prev == nil {
+		prev = &Metrics{}
+	} else {
+		start = prev.taken
+	}
+	d := delta(cur, prev)
+	d.Since = &start
+	return d
+}
+
+// delta is cur's counters minus prev's. Gauges and the rolling windows are
+// cur's as they are. A counter lower than in prev was reset or evicted in
+// between, so all of it counts.
+func delta(cur, prev *Metrics) *Metrics {
+	d := &Metrics{
+		taken:         cur.taken,
+		TotalRequests: sub(cur.TotalRequests, prev.TotalRequests),
+		TotalErrors:   sub(cur.TotalErrors, prev.TotalErrors),
+		PHPFatals:     sub(cur.PHPFatals, prev.PHPFatals),
+		IPDenied:      sub(cur.IPDenied, prev.IPDenied),
+		OverLimit:     sub(cur.OverLimit, prev.OverLimit),
+		RateLimited:   sub(cur.RateLimited, prev.RateLimited),
+		Panics:        sub(cur.Panics, prev.Panics),
+		InFlight:      cur.InFlight,
+		BytesIn:       sub(cur.BytesIn, prev.BytesIn),
+		BytesOut:      sub(cur.BytesOut, prev.BytesOut),
+		ByRoute:       make(map[string]*RouteMetrics),
+		ByPool:        make(map[string]*PoolTraffic),
+		Retries: server.RetryStats{
+			Retries:   sub(cur.Retries.Retries, prev.Retries.Retries),
+			Recovered: sub(cur.Retries.Recovered, prev.Retries.Recovered),
+			Exhausted: sub(cur.Retries.Exhausted, prev.Retries.Exhausted),
+		},
+		Static: StaticMetrics{
+			Requests:     sub(cur.Static.Requests, prev.Static.Requests),
+			Bytes:        sub(cur.Static.Bytes, prev.Static.Bytes),
+			NotModified:  sub(cur.Static.NotModified, prev.Static.NotModified),
+			Errors:       sub(cur.Static.Errors, prev.Static.Errors),
+			TotalLatency: time.Duration(sub(uint64(cur.Static.TotalLatency), uint64(prev.Static.TotalLatency))),
+			CacheHits:    sub(cur.Static.CacheHits, prev.Static.CacheHits),
+		},
+		RoutesDropped:    sub(cur.RoutesDropped, prev.RoutesDropped),
+		ErrorsByCategory: make(map[string]uint64),
+	}
+	if c := cur.StaticCache; c != nil {
+		sc := *c
+		if p := prev.StaticCache; p != nil {
+			sc.Hits, sc.Misses = sub(c.Hits, p.Hits), sub(c.Misses, p.Misses)
+		}
+		d.StaticCache = &sc
+	}
+	if c := cur.ResponseCache; c != nil {
+		rc := *c
+		if p := prev.ResponseCache; p != nil {
+			rc.Hits, rc.Misses = sub(c.Hits, p.Hits), sub(c.Misses, p.Misses)
+			rc.Stale, rc.StaleIfError = sub(c.Stale, p.Stale), sub(c.StaleIfError, p.StaleIfError)
+		}
+		d.ResponseCache = &rc
+	}
+
+	// only routes and pools that saw traffic
+	for route, rm := range cur.ByRoute {
+		p := prev.ByRoute[route]
+		if p == nil {
+			p = &RouteMetrics{}
+		}
+		rd := RouteMetrics{
+			Count:        sub(rm.Count, p.Count),
+			TotalLatency: time.Duration(sub(uint64(rm.TotalLatency), uint64(p.TotalLatency))),
+			BytesIn:      sub(rm.BytesIn, p.BytesIn),
+			BytesOut:     sub(rm.BytesOut, p.BytesOut),
+			QueueWait:    time.Duration(sub(uint64(rm.QueueWait), uint64(p.QueueWait))),
+			WorkerTime:   time.Duration(sub(uint64(rm.WorkerTime), uint64(p.WorkerTime))),
+			Windows:      rm.Windows,
+		}
+		if rd.Count > 0 {
+			d.ByRoute[route] = &rd
+		}
+	}
+	for pool, pt := range cur.ByPool {
+		p := prev.ByPool[pool]
+		if p == nil {
+			p = &PoolTraffic{}
+		}
+		pd := PoolTraffic{
+			Requests: sub(pt.Requests, p.Requests),
+			BytesIn:  sub(pt.BytesIn, p.BytesIn),
+			BytesOut: sub(pt.BytesOut, p.BytesOut),
+			Windows:  pt.Windows,
+		}
+		if pd.Requests > 0 {
+			d.ByPool[pool] = &pd
+		}
+	}
+	for category, n := range cur.ErrorsByCategory {
+		if n = sub(n, prev.ErrorsByCategory[category]); n > 0 {
+			d.ErrorsByCategory[category] = n
+		}
+	}
+	for _, e := range cur.RecentErrors {
+		if e.Time.After(prev.taken) {
+			d.RecentErrors = append(d.RecentErrors, e)
+		}
+	}
+	return d
+}
+
+// sub is cur - prev for a counter, or cur if the counter went back to zero
+// in between.
+func sub(cur, prev uint64) uint64 {
+	if cur < prev {
+		return cur
+	}
+	return cur - prev
+}
@@ -0,0 +1,125 @@
+package appserver
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go-php/server"
+	"go-php/server/testkit"
+)
+
+func TestMetricsDelta(t *testing.T) {
+	m := NewMetrics()
+	serve := func(path string, failed bool) {
+		m.StartRequest(path)
+		m.EndPoolRequest(path, "fast", time.Millisecond, failed)
+		m.RecordBytes(path, "fast", 1, 10)
+	}
+	serve("/a", false)
+	serve("/b", true)
+
+	first := m.since("agent", m.Snapshot())
+	if first.TotalRequests != 2 || first.TotalErrors != 1 || len(first.ByRoute) != 2 || first.Since == nil {
+		t.Fatalf("first delta = %+v, want everything since the start", first)
+	}
+
+	serve("/a", false)
+	m.RecordError(&server.RequestPayload{Path: "/a"}, http.StatusBadGateway, server.ErrWorkerCrashed)
+	d := m.since("agent", m.Snapshot())
+	if d.TotalRequests != 1 || d.TotalErrors != 0 || d.BytesOut != 10 {
+		t.Fatalf("delta totals = %d requests, %d errors, %d bytes out", d.TotalRequests, d.TotalErrors, d.BytesOut)
+	}
+	if len(d.ByRoute) != 1 || d.ByRoute["/a"].Count != 1 || d.ByPool["fast"].Requests != 1 {
+		t.Fatalf("delta routes = %v, pools = %v, want only /a's one request", d.ByRoute, d.ByPool)
+	}
+	if len(d.RecentErrors) != 1 || d.ErrorsByCategory[server.CategoryCrashed] != 1 {
+		t.Fatalf("delta errors = %v, %v", d.RecentErrors, d.ErrorsByCategory)
+	}
+	if d.ByRoute["/a"].Windows["1m"].Requests != 2 {
+		t.Fatalf("windows = %+v, want them as they are", d.ByRoute["/a"].Windows)
+	}
+	if !d.Since.Equal(first.taken) {
+		t.Fatalf("Since = %v, want the previous scrape at %v", d.Since, first.taken)
+	}
+
+	// another poller has its own baseline
+	if other := m.since("other-agent", m.Snapshot()); other.TotalRequests != 3 {
+		t.Fatalf("other poller's first delta = %d requests, want 3", other.TotalRequests)
+	}
+}
+
+func TestMetricsReset(t *testing.T) {
+	m := NewMetrics()
+	m.StartRequest("/a")
+	m.EndPoolRequest("/a", "fast", time.Millisecond, true)
+	m.RecordBytes("/a", "fast", 1, 10)
+	m.RecordStatic(http.StatusOK, 100, time.Millisecond)
+	m.RecordError(&server.RequestPayload{Path: "/a"}, http.StatusInternalServerError, errors.New("boom"))
+	m.since("agent", m.Snapshot())
+	m.StartRequest("/slow") // still in flight across the reset
+
+	m.Reset()
+	snap := m.Snapshot()
+	if snap.TotalRequests != 0 || snap.TotalErrors != 0 || snap.BytesOut != 0 || snap.Static.Requests != 0 ||
+		len(snap.ByRoute) != 0 || len(snap.ByPool) != 0 || len(snap.RecentErrors) != 0 || len(snap.ErrorsByCategory) != 0 {
+		t.Fatalf("after reset: %+v", snap)
+	}
+	if snap.InFlight != 1 {
+		t.Fatalf("InFlight = %d, want the request still running", snap.InFlight)
+	}
+
+	m.EndRequest("/slow", time.Second, false)
+	if d := m.since("agent", m.Snapshot()); d.TotalRequests != 0 || d.ByRoute["/slow"].Count != 1 {
+		t.Fatalf("delta after reset = %+v, want it to start from the reset", d)
+	}
+}
+
+func TestMetricsResetEndpoint(t *testing.T) {
+	addr := testkit.Listen(t, func(*server.RequestPayload) testkit.Reply { return testkit.Respond(http.StatusOK, "ok") })
+	srv, err := New(&AppServerConfig{
+		Root:  t.TempDir(),
+		Pools: []PoolConfig{{Name: "fast", Workers: 1, Transport: "tcp", Address: addr}},
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	t.Cleanup(func() { _ = srv.Shutdown(t.Context()) })
+
+	do := func(method, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.RemoteAddr = "127.0.0.1:5000"
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+		return rr
+	}
+	total := func(path string) uint64 {
+		var snap Metrics
+		if err := json.NewDecoder(do("GET", path).Body).Decode(&snap); err != nil {
+			t.Fatal(err)
+		}
+		return snap.TotalRequests
+	}
+
+	do("GET", "/page")
+	if got := total("/__baremetal/metrics?delta=test"); got != 1 {
+		t.Fatalf("delta total = %d, want 1", got)
+	}
+	if got := total("/__baremetal/metrics?delta=test"); got != 0 {
+		t.Fatalf("second delta total = %d, want 0", got)
+	}
+
+	if rr := do("GET", "/__baremetal/metrics/reset"); rr.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("GET reset = %d, want 405", rr.Code)
+	}
+	do("GET", "/page")
+	if rr := do("POST", "/__baremetal/metrics/reset"); rr.Code != http.StatusOK {
+		t.Fatalf("POST reset = %d", rr.Code)
+	}
+	if got := total("/__baremetal/metrics"); got != 0 {
+		t.Fatalf("total after reset = %d, want 0", got)
+	}
+}
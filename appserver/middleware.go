@@ -0,0 +1,159 @@
+package appserver
+
+import (
+	"context"
+	"net/http"
+	"slices"
+
+	"go-php/server"
+)
+
+// Middleware wraps the server's HTTP handler, for auth, tenancy, header
+// rewriting and the like.
+type Middleware func(http.Handler) http.Handler
+
+// DispatchFunc sends the payload built from r to the PHP workers.
+type DispatchFunc func(r *http.Request, req *server.RequestPayload) (*server.ResponsePayload, error)
+
+// DispatchMiddleware wraps the hand-off to PHP: it sees the payload before
+// it goes out and the response before it is written, and can answer on its
+// own by not calling next. An error is reported to the client like a worker
+// error; to refuse a request, return a response with the status instead.
+// For streamed responses next writes to the client itself and returns a
+// nil response; use onStreamHeaders to see its headers. With response_relay on, a large response may come back
+// Relayed, its body still on the worker: call ReadBody before looking at
+// it, or Discard when answering with something else.
+type DispatchMiddleware func(next DispatchFunc) DispatchFunc
+
+// Use adds HTTP middleware around the main handler (not AdminHandler).
+// The first middleware added is the outermost.
+func (s *Server) Use(mw ...Middleware) {
+	s.mwMu.Lock()
+	defer s.mwMu.Unlock()
+
+	s.middleware = append(s.middleware, mw...)
+	h := s.handler
+	for _, m := range slices.Backward(s.middleware) {
+		h = m(h)
+	}
+	s.chain.Store(&h)
+}
+
+// UseDispatch adds dispatch middleware for requests that reach PHP. The
+// first middleware added is the outermost.
+func (s *Server) UseDispatch(mw ...DispatchMiddleware) {
+	s.mwMu.Lock()
+	defer s.mwMu.Unlock()
+
+	// Copy so that requests already reading the old slice are unaffected.
+	s.dispatchMW = append(slices.Clone(s.dispatchMW), mw...)
+}
+
+// dispatch sends payload to app's workers through the dispatch middleware.
+func (s *Server) dispatch(r *http.Request, app *vhost, payload *server.RequestPayload) (*server.ResponsePayload, error) {
+	return s.dispatchChain(func(r *http.Request, req *server.RequestPayload) (*server.ResponsePayload, error) {
+		s.hooks.each(func(h Hooks) { h.OnDispatch(DispatchEvent{Request: r, App: app.name, Payload: req}) })
+		return app.srv.Dispatch(req)
+	})(r, payload)
+}
+
+// dispatchStream streams payload's response from app's workers to w
+// through the dispatch middleware, writing the response of a middleware
+// that answered on its own.
+func (s *Server) dispatchStream(w http.ResponseWriter, r *http.Request, app *vhost, payload *server.RequestPayload) error {
+	streaming(w)
+	sw := &streamHeaderWriter{ResponseWriter: w}
+	r = r.WithContext(context.WithValue(r.Context(), streamHeadersKey{}, sw))
+	resp, err := s.dispatchChain(func(r *http.Request, req *server.RequestPayload) (*server.ResponsePayload, error) {
+		s.hooks.each(func(h Hooks) { h.OnDispatch(DispatchEvent{Request: r, App: app.name, Payload: req, Stream: true}) })
+		return nil, app.srv.DispatchStream(req, sw)
+	})(r, payload)
+	if err == nil && resp != nil {
+		writePayload(w, resp)
+	}
+	return err
+}
+
+type streamHeadersKey struct{}
+
+// streamHeaderWriter runs hooks on a streamed response's status and
+// headers just before they are written. A hook that returns a response
+// replaces the stream: that response is written instead and the rest of
+// PHP's output is dropped.
+type streamHeaderWriter struct {
+	http.ResponseWriter
+	hooks    []func(status int, h http.Header) *server.ResponsePayload
+	wrote    bool
+	replaced bool
+}
+
+// onStreamHeaders adds hook to r's streamed response and reports whether r
+// is streamed. Dispatch middleware calls it before next.
+func onStreamHeaders(r *http.Request, hook func(status int, h http.Header) *server.ResponsePayload) bool {
+	sw, ok := r.Context().Value(streamHeadersKey{}).(*streamHeaderWriter)
+	if ok {
+		sw.hooks = append(sw.hooks, hook)
+	}
+	return ok
+}
+
+func (sw *streamHeaderWriter) WriteHeader(status int) {
+	if sw.wrote {
+		return
+	}
+	sw.wrote = true
+	for _, hook := range sw.hooks {
+		if resp := hook(status, sw.Header()); resp != nil {
+			sw.replaced = true
+			clear(sw.Header())
+			writePayload(sw.ResponseWriter, resp)
+			return
+		}
+	}
+	sw.ResponseWriter.WriteHeader(status)
+}
+
+func (sw *streamHeaderWriter) Write(p []byte) (int, error) {
+	if !sw.wrote {
+		sw.WriteHeader(http.StatusOK)
+	}
+	if sw.replaced {
+		return len(p), nil
+	}
+	return sw.ResponseWriter.Write(p)
+}
+
+func (sw *streamHeaderWriter) Unwrap() http.ResponseWriter { return sw.ResponseWriter }
+
+func (sw *streamHeaderWriter) Flush() {
+	if f, ok := sw.ResponseWriter.(http.Flusher); ok && !sw.replaced {
+		f.Flush()
+	}
+}
+
+func (s *Server) dispatchChain(final DispatchFunc) DispatchFunc {
+	s.mwMu.RLock()
+	mws := s.dispatchMW
+	s.mwMu.RUnlock()
+
+	for _, m := range slices.Backward(mws) {
+		final = m(final)
+	}
+	return final
+}
+
+// writePayload writes resp as is.
+func writePayload(w http.ResponseWriter, resp *server.ResponsePayload) {
+	for k, v := range resp.Headers {
+		w.Header().Set(k, v)
+	}
+	for _, c := range resp.Cookies {
+		w.Header().Add("Set-Cookie", c)
+	}
+	status := resp.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	w.WriteHeader(status)
+	_, _ = w.Write(resp.Body)
+}
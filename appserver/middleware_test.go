@@ -0,0 +1,82 @@
+package appserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go-php/server"
+	"go-php/server/testkit"
+)
+
+// fakePHP is an external worker (the "tcp" transport) that answers every
+// request with handle.
+func fakePHP(t *testing.T, handle func(*server.RequestPayload) *server.ResponsePayload) string {
+	t.Helper()
+	return testkit.Listen(t, func(req *server.RequestPayload) testkit.Reply {
+		return testkit.Reply{Response: handle(req)}
+	})
+}
+
+// newFakePHPServer runs New against a fakePHP worker.
+func newFakePHPServer(t *testing.T, handle func(*server.RequestPayload) *server.ResponsePayload) *Server {
+	t.Helper()
+	srv, err := New(&AppServerConfig{
+		Root:  t.TempDir(),
+		Pools: []PoolConfig{{Name: "fast", Workers: 1, Transport: "tcp", Address: fakePHP(t, handle)}},
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	t.Cleanup(func() { _ = srv.Shutdown(t.Context()) })
+	return srv
+}
+
+func TestMiddlewareOrderAndDispatchHooks(t *testing.T) {
+	srv := newFakePHPServer(t, func(req *server.RequestPayload) *server.ResponsePayload {
+		return &server.ResponsePayload{Status: 200, Body: []byte("tenant=" + strings.Join(req.Headers["X-Tenant"], ","))}
+	})
+
+	var order []string
+	tag := func(name string) Middleware {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				order = append(order, name)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+	srv.Use(tag("outer"), tag("inner"))
+
+	srv.UseDispatch(func(next DispatchFunc) DispatchFunc {
+		return func(r *http.Request, req *server.RequestPayload) (*server.ResponsePayload, error) {
+			if r.Header.Get("Authorization") == "" {
+				return &server.ResponsePayload{Status: http.StatusForbidden, Body: []byte("no")}, nil
+			}
+			req.Headers["X-Tenant"] = []string{"acme"}
+			resp, err := next(r, req)
+			if resp != nil {
+				resp.Headers = map[string]string{"X-Rewritten": "1"}
+			}
+			return resp, err
+		}
+	})
+
+	rr := httptest.NewRecorder()
+	srv.ServeHTTP(rr, httptest.NewRequest("GET", "/page", nil))
+	if rr.Code != http.StatusForbidden || rr.Body.String() != "no" {
+		t.Fatalf("dispatch middleware should answer on its own: %d %q", rr.Code, rr.Body.String())
+	}
+	if strings.Join(order, ",") != "outer,inner" {
+		t.Fatalf("middleware order = %v", order)
+	}
+
+	req := httptest.NewRequest("GET", "/page", nil)
+	req.Header.Set("Authorization", "Bearer x")
+	rr = httptest.NewRecorder()
+	srv.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK || rr.Body.String() != "tenant=acme" || rr.Header().Get("X-Rewritten") != "1" {
+		t.Fatalf("unexpected response: %d %q %v", rr.Code, rr.Body.String(), rr.Header())
+	}
+}
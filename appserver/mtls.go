@@ -0,0 +1,160 @@
+package appserver
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// TLSConfig serves the main listener over HTTPS, optionally asking
+// clients for certificates (mutual TLS).
+type TLSConfig struct {
+	CertFile string `json:"cert_file"`
+	KeyFile  string `json:"key_file"`
+
+	// Certificates and CertDir add pairs for more domains, picked by SNI.
+	Certificates []TLSCertificate `json:"certificates"`
+	CertDir      string           `json:"cert_dir"`
+
+	// ClientAuth is "none" (default), "request" (verify a certificate if
+	// the client sends one) or "require" (refuse clients without a valid
+	// one). Certificates are verified against ClientCAFile.
+	ClientAuth   string `json:"client_auth"`
+	ClientCAFile string `json:"client_ca_file"`
+
+	// Policy. Unset fields keep crypto/tls's defaults, except that
+	// MinVersion defaults to 1.2. CipherSuites only apply up to TLS 1.2;
+	// TLS 1.3's suites are not configurable.
+	MinVersion       string   `json:"min_version"` // "1.2" or "1.3"
+	MaxVersion       string   `json:"max_version"`
+	CipherSuites     []string `json:"cipher_suites"`     // IANA names, e.g. "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"
+	CurvePreferences []string `json:"curve_preferences"` // "X25519", "P256", "P384", "P521", "X25519MLKEM768"
+
+	// Session tickets: crypto/tls rotates its keys daily on its own;
+	// SessionTicketRotationMinutes rotates them faster (the last 3 keys
+	// stay valid).
+	DisableSessionTickets        bool `json:"disable_session_tickets"`
+	SessionTicketRotationMinutes int  `json:"session_ticket_rotation_minutes"`
+}
+
+// Headers carrying the verified client certificate to PHP. They are
+// dropped from every incoming request, over TLS or not, so only the
+// server's own TLS listener can set them.
+var clientCertHeaders = []string{
+	"X-Client-Cert-Subject",
+	"X-Client-Cert-San",
+	"X-Client-Cert-Fingerprint",
+}
+
+func (c TLSConfig) enabled() bool {
+	return c.CertFile != "" || c.KeyFile != "" || len(c.Certificates) > 0 || c.CertDir != ""
+}
+
+// serverTLS builds the listener's tls.Config, resolving relative paths
+// against root.
+func (c TLSConfig) serverTLS(root string) (*tls.Config, error) {
+	abs := func(p string) string {
+		if p == "" || filepath.IsAbs(p) {
+			return p
+		}
+		return filepath.Join(root, p)
+	}
+
+	certs, err := c.loadCertificates(abs)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &tls.Config{Certificates: certs, MinVersion: tls.VersionTLS12, NextProtos: []string{"h2", "http/1.1"}}
+	if err := c.applyPolicy(cfg); err != nil {
+		return nil, err
+	}
+
+	switch c.ClientAuth {
+	case "", "none":
+		return cfg, nil
+	case "request":
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	case "require":
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	default:
+		return nil, fmt.Errorf("tls: unknown client_auth %q", c.ClientAuth)
+	}
+
+	pem, err := os.ReadFile(abs(c.ClientCAFile))
+	if err != nil {
+		return nil, fmt.Errorf("tls: client CA: %w", err)
+	}
+	cfg.ClientCAs = x509.NewCertPool()
+	if !cfg.ClientCAs.AppendCertsFromPEM(pem) {
+		return nil, errors.New("tls: client_ca_file has no PEM certificates")
+	}
+	return cfg, nil
+}
+
+// setClientCertHeaders replaces the client certificate headers with the
+// identity of r's verified client certificate, if it has one. Plain HTTP
+// requests lose them too, so a client can't claim an identity over a
+// listener without TLS.
+func setClientCertHeaders(r *http.Request, headers map[string][]string) {
+	for _, h := range clientCertHeaders {
+		delete(headers, h)
+	}
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.PeerCertificates) == 0 {
+		return
+	}
+
+	cert := r.TLS.PeerCertificates[0]
+	var sans []string
+	for _, d := range cert.DNSNames {
+		sans = append(sans, "DNS:"+d)
+	}
+	for _, u := range cert.URIs {
+		sans = append(sans, "URI:"+u.String())
+	}
+	for _, e := range cert.EmailAddresses {
+		sans = append(sans, "email:"+e)
+	}
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, "IP:"+ip.String())
+	}
+	sum := sha256.Sum256(cert.Raw)
+
+	headers["X-Client-Cert-Subject"] = []string{cert.Subject.String()}
+	if len(sans) > 0 {
+		headers["X-Client-Cert-San"] = []string{strings.Join(sans, ", ")}
+	}
+	headers["X-Client-Cert-Fingerprint"] = []string{hex.EncodeToString(sum[:])}
+}
+
+// validateTLS checks the tls block for settings that can't work.
+func validateTLS(cfg *AppServerConfig, warn configWarnFunc) {
+	t := &cfg.TLS
+	if (t.CertFile == "") != (t.KeyFile == "") {
+		warn("tls", "tls needs both cert_file and key_file")
+	}
+	for i, p := range t.Certificates {
+		if p.CertFile == "" || p.KeyFile == "" {
+			warn(fmt.Sprintf("tls.certificates[%d]", i), "tls.certificates[%d] needs both cert_file and key_file", i)
+		}
+	}
+	validateTLSPolicy(t, warn)
+	// Bad settings are reported and then fail the listener: falling back
+	// to plain HTTP or to no client certificates would open the API to
+	// everyone.
+	switch t.ClientAuth {
+	case "", "none":
+	case "request", "require":
+		if t.ClientCAFile == "" {
+			warn("tls.client_ca_file", "tls.client_auth=%q needs client_ca_file", t.ClientAuth)
+		}
+	default:
+		warn("tls.client_auth", "tls.client_auth=%q is not none, request or require", t.ClientAuth)
+	}
+}
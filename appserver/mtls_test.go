@@ -0,0 +1,146 @@
+package appserver
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func (c testCert) tls() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.cert.Raw}, PrivateKey: c.key}
+}
+
+// issueCert signs tmpl with parent (or self-signs it when parent is nil).
+func issueCert(t *testing.T, tmpl *x509.Certificate, parent *testCert) testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl.SerialNumber = big.NewInt(time.Now().UnixNano())
+	tmpl.NotBefore = time.Now().Add(-time.Hour)
+	tmpl.NotAfter = time.Now().Add(time.Hour)
+	signer, signerKey := tmpl, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testCert{cert, key}
+}
+
+func writePEM(t *testing.T, path, typ string, der []byte) {
+	t.Helper()
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMutualTLSForwardsIdentity(t *testing.T) {
+	dir := t.TempDir()
+	ca := issueCert(t, &x509.Certificate{Subject: pkix.Name{CommonName: "test CA"}, IsCA: true, BasicConstraintsValid: true, KeyUsage: x509.KeyUsageCertSign}, nil)
+	srvCert := issueCert(t, &x509.Certificate{Subject: pkix.Name{CommonName: "localhost"}, IPAddresses: []net.IP{net.IPv4(127, 0, 0, 1)}, ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}}, &ca)
+	spiffe, _ := url.Parse("spiffe://example.org/billing")
+	client := issueCert(t, &x509.Certificate{Subject: pkix.Name{CommonName: "billing", Organization: []string{"Example"}}, URIs: []*url.URL{spiffe}, ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}}, &ca)
+
+	writePEM(t, filepath.Join(dir, "ca.pem"), "CERTIFICATE", ca.cert.Raw)
+	writePEM(t, filepath.Join(dir, "server.pem"), "CERTIFICATE", srvCert.cert.Raw)
+	keyDER, err := x509.MarshalECPrivateKey(srvCert.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePEM(t, filepath.Join(dir, "server.key"), "EC PRIVATE KEY", keyDER)
+
+	start := func(mode string) *httptest.Server {
+		cfg, err := TLSConfig{CertFile: "server.pem", KeyFile: "server.key", ClientAuth: mode, ClientCAFile: "ca.pem"}.serverTLS(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			p := BuildPayload(r)
+			for _, h := range clientCertHeaders {
+				io.WriteString(w, h+"="+strings.Join(p.Headers[h], ",")+"\n")
+			}
+		}))
+		ts.TLS = cfg
+		ts.StartTLS()
+		t.Cleanup(ts.Close)
+		return ts
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	get := func(ts *httptest.Server, certs ...tls.Certificate) (string, error) {
+		c := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots, Certificates: certs}}}
+		req, _ := http.NewRequest("GET", ts.URL, nil)
+		req.Header.Set("X-Client-Cert-Subject", "CN=admin")
+		resp, err := c.Do(req)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		return string(b), nil
+	}
+
+	ts := start("request")
+	body, err := get(ts, client.tls())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(client.cert.Raw)
+	for _, want := range []string{
+		"X-Client-Cert-Subject=CN=billing,O=Example",
+		"X-Client-Cert-San=URI:spiffe://example.org/billing",
+		"X-Client-Cert-Fingerprint=" + hex.EncodeToString(sum[:]),
+	} {
+		if !strings.Contains(body, want+"\n") {
+			t.Errorf("missing %q in:\n%s", want, body)
+		}
+	}
+
+	body, err = get(ts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(body, "X-Client-Cert-Subject=\n") {
+		t.Fatalf("forged subject header was forwarded:\n%s", body)
+	}
+
+	if _, err := get(start("require")); err == nil {
+		t.Fatal("require: request without a client certificate succeeded")
+	}
+
+	plain := httptest.NewRequest("GET", "/", nil)
+	plain.Header.Set("X-Client-Cert-Fingerprint", "forged")
+	if got := BuildPayload(plain).Headers["X-Client-Cert-Fingerprint"]; got != nil {
+		t.Fatalf("plain HTTP request forwarded fingerprint %q", got)
+	}
+}
@@ -0,0 +1,163 @@
+package appserver
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// phpUnserialize decodes PHP's serialize() format. Arrays and objects
+// become map[string]any (keys as strings), scalars become nil, bool,
+// int64, float64 or string. References and custom (C:) serializations
+// aren't supported.
+func phpUnserialize(data []byte) (any, error) {
+	d := phpDecoder{data: data}
+	return d.value()
+}
+
+// phpSessionDecode decodes session data written by PHP's default "php"
+// serialize_handler: name|<serialized value> repeated.
+func phpSessionDecode(data []byte) (map[string]any, error) {
+	d := phpDecoder{data: data}
+	vars := map[string]any{}
+	for d.pos < len(d.data) {
+		i := bytes.IndexByte(d.data[d.pos:], '|')
+		if i < 0 {
+			return nil, errors.New("php session: missing '|'")
+		}
+		name := string(d.data[d.pos : d.pos+i])
+		d.pos += i + 1
+		v, err := d.value()
+		if err != nil {
+			return nil, fmt.Errorf("php session: %s: %w", name, err)
+		}
+		vars[name] = v
+	}
+	return vars, nil
+}
+
+type phpDecoder struct {
+	data []byte
+	pos  int
+}
+
+// until returns the bytes up to the next delim and skips past it.
+func (d *phpDecoder) until(delim byte) (string, error) {
+	i := bytes.IndexByte(d.data[d.pos:], delim)
+	if i < 0 {
+		return "", fmt.Errorf("expected %q at %d", delim, d.pos)
+	}
+	s := string(d.data[d.pos : d.pos+i])
+	d.pos += i + 1
+	return s, nil
+}
+
+func (d *phpDecoder) expect(s string) error {
+	if !bytes.HasPrefix(d.data[d.pos:], []byte(s)) {
+		return fmt.Errorf("expected %q at %d", s, d.pos)
+	}
+	d.pos += len(s)
+	return nil
+}
+
+// str reads a length-prefixed "..." string; the length is in bytes.
+func (d *phpDecoder) str() (string, error) {
+	n, err := d.until(':')
+	if err != nil {
+		return "", err
+	}
+	size, err := strconv.Atoi(n)
+	if err != nil || size < 0 || d.pos+size+2 > len(d.data) {
+		return "", fmt.Errorf("bad string length %q", n)
+	}
+	if err := d.expect(`"`); err != nil {
+		return "", err
+	}
+	s := string(d.data[d.pos : d.pos+size])
+	d.pos += size
+	return s, d.expect(`"`)
+}
+
+func (d *phpDecoder) value() (any, error) {
+	if d.pos+1 >= len(d.data) {
+		return nil, errors.New("unexpected end of data")
+	}
+	kind := d.data[d.pos]
+	if kind == 'N' {
+		d.pos++
+		return nil, d.expect(";")
+	}
+	d.pos++
+	if err := d.expect(":"); err != nil {
+		return nil, err
+	}
+
+	switch kind {
+	case 'b':
+		s, err := d.until(';')
+		return s == "1", err
+	case 'i':
+		s, err := d.until(';')
+		if err != nil {
+			return nil, err
+		}
+		return strconv.ParseInt(s, 10, 64)
+	case 'd':
+		s, err := d.until(';')
+		if err != nil {
+			return nil, err
+		}
+		return strconv.ParseFloat(s, 64)
+	case 's':
+		s, err := d.str()
+		if err != nil {
+			return nil, err
+		}
+		return s, d.expect(";")
+	case 'a':
+		return d.members()
+	case 'O':
+		if _, err := d.str(); err != nil { // class name
+			return nil, err
+		}
+		if err := d.expect(":"); err != nil {
+			return nil, err
+		}
+		return d.members()
+	}
+	return nil, fmt.Errorf("unsupported type %q at %d", kind, d.pos-2)
+}
+
+// members reads count:{key value ...} into a map. Private and protected
+// object properties lose their "\x00Class\x00" / "\x00*\x00" prefixes.
+func (d *phpDecoder) members() (map[string]any, error) {
+	n, err := d.until(':')
+	if err != nil {
+		return nil, err
+	}
+	count, err := strconv.Atoi(n)
+	if err != nil || count < 0 {
+		return nil, fmt.Errorf("bad member count %q", n)
+	}
+	if err := d.expect("{"); err != nil {
+		return nil, err
+	}
+	m := make(map[string]any, min(count, 64))
+	for range count {
+		k, err := d.value()
+		if err != nil {
+			return nil, err
+		}
+		v, err := d.value()
+		if err != nil {
+			return nil, err
+		}
+		key := fmt.Sprint(k)
+		if i := bytes.LastIndexByte([]byte(key), 0); i >= 0 {
+			key = key[i+1:]
+		}
+		m[key] = v
+	}
+	return m, d.expect("}")
+}
@@ -0,0 +1,43 @@
+package appserver
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"go-php/server"
+)
+
+// poolResizeHandler serves POST /__baremetal/pools/{name}/resize with a body
+// of {"workers": n} (?app= selects the virtual host). It answers with the
+// pool's stats after the resize.
+func poolResizeHandler(vhosts *vhostRouter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		srv := vhosts.byName(r.URL.Query().Get("app")).srv
+		name := r.PathValue("name")
+
+		var body struct {
+			Workers *int `json:"workers"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Workers == nil {
+			http.Error(w, "expected {\"workers\": n}", http.StatusBadRequest)
+			return
+		}
+
+		pool := srv.Pool(name)
+		if pool == nil {
+			http.Error(w, "unknown pool", http.StatusNotFound)
+			return
+		}
+		if *body.Workers < 1 {
+			http.Error(w, "workers must be at least 1", http.StatusBadRequest)
+			return
+		}
+		if err := srv.ResizePool(name, *body.Workers); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]server.PoolStats{name: pool.Stats()})
+	}
+}
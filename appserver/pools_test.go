@@ -0,0 +1,31 @@
+package appserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPoolResizeHandler(t *testing.T) {
+	srv := newCanaryTestServer(t)
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /__baremetal/pools/{name}/resize", poolResizeHandler(&vhostRouter{def: &vhost{name: "default", srv: srv}}))
+
+	cases := []struct {
+		path, body string
+		want       int
+	}{
+		{"/__baremetal/pools/nope/resize", `{"workers": 2}`, http.StatusNotFound},
+		{"/__baremetal/pools/stable/resize", `{}`, http.StatusBadRequest},
+		{"/__baremetal/pools/stable/resize", `{"workers": 0}`, http.StatusBadRequest},
+		{"/__baremetal/pools/stable/resize", `{"workers": 1}`, http.StatusOK},
+	}
+	for _, c := range cases {
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, c.path, strings.NewReader(c.body)))
+		if rr.Code != c.want {
+			t.Errorf("%s %s: got %d, want %d (%s)", c.path, c.body, rr.Code, c.want, rr.Body.String())
+		}
+	}
+}
@@ -0,0 +1,21 @@
+package appserver
+
+import (
+	"net/http"
+	"net/http/pprof"
+)
+
+// pprofPrefix is where the Go profiler is mounted when admin.pprof is set.
+const pprofPrefix = "/__baremetal/debug/pprof/"
+
+// registerPprof mounts net/http/pprof under pprofPrefix behind admin auth.
+func registerPprof(mux *http.ServeMux, admin AdminConfig) {
+	// pprof.Index looks up named profiles under /debug/pprof/.
+	index := http.StripPrefix("/__baremetal", http.HandlerFunc(pprof.Index))
+
+	mux.Handle(pprofPrefix, requireAdmin(admin, index))
+	mux.Handle(pprofPrefix+"cmdline", requireAdmin(admin, http.HandlerFunc(pprof.Cmdline)))
+	mux.Handle(pprofPrefix+"profile", requireAdmin(admin, http.HandlerFunc(pprof.Profile)))
+	mux.Handle(pprofPrefix+"symbol", requireAdmin(admin, http.HandlerFunc(pprof.Symbol)))
+	mux.Handle(pprofPrefix+"trace", requireAdmin(admin, http.HandlerFunc(pprof.Trace)))
+}
@@ -0,0 +1,47 @@
+package appserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPprofRequiresAdminToken(t *testing.T) {
+	mux := http.NewServeMux()
+	registerPprof(mux, AdminConfig{Token: "s3cret", Pprof: true})
+
+	req := httptest.NewRequest(http.MethodGet, pprofPrefix+"goroutine?debug=1", nil)
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("without token: got %d, want 401", rr.Code)
+	}
+
+	req.Header.Set("Authorization", "Bearer s3cret")
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("with token: got %d, want 200", rr.Code)
+	}
+	if !strings.Contains(rr.Body.String(), "goroutine") {
+		t.Fatalf("expected a goroutine dump, got %q", rr.Body.String())
+	}
+}
+
+func TestAdminAuthLoopbackWithoutToken(t *testing.T) {
+	t.Setenv("APP_ADMIN_TOKEN", "")
+	a := AdminConfig{}
+
+	local := httptest.NewRequest(http.MethodGet, "/", nil)
+	local.RemoteAddr = "127.0.0.1:5000"
+	if !a.authorized(local) {
+		t.Fatalf("loopback client should be allowed without a token")
+	}
+
+	remote := httptest.NewRequest(http.MethodGet, "/", nil)
+	remote.RemoteAddr = "203.0.113.9:5000"
+	if a.authorized(remote) {
+		t.Fatalf("remote client should be denied without a token")
+	}
+}
@@ -0,0 +1,148 @@
+package appserver
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// ProxyRule forwards requests under Prefix to an upstream HTTP(S) origin
+// instead of the PHP workers. WebSocket upgrades are passed through.
+type ProxyRule struct {
+	Prefix   string `json:"prefix"`
+	Upstream string `json:"upstream"` // e.g. "https://legacy.internal:8443/base"
+
+	StripPrefix  bool `json:"strip_prefix"`  // /legacy/x → upstream /x
+	PreserveHost bool `json:"preserve_host"` // send the client's Host instead of the upstream's
+
+	SetHeaders      map[string]string `json:"set_headers"`      // added to the upstream request
+	RemoveHeaders   []string          `json:"remove_headers"`   // dropped from the upstream request
+	ResponseHeaders map[string]string `json:"response_headers"` // added to the client response
+}
+
+type proxyRoute struct {
+	prefix  string
+	handler http.Handler
+}
+
+// proxyRouter holds an app's proxy rules, longest prefix first.
+type proxyRouter []proxyRoute
+
+func newProxyRouter(rules []ProxyRule) proxyRouter {
+	var routes proxyRouter
+	for _, rule := range rules {
+		h, err := newProxyHandler(rule)
+		if err != nil {
+			log.Printf("[proxy] %s: %v, skipping", rule.Prefix, err)
+			continue
+		}
+		routes = append(routes, proxyRoute{prefix: rule.Prefix, handler: h})
+	}
+	sort.SliceStable(routes, func(i, j int) bool {
+		return len(routes[i].prefix) > len(routes[j].prefix)
+	})
+	return routes
+}
+
+// match returns the proxy for path, or nil if PHP should handle it.
+func (pr proxyRouter) match(path string) http.Handler {
+	for _, rt := range pr {
+		if underPrefix(path, rt.prefix) {
+			return rt.handler
+		}
+	}
+	return nil
+}
+
+// underPrefix reports whether path is prefix or lies below it, so "/api"
+// covers "/api" and "/api/users" but not "/apiary".
+func underPrefix(path, prefix string) bool {
+	if !strings.HasPrefix(path, prefix) {
+		return false
+	}
+	return len(path) == len(prefix) || strings.HasSuffix(prefix, "/") || path[len(prefix)] == '/'
+}
+
+// stripPrefix removes prefix from u's path, leaving one slash in front of
+// the rest. The escaped form loses it too, so encoded slashes reach the
+// upstream intact.
+func stripPrefix(u *url.URL, prefix string) {
+	trim := func(path, prefix string) string {
+		return "/" + strings.TrimPrefix(strings.TrimPrefix(path, prefix), "/")
+	}
+	raw := u.RawPath
+	u.Path, u.RawPath = trim(u.Path, prefix), ""
+	if escaped := (&url.URL{Path: prefix}).EscapedPath(); raw != "" && strings.HasPrefix(raw, escaped) {
+		u.RawPath = trim(raw, escaped)
+	}
+}
+
+func parseUpstream(raw string) (*url.URL, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("upstream %q must be an absolute http(s) URL", raw)
+	}
+	return u, nil
+}
+
+func newProxyHandler(rule ProxyRule) (http.Handler, error) {
+	target, err := parseUpstream(rule.Upstream)
+	if err != nil {
+		return nil, err
+	}
+
+	rp := &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			if rule.StripPrefix {
+				stripPrefix(pr.Out.URL, rule.Prefix)
+			}
+			pr.SetURL(target)
+			pr.SetXForwarded()
+			if rule.PreserveHost {
+				pr.Out.Host = pr.In.Host
+			}
+
+			for _, h := range rule.RemoveHeaders {
+				pr.Out.Header.Del(h)
+			}
+			for k, v := range rule.SetHeaders {
+				pr.Out.Header.Set(k, v)
+			}
+		},
+		ModifyResponse: func(resp *http.Response) error {
+			for k, v := range rule.ResponseHeaders {
+				resp.Header.Set(k, v)
+			}
+			return nil
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			log.Printf("[proxy] %s %s -> %s: %v", r.Method, r.URL.Path, rule.Upstream, err)
+			http.Error(w, "Bad Gateway", http.StatusBadGateway)
+		},
+	}
+	return rp, nil
+}
+
+// validateProxies normalises proxy rules, dropping ones without a usable upstream.
+func validateProxies(cfg *AppServerConfig, warn configWarnFunc) {
+	rules := cfg.Proxy[:0]
+	for i, rule := range cfg.Proxy {
+		if _, err := parseUpstream(rule.Upstream); err != nil {
+			warn(fmt.Sprintf("proxy[%d].upstream", i), "proxy[%d]: %v, ignoring it", i, err)
+			continue
+		}
+		if !strings.HasPrefix(rule.Prefix, "/") {
+			warn(fmt.Sprintf("proxy[%d].prefix", i), "proxy[%d].prefix=%q does not start with '/', fixing", i, rule.Prefix)
+			rule.Prefix = "/" + rule.Prefix
+		}
+		rules = append(rules, rule)
+	}
+	cfg.Proxy = rules
+}
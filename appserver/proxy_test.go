@@ -0,0 +1,130 @@
+package appserver
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestProxyRouterRewritesRequest(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Upstream-Path", r.URL.Path)
+		w.Header().Set("X-Upstream-Raw", r.URL.EscapedPath())
+		w.Header().Set("X-Upstream-Host", r.Host)
+		w.Header().Set("X-Upstream-Tag", r.Header.Get("X-Tag"))
+		w.Header().Set("X-Upstream-Cookie", r.Header.Get("Cookie"))
+		_, _ = io.WriteString(w, "legacy")
+	}))
+	defer upstream.Close()
+
+	pr := newProxyRouter([]ProxyRule{
+		{Prefix: "/legacy/", Upstream: upstream.URL + "/base", StripPrefix: true,
+			SetHeaders: map[string]string{"X-Tag": "go-php"}, RemoveHeaders: []string{"Cookie"},
+			ResponseHeaders: map[string]string{"X-Proxied": "1"}},
+		{Prefix: "/legacy/keep/", Upstream: upstream.URL, PreserveHost: true},
+		{Prefix: "/api", Upstream: upstream.URL + "/v2", StripPrefix: true},
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "http://app.test/legacy/users/1", nil)
+	req.Header.Set("Cookie", "sid=secret")
+	rr := httptest.NewRecorder()
+	pr.match(req.URL.Path).ServeHTTP(rr, req)
+
+	if rr.Body.String() != "legacy" || rr.Header().Get("X-Proxied") != "1" {
+		t.Fatalf("unexpected response: %d %q %v", rr.Code, rr.Body.String(), rr.Header())
+	}
+	if got := rr.Header().Get("X-Upstream-Path"); got != "/base/users/1" {
+		t.Fatalf("upstream path = %q, want /base/users/1", got)
+	}
+	if rr.Header().Get("X-Upstream-Tag") != "go-php" || rr.Header().Get("X-Upstream-Cookie") != "" {
+		t.Fatalf("request headers not rewritten: %v", rr.Header())
+	}
+
+	// Longest prefix wins; PreserveHost keeps the client's Host.
+	req = httptest.NewRequest(http.MethodGet, "http://app.test/legacy/keep/x", nil)
+	rr = httptest.NewRecorder()
+	pr.match(req.URL.Path).ServeHTTP(rr, req)
+	if rr.Header().Get("X-Upstream-Path") != "/legacy/keep/x" || rr.Header().Get("X-Upstream-Host") != "app.test" {
+		t.Fatalf("unexpected upstream view: %v", rr.Header())
+	}
+
+	if pr.match("/other") != nil || pr.match("/apiary") != nil {
+		t.Fatalf("expected no proxy for unmatched path")
+	}
+
+	// A prefix without a trailing slash matches whole segments and keeps
+	// one slash, and encoded slashes stay encoded.
+	for path, want := range map[string]string{"/api": "/v2/", "/api/users": "/v2/users", "/api/a%2Fb": "/v2/a%2Fb"} {
+		req = httptest.NewRequest(http.MethodGet, "http://app.test"+path, nil)
+		rr = httptest.NewRecorder()
+		pr.match(req.URL.Path).ServeHTTP(rr, req)
+		if got := rr.Header().Get("X-Upstream-Raw"); got != want {
+			t.Errorf("%s reached the upstream as %q, want %q", path, got, want)
+		}
+	}
+}
+
+func TestProxyRouterPassesWebSocketUpgrades(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		mt, msg, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		_ = conn.WriteMessage(mt, append([]byte("echo:"), msg...))
+	}))
+	defer upstream.Close()
+
+	pr := newProxyRouter([]ProxyRule{{Prefix: "/socket", Upstream: upstream.URL}})
+	front := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pr.match(r.URL.Path).ServeHTTP(w, r)
+	}))
+	defer front.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(front.URL, "http")+"/socket", nil)
+	if err != nil {
+		t.Fatalf("dial through proxy: %v", err)
+	}
+	defer conn.Close()
+
+	if err := conn.WriteMessage(websocket.TextMessage, []byte("hi")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	_, msg, err := conn.ReadMessage()
+	if err != nil || string(msg) != "echo:hi" {
+		t.Fatalf("read = %q, %v", msg, err)
+	}
+}
+
+func TestProxyRouterUpstreamDown(t *testing.T) {
+	pr := newProxyRouter([]ProxyRule{{Prefix: "/", Upstream: "http://127.0.0.1:1"}})
+
+	rr := httptest.NewRecorder()
+	pr.match("/x").ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/x", nil))
+	if rr.Code != http.StatusBadGateway {
+		t.Fatalf("expected 502, got %d", rr.Code)
+	}
+}
+
+func TestValidateProxies(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Proxy = []ProxyRule{
+		{Prefix: "api/v1/", Upstream: "https://api.internal"},
+		{Prefix: "/bad", Upstream: "ftp://x"},
+		{Prefix: "/rel", Upstream: "/not-absolute"},
+	}
+	validateProxies(cfg, logConfig.warn)
+
+	if len(cfg.Proxy) != 1 || cfg.Proxy[0].Prefix != "/api/v1/" {
+		t.Fatalf("unexpected proxies after validation: %+v", cfg.Proxy)
+	}
+}
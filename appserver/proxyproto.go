@@ -0,0 +1,217 @@
+package appserver
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ProxyProtocolConfig reads PROXY protocol (v1 or v2) headers sent by a
+// TCP load balancer, so RemoteAddr is the real client. Only connections
+// from Trusted addresses may send one; from those the header is optional.
+type ProxyProtocolConfig struct {
+	Enabled         bool     `json:"enabled"`
+	Trusted         []string `json:"trusted"`           // CIDRs or addresses of the load balancers
+	HeaderTimeoutMs int      `json:"header_timeout_ms"` // default 5000
+}
+
+var (
+	proxyV1Prefix = []byte("PROXY ")
+	proxyV2Sig    = []byte("\r\n\r\n\x00\r\nQUIT\n")
+)
+
+// proxyProtoListener wraps accepted connections from trusted peers in
+// proxyProtoConn.
+type proxyProtoListener struct {
+	net.Listener
+	trusted []netip.Prefix
+	timeout time.Duration
+}
+
+func newProxyProtoListener(ln net.Listener, cfg ProxyProtocolConfig) net.Listener {
+	pl := &proxyProtoListener{Listener: ln, timeout: time.Duration(cfg.HeaderTimeoutMs) * time.Millisecond}
+	if pl.timeout <= 0 {
+		pl.timeout = 5 * time.Second
+	}
+	for _, s := range cfg.Trusted {
+		if p, err := parseIPRule(s); err == nil {
+			pl.trusted = append(pl.trusted, p)
+		}
+	}
+	return pl
+}
+
+func (pl *proxyProtoListener) Accept() (net.Conn, error) {
+	c, err := pl.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	peer, ok := c.RemoteAddr().(*net.TCPAddr)
+	if !ok || !containsAddr(pl.trusted, peer.AddrPort().Addr().Unmap()) {
+		return c, nil
+	}
+	return &proxyProtoConn{Conn: c, r: bufio.NewReader(c), timeout: pl.timeout}, nil
+}
+
+// proxyProtoConn reads the PROXY header on first use (from the connection's
+// own goroutine, not Accept) and then reports the client it names.
+type proxyProtoConn struct {
+	net.Conn
+	r       *bufio.Reader
+	timeout time.Duration
+
+	once   sync.Once
+	remote net.Addr
+	err    error
+}
+
+func (c *proxyProtoConn) init() {
+	c.once.Do(func() {
+		_ = c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+		c.remote, c.err = readProxyHeader(c.r)
+		_ = c.Conn.SetReadDeadline(time.Time{})
+		if c.err != nil {
+			c.Conn.Close()
+		}
+	})
+}
+
+func (c *proxyProtoConn) Read(p []byte) (int, error) {
+	c.init()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.r.Read(p)
+}
+
+func (c *proxyProtoConn) RemoteAddr() net.Addr {
+	c.init()
+	if c.remote != nil {
+		return c.remote
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// readProxyHeader consumes a PROXY header if the stream starts with one.
+// It returns the client address, or nil when there is no header or it
+// carries no address (v1 UNKNOWN, v2 LOCAL).
+func readProxyHeader(r *bufio.Reader) (net.Addr, error) {
+	start, err := r.Peek(len(proxyV1Prefix))
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	switch {
+	case bytes.Equal(start, proxyV1Prefix):
+		return readProxyV1(r)
+	case bytes.Equal(start, proxyV2Sig[:len(start)]):
+		return readProxyV2(r)
+	}
+	return nil, nil
+}
+
+func readProxyV1(r *bufio.Reader) (net.Addr, error) {
+	// The whole line is at most 107 bytes.
+	var line []byte
+	for len(line) < 107 {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("proxy protocol v1: %w", err)
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	text, ok := strings.CutSuffix(string(line), "\r\n")
+	if !ok {
+		return nil, errors.New("proxy protocol v1: header too long")
+	}
+	fields := strings.Fields(text)
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, fmt.Errorf("proxy protocol v1: malformed header %q", text)
+	}
+	addr, err := netip.ParseAddr(fields[2])
+	if err != nil {
+		return nil, fmt.Errorf("proxy protocol v1: %w", err)
+	}
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("proxy protocol v1: bad port %q", fields[4])
+	}
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(addr, uint16(port))), nil
+}
+
+func readProxyV2(r *bufio.Reader) (net.Addr, error) {
+	hdr := make([]byte, 16)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return nil, fmt.Errorf("proxy protocol v2: %w", err)
+	}
+	if !bytes.Equal(hdr[:12], proxyV2Sig) {
+		return nil, errors.New("proxy protocol v2: bad signature")
+	}
+	if hdr[12]>>4 != 2 {
+		return nil, fmt.Errorf("proxy protocol v2: unsupported version %d", hdr[12]>>4)
+	}
+	body := make([]byte, binary.BigEndian.Uint16(hdr[14:]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, fmt.Errorf("proxy protocol v2: %w", err)
+	}
+	if hdr[12]&0x0f == 0 { // LOCAL: the balancer's own health check
+		return nil, nil
+	}
+
+	var src netip.Addr
+	var port uint16
+	switch hdr[13] >> 4 {
+	case 1: // AF_INET
+		if len(body) < 12 {
+			return nil, errors.New("proxy protocol v2: short IPv4 address block")
+		}
+		src = netip.AddrFrom4([4]byte(body[:4]))
+		port = binary.BigEndian.Uint16(body[8:])
+	case 2: // AF_INET6
+		if len(body) < 36 {
+			return nil, errors.New("proxy protocol v2: short IPv6 address block")
+		}
+		src = netip.AddrFrom16([16]byte(body[:16])).Unmap()
+		port = binary.BigEndian.Uint16(body[32:])
+	default: // AF_UNSPEC or AF_UNIX: nothing usable
+		return nil, nil
+	}
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(src, port)), nil
+}
+
+// validateProxyProtocol reports trusted entries that don't parse.
+func validateProxyProtocol(cfg *AppServerConfig, warn configWarnFunc) {
+	pp := cfg.ProxyProtocol
+	if !pp.Enabled {
+		return
+	}
+	if len(pp.Trusted) == 0 {
+		warn("proxy_protocol.trusted", "proxy_protocol is enabled but trusted is empty, PROXY headers will be ignored")
+	}
+	for i, s := range pp.Trusted {
+		if _, err := parseIPRule(s); err != nil {
+			warn(fmt.Sprintf("proxy_protocol.trusted[%d]", i), "proxy_protocol.trusted[%d]=%q is not an address or CIDR, ignoring it", i, s)
+		}
+	}
+	if pp.HeaderTimeoutMs < 0 {
+		warn("proxy_protocol.header_timeout_ms", "proxy_protocol.header_timeout_ms=%d is invalid, using 5000", pp.HeaderTimeoutMs)
+		cfg.ProxyProtocol.HeaderTimeoutMs = 0
+	}
+}
@@ -0,0 +1,99 @@
+package appserver
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestReadProxyHeader(t *testing.T) {
+	v2 := func(cmd, fam byte, addr []byte) string {
+		hdr := append([]byte(nil), proxyV2Sig...)
+		hdr = append(hdr, 0x20|cmd, fam)
+		hdr = binary.BigEndian.AppendUint16(hdr, uint16(len(addr)))
+		return string(append(hdr, addr...))
+	}
+	ipv4 := []byte{198, 51, 100, 7, 10, 0, 0, 1, 0xc8, 0x22, 0, 80}
+	ipv6 := make([]byte, 36)
+	copy(ipv6, net.ParseIP("2001:db8::7"))
+	binary.BigEndian.PutUint16(ipv6[32:], 443)
+
+	cases := []struct {
+		name, in, want, rest string
+		err                  bool
+	}{
+		{"v1 tcp4", "PROXY TCP4 198.51.100.7 10.0.0.1 51234 80\r\nGET /", "198.51.100.7:51234", "GET /", false},
+		{"v1 tcp6", "PROXY TCP6 2001:db8::7 2001:db8::1 443 80\r\nGET /", "[2001:db8::7]:443", "GET /", false},
+		{"v1 unknown", "PROXY UNKNOWN\r\nGET /", "", "GET /", false},
+		{"v1 malformed", "PROXY TCP4 nope\r\n", "", "", true},
+		{"v2 ipv4", v2(1, 0x11, ipv4) + "GET /", "198.51.100.7:51234", "GET /", false},
+		{"v2 ipv6 with TLV", v2(1, 0x21, append(ipv6, 0x04, 0, 1, 'x')) + "GET /", "[2001:db8::7]:443", "GET /", false},
+		{"v2 local", v2(0, 0x11, ipv4) + "GET /", "", "GET /", false},
+		{"no header", "GET / HTTP/1.1\r\n", "", "GET / HTTP/1.1\r\n", false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			r := bufio.NewReader(strings.NewReader(c.in))
+			addr, err := readProxyHeader(r)
+			if c.err {
+				if err == nil {
+					t.Fatalf("want an error, got %v", addr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := ""
+			if addr != nil {
+				got = addr.String()
+			}
+			rest, _ := io.ReadAll(r)
+			if got != c.want || string(rest) != c.rest {
+				t.Fatalf("addr %q rest %q, want %q %q", got, rest, c.want, c.rest)
+			}
+		})
+	}
+}
+
+func TestProxyProtoListenerTrust(t *testing.T) {
+	serve := func(trusted string) string {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = io.WriteString(w, r.RemoteAddr)
+		})}
+		go srv.Serve(newProxyProtoListener(ln, ProxyProtocolConfig{Enabled: true, Trusted: []string{trusted}}))
+		defer srv.Close()
+
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		_, _ = io.WriteString(conn, "PROXY TCP4 198.51.100.7 10.0.0.1 51234 80\r\nGET / HTTP/1.1\r\nHost: x\r\nConnection: close\r\n\r\n")
+		resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != http.StatusOK {
+			return resp.Status
+		}
+		return string(body)
+	}
+
+	if got := serve("127.0.0.1"); got != "198.51.100.7:51234" {
+		t.Fatalf("trusted peer: RemoteAddr = %q", got)
+	}
+	if got := serve("10.0.0.0/8"); got != "400 Bad Request" {
+		t.Fatalf("untrusted peer's PROXY header was honoured: %q", got)
+	}
+}
@@ -0,0 +1,74 @@
+package appserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go-php/server"
+)
+
+func TestQueueWaitAndWorkerTime(t *testing.T) {
+	srv, err := New(&AppServerConfig{
+		Root: t.TempDir(),
+		Pools: []PoolConfig{{Name: "fast", Workers: 1, Transport: "tcp", Address: fakePHP(t, func(req *server.RequestPayload) *server.ResponsePayload {
+			time.Sleep(50 * time.Millisecond)
+			return &server.ResponsePayload{Status: http.StatusOK, Body: []byte("ok")}
+		})}},
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	t.Cleanup(func() { _ = srv.Shutdown(t.Context()) })
+
+	var logs bytes.Buffer
+	prev := log.Writer()
+	log.SetOutput(&logs)
+	t.Cleanup(func() { log.SetOutput(prev) })
+
+	// two requests on one worker: one of them queues behind the other
+	var wg sync.WaitGroup
+	for range 2 {
+		wg.Go(func() {
+			srv.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/slow", nil))
+		})
+	}
+	wg.Wait()
+	log.SetOutput(prev)
+
+	rm := srv.metrics.Snapshot().ByRoute["/slow"]
+	if rm == nil || rm.Count != 2 {
+		t.Fatalf("route metrics = %+v", rm)
+	}
+	if rm.WorkerTime < 100*time.Millisecond {
+		t.Errorf("worker time = %v, want at least 2×50ms", rm.WorkerTime)
+	}
+	if rm.QueueWait < 30*time.Millisecond {
+		t.Errorf("queue wait = %v, want most of one request's 50ms", rm.QueueWait)
+	}
+
+	var maxQueue float64
+	for line := range strings.Lines(logs.String()) {
+		_, js, ok := strings.Cut(line, "{")
+		if !ok || !strings.Contains(js, `"path":"/slow"`) {
+			continue
+		}
+		var entry RequestLog
+		if err := json.Unmarshal([]byte("{"+js), &entry); err != nil {
+			t.Fatalf("log line %q: %v", line, err)
+		}
+		if entry.WorkerMs < 50 {
+			t.Errorf("worker_ms = %v, want at least 50", entry.WorkerMs)
+		}
+		maxQueue = max(maxQueue, entry.QueueMs)
+	}
+	if maxQueue < 30 {
+		t.Errorf("largest queue_ms = %v, want the queued request's wait", maxQueue)
+	}
+}
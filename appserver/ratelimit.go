@@ -0,0 +1,346 @@
+package appserver
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// RateLimitConfig limits how fast each client may call a path prefix,
+// with a token bucket per rule and client. Over a limit the server
+// answers 429 with Retry-After before static files, proxies or PHP see
+// the request.
+type RateLimitConfig struct {
+	Rules []RateLimitRule `json:"rules"`
+
+	// Store keeps the buckets: "memory" (default) for this server alone,
+	// or "redis" to share them with every server using the same Redis.
+	Store         string `json:"store"`
+	RedisAddr     string `json:"redis_addr"` // default 127.0.0.1:6379
+	RedisPassword string `json:"redis_password"`
+	RedisDB       int    `json:"redis_db"`
+	KeyPrefix     string `json:"key_prefix"` // default "go-php:ratelimit:"
+}
+
+// RateLimitRule allows RPS requests a second to Prefix, in bursts of up to
+// Burst, per client. Every rule matching a request applies.
+type RateLimitRule struct {
+	Prefix  string   `json:"prefix"`
+	Methods []string `json:"methods"` // empty matches every method
+	RPS     float64  `json:"rps"`
+	Burst   int      `json:"burst"` // default RPS, at least 1
+
+	// Key tells clients apart: "ip" (default), "user" for the
+	// authenticated user ID (same rules as the realtime endpoints, but
+	// never the unsigned bm_user_id cookie) or "api_key" for the
+	// APIKeyHeader value. Keys can't be checked here, so an api_key rule
+	// also limits each IP across all the keys it sends, and made-up keys
+	// don't get fresh buckets. Requests without a verified user or a key
+	// are limited by IP.
+	Key          string `json:"key"`
+	APIKeyHeader string `json:"api_key_header"` // default X-API-Key
+}
+
+// Rate limit keys.
+const (
+	RateKeyIP     = "ip"
+	RateKeyUser   = "user"
+	RateKeyAPIKey = "api_key"
+)
+
+// rateStore takes tokens out of buckets.
+type rateStore interface {
+	// take removes a token from key's bucket, returning 0 if there was
+	// one and otherwise how long until there will be.
+	take(key string, rps float64, burst int) (time.Duration, error)
+}
+
+type rateLimiter struct {
+	rules  atomic.Pointer[[]RateLimitRule] // replaced by PATCH /__baremetal/config
+	prefix string
+	store  rateStore
+	auth   *wsAuth
+
+	failing atomic.Bool // the store is failing open, and that was logged
+}
+
+func newRateLimiter(cfg *RateLimitConfig, auth *wsAuth) *rateLimiter {
+	rl := &rateLimiter{prefix: cfg.KeyPrefix, auth: auth}
+	rl.setRules(cfg.Rules)
+	if rl.prefix == "" {
+		rl.prefix = "go-php:ratelimit:"
+	}
+	if cfg.Store == "redis" {
+		addr := cfg.RedisAddr
+		if addr == "" {
+			addr = "127.0.0.1:6379"
+		}
+		rl.store = &redisRateStore{redis: newRedisClient(addr, cfg.RedisPassword, cfg.RedisDB)}
+	} else {
+		rl.store = &memoryRateStore{buckets: make(map[string]*tokenBucket)}
+	}
+	return rl
+}
+
+func (rl *rateLimiter) setRules(rules []RateLimitRule) {
+	rl.rules.Store(&rules)
+}
+
+func (rl *rateLimiter) currentRules() []RateLimitRule {
+	return *rl.rules.Load()
+}
+
+func (rule *RateLimitRule) matches(r *http.Request) bool {
+	return strings.HasPrefix(r.URL.Path, rule.Prefix) &&
+		(len(rule.Methods) == 0 || slices.ContainsFunc(rule.Methods, func(m string) bool { return strings.EqualFold(m, r.Method) }))
+}
+
+// clients names r's buckets under rule. Users and API keys are hashed so
+// the keys don't end up in Redis.
+func (rule *RateLimitRule) clients(r *http.Request, auth *wsAuth) []string {
+	ip := "ip:" + clientAddr(r).String()
+	switch rule.Key {
+	case RateKeyUser:
+		if id, err := verifiedUser(auth, r); err == nil && id != "" {
+			return []string{"user:" + hashKey(id)}
+		}
+	case RateKeyAPIKey:
+		if k := r.Header.Get(rule.APIKeyHeader); k != "" {
+			return []string{"key:" + hashKey(k), ip}
+		}
+	}
+	return []string{ip}
+}
+
+func hashKey(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:16])
+}
+
+// allow takes a token for r from every matching rule, returning how long
+// the client should wait if one was empty. A store that fails lets the
+// request through; that is logged once per outage.
+func (rl *rateLimiter) allow(r *http.Request) (time.Duration, bool) {
+	rules := rl.currentRules()
+	for i := range rules {
+		rule := &rules[i]
+		if !rule.matches(r) {
+			continue
+		}
+		for _, client := range rule.clients(r, rl.auth) {
+			key := rl.prefix + strconv.Itoa(i) + ":" + client
+			wait, err := rl.store.take(key, rule.RPS, rule.Burst)
+			if err != nil {
+				if !rl.failing.Swap(true) {
+					log.Printf("[ratelimit] store failed: %v; allowing requests until it recovers", err)
+				}
+				continue
+			}
+			if rl.failing.Swap(false) {
+				log.Printf("[ratelimit] store recovered")
+			}
+			if wait > 0 {
+				return wait, false
+			}
+		}
+	}
+	return 0, true
+}
+
+// rateLimitHandler answers 429 to clients over a limit. Management
+// endpoints are never limited.
+func rateLimitHandler(rl *rateLimiter, metrics *Metrics, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isManagementPath(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if wait, ok := rl.allow(r); !ok {
+			metrics.RecordRateLimited()
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+	full   time.Time // when it will have refilled
+}
+
+// memoryRateStore keeps the buckets in this process.
+type memoryRateStore struct {
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+	sweepAt int // len(buckets) that triggers dropping full buckets
+}
+
+func (m *memoryRateStore) take(key string, rps float64, burst int) (time.Duration, error) {
+	return m.takeAt(time.Now(), key, rps, burst), nil
+}
+
+func (m *memoryRateStore) takeAt(now time.Time, key string, rps float64, burst int) time.Duration {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	b := m.buckets[key]
+	if b == nil {
+		m.sweep(now)
+		b = &tokenBucket{tokens: float64(burst), last: now}
+		m.buckets[key] = b
+	}
+	if now.After(b.last) {
+		b.tokens = min(float64(burst), b.tokens+now.Sub(b.last).Seconds()*rps)
+		b.last = now
+	}
+	wait := time.Duration(0)
+	if b.tokens >= 1 {
+		b.tokens--
+	} else {
+		wait = time.Duration((1 - b.tokens) / rps * float64(time.Second))
+	}
+	b.full = now.Add(time.Duration((float64(burst) - b.tokens) / rps * float64(time.Second)))
+	return wait
+}
+
+// sweep drops buckets that have refilled, which are no different from new
+// ones, once the map has doubled since the last sweep.
+func (m *memoryRateStore) sweep(now time.Time) {
+	if len(m.buckets) < max(m.sweepAt, 1024) {
+		return
+	}
+	for k, b := range m.buckets {
+		if !now.Before(b.full) {
+			delete(m.buckets, k)
+		}
+	}
+	m.sweepAt = 2 * len(m.buckets)
+}
+
+// rateScript is the token bucket run inside Redis, on Redis's clock so
+// every server agrees on the time. It returns 0 if a token was taken,
+// otherwise the milliseconds until there is one.
+const rateScript = `
+local rps, burst = tonumber(ARGV[1]), tonumber(ARGV[2])
+local t = redis.call('TIME')
+local now = tonumber(t[1]) + tonumber(t[2]) / 1e6
+local b = redis.call('HMGET', KEYS[1], 'tokens', 'last')
+local tokens, last = tonumber(b[1]), tonumber(b[2])
+if not tokens then tokens, last = burst, now end
+tokens = math.min(burst, tokens + math.max(0, now - last) * rps)
+local wait = 0
+if tokens >= 1 then tokens = tokens - 1 else wait = math.ceil((1 - tokens) / rps * 1000) end
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'last', tostring(now))
+redis.call('PEXPIRE', KEYS[1], math.ceil(burst / rps * 1000) + 1000)
+return wait
+`
+
+var rateScriptSHA = func() string {
+	sum := sha1.Sum([]byte(rateScript))
+	return hex.EncodeToString(sum[:])
+}()
+
+// redisRateStore keeps the buckets in Redis, shared between servers.
+type redisRateStore struct {
+	redis *redisClient
+}
+
+func (s *redisRateStore) take(key string, rps float64, burst int) (time.Duration, error) {
+	args := []string{key, strconv.FormatFloat(rps, 'g', -1, 64), strconv.Itoa(burst)}
+	reply, err := s.redis.do(append([]string{"EVALSHA", rateScriptSHA, "1"}, args...)...)
+	if err != nil && strings.Contains(err.Error(), "NOSCRIPT") {
+		reply, err = s.redis.do(append([]string{"EVAL", rateScript, "1"}, args...)...)
+	}
+	if err != nil {
+		return 0, err
+	}
+	ms, err := strconv.ParseInt(string(reply), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("redis: bad rate limit reply %q", reply)
+	}
+	return time.Duration(ms) * time.Millisecond, nil
+}
+
+// checkRateLimitRules fills in defaults for rules sent in a config patch,
+// which are refused outright rather than patched over.
+func checkRateLimitRules(rules []RateLimitRule) ([]RateLimitRule, error) {
+	var err error
+	cfg := &AppServerConfig{RateLimits: &RateLimitConfig{Rules: slices.Clone(rules)}}
+	validateRateLimits(cfg, func(_, format string, args ...any) {
+		if err == nil {
+			// drop what the warning says it does instead, such as
+			// ", rule dropped"
+			msg := fmt.Sprintf(format, args...)
+			if i := strings.LastIndex(msg, ", "); i > 0 {
+				msg = msg[:i]
+			}
+			err = errors.New(msg)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return cfg.RateLimits.Rules, nil
+}
+
+// validateRateLimits drops rules that can't limit anything and fills in
+// defaults.
+func validateRateLimits(cfg *AppServerConfig, warn configWarnFunc) {
+	rl := cfg.RateLimits
+	if rl == nil {
+		return
+	}
+	switch rl.Store {
+	case "", "memory", "redis":
+	default:
+		warn("rate_limits.store", "rate_limits.store=%q is not memory or redis, using memory", rl.Store)
+		rl.Store = "memory"
+	}
+	rules := rl.Rules[:0]
+	for i, r := range rl.Rules {
+		path := fmt.Sprintf("rate_limits.rules[%d]", i)
+		if r.RPS <= 0 {
+			warn(path+".rps", "%s.rps=%v must be positive, rule dropped", path, r.RPS)
+			continue
+		}
+		if r.Prefix == "" {
+			r.Prefix = "/"
+		} else if !strings.HasPrefix(r.Prefix, "/") {
+			r.Prefix = "/" + r.Prefix
+		}
+		switch r.Key {
+		case "":
+			r.Key = RateKeyIP
+		case RateKeyIP, RateKeyUser, RateKeyAPIKey:
+		default:
+			warn(path+".key", "%s.key=%q is not ip, user or api_key, using ip", path, r.Key)
+			r.Key = RateKeyIP
+		}
+		if r.Key == RateKeyAPIKey && r.APIKeyHeader == "" {
+			r.APIKeyHeader = "X-API-Key"
+		}
+		if r.Burst < 0 {
+			warn(path+".burst", "%s.burst=%d is invalid, using the rate", path, r.Burst)
+			r.Burst = 0
+		}
+		if r.Burst == 0 {
+			r.Burst = max(1, int(math.Ceil(r.RPS)))
+		}
+		rules = append(rules, r)
+	}
+	rl.Rules = rules
+}
@@ -0,0 +1,242 @@
+package appserver
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go-php/server"
+	"go-php/server/testkit"
+)
+
+func TestMemoryRateStoreRefills(t *testing.T) {
+	m := &memoryRateStore{buckets: make(map[string]*tokenBucket)}
+	now := time.Now()
+	for i := range 2 {
+		if wait := m.takeAt(now, "k", 1, 2); wait != 0 {
+			t.Fatalf("request %d waited %v within the burst", i, wait)
+		}
+	}
+	if wait := m.takeAt(now, "k", 1, 2); wait != time.Second {
+		t.Fatalf("wait = %v past the burst, want 1s", wait)
+	}
+	if wait := m.takeAt(now, "other", 1, 2); wait != 0 {
+		t.Fatalf("another key waited %v", wait)
+	}
+	if wait := m.takeAt(now.Add(500*time.Millisecond), "k", 1, 2); wait != 500*time.Millisecond {
+		t.Fatalf("wait = %v half a token later, want 500ms", wait)
+	}
+	if wait := m.takeAt(now.Add(2*time.Second), "k", 1, 2); wait != 0 {
+		t.Fatalf("wait = %v once refilled", wait)
+	}
+}
+
+func TestMemoryRateStoreSweepsFullBuckets(t *testing.T) {
+	m := &memoryRateStore{buckets: make(map[string]*tokenBucket)}
+	now := time.Now()
+	for i := range 1024 {
+		m.takeAt(now, fmt.Sprint(i), 10, 1)
+	}
+	m.takeAt(now.Add(time.Second), "new", 10, 1)
+	if len(m.buckets) != 1 {
+		t.Fatalf("%d buckets kept, want only the new one", len(m.buckets))
+	}
+}
+
+func TestRateLimitHandler(t *testing.T) {
+	addr := testkit.Listen(t, func(*server.RequestPayload) testkit.Reply { return testkit.Respond(http.StatusOK, "ok") })
+	cfg := &AppServerConfig{
+		Root:  t.TempDir(),
+		Pools: []PoolConfig{{Name: "fast", Workers: 1, Transport: "tcp", Address: addr}},
+		RateLimits: &RateLimitConfig{Rules: []RateLimitRule{
+			{Prefix: "/api", RPS: 0.01, Key: RateKeyAPIKey},
+			{Prefix: "/login", Methods: []string{"POST"}, RPS: 0.01, Burst: 2},
+		}},
+	}
+	validateRateLimits(cfg, logConfig.warn)
+	srv, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	t.Cleanup(func() { _ = srv.Shutdown(t.Context()) })
+
+	remote := "192.0.2.1:5000"
+	do := func(method, path, key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.RemoteAddr = remote
+		if key != "" {
+			req.Header.Set("X-API-Key", key)
+		}
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := do("GET", "/api/users", "a"); rec.Code != http.StatusOK {
+		t.Fatalf("first request = %d", rec.Code)
+	}
+	rec := do("GET", "/api/users", "a")
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "100" {
+		t.Fatalf("second request = %d, Retry-After %q; want 429 after 100s", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	if rec := do("GET", "/api/users", "made-up"); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("made-up API key from the same IP = %d, want 429", rec.Code)
+	}
+	remote = "192.0.2.2:5000"
+	if rec := do("GET", "/api/users", "b"); rec.Code != http.StatusOK {
+		t.Fatalf("another API key = %d, want its own bucket", rec.Code)
+	}
+	if rec := do("GET", "/api/users", "a"); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("same API key from another IP = %d, want 429", rec.Code)
+	}
+	if rec := do("GET", "/other", "a"); rec.Code != http.StatusOK {
+		t.Fatalf("unlimited path = %d", rec.Code)
+	}
+
+	for i := range 2 {
+		if rec := do("POST", "/login", ""); rec.Code != http.StatusOK {
+			t.Fatalf("login %d = %d within the burst", i, rec.Code)
+		}
+	}
+	if rec := do("GET", "/login", ""); rec.Code != http.StatusOK {
+		t.Fatalf("GET /login = %d, want only POST limited", rec.Code)
+	}
+	if rec := do("POST", "/login", ""); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("third login = %d, want 429", rec.Code)
+	}
+
+	if got := srv.metrics.Snapshot().RateLimited; got != 4 {
+		t.Fatalf("rate_limited = %d, want 4", got)
+	}
+}
+
+func TestRateLimitUserKeyNeedsVerifiedUser(t *testing.T) {
+	rule := &RateLimitRule{Key: RateKeyUser}
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "192.0.2.1:5000"
+	r.AddCookie(&http.Cookie{Name: "bm_user_id", Value: "anyone"})
+	if got := rule.clients(r, nil); len(got) != 1 || got[0] != "ip:192.0.2.1" {
+		t.Fatalf("unsigned cookie: clients = %v, want the IP", got)
+	}
+
+	cookie, err := newCookieVerifier(WSCookieConfig{Secret: "cookie-secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := hmac.New(sha256.New, []byte("cookie-secret"))
+	m.Write([]byte("42"))
+	r = httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(&http.Cookie{Name: "bm_user_id", Value: "42." + base64.RawURLEncoding.EncodeToString(m.Sum(nil))})
+	if got := rule.clients(r, &wsAuth{cookie: cookie}); len(got) != 1 || got[0] != "user:"+hashKey("42") {
+		t.Fatalf("signed cookie: clients = %v, want the user", got)
+	}
+}
+
+func TestRedisRateStore(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	var mu sync.Mutex
+	taken := map[string]int{}
+	scripts := map[string]bool{}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go fakeRedisScripts(conn, func(args []string) string {
+				mu.Lock()
+				defer mu.Unlock()
+				switch strings.ToUpper(args[0]) {
+				case "EVALSHA":
+					if !scripts[args[1]] {
+						return "-NOSCRIPT No matching script.\r\n"
+					}
+				case "EVAL":
+					scripts[rateScriptSHA] = args[1] == rateScript
+				default:
+					return "-ERR unknown command\r\n"
+				}
+				key := args[3]
+				burst, _ := strconv.Atoi(args[5])
+				if taken[key]++; taken[key] > burst {
+					return ":250\r\n"
+				}
+				return ":0\r\n"
+			})
+		}
+	}()
+
+	rl := newRateLimiter(&RateLimitConfig{Store: "redis", RedisAddr: ln.Addr().String()}, nil)
+	for i, want := range []time.Duration{0, 0, 250 * time.Millisecond} {
+		wait, err := rl.store.take("go-php:ratelimit:0:ip:192.0.2.1", 1, 2)
+		if err != nil || wait != want {
+			t.Fatalf("take %d = %v, %v; want %v", i, wait, err, want)
+		}
+	}
+	if !scripts[rateScriptSHA] {
+		t.Fatal("script was not loaded with EVAL after NOSCRIPT")
+	}
+}
+
+// fakeRedisScripts answers each command on conn with reply(args).
+func fakeRedisScripts(conn net.Conn, reply func(args []string) string) {
+	defer conn.Close()
+	br := bufio.NewReader(conn)
+	for {
+		var n int
+		if _, err := fmt.Fscanf(br, "*%d\r\n", &n); err != nil {
+			return
+		}
+		args := make([]string, n)
+		for i := range args {
+			var size int
+			if _, err := fmt.Fscanf(br, "$%d\r\n", &size); err != nil {
+				return
+			}
+			buf := make([]byte, size+2)
+			if _, err := io.ReadFull(br, buf); err != nil {
+				return
+			}
+			args[i] = string(buf[:size])
+		}
+		fmt.Fprint(conn, reply(args))
+	}
+}
+
+func TestValidateRateLimits(t *testing.T) {
+	cfg := &AppServerConfig{RateLimits: &RateLimitConfig{
+		Store: "memcached",
+		Rules: []RateLimitRule{
+			{Prefix: "api", RPS: 2.5, Key: RateKeyAPIKey},
+			{RPS: 0},
+			{RPS: 5, Key: "cookie", Burst: -1},
+		},
+	}}
+	validateRateLimits(cfg, logConfig.warn)
+	rl := cfg.RateLimits
+	if rl.Store != "memory" || len(rl.Rules) != 2 {
+		t.Fatalf("rate_limits = %+v", rl)
+	}
+	if r := rl.Rules[0]; r.Prefix != "/api" || r.Burst != 3 || r.APIKeyHeader != "X-API-Key" {
+		t.Fatalf("rule 0 = %+v", r)
+	}
+	if r := rl.Rules[1]; r.Prefix != "/" || r.Key != RateKeyIP || r.Burst != 5 {
+		t.Fatalf("rule 1 = %+v", r)
+	}
+}
@@ -0,0 +1,81 @@
+package appserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"go-php/server"
+
+	"github.com/gorilla/websocket"
+)
+
+// defaultRevokeTTL is how long a revoked user stays blocked when the
+// request doesn't say.
+const defaultRevokeTTL = 15 * time.Minute
+
+// revokeHandler manages realtime revocations:
+//
+//	POST   {"user_id": "42", "ttl_seconds": 600}  close user:42 feeds and block re-subscribe
+//	DELETE ?user_id=42                             lift the block early
+//	GET                                            list active revocations
+func revokeHandler(revocations *server.RevocationList, wsHub *server.WSHub, sseHub *server.SSEHub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(revocations.Revoked())
+
+		case http.MethodDelete:
+			userID := r.URL.Query().Get("user_id")
+			if userID == "" {
+				http.Error(w, "missing user_id", http.StatusBadRequest)
+				return
+			}
+			revocations.Restore(userID)
+			w.WriteHeader(http.StatusNoContent)
+
+		case http.MethodPost:
+			var body struct {
+				UserID     string `json:"user_id"`
+				TTLSeconds int    `json:"ttl_seconds"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				http.Error(w, "invalid json", http.StatusBadRequest)
+				return
+			}
+			if body.UserID == "" {
+				http.Error(w, "missing user_id", http.StatusBadRequest)
+				return
+			}
+
+			ttl := defaultRevokeTTL
+			if body.TTLSeconds > 0 {
+				ttl = time.Duration(body.TTLSeconds) * time.Second
+			}
+
+			// Block first so a client can't slip back in between close and block.
+			until := revocations.Revoke(body.UserID, ttl)
+			channel := server.UserChannel(body.UserID)
+
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(map[string]any{
+				"user_id":       body.UserID,
+				"revoked_until": until,
+				"closed_ws":     wsHub.CloseChannel(channel),
+				"closed_sse":    sseHub.CloseChannel(channel),
+			})
+
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// closeRevokedWS tells the client why its socket is going away and closes it.
+// Called once the hub has closed the client's send channel.
+func closeRevokedWS(conn *websocket.Conn) {
+	msg := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "subscription closed")
+	_ = conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+	_ = conn.Close()
+}
@@ -0,0 +1,78 @@
+package appserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go-php/server"
+)
+
+func TestRevokeHandlerClosesFeedsAndBlocks(t *testing.T) {
+	revocations := server.NewRevocationList()
+	wsHub := server.NewWSHub()
+	sseHub := server.NewSSEHub()
+	h := revokeHandler(revocations, wsHub, sseHub)
+
+	wsClient := wsHub.Subscribe("user:42")
+	sseClient := sseHub.Subscribe("user:42")
+
+	body, _ := json.Marshal(map[string]any{"user_id": "42", "ttl_seconds": 60})
+	rr := httptest.NewRecorder()
+	h(rr, httptest.NewRequest(http.MethodPost, "/__baremetal/realtime/revoke", bytes.NewReader(body)))
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rr.Code)
+	}
+	var out struct {
+		ClosedWS  int `json:"closed_ws"`
+		ClosedSSE int `json:"closed_sse"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&out); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if out.ClosedWS != 1 || out.ClosedSSE != 1 {
+		t.Fatalf("unexpected close counts: %+v", out)
+	}
+
+	if _, ok := <-wsClient.Send; ok {
+		t.Fatalf("expected ws client to be closed")
+	}
+	<-sseClient.Done()
+
+	if !revocations.IsRevoked("42") {
+		t.Fatalf("expected user to stay revoked")
+	}
+
+	// Lift it again.
+	rr = httptest.NewRecorder()
+	h(rr, httptest.NewRequest(http.MethodDelete, "/__baremetal/realtime/revoke?user_id=42", nil))
+	if rr.Code != http.StatusNoContent || revocations.IsRevoked("42") {
+		t.Fatalf("expected DELETE to restore access, got %d", rr.Code)
+	}
+}
+
+func TestRevokeHandlerValidation(t *testing.T) {
+	h := revokeHandler(server.NewRevocationList(), server.NewWSHub(), server.NewSSEHub())
+
+	cases := []struct {
+		method string
+		body   string
+		want   int
+	}{
+		{http.MethodPost, "not json", http.StatusBadRequest},
+		{http.MethodPost, `{"ttl_seconds": 5}`, http.StatusBadRequest},
+		{http.MethodDelete, "", http.StatusBadRequest},
+		{http.MethodPut, "", http.StatusMethodNotAllowed},
+		{http.MethodGet, "", http.StatusOK},
+	}
+	for _, c := range cases {
+		rr := httptest.NewRecorder()
+		h(rr, httptest.NewRequest(c.method, "/__baremetal/realtime/revoke", bytes.NewBufferString(c.body)))
+		if rr.Code != c.want {
+			t.Fatalf("%s %q → %d, want %d", c.method, c.body, rr.Code, c.want)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package appserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"go-php/server"
+)
+
+// defaultRecentRequests is how many requests /__baremetal/requests/recent
+// keeps when recent_requests isn't set.
+const defaultRecentRequests = 100
+
+// RecentRequest is one PHP request as kept for
+// /__baremetal/requests/recent.
+type RecentRequest struct {
+	Time       time.Time `json:"time"`
+	ID         string    `json:"id"`
+	App        string    `json:"app,omitempty"`
+	Method     string    `json:"method"`
+	Path       string    `json:"path"`
+	Status     int       `json:"status"` // 0 for streamed responses
+	DurationMs float64   `json:"duration_ms"`
+	QueueMs    float64   `json:"queue_ms"`
+	WorkerMs   float64   `json:"worker_ms"`
+	Pool       string    `json:"pool,omitempty"`
+	Worker     uint64    `json:"worker,omitempty"` // as on the scoreboard
+	Stream     bool      `json:"stream,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// recentRequests keeps the last requests PHP answered or failed, for
+// looking at during an incident. It gets them as Hooks.
+type recentRequests struct {
+	NopHooks
+
+	mu   sync.Mutex
+	buf  []RecentRequest
+	next int
+	full bool
+}
+
+// newRecentRequests returns nil when n is negative, turning the capture off.
+func newRecentRequests(n int) *recentRequests {
+	if n < 0 {
+		return nil
+	}
+	if n == 0 {
+		n = defaultRecentRequests
+	}
+	return &recentRequests{buf: make([]RecentRequest, n)}
+}
+
+func (rr *recentRequests) OnResponse(ev ResponseEvent) {
+	rr.add(ev.App, ev.Payload, ev.Status, ev.Duration, ev.Stream, nil)
+}
+
+func (rr *recentRequests) OnWorkerError(ev WorkerErrorEvent) {
+	rr.add(ev.App, ev.Payload, ev.Status, ev.Duration, false, ev.Err)
+}
+
+func (rr *recentRequests) add(app string, payload *server.RequestPayload, status int, d time.Duration, stream bool, err error) {
+	queue, worker := payload.Timing()
+	e := RecentRequest{
+		Time:       time.Now(),
+		ID:         payload.ID,
+		App:        app,
+		Method:     payload.Method,
+		Path:       payload.Path,
+		Status:     status,
+		DurationMs: durationMs(d),
+		QueueMs:    durationMs(queue),
+		WorkerMs:   durationMs(worker),
+		Pool:       payload.Pool(),
+		Worker:     payload.Worker(),
+		Stream:     stream,
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+
+	rr.mu.Lock()
+	rr.buf[rr.next] = e
+	rr.next = (rr.next + 1) % len(rr.buf)
+	rr.full = rr.full || rr.next == 0
+	rr.mu.Unlock()
+}
+
+// list returns the kept requests, newest first.
+func (rr *recentRequests) list() []RecentRequest {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+	n := rr.next
+	if rr.full {
+		n = len(rr.buf)
+	}
+	out := make([]RecentRequest, 0, n)
+	for i := range n {
+		out = append(out, rr.buf[(rr.next-1-i+len(rr.buf))%len(rr.buf)])
+	}
+	return out
+}
+
+// handler serves the kept requests, newest first. ?errors=1 keeps only
+// failed ones (an error or a 5xx status) and ?limit=N the newest N.
+func (rr *recentRequests) handler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	reqs := rr.list()
+	if q.Get("errors") == "1" {
+		failed := reqs[:0]
+		for _, e := range reqs {
+			if e.Error != "" || e.Status >= 500 {
+				failed = append(failed, e)
+			}
+		}
+		reqs = failed
+	}
+	if n, err := strconv.Atoi(q.Get("limit")); err == nil && n >= 0 && n < len(reqs) {
+		reqs = reqs[:n]
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(reqs)
+}
@@ -0,0 +1,73 @@
+package appserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go-php/server"
+)
+
+func TestRecentRequests(t *testing.T) {
+	srv, err := New(&AppServerConfig{
+		Root: t.TempDir(),
+		Pools: []PoolConfig{{Name: "fast", Workers: 1, Transport: "tcp", Address: fakePHP(t, func(req *server.RequestPayload) *server.ResponsePayload {
+			if req.Path == "/broken" {
+				return &server.ResponsePayload{Status: http.StatusInternalServerError, Body: []byte("oops")}
+			}
+			return &server.ResponsePayload{Status: http.StatusOK, Body: []byte("ok")}
+		})}},
+		RecentRequests: 3,
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	t.Cleanup(func() { _ = srv.Shutdown(t.Context()) })
+
+	for _, p := range []string{"/a", "/b", "/broken", "/c"} {
+		srv.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", p, nil))
+	}
+
+	recent := func(query string) []RecentRequest {
+		t.Helper()
+		req := httptest.NewRequest("GET", "/__baremetal/requests/recent"+query, nil)
+		req.RemoteAddr = "127.0.0.1:1234"
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("%s: %d %s", query, rr.Code, rr.Body)
+		}
+		var out []RecentRequest
+		if err := json.Unmarshal(rr.Body.Bytes(), &out); err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+
+	got := recent("")
+	var paths []string
+	for _, e := range got {
+		paths = append(paths, e.Path)
+	}
+	if len(got) != 3 || paths[0] != "/c" || paths[1] != "/broken" || paths[2] != "/b" {
+		t.Fatalf("recent paths = %v, want the newest 3 newest first", paths)
+	}
+	if e := got[0]; e.Status != http.StatusOK || e.Pool != "fast" || e.Worker == 0 || e.ID == "" || e.WorkerMs <= 0 {
+		t.Errorf("entry = %+v", e)
+	}
+
+	if got := recent("?errors=1"); len(got) != 1 || got[0].Path != "/broken" || got[0].Status != http.StatusInternalServerError {
+		t.Errorf("errors only = %+v", got)
+	}
+	if got := recent("?limit=1"); len(got) != 1 || got[0].Path != "/c" {
+		t.Errorf("limit=1 = %+v", got)
+	}
+
+	// remote clients need the admin token
+	rr := httptest.NewRecorder()
+	srv.ServeHTTP(rr, httptest.NewRequest("GET", "/__baremetal/requests/recent", nil))
+	if rr.Code == http.StatusOK {
+		t.Errorf("remote request without a token got %d", rr.Code)
+	}
+}
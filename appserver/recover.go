@@ -0,0 +1,57 @@
+package appserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"runtime/debug"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// PanicLog is the structured record of a handler panic.
+type PanicLog struct {
+	Time       time.Time `json:"time"`
+	ID         string    `json:"id"`
+	Method     string    `json:"method"`
+	Path       string    `json:"path"`
+	RemoteAddr string    `json:"remote_addr,omitempty"`
+	Panic      string    `json:"panic"`
+	Stack      string    `json:"stack"`
+}
+
+// recoverPanic, deferred around a handler, turns a panic into a 500, logs
+// it with its stack and counts it. http.ErrAbortHandler is passed on, as
+// it is how a handler asks net/http to drop the connection.
+func (s *Server) recoverPanic(w http.ResponseWriter, r *http.Request) {
+	v := recover()
+	if v == nil {
+		return
+	}
+	if v == http.ErrAbortHandler {
+		panic(v)
+	}
+
+	id := r.Header.Get("X-Request-ID")
+	if id == "" {
+		id = uuid.New().String()
+	}
+	s.metrics.RecordPanic()
+	b, _ := json.Marshal(PanicLog{
+		Time:       time.Now(),
+		ID:         id,
+		Method:     r.Method,
+		Path:       r.URL.Path,
+		RemoteAddr: r.RemoteAddr,
+		Panic:      fmt.Sprint(v),
+		Stack:      string(debug.Stack()),
+	})
+	log.Printf("[panic] %s", b)
+
+	// If the handler already started its response this only logs a
+	// superfluous WriteHeader; the client gets a truncated body.
+	w.Header().Set("X-Request-ID", id)
+	http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+}
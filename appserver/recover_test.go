@@ -0,0 +1,76 @@
+package appserver
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go-php/server"
+)
+
+func TestPanicRecovery(t *testing.T) {
+	srv, err := New(&AppServerConfig{
+		Root: t.TempDir(),
+		Pools: []PoolConfig{{Name: "fast", Workers: 1, Transport: "tcp", Address: fakePHP(t, func(req *server.RequestPayload) *server.ResponsePayload {
+			return &server.ResponsePayload{Status: http.StatusOK, Body: []byte("ok")}
+		})}},
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	t.Cleanup(func() { _ = srv.Shutdown(t.Context()) })
+	srv.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/boom" {
+				panic("boom")
+			}
+			next.ServeHTTP(w, r)
+		})
+	})
+
+	var logs bytes.Buffer
+	prev := log.Writer()
+	log.SetOutput(&logs)
+	t.Cleanup(func() { log.SetOutput(prev) })
+
+	req := httptest.NewRequest("GET", "/boom", nil)
+	req.Header.Set("X-Request-ID", "req-42")
+	rr := httptest.NewRecorder()
+	srv.ServeHTTP(rr, req)
+	log.SetOutput(prev)
+
+	if rr.Code != http.StatusInternalServerError || rr.Header().Get("X-Request-ID") != "req-42" {
+		t.Errorf("got %d, X-Request-ID %q", rr.Code, rr.Header().Get("X-Request-ID"))
+	}
+	out := logs.String()
+	for _, want := range []string{"[panic]", `"id":"req-42"`, `"panic":"boom"`, `"path":"/boom"`, "recover_test.go"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("log lacks %s:\n%s", want, out)
+		}
+	}
+	if got := srv.metrics.Snapshot().Panics; got != 1 {
+		t.Errorf("panics = %d, want 1", got)
+	}
+
+	// the server keeps serving
+	rr = httptest.NewRecorder()
+	srv.ServeHTTP(rr, httptest.NewRequest("GET", "/fine", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("after a panic: got %d", rr.Code)
+	}
+}
+
+func TestPanicRecoveryPassesAbortHandler(t *testing.T) {
+	srv := &Server{metrics: NewMetrics(), handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	})}
+	defer func() {
+		if v := recover(); v != http.ErrAbortHandler {
+			t.Errorf("recovered %v, want http.ErrAbortHandler", v)
+		}
+	}()
+	srv.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+}
@@ -0,0 +1,200 @@
+package appserver
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// RedirectConfig answers redirects at the edge, so PHP doesn't run just to
+// send a 301.
+type RedirectConfig struct {
+	// HTTPS redirects plain-HTTP requests to https://. Behind a load
+	// balancer that terminates TLS, set TrustForwardedProto so its
+	// X-Forwarded-Proto: https marks a request as secure already.
+	HTTPS               bool `json:"https"`
+	TrustForwardedProto bool `json:"trust_forwarded_proto"`
+	HTTPSPort           int  `json:"https_port"` // default 443
+	// HTTPListen, with tls enabled, also listens for plain HTTP there
+	// (e.g. ":80") and redirects everything to HTTPS.
+	HTTPListen string `json:"http_listen"`
+
+	// CanonicalHost redirects its www/apex twin to it: "example.com"
+	// sends www.example.com there, "www.example.com" the reverse.
+	CanonicalHost string `json:"canonical_host"`
+
+	// Status is used for the HTTPS and host redirects (default 301).
+	Status int `json:"status"`
+
+	Rules []RedirectRule `json:"rules"`
+}
+
+// RedirectRule redirects From to To. From is an exact path, or a prefix
+// ending in "*" whose remainder replaces a "*" at the end of To. To may be
+// a path or an absolute URL. The query string is kept.
+type RedirectRule struct {
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Status int    `json:"status"` // default 301
+}
+
+type redirector struct {
+	cfg   *RedirectConfig
+	twin  string // the host redirected to cfg.CanonicalHost
+	rules []RedirectRule
+}
+
+func newRedirector(cfg *RedirectConfig) *redirector {
+	rd := &redirector{cfg: cfg, rules: cfg.Rules}
+	if h := cfg.CanonicalHost; h != "" {
+		if apex, ok := strings.CutPrefix(h, "www."); ok {
+			rd.twin = apex
+		} else {
+			rd.twin = "www." + h
+		}
+	}
+	// Exact paths and longer prefixes first.
+	sort.SliceStable(rd.rules, func(i, j int) bool {
+		wi, wj := strings.HasSuffix(rd.rules[i].From, "*"), strings.HasSuffix(rd.rules[j].From, "*")
+		if wi != wj {
+			return !wi
+		}
+		return len(rd.rules[i].From) > len(rd.rules[j].From)
+	})
+	return rd
+}
+
+func (rd *redirector) secure(r *http.Request) bool {
+	return r.TLS != nil || rd.cfg.TrustForwardedProto && strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https")
+}
+
+// target returns where r should be redirected and with which status, or
+// "" if it shouldn't be.
+func (rd *redirector) target(r *http.Request) (string, int) {
+	for _, rule := range rd.rules {
+		var to string
+		if prefix, ok := strings.CutSuffix(rule.From, "*"); ok {
+			rest, ok := strings.CutPrefix(r.URL.Path, prefix)
+			if !ok {
+				continue
+			}
+			to = rule.To
+			if base, ok := strings.CutSuffix(to, "*"); ok {
+				// Collapse leading slashes so "/blog//evil.com" can't
+				// become the protocol-relative "//evil.com".
+				trimmed := strings.TrimLeft(rest, `/\`)
+				if trimmed != rest && !strings.HasSuffix(base, "/") {
+					trimmed = "/" + trimmed
+				}
+				to = base + trimmed
+			}
+		} else if r.URL.Path == rule.From {
+			to = rule.To
+		} else {
+			continue
+		}
+		if !strings.Contains(rule.To, "://") && (strings.HasPrefix(to, "//") || strings.HasPrefix(to, `/\`)) {
+			// A path rule must not send clients to another host.
+			continue
+		}
+		if r.URL.RawQuery != "" {
+			sep := "?"
+			if strings.Contains(to, "?") {
+				sep = "&"
+			}
+			to += sep + r.URL.RawQuery
+		}
+		return to, rule.Status
+	}
+
+	host := requestHost(r)
+	secure := rd.secure(r)
+	toHost := host
+	if rd.twin != "" && host == rd.twin {
+		toHost = rd.cfg.CanonicalHost
+	}
+	if toHost == host && (secure || !rd.cfg.HTTPS) {
+		return "", 0
+	}
+
+	scheme := "http"
+	if secure || rd.cfg.HTTPS {
+		scheme = "https"
+	}
+	if scheme == "https" && rd.cfg.HTTPSPort != 443 {
+		toHost = net.JoinHostPort(toHost, strconv.Itoa(rd.cfg.HTTPSPort))
+	} else if _, port, err := net.SplitHostPort(r.Host); err == nil && scheme == "http" {
+		toHost = net.JoinHostPort(toHost, port)
+	}
+	return scheme + "://" + toHost + r.URL.RequestURI(), rd.cfg.Status
+}
+
+// redirectHandler answers requests that a redirect rule matches.
+func redirectHandler(rd *redirector, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isManagementPath(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if to, status := rd.target(r); to != "" {
+			http.Redirect(w, r, to, status)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func validRedirectStatus(code int) bool {
+	switch code {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+		http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return true
+	}
+	return false
+}
+
+// validateRedirects fills in defaults and drops rules that can't work.
+func validateRedirects(cfg *AppServerConfig, warn configWarnFunc) {
+	rc := cfg.Redirects
+	if rc == nil {
+		return
+	}
+	if rc.Status == 0 {
+		rc.Status = http.StatusMovedPermanently
+	} else if !validRedirectStatus(rc.Status) {
+		warn("redirects.status", "redirects.status=%d is not a redirect status, using 301", rc.Status)
+		rc.Status = http.StatusMovedPermanently
+	}
+	if rc.HTTPSPort == 0 {
+		rc.HTTPSPort = 443
+	}
+	rc.CanonicalHost = strings.ToLower(strings.TrimSpace(rc.CanonicalHost))
+	if rc.HTTPListen != "" && !cfg.TLS.enabled() {
+		warn("redirects.http_listen", "redirects.http_listen needs tls; ignoring it")
+		rc.HTTPListen = ""
+	}
+
+	rules := rc.Rules[:0]
+	for i, rule := range rc.Rules {
+		path := fmt.Sprintf("redirects.rules[%d]", i)
+		if !strings.HasPrefix(rule.From, "/") || rule.To == "" {
+			warn(path, "%s needs a from path starting with '/' and a to; ignoring it", path)
+			continue
+		}
+		if rule.Status == 0 {
+			rule.Status = http.StatusMovedPermanently
+		} else if !validRedirectStatus(rule.Status) {
+			warn(path+".status", "%s.status=%d is not a redirect status, using 301", path, rule.Status)
+			rule.Status = http.StatusMovedPermanently
+		}
+		if strings.TrimSuffix(rule.From, "*") == strings.TrimSuffix(rule.To, "*") {
+			warn(path, "%s redirects %s to itself; ignoring it", path, rule.From)
+			continue
+		}
+		rules = append(rules, rule)
+	}
+	rc.Rules = rules
+}
@@ -0,0 +1,80 @@
+package appserver
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go-php/server"
+)
+
+func TestRedirects(t *testing.T) {
+	srv, err := New(&AppServerConfig{
+		Root: t.TempDir(),
+		Pools: []PoolConfig{{Name: "fast", Workers: 1, Transport: "tcp", Address: fakePHP(t, func(req *server.RequestPayload) *server.ResponsePayload {
+			return &server.ResponsePayload{Status: http.StatusOK, Body: []byte("ok")}
+		})}},
+		Redirects: &RedirectConfig{
+			HTTPS:               true,
+			TrustForwardedProto: true,
+			CanonicalHost:       "Example.com",
+			Rules: []RedirectRule{
+				{From: "/blog/*", To: "/news/*"},
+				{From: "/blog/archive", To: "https://archive.example.org/", Status: http.StatusFound},
+				{From: "/loop", To: "/loop"},
+				{From: "/go/*", To: "/*"},
+				{From: "/old*", To: "/new*"},
+				{From: "/cdn", To: "//cdn.example.com/"},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	t.Cleanup(func() { _ = srv.Shutdown(t.Context()) })
+
+	for _, tc := range []struct {
+		host, target string
+		tls, fwd     bool
+		status       int
+		location     string
+	}{
+		{"example.com", "/a?b=1", false, false, 301, "https://example.com/a?b=1"},
+		{"www.example.com:8080", "/a", true, false, 301, "https://example.com/a"},
+		{"www.example.com", "/a", false, true, 301, "https://example.com/a"},
+		{"example.com", "/a", true, false, 200, ""},
+		{"example.com", "/a", false, true, 200, ""},
+		{"other.test", "/a", true, false, 200, ""},
+		{"example.com", "/blog/2024/post?x=1", true, false, 301, "/news/2024/post?x=1"},
+		{"example.com", "/blog/archive", true, false, 302, "https://archive.example.org/"},
+		{"example.com", "/loop", true, false, 200, ""},
+		{"example.com", "/go//evil.com", true, false, 301, "/evil.com"},
+		{"example.com", "/go/%2Fevil.com", true, false, 301, "/evil.com"},
+		{"example.com", "/go/%5Cevil.com", true, false, 301, "/evil.com"},
+		{"example.com", "/old//x", true, false, 301, "/new/x"},
+		{"example.com", "/cdn", true, false, 200, ""},
+		{"example.com", "/__baremetal/health", false, false, 0, ""},
+	} {
+		req := httptest.NewRequest("GET", tc.target, nil)
+		req.Host = tc.host
+		if tc.tls {
+			req.TLS = &tls.ConnectionState{}
+		}
+		if tc.fwd {
+			req.Header.Set("X-Forwarded-Proto", "https")
+		}
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+		if tc.status == 0 {
+			if rr.Code >= 300 && rr.Code < 400 {
+				t.Errorf("%s%s: management path redirected", tc.host, tc.target)
+			}
+			continue
+		}
+		if rr.Code != tc.status || rr.Header().Get("Location") != tc.location {
+			t.Errorf("%s%s (tls=%v fwd=%v): got %d %q, want %d %q", tc.host, tc.target, tc.tls, tc.fwd,
+				rr.Code, rr.Header().Get("Location"), tc.status, tc.location)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package appserver
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// redisIdle is how many Redis connections are kept open between commands.
+const redisIdle = 4
+
+// redisClient runs single commands against one Redis server, for the
+// features that keep state there.
+type redisClient struct {
+	addr     string
+	password string
+	db       int
+	idle     chan net.Conn
+}
+
+func newRedisClient(addr, password string, db int) *redisClient {
+	return &redisClient{addr: addr, password: password, db: db, idle: make(chan net.Conn, redisIdle)}
+}
+
+// do runs one command, reusing an idle connection when there is one.
+func (c *redisClient) do(args ...string) ([]byte, error) {
+	var conn net.Conn
+	select {
+	case conn = <-c.idle:
+	default:
+		var err error
+		if conn, err = c.dial(); err != nil {
+			return nil, err
+		}
+	}
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	br := bufio.NewReader(conn)
+	data, err := redisCommand(conn, br, args...)
+	if err != nil || br.Buffered() > 0 {
+		conn.Close()
+		return nil, err
+	}
+	select {
+	case c.idle <- conn:
+	default:
+		conn.Close()
+	}
+	return data, nil
+}
+
+func (c *redisClient) dial() (net.Conn, error) {
+	conn, err := net.DialTimeout("tcp", c.addr, 2*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	br := bufio.NewReader(conn)
+	if c.password != "" {
+		if _, err := redisCommand(conn, br, "AUTH", c.password); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if c.db != 0 {
+		if _, err := redisCommand(conn, br, "SELECT", strconv.Itoa(c.db)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// redisCommand sends args as a RESP array and reads one simple string,
+// integer, bulk string or error reply. An integer comes back in decimal; a
+// nil bulk string returns nil, nil.
+func redisCommand(w io.Writer, br *bufio.Reader, args ...string) ([]byte, error) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(a), a)
+	}
+	if _, err := io.WriteString(w, b.String()); err != nil {
+		return nil, err
+	}
+
+	line, err := br.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("redis: empty reply")
+	}
+	switch line[0] {
+	case '+', ':':
+		return []byte(line[1:]), nil
+	case '-':
+		return nil, fmt.Errorf("redis: %s", line[1:])
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("redis: bad reply %q", line)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		data := make([]byte, n+2)
+		if _, err := io.ReadFull(br, data); err != nil {
+			return nil, err
+		}
+		return data[:n], nil
+	}
+	return nil, fmt.Errorf("redis: unexpected reply %q", line)
+}
@@ -0,0 +1,45 @@
+package appserver
+
+import (
+	"log"
+	"net/http"
+
+	"go-php/server"
+)
+
+// RelayConfig lets workers hand over large buffered responses in chunks,
+// which are written to the client as they arrive instead of once the whole
+// body is in memory. Bodies over ThresholdBytes are relayed (0 turns it
+// off). The worker stays busy until the client has taken the body.
+type RelayConfig struct {
+	ThresholdBytes int `json:"threshold_bytes"`
+}
+
+// relayOver is what to set as RelayOver on a request to app: nothing when
+// the response has to be whole, because ESI rewrites it.
+func (c RelayConfig) relayOver(app *vhost) int {
+	if app.esi != nil {
+		return 0
+	}
+	return c.ThresholdBytes
+}
+
+// writeRelayedBody writes the head of a relayed response, then its body as
+// the worker sends it, and returns the bytes written.
+func writeRelayedBody(w http.ResponseWriter, status int, resp *server.ResponsePayload) int64 {
+	// the length isn't known yet: chunked encoding
+	w.Header().Del("Content-Length")
+	w.WriteHeader(status)
+	n, err := resp.WriteBodyTo(w)
+	if err != nil {
+		log.Printf("[req %s] relay: %v after %d bytes", resp.ID, err, n)
+	}
+	return n
+}
+
+func validateRelay(cfg *AppServerConfig, warn configWarnFunc) {
+	if cfg.Relay.ThresholdBytes < 0 {
+		warn("response_relay.threshold_bytes", "response_relay.threshold_bytes=%d is invalid, relaying disabled", cfg.Relay.ThresholdBytes)
+		cfg.Relay.ThresholdBytes = 0
+	}
+}
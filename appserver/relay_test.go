@@ -0,0 +1,45 @@
+package appserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go-php/server"
+	"go-php/server/testkit"
+)
+
+func TestResponseRelay(t *testing.T) {
+	relayOver := make(chan int, 2)
+	addr := testkit.Listen(t, func(req *server.RequestPayload) testkit.Reply {
+		relayOver <- req.RelayOver
+		if req.RelayOver > 0 {
+			return testkit.Relay(http.StatusOK, "<html>", "big", "</html>")
+		}
+		return testkit.Respond(http.StatusOK, "<html>big</html>")
+	})
+	srv, err := New(&AppServerConfig{
+		Root:  t.TempDir(),
+		Pools: []PoolConfig{{Name: "fast", Workers: 1, Transport: "tcp", Address: addr}},
+		Relay: RelayConfig{ThresholdBytes: 4096},
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	t.Cleanup(func() { _ = srv.Shutdown(t.Context()) })
+
+	// twice: the one worker must be free again after the first body
+	for range 2 {
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, httptest.NewRequest("GET", "/report", nil))
+		if rr.Code != http.StatusOK || rr.Body.String() != "<html>big</html>" {
+			t.Fatalf("got %d %q", rr.Code, rr.Body.String())
+		}
+		if got := <-relayOver; got != 4096 {
+			t.Fatalf("relay_over = %d, want 4096", got)
+		}
+		if rr.Header().Get("Content-Length") != "" {
+			t.Fatalf("relayed response has Content-Length %q", rr.Header().Get("Content-Length"))
+		}
+	}
+}
@@ -0,0 +1,409 @@
+package appserver
+
+import (
+	"container/list"
+	"context"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+
+	"go-php/server"
+)
+
+// ResponseCacheConfig keeps PHP responses that a shared cache may store,
+// going by their Cache-Control, and answers GET and HEAD requests from
+// memory while they are fresh. The RFC 5861 extensions keep stale ones
+// useful: within stale-while-revalidate a stale response is served at once
+// while one request refreshes it in the background, and within
+// stale-if-error it is served instead of a worker error or 5xx. Requests
+// are keyed like coalesced ones, adjusted by Key. A Surrogate-Key response
+// header tags the response for purging by tag; clients never see it.
+type ResponseCacheConfig struct {
+	Enabled  bool           `json:"enabled"`
+	Prefixes []string       `json:"prefixes"` // default: every path
+	Key      CacheKeyConfig `json:"key"`
+
+	MaxBodyBytes int   `json:"max_body_bytes"` // larger responses aren't cached (default 1 MiB)
+	MaxBytes     int64 `json:"max_bytes"`      // total budget (default 64 MiB)
+
+	// StaleWhileRevalidate and StaleIfError, in seconds, apply to
+	// responses whose Cache-Control doesn't set them itself.
+	StaleWhileRevalidate int `json:"stale_while_revalidate"`
+	StaleIfError         int `json:"stale_if_error"`
+}
+
+const defaultResponseCacheMaxBytes = 64 << 20
+
+// cacheHeader tells clients how the cache answered: HIT, MISS, STALE
+// (served while revalidating) or STALE-IF-ERROR.
+const cacheHeader = "X-Cache"
+
+// surrogateKeyHeader lists a response's space-separated cache tags.
+const surrogateKeyHeader = "Surrogate-Key"
+
+// ResponseCacheStats is reported under "response_cache" in the metrics.
+type ResponseCacheStats struct {
+	Hits         uint64 `json:"hits"`
+	Misses       uint64 `json:"misses"`
+	Stale        uint64 `json:"stale"`          // served stale while revalidating
+	StaleIfError uint64 `json:"stale_if_error"` // served stale instead of an error
+	Entries      int    `json:"entries"`
+	Bytes        int64  `json:"bytes"`
+}
+
+type cachedResponse struct {
+	key  string
+	host string // lower-cased, without the port
+	uri  string // path and query
+	tags []string
+	resp *server.ResponsePayload
+	size int64
+
+	stored     time.Time
+	fresh      time.Time // until
+	staleOK    time.Time // stale-while-revalidate until
+	staleIfErr time.Time // stale-if-error until
+
+	refreshing bool
+}
+
+type responseCache struct {
+	cfg ResponseCacheConfig
+
+	mu      sync.Mutex
+	entries map[string]*list.Element   // of *cachedResponse
+	lru     *list.List                 // most recently used at the front
+	tags    map[string]map[string]bool // tag -> keys of the entries it's on
+	used    int64
+
+	hits, misses, stale, staleIfErr atomic.Uint64
+}
+
+// newResponseCache returns nil when the cache is disabled.
+func newResponseCache(cfg ResponseCacheConfig) *responseCache {
+	if !cfg.Enabled {
+		return nil
+	}
+	if cfg.MaxBodyBytes <= 0 {
+		cfg.MaxBodyBytes = 1 << 20
+	}
+	if cfg.MaxBytes <= 0 {
+		cfg.MaxBytes = defaultResponseCacheMaxBytes
+	}
+	return &responseCache{
+		cfg:     cfg,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+		tags:    make(map[string]map[string]bool),
+	}
+}
+
+// cachePolicy is what a response's Cache-Control allows a shared cache.
+type cachePolicy struct {
+	ttl, staleWhileRevalidate, staleIfError time.Duration
+}
+
+// policy returns how long resp may be kept, or false if it mustn't be.
+func (c *responseCache) policy(resp *server.ResponsePayload) (cachePolicy, bool) {
+	if resp.Status >= 500 || resp.Status == http.StatusPartialContent || !sharedResponse(resp, c.cfg.MaxBodyBytes, c.cfg.Key.Vary) {
+		return cachePolicy{}, false
+	}
+	cc := ""
+	for k, v := range resp.Headers {
+		if strings.EqualFold(k, "Cache-Control") {
+			cc = strings.ToLower(v)
+		}
+	}
+
+	maxAge, sMaxAge, swr, sie := -1, -1, -1, -1
+	revalidate := false
+	for _, d := range strings.Split(cc, ",") {
+		name, val, _ := strings.Cut(strings.TrimSpace(d), "=")
+		n, err := strconv.Atoi(strings.Trim(val, `"`))
+		if err != nil {
+			n = -1
+		}
+		switch name {
+		case "private", "no-store", "no-cache":
+			return cachePolicy{}, false
+		case "must-revalidate", "proxy-revalidate":
+			revalidate = true
+		case "max-age":
+			maxAge = n
+		case "s-maxage":
+			sMaxAge = n
+		case "stale-while-revalidate":
+			swr = n
+		case "stale-if-error":
+			sie = n
+		}
+	}
+	if sMaxAge >= 0 {
+		maxAge = sMaxAge
+	}
+	if maxAge <= 0 {
+		return cachePolicy{}, false
+	}
+	p := cachePolicy{ttl: time.Duration(maxAge) * time.Second}
+	if revalidate {
+		// Never served stale.
+		return p, true
+	}
+	if swr < 0 {
+		swr = c.cfg.StaleWhileRevalidate
+	}
+	if sie < 0 {
+		sie = c.cfg.StaleIfError
+	}
+	p.staleWhileRevalidate = time.Duration(swr) * time.Second
+	p.staleIfError = time.Duration(sie) * time.Second
+	return p, true
+}
+
+// lookup returns key's entry, moving it to the front, or nil.
+func (c *responseCache) lookup(key string) *cachedResponse {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+	c.lru.MoveToFront(el)
+	return el.Value.(*cachedResponse)
+}
+
+// store keeps resp to r under key, for uri, if its Cache-Control allows,
+// replacing what was there. It takes the Surrogate-Key header out of resp
+// either way.
+func (c *responseCache) store(key, uri string, r *http.Request, resp *server.ResponsePayload, now time.Time) {
+	tags := surrogateKeys(resp)
+	p, ok := c.policy(resp)
+	if !ok {
+		// The page isn't cacheable any more: stop serving the old copy.
+		c.mu.Lock()
+		if el, ok := c.entries[key]; ok {
+			c.remove(el)
+		}
+		c.mu.Unlock()
+		return
+	}
+	e := &cachedResponse{
+		key:    key,
+		host:   requestHost(r),
+		uri:    uri,
+		tags:   tags,
+		resp:   copyResponse(resp, ""),
+		stored: now,
+		fresh:  now.Add(p.ttl),
+	}
+	e.staleOK = e.fresh.Add(p.staleWhileRevalidate)
+	e.staleIfErr = e.fresh.Add(p.staleIfError)
+	e.size = int64(len(resp.Body))
+	for k, v := range resp.Headers {
+		e.size += int64(len(k) + len(v))
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[key]; ok {
+		c.remove(el)
+	}
+	for c.used+e.size > c.cfg.MaxBytes && c.lru.Len() > 0 {
+		c.remove(c.lru.Back())
+	}
+	if e.size > c.cfg.MaxBytes {
+		return
+	}
+	c.entries[key] = c.lru.PushFront(e)
+	c.used += e.size
+	for _, tag := range e.tags {
+		if c.tags[tag] == nil {
+			c.tags[tag] = make(map[string]bool)
+		}
+		c.tags[tag][key] = true
+	}
+}
+
+// remove drops el; c.mu must be held.
+func (c *responseCache) remove(el *list.Element) {
+	e := c.lru.Remove(el).(*cachedResponse)
+	delete(c.entries, e.key)
+	c.used -= e.size
+	for _, tag := range e.tags {
+		delete(c.tags[tag], e.key)
+		if len(c.tags[tag]) == 0 {
+			delete(c.tags, tag)
+		}
+	}
+}
+
+// surrogateKeys removes the Surrogate-Key header from resp and returns its
+// tags.
+func surrogateKeys(resp *server.ResponsePayload) []string {
+	var tags []string
+	for k, v := range resp.Headers {
+		if strings.EqualFold(k, surrogateKeyHeader) {
+			tags = append(tags, strings.Fields(v)...)
+			delete(resp.Headers, k)
+		}
+	}
+	slices.Sort(tags)
+	return slices.Compact(tags)
+}
+
+// serve returns a copy of e's response for req, marked with how it was
+// served.
+func (e *cachedResponse) serve(req *server.RequestPayload, how string, now time.Time) *server.ResponsePayload {
+	resp := copyResponse(e.resp, req.ID)
+	if resp.Headers == nil {
+		resp.Headers = make(map[string]string)
+	}
+	resp.Headers[cacheHeader] = how
+	resp.Headers["Age"] = strconv.Itoa(int(now.Sub(e.stored).Seconds()))
+	return resp
+}
+
+// startRefresh claims e's background refresh, reporting false if one is
+// already running.
+func (c *responseCache) startRefresh(e *cachedResponse) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e.refreshing {
+		return false
+	}
+	e.refreshing = true
+	return true
+}
+
+// refresh dispatches a copy of req on its own and stores the answer in
+// place of e. Until it does, or if it fails, e keeps being served.
+func (c *responseCache) refresh(next DispatchFunc, r *http.Request, req *server.RequestPayload, e *cachedResponse) {
+	defer func() {
+		c.mu.Lock()
+		e.refreshing = false
+		c.mu.Unlock()
+	}()
+	resp, err := next(r, req)
+	switch {
+	case err != nil:
+		log.Printf("[cache] refresh %s %s: %v", req.Method, req.Path, err)
+	case resp.Status >= 500:
+		resp.Discard()
+		log.Printf("[cache] refresh %s %s: status %d", req.Method, req.Path, resp.Status)
+	default:
+		c.store(e.key, e.uri, r, resp, time.Now())
+		resp.Discard()
+	}
+}
+
+// middleware answers from the cache around the hand-off to PHP.
+func (c *responseCache) middleware(next DispatchFunc) DispatchFunc {
+	return func(r *http.Request, req *server.RequestPayload) (*server.ResponsePayload, error) {
+		key, uri := c.key(r, req)
+		if key == "" {
+			return next(r, req)
+		}
+
+		now := time.Now()
+		e := c.lookup(key)
+		switch {
+		case e == nil:
+		case now.Before(e.fresh):
+			c.hits.Add(1)
+			return e.serve(req, "HIT", now), nil
+		case now.Before(e.staleOK):
+			if c.startRefresh(e) {
+				bg := *req
+				bg.ID = uuid.New().String()
+				go c.refresh(next, r.Clone(context.WithoutCancel(r.Context())), &bg, e)
+			}
+			c.stale.Add(1)
+			return e.serve(req, "STALE", now), nil
+		}
+
+		c.misses.Add(1)
+		resp, err := next(r, req)
+		now = time.Now()
+		if e != nil && now.Before(e.staleIfErr) && (err != nil || resp.Status >= 500) {
+			if err == nil {
+				resp.Discard()
+			}
+			log.Printf("[cache] %s %s: serving stale instead of %s", req.Method, req.Path, failureText(resp, err))
+			c.staleIfErr.Add(1)
+			return e.serve(req, "STALE-IF-ERROR", now), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		c.store(key, uri, r, resp, now)
+		if resp.Headers == nil {
+			resp.Headers = make(map[string]string)
+		}
+		resp.Headers[cacheHeader] = "MISS"
+		return resp, nil
+	}
+}
+
+func failureText(resp *server.ResponsePayload, err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	return "status " + strconv.Itoa(resp.Status)
+}
+
+// resetStats zeroes the counters.
+func (c *responseCache) resetStats() {
+	if c != nil {
+		c.hits.Store(0)
+		c.misses.Store(0)
+		c.stale.Store(0)
+		c.staleIfErr.Store(0)
+	}
+}
+
+func (c *responseCache) stats() *ResponseCacheStats {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return &ResponseCacheStats{
+		Hits:         c.hits.Load(),
+		Misses:       c.misses.Load(),
+		Stale:        c.stale.Load(),
+		StaleIfError: c.staleIfErr.Load(),
+		Entries:      c.lru.Len(),
+		Bytes:        c.used,
+	}
+}
+
+// validateResponseCache reports invalid cache settings.
+func validateResponseCache(cfg *AppServerConfig, warn configWarnFunc) {
+	c := &cfg.ResponseCache
+	if c.MaxBodyBytes < 0 {
+		warn("response_cache.max_body_bytes", "response_cache.max_body_bytes=%d is invalid, using 1 MiB", c.MaxBodyBytes)
+		c.MaxBodyBytes = 0
+	}
+	if c.MaxBytes < 0 {
+		warn("response_cache.max_bytes", "response_cache.max_bytes=%d is invalid, using 64 MiB", c.MaxBytes)
+		c.MaxBytes = 0
+	}
+	if c.StaleWhileRevalidate < 0 {
+		warn("response_cache.stale_while_revalidate", "response_cache.stale_while_revalidate=%d is invalid, using 0", c.StaleWhileRevalidate)
+		c.StaleWhileRevalidate = 0
+	}
+	if c.StaleIfError < 0 {
+		warn("response_cache.stale_if_error", "response_cache.stale_if_error=%d is invalid, using 0", c.StaleIfError)
+		c.StaleIfError = 0
+	}
+	if k := &c.Key; k.IgnoreQuery && (len(k.QueryAllow) > 0 || len(k.QueryDeny) > 0) {
+		warn("response_cache.key.ignore_query", "response_cache.key.ignore_query is set, so query_allow and query_deny have no effect")
+	}
+}
@@ -0,0 +1,174 @@
+package appserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go-php/server"
+	"go-php/server/testkit"
+)
+
+// cacheTestServer serves /page through a response cache. Each PHP answer
+// says which version it is; fail makes PHP answer 503 instead.
+func cacheTestServer(t *testing.T, cacheControl string) (srv *Server, calls *atomic.Int32, fail *atomic.Bool) {
+	t.Helper()
+	calls, fail = new(atomic.Int32), new(atomic.Bool)
+	addr := testkit.Listen(t, func(req *server.RequestPayload) testkit.Reply {
+		n := calls.Add(1)
+		if fail.Load() {
+			return testkit.Respond(http.StatusServiceUnavailable, "down")
+		}
+		return testkit.Reply{Response: &server.ResponsePayload{
+			Status:  http.StatusOK,
+			Headers: map[string]string{"Cache-Control": cacheControl},
+			Body:    []byte("v" + string(rune('0'+n))),
+		}}
+	})
+	srv, err := New(&AppServerConfig{
+		Root:          t.TempDir(),
+		Pools:         []PoolConfig{{Name: "fast", Workers: 1, Transport: "tcp", Address: addr}},
+		ResponseCache: ResponseCacheConfig{Enabled: true, StaleIfError: 600},
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	t.Cleanup(func() { _ = srv.Shutdown(t.Context()) })
+	return srv, calls, fail
+}
+
+func getCached(srv *Server, header ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", "/page", nil)
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+	return rec
+}
+
+// ageCache moves every entry's timestamps d into the past.
+func ageCache(c *responseCache, d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, el := range c.entries {
+		e := el.Value.(*cachedResponse)
+		e.stored, e.fresh = e.stored.Add(-d), e.fresh.Add(-d)
+		e.staleOK, e.staleIfErr = e.staleOK.Add(-d), e.staleIfErr.Add(-d)
+	}
+}
+
+func TestResponseCacheHit(t *testing.T) {
+	srv, calls, _ := cacheTestServer(t, "public, max-age=60")
+
+	if rec := getCached(srv); rec.Body.String() != "v1" || rec.Header().Get(cacheHeader) != "MISS" {
+		t.Fatalf("first = %q (%s)", rec.Body, rec.Header().Get(cacheHeader))
+	}
+	rec := getCached(srv)
+	if rec.Body.String() != "v1" || rec.Header().Get(cacheHeader) != "HIT" || rec.Header().Get("Age") != "0" {
+		t.Fatalf("second = %q (%s, age %q)", rec.Body, rec.Header().Get(cacheHeader), rec.Header().Get("Age"))
+	}
+	if rec := getCached(srv, "Authorization", "Bearer x"); rec.Body.String() != "v2" {
+		t.Fatalf("request with credentials = %q, want it sent to PHP", rec.Body)
+	}
+	if n := calls.Load(); n != 2 {
+		t.Fatalf("PHP saw %d requests, want 2", n)
+	}
+
+	ageCache(srv.cache, time.Minute)
+	if rec := getCached(srv); rec.Body.String() != "v3" || rec.Header().Get(cacheHeader) != "MISS" {
+		t.Fatalf("expired = %q (%s), want a new response", rec.Body, rec.Header().Get(cacheHeader))
+	}
+
+	st := srv.cache.stats()
+	if st.Hits != 1 || st.Misses != 2 || st.Entries != 1 {
+		t.Fatalf("stats = %+v", st)
+	}
+}
+
+func TestResponseCacheStaleWhileRevalidate(t *testing.T) {
+	srv, calls, _ := cacheTestServer(t, "public, max-age=60, stale-while-revalidate=30")
+	getCached(srv)
+
+	ageCache(srv.cache, 70*time.Second)
+	rec := getCached(srv)
+	if rec.Body.String() != "v1" || rec.Header().Get(cacheHeader) != "STALE" || rec.Header().Get("Age") != "70" {
+		t.Fatalf("stale = %q (%s, age %q)", rec.Body, rec.Header().Get(cacheHeader), rec.Header().Get("Age"))
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if rec := getCached(srv); rec.Body.String() == "v2" {
+			if rec.Header().Get(cacheHeader) != "HIT" {
+				t.Fatalf("refreshed = %s, want HIT", rec.Header().Get(cacheHeader))
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("background refresh never stored a new response")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := calls.Load(); n != 2 {
+		t.Fatalf("PHP saw %d requests, want one refresh", n)
+	}
+
+	ageCache(srv.cache, 2*time.Minute)
+	if rec := getCached(srv); rec.Body.String() != "v3" || rec.Header().Get(cacheHeader) != "MISS" {
+		t.Fatalf("past stale-while-revalidate = %q (%s)", rec.Body, rec.Header().Get(cacheHeader))
+	}
+}
+
+func TestResponseCacheStaleIfError(t *testing.T) {
+	srv, _, fail := cacheTestServer(t, "public, max-age=60")
+	getCached(srv)
+	fail.Store(true)
+
+	ageCache(srv.cache, 5*time.Minute)
+	rec := getCached(srv)
+	if rec.Code != http.StatusOK || rec.Body.String() != "v1" || rec.Header().Get(cacheHeader) != "STALE-IF-ERROR" {
+		t.Fatalf("during outage = %d %q (%s)", rec.Code, rec.Body, rec.Header().Get(cacheHeader))
+	}
+	if st := srv.cache.stats(); st.StaleIfError != 1 {
+		t.Fatalf("stats = %+v", st)
+	}
+
+	ageCache(srv.cache, 10*time.Minute)
+	if rec := getCached(srv); rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("past stale-if-error = %d, want PHP's 503", rec.Code)
+	}
+}
+
+func TestResponseCachePolicy(t *testing.T) {
+	c := newResponseCache(ResponseCacheConfig{Enabled: true, StaleWhileRevalidate: 5, Key: CacheKeyConfig{Vary: []string{"Accept-Language"}}})
+	for _, tc := range []struct {
+		headers map[string]string
+		cookies []string
+		status  int
+		want    cachePolicy
+		ok      bool
+	}{
+		{headers: map[string]string{"Cache-Control": "public, max-age=60"}, want: cachePolicy{ttl: time.Minute, staleWhileRevalidate: 5 * time.Second}, ok: true},
+		{headers: map[string]string{"cache-control": "max-age=60, s-maxage=10, stale-if-error=30"}, want: cachePolicy{ttl: 10 * time.Second, staleWhileRevalidate: 5 * time.Second, staleIfError: 30 * time.Second}, ok: true},
+		{headers: map[string]string{"Cache-Control": "max-age=60, stale-while-revalidate=0, must-revalidate"}, want: cachePolicy{ttl: time.Minute}, ok: true},
+		{headers: map[string]string{"Cache-Control": "private, max-age=60"}},
+		{headers: map[string]string{"Cache-Control": "no-store"}},
+		{headers: map[string]string{}},
+		{headers: map[string]string{"Cache-Control": "max-age=60"}, cookies: []string{"a=b"}},
+		{headers: map[string]string{"Cache-Control": "max-age=60"}, status: http.StatusBadGateway},
+		{headers: map[string]string{"Cache-Control": "max-age=60", "Vary": "accept-language"}, want: cachePolicy{ttl: time.Minute, staleWhileRevalidate: 5 * time.Second}, ok: true},
+		{headers: map[string]string{"Cache-Control": "max-age=60", "Vary": "Accept-Language, Accept-Encoding"}},
+		{headers: map[string]string{"Cache-Control": "max-age=60", "vary": "*"}},
+	} {
+		status := tc.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		got, ok := c.policy(&server.ResponsePayload{Status: status, Headers: tc.headers, Cookies: tc.cookies})
+		if ok != tc.ok || got != tc.want {
+			t.Errorf("policy(%v, cookies %v, %d) = %+v, %v; want %+v, %v", tc.headers, tc.cookies, status, got, ok, tc.want, tc.ok)
+		}
+	}
+}
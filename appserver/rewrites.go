@@ -0,0 +1,137 @@
+package appserver
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// RewriteRule changes the request path before static matching and
+// dispatch. Either Match, a regexp whose capture groups To can use as
+// $1..$9, or Prefix, which To replaces, is set. Rules are tried in order
+// and the first that matches wins.
+type RewriteRule struct {
+	Match  string `json:"match"`
+	Prefix string `json:"prefix"`
+	// To is the new path. A query string in it comes before the
+	// request's own.
+	To string `json:"to"`
+}
+
+// originalURIHeader carries the request URI from before a rewrite to PHP.
+const originalURIHeader = "X-Original-URI"
+
+type rewriteRule struct {
+	re     *regexp.Regexp
+	prefix string
+	to     string
+}
+
+// rewrite returns the path (and query) p becomes, and whether rule matched.
+func (rule rewriteRule) rewrite(p string) (string, bool) {
+	if rule.re != nil {
+		m := rule.re.FindStringSubmatchIndex(p)
+		if m == nil {
+			return "", false
+		}
+		return string(rule.re.ExpandString(nil, rule.to, p, m)), true
+	}
+	rest, ok := strings.CutPrefix(p, rule.prefix)
+	if !ok || rest != "" && !strings.HasPrefix(rest, "/") && !strings.HasSuffix(rule.prefix, "/") {
+		return "", false
+	}
+	if rest == "" {
+		return rule.to, true
+	}
+	return strings.TrimSuffix(rule.to, "/") + "/" + strings.TrimPrefix(rest, "/"), true
+}
+
+func newRewriteRules(rules []RewriteRule) []rewriteRule {
+	out := make([]rewriteRule, 0, len(rules))
+	for _, r := range rules {
+		rule := rewriteRule{prefix: r.Prefix, to: r.To}
+		if r.Match != "" {
+			// validateRewrites already dropped the ones that don't compile
+			rule.re = regexp.MustCompile(r.Match)
+		}
+		out = append(out, rule)
+	}
+	return out
+}
+
+// rewriteHandler applies the first matching rule to r.URL, keeping the
+// original request URI in X-Original-URI. Management endpoints are left
+// alone, and a client's own X-Original-URI is never passed on.
+func rewriteHandler(rules []rewriteRule, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Header.Del(originalURIHeader)
+		if isManagementPath(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		for _, rule := range rules {
+			to, ok := rule.rewrite(r.URL.Path)
+			if !ok {
+				continue
+			}
+			p, query, hasQuery := strings.Cut(to, "?")
+			p = cleanRewrittenPath(p)
+			if isManagementPath(p) {
+				break
+			}
+			if hasQuery && r.URL.RawQuery != "" {
+				query += "&" + r.URL.RawQuery
+			} else if !hasQuery {
+				query = r.URL.RawQuery
+			}
+
+			original := r.URL.RequestURI()
+			r2 := r.Clone(r.Context())
+			r2.URL.Path, r2.URL.RawPath, r2.URL.RawQuery = p, "", query
+			r2.RequestURI = r2.URL.RequestURI()
+			r2.Header.Set(originalURIHeader, original)
+			next.ServeHTTP(w, r2)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// cleanRewrittenPath resolves dot segments a capture group may have brought
+// in, keeping a trailing slash.
+func cleanRewrittenPath(p string) string {
+	clean := path.Clean("/" + p)
+	if strings.HasSuffix(p, "/") && clean != "/" {
+		clean += "/"
+	}
+	return clean
+}
+
+// validateRewrites drops rules that can't work.
+func validateRewrites(cfg *AppServerConfig, warn configWarnFunc) {
+	rules := cfg.Rewrites[:0]
+	for i, rule := range cfg.Rewrites {
+		path := fmt.Sprintf("rewrites[%d]", i)
+		if (rule.Match == "") == (rule.Prefix == "") {
+			warn(path, "%s needs exactly one of match or prefix; ignoring it", path)
+			continue
+		}
+		if !strings.HasPrefix(rule.To, "/") {
+			warn(path+".to", "%s.to=%q must start with '/'; ignoring the rule", path, rule.To)
+			continue
+		}
+		if rule.Match != "" {
+			if _, err := regexp.Compile(rule.Match); err != nil {
+				warn(path+".match", "%s.match: %v; ignoring the rule", path, err)
+				continue
+			}
+		} else if !strings.HasPrefix(rule.Prefix, "/") {
+			warn(path+".prefix", "%s.prefix=%q must start with '/'; ignoring the rule", path, rule.Prefix)
+			continue
+		}
+		rules = append(rules, rule)
+	}
+	cfg.Rewrites = rules
+}
@@ -0,0 +1,57 @@
+package appserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go-php/server"
+)
+
+func TestRewrites(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "public/assets"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "public/assets/app.css"), []byte("body{}"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	srv, err := New(&AppServerConfig{
+		Root: root,
+		Pools: []PoolConfig{{Name: "fast", Workers: 1, Transport: "tcp", Address: fakePHP(t, func(req *server.RequestPayload) *server.ResponsePayload {
+			return &server.ResponsePayload{Status: http.StatusOK, Body: []byte(req.Path + " " + http.Header(req.Headers).Get("X-Original-URI"))}
+		})}},
+		Static: []StaticRule{{Prefix: "/assets/", Dir: "public/assets"}},
+		Rewrites: []RewriteRule{
+			{Match: `^/v1/(.*)$`, To: "/api/$1?version=1"},
+			{Prefix: "/myapp", To: "/"},
+			{Match: `^/escape/(.*)$`, To: "/__baremetal/$1"},
+			{Prefix: "/bad"}, // dropped: no to
+		},
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	t.Cleanup(func() { _ = srv.Shutdown(t.Context()) })
+
+	for target, want := range map[string]string{
+		"/v1/users?page=2":      "/api/users?version=1&page=2 /v1/users?page=2",
+		"/v1/../../etc/passwd":  "/etc/passwd?version=1 /v1/../../etc/passwd",
+		"/myapp":                "/ /myapp",
+		"/myapp/orders/7":       "/orders/7 /myapp/orders/7",
+		"/myapplication":        "/myapplication ",
+		"/plain":                "/plain ",
+		"/myapp/assets/app.css": "body{}",
+		"/escape/workers":       "/escape/workers ",
+	} {
+		req := httptest.NewRequest("GET", target, nil)
+		req.Header.Set("X-Original-URI", "/spoofed")
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+		if got := rr.Body.String(); got != want {
+			t.Errorf("%s: got %q, want %q", target, got, want)
+		}
+	}
+}
@@ -0,0 +1,212 @@
+package appserver
+
+import (
+	"container/list"
+	"hash/maphash"
+	"sync"
+	"time"
+)
+
+// RouteMetricsConfig bounds the per-path metrics under by_route, which
+// would otherwise grow with every distinct path a client ever asks for.
+// Past MaxRoutes paths a least recently requested one is folded into the
+// OtherRoute bucket, as is any path idle for TTLMs.
+type RouteMetricsConfig struct {
+	MaxRoutes int `json:"max_routes"` // default 1000
+	TTLMs     int `json:"ttl_ms"`     // 0 keeps paths until evicted
+}
+
+const defaultMaxRoutes = 1000
+
+// OtherRoute is the by_route bucket for paths dropped from the metrics.
+const OtherRoute = "other"
+
+// routeShards splits the routes so concurrent requests for different
+// paths rarely wait on the same lock.
+const routeShards = 16
+
+var routeSeed = maphash.MakeSeed()
+
+// routeShard holds the routes that hash to it, least recently used last.
+type routeShard struct {
+	mu     sync.Mutex
+	routes map[string]*list.Element // of *routeEntry, in lru
+	lru    *list.List               // most recently requested first
+	other  RouteMetrics             // what was dropped from this shard
+}
+
+type routeEntry struct {
+	route    string
+	lastSeen time.Time
+	metrics  RouteMetrics
+	window   *rollingWindow // made by the first EndRequest
+}
+
+// limitRoutes applies cfg to routes recorded from now on.
+func (m *Metrics) limitRoutes(cfg RouteMetricsConfig) {
+	m.routeLimits.Store(&cfg)
+}
+
+func (m *Metrics) routeConfig() (maxRoutes int, ttl time.Duration) {
+	maxRoutes = defaultMaxRoutes
+	if cfg := m.routeLimits.Load(); cfg != nil {
+		if cfg.MaxRoutes > 0 {
+			maxRoutes = cfg.MaxRoutes
+		}
+		ttl = time.Duration(cfg.TTLMs) * time.Millisecond
+	}
+	return maxRoutes, ttl
+}
+
+// withRoute runs f on route's entry under its shard's lock, first making
+// room for it if route is new.
+func (m *Metrics) withRoute(route string, f func(*routeEntry)) {
+	maxRoutes, ttl := m.routeConfig()
+	now := time.Now()
+	i := int(maphash.String(routeSeed, route) % routeShards)
+	sh := &m.routes[i]
+
+	sh.mu.Lock()
+	if sh.routes == nil {
+		sh.routes = make(map[string]*list.Element)
+		sh.lru = list.New()
+	}
+	el := sh.routes[route]
+	added := el == nil
+	if added {
+		m.forget(sh.expire(now, ttl))
+		el = sh.lru.PushFront(&routeEntry{route: route})
+		sh.routes[route] = el
+	} else {
+		sh.lru.MoveToFront(el)
+	}
+	e := el.Value.(*routeEntry)
+	e.lastSeen = now
+	f(e)
+	sh.mu.Unlock()
+
+	if added && m.routesTracked.Add(1) > int64(maxRoutes) {
+		m.evict(maxRoutes)
+	}
+}
+
+// evict drops least recently used routes until at most maxRoutes are
+// left. Only one shard is locked at a time, so under concurrent updates it
+// may pick one that is a little newer; that is fine for metrics.
+func (m *Metrics) evict(maxRoutes int) {
+	for m.routesTracked.Load() > int64(maxRoutes) {
+		oldest := m.oldestShard()
+		if oldest == nil {
+			// Every shard is empty: whoever emptied them is still
+			// updating the count.
+			return
+		}
+		oldest.mu.Lock()
+		// A concurrent evict may have emptied it since; then look again.
+		dropped := oldest.lru.Len() > 0
+		if dropped {
+			oldest.drop(oldest.lru.Back())
+		}
+		oldest.mu.Unlock()
+		if dropped {
+			m.forget(1)
+		}
+	}
+}
+
+// oldestShard returns the shard whose least recently used route is the
+// oldest, or nil if every shard is empty.
+func (m *Metrics) oldestShard() *routeShard {
+	var oldest *routeShard
+	var seen time.Time
+	for i := range m.routes {
+		sh := &m.routes[i]
+		sh.mu.Lock()
+		if sh.lru != nil && sh.lru.Len() > 0 {
+			if t := sh.lru.Back().Value.(*routeEntry).lastSeen; oldest == nil || t.Before(seen) {
+				oldest, seen = sh, t
+			}
+		}
+		sh.mu.Unlock()
+	}
+	return oldest
+}
+
+// forget accounts for n routes folded into OtherRoute.
+func (m *Metrics) forget(n int) {
+	if n > 0 {
+		m.routesTracked.Add(int64(-n))
+		m.dropped.Add(int64(n))
+	}
+}
+
+// routeSnapshot copies every shard's routes, with what they dropped summed
+// up under OtherRoute.
+func (m *Metrics) routeSnapshot(now time.Time) map[string]*RouteMetrics {
+	_, ttl := m.routeConfig()
+	out := make(map[string]*RouteMetrics)
+	var other RouteMetrics
+	for i := range m.routes {
+		sh := &m.routes[i]
+		sh.mu.Lock()
+		m.forget(sh.expire(now, ttl))
+		for route, el := range sh.routes {
+			e := el.Value.(*routeEntry)
+			rm := e.metrics
+			if e.window != nil {
+				rm.Windows = e.window.stats(now)
+			}
+			out[route] = &rm
+		}
+		other.add(&sh.other)
+		sh.mu.Unlock()
+	}
+	if m.dropped.Load() > 0 {
+		out[OtherRoute] = &other
+	}
+	return out
+}
+
+// expire drops the routes idle for ttl or longer and returns how many.
+// Callers hold sh.mu.
+func (sh *routeShard) expire(now time.Time, ttl time.Duration) int {
+	if ttl <= 0 || sh.lru == nil {
+		return 0
+	}
+	n := 0
+	for el := sh.lru.Back(); el != nil && now.Sub(el.Value.(*routeEntry).lastSeen) >= ttl; el = sh.lru.Back() {
+		sh.drop(el)
+		n++
+	}
+	return n
+}
+
+// drop folds el's route into the shard's share of OtherRoute. Callers
+// hold sh.mu.
+func (sh *routeShard) drop(el *list.Element) {
+	e := sh.lru.Remove(el).(*routeEntry)
+	delete(sh.routes, e.route)
+	sh.other.add(&e.metrics)
+}
+
+// add merges o's counts into rm.
+func (rm *RouteMetrics) add(o *RouteMetrics) {
+	rm.Count += o.Count
+	rm.TotalLatency += o.TotalLatency
+	rm.BytesIn += o.BytesIn
+	rm.BytesOut += o.BytesOut
+	rm.QueueWait += o.QueueWait
+	rm.WorkerTime += o.WorkerTime
+}
+
+func validateRouteMetrics(cfg *AppServerConfig, warn configWarnFunc) {
+	rm := &cfg.RouteMetrics
+	if rm.MaxRoutes < 0 {
+		warn("route_metrics.max_routes", "route_metrics.max_routes=%d is invalid, using %d", rm.MaxRoutes, defaultMaxRoutes)
+		rm.MaxRoutes = 0
+	}
+	if rm.TTLMs < 0 {
+		warn("route_metrics.ttl_ms", "route_metrics.ttl_ms=%d is invalid, routes won't expire", rm.TTLMs)
+		rm.TTLMs = 0
+	}
+}
@@ -0,0 +1,113 @@
+package appserver
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRouteMetricsBounded(t *testing.T) {
+	m := NewMetrics()
+	m.limitRoutes(RouteMetricsConfig{MaxRoutes: 2})
+	for i, path := range []string{"/a", "/b", "/a", "/c"} {
+		m.StartRequest(path)
+		m.EndRequest(path, time.Duration(i+1)*time.Millisecond, false)
+		m.RecordBytes(path, "", 1, 10)
+	}
+
+	snap := m.Snapshot()
+	if len(snap.ByRoute) != 3 || snap.ByRoute["/a"] == nil || snap.ByRoute["/c"] == nil {
+		t.Fatalf("ByRoute = %v, want /a, /c and %q", snap.ByRoute, OtherRoute)
+	}
+	other := snap.ByRoute[OtherRoute]
+	if other == nil || other.Count != 1 || other.TotalLatency != 2*time.Millisecond || other.BytesOut != 10 {
+		t.Fatalf("%s = %+v, want /b's counts", OtherRoute, other)
+	}
+	if snap.RoutesDropped != 1 {
+		t.Fatalf("RoutesDropped = %d, want 1", snap.RoutesDropped)
+	}
+	if snap.ByRoute["/a"].Count != 2 {
+		t.Fatalf("/a count = %d, want 2", snap.ByRoute["/a"].Count)
+	}
+}
+
+func TestRouteMetricsTTL(t *testing.T) {
+	m := NewMetrics()
+	m.limitRoutes(RouteMetricsConfig{TTLMs: 60_000})
+	for i := 0; i < 3; i++ {
+		path := fmt.Sprintf("/probe/%d", i)
+		m.StartRequest(path)
+		m.EndRequest(path, time.Millisecond, false)
+	}
+	// age the two oldest past the TTL
+	for i := range m.routes {
+		for _, el := range m.routes[i].routes {
+			if e := el.Value.(*routeEntry); e.route != "/probe/2" {
+				e.lastSeen = time.Now().Add(-time.Hour)
+			}
+		}
+	}
+
+	snap := m.Snapshot()
+	if len(snap.ByRoute) != 2 || snap.ByRoute["/probe/2"] == nil || snap.ByRoute[OtherRoute].Count != 2 {
+		t.Fatalf("ByRoute = %v, want /probe/2 and the expired two under %q", snap.ByRoute, OtherRoute)
+	}
+}
+
+func TestMetricsConcurrentUpdates(t *testing.T) {
+	m := NewMetrics()
+	m.limitRoutes(RouteMetricsConfig{MaxRoutes: 10})
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 500; i++ {
+				path := fmt.Sprintf("/g%d/%d", g, i%20)
+				m.StartRequest(path)
+				m.RecordBytes(path, "fast", 1, 2)
+				m.EndRequest(path, time.Millisecond, i%10 == 0)
+				if i%100 == 0 {
+					m.Snapshot()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	snap := m.Snapshot()
+	if snap.TotalRequests != 4000 || snap.TotalErrors != 400 || snap.InFlight != 0 || snap.BytesOut != 8000 {
+		t.Fatalf("totals = %d requests, %d errors, %d in flight, %d bytes out", snap.TotalRequests, snap.TotalErrors, snap.InFlight, snap.BytesOut)
+	}
+	if pt := snap.ByPool["fast"]; pt == nil || pt.Requests != 4000 {
+		t.Fatalf("ByPool = %+v", snap.ByPool)
+	}
+	var count uint64
+	for _, rm := range snap.ByRoute {
+		count += rm.Count
+	}
+	if count != 4000 || len(snap.ByRoute) > 11 {
+		t.Fatalf("%d routes counting %d requests, want at most 10 plus %q counting 4000", len(snap.ByRoute), count, OtherRoute)
+	}
+}
+
+func BenchmarkMetricsParallel(b *testing.B) {
+	m := NewMetrics()
+	paths := make([]string, 64)
+	for i := range paths {
+		paths[i] = fmt.Sprintf("/api/%d", i)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			path := paths[i%len(paths)]
+			m.StartRequest(path)
+			m.RecordBytes(path, "fast", 100, 1000)
+			m.EndRequest(path, time.Millisecond, false)
+			i++
+		}
+	})
+}
@@ -0,0 +1,73 @@
+package appserver
+
+import (
+	"net"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// serverParams builds the CGI-style $_SERVER entries PHP would get from a
+// web server SAPI, so workers don't have to reconstruct them from headers.
+// DOCUMENT_ROOT depends on the app and is filled in by setDocumentRoot.
+func serverParams(r *http.Request, now time.Time) map[string]string {
+	uri := r.URL.RequestURI()
+	path, query, _ := strings.Cut(uri, "?")
+
+	params := map[string]string{
+		"GATEWAY_INTERFACE":  "CGI/1.1",
+		"SERVER_SOFTWARE":    "go-php",
+		"SERVER_PROTOCOL":    r.Proto,
+		"REQUEST_METHOD":     r.Method,
+		"REQUEST_URI":        uri,
+		"QUERY_STRING":       query,
+		"DOCUMENT_URI":       path,
+		"REQUEST_SCHEME":     "http",
+		"REQUEST_TIME":       strconv.FormatInt(now.Unix(), 10),
+		"REQUEST_TIME_FLOAT": strconv.FormatFloat(float64(now.UnixMicro())/1e6, 'f', 6, 64),
+	}
+	if r.TLS != nil {
+		params["HTTPS"] = "on"
+		params["REQUEST_SCHEME"] = "https"
+	}
+
+	if host, port, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		params["REMOTE_ADDR"] = host
+		params["REMOTE_PORT"] = port
+	}
+
+	if local, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		if host, port, err := net.SplitHostPort(local.String()); err == nil {
+			params["SERVER_ADDR"] = host
+			params["SERVER_PORT"] = port
+		}
+	}
+	name := r.Host
+	if host, port, err := net.SplitHostPort(r.Host); err == nil {
+		name = host
+		if _, ok := params["SERVER_PORT"]; !ok {
+			params["SERVER_PORT"] = port
+		}
+	}
+	if name != "" {
+		params["SERVER_NAME"] = strings.Trim(name, "[]")
+	}
+	if _, ok := params["SERVER_PORT"]; !ok {
+		params["SERVER_PORT"] = "80"
+		if r.TLS != nil {
+			params["SERVER_PORT"] = "443"
+		}
+	}
+
+	return params
+}
+
+// setDocumentRoot records the app's public directory (root/public) as
+// DOCUMENT_ROOT.
+func setDocumentRoot(params map[string]string, appRoot string) {
+	if params != nil && appRoot != "" {
+		params["DOCUMENT_ROOT"] = filepath.Join(appRoot, "public")
+	}
+}
@@ -0,0 +1,61 @@
+package appserver
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestServerParams(t *testing.T) {
+	r := httptest.NewRequest("GET", "http://example.com:8080/users?page=2", nil)
+	r.RemoteAddr = "203.0.113.9:51234"
+
+	params := serverParams(r, time.Unix(1700000000, 250000000))
+	want := map[string]string{
+		"REQUEST_METHOD":     "GET",
+		"REQUEST_URI":        "/users?page=2",
+		"QUERY_STRING":       "page=2",
+		"DOCUMENT_URI":       "/users",
+		"REQUEST_SCHEME":     "http",
+		"REQUEST_TIME":       "1700000000",
+		"REQUEST_TIME_FLOAT": "1700000000.250000",
+		"REMOTE_ADDR":        "203.0.113.9",
+		"REMOTE_PORT":        "51234",
+		"SERVER_NAME":        "example.com",
+		"SERVER_PORT":        "8080",
+	}
+	for k, v := range want {
+		if params[k] != v {
+			t.Errorf("%s = %q, want %q", k, params[k], v)
+		}
+	}
+	if _, ok := params["HTTPS"]; ok {
+		t.Errorf("HTTPS set on a plain request")
+	}
+}
+
+func TestServerParamsTLSAndLocalAddr(t *testing.T) {
+	r := httptest.NewRequest("GET", "https://example.com/", nil)
+	r.TLS = &tls.ConnectionState{}
+
+	params := serverParams(r, time.Now())
+	if params["HTTPS"] != "on" || params["REQUEST_SCHEME"] != "https" || params["SERVER_PORT"] != "443" {
+		t.Errorf("tls params = %v", params)
+	}
+
+	local := &net.TCPAddr{IP: net.ParseIP("10.0.0.5"), Port: 8443}
+	r = r.WithContext(context.WithValue(r.Context(), http.LocalAddrContextKey, local))
+	params = serverParams(r, time.Now())
+	if params["SERVER_ADDR"] != "10.0.0.5" || params["SERVER_PORT"] != "8443" {
+		t.Errorf("local addr params = %v", params)
+	}
+
+	setDocumentRoot(params, "/srv/app")
+	if params["DOCUMENT_ROOT"] != "/srv/app/public" {
+		t.Errorf("DOCUMENT_ROOT = %q", params["DOCUMENT_ROOT"])
+	}
+}
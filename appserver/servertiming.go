@@ -0,0 +1,42 @@
+package appserver
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"go-php/server"
+)
+
+// ServerTimingConfig adds a Server-Timing header to PHP responses, so
+// browser devtools show where a request's time went: waiting for a worker
+// ("queue"), in PHP ("php") and in the Go server around them ("go").
+// Streamed responses send their headers before the numbers are known and
+// get none.
+type ServerTimingConfig struct {
+	Enabled  bool     `json:"enabled"`
+	Prefixes []string `json:"prefixes"` // default: every path
+}
+
+func (c ServerTimingConfig) applies(path string) bool {
+	return c.Enabled && (len(c.Prefixes) == 0 || slices.ContainsFunc(c.Prefixes, func(p string) bool { return strings.HasPrefix(path, p) }))
+}
+
+// serverTiming formats the Server-Timing value for req, which took total
+// from being read to being ready to write.
+func serverTiming(req *server.RequestPayload, total time.Duration) string {
+	queue, worker := req.Timing()
+	goTime := max(total-queue-worker, 0)
+	ms := func(d time.Duration) string { return fmt.Sprintf("%.3f", durationMs(d)) }
+	return `queue;desc="Worker queue";dur=` + ms(queue) +
+		`, php;desc="PHP";dur=` + ms(worker) +
+		`, go;desc="Go server";dur=` + ms(goTime)
+}
+
+// addServerTiming appends the timing entry to w's headers, after any
+// Server-Timing PHP sent itself.
+func addServerTiming(w http.ResponseWriter, req *server.RequestPayload, total time.Duration) {
+	w.Header().Add("Server-Timing", serverTiming(req, total))
+}
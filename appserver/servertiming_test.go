@@ -0,0 +1,46 @@
+package appserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"go-php/server"
+)
+
+func TestServerTiming(t *testing.T) {
+	srv, err := New(&AppServerConfig{
+		Root: t.TempDir(),
+		Pools: []PoolConfig{{Name: "fast", Workers: 1, Transport: "tcp", Address: fakePHP(t, func(req *server.RequestPayload) *server.ResponsePayload {
+			time.Sleep(20 * time.Millisecond)
+			return &server.ResponsePayload{Status: http.StatusOK, Headers: map[string]string{"Server-Timing": "db;dur=5"}, Body: []byte("ok")}
+		})}},
+		ServerTiming: ServerTimingConfig{Enabled: true, Prefixes: []string{"/app"}},
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	t.Cleanup(func() { _ = srv.Shutdown(t.Context()) })
+
+	rr := httptest.NewRecorder()
+	srv.ServeHTTP(rr, httptest.NewRequest("GET", "/app/home", nil))
+	got := rr.Header().Values("Server-Timing")
+	if len(got) != 2 || got[0] != "db;dur=5" {
+		t.Fatalf("Server-Timing = %q, want PHP's entry then ours", got)
+	}
+	m := regexp.MustCompile(`^queue;desc="Worker queue";dur=[0-9.]+, php;desc="PHP";dur=([0-9.]+), go;desc="Go server";dur=[0-9.]+$`).FindStringSubmatch(got[1])
+	if m == nil {
+		t.Fatalf("Server-Timing = %q", got[1])
+	}
+	if php, _ := time.ParseDuration(m[1] + "ms"); php < 20*time.Millisecond {
+		t.Errorf("php dur = %v, want at least the handler's 20ms", php)
+	}
+
+	rr = httptest.NewRecorder()
+	srv.ServeHTTP(rr, httptest.NewRequest("GET", "/other", nil))
+	if got := rr.Header().Values("Server-Timing"); len(got) != 1 {
+		t.Errorf("outside the prefixes Server-Timing = %q, want only PHP's", got)
+	}
+}
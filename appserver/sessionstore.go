@@ -0,0 +1,200 @@
+package appserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PHPSessionConfig reads the user ID out of the PHP session named by the
+// request's session cookie, for apps that log users in with sessions
+// rather than tokens.
+type PHPSessionConfig struct {
+	Cookie string `json:"cookie"` // default PHPSESSID
+	Store  string `json:"store"`  // "files" (default) or "redis"
+
+	// Path is session.save_path for the files store (default
+	// /var/lib/php/sessions). Relative paths are under the root.
+	Path string `json:"path"`
+	// MaxLifetimeSeconds ignores session files older than
+	// session.gc_maxlifetime (default 1440).
+	MaxLifetimeSeconds int `json:"max_lifetime_seconds"`
+
+	RedisAddr     string `json:"redis_addr"` // default 127.0.0.1:6379
+	RedisPassword string `json:"redis_password"`
+	RedisDB       int    `json:"redis_db"`
+
+	// KeyPrefix comes before the session ID in file names and Redis keys;
+	// it defaults to "sess_" for files and "PHPREDIS_SESSION:" for Redis.
+	KeyPrefix string `json:"key_prefix"`
+
+	// Serializer is session.serialize_handler: "php" (default),
+	// "php_serialize" or "json".
+	Serializer string `json:"serializer"`
+
+	// UserKey names the session variable holding the user ID; dots walk
+	// into arrays, e.g. "auth.user.id". Default "user_id".
+	UserKey string `json:"user_key"`
+}
+
+type sessionStore struct {
+	cfg   PHPSessionConfig
+	path  string
+	redis *redisClient
+}
+
+func newSessionStore(root string, cfg PHPSessionConfig) (*sessionStore, error) {
+	if cfg.Cookie == "" {
+		cfg.Cookie = "PHPSESSID"
+	}
+	if cfg.Store == "" {
+		cfg.Store = "files"
+	}
+	if cfg.Serializer == "" {
+		cfg.Serializer = "php"
+	}
+	if cfg.UserKey == "" {
+		cfg.UserKey = "user_id"
+	}
+	s := &sessionStore{cfg: cfg}
+	switch cfg.Store {
+	case "files":
+		s.path = cfg.Path
+		if s.path == "" {
+			s.path = "/var/lib/php/sessions"
+		} else if !filepath.IsAbs(s.path) {
+			s.path = filepath.Join(root, s.path)
+		}
+		if s.cfg.KeyPrefix == "" {
+			s.cfg.KeyPrefix = "sess_"
+		}
+		if s.cfg.MaxLifetimeSeconds == 0 {
+			s.cfg.MaxLifetimeSeconds = 1440
+		}
+	case "redis":
+		if s.cfg.RedisAddr == "" {
+			s.cfg.RedisAddr = "127.0.0.1:6379"
+		}
+		if s.cfg.KeyPrefix == "" {
+			s.cfg.KeyPrefix = "PHPREDIS_SESSION:"
+		}
+		s.redis = newRedisClient(s.cfg.RedisAddr, s.cfg.RedisPassword, s.cfg.RedisDB)
+	default:
+		return nil, fmt.Errorf("unknown store %q (want files or redis)", cfg.Store)
+	}
+	switch cfg.Serializer {
+	case "php", "php_serialize", "json":
+	default:
+		return nil, fmt.Errorf("unknown serializer %q (want php, php_serialize or json)", cfg.Serializer)
+	}
+	return s, nil
+}
+
+// validSessionID matches PHP's session ID alphabet, which keeps IDs from
+// escaping the save path.
+func validSessionID(id string) bool {
+	if id == "" || len(id) > 256 {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == ',' || c == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// userID returns the user ID stored in r's session.
+func (s *sessionStore) userID(r *http.Request) (string, error) {
+	c, err := r.Cookie(s.cfg.Cookie)
+	if err != nil {
+		return "", err
+	}
+	if !validSessionID(c.Value) {
+		return "", errors.New("bad session id")
+	}
+
+	var data []byte
+	if s.cfg.Store == "redis" {
+		data, err = s.redis.do("GET", s.cfg.KeyPrefix+c.Value)
+	} else {
+		data, err = s.readFile(c.Value)
+	}
+	if err != nil {
+		return "", err
+	}
+	if len(data) == 0 {
+		return "", errors.New("no session")
+	}
+
+	var vars any
+	switch s.cfg.Serializer {
+	case "php":
+		vars, err = phpSessionDecode(data)
+	case "php_serialize":
+		vars, err = phpUnserialize(data)
+	case "json":
+		err = json.Unmarshal(data, &vars)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	v := vars
+	for _, key := range strings.Split(s.cfg.UserKey, ".") {
+		m, ok := v.(map[string]any)
+		if !ok {
+			return "", errors.New("no user in session")
+		}
+		v = m[key]
+	}
+	switch id := v.(type) {
+	case string:
+		if id != "" {
+			return id, nil
+		}
+	case int64:
+		return strconv.FormatInt(id, 10), nil
+	case float64:
+		return strconv.FormatFloat(id, 'f', -1, 64), nil
+	}
+	return "", errors.New("no user in session")
+}
+
+func (s *sessionStore) readFile(id string) ([]byte, error) {
+	path := filepath.Join(s.path, s.cfg.KeyPrefix+id)
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if time.Since(fi.ModTime()) > time.Duration(s.cfg.MaxLifetimeSeconds)*time.Second {
+		return nil, errors.New("session expired")
+	}
+	return os.ReadFile(path)
+}
+
+// validateWSSession reports session settings the store can't use.
+func validateWSSession(cfg *AppServerConfig, warn configWarnFunc) {
+	s := cfg.WSSession
+	if s == nil {
+		return
+	}
+	if s.Store != "" && s.Store != "files" && s.Store != "redis" {
+		warn("ws_session.store", "ws_session.store=%q is not files or redis", s.Store)
+	}
+	switch s.Serializer {
+	case "", "php", "php_serialize", "json":
+	default:
+		warn("ws_session.serializer", "ws_session.serializer=%q is not php, php_serialize or json", s.Serializer)
+	}
+	if s.MaxLifetimeSeconds < 0 {
+		warn("ws_session.max_lifetime_seconds", "ws_session.max_lifetime_seconds=%d is invalid, using 1440", s.MaxLifetimeSeconds)
+		s.MaxLifetimeSeconds = 0
+	}
+}
@@ -0,0 +1,119 @@
+package appserver
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPHPSessionStoreUserID(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, data string) {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("sess_abc123", `csrf|s:4:"tok1";user_id|i:42;flash|a:0:{}`)
+	write("sess_guest", `csrf|s:4:"tok2";`)
+	write("sess_nested", `a:2:{s:4:"auth";O:8:"stdClass":1:{s:4:"user";a:1:{s:2:"id";s:5:"u-123";}}s:4:"csrf";N;}`)
+
+	lookup := func(s *sessionStore, cookie string) (string, error) {
+		r := httptest.NewRequest("GET", "/ws", nil)
+		r.AddCookie(&http.Cookie{Name: "PHPSESSID", Value: cookie})
+		return s.userID(r)
+	}
+
+	files, err := newSessionStore(dir, PHPSessionConfig{Path: "."})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, err := lookup(files, "abc123"); err != nil || id != "42" {
+		t.Errorf("php serializer: got %q, %v", id, err)
+	}
+	for _, cookie := range []string{"guest", "missing", "../sess_abc123"} {
+		if id, err := lookup(files, cookie); err == nil {
+			t.Errorf("session %q authenticated as %q", cookie, id)
+		}
+	}
+
+	nested, err := newSessionStore(dir, PHPSessionConfig{Path: dir, Serializer: "php_serialize", UserKey: "auth.user.id"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, err := lookup(nested, "nested"); err != nil || id != "u-123" {
+		t.Errorf("php_serialize serializer: got %q, %v", id, err)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go fakeRedis(conn, "secret", map[string]string{"PHPREDIS_SESSION:r1": `{"user_id":7}`})
+		}
+	}()
+
+	redis, err := newSessionStore(dir, PHPSessionConfig{Store: "redis", RedisAddr: ln.Addr().String(), RedisPassword: "secret", Serializer: "json"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 2 { // the second lookup reuses the connection
+		if id, err := lookup(redis, "r1"); err != nil || id != "7" {
+			t.Errorf("redis: got %q, %v", id, err)
+		}
+	}
+	if id, err := lookup(redis, "r2"); err == nil {
+		t.Errorf("missing redis session authenticated as %q", id)
+	}
+}
+
+// fakeRedis answers AUTH and GET on conn.
+func fakeRedis(conn net.Conn, password string, keys map[string]string) {
+	defer conn.Close()
+	br := bufio.NewReader(conn)
+	authed := false
+	for {
+		var n int
+		if _, err := fmt.Fscanf(br, "*%d\r\n", &n); err != nil {
+			return
+		}
+		args := make([]string, n)
+		for i := range args {
+			var size int
+			if _, err := fmt.Fscanf(br, "$%d\r\n", &size); err != nil {
+				return
+			}
+			buf := make([]byte, size+2)
+			if _, err := io.ReadFull(br, buf); err != nil {
+				return
+			}
+			args[i] = string(buf[:size])
+		}
+		switch {
+		case strings.EqualFold(args[0], "AUTH"):
+			authed = args[1] == password
+			fmt.Fprint(conn, "+OK\r\n")
+		case !authed:
+			fmt.Fprint(conn, "-NOAUTH Authentication required.\r\n")
+		case strings.EqualFold(args[0], "GET"):
+			if v, ok := keys[args[1]]; ok {
+				fmt.Fprintf(conn, "$%d\r\n%s\r\n", len(v), v)
+			} else {
+				fmt.Fprint(conn, "$-1\r\n")
+			}
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package appserver
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// shadowHandler reports or adjusts an app's shadow traffic (?app= selects the app):
+//
+//	GET                    mirrored count, errors, status codes and latency
+//	POST {"percent": 10}   mirror 10% of eligible requests
+func shadowHandler(vhosts *vhostRouter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		srv := vhosts.byName(r.URL.Query().Get("app")).srv
+
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost:
+			var body struct {
+				Percent *int `json:"percent"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Percent == nil {
+				http.Error(w, "expected {\"percent\": 0-100}", http.StatusBadRequest)
+				return
+			}
+			if err := srv.SetShadowPercent(*body.Percent); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		stats, ok := srv.ShadowStats()
+		if !ok {
+			http.Error(w, "no shadow pool configured", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(stats)
+	}
+}
@@ -0,0 +1,39 @@
+package appserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go-php/server"
+)
+
+func TestShadowHandler(t *testing.T) {
+	srv := newCanaryTestServer(t)
+	h := shadowHandler(&vhostRouter{def: &vhost{name: "default", srv: srv}})
+
+	rr := httptest.NewRecorder()
+	h(rr, httptest.NewRequest(http.MethodGet, "/__baremetal/shadow", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 without shadow, got %d", rr.Code)
+	}
+
+	if err := srv.SetShadow(server.Shadow{Pool: "canary", Percent: 5}); err != nil {
+		t.Fatalf("SetShadow: %v", err)
+	}
+
+	rr = httptest.NewRecorder()
+	h(rr, httptest.NewRequest(http.MethodPost, "/__baremetal/shadow", strings.NewReader(`{"percent": 25}`)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var stats server.ShadowStats
+	if err := json.Unmarshal(rr.Body.Bytes(), &stats); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if stats.Pool != "canary" || stats.Percent != 25 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
@@ -0,0 +1,175 @@
+package appserver
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// WSCookieConfig makes the WebSocket cookie fallback accept only signed
+// cookies. Format "hmac" expects <user id>.<base64url HMAC-SHA256 of the
+// id>; "laravel" reads cookies written by Laravel's EncryptCookies
+// middleware, with Secret being the APP_KEY.
+type WSCookieConfig struct {
+	Name   string `json:"name"`   // default bm_user_id
+	Secret string `json:"secret"` // default APP_COOKIE_SECRET, or APP_KEY for "laravel"
+	Format string `json:"format"` // "hmac" (default) or "laravel"
+}
+
+// cookieVerifier returns the user ID of a signed cookie.
+type cookieVerifier struct {
+	name   string
+	format string
+	key    []byte
+}
+
+func newCookieVerifier(cfg WSCookieConfig) (*cookieVerifier, error) {
+	v := &cookieVerifier{name: cfg.Name, format: cfg.Format}
+	if v.name == "" {
+		v.name = "bm_user_id"
+	}
+	if v.format == "" {
+		v.format = "hmac"
+	}
+
+	secret := cfg.Secret
+	switch v.format {
+	case "hmac":
+		if secret == "" {
+			secret = os.Getenv("APP_COOKIE_SECRET")
+		}
+		v.key = []byte(secret)
+	case "laravel":
+		if secret == "" {
+			secret = os.Getenv("APP_KEY")
+		}
+		// Laravel keys are written "base64:<key>"
+		if b64, ok := strings.CutPrefix(secret, "base64:"); ok {
+			key, err := base64.StdEncoding.DecodeString(b64)
+			if err != nil {
+				return nil, fmt.Errorf("bad APP_KEY: %w", err)
+			}
+			v.key = key
+		} else {
+			v.key = []byte(secret)
+		}
+		if len(v.key) != 16 && len(v.key) != 32 {
+			return nil, fmt.Errorf("APP_KEY is %d bytes, want 16 (AES-128-CBC) or 32 (AES-256-CBC)", len(v.key))
+		}
+	default:
+		return nil, fmt.Errorf("unknown format %q (want hmac or laravel)", v.format)
+	}
+	if len(v.key) == 0 {
+		return nil, errors.New("no secret")
+	}
+	return v, nil
+}
+
+// userID verifies value, a raw cookie value, and returns the ID it carries.
+func (v *cookieVerifier) userID(value string) (string, error) {
+	if v.format == "laravel" {
+		return v.laravel(value)
+	}
+	id, sig, ok := strings.Cut(value, ".")
+	if !ok || id == "" {
+		return "", errors.New("unsigned cookie")
+	}
+	got, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil || !hmac.Equal(got, v.sign(id)) {
+		return "", errors.New("bad cookie signature")
+	}
+	return id, nil
+}
+
+func (v *cookieVerifier) sign(id string) []byte {
+	m := hmac.New(sha256.New, v.key)
+	m.Write([]byte(id))
+	return m.Sum(nil)
+}
+
+// laravel checks the MAC of a Laravel-encrypted cookie, decrypts it and
+// strips the cookie name prefix Laravel binds values with.
+func (v *cookieVerifier) laravel(value string) (string, error) {
+	if unescaped, err := url.QueryUnescape(value); err == nil {
+		value = unescaped
+	}
+	raw, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return "", err
+	}
+	var p struct {
+		IV    string `json:"iv"`
+		Value string `json:"value"`
+		MAC   string `json:"mac"`
+	}
+	if err := json.Unmarshal(raw, &p); err != nil {
+		return "", err
+	}
+
+	m := hmac.New(sha256.New, v.key)
+	m.Write([]byte(p.IV + p.Value))
+	mac, err := hex.DecodeString(p.MAC)
+	if err != nil || !hmac.Equal(mac, m.Sum(nil)) {
+		return "", errors.New("bad cookie MAC")
+	}
+
+	iv, err := base64.StdEncoding.DecodeString(p.IV)
+	if err != nil {
+		return "", err
+	}
+	data, err := base64.StdEncoding.DecodeString(p.Value)
+	if err != nil {
+		return "", err
+	}
+	block, err := aes.NewCipher(v.key)
+	if err != nil {
+		return "", err
+	}
+	if len(iv) != block.BlockSize() || len(data) == 0 || len(data)%block.BlockSize() != 0 {
+		return "", errors.New("bad cookie ciphertext")
+	}
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(data, data)
+	pad := int(data[len(data)-1])
+	if pad == 0 || pad > block.BlockSize() {
+		return "", errors.New("bad cookie padding")
+	}
+	data = data[:len(data)-pad]
+
+	// CookieValuePrefix: hash_hmac('sha1', $name.'v2', $key).'|'
+	pm := hmac.New(sha1.New, v.key)
+	pm.Write([]byte(v.name + "v2"))
+	id, ok := strings.CutPrefix(string(data), hex.EncodeToString(pm.Sum(nil))+"|")
+	if !ok || id == "" {
+		return "", errors.New("cookie was issued under another name")
+	}
+	return id, nil
+}
+
+// validateWSCookie reports a signed cookie fallback that can't verify
+// anything.
+func validateWSCookie(cfg *AppServerConfig, warn configWarnFunc) {
+	c := cfg.WSCookie
+	if c == nil {
+		return
+	}
+	if c.Format != "" && c.Format != "hmac" && c.Format != "laravel" {
+		warn("ws_cookie.format", "ws_cookie.format=%q is not hmac or laravel", c.Format)
+	}
+	env := "APP_COOKIE_SECRET"
+	if c.Format == "laravel" {
+		env = "APP_KEY"
+	}
+	if c.Secret == "" && os.Getenv(env) == "" {
+		warn("ws_cookie.secret", "ws_cookie has no secret and %s is not set", env)
+	}
+}
@@ -0,0 +1,111 @@
+package appserver
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// laravelCookie encrypts value the way Laravel's EncryptCookies does.
+func laravelCookie(t *testing.T, key []byte, name, value string) string {
+	t.Helper()
+	pm := hmac.New(sha1.New, key)
+	pm.Write([]byte(name + "v2"))
+	plain := []byte(hex.EncodeToString(pm.Sum(nil)) + "|" + value)
+	pad := aes.BlockSize - len(plain)%aes.BlockSize
+	plain = append(plain, bytes.Repeat([]byte{byte(pad)}, pad)...)
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iv := bytes.Repeat([]byte{7}, aes.BlockSize)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(plain, plain)
+
+	ivB64 := base64.StdEncoding.EncodeToString(iv)
+	valB64 := base64.StdEncoding.EncodeToString(plain)
+	m := hmac.New(sha256.New, key)
+	m.Write([]byte(ivB64 + valB64))
+	payload, _ := json.Marshal(map[string]string{"iv": ivB64, "value": valB64, "mac": hex.EncodeToString(m.Sum(nil)), "tag": ""})
+	return url.QueryEscape(base64.StdEncoding.EncodeToString(payload))
+}
+
+func TestSignedCookieFallbackForWS(t *testing.T) {
+	auth := func(cfg *WSCookieConfig, cookie *http.Cookie) (string, error) {
+		t.Helper()
+		srv, err := New(&AppServerConfig{
+			Root:     t.TempDir(),
+			Pools:    []PoolConfig{{Name: "fast", Workers: 1, Transport: "tcp", Address: fakePHP(t, nil)}},
+			WSCookie: cfg,
+		})
+		if err != nil {
+			t.Fatalf("New: %v", err)
+		}
+		t.Cleanup(func() { _ = srv.Shutdown(t.Context()) })
+		r := httptest.NewRequest("GET", "/ws", nil)
+		r.AddCookie(cookie)
+		return authenticateWS(srv.auth, r)
+	}
+
+	hmacCfg := &WSCookieConfig{Secret: "cookie-secret"}
+	m := hmac.New(sha256.New, []byte("cookie-secret"))
+	m.Write([]byte("42"))
+	signed := "42." + base64.RawURLEncoding.EncodeToString(m.Sum(nil))
+
+	if id, err := auth(hmacCfg, &http.Cookie{Name: "bm_user_id", Value: signed}); err != nil || id != "42" {
+		t.Errorf("signed cookie: got %q, %v", id, err)
+	}
+	for _, v := range []string{"42", "43" + signed[2:], signed + "x"} {
+		if id, err := auth(hmacCfg, &http.Cookie{Name: "bm_user_id", Value: v}); err == nil {
+			t.Errorf("cookie %q accepted as %q", v, id)
+		}
+	}
+
+	key := bytes.Repeat([]byte{1}, 32)
+	laravelCfg := &WSCookieConfig{Name: "user_id", Format: "laravel", Secret: "base64:" + base64.StdEncoding.EncodeToString(key)}
+	if id, err := auth(laravelCfg, &http.Cookie{Name: "user_id", Value: laravelCookie(t, key, "user_id", "99")}); err != nil || id != "99" {
+		t.Errorf("laravel cookie: got %q, %v", id, err)
+	}
+	if _, err := auth(laravelCfg, &http.Cookie{Name: "user_id", Value: laravelCookie(t, key, "other", "99")}); err == nil {
+		t.Error("laravel cookie issued under another name accepted")
+	}
+	if _, err := auth(laravelCfg, &http.Cookie{Name: "user_id", Value: laravelCookie(t, bytes.Repeat([]byte{2}, 32), "user_id", "99")}); err == nil {
+		t.Error("laravel cookie encrypted with another key accepted")
+	}
+}
+
+func TestWSCookieIsPerServer(t *testing.T) {
+	newServer := func(cfg *WSCookieConfig) *Server {
+		srv, err := New(&AppServerConfig{
+			Root:     t.TempDir(),
+			Pools:    []PoolConfig{{Name: "fast", Workers: 1, Transport: "tcp", Address: fakePHP(t, nil)}},
+			WSCookie: cfg,
+		})
+		if err != nil {
+			t.Fatalf("New: %v", err)
+		}
+		t.Cleanup(func() { _ = srv.Shutdown(t.Context()) })
+		return srv
+	}
+	signed := newServer(&WSCookieConfig{Secret: "cookie-secret"})
+	plain := newServer(nil)
+
+	r := httptest.NewRequest("GET", "/ws", nil)
+	r.AddCookie(&http.Cookie{Name: "bm_user_id", Value: "42"})
+	if id, err := authenticateWS(signed.auth, r); err == nil {
+		t.Fatalf("unsigned cookie accepted as %q after another Server started without ws_cookie", id)
+	}
+	if id, err := authenticateWS(plain.auth, r); err != nil || id != "42" {
+		t.Fatalf("server without ws_cookie: got %q, %v", id, err)
+	}
+}
@@ -0,0 +1,83 @@
+package appserver
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+
+	"go-php/server"
+)
+
+// SpoolConfig moves large buffered responses out of the heap: bodies above
+// ThresholdBytes are written to a temp file in Dir (os.TempDir() if empty)
+// and streamed to the client from disk, so slow clients don't pin the
+// response in memory.
+type SpoolConfig struct {
+	ThresholdBytes int    `json:"threshold_bytes"`
+	Dir            string `json:"dir"`
+}
+
+func (c SpoolConfig) shouldSpool(size int) bool {
+	return c.ThresholdBytes > 0 && size > c.ThresholdBytes
+}
+
+// writeResponseBody writes the status line and body for a buffered worker
+// response, spooling it to disk first when it is large enough, and returns
+// the bytes of body written, which fall short if the client goes away. Relayed bodies are never spooled: they go straight
+// through.
+func writeResponseBody(w http.ResponseWriter, status int, resp *server.ResponsePayload, spool SpoolConfig) int64 {
+	if resp.Relayed() {
+		return writeRelayedBody(w, status, resp)
+	}
+	if spool.shouldSpool(len(resp.Body)) {
+		f, err := spoolBody(resp.Body, spool.Dir)
+		if err == nil {
+			defer closeSpool(f)
+
+			// Drop our reference so the GC can reclaim the body while a
+			// slow client drains the file.
+			size := len(resp.Body)
+			resp.Body = nil
+
+			w.Header().Set("Content-Length", strconv.Itoa(size))
+			w.WriteHeader(status)
+
+			// *os.File lets net/http use sendfile where the platform supports it.
+			n, err := io.Copy(w, f)
+			if err != nil {
+				log.Printf("[spool] copy to client: %v after %d of %d bytes", err, n, size)
+			}
+			return n
+		}
+		log.Printf("[spool] falling back to in-memory body: %v", err)
+	}
+
+	w.WriteHeader(status)
+	n, _ := w.Write(resp.Body)
+	return int64(n)
+}
+
+// spoolBody writes body to a fresh temp file and rewinds it for reading.
+func spoolBody(body []byte, dir string) (*os.File, error) {
+	f, err := os.CreateTemp(dir, "go-php-spool-*")
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := f.Write(body); err != nil {
+		closeSpool(f)
+		return nil, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		closeSpool(f)
+		return nil, err
+	}
+	return f, nil
+}
+
+func closeSpool(f *os.File) {
+	_ = f.Close()
+	_ = os.Remove(f.Name())
+}
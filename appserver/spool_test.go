@@ -0,0 +1,87 @@
+package appserver
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"go-php/server"
+)
+
+func TestWriteResponseBodySpoolsLargeBodies(t *testing.T) {
+	dir := t.TempDir()
+	body := strings.Repeat("x", 4096)
+	resp := &server.ResponsePayload{Body: []byte(body)}
+
+	rr := httptest.NewRecorder()
+	writeResponseBody(rr, http.StatusCreated, resp, SpoolConfig{ThresholdBytes: 1024, Dir: dir})
+
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d", rr.Code)
+	}
+	if rr.Body.String() != body {
+		t.Fatalf("spooled body mismatch (len %d)", rr.Body.Len())
+	}
+	if rr.Header().Get("Content-Length") != "4096" {
+		t.Fatalf("expected Content-Length 4096, got %q", rr.Header().Get("Content-Length"))
+	}
+	if string(resp.Body) != "" {
+		t.Fatalf("expected in-memory body to be released after spooling")
+	}
+
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 0 {
+		t.Fatalf("expected spool file to be removed, found %d entries", len(entries))
+	}
+}
+
+func TestWriteResponseBodyBelowThreshold(t *testing.T) {
+	resp := &server.ResponsePayload{Body: []byte("small")}
+
+	rr := httptest.NewRecorder()
+	writeResponseBody(rr, http.StatusOK, resp, SpoolConfig{ThresholdBytes: 1024})
+
+	if rr.Body.String() != "small" || string(resp.Body) != "small" {
+		t.Fatalf("small bodies should be written directly")
+	}
+}
+
+func TestWriteResponseBodySpoolFailureFallsBack(t *testing.T) {
+	resp := &server.ResponsePayload{Body: []byte(strings.Repeat("y", 10))}
+
+	rr := httptest.NewRecorder()
+	writeResponseBody(rr, http.StatusOK, resp, SpoolConfig{ThresholdBytes: 1, Dir: "/nonexistent/spool/dir"})
+
+	if rr.Body.String() != strings.Repeat("y", 10) {
+		t.Fatalf("expected in-memory fallback, got %q", rr.Body.String())
+	}
+}
+
+// failingWriter takes limit bytes, then fails like a client that hung up.
+type failingWriter struct {
+	*httptest.ResponseRecorder
+	limit int
+}
+
+func (fw *failingWriter) Write(p []byte) (int, error) {
+	if len(p) > fw.limit {
+		n, _ := fw.ResponseRecorder.Write(p[:fw.limit])
+		fw.limit = 0
+		return n, io.ErrClosedPipe
+	}
+	fw.limit -= len(p)
+	return fw.ResponseRecorder.Write(p)
+}
+
+func TestWriteResponseBodyCountsPartialWrites(t *testing.T) {
+	for _, spool := range []SpoolConfig{{ThresholdBytes: 1024, Dir: t.TempDir()}, {}} {
+		resp := &server.ResponsePayload{Body: []byte(strings.Repeat("z", 4096))}
+		fw := &failingWriter{ResponseRecorder: httptest.NewRecorder(), limit: 1000}
+		if n := writeResponseBody(fw, http.StatusOK, resp, spool); n != 1000 {
+			t.Fatalf("spool %+v: wrote %d bytes, want 1000", spool, n)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package appserver
+
+import (
+	"bytes"
+	"container/list"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// StaticCacheConfig keeps small static files in memory, so a hit costs a
+// stat instead of an open, read and close. Entries are keyed by path and
+// dropped when the file's mtime or size changes; the least recently used
+// go first once MaxBytes is reached.
+type StaticCacheConfig struct {
+	Enabled      bool  `json:"enabled"`
+	MaxFileBytes int64 `json:"max_file_bytes"` // larger files aren't cached (default 256 KiB)
+	MaxBytes     int64 `json:"max_bytes"`      // total budget (default 32 MiB)
+}
+
+const (
+	defaultStaticCacheMaxFileBytes = 256 << 10
+	defaultStaticCacheMaxBytes     = 32 << 20
+)
+
+// StaticCacheStats is reported under "static_cache" in the metrics.
+type StaticCacheStats struct {
+	Hits    uint64 `json:"hits"`
+	Misses  uint64 `json:"misses"`
+	Entries int    `json:"entries"`
+	Bytes   int64  `json:"bytes"`
+}
+
+type fileEntry struct {
+	path    string
+	modTime time.Time
+	data    []byte
+}
+
+type fileCache struct {
+	cfg StaticCacheConfig
+
+	mu      sync.Mutex
+	entries map[string]*list.Element // of *fileEntry
+	lru     *list.List               // most recently used at the front
+	used    int64
+
+	hits, misses atomic.Uint64
+}
+
+// newFileCache returns nil when the cache is disabled, which serveStatic
+// treats as "open the file every time".
+func newFileCache(cfg StaticCacheConfig) *fileCache {
+	if !cfg.Enabled {
+		return nil
+	}
+	if cfg.MaxFileBytes <= 0 {
+		cfg.MaxFileBytes = defaultStaticCacheMaxFileBytes
+	}
+	if cfg.MaxBytes <= 0 {
+		cfg.MaxBytes = defaultStaticCacheMaxBytes
+	}
+	cfg.MaxFileBytes = min(cfg.MaxFileBytes, cfg.MaxBytes)
+	return &fileCache{cfg: cfg, entries: make(map[string]*list.Element), lru: list.New()}
+}
+
+// serve writes path from memory, reading it into the cache on a miss.
+// It returns false for files too large to cache, leaving them to the caller.
+func (c *fileCache) serve(w http.ResponseWriter, r *http.Request, path string, info os.FileInfo) bool {
+	if info.Size() > c.cfg.MaxFileBytes {
+		return false
+	}
+	data, ok := c.get(path, info)
+	if !ok {
+		c.misses.Add(1)
+		var err error
+		if data, err = os.ReadFile(path); err != nil || int64(len(data)) != info.Size() {
+			// changed under us: let http.ServeFile deal with it
+			return false
+		}
+		c.put(path, info.ModTime(), data)
+	} else {
+		c.hits.Add(1)
+	}
+
+	if ct := mime.TypeByExtension(filepath.Ext(path)); ct != "" && w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", ct)
+	}
+	http.ServeContent(w, r, path, info.ModTime(), bytes.NewReader(data))
+	return true
+}
+
+func (c *fileCache) get(path string, info os.FileInfo) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[path]
+	if !ok {
+		return nil, false
+	}
+	e := el.Value.(*fileEntry)
+	if !e.modTime.Equal(info.ModTime()) || int64(len(e.data)) != info.Size() {
+		c.remove(el)
+		return nil, false
+	}
+	c.lru.MoveToFront(el)
+	return e.data, true
+}
+
+func (c *fileCache) put(path string, modTime time.Time, data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[path]; ok {
+		c.remove(el)
+	}
+	for c.used+int64(len(data)) > c.cfg.MaxBytes && c.lru.Len() > 0 {
+		c.remove(c.lru.Back())
+	}
+	c.entries[path] = c.lru.PushFront(&fileEntry{path: path, modTime: modTime, data: data})
+	c.used += int64(len(data))
+}
+
+// remove drops el; c.mu must be held.
+func (c *fileCache) remove(el *list.Element) {
+	e := c.lru.Remove(el).(*fileEntry)
+	delete(c.entries, e.path)
+	c.used -= int64(len(e.data))
+}
+
+// resetStats zeroes the hit and miss counters.
+func (c *fileCache) resetStats() {
+	if c != nil {
+		c.hits.Store(0)
+		c.misses.Store(0)
+	}
+}
+
+func (c *fileCache) stats() *StaticCacheStats {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return &StaticCacheStats{
+		Hits:    c.hits.Load(),
+		Misses:  c.misses.Load(),
+		Entries: c.lru.Len(),
+		Bytes:   c.used,
+	}
+}
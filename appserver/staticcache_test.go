@@ -0,0 +1,83 @@
+package appserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStaticCache(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "public")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	write := func(name, body string, mtime time.Time) {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(body), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	now := time.Now().Truncate(time.Second)
+	write("a.css", "aaaa", now)
+	write("b.js", "bbbb", now)
+	write("c.svg", "cccc", now)
+	write("big.txt", "0123456789", now)
+
+	rules := []StaticRule{{Prefix: "/", Dir: "public"}}
+	files := newFileCache(StaticCacheConfig{Enabled: true, MaxFileBytes: 6, MaxBytes: 8})
+	get := func(path string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		if !serveStatic(rr, httptest.NewRequest("GET", path, nil), root, rules, nil, files) {
+			t.Fatalf("%s not served", path)
+		}
+		return rr
+	}
+
+	if rr := get("/a.css"); rr.Body.String() != "aaaa" || rr.Header().Get("Content-Type") != "text/css; charset=utf-8" {
+		t.Fatalf("a.css: %q %q", rr.Body.String(), rr.Header().Get("Content-Type"))
+	}
+	get("/a.css")
+	if st := files.stats(); st.Hits != 1 || st.Misses != 1 || st.Entries != 1 {
+		t.Fatalf("after two reads: %+v", st)
+	}
+
+	// two files fit: c.svg evicts b.js, the least recently used
+	get("/b.js")
+	get("/a.css")
+	get("/c.svg")
+	get("/a.css")
+	if st := files.stats(); st.Hits != 3 || st.Misses != 3 || st.Entries != 2 || st.Bytes != 8 {
+		t.Fatalf("after eviction: %+v", st)
+	}
+	get("/b.js")
+	if st := files.stats(); st.Misses != 4 {
+		t.Fatalf("b.js should have been evicted: %+v", st)
+	}
+
+	// a new mtime invalidates the entry
+	write("a.css", "AAAA", now.Add(time.Second))
+	if rr := get("/a.css"); rr.Body.String() != "AAAA" {
+		t.Fatalf("stale body %q", rr.Body.String())
+	}
+
+	// too large to cache, but still served
+	if rr := get("/big.txt"); rr.Body.String() != "0123456789" || files.stats().Entries != 2 {
+		t.Fatalf("big.txt: %q %+v", rr.Body.String(), files.stats())
+	}
+
+	// conditional requests still work from memory
+	req := httptest.NewRequest("GET", "/b.js", nil)
+	req.Header.Set("If-Modified-Since", now.UTC().Format(http.TimeFormat))
+	rr := httptest.NewRecorder()
+	serveStatic(rr, req, root, rules, nil, files)
+	if rr.Code != http.StatusNotModified {
+		t.Fatalf("If-Modified-Since: got %d", rr.Code)
+	}
+}
@@ -0,0 +1,67 @@
+package appserver
+
+import (
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// StaticMetrics counts responses from the static rules. Those served
+// before PHP is asked are not part of TotalRequests.
+type StaticMetrics struct {
+	Requests     uint64        `json:"requests"`
+	Bytes        uint64        `json:"bytes"`
+	NotModified  uint64        `json:"not_modified"`
+	Errors       uint64        `json:"errors"` // 4xx/5xx, e.g. refused paths
+	TotalLatency time.Duration `json:"total_latency_ns"`
+	// CacheHits is filled in from static_cache when a snapshot is served.
+	CacheHits uint64 `json:"cache_hits"`
+}
+
+// staticCounters is the live form of StaticMetrics.
+type staticCounters struct {
+	requests, bytes, notModified, errors atomic.Uint64
+	latency                              atomic.Int64
+}
+
+func (c *staticCounters) snapshot() StaticMetrics {
+	return StaticMetrics{
+		Requests:     c.requests.Load(),
+		Bytes:        c.bytes.Load(),
+		NotModified:  c.notModified.Load(),
+		Errors:       c.errors.Load(),
+		TotalLatency: time.Duration(c.latency.Load()),
+	}
+}
+
+func (c *staticCounters) reset() {
+	c.requests.Store(0)
+	c.bytes.Store(0)
+	c.notModified.Store(0)
+	c.errors.Store(0)
+	c.latency.Store(0)
+}
+
+// RecordStatic counts one static response.
+func (m *Metrics) RecordStatic(status int, bytes int64, latency time.Duration) {
+	m.static.requests.Add(1)
+	m.static.bytes.Add(uint64(bytes))
+	m.static.latency.Add(int64(latency))
+	switch {
+	case status == http.StatusNotModified:
+		m.static.notModified.Add(1)
+	case status >= 400:
+		m.static.errors.Add(1)
+	}
+}
+
+// serveStaticCounted is serveStatic, recording what it serves in metrics.
+func (s *Server) serveStaticCounted(w http.ResponseWriter, r *http.Request, app *vhost) bool {
+	start := time.Now()
+	rec := &countingWriter{ResponseWriter: w}
+	if !serveStatic(rec, r, app.root, app.staticRules(), app.gzip, s.files) {
+		return false
+	}
+	s.metrics.RecordStatic(rec.status, rec.bytes, time.Since(start))
+	return true
+}
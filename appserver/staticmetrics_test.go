@@ -0,0 +1,70 @@
+package appserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go-php/server"
+)
+
+func TestStaticMetrics(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "public"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	path := filepath.Join(root, "public/app.js")
+	if err := os.WriteFile(path, []byte("console.log(1)"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	_ = os.Chtimes(path, mtime, mtime)
+	srv, err := New(&AppServerConfig{
+		Root: root,
+		Pools: []PoolConfig{{Name: "fast", Workers: 1, Transport: "tcp", Address: fakePHP(t, func(req *server.RequestPayload) *server.ResponsePayload {
+			return &server.ResponsePayload{Status: http.StatusOK, Body: []byte("php")}
+		})}},
+		Static:      []StaticRule{{Prefix: "/", Dir: "public"}},
+		StaticCache: StaticCacheConfig{Enabled: true},
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	t.Cleanup(func() { _ = srv.Shutdown(t.Context()) })
+
+	get := func(target string, hdr map[string]string) {
+		req := httptest.NewRequest("GET", target, nil)
+		for k, v := range hdr {
+			req.Header.Set(k, v)
+		}
+		srv.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	get("/app.js", nil)
+	get("/app.js", nil)
+	get("/app.js", map[string]string{"If-Modified-Since": mtime.UTC().Format(http.TimeFormat)})
+	get("/index.php", nil)
+
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/__baremetal/metrics", nil)
+	req.RemoteAddr = "127.0.0.1:1234"
+	srv.ServeHTTP(rr, req)
+
+	var snap Metrics
+	if err := json.Unmarshal(rr.Body.Bytes(), &snap); err != nil {
+		t.Fatalf("metrics: %v %s", err, rr.Body.String())
+	}
+	st := snap.Static
+	if st.Requests != 3 || st.Bytes != 2*uint64(len("console.log(1)")) || st.NotModified != 1 || st.TotalLatency <= 0 {
+		t.Errorf("static metrics: %+v", st)
+	}
+	if st.CacheHits != 2 {
+		t.Errorf("cache_hits = %d, want 2", st.CacheHits)
+	}
+	if snap.TotalRequests != 1 {
+		t.Errorf("total_requests = %d, want only the PHP request", snap.TotalRequests)
+	}
+}
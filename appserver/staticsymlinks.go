@@ -0,0 +1,52 @@
+package appserver
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Symlink policies for a static rule's "symlinks" setting.
+const (
+	symlinksFollow = "follow" // serve wherever a link points (default)
+	symlinksWithin = "within" // follow links, but only to files under the rule's dir
+	symlinksDeny   = "deny"   // never serve through a link below the rule's dir
+)
+
+// withinDir reports whether path is dir or below it.
+func withinDir(path, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// symlinkAllowed applies policy to fullPath, which is known to be under
+// baseDir before links are resolved. baseDir itself may be a link (as in
+// current -> releases/N deploys) under every policy.
+func symlinkAllowed(policy, baseDir, fullPath string) bool {
+	switch policy {
+	case symlinksWithin:
+		base, err := filepath.EvalSymlinks(baseDir)
+		if err != nil {
+			return false
+		}
+		resolved, err := filepath.EvalSymlinks(fullPath)
+		return err == nil && withinDir(resolved, base)
+	case symlinksDeny:
+		for p := fullPath; p != baseDir && withinDir(p, baseDir); p = filepath.Dir(p) {
+			info, err := os.Lstat(p)
+			if err != nil || info.Mode()&os.ModeSymlink != 0 {
+				return false
+			}
+		}
+		return true
+	}
+	return true
+}
+
+func validSymlinkPolicy(policy string) bool {
+	switch policy {
+	case "", symlinksFollow, symlinksWithin, symlinksDeny:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,63 @@
+package appserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStaticSymlinkPolicy(t *testing.T) {
+	root := t.TempDir()
+	public := filepath.Join(root, "public")
+	for _, d := range []string{"public/css", "secrets"} {
+		if err := os.MkdirAll(filepath.Join(root, d), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, body := range map[string]string{"public/css/app.css": "css", "secrets/.env": "KEY=1"} {
+		if err := os.WriteFile(filepath.Join(root, name), []byte(body), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	links := map[string]string{
+		"public/env":       filepath.Join(root, "secrets/.env"),
+		"public/style.css": filepath.Join(public, "css/app.css"),
+		"public/styles":    filepath.Join(public, "css"),
+	}
+	for link, target := range links {
+		if err := os.Symlink(target, filepath.Join(root, link)); err != nil {
+			t.Skipf("symlinks unsupported: %v", err)
+		}
+	}
+	// a sibling dir sharing the prefix must not count as inside public/
+	if err := os.MkdirAll(filepath.Join(root, "public2"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "public2/x.txt"), []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		policy, path string
+		want         int
+	}{
+		{"", "/env", http.StatusOK},
+		{"within", "/env", http.StatusForbidden},
+		{"within", "/style.css", http.StatusOK},
+		{"within", "/styles/app.css", http.StatusOK},
+		{"deny", "/style.css", http.StatusForbidden},
+		{"deny", "/styles/app.css", http.StatusForbidden},
+		{"deny", "/css/app.css", http.StatusOK},
+		{"", "/../public2/x.txt", http.StatusForbidden},
+	} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.URL.Path = tc.path
+		tryServeStatic(w, r, root, []StaticRule{{Prefix: "/", Dir: "public", Symlinks: tc.policy}})
+		if w.Code != tc.want {
+			t.Errorf("symlinks=%q %s: got %d, want %d", tc.policy, tc.path, w.Code, tc.want)
+		}
+	}
+}
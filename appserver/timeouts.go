@@ -0,0 +1,75 @@
+package appserver
+
+import (
+	"net/http"
+	"time"
+)
+
+// ClientTimeouts bounds how long a client may hold a connection, so slow
+// or idle clients (Slowloris) can't use up the server's connections. Zero
+// takes the default; -1 turns a timeout off.
+type ClientTimeouts struct {
+	ReadHeaderMs int `json:"read_header_ms"` // default 10s
+	ReadMs       int `json:"read_ms"`        // whole request incl. body; default 60s
+	IdleMs       int `json:"idle_ms"`        // between keep-alive requests; default 120s
+
+	// WriteMs bounds writing a response. It's off by default since it
+	// also cuts off large downloads; streamed PHP responses and SSE are
+	// always exempt.
+	WriteMs int `json:"write_ms"`
+}
+
+func defaultClientTimeouts() ClientTimeouts {
+	return ClientTimeouts{ReadHeaderMs: 10_000, ReadMs: 60_000, IdleMs: 120_000, WriteMs: -1}
+}
+
+// apply sets t's timeouts on srv. net/http treats negative timeouts as
+// off, which is what -1 means here.
+func (t ClientTimeouts) apply(srv *http.Server) {
+	ms := time.Millisecond
+	srv.ReadHeaderTimeout = time.Duration(t.ReadHeaderMs) * ms
+	srv.ReadTimeout = time.Duration(t.ReadMs) * ms
+	srv.IdleTimeout = time.Duration(t.IdleMs) * ms
+	srv.WriteTimeout = time.Duration(t.WriteMs) * ms
+}
+
+// requestRead lifts the read timeout once the request has been read, so
+// time spent in PHP doesn't count against it.
+func requestRead(w http.ResponseWriter) {
+	_ = http.NewResponseController(w).SetReadDeadline(time.Time{})
+}
+
+// streaming lifts the read and write timeouts for a response that runs
+// for as long as it needs to.
+func streaming(w http.ResponseWriter) {
+	rc := http.NewResponseController(w)
+	_ = rc.SetReadDeadline(time.Time{})
+	_ = rc.SetWriteDeadline(time.Time{})
+}
+
+// validateClientTimeouts fills in defaults and reports invalid values.
+func validateClientTimeouts(cfg *AppServerConfig, warn configWarnFunc) {
+	def := defaultClientTimeouts()
+	t := &cfg.ClientTimeouts
+	for _, f := range []struct {
+		name string
+		v    *int
+		def  int
+	}{
+		{"read_header_ms", &t.ReadHeaderMs, def.ReadHeaderMs},
+		{"read_ms", &t.ReadMs, def.ReadMs},
+		{"idle_ms", &t.IdleMs, def.IdleMs},
+		{"write_ms", &t.WriteMs, def.WriteMs},
+	} {
+		if *f.v < -1 {
+			warn("client_timeouts."+f.name, "client_timeouts.%s=%d is invalid, using the default", f.name, *f.v)
+			*f.v = 0
+		}
+		if *f.v == 0 {
+			*f.v = f.def
+		}
+	}
+	if t.ReadHeaderMs == -1 {
+		warn("client_timeouts.read_header_ms", "client_timeouts.read_header_ms is off; slow clients can hold connections open indefinitely")
+	}
+}
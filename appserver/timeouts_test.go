@@ -0,0 +1,65 @@
+package appserver
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClientTimeouts(t *testing.T) {
+	cfg := &AppServerConfig{ClientTimeouts: ClientTimeouts{ReadHeaderMs: 100, WriteMs: 100, IdleMs: -5}}
+	validateClientTimeouts(cfg, logConfig.warn)
+	if got := cfg.ClientTimeouts; got.ReadMs != 60_000 || got.IdleMs != 120_000 || got.WriteMs != 100 {
+		t.Fatalf("validated timeouts = %+v", got)
+	}
+
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/stream" {
+			streaming(w)
+		}
+		time.Sleep(300 * time.Millisecond) // past write_ms
+		io.WriteString(w, "done")
+	}))
+	cfg.ClientTimeouts.apply(ts.Config)
+	ts.Start()
+	defer ts.Close()
+
+	// A client that never finishes its headers is cut off.
+	conn, err := net.Dial("tcp", ts.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	io.WriteString(conn, "GET / HTTP/1.1\r\nHost: x\r\n")
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.ReadAll(conn); err != nil {
+		t.Fatalf("slow client not disconnected: %v", err)
+	}
+
+	get := func(path string) (string, error) {
+		conn, err := net.Dial("tcp", ts.Listener.Addr().String())
+		if err != nil {
+			return "", err
+		}
+		defer conn.Close()
+		io.WriteString(conn, "GET "+path+" HTTP/1.1\r\nHost: x\r\n\r\n")
+		resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		b, err := io.ReadAll(resp.Body)
+		return string(b), err
+	}
+	if body, err := get("/slow"); err == nil && strings.Contains(body, "done") {
+		t.Errorf("response past write_ms delivered: %q", body)
+	}
+	if body, err := get("/stream"); err != nil || body != "done" {
+		t.Errorf("streaming response: %q, %v", body, err)
+	}
+}
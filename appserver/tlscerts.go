@@ -0,0 +1,107 @@
+package appserver
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// TLSCertificate is one cert/key pair offered by SNI.
+type TLSCertificate struct {
+	CertFile string `json:"cert_file"`
+	KeyFile  string `json:"key_file"`
+}
+
+// loadCertificates loads the default pair, then Certificates, then the
+// pairs found in CertDir. The TLS stack offers each client the first one
+// valid for the name it asked for (SNI), and the first one overall to
+// clients that name none or an unknown host.
+func (c TLSConfig) loadCertificates(abs func(string) string) ([]tls.Certificate, error) {
+	pairs := c.Certificates
+	if c.CertFile != "" || c.KeyFile != "" {
+		pairs = append([]TLSCertificate{{CertFile: c.CertFile, KeyFile: c.KeyFile}}, pairs...)
+	}
+	if c.CertDir != "" {
+		found, err := certDirPairs(abs(c.CertDir))
+		if err != nil {
+			return nil, fmt.Errorf("tls: cert_dir: %w", err)
+		}
+		pairs = append(pairs, found...)
+	}
+	if len(pairs) == 0 {
+		return nil, errors.New("tls: no certificates configured")
+	}
+
+	certs := make([]tls.Certificate, 0, len(pairs))
+	for _, p := range pairs {
+		cert, err := tls.LoadX509KeyPair(abs(p.CertFile), abs(p.KeyFile))
+		if err != nil {
+			return nil, fmt.Errorf("tls: %s: %w", p.CertFile, err)
+		}
+		certs = append(certs, cert)
+	}
+	return certs, nil
+}
+
+// certDirPairs finds cert/key pairs in dir: name.crt or name.pem next to
+// name.key, and certbot-style name/fullchain.pem + name/privkey.pem.
+func certDirPairs(dir string) ([]TLSCertificate, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	exists := func(path string) bool {
+		_, err := os.Stat(path)
+		return err == nil
+	}
+
+	var pairs []TLSCertificate
+	for _, e := range entries {
+		path := filepath.Join(dir, e.Name())
+		if e.IsDir() {
+			cert, key := filepath.Join(path, "fullchain.pem"), filepath.Join(path, "privkey.pem")
+			if exists(cert) && exists(key) {
+				pairs = append(pairs, TLSCertificate{CertFile: cert, KeyFile: key})
+			}
+			continue
+		}
+		base, ok := strings.CutSuffix(path, ".key")
+		if !ok {
+			continue
+		}
+		for _, ext := range []string{".crt", ".pem"} {
+			if exists(base + ext) {
+				pairs = append(pairs, TLSCertificate{CertFile: base + ext, KeyFile: path})
+				break
+			}
+		}
+	}
+	return pairs, nil
+}
+
+// warnUncoveredHosts logs virtual hosts no certificate is valid for;
+// clients asking for them get the default certificate and a name mismatch.
+func warnUncoveredHosts(certs []tls.Certificate, vhosts *vhostRouter) {
+	for _, app := range vhosts.apps {
+		for _, host := range app.hosts {
+			name := host
+			if rest, ok := strings.CutPrefix(host, "*."); ok {
+				name = "sni-check." + rest
+			}
+			covered := false
+			for _, cert := range certs {
+				if cert.Leaf != nil && cert.Leaf.VerifyHostname(name) == nil {
+					covered = true
+					break
+				}
+			}
+			if !covered {
+				log.Printf("[tls] no certificate covers %s (app %q); it will get the default certificate", host, app.name)
+			}
+		}
+	}
+}
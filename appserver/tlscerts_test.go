@@ -0,0 +1,70 @@
+package appserver
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTLSCertificatesBySNI(t *testing.T) {
+	dir := t.TempDir()
+	ca := issueCert(t, &x509.Certificate{Subject: pkix.Name{CommonName: "test CA"}, IsCA: true, BasicConstraintsValid: true, KeyUsage: x509.KeyUsageCertSign}, nil)
+	writeLeaf := func(certPath, keyPath string, names ...string) {
+		c := issueCert(t, &x509.Certificate{Subject: pkix.Name{CommonName: names[0]}, DNSNames: names, ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}}, &ca)
+		key, err := x509.MarshalECPrivateKey(c.key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		writePEM(t, certPath, "CERTIFICATE", c.cert.Raw)
+		writePEM(t, keyPath, "EC PRIVATE KEY", key)
+	}
+
+	writeLeaf(filepath.Join(dir, "default.pem"), filepath.Join(dir, "default.key"), "example.com")
+	writeLeaf(filepath.Join(dir, "shop.pem"), filepath.Join(dir, "shop.key"), "shop.example.net")
+	certs := filepath.Join(dir, "certs")
+	if err := os.MkdirAll(filepath.Join(certs, "blog.example.org"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	writeLeaf(filepath.Join(certs, "api.crt"), filepath.Join(certs, "api.key"), "*.api.example.io")
+	writeLeaf(filepath.Join(certs, "blog.example.org", "fullchain.pem"), filepath.Join(certs, "blog.example.org", "privkey.pem"), "blog.example.org")
+
+	cfg, err := TLSConfig{
+		CertFile:     "default.pem",
+		KeyFile:      "default.key",
+		Certificates: []TLSCertificate{{CertFile: "shop.pem", KeyFile: "shop.key"}},
+		CertDir:      "certs",
+	}.serverTLS(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Certificates) != 4 {
+		t.Fatalf("loaded %d certificates, want 4", len(cfg.Certificates))
+	}
+
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	ts.TLS = cfg
+	ts.StartTLS()
+	defer ts.Close()
+
+	for sni, want := range map[string]string{
+		"shop.example.net":  "shop.example.net",
+		"v2.api.example.io": "*.api.example.io",
+		"blog.example.org":  "blog.example.org",
+		"unknown.test":      "example.com",
+	} {
+		conn, err := tls.Dial("tcp", ts.Listener.Addr().String(), &tls.Config{ServerName: sni, InsecureSkipVerify: true})
+		if err != nil {
+			t.Fatalf("%s: %v", sni, err)
+		}
+		got := conn.ConnectionState().PeerCertificates[0].Subject.CommonName
+		conn.Close()
+		if got != want {
+			t.Errorf("SNI %s got certificate %q, want %q", sni, got, want)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package appserver
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// ticketKeysKept is how many session ticket keys stay valid: the current
+// one encrypts, the older ones still decrypt tickets they issued.
+const ticketKeysKept = 3
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+func parseTLSVersion(v string) (uint16, error) {
+	if id, ok := tlsVersions[strings.TrimPrefix(strings.ToLower(v), "tls")]; ok {
+		return id, nil
+	}
+	return 0, fmt.Errorf("unknown TLS version %q (want 1.2 or 1.3)", v)
+}
+
+// parseCipherSuite accepts the IANA names crypto/tls uses, e.g.
+// "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256".
+func parseCipherSuite(name string) (id uint16, insecure bool, err error) {
+	for _, cs := range tls.CipherSuites() {
+		if strings.EqualFold(cs.Name, name) {
+			return cs.ID, false, nil
+		}
+	}
+	for _, cs := range tls.InsecureCipherSuites() {
+		if strings.EqualFold(cs.Name, name) {
+			return cs.ID, true, nil
+		}
+	}
+	return 0, false, fmt.Errorf("unknown cipher suite %q", name)
+}
+
+var tlsCurves = map[string]tls.CurveID{
+	"x25519":         tls.X25519,
+	"x25519mlkem768": tls.X25519MLKEM768,
+	"p256":           tls.CurveP256,
+	"p384":           tls.CurveP384,
+	"p521":           tls.CurveP521,
+}
+
+// parseCurve accepts "X25519", "P256" or crypto/tls's "CurveP256".
+func parseCurve(name string) (tls.CurveID, error) {
+	if id, ok := tlsCurves[strings.TrimPrefix(strings.ToLower(name), "curve")]; ok {
+		return id, nil
+	}
+	return 0, fmt.Errorf("unknown curve %q", name)
+}
+
+// applyPolicy sets the versions, cipher suites, curves and session ticket
+// settings from c on cfg.
+func (c TLSConfig) applyPolicy(cfg *tls.Config) error {
+	if c.MinVersion != "" {
+		v, err := parseTLSVersion(c.MinVersion)
+		if err != nil {
+			return fmt.Errorf("tls: min_version: %w", err)
+		}
+		cfg.MinVersion = v
+	}
+	if c.MaxVersion != "" {
+		v, err := parseTLSVersion(c.MaxVersion)
+		if err != nil {
+			return fmt.Errorf("tls: max_version: %w", err)
+		}
+		cfg.MaxVersion = v
+	}
+	for _, name := range c.CipherSuites {
+		id, _, err := parseCipherSuite(name)
+		if err != nil {
+			return fmt.Errorf("tls: %w", err)
+		}
+		cfg.CipherSuites = append(cfg.CipherSuites, id)
+	}
+	for _, name := range c.CurvePreferences {
+		id, err := parseCurve(name)
+		if err != nil {
+			return fmt.Errorf("tls: %w", err)
+		}
+		cfg.CurvePreferences = append(cfg.CurvePreferences, id)
+	}
+	cfg.SessionTicketsDisabled = c.DisableSessionTickets
+	return nil
+}
+
+// rotateTicketKeys replaces cfg's session ticket key every interval,
+// keeping the last few so recent tickets still resume. It returns a func
+// that stops the rotation.
+func rotateTicketKeys(cfg *tls.Config, every time.Duration) func() {
+	var keys [][32]byte
+	rotate := func() {
+		var k [32]byte
+		if _, err := rand.Read(k[:]); err != nil {
+			log.Printf("[tls] session ticket key rotation: %v", err)
+			return
+		}
+		keys = append([][32]byte{k}, keys...)
+		if len(keys) > ticketKeysKept {
+			keys = keys[:ticketKeysKept]
+		}
+		cfg.SetSessionTicketKeys(keys)
+	}
+	rotate()
+
+	done := make(chan struct{})
+	go func() {
+		t := time.NewTicker(every)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				rotate()
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+// validateTLSPolicy reports policy settings crypto/tls won't accept.
+func validateTLSPolicy(t *TLSConfig, warn configWarnFunc) {
+	if t.MinVersion != "" {
+		if v, err := parseTLSVersion(t.MinVersion); err != nil {
+			warn("tls.min_version", "tls.min_version: %v", err)
+		} else if v < tls.VersionTLS12 {
+			warn("tls.min_version", "tls.min_version=%s is deprecated and fails most compliance scans", t.MinVersion)
+		}
+	}
+	if t.MaxVersion != "" {
+		if _, err := parseTLSVersion(t.MaxVersion); err != nil {
+			warn("tls.max_version", "tls.max_version: %v", err)
+		}
+	}
+	for i, name := range t.CipherSuites {
+		if _, insecure, err := parseCipherSuite(name); err != nil {
+			warn(fmt.Sprintf("tls.cipher_suites[%d]", i), "tls.cipher_suites[%d]: %v", i, err)
+		} else if insecure {
+			warn(fmt.Sprintf("tls.cipher_suites[%d]", i), "tls.cipher_suites[%d]=%s is insecure", i, name)
+		}
+	}
+	for i, name := range t.CurvePreferences {
+		if _, err := parseCurve(name); err != nil {
+			warn(fmt.Sprintf("tls.curve_preferences[%d]", i), "tls.curve_preferences[%d]: %v", i, err)
+		}
+	}
+	if t.SessionTicketRotationMinutes < 0 {
+		warn("tls.session_ticket_rotation_minutes", "tls.session_ticket_rotation_minutes=%d is invalid, using crypto/tls's own rotation", t.SessionTicketRotationMinutes)
+		t.SessionTicketRotationMinutes = 0
+	}
+}
@@ -0,0 +1,70 @@
+package appserver
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestTLSPolicy(t *testing.T) {
+	dir := t.TempDir()
+	leaf := issueCert(t, &x509.Certificate{Subject: pkix.Name{CommonName: "example.com"}, DNSNames: []string{"example.com"}}, nil)
+	key, err := x509.MarshalECPrivateKey(leaf.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePEM(t, filepath.Join(dir, "cert.pem"), "CERTIFICATE", leaf.cert.Raw)
+	writePEM(t, filepath.Join(dir, "cert.key"), "EC PRIVATE KEY", key)
+	base := TLSConfig{CertFile: "cert.pem", KeyFile: "cert.key"}
+
+	dial := func(policy TLSConfig, client *tls.Config) (tls.ConnectionState, error) {
+		cfg, err := policy.serverTLS(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ts := httptest.NewUnstartedServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+		ts.TLS = cfg
+		ts.StartTLS()
+		defer ts.Close()
+		client.InsecureSkipVerify = true
+		conn, err := tls.Dial("tcp", ts.Listener.Addr().String(), client)
+		if err != nil {
+			return tls.ConnectionState{}, err
+		}
+		defer conn.Close()
+		return conn.ConnectionState(), nil
+	}
+
+	p := base
+	p.MinVersion = "1.3"
+	if _, err := dial(p, &tls.Config{MaxVersion: tls.VersionTLS12}); err == nil {
+		t.Fatal("min_version 1.3 accepted a TLS 1.2 client")
+	}
+
+	p = base
+	p.MaxVersion = "TLS1.2"
+	p.CipherSuites = []string{"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384"}
+	p.CurvePreferences = []string{"CurveP384"}
+	state, err := dial(p, &tls.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Version != tls.VersionTLS12 || state.CipherSuite != tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384 || state.CurveID != tls.CurveP384 {
+		t.Fatalf("negotiated version %x suite %s curve %s", state.Version, tls.CipherSuiteName(state.CipherSuite), state.CurveID)
+	}
+
+	for _, bad := range []TLSConfig{
+		{MinVersion: "1.4"},
+		{CipherSuites: []string{"TLS_NOPE"}},
+		{CurvePreferences: []string{"P192"}},
+	} {
+		bad.CertFile, bad.KeyFile = base.CertFile, base.KeyFile
+		if _, err := bad.serverTLS(dir); err == nil {
+			t.Errorf("%+v: want an error", bad)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package appserver
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+
+	"go-php/server"
+)
+
+// UploadConfig makes the server decode multipart/form-data requests itself
+// and hand PHP the fields and saved files (RequestPayload.Form and Files),
+// for CLI workers, where PHP never fills $_POST and $_FILES. Leave it off
+// for FastCGI pools: php-fpm parses the raw body.
+type UploadConfig struct {
+	Parse bool   `json:"parse"`
+	Dir   string `json:"dir"` // where uploads are saved; os.TempDir() if empty
+}
+
+// PHP's UPLOAD_ERR_* codes used in UploadedFile.Error.
+const (
+	uploadErrOK     = 0
+	uploadErrNoFile = 4
+)
+
+// maxFormValue caps a single non-file form field, like PHP's post_max_size
+// does for the whole body.
+const maxFormValue = 10 << 20
+
+// errFormValueTooLarge reports a form field over maxFormValue.
+var errFormValueTooLarge = errors.New("form field larger than 10 MiB")
+
+// parseUploads decodes a multipart/form-data body into payload.Form and
+// payload.Files, the way PHP fills $_POST and $_FILES before a script runs.
+// Each file is saved under dir (the system temp dir if empty) and removed
+// once the request is finished. The raw body is dropped, as php://input is
+// empty for multipart requests. Other content types are left alone.
+func parseUploads(r *http.Request, payload *server.RequestPayload, dir string) error {
+	mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/form-data" {
+		return nil
+	}
+
+	var files []server.UploadedFile
+	cleanup := func() {
+		for _, f := range files {
+			if f.TmpName != "" {
+				_ = os.Remove(f.TmpName)
+			}
+		}
+	}
+
+	form := map[string][]string{}
+	mr := multipart.NewReader(bytes.NewReader(payload.Body), params["boundary"])
+	for {
+		part, err := mr.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			cleanup()
+			return err
+		}
+
+		name := part.FormName()
+		if name == "" {
+			continue
+		}
+		if _, dparams, _ := mime.ParseMediaType(part.Header.Get("Content-Disposition")); !hasKey(dparams, "filename") {
+			value, err := io.ReadAll(io.LimitReader(part, maxFormValue+1))
+			if err == nil && len(value) > maxFormValue {
+				err = errFormValueTooLarge
+			}
+			if err != nil {
+				cleanup()
+				return err
+			}
+			form[name] = append(form[name], string(value))
+			continue
+		}
+
+		f := server.UploadedFile{Field: name, Name: part.FileName(), Type: part.Header.Get("Content-Type")}
+		if f.Name == "" {
+			// a file input left empty
+			f.Error = uploadErrNoFile
+			files = append(files, f)
+			continue
+		}
+		if f.TmpName, f.Size, err = saveUpload(dir, part); err != nil {
+			cleanup()
+			return err
+		}
+		files = append(files, f)
+	}
+
+	payload.Form, payload.Files, payload.Body = form, files, nil
+	if len(files) > 0 {
+		context.AfterFunc(r.Context(), cleanup)
+	}
+	return nil
+}
+
+// uploadError answers a request whose body parseUploads refused.
+func uploadError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errFormValueTooLarge) {
+		http.Error(w, "Request Entity Too Large: "+err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	http.Error(w, "Bad Request: "+err.Error(), http.StatusBadRequest)
+}
+
+func hasKey(m map[string]string, key string) bool {
+	_, ok := m[key]
+	return ok
+}
+
+func saveUpload(dir string, src io.Reader) (string, int64, error) {
+	tmp, err := os.CreateTemp(dir, "php-upload-*")
+	if err != nil {
+		return "", 0, err
+	}
+	n, err := io.Copy(tmp, src)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return "", 0, err
+	}
+	return tmp.Name(), n, nil
+}
@@ -0,0 +1,105 @@
+package appserver
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseUploads(t *testing.T) {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	_ = mw.WriteField("title", "Holiday")
+	_ = mw.WriteField("tags[]", "sea")
+	_ = mw.WriteField("tags[]", "sun")
+	fw, _ := mw.CreateFormFile("photo", "beach.jpg")
+	_, _ = fw.Write([]byte("jpeg bytes"))
+	_, _ = mw.CreateFormFile("extra", "") // file input left empty
+	_ = mw.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r := httptest.NewRequest("POST", "/upload", bytes.NewReader(body.Bytes())).WithContext(ctx)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	payload := BuildPayload(r)
+
+	dir := t.TempDir()
+	if err := parseUploads(r, payload, dir); err != nil {
+		t.Fatalf("parseUploads: %v", err)
+	}
+
+	if string(payload.Body) != "" {
+		t.Errorf("raw body kept for a parsed multipart request")
+	}
+	if got := payload.Form["tags[]"]; len(got) != 2 || got[1] != "sun" {
+		t.Errorf("tags[] = %v", got)
+	}
+	if len(payload.Files) != 2 {
+		t.Fatalf("files = %+v", payload.Files)
+	}
+	photo := payload.Files[0]
+	if photo.Field != "photo" || photo.Name != "beach.jpg" || photo.Size != 10 || photo.Error != uploadErrOK {
+		t.Errorf("photo = %+v", photo)
+	}
+	if data, err := os.ReadFile(photo.TmpName); err != nil || string(data) != "jpeg bytes" {
+		t.Errorf("saved upload = %q, %v", data, err)
+	}
+	if extra := payload.Files[1]; extra.Error != uploadErrNoFile || extra.TmpName != "" {
+		t.Errorf("empty file input = %+v", extra)
+	}
+
+	// The request finishing removes the saved files.
+	cancel()
+	waitUntil(t, func() bool {
+		_, err := os.Stat(photo.TmpName)
+		return os.IsNotExist(err)
+	})
+}
+
+func TestParseUploadsIgnoresOtherBodies(t *testing.T) {
+	r := httptest.NewRequest("POST", "/", bytes.NewReader([]byte("a=1&b=2")))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	payload := BuildPayload(r)
+	if err := parseUploads(r, payload, ""); err != nil {
+		t.Fatal(err)
+	}
+	if string(payload.Body) != "a=1&b=2" || payload.Form != nil {
+		t.Fatalf("urlencoded body was touched: %+v", payload)
+	}
+}
+
+func TestParseUploadsRefusesHugeFields(t *testing.T) {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	_ = mw.WriteField("big", strings.Repeat("x", maxFormValue+1))
+	_ = mw.Close()
+
+	r := httptest.NewRequest("POST", "/upload", bytes.NewReader(body.Bytes()))
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	err := parseUploads(r, BuildPayload(r), "")
+	if !errors.Is(err, errFormValueTooLarge) {
+		t.Fatalf("parseUploads = %v, want errFormValueTooLarge", err)
+	}
+	rr := httptest.NewRecorder()
+	uploadError(rr, err)
+	if rr.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("status = %d, want 413", rr.Code)
+	}
+}
+
+func waitUntil(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
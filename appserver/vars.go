@@ -0,0 +1,117 @@
+package appserver
+
+import (
+	"expvar"
+	"runtime"
+	"sync"
+	"time"
+
+	"go-php/server"
+)
+
+// runtimeVars is the "runtime" expvar: the Go runtime figures worth
+// graphing, without the full memstats dump.
+type runtimeVars struct {
+	Goroutines     int     `json:"goroutines"`
+	GOMAXPROCS     int     `json:"gomaxprocs"`
+	HeapAllocBytes uint64  `json:"heap_alloc_bytes"`
+	HeapInuseBytes uint64  `json:"heap_inuse_bytes"`
+	HeapSysBytes   uint64  `json:"heap_sys_bytes"`
+	HeapObjects    uint64  `json:"heap_objects"`
+	NumGC          uint32  `json:"num_gc"`
+	GCPauseTotalNs uint64  `json:"gc_pause_total_ns"`
+	GCPauseLastNs  uint64  `json:"gc_pause_last_ns"`
+	GCPauseMaxNs   uint64  `json:"gc_pause_max_ns"` // over the last 256 collections
+	GCCPUFraction  float64 `json:"gc_cpu_fraction"`
+	UptimeSeconds  float64 `json:"uptime_seconds"`
+}
+
+func readRuntimeVars(started time.Time) runtimeVars {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+
+	v := runtimeVars{
+		Goroutines:     runtime.NumGoroutine(),
+		GOMAXPROCS:     runtime.GOMAXPROCS(0),
+		HeapAllocBytes: ms.HeapAlloc,
+		HeapInuseBytes: ms.HeapInuse,
+		HeapSysBytes:   ms.HeapSys,
+		HeapObjects:    ms.HeapObjects,
+		NumGC:          ms.NumGC,
+		GCPauseTotalNs: ms.PauseTotalNs,
+		GCCPUFraction:  ms.GCCPUFraction,
+		UptimeSeconds:  time.Since(started).Seconds(),
+	}
+	if ms.NumGC > 0 {
+		v.GCPauseLastNs = ms.PauseNs[(ms.NumGC+255)%256]
+	}
+	for _, p := range ms.PauseNs {
+		v.GCPauseMaxNs = max(v.GCPauseMaxNs, p)
+	}
+	return v
+}
+
+// poolVars returns app → pool → gauges.
+func poolVars(vhosts *vhostRouter) map[string]map[string]server.PoolStats {
+	out := make(map[string]map[string]server.PoolStats)
+	for _, app := range vhosts.all() {
+		out[app.name] = app.srv.Health().Pools
+	}
+	return out
+}
+
+// publishVars registers the server's expvars: runtime, pools, hubs and
+// request totals, next to expvar's own cmdline and memstats. expvar is
+// process-wide, so with several Servers in one process the vars describe
+// the most recently created one.
+func publishVars(vhosts *vhostRouter, metrics *Metrics, wsHub *server.WSHub, sseHub *server.SSEHub) {
+	started := time.Now()
+	varsMu.Lock()
+	current = expvarSource{started, vhosts, metrics, wsHub, sseHub}
+	varsMu.Unlock()
+
+	publishFunc("runtime", func() any { return readRuntimeVars(source().started) })
+	publishFunc("pools", func() any { return poolVars(source().vhosts) })
+	publishFunc("hubs", func() any {
+		src := source()
+		return map[string]server.HubStats{"ws": src.wsHub.Stats(), "sse": src.sseHub.Stats()}
+	})
+	publishFunc("requests", func() any {
+		snap := source().metrics.Snapshot()
+		return map[string]uint64{
+			"total":     snap.TotalRequests,
+			"errors":    snap.TotalErrors,
+			"in_flight": snap.InFlight,
+			"static":    snap.Static.Requests,
+			"panics":    snap.Panics,
+		}
+	})
+}
+
+// expvarSource is what the published expvars read from.
+type expvarSource struct {
+	started time.Time
+	vhosts  *vhostRouter
+	metrics *Metrics
+	wsHub   *server.WSHub
+	sseHub  *server.SSEHub
+}
+
+var (
+	varsMu  sync.Mutex
+	current expvarSource
+)
+
+func source() expvarSource {
+	varsMu.Lock()
+	defer varsMu.Unlock()
+	return current
+}
+
+// publishFunc is expvar.Publish, skipping names that already exist
+// (expvar panics on duplicates).
+func publishFunc(name string, f func() any) {
+	if expvar.Get(name) == nil {
+		expvar.Publish(name, expvar.Func(f))
+	}
+}
@@ -0,0 +1,53 @@
+package appserver
+
+import (
+	"encoding/json"
+	"expvar"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go-php/server"
+)
+
+func TestPublishVars(t *testing.T) {
+	srv := newCanaryTestServer(t)
+	vhosts := &vhostRouter{def: &vhost{name: "default", srv: srv}}
+	wsHub := server.NewWSHub()
+	sseHub := server.NewSSEHub()
+
+	c := wsHub.Subscribe("room")
+	defer wsHub.Unsubscribe("room", c)
+	wsHub.Publish("room", "ping", nil)
+
+	metrics := NewMetrics()
+	metrics.StartRequest("/")
+	publishVars(vhosts, metrics, wsHub, sseHub)
+
+	rr := httptest.NewRecorder()
+	expvar.Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/__baremetal/vars", nil))
+
+	var vars struct {
+		Runtime  runtimeVars                            `json:"runtime"`
+		Pools    map[string]map[string]server.PoolStats `json:"pools"`
+		Hubs     map[string]server.HubStats             `json:"hubs"`
+		Requests map[string]uint64                      `json:"requests"`
+		Memstats map[string]any                         `json:"memstats"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &vars); err != nil {
+		t.Fatalf("decode vars: %v\n%s", err, rr.Body.String())
+	}
+
+	if vars.Runtime.Goroutines == 0 || vars.Memstats == nil {
+		t.Fatalf("missing runtime stats: %+v", vars.Runtime)
+	}
+	if _, ok := vars.Pools["default"]; !ok {
+		t.Fatalf("missing default app pools: %+v", vars.Pools)
+	}
+	if ws := vars.Hubs["ws"]; ws.Clients != 1 || ws.Published != 1 || ws.Delivered != 1 {
+		t.Fatalf("unexpected ws hub stats: %+v", ws)
+	}
+	if vars.Requests["in_flight"] != 1 {
+		t.Fatalf("requests = %+v, want 1 in flight", vars.Requests)
+	}
+}
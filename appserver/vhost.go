@@ -0,0 +1,403 @@
+package appserver
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"go-php/server"
+)
+
+// AppConfig describes one PHP application served by this process. Requests
+// whose Host matches one of Hosts (exact, or "*.example.com" for any
+// subdomain) and whose path starts with Prefix are served from Root using
+// the app's own workers and static rules. Unset worker settings inherit the
+// top-level config.
+type AppConfig struct {
+	Name         string              `json:"name"`
+	Hosts        []string            `json:"hosts"`
+	Prefix       string              `json:"prefix"`
+	Root         string              `json:"root"`
+	WorkerScript string              `json:"worker_script"`
+	FastWorkers  int                 `json:"fast_workers"`
+	SlowWorkers  int                 `json:"slow_workers"`
+	Pools        []PoolConfig        `json:"pools"`
+	PoolRoutes   []PoolRoute         `json:"pool_routes"`
+	Canary       *server.Canary      `json:"canary"`
+	Shadow       *server.Shadow      `json:"shadow"`
+	Retry        *server.RetryPolicy `json:"retry"`      // inherited when unset
+	LoadShed     *server.LoadShed    `json:"load_shed"`  // inherited when unset
+	Priorities   *server.Priorities  `json:"priorities"` // inherited when unset
+	Proxy        []ProxyRule         `json:"proxy"`
+	Static       []StaticRule        `json:"static"`
+}
+
+// vhost is a running application: its resolved root, static rules and
+// worker pools.
+type vhost struct {
+	name   string
+	hosts  []string
+	prefix string
+	root   string
+	static atomic.Pointer[[]StaticRule] // replaced by PATCH /__baremetal/config
+	proxy  proxyRouter
+	gzip   *gzipCache
+	esi    *esiProcessor
+	srv    *server.Server
+}
+
+func (v *vhost) staticRules() []StaticRule {
+	if rules := v.static.Load(); rules != nil {
+		return *rules
+	}
+	return nil
+}
+
+func (v *vhost) setStatic(rules []StaticRule) {
+	v.static.Store(&rules)
+}
+
+// vhostRouter picks the application for a request; def serves everything
+// no app claims.
+type vhostRouter struct {
+	apps []*vhost
+	def  *vhost
+}
+
+// match returns the app whose host pattern matches and whose prefix is the
+// longest match for the request path. Prefixes match whole segments, so
+// "/shop" doesn't claim "/shopping".
+func (vr *vhostRouter) match(r *http.Request) *vhost {
+	host := strings.ToLower(r.Host)
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	var best *vhost
+	for _, app := range vr.apps {
+		if !hostMatches(app.hosts, host) || !underPrefix(r.URL.Path, app.prefix) {
+			continue
+		}
+		if best == nil || len(app.prefix) > len(best.prefix) {
+			best = app
+		}
+	}
+
+	if best == nil {
+		return vr.def
+	}
+	return best
+}
+
+// byName returns the app with the given name ("" or unknown → default app).
+func (vr *vhostRouter) byName(name string) *vhost {
+	for _, app := range vr.apps {
+		if app.name == name {
+			return app
+		}
+	}
+	return vr.def
+}
+
+// scoreboardFile returns where v's scoreboard goes when the default app's
+// goes to path: "storage/scoreboard.txt" becomes
+// "storage/scoreboard.blog.txt" for the blog app.
+func (v *vhost) scoreboardFile(path string, def bool) string {
+	if def {
+		return path
+	}
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "." + v.name + ext
+}
+
+// all returns the default app followed by every virtual host.
+func (vr *vhostRouter) all() []*vhost {
+	return append([]*vhost{vr.def}, vr.apps...)
+}
+
+// retryStats sums the retry counters of every app.
+func (vr *vhostRouter) retryStats() server.RetryStats {
+	var total server.RetryStats
+	for _, app := range vr.all() {
+		st := app.srv.RetryStats()
+		total.Retries += st.Retries
+		total.Recovered += st.Recovered
+		total.Exhausted += st.Exhausted
+	}
+	return total
+}
+
+// resetRetryStats zeroes the retry counters of every app.
+func (vr *vhostRouter) resetRetryStats() {
+	for _, app := range vr.all() {
+		app.srv.ResetRetryStats()
+	}
+}
+
+// anyDegraded reports whether any app is running without PHP workers.
+func (vr *vhostRouter) anyDegraded() bool {
+	for _, app := range vr.all() {
+		if app.srv.Degraded() {
+			return true
+		}
+	}
+	return false
+}
+
+func hostMatches(patterns []string, host string) bool {
+	for _, p := range patterns {
+		if p == host {
+			return true
+		}
+		if strings.HasPrefix(p, "*.") && strings.HasSuffix(host, p[1:]) {
+			return true
+		}
+	}
+	return false
+}
+
+// appConfig derives a full AppServerConfig for the app, inheriting anything
+// it leaves unset from the top-level config.
+func (a AppConfig) appConfig(parent *AppServerConfig) *AppServerConfig {
+	cfg := *parent
+	cfg.Static = a.Static
+	cfg.Pools = a.Pools
+	cfg.PoolRoutes = a.PoolRoutes
+	cfg.Canary = a.Canary
+	cfg.Shadow = a.Shadow
+	cfg.Proxy = a.Proxy
+	if a.Retry != nil {
+		cfg.Retry = a.Retry
+	}
+	if a.LoadShed != nil {
+		cfg.LoadShed = a.LoadShed
+	}
+	if a.Priorities != nil {
+		cfg.Priorities = a.Priorities
+	}
+
+	if a.FastWorkers > 0 {
+		cfg.FastWorkers = a.FastWorkers
+	}
+	if a.SlowWorkers > 0 {
+		cfg.SlowWorkers = a.SlowWorkers
+	}
+	return &cfg
+}
+
+// resolveRoot makes the app root absolute relative to the main project root.
+func (a AppConfig) resolveRoot(projectRoot string) string {
+	if filepath.IsAbs(a.Root) {
+		return a.Root
+	}
+	return filepath.Join(projectRoot, a.Root)
+}
+
+// newAppServer starts the pools for one app, falling back to degraded mode
+// if allowed.
+func newAppServer(name string, cfg *AppServerConfig, root, workerScript string, scale float64, hooks *hookSet, auth *wsAuth) (*server.Server, error) {
+	slowCfg := server.SlowRequestConfig{
+		RoutePrefixes: cfg.SlowRoutes,
+		Methods:       cfg.SlowMethods,
+		BodyThreshold: cfg.SlowBodyThreshold,
+		Adaptive:      cfg.Adaptive,
+	}
+	pools := cfg.serverPools(auth)
+	for i := range pools {
+		pools[i].Workers = scaleWorkers(pools[i].Workers, scale)
+		switch {
+		case pools[i].ProjectRoot == "":
+			pools[i].ProjectRoot = root
+		case !filepath.IsAbs(pools[i].ProjectRoot):
+			pools[i].ProjectRoot = filepath.Join(root, pools[i].ProjectRoot)
+		}
+		if pools[i].WorkerScript == "" {
+			pools[i].WorkerScript = workerScript
+		}
+		pools[i].OnRestart = hooks.workerRestarted(name)
+	}
+	poolRoutes := cfg.serverPoolRoutes()
+
+	srv, err := server.NewServerWithPools(pools, poolRoutes, slowCfg)
+	if err != nil {
+		if !cfg.DegradedStart {
+			return nil, fmt.Errorf("failed to create server for app %q: %w", name, err)
+		}
+
+		// Keep static assets up while we retry spawning PHP in the background.
+		log.Printf("[degraded] failed to create server for app %q: %v; starting in degraded mode", name, err)
+		srv, err = server.NewDegradedServer(pools, poolRoutes, slowCfg, 30*time.Second)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create degraded server for app %q: %w", name, err)
+		}
+	}
+
+	srv.SetWorkerRSSEstimate(cfg.WorkerGuard.estimate())
+
+	if cfg.Canary != nil {
+		if err := srv.SetCanary(*cfg.Canary); err != nil {
+			log.Printf("[config] app %q: %v; canary disabled", name, err)
+		} else {
+			log.Printf(" [%s] canary: %d%% of pool %q → pool %q", name, cfg.Canary.Weight, cfg.Canary.Stable, cfg.Canary.Canary)
+		}
+	}
+
+	if cfg.Shadow != nil {
+		if err := srv.SetShadow(*cfg.Shadow); err != nil {
+			log.Printf("[config] app %q: %v; shadow traffic disabled", name, err)
+		} else {
+			log.Printf(" [%s] shadow: mirroring %d%% of traffic → pool %q", name, cfg.Shadow.Percent, cfg.Shadow.Pool)
+		}
+	}
+
+	if cfg.Retry != nil {
+		if err := srv.SetRetryPolicy(*cfg.Retry); err != nil {
+			log.Printf("[config] app %q: %v; retries disabled", name, err)
+		} else if cfg.Retry.MaxAttempts > 1 {
+			log.Printf(" [%s] retry: up to %d attempts for idempotent requests", name, cfg.Retry.MaxAttempts)
+		}
+	}
+
+	if cfg.LoadShed != nil {
+		if err := srv.SetLoadShed(*cfg.LoadShed); err != nil {
+			log.Printf("[config] app %q: %v; load shedding disabled", name, err)
+		}
+	}
+
+	if cfg.Chaos != nil && cfg.Chaos.Enabled() {
+		if err := srv.SetChaos(*cfg.Chaos); err != nil {
+			log.Printf("[config] app %q: %v; chaos disabled", name, err)
+		} else {
+			c := cfg.Chaos
+			log.Printf(" [%s] CHAOS: %d%% of requests +%dms, %d%% broken pipes, %d%% of stream chunks dropped",
+				name, c.LatencyPercent, c.LatencyMs, c.BrokenPipePercent, c.DropFramePercent)
+		}
+	}
+
+	if cfg.Priorities != nil {
+		if err := srv.SetPriorities(*cfg.Priorities); err != nil {
+			log.Printf("[config] app %q: %v; request priorities disabled", name, err)
+		}
+	}
+
+	for _, p := range pools {
+		log.Printf(" [%s] pool %q: %d workers in %s", name, p.Name, p.Workers, p.ProjectRoot)
+	}
+	for _, rt := range poolRoutes {
+		switch {
+		case rt.Header != "":
+			log.Printf(" [%s]   %s %v header %s=%q → pool %q", name, rt.Prefix, rt.Methods, rt.Header, rt.Value, rt.Pool)
+		case rt.Cookie != "":
+			log.Printf(" [%s]   %s %v cookie %s=%q → pool %q", name, rt.Prefix, rt.Methods, rt.Cookie, rt.Value, rt.Pool)
+		case rt.Query != "":
+			log.Printf(" [%s]   %s %v query %s=%q → pool %q", name, rt.Prefix, rt.Methods, rt.Query, rt.Value, rt.Pool)
+		default:
+			log.Printf(" [%s]   %s %v → pool %q", name, rt.Prefix, rt.Methods, rt.Pool)
+		}
+	}
+
+	return srv, nil
+}
+
+// buildVhosts starts the default app plus every configured virtual host.
+// If one can't start, the ones already running are drained.
+func buildVhosts(root string, cfg *AppServerConfig, hooks *hookSet, auth *wsAuth) (*vhostRouter, error) {
+	scale := workerScale(configuredWorkers(cfg), cfg.WorkerGuard)
+
+	srv, err := newAppServer("default", cfg, root, "", scale, hooks, auth)
+	if err != nil {
+		return nil, err
+	}
+	def := &vhost{
+		name:  "default",
+		root:  root,
+		proxy: newProxyRouter(cfg.Proxy),
+		gzip:  newGzipCache(cfg.StaticGzip, root),
+		srv:   srv,
+	}
+	def.setStatic(cfg.Static)
+	def.esi = newESIProcessor(cfg.ESI, def.srv.Dispatch)
+	vr := &vhostRouter{def: def}
+
+	for _, a := range cfg.Apps {
+		appRoot := a.resolveRoot(root)
+		appCfg := a.appConfig(cfg)
+
+		srv, err := newAppServer(a.Name, appCfg, appRoot, a.WorkerScript, scale, hooks, auth)
+		if err != nil {
+			for _, app := range vr.all() {
+				app.srv.DrainWorkers()
+			}
+			return nil, err
+		}
+		app := &vhost{
+			name:   a.Name,
+			hosts:  a.Hosts,
+			prefix: a.Prefix,
+			root:   appRoot,
+			proxy:  newProxyRouter(appCfg.Proxy),
+			gzip:   newGzipCache(appCfg.StaticGzip, appRoot),
+			srv:    srv,
+		}
+		app.setStatic(appCfg.Static)
+		app.esi = newESIProcessor(appCfg.ESI, app.srv.Dispatch)
+		vr.apps = append(vr.apps, app)
+	}
+
+	return vr, nil
+}
+
+// validateApps normalises virtual host definitions, dropping unusable ones.
+func validateApps(cfg *AppServerConfig, warn configWarnFunc) {
+	seen := make(map[string]bool, len(cfg.Apps))
+	apps := cfg.Apps[:0]
+
+	for i, a := range cfg.Apps {
+		if a.Name == "" || a.Name == "default" || seen[a.Name] {
+			warn(fmt.Sprintf("apps[%d].name", i), "apps[%d] needs a unique name other than \"default\", ignoring it", i)
+			continue
+		}
+		if len(a.Hosts) == 0 {
+			warn(fmt.Sprintf("apps[%d].hosts", i), "apps[%d] (%s) has no hosts, ignoring it", i, a.Name)
+			continue
+		}
+		if a.Root == "" {
+			warn(fmt.Sprintf("apps[%d].root", i), "apps[%d] (%s) has no root, ignoring it", i, a.Name)
+			continue
+		}
+
+		for j, h := range a.Hosts {
+			a.Hosts[j] = strings.ToLower(strings.TrimSpace(h))
+		}
+		if !strings.HasPrefix(a.Prefix, "/") {
+			a.Prefix = "/" + a.Prefix
+		}
+		for j, rule := range a.Static {
+			if !strings.HasPrefix(rule.Prefix, "/") {
+				a.Static[j].Prefix = "/" + rule.Prefix
+			}
+		}
+
+		// Validate the app's pools and proxies the same way as the top-level
+		// ones, reporting problems under apps[i].
+		derived := a.appConfig(cfg)
+		appWarn := func(path, format string, args ...any) {
+			warn(fmt.Sprintf("apps[%d].%s", i, path), "apps[%d] (%s): "+format, append([]any{i, a.Name}, args...)...)
+		}
+		validatePools(derived, appWarn)
+		validateProxies(derived, appWarn)
+		a.Pools = derived.Pools
+		a.PoolRoutes = derived.PoolRoutes
+		a.Proxy = derived.Proxy
+
+		seen[a.Name] = true
+		apps = append(apps, a)
+	}
+
+	cfg.Apps = apps
+}
@@ -0,0 +1,103 @@
+package appserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestVhostRouterMatch(t *testing.T) {
+	def := &vhost{name: "default"}
+	blog := &vhost{name: "blog", hosts: []string{"blog.example.com"}, prefix: "/"}
+	blogAdmin := &vhost{name: "blog-admin", hosts: []string{"blog.example.com"}, prefix: "/admin/"}
+	tenants := &vhost{name: "tenants", hosts: []string{"*.tenants.example.com"}, prefix: "/"}
+	shop := &vhost{name: "shop", hosts: []string{"blog.example.com"}, prefix: "/shop"}
+
+	vr := &vhostRouter{apps: []*vhost{blog, blogAdmin, tenants, shop}, def: def}
+
+	cases := []struct {
+		host, path string
+		want       *vhost
+	}{
+		{"blog.example.com", "/posts/1", blog},
+		{"BLOG.example.com:8443", "/posts/1", blog},
+		{"blog.example.com", "/admin/users", blogAdmin},
+		{"blog.example.com", "/shop", shop},
+		{"blog.example.com", "/shop/cart", shop},
+		{"blog.example.com", "/shopping", blog},
+		{"acme.tenants.example.com", "/", tenants},
+		{"tenants.example.com", "/", def},
+		{"other.example.com", "/", def},
+	}
+
+	for _, c := range cases {
+		r := httptest.NewRequest(http.MethodGet, c.path, nil)
+		r.Host = c.host
+		if got := vr.match(r); got != c.want {
+			t.Fatalf("match(%s%s) = %s, want %s", c.host, c.path, got.name, c.want.name)
+		}
+	}
+
+	if vr.byName("blog") != blog || vr.byName("") != def || vr.byName("nope") != def {
+		t.Fatalf("byName did not resolve apps correctly")
+	}
+	if len(vr.all()) != 5 || vr.all()[0] != def {
+		t.Fatalf("all() should list the default app first")
+	}
+	if got := blog.scoreboardFile("storage/scoreboard.txt", false); got != "storage/scoreboard.blog.txt" {
+		t.Fatalf("blog scoreboard file = %q", got)
+	}
+	if got := def.scoreboardFile("storage/scoreboard", true); got != "storage/scoreboard" {
+		t.Fatalf("default scoreboard file = %q", got)
+	}
+}
+
+func TestLoadConfigApps(t *testing.T) {
+	tmp := t.TempDir()
+	raw := `{
+		"fast_workers": 3,
+		"slow_workers": 1,
+		"apps": [
+			{"name": "blog", "hosts": ["Blog.Example.com "], "root": "apps/blog", "static": [{"prefix": "assets/", "dir": "public/assets"}]},
+			{"name": "shop", "hosts": ["shop.example.com"], "prefix": "store", "root": "/srv/shop", "fast_workers": 6,
+			 "pools": [{"name": "web", "workers": 2}], "pool_routes": [{"prefix": "/x", "pool": "nope"}]},
+			{"name": "blog", "hosts": ["dup.example.com"], "root": "x"},
+			{"name": "nohosts", "root": "x"},
+			{"name": "default", "hosts": ["d.example.com"], "root": "x"}
+		]
+	}`
+	if err := os.WriteFile(filepath.Join(tmp, "go_appserver.json"), []byte(raw), 0o644); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+
+	cfg := LoadConfig(tmp)
+	if len(cfg.Apps) != 2 {
+		t.Fatalf("expected 2 valid apps, got %+v", cfg.Apps)
+	}
+
+	blog, shop := cfg.Apps[0], cfg.Apps[1]
+	if blog.Hosts[0] != "blog.example.com" || blog.Prefix != "/" || blog.Static[0].Prefix != "/assets/" {
+		t.Fatalf("blog app not normalised: %+v", blog)
+	}
+	if got := blog.resolveRoot(tmp); got != filepath.Join(tmp, "apps/blog") {
+		t.Fatalf("relative root resolved to %q", got)
+	}
+	if blog.appConfig(cfg).FastWorkers != 3 {
+		t.Fatalf("blog should inherit fast_workers")
+	}
+
+	if shop.Prefix != "/store" || shop.resolveRoot(tmp) != "/srv/shop" {
+		t.Fatalf("shop app not normalised: %+v", shop)
+	}
+	if shop.appConfig(cfg).FastWorkers != 6 {
+		t.Fatalf("shop should override fast_workers")
+	}
+	if len(shop.Pools) != 1 || shop.Pools[0].MaxRequestsPerWorker != cfg.MaxRequestsPerWorker {
+		t.Fatalf("shop pools not validated: %+v", shop.Pools)
+	}
+	if len(shop.PoolRoutes) != 0 {
+		t.Fatalf("route to unknown pool should be dropped: %+v", shop.PoolRoutes)
+	}
+}
@@ -0,0 +1,649 @@
+package appserver
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+
+	"go-php/server"
+)
+
+// WasmFilter runs a WebAssembly module on requests under Prefix before they
+// reach PHP, and on PHP's response headers on the way back. Modules speak a
+// subset of the proxy-wasm ABI (see README).
+type WasmFilter struct {
+	Prefix    string `json:"prefix"`
+	Module    string `json:"module"`     // .wasm file; relative paths resolve against the project root
+	Config    string `json:"config"`     // handed to the module as its plugin configuration
+	TimeoutMs int    `json:"timeout_ms"` // per callback; default 100
+}
+
+// proxy-wasm constants used by the supported calls.
+const (
+	wasmOK          = 0
+	wasmNotFound    = 1
+	wasmBadArgument = 2
+
+	wasmContinue = 0
+	wasmPause    = 1
+
+	wasmRequestHeaders  = 0
+	wasmResponseHeaders = 2
+
+	wasmPluginConfiguration = 7
+
+	wasmRootContext = 1
+	wasmHTTPContext = 2
+)
+
+// wasmFilters holds the compiled filters, in config order.
+type wasmFilters struct {
+	runtime wazero.Runtime
+	filters []*wasmFilter
+}
+
+type wasmFilter struct {
+	WasmFilter
+	name    string // module file name, for logs
+	module  wazero.CompiledModule
+	timeout time.Duration
+}
+
+// newWasmFilters compiles the filter modules. The modules are compiled once;
+// each request gets fresh instances, so filters can't leak state between
+// requests.
+func newWasmFilters(root string, rules []WasmFilter) (*wasmFilters, error) {
+	ctx := context.Background()
+	rt := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().WithCloseOnContextDone(true))
+	wf := &wasmFilters{runtime: rt}
+
+	if _, err := wasi_snapshot_preview1.Instantiate(ctx, rt); err != nil {
+		wf.close()
+		return nil, err
+	}
+	if err := instantiateWasmHost(ctx, rt); err != nil {
+		wf.close()
+		return nil, err
+	}
+
+	for _, rule := range rules {
+		path := rule.Module
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(root, path)
+		}
+		bin, err := os.ReadFile(path)
+		if err != nil {
+			wf.close()
+			return nil, fmt.Errorf("wasm filter %s: %w", rule.Prefix, err)
+		}
+		compiled, err := rt.CompileModule(ctx, bin)
+		if err != nil {
+			wf.close()
+			return nil, fmt.Errorf("wasm filter %s: compiling %s: %w", rule.Prefix, rule.Module, err)
+		}
+		timeout := time.Duration(rule.TimeoutMs) * time.Millisecond
+		if timeout <= 0 {
+			timeout = 100 * time.Millisecond
+		}
+		wf.filters = append(wf.filters, &wasmFilter{WasmFilter: rule, name: filepath.Base(rule.Module), module: compiled, timeout: timeout})
+		log.Printf("[wasm] %s filters %s", rule.Module, rule.Prefix)
+	}
+	return wf, nil
+}
+
+func (wf *wasmFilters) close() {
+	_ = wf.runtime.Close(context.Background())
+}
+
+// middleware runs the filters matching the request around the hand-off to
+// PHP: request headers in config order, response headers in reverse. A
+// filter that sends a local response stops the request there. Filters that
+// fail (trap, time out, can't load) fail closed with a 500. Streamed
+// responses are filtered just before their headers go out.
+func (wf *wasmFilters) middleware(next DispatchFunc) DispatchFunc {
+	return func(r *http.Request, req *server.RequestPayload) (*server.ResponsePayload, error) {
+		var active []*wasmInstance
+		defer func() {
+			for _, inst := range active {
+				inst.close()
+			}
+		}()
+
+		for _, f := range wf.filters {
+			if !strings.HasPrefix(r.URL.Path, f.Prefix) {
+				continue
+			}
+			call := &wasmCall{filter: f, r: r, req: req}
+			inst, err := wf.instantiate(f, call)
+			if err != nil {
+				return wasmFailed(f, req, err), nil
+			}
+			active = append(active, inst)
+
+			action, err := inst.headers(call, "proxy_on_request_headers", len(req.Headers))
+			if err != nil {
+				return wasmFailed(f, req, err), nil
+			}
+			if call.local != nil {
+				return call.local, nil
+			}
+			if action == wasmPause {
+				log.Printf("[wasm] %s paused %s without a local response, continuing", f.name, r.URL.Path)
+			}
+		}
+
+		if len(active) > 0 {
+			onStreamHeaders(r, func(status int, h http.Header) *server.ResponsePayload {
+				return filterStreamHeaders(active, r, req, status, h)
+			})
+		}
+		resp, err := next(r, req)
+		if err != nil || resp == nil {
+			return resp, err
+		}
+		if resp.Headers == nil {
+			resp.Headers = map[string]string{}
+		}
+		if local := filterResponse(active, r, req, resp); local != nil {
+			return local, nil
+		}
+		return resp, nil
+	}
+}
+
+// filterResponse runs the response phase of active in reverse and returns
+// the response to send instead of resp, if a filter failed or answered.
+func filterResponse(active []*wasmInstance, r *http.Request, req *server.RequestPayload, resp *server.ResponsePayload) *server.ResponsePayload {
+	for i := len(active) - 1; i >= 0; i-- {
+		inst := active[i]
+		call := &wasmCall{filter: inst.filter, r: r, req: req, resp: resp}
+		if _, err := inst.headers(call, "proxy_on_response_headers", len(resp.Headers)); err != nil {
+			return wasmFailed(inst.filter, req, err)
+		}
+		if call.local != nil {
+			return call.local
+		}
+	}
+	return nil
+}
+
+// filterStreamHeaders runs the response phase on a streamed response's
+// headers, h, before they are written. Cookies aren't shown to filters, as
+// with buffered responses.
+func filterStreamHeaders(active []*wasmInstance, r *http.Request, req *server.RequestPayload, status int, h http.Header) *server.ResponsePayload {
+	resp := &server.ResponsePayload{ID: req.ID, Status: status, Headers: map[string]string{}}
+	for k, vs := range h {
+		if k != "Set-Cookie" {
+			resp.Headers[k] = strings.Join(vs, ", ")
+		}
+	}
+	if local := filterResponse(active, r, req, resp); local != nil {
+		return local
+	}
+	for k := range h {
+		if k != "Set-Cookie" {
+			delete(h, k)
+		}
+	}
+	for k, v := range resp.Headers {
+		h.Set(k, v)
+	}
+	return nil
+}
+
+func wasmFailed(f *wasmFilter, req *server.RequestPayload, err error) *server.ResponsePayload {
+	log.Printf("[wasm] %s on %s: %v", f.name, req.Path, err)
+	return &server.ResponsePayload{ID: req.ID, Status: http.StatusInternalServerError, Body: []byte("Internal Server Error")}
+}
+
+// wasmInstance is one filter module instantiated for one request.
+type wasmInstance struct {
+	filter *wasmFilter
+	mod    api.Module
+}
+
+func (wf *wasmFilters) instantiate(f *wasmFilter, call *wasmCall) (*wasmInstance, error) {
+	ctx, cancel := call.context()
+	defer cancel()
+
+	mod, err := wf.runtime.InstantiateModule(ctx, f.module, wazero.NewModuleConfig().WithName("").WithStartFunctions("_initialize"))
+	if err != nil {
+		return nil, err
+	}
+	inst := &wasmInstance{filter: f, mod: mod}
+
+	// Give SDK-built modules the lifecycle they expect, where exported.
+	if fn := mod.ExportedFunction("proxy_on_context_create"); fn != nil {
+		if _, err := fn.Call(ctx, wasmRootContext, 0); err != nil {
+			inst.close()
+			return nil, err
+		}
+	}
+	if fn := mod.ExportedFunction("proxy_on_configure"); fn != nil {
+		if _, err := fn.Call(ctx, wasmRootContext, uint64(len(f.Config))); err != nil {
+			inst.close()
+			return nil, err
+		}
+	}
+	if fn := mod.ExportedFunction("proxy_on_context_create"); fn != nil {
+		if _, err := fn.Call(ctx, wasmHTTPContext, wasmRootContext); err != nil {
+			inst.close()
+			return nil, err
+		}
+	}
+	return inst, nil
+}
+
+// headers calls a proxy_on_*_headers export, if the module has it.
+func (inst *wasmInstance) headers(call *wasmCall, export string, n int) (uint32, error) {
+	fn := inst.mod.ExportedFunction(export)
+	if fn == nil {
+		return wasmContinue, nil
+	}
+	ctx, cancel := call.context()
+	defer cancel()
+
+	res, err := fn.Call(ctx, wasmHTTPContext, uint64(n), 0)
+	if err != nil {
+		return 0, err
+	}
+	if len(res) == 0 {
+		return wasmContinue, nil
+	}
+	return uint32(res[0]), nil
+}
+
+func (inst *wasmInstance) close() {
+	_ = inst.mod.Close(context.Background())
+}
+
+// wasmCall is what the host functions work on during one callback.
+type wasmCall struct {
+	filter *wasmFilter
+	r      *http.Request
+	req    *server.RequestPayload
+	resp   *server.ResponsePayload // nil during the request phase
+
+	local *server.ResponsePayload // set by proxy_send_local_response
+}
+
+type wasmCallKey struct{}
+
+func (c *wasmCall) context() (context.Context, context.CancelFunc) {
+	ctx := context.WithValue(context.Background(), wasmCallKey{}, c)
+	return context.WithTimeout(ctx, c.filter.timeout)
+}
+
+func callFrom(ctx context.Context) *wasmCall {
+	c, _ := ctx.Value(wasmCallKey{}).(*wasmCall)
+	return c
+}
+
+// headerMap returns the header map a module asked for, or nil if it isn't
+// available in this phase.
+func (c *wasmCall) headerMap(mapType uint32) wasmHeaders {
+	switch {
+	case mapType == wasmRequestHeaders && c.resp == nil:
+		return requestHeaders{c}
+	case mapType == wasmRequestHeaders:
+		return readOnlyHeaders{requestHeaders{c}}
+	case mapType == wasmResponseHeaders && c.resp != nil:
+		return responseHeaders(c.resp.Headers)
+	}
+	return nil
+}
+
+// wasmHeaders is a header map as proxy-wasm sees it: lower-case names,
+// one value per entry.
+type wasmHeaders interface {
+	get(name string) (string, bool)
+	pairs() [][2]string
+	add(name, value string) bool
+	replace(name, value string) bool
+	remove(name string) bool
+}
+
+// requestHeaders exposes the payload's headers plus the :method, :path,
+// :authority and :scheme pseudo-headers, which are read-only.
+type requestHeaders struct{ c *wasmCall }
+
+func (h requestHeaders) pseudo() [][2]string {
+	scheme := "http"
+	if h.c.r.TLS != nil {
+		scheme = "https"
+	}
+	return [][2]string{
+		{":method", h.c.req.Method},
+		{":path", h.c.r.URL.RequestURI()},
+		{":authority", h.c.r.Host},
+		{":scheme", scheme},
+	}
+}
+
+func (h requestHeaders) get(name string) (string, bool) {
+	if strings.HasPrefix(name, ":") {
+		for _, p := range h.pseudo() {
+			if p[0] == name {
+				return p[1], true
+			}
+		}
+		return "", false
+	}
+	v := h.c.req.Headers[http.CanonicalHeaderKey(name)]
+	if len(v) == 0 {
+		return "", false
+	}
+	return strings.Join(v, ", "), true
+}
+
+func (h requestHeaders) pairs() [][2]string {
+	out := h.pseudo()
+	for k, vs := range h.c.req.Headers {
+		for _, v := range vs {
+			out = append(out, [2]string{strings.ToLower(k), v})
+		}
+	}
+	return out
+}
+
+func (h requestHeaders) add(name, value string) bool {
+	if strings.HasPrefix(name, ":") {
+		return false
+	}
+	if h.c.req.Headers == nil {
+		h.c.req.Headers = map[string][]string{}
+	}
+	k := http.CanonicalHeaderKey(name)
+	h.c.req.Headers[k] = append(h.c.req.Headers[k], value)
+	return true
+}
+
+func (h requestHeaders) replace(name, value string) bool {
+	if strings.HasPrefix(name, ":") {
+		return false
+	}
+	if h.c.req.Headers == nil {
+		h.c.req.Headers = map[string][]string{}
+	}
+	h.c.req.Headers[http.CanonicalHeaderKey(name)] = []string{value}
+	return true
+}
+
+func (h requestHeaders) remove(name string) bool {
+	if strings.HasPrefix(name, ":") {
+		return false
+	}
+	delete(h.c.req.Headers, http.CanonicalHeaderKey(name))
+	return true
+}
+
+// readOnlyHeaders lets the response phase read the request headers.
+type readOnlyHeaders struct{ requestHeaders }
+
+func (readOnlyHeaders) add(string, string) bool     { return false }
+func (readOnlyHeaders) replace(string, string) bool { return false }
+func (readOnlyHeaders) remove(string) bool          { return false }
+
+// responseHeaders is PHP's response header map, whose keys are in whatever
+// case PHP used.
+type responseHeaders map[string]string
+
+func (h responseHeaders) key(name string) (string, bool) {
+	for k := range h {
+		if strings.EqualFold(k, name) {
+			return k, true
+		}
+	}
+	return "", false
+}
+
+func (h responseHeaders) get(name string) (string, bool) {
+	k, ok := h.key(name)
+	return h[k], ok
+}
+
+func (h responseHeaders) pairs() [][2]string {
+	out := make([][2]string, 0, len(h))
+	for k, v := range h {
+		out = append(out, [2]string{strings.ToLower(k), v})
+	}
+	return out
+}
+
+func (h responseHeaders) add(name, value string) bool {
+	if k, ok := h.key(name); ok {
+		h[k] += ", " + value
+		return true
+	}
+	h[http.CanonicalHeaderKey(name)] = value
+	return true
+}
+
+func (h responseHeaders) replace(name, value string) bool {
+	k, ok := h.key(name)
+	if !ok {
+		k = http.CanonicalHeaderKey(name)
+	}
+	h[k] = value
+	return true
+}
+
+func (h responseHeaders) remove(name string) bool {
+	if k, ok := h.key(name); ok {
+		delete(h, k)
+	}
+	return true
+}
+
+// encodeWasmPairs serialises headers the proxy-wasm way: the pair count,
+// each key and value length, then the NUL-terminated keys and values.
+func encodeWasmPairs(pairs [][2]string) []byte {
+	size := 4
+	for _, p := range pairs {
+		size += 8 + len(p[0]) + len(p[1]) + 2
+	}
+	buf := make([]byte, 0, size)
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(pairs)))
+	for _, p := range pairs {
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(len(p[0])))
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(len(p[1])))
+	}
+	for _, p := range pairs {
+		buf = append(buf, p[0]...)
+		buf = append(buf, 0)
+		buf = append(buf, p[1]...)
+		buf = append(buf, 0)
+	}
+	return buf
+}
+
+func decodeWasmPairs(buf []byte) ([][2]string, bool) {
+	if len(buf) < 4 {
+		return nil, len(buf) == 0
+	}
+	n := int(binary.LittleEndian.Uint32(buf))
+	if n > (len(buf)-4)/8 {
+		return nil, false
+	}
+	sizes, data := buf[4:4+8*n], buf[4+8*n:]
+	pairs := make([][2]string, 0, n)
+	for i := range n {
+		ks := int(binary.LittleEndian.Uint32(sizes[8*i:]))
+		vs := int(binary.LittleEndian.Uint32(sizes[8*i+4:]))
+		if ks+vs+2 > len(data) {
+			return nil, false
+		}
+		pairs = append(pairs, [2]string{string(data[:ks]), string(data[ks+1 : ks+1+vs])})
+		data = data[ks+vs+2:]
+	}
+	return pairs, true
+}
+
+// instantiateWasmHost registers the host side of the ABI as module "env".
+func instantiateWasmHost(ctx context.Context, rt wazero.Runtime) error {
+	_, err := rt.NewHostModuleBuilder("env").
+		NewFunctionBuilder().WithFunc(wasmLog).Export("proxy_log").
+		NewFunctionBuilder().WithFunc(wasmGetHeader).Export("proxy_get_header_map_value").
+		NewFunctionBuilder().WithFunc(wasmGetHeaderPairs).Export("proxy_get_header_map_pairs").
+		NewFunctionBuilder().WithFunc(wasmAddHeader).Export("proxy_add_header_map_value").
+		NewFunctionBuilder().WithFunc(wasmReplaceHeader).Export("proxy_replace_header_map_value").
+		NewFunctionBuilder().WithFunc(wasmRemoveHeader).Export("proxy_remove_header_map_value").
+		NewFunctionBuilder().WithFunc(wasmSendLocalResponse).Export("proxy_send_local_response").
+		NewFunctionBuilder().WithFunc(wasmGetBuffer).Export("proxy_get_buffer_bytes").
+		Instantiate(ctx)
+	return err
+}
+
+func readString(m api.Module, ptr, size uint32) (string, bool) {
+	b, ok := m.Memory().Read(ptr, size)
+	return string(b), ok
+}
+
+// returnBytes copies data into guest memory allocated by the module and
+// stores its address and length at retPtr and retSize.
+func returnBytes(ctx context.Context, m api.Module, data []byte, retPtr, retSize uint32) uint32 {
+	alloc := m.ExportedFunction("proxy_on_memory_allocate")
+	if alloc == nil {
+		return wasmBadArgument
+	}
+	res, err := alloc.Call(ctx, uint64(len(data)))
+	if err != nil || len(res) == 0 {
+		return wasmBadArgument
+	}
+	ptr := uint32(res[0])
+	mem := m.Memory()
+	if !mem.Write(ptr, data) || !mem.WriteUint32Le(retPtr, ptr) || !mem.WriteUint32Le(retSize, uint32(len(data))) {
+		return wasmBadArgument
+	}
+	return wasmOK
+}
+
+func wasmLog(ctx context.Context, m api.Module, level, ptr, size uint32) uint32 {
+	msg, ok := readString(m, ptr, size)
+	if !ok {
+		return wasmBadArgument
+	}
+	name := "?"
+	if c := callFrom(ctx); c != nil {
+		name = c.filter.name
+	}
+	log.Printf("[wasm] %s: %s", name, msg)
+	return wasmOK
+}
+
+func wasmGetHeader(ctx context.Context, m api.Module, mapType, keyPtr, keySize, retPtr, retSize uint32) uint32 {
+	h := callFrom(ctx).headerMap(mapType)
+	key, ok := readString(m, keyPtr, keySize)
+	if h == nil || !ok {
+		return wasmBadArgument
+	}
+	v, found := h.get(key)
+	if !found {
+		return wasmNotFound
+	}
+	return returnBytes(ctx, m, []byte(v), retPtr, retSize)
+}
+
+func wasmGetHeaderPairs(ctx context.Context, m api.Module, mapType, retPtr, retSize uint32) uint32 {
+	h := callFrom(ctx).headerMap(mapType)
+	if h == nil {
+		return wasmBadArgument
+	}
+	return returnBytes(ctx, m, encodeWasmPairs(h.pairs()), retPtr, retSize)
+}
+
+func wasmAddHeader(ctx context.Context, m api.Module, mapType, keyPtr, keySize, valPtr, valSize uint32) uint32 {
+	return setHeader(ctx, m, mapType, keyPtr, keySize, valPtr, valSize, wasmHeaders.add)
+}
+
+func wasmReplaceHeader(ctx context.Context, m api.Module, mapType, keyPtr, keySize, valPtr, valSize uint32) uint32 {
+	return setHeader(ctx, m, mapType, keyPtr, keySize, valPtr, valSize, wasmHeaders.replace)
+}
+
+func setHeader(ctx context.Context, m api.Module, mapType, keyPtr, keySize, valPtr, valSize uint32, set func(wasmHeaders, string, string) bool) uint32 {
+	h := callFrom(ctx).headerMap(mapType)
+	key, ok1 := readString(m, keyPtr, keySize)
+	val, ok2 := readString(m, valPtr, valSize)
+	if h == nil || !ok1 || !ok2 || !set(h, key, val) {
+		return wasmBadArgument
+	}
+	return wasmOK
+}
+
+func wasmRemoveHeader(ctx context.Context, m api.Module, mapType, keyPtr, keySize uint32) uint32 {
+	h := callFrom(ctx).headerMap(mapType)
+	key, ok := readString(m, keyPtr, keySize)
+	if h == nil || !ok || !h.remove(key) {
+		return wasmBadArgument
+	}
+	return wasmOK
+}
+
+func wasmSendLocalResponse(ctx context.Context, m api.Module, status, _, _, bodyPtr, bodySize, headersPtr, headersSize uint32, _ int32) uint32 {
+	c := callFrom(ctx)
+	body, ok := readString(m, bodyPtr, bodySize)
+	if !ok {
+		return wasmBadArgument
+	}
+	raw, ok := m.Memory().Read(headersPtr, headersSize)
+	if !ok {
+		return wasmBadArgument
+	}
+	pairs, ok := decodeWasmPairs(raw)
+	if !ok {
+		return wasmBadArgument
+	}
+
+	resp := &server.ResponsePayload{ID: c.req.ID, Status: int(status), Headers: map[string]string{}, Body: []byte(body)}
+	for _, p := range pairs {
+		responseHeaders(resp.Headers).add(p[0], p[1])
+	}
+	c.local = resp
+	return wasmOK
+}
+
+func wasmGetBuffer(ctx context.Context, m api.Module, bufferType, start, maxSize, retPtr, retSize uint32) uint32 {
+	if bufferType != wasmPluginConfiguration {
+		return wasmNotFound
+	}
+	data := []byte(callFrom(ctx).filter.Config)
+	if int(start) > len(data) {
+		return wasmBadArgument
+	}
+	data = data[start:]
+	if len(data) > int(maxSize) {
+		data = data[:maxSize]
+	}
+	return returnBytes(ctx, m, data, retPtr, retSize)
+}
+
+// validateWasmFilters drops filters without a module and fixes prefixes.
+func validateWasmFilters(cfg *AppServerConfig, warn configWarnFunc) {
+	filters := cfg.WasmFilters[:0]
+	for i, f := range cfg.WasmFilters {
+		if f.Module == "" {
+			warn(fmt.Sprintf("wasm_filters[%d].module", i), "wasm_filters[%d] has no module, ignoring it", i)
+			continue
+		}
+		if !strings.HasPrefix(f.Prefix, "/") {
+			warn(fmt.Sprintf("wasm_filters[%d].prefix", i), "wasm_filters[%d].prefix=%q does not start with '/', fixing", i, f.Prefix)
+			f.Prefix = "/" + f.Prefix
+		}
+		if f.TimeoutMs < 0 {
+			warn(fmt.Sprintf("wasm_filters[%d].timeout_ms", i), "wasm_filters[%d].timeout_ms=%d is invalid, using 100", i, f.TimeoutMs)
+			f.TimeoutMs = 0
+		}
+		filters = append(filters, f)
+	}
+	cfg.WasmFilters = filters
+}
@@ -0,0 +1,238 @@
+package appserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync/atomic"
+	"testing"
+
+	"go-php/server"
+	"go-php/server/testkit"
+)
+
+// Hand-assembled WebAssembly, so the tests need no toolchain.
+
+func wasmULEB(v uint32) []byte {
+	var out []byte
+	for {
+		b := byte(v & 0x7f)
+		v >>= 7
+		if v != 0 {
+			out = append(out, b|0x80)
+			continue
+		}
+		return append(out, b)
+	}
+}
+
+func wasmI32(v int32) []byte {
+	out := []byte{0x41}
+	for {
+		b := byte(v & 0x7f)
+		v >>= 7
+		if (v == 0 && b&0x40 == 0) || (v == -1 && b&0x40 != 0) {
+			return append(out, b)
+		}
+		out = append(out, b|0x80)
+	}
+}
+
+func wasmVec(items ...[]byte) []byte {
+	out := wasmULEB(uint32(len(items)))
+	for _, it := range items {
+		out = append(out, it...)
+	}
+	return out
+}
+
+func wasmName(s string) []byte { return append(wasmULEB(uint32(len(s))), s...) }
+
+func wasmSection(id byte, items ...[]byte) []byte {
+	body := wasmVec(items...)
+	return append(append([]byte{id}, wasmULEB(uint32(len(body)))...), body...)
+}
+
+func wasmFuncType(params, results int) []byte {
+	out := []byte{0x60}
+	out = append(out, wasmULEB(uint32(params))...)
+	for range params {
+		out = append(out, 0x7f)
+	}
+	out = append(out, wasmULEB(uint32(results))...)
+	for range results {
+		out = append(out, 0x7f)
+	}
+	return out
+}
+
+func wasmCode(instrs ...[]byte) []byte {
+	body := []byte{0x00} // no locals
+	for _, in := range instrs {
+		body = append(body, in...)
+	}
+	body = append(body, 0x0b)
+	return append(wasmULEB(uint32(len(body))), body...)
+}
+
+func wasmData(offset int32, s string) []byte {
+	out := append([]byte{0x00}, wasmI32(offset)...)
+	return append(append(out, 0x0b), wasmName(s)...)
+}
+
+func wasmCallOp(idx uint32) []byte { return append([]byte{0x10}, wasmULEB(idx)...) }
+
+var wasmDrop = []byte{0x1a}
+
+// botFilter answers 403 to requests with an X-Bot header, tags the rest with
+// X-Filtered and strips X-Powered-By from PHP's responses.
+func botFilter() []byte {
+	mod := []byte{0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00}
+	mod = append(mod, wasmSection(1,
+		wasmFuncType(5, 1), // 0: get/add header
+		wasmFuncType(8, 1), // 1: send_local_response
+		wasmFuncType(1, 1), // 2: memory_allocate
+		wasmFuncType(3, 1), // 3: on_*_headers, remove header
+	)...)
+	imp := func(name string, typ uint32) []byte {
+		return append(append(wasmName("env"), wasmName(name)...), append([]byte{0x00}, wasmULEB(typ)...)...)
+	}
+	mod = append(mod, wasmSection(2,
+		imp("proxy_get_header_map_value", 0),
+		imp("proxy_send_local_response", 1),
+		imp("proxy_add_header_map_value", 0),
+		imp("proxy_remove_header_map_value", 3),
+	)...)
+	mod = append(mod, wasmSection(3, wasmULEB(2), wasmULEB(3), wasmULEB(3))...)
+	mod = append(mod, wasmSection(5, []byte{0x00, 0x01})...)
+	exp := func(name string, kind byte, idx uint32) []byte {
+		return append(append(wasmName(name), kind), wasmULEB(idx)...)
+	}
+	mod = append(mod, wasmSection(7,
+		exp("memory", 0x02, 0),
+		exp("proxy_on_memory_allocate", 0x00, 4),
+		exp("proxy_on_request_headers", 0x00, 5),
+		exp("proxy_on_response_headers", 0x00, 6),
+	)...)
+	mod = append(mod, wasmSection(10,
+		wasmCode(wasmI32(1024)),
+		wasmCode(
+			wasmI32(wasmRequestHeaders), wasmI32(100), wasmI32(5), wasmI32(16), wasmI32(20), wasmCallOp(0),
+			[]byte{0x45, 0x04, 0x7f}, // i32.eqz; if (result i32): header found
+			wasmI32(403), wasmI32(0), wasmI32(0), wasmI32(110), wasmI32(7), wasmI32(0), wasmI32(0), wasmI32(-1), wasmCallOp(1), wasmDrop,
+			wasmI32(wasmPause),
+			[]byte{0x05},
+			wasmI32(wasmRequestHeaders), wasmI32(120), wasmI32(10), wasmI32(131), wasmI32(4), wasmCallOp(2), wasmDrop,
+			wasmI32(wasmContinue),
+			[]byte{0x0b},
+		),
+		wasmCode(wasmI32(wasmResponseHeaders), wasmI32(140), wasmI32(12), wasmCallOp(3), wasmDrop, wasmI32(wasmContinue)),
+	)...)
+	mod = append(mod, wasmSection(11,
+		wasmData(100, "x-bot"),
+		wasmData(110, "blocked"),
+		wasmData(120, "x-filtered"),
+		wasmData(131, "wasm"),
+		wasmData(140, "x-powered-by"),
+	)...)
+	return mod
+}
+
+func TestWasmFilterBlocksAndRewrites(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "bot.wasm"), botFilter(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	var phpCalls atomic.Int32
+	addr := fakePHP(t, func(req *server.RequestPayload) *server.ResponsePayload {
+		phpCalls.Add(1)
+		return &server.ResponsePayload{
+			Status:  http.StatusOK,
+			Headers: map[string]string{"X-Powered-By": "PHP/8.3"},
+			Body:    []byte("filtered=" + req.Headers["X-Filtered"][0]),
+		}
+	})
+	srv, err := New(&AppServerConfig{
+		Root:        root,
+		Pools:       []PoolConfig{{Name: "fast", Workers: 1, Transport: "tcp", Address: addr}},
+		WasmFilters: []WasmFilter{{Prefix: "/api", Module: "bot.wasm"}},
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	t.Cleanup(func() { _ = srv.Shutdown(t.Context()) })
+
+	req := httptest.NewRequest("GET", "/api/users", nil)
+	req.Header.Set("X-Bot", "1")
+	rr := httptest.NewRecorder()
+	srv.ServeHTTP(rr, req)
+	if rr.Code != http.StatusForbidden || rr.Body.String() != "blocked" || phpCalls.Load() != 0 {
+		t.Fatalf("bot request: %d %q, php calls %d", rr.Code, rr.Body.String(), phpCalls.Load())
+	}
+
+	rr = httptest.NewRecorder()
+	srv.ServeHTTP(rr, httptest.NewRequest("GET", "/api/users", nil))
+	if rr.Code != http.StatusOK || rr.Body.String() != "filtered=wasm" {
+		t.Fatalf("filtered request: %d %q", rr.Code, rr.Body.String())
+	}
+	if got := rr.Header().Get("X-Powered-By"); got != "" {
+		t.Fatalf("X-Powered-By = %q, want it scrubbed", got)
+	}
+
+	req = httptest.NewRequest("GET", "/home", nil)
+	req.Header.Set("X-Bot", "1")
+	req.Header.Set("X-Filtered", "none")
+	rr = httptest.NewRecorder()
+	srv.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK || rr.Body.String() != "filtered=none" || rr.Header().Get("X-Powered-By") == "" {
+		t.Fatalf("request outside the prefix was filtered: %d %q %v", rr.Code, rr.Body.String(), rr.Header())
+	}
+}
+
+func TestWasmFilterScrubsStreamedHeaders(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "bot.wasm"), botFilter(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	addr := testkit.Listen(t, func(req *server.RequestPayload) testkit.Reply {
+		return testkit.Reply{Frames: []server.StreamFrame{
+			{Type: "headers", Status: http.StatusOK, Headers: map[string][]string{"X-Powered-By": {"PHP/8.3"}, "Set-Cookie": {"a=1", "b=2"}}},
+			{Type: "chunk", Data: "one,"},
+			{Type: "chunk", Data: "two"},
+		}}
+	})
+	srv, err := New(&AppServerConfig{
+		Root:        root,
+		Pools:       []PoolConfig{{Name: "fast", Workers: 1, Transport: "tcp", Address: addr}},
+		WasmFilters: []WasmFilter{{Prefix: "/stream/", Module: "bot.wasm"}},
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	t.Cleanup(func() { _ = srv.Shutdown(t.Context()) })
+
+	rr := httptest.NewRecorder()
+	srv.ServeHTTP(rr, httptest.NewRequest("GET", "/stream/feed", nil))
+	if rr.Code != http.StatusOK || rr.Body.String() != "one,two" {
+		t.Fatalf("stream: %d %q", rr.Code, rr.Body.String())
+	}
+	if got := rr.Header().Get("X-Powered-By"); got != "" {
+		t.Fatalf("X-Powered-By = %q, want it scrubbed", got)
+	}
+	if got := rr.Header().Values("Set-Cookie"); len(got) != 2 {
+		t.Fatalf("Set-Cookie = %q, want both cookies", got)
+	}
+}
+
+func TestWasmPairsRoundTrip(t *testing.T) {
+	pairs := [][2]string{{":method", "GET"}, {"x-empty", ""}, {"cookie", "a=1; b=2"}}
+	got, ok := decodeWasmPairs(encodeWasmPairs(pairs))
+	if !ok || !reflect.DeepEqual(got, pairs) {
+		t.Fatalf("round trip = %v (ok=%v), want %v", got, ok, pairs)
+	}
+	if _, ok := decodeWasmPairs([]byte{9, 0, 0, 0, 1}); ok {
+		t.Fatal("truncated pairs decoded")
+	}
+}
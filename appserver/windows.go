@@ -0,0 +1,143 @@
+package appserver
+
+import (
+	"sort"
+	"time"
+)
+
+// Rolling windows count the requests of the last 1, 5 and 15 minutes in
+// slotWidth-wide slots, with a latency histogram per slot for percentiles,
+// so recent behavior shows up instead of being lost in lifetime totals.
+const (
+	slotWidth   = 15 * time.Second
+	windowSlots = 60 // 15 minutes
+)
+
+// latencyBounds are the upper bounds, in ms, of the histogram buckets; the
+// last bucket holds anything slower. Percentiles are interpolated within a
+// bucket, so they are estimates.
+var latencyBounds = [...]float64{1, 2.5, 5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000, 30000}
+
+// windowSpans are the windows reported, by name, in slots.
+var windowSpans = []struct {
+	name  string
+	slots int
+}{{"1m", 4}, {"5m", 20}, {"15m", 60}}
+
+// WindowStats is one rolling window of a route or pool.
+type WindowStats struct {
+	Requests  uint64  `json:"requests"`
+	Rate      float64 `json:"rate"` // requests per second
+	Errors    uint64  `json:"errors"`
+	ErrorRate float64 `json:"error_rate"` // errors per request
+	P50Ms     float64 `json:"p50_ms"`
+	P90Ms     float64 `json:"p90_ms"`
+	P95Ms     float64 `json:"p95_ms"`
+	P99Ms     float64 `json:"p99_ms"`
+}
+
+type windowSlot struct {
+	n                int64 // unix time / slotWidth
+	requests, errors uint32
+	latency          [len(latencyBounds) + 1]uint32
+}
+
+// rollingWindow is a ring of the last windowSlots slots. It isn't safe
+// for concurrent use; callers hold the lock of whatever it belongs to.
+type rollingWindow struct {
+	slots [windowSlots]windowSlot
+}
+
+func (w *rollingWindow) record(now time.Time, latency time.Duration, failed bool) {
+	n := now.UnixNano() / int64(slotWidth)
+	s := &w.slots[n%windowSlots]
+	if s.n != n {
+		*s = windowSlot{n: n}
+	}
+	s.requests++
+	if failed {
+		s.errors++
+	}
+	ms := float64(latency) / float64(time.Millisecond)
+	s.latency[sort.SearchFloat64s(latencyBounds[:], ms)]++
+}
+
+// add adds o's slots that are still current as of now to w's.
+func (w *rollingWindow) add(o *rollingWindow, now time.Time) {
+	n := now.UnixNano() / int64(slotWidth)
+	for i := range o.slots {
+		src := &o.slots[i]
+		if src.n <= n-windowSlots || src.n > n {
+			continue
+		}
+		dst := &w.slots[i]
+		if dst.n != src.n {
+			*dst = windowSlot{n: src.n}
+		}
+		dst.requests += src.requests
+		dst.errors += src.errors
+		for b, c := range src.latency {
+			dst.latency[b] += c
+		}
+	}
+}
+
+// stats sums up every window in windowSpans as of now.
+func (w *rollingWindow) stats(now time.Time) map[string]WindowStats {
+	n := now.UnixNano() / int64(slotWidth)
+	// the current slot is only partly over
+	partial := time.Duration(now.UnixNano() % int64(slotWidth))
+
+	out := make(map[string]WindowStats, len(windowSpans))
+	for _, span := range windowSpans {
+		var sum windowSlot
+		for i := int64(0); i < int64(span.slots); i++ {
+			s := &w.slots[(n-i)%windowSlots]
+			if s.n != n-i {
+				continue
+			}
+			sum.requests += s.requests
+			sum.errors += s.errors
+			for b, c := range s.latency {
+				sum.latency[b] += c
+			}
+		}
+
+		ws := WindowStats{Requests: uint64(sum.requests), Errors: uint64(sum.errors)}
+		if covered := time.Duration(span.slots-1)*slotWidth + partial; covered > 0 {
+			ws.Rate = float64(sum.requests) / covered.Seconds()
+		}
+		if sum.requests > 0 {
+			ws.ErrorRate = float64(sum.errors) / float64(sum.requests)
+			ws.P50Ms = percentile(&sum.latency, sum.requests, 0.50)
+			ws.P90Ms = percentile(&sum.latency, sum.requests, 0.90)
+			ws.P95Ms = percentile(&sum.latency, sum.requests, 0.95)
+			ws.P99Ms = percentile(&sum.latency, sum.requests, 0.99)
+		}
+		out[span.name] = ws
+	}
+	return out
+}
+
+// percentile estimates the q quantile of the total latencies in hist,
+// interpolating linearly within the bucket it falls in. The open-ended
+// last bucket reports its lower bound.
+func percentile(hist *[len(latencyBounds) + 1]uint32, total uint32, q float64) float64 {
+	rank := q * float64(total)
+	var seen float64
+	for b, c := range hist {
+		if c == 0 || seen+float64(c) < rank {
+			seen += float64(c)
+			continue
+		}
+		if b == len(latencyBounds) {
+			return latencyBounds[b-1]
+		}
+		lo := 0.0
+		if b > 0 {
+			lo = latencyBounds[b-1]
+		}
+		return lo + (latencyBounds[b]-lo)*(rank-seen)/float64(c)
+	}
+	return latencyBounds[len(latencyBounds)-1]
+}
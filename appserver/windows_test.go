@@ -0,0 +1,55 @@
+package appserver
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRollingWindowStats(t *testing.T) {
+	var w rollingWindow
+	now := time.Unix(1_699_999_995, 0) // the start of a slot
+	for i := 1; i <= 100; i++ {
+		w.record(now, time.Duration(i)*time.Millisecond, i%10 == 0)
+	}
+	// four minutes ago: outside 1m, inside 5m and 15m
+	w.record(now.Add(-4*time.Minute), 20*time.Second, true)
+
+	stats := w.stats(now)
+	m1, m5, m15 := stats["1m"], stats["5m"], stats["15m"]
+	if m1.Requests != 100 || m1.Errors != 10 || m1.ErrorRate != 0.1 {
+		t.Fatalf("1m = %+v, want 100 requests, 10 errors", m1)
+	}
+	if m1.P50Ms < 25 || m1.P50Ms > 50 || m1.P90Ms < 50 || m1.P90Ms > 100 || m1.P99Ms < m1.P90Ms || m1.P99Ms > 100 {
+		t.Fatalf("1m percentiles = %v/%v/%v, want about 50/90/99", m1.P50Ms, m1.P90Ms, m1.P99Ms)
+	}
+	if m5.Requests != 101 || m15.Requests != 101 || m5.Errors != 11 {
+		t.Fatalf("5m = %+v, 15m = %+v, want the old request too", m5, m15)
+	}
+	if want := 100.0 / 45; m1.Rate != want {
+		t.Fatalf("1m rate = %v, want %v per second over the 45s covered", m1.Rate, want)
+	}
+
+	// a slot is reused once the ring comes round again
+	later := now.Add(windowSlots * slotWidth)
+	w.record(later, time.Millisecond, false)
+	if got := w.stats(later)["15m"].Requests; got != 1 {
+		t.Fatalf("15m later = %d requests, want 1", got)
+	}
+}
+
+func TestMetricsPoolWindows(t *testing.T) {
+	m := NewMetrics()
+	m.StartRequest("/a")
+	m.EndPoolRequest("/a", "fast", 5*time.Millisecond, false)
+	m.RecordBytes("/a", "fast", 0, 10)
+	m.StartRequest("/a")
+	m.EndPoolRequest("/a", "fast", 500*time.Millisecond, true)
+
+	snap := m.Snapshot()
+	if w := snap.ByPool["fast"].Windows["1m"]; w.Requests != 2 || w.Errors != 1 {
+		t.Fatalf("pool 1m = %+v, want 2 requests, 1 error", w)
+	}
+	if w := snap.ByRoute["/a"].Windows["15m"]; w.Requests != 2 || w.P99Ms < 250 {
+		t.Fatalf("route 15m = %+v, want 2 requests with a slow p99", w)
+	}
+}
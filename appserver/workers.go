@@ -0,0 +1,82 @@
+package appserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"go-php/server"
+)
+
+// findWorker resolves the {id} path value to a worker in any app, writing
+// the error response itself when it can't.
+func findWorker(vhosts *vhostRouter, w http.ResponseWriter, r *http.Request) (uint64, *server.Worker) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid worker id", http.StatusBadRequest)
+		return 0, nil
+	}
+	for _, app := range vhosts.all() {
+		if worker := app.srv.Worker(id); worker != nil {
+			return id, worker
+		}
+	}
+	http.Error(w, "no such worker", http.StatusNotFound)
+	return id, nil
+}
+
+// workerLogsHandler serves GET /__baremetal/workers/{id}/logs?n=50: the last
+// n stderr lines of the worker with that id (ids are listed in the health
+// and scoreboard output).
+func workerLogsHandler(vhosts *vhostRouter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		n := 100
+		if s := r.URL.Query().Get("n"); s != "" {
+			var err error
+			if n, err = strconv.Atoi(s); err != nil || n < 0 {
+				http.Error(w, "invalid n", http.StatusBadRequest)
+				return
+			}
+		}
+
+		id, worker := findWorker(vhosts, w, r)
+		if worker == nil {
+			return
+		}
+
+		lines := worker.Logs(n)
+		if lines == nil {
+			lines = []server.LogLine{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"worker": id,
+			"lines":  lines,
+		})
+	}
+}
+
+// workerActionHandler serves POST /__baremetal/workers/{id}/recycle and
+// /drain for a single worker, leaving the rest of its pool alone.
+func workerActionHandler(vhosts *vhostRouter, action string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, worker := findWorker(vhosts, w, r)
+		if worker == nil {
+			return
+		}
+
+		switch action {
+		case "recycle":
+			worker.Recycle()
+		case "drain":
+			worker.Drain()
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"worker": id,
+			"status": action + " scheduled",
+		})
+	}
+}
@@ -0,0 +1,37 @@
+package appserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWorkerLogsHandlerRejectsBadOrUnknownIDs(t *testing.T) {
+	srv := newCanaryTestServer(t)
+	mux := http.NewServeMux()
+	mux.Handle("GET /__baremetal/workers/{id}/logs", workerLogsHandler(&vhostRouter{def: &vhost{name: "default", srv: srv}}))
+
+	for path, want := range map[string]int{
+		"/__baremetal/workers/abc/logs":       http.StatusBadRequest,
+		"/__baremetal/workers/1/logs?n=-1":    http.StatusBadRequest,
+		"/__baremetal/workers/999999999/logs": http.StatusNotFound,
+	} {
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, path, nil))
+		if rr.Code != want {
+			t.Errorf("%s: got %d, want %d", path, rr.Code, want)
+		}
+	}
+}
+
+func TestWorkerActionHandlerUnknownWorker(t *testing.T) {
+	srv := newCanaryTestServer(t)
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /__baremetal/workers/{id}/recycle", workerActionHandler(&vhostRouter{def: &vhost{name: "default", srv: srv}}, "recycle"))
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/__baremetal/workers/999999999/recycle", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("got %d, want 404", rr.Code)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"go-php/server"
+)
+
+// benchTransportCmd times round trips over each worker transport and codec
+// for a few body sizes, against an in-process echo peer, so the choice for
+// a deployment can follow numbers from its own hardware.
+func benchTransportCmd(args []string, out io.Writer) int {
+	fs := flag.NewFlagSet("bench-transport", flag.ContinueOnError)
+	fs.SetOutput(out)
+	transports := fs.String("transports", strings.Join(server.BenchTransports, ","), "transports to measure")
+	codecs := fs.String("codecs", strings.Join(server.BenchCodecs, ","), "codecs to measure on the length-prefixed transports")
+	sizes := fs.String("sizes", "1k,32k,1m", "request and response body sizes (k and m suffixes allowed)")
+	n := fs.Int("n", 200, "round trips per combination")
+	asJSON := fs.Bool("json", false, "print results as JSON")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	var sizeList []int
+	for _, s := range strings.Split(*sizes, ",") {
+		size, err := parseSize(s)
+		if err != nil {
+			fmt.Fprintf(out, "bench-transport: %v\n", err)
+			return 2
+		}
+		sizeList = append(sizeList, size)
+	}
+
+	var results []server.TransportBenchResult
+	for _, transport := range strings.Split(*transports, ",") {
+		codecList := strings.Split(*codecs, ",")
+		if transport == server.TransportFastCGI {
+			codecList = []string{""} // FastCGI frames bodies itself
+		}
+		for _, codec := range codecList {
+			for _, size := range sizeList {
+				res, err := server.BenchTransport(transport, codec, size, *n)
+				if err != nil {
+					fmt.Fprintf(out, "bench-transport: %s/%s: %v\n", transport, codec, err)
+					return 1
+				}
+				results = append(results, res)
+			}
+		}
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(results)
+		return 0
+	}
+	tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "TRANSPORT\tCODEC\tBODY\tPER REQUEST\tREQ/S\tMB/S")
+	for _, r := range results {
+		mbps := float64(r.PayloadBytes) * r.RequestsPerSecond() / 1e6
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%v\t%.0f\t%.1f\n", r.Transport, r.Codec, formatSize(r.PayloadBytes),
+			r.PerRequest(), r.RequestsPerSecond(), mbps)
+	}
+	tw.Flush()
+	return 0
+}
+
+// parseSize reads "512", "32k" or "1m".
+func parseSize(s string) (int, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	mult := 1
+	switch {
+	case strings.HasSuffix(s, "k"):
+		s, mult = s[:len(s)-1], 1<<10
+	case strings.HasSuffix(s, "m"):
+		s, mult = s[:len(s)-1], 1<<20
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("bad size %q", s)
+	}
+	return n * mult, nil
+}
+
+func formatSize(n int) string {
+	switch {
+	case n >= 1<<20 && n%(1<<20) == 0:
+		return strconv.Itoa(n>>20) + "MiB"
+	case n >= 1<<10 && n%(1<<10) == 0:
+		return strconv.Itoa(n>>10) + "KiB"
+	}
+	return strconv.Itoa(n) + "B"
+}
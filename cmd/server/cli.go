@@ -0,0 +1,278 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"time"
+
+	"go-php/appserver"
+	"go-php/server"
+)
+
+// version is stamped at build time with -ldflags "-X main.version=v1.2.3".
+var version = "dev"
+
+const usage = `usage: server [command] [flags]
+
+commands:
+  start      run the app server (the default)
+  init       create a starter project (worker, sample app, config)
+  validate   check the config file and exit nonzero on problems
+  status     show the health of a running server
+  replay     send captured requests to the PHP workers again
+  bench-transport
+             compare worker transports and codecs on this machine
+  schema     print a JSON Schema for go_appserver.json
+  version    print version information
+
+Run "server <command> -h" for the command's flags.
+`
+
+func main() {
+	cmd, args := "start", os.Args[1:]
+	if len(args) > 0 {
+		cmd, args = args[0], args[1:]
+	}
+
+	switch cmd {
+	case "start":
+		start(args)
+	case "init":
+		os.Exit(initCmd(args, os.Stdout))
+	case "validate":
+		os.Exit(validateCmd(args, os.Stdout))
+	case "status":
+		os.Exit(statusCmd(args, os.Stdout))
+	case "replay":
+		os.Exit(replayCmd(args, os.Stdout))
+	case "bench-transport":
+		os.Exit(benchTransportCmd(args, os.Stdout))
+	case "schema":
+		schemaCmd(os.Stdout)
+	case "version":
+		printVersion(os.Stdout)
+	case "help", "-h", "-help", "--help":
+		fmt.Print(usage)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", cmd, usage)
+		os.Exit(2)
+	}
+}
+
+// validateCmd parses the config file the way start would and lists every
+// problem that start would silently fix up or ignore.
+func validateCmd(args []string, out io.Writer) int {
+	fs := flag.NewFlagSet("validate", flag.ContinueOnError)
+	fs.SetOutput(out)
+	path := fs.String("config", "", "config file (default: go_appserver.{json,yaml,toml} in the project root)")
+	asJSON := fs.Bool("json", false, "print the result as JSON")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *path == "" {
+		*path = appserver.ConfigPath(appserver.ProjectRoot())
+	}
+
+	problems := appserver.CheckConfig(*path)
+	if *asJSON {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(map[string]any{"file": *path, "valid": len(problems) == 0, "errors": problems})
+	} else {
+		for _, p := range problems {
+			fmt.Fprintf(out, "%s: %s\n", *path, p.Message)
+		}
+		if len(problems) > 0 {
+			fmt.Fprintf(out, "%d problem(s) found\n", len(problems))
+		} else {
+			fmt.Fprintf(out, "%s: ok\n", *path)
+		}
+	}
+	if len(problems) > 0 {
+		return 1
+	}
+	return 0
+}
+
+// enforceStrict stops the server if the config file at path has any
+// problems, printing them as JSON on stderr for tooling.
+func enforceStrict(path string) {
+	if _, err := os.Stat(path); err != nil {
+		return // no config file: the defaults are valid
+	}
+	problems := appserver.CheckConfig(path)
+	if len(problems) == 0 {
+		return
+	}
+	for _, p := range problems {
+		log.Printf("[config] strict: %s", p.Message)
+	}
+	_ = json.NewEncoder(os.Stderr).Encode(map[string]any{"file": path, "errors": problems})
+	os.Exit(1)
+}
+
+func schemaCmd(out io.Writer) {
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(appserver.Schema())
+}
+
+// statusCmd asks a running server for its health summary and exits
+// nonzero if it can't be reached or isn't healthy.
+func statusCmd(args []string, out io.Writer) int {
+	root := appserver.ProjectRoot()
+	cfg := appserver.DefaultConfig()
+	if c, err := appserver.ReadConfig(appserver.ConfigPath(root)); err == nil {
+		cfg = c
+	}
+
+	fs := flag.NewFlagSet("status", flag.ContinueOnError)
+	fs.SetOutput(out)
+	addr := fs.String("addr", statusAddr(cfg), `server address ("host:port", "unix:/path" or a URL)`)
+	token := fs.String("token", cfg.Admin.EffectiveToken(), "admin token")
+	app := fs.String("app", "", "virtual host to report on")
+	timeout := fs.Duration("timeout", 5*time.Second, "request timeout")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	summary, err := fetchHealth(*addr, *token, *app, *timeout)
+	if err != nil {
+		fmt.Fprintf(out, "status: %v\n", err)
+		return 1
+	}
+	if !printHealth(out, summary) {
+		return 1
+	}
+	return 0
+}
+
+// statusAddr is where the management endpoints of a server started with
+// cfg listen: the admin listener if there is one, else the main one.
+func statusAddr(cfg *appserver.AppServerConfig) string {
+	if cfg.Admin.Listen != "" {
+		return cfg.Admin.Listen
+	}
+	if addr := os.Getenv("APP_SERVER_ADDR"); addr != "" {
+		return addr
+	}
+	return ":8080"
+}
+
+func fetchHealth(addr, token, app string, timeout time.Duration) (*server.HealthSummary, error) {
+	client := &http.Client{Timeout: timeout}
+	base := addr
+	switch {
+	case strings.HasPrefix(addr, "unix:"):
+		sock := strings.TrimPrefix(addr, "unix:")
+		client.Transport = &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", sock)
+			},
+		}
+		base = "http://unix"
+	case !strings.Contains(addr, "://"):
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, fmt.Errorf("bad address %q: %w", addr, err)
+		}
+		if host == "" || host == "0.0.0.0" || host == "::" {
+			host = "127.0.0.1"
+		}
+		base = "http://" + net.JoinHostPort(host, port)
+	}
+
+	u := strings.TrimSuffix(base, "/") + "/__baremetal/health"
+	if app != "" {
+		u += "?app=" + url.QueryEscape(app)
+	}
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", u, resp.Status)
+	}
+
+	var summary server.HealthSummary
+	if err := json.NewDecoder(resp.Body).Decode(&summary); err != nil {
+		return nil, fmt.Errorf("decoding health summary: %w", err)
+	}
+	return &summary, nil
+}
+
+// printHealth writes one line per pool and reports whether the server is
+// healthy: not degraded and with a live worker in every pool.
+func printHealth(out io.Writer, h *server.HealthSummary) bool {
+	healthy := !h.Degraded
+	if h.Degraded {
+		fmt.Fprintln(out, "degraded: php workers unavailable")
+	}
+
+	names := make([]string, 0, len(h.Pools))
+	for name := range h.Pools {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		p := h.Pools[name]
+		fmt.Fprintf(out, "pool %-10s %d workers, %d dead, %d in flight, %.1fms queue wait\n",
+			name, p.Workers, p.DeadWorkers, p.InFlight, p.QueueWaitMs)
+		if p.Workers > 0 && p.DeadWorkers == p.Workers {
+			healthy = false
+		}
+	}
+	if h.Shed > 0 {
+		fmt.Fprintf(out, "shed: %d requests\n", h.Shed)
+	}
+	if healthy {
+		fmt.Fprintln(out, "ok")
+	}
+	return healthy
+}
+
+func printVersion(out io.Writer) {
+	fmt.Fprintf(out, "go-php-app-server %s", version)
+	if info, ok := debug.ReadBuildInfo(); ok {
+		var rev, dirty string
+		for _, s := range info.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				rev = s.Value
+			case "vcs.modified":
+				if s.Value == "true" {
+					dirty = "-dirty"
+				}
+			}
+		}
+		if len(rev) > 12 {
+			rev = rev[:12]
+		}
+		if rev != "" {
+			fmt.Fprintf(out, " (%s%s)", rev, dirty)
+		}
+	}
+	fmt.Fprintf(out, " %s %s/%s\n", runtime.Version(), runtime.GOOS, runtime.GOARCH)
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go-php/appserver"
+	"go-php/server"
+)
+
+func TestValidateCmd(t *testing.T) {
+	tmp := t.TempDir()
+	path := filepath.Join(tmp, "go_appserver.json")
+
+	cases := []struct {
+		name, config string
+		code         int
+		want         string
+	}{
+		{"valid", `{"fast_workers": 2}`, 0, "ok"},
+		{"invalid value", `{"fast_workers": -1}`, 1, "fast_workers=-1 is invalid"},
+		{"unknown pool", `{"pools": [{"name": "fast"}], "pool_routes": [{"prefix": "/x", "pool": "nope"}]}`, 1, `unknown pool "nope"`},
+		{"syntax error", `{"fast_workers": `, 1, "unexpected end"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if err := os.WriteFile(path, []byte(c.config), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			var out strings.Builder
+			if code := validateCmd([]string{"-config", path}, &out); code != c.code {
+				t.Fatalf("exit code = %d, want %d; output:\n%s", code, c.code, out.String())
+			}
+			if !strings.Contains(out.String(), c.want) {
+				t.Fatalf("output %q does not mention %q", out.String(), c.want)
+			}
+		})
+	}
+}
+
+func TestStatusCmd(t *testing.T) {
+	summary := server.HealthSummary{Pools: map[string]server.PoolStats{
+		"fast": {Workers: 2},
+		"slow": {Workers: 1},
+	}}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/__baremetal/health" || r.Header.Get("Authorization") != "Bearer secret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(summary)
+	}))
+	defer ts.Close()
+
+	var out strings.Builder
+	if code := statusCmd([]string{"-addr", ts.URL, "-token", "secret"}, &out); code != 0 {
+		t.Fatalf("status exit code = %d; output:\n%s", code, out.String())
+	}
+	if !strings.Contains(out.String(), "pool fast") || !strings.HasSuffix(out.String(), "ok\n") {
+		t.Fatalf("unexpected output:\n%s", out.String())
+	}
+
+	summary.Pools["slow"] = server.PoolStats{Workers: 1, DeadWorkers: 1}
+	out.Reset()
+	if code := statusCmd([]string{"-addr", ts.URL, "-token", "secret"}, &out); code != 1 {
+		t.Fatalf("pool with only dead workers: exit code = %d, want 1", code)
+	}
+
+	out.Reset()
+	if code := statusCmd([]string{"-addr", ts.URL, "-token", "wrong"}, &out); code != 1 || !strings.Contains(out.String(), "401") {
+		t.Fatalf("bad token: exit code = %d, output %q", code, out.String())
+	}
+}
+
+func TestValidateCmdJSON(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "go_appserver.json")
+	if err := os.WriteFile(path, []byte(`{"slow_workers": -1}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var out strings.Builder
+	if code := validateCmd([]string{"-json", "-config", path}, &out); code != 1 {
+		t.Fatalf("exit code = %d, want 1", code)
+	}
+	var res struct {
+		Valid  bool                    `json:"valid"`
+		Errors []appserver.ConfigError `json:"errors"`
+	}
+	if err := json.Unmarshal([]byte(out.String()), &res); err != nil {
+		t.Fatalf("output is not JSON: %v\n%s", err, out.String())
+	}
+	if res.Valid || len(res.Errors) != 1 || res.Errors[0].Path != "slow_workers" {
+		t.Fatalf("unexpected result: %+v", res)
+	}
+}
+
+func TestBenchTransportCmd(t *testing.T) {
+	var out strings.Builder
+	if code := benchTransportCmd([]string{"-transports", "stdio,fastcgi", "-sizes", "1k,2k", "-n", "2"}, &out); code != 0 {
+		t.Fatalf("exit code = %d; output:\n%s", code, out.String())
+	}
+	for _, want := range []string{"stdio", "fastcgi", "1KiB", "2KiB"} {
+		if !strings.Contains(out.String(), want) {
+			t.Fatalf("output %q does not mention %q", out.String(), want)
+		}
+	}
+
+	out.Reset()
+	if code := benchTransportCmd([]string{"-sizes", "big"}, &out); code != 2 {
+		t.Fatalf("bad size: exit code = %d, want 2", code)
+	}
+}
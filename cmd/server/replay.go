@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"go-php/appserver"
+)
+
+// replayCmd feeds captured requests (files or directories of them, as
+// written by the capture config) back to fresh PHP workers, to reproduce
+// a failure seen in production.
+func replayCmd(args []string, out io.Writer) int {
+	fs := flag.NewFlagSet("replay", flag.ContinueOnError)
+	fs.SetOutput(out)
+	pool := fs.String("pool", "", "pool to send every request to (default: the one the routes pick)")
+	app := fs.String("app", "", "virtual host whose workers to use (default: the captured one)")
+	repeat := fs.Int("repeat", 1, "send each request this many times")
+	verbose := fs.Bool("v", false, "print response bodies")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fmt.Fprintln(out, "usage: server replay [-pool name] [-app name] [-repeat n] [-v] file-or-dir...")
+		return 2
+	}
+
+	var files []string
+	for _, arg := range fs.Args() {
+		info, err := os.Stat(arg)
+		if err != nil {
+			fmt.Fprintf(out, "replay: %v\n", err)
+			return 2
+		}
+		if !info.IsDir() {
+			files = append(files, arg)
+			continue
+		}
+		names, _ := filepath.Glob(filepath.Join(arg, "*.json"))
+		slices.Sort(names)
+		files = append(files, names...)
+	}
+
+	root := appserver.ProjectRoot()
+	cfg := appserver.LoadConfig(root)
+	cfg.Root = root
+	cfg.Capture = nil // don't capture the replays
+	srv, err := appserver.New(cfg)
+	if err != nil {
+		fmt.Fprintf(out, "replay: %v\n", err)
+		return 1
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(ctx)
+	}()
+
+	return replayFiles(srv, files, *app, *pool, *repeat, *verbose, out)
+}
+
+// replayFiles sends each file's request to srv, printing one line per
+// attempt, and returns 1 if any failed in the worker layer.
+func replayFiles(srv *appserver.Server, files []string, app, pool string, repeat int, verbose bool, out io.Writer) int {
+	code := 0
+	for _, name := range files {
+		rec, err := appserver.ReadCapture(name)
+		if err != nil {
+			fmt.Fprintf(out, "%s: %v\n", name, err)
+			code = 1
+			continue
+		}
+		target := app
+		if target == "" {
+			target = rec.App
+		}
+		for range max(repeat, 1) {
+			req := *rec.Payload
+			start := time.Now()
+			resp, err := srv.Replay(target, pool, &req)
+			elapsed := time.Since(start).Round(time.Microsecond)
+			if err != nil {
+				fmt.Fprintf(out, "%s: %s %s -> error after %v: %v\n", name, req.Method, req.Path, elapsed, err)
+				code = 1
+				continue
+			}
+			fmt.Fprintf(out, "%s: %s %s -> %d (%d bytes) in %v\n", name, req.Method, req.Path, resp.Status, len(resp.Body), elapsed)
+			if verbose {
+				fmt.Fprintf(out, "%s\n", resp.Body)
+			}
+		}
+	}
+	return code
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"embed"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// scaffold is the starter project written by `server init`: a worker that
+// speaks the stdio protocol (including streaming frames), a sample app, a
+// config and a public/ directory.
+//
+//go:embed all:scaffold
+var scaffold embed.FS
+
+// initCmd writes the starter project into a directory, leaving existing
+// files alone unless -force is given.
+func initCmd(args []string, out io.Writer) int {
+	flags := flag.NewFlagSet("init", flag.ContinueOnError)
+	flags.SetOutput(out)
+	dir := flags.String("dir", ".", "directory to create the project in")
+	force := flags.Bool("force", false, "overwrite existing files")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	skipped := 0
+	err := fs.WalkDir(scaffold, "scaffold", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel := name[len("scaffold/"):]
+		dest := filepath.Join(*dir, filepath.FromSlash(rel))
+
+		if _, err := os.Stat(dest); err == nil && !*force {
+			fmt.Fprintf(out, "  exists  %s\n", rel)
+			skipped++
+			return nil
+		} else if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+
+		data, err := scaffold.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(dest, data, 0o644); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "  create  %s\n", rel)
+		return nil
+	})
+	if err != nil {
+		fmt.Fprintf(out, "init: %v\n", err)
+		return 1
+	}
+
+	if skipped > 0 {
+		fmt.Fprintf(out, "\n%d existing file(s) kept; rerun with -force to overwrite them.\n", skipped)
+	}
+	fmt.Fprintf(out, "\nNext: run `server start` in %s and open http://localhost:8080\n", *dir)
+	return 0
+}
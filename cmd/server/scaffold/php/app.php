@@ -0,0 +1,75 @@
+<?php
+
+declare(strict_types=1);
+
+// The request handler. It is loaded once per worker and then called for
+// every request, so anything set up out here (connections, config, a
+// framework kernel) is reused until the worker is recycled.
+//
+// $request has id, method, path (with query string), headers (name =>
+// list of values), body and server (CGI-style $_SERVER entries). Return ['status' => ..., 'headers' => [...],
+// 'body' => ...]; the body may be a Generator to stream it in chunks.
+
+$booted = date(DATE_ATOM);
+
+return function (array $request) use ($booted): array {
+    $path = parse_url($request['path'], PHP_URL_PATH) ?: '/';
+
+    if (str_starts_with($path, '/stream/')) {
+        return [
+            'headers' => ['Content-Type' => 'text/plain; charset=UTF-8'],
+            'body'    => (function () {
+                for ($i = 1; $i <= 5; $i++) {
+                    yield "tick {$i}\n";
+                    usleep(500_000);
+                }
+            })(),
+        ];
+    }
+
+    if ($path === '/') {
+        return [
+            'status'  => 200,
+            'headers' => ['Content-Type' => 'text/html; charset=UTF-8'],
+            'body'    => <<<HTML
+                <!doctype html>
+                <html lang="en">
+                <head>
+                  <meta charset="utf-8">
+                  <title>It works</title>
+                  <link rel="stylesheet" href="/assets/app.css">
+                </head>
+                <body>
+                  <h1>It works</h1>
+                  <p>This page comes from <code>php/app.php</code>. The stylesheet is a static file from <code>public/assets/</code>.</p>
+                  <ul>
+                    <li><a href="/hello?name=you">/hello?name=you</a> returns JSON</li>
+                    <li><a href="/stream/ticks">/stream/ticks</a> streams its response in chunks</li>
+                  </ul>
+                </body>
+                </html>
+                HTML,
+        ];
+    }
+
+    if ($path === '/hello') {
+        parse_str((string) parse_url($request['path'], PHP_URL_QUERY), $query);
+        $name = $query['name'] ?? 'world';
+
+        return [
+            'status'  => 200,
+            'headers' => ['Content-Type' => 'application/json'],
+            'body'    => json_encode([
+                'hello'  => $name,
+                'worker' => getmypid(),
+                'booted' => $booted,
+            ]),
+        ];
+    }
+
+    return [
+        'status'  => 404,
+        'headers' => ['Content-Type' => 'text/plain; charset=UTF-8'],
+        'body'    => "Not Found\n",
+    ];
+};
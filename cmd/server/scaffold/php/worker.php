@@ -0,0 +1,224 @@
+<?php
+
+declare(strict_types=1);
+
+// -------------------------------------------------------------
+// PROTOCOL
+// -------------------------------------------------------------
+//
+// The app server talks to this process over stdin/stdout. Every message,
+// in both directions, is a 4-byte big-endian length followed by that many
+// bytes of JSON.
+//
+// Request (Go -> PHP):
+//   {"id": "...", "method": "GET", "path": "/users?page=2",
+//    "headers": {"Accept": ["text/html"], ...}, "body": "...",
+//    "server": {"REMOTE_ADDR": "203.0.113.9", "HTTPS": "on", ...}}
+//
+// A body that isn't valid UTF-8 travels as "body_base64" instead of "body",
+// in both directions.
+//
+// "server" holds CGI-style $_SERVER entries; multipart requests may also
+// carry decoded "form" fields and "files" (see uploads in the README).
+//
+// Response (PHP -> Go), one message:
+//   {"id": "...", "status": 200, "headers": {"Content-Type": "text/html"},
+//    "body": "..."}
+//
+// When the request has "relay_over", a response whose body is bigger may
+// instead be sent as the message above with "relay": true and an empty
+// body, followed by chunk and end frames as below (see send_buffered()).
+//
+// Streaming response, when the request carries "X-Go-Stream: 1" (the
+// server sets it for everything under /stream/): any number of frames,
+//   {"type": "headers", "status": 200, "headers": {"Content-Type": ["text/plain"]}}
+//   {"type": "chunk", "data": "..."}
+//   {"type": "end"}
+// or {"type": "error", "error": "..."} to abort. Each chunk is flushed to
+// the client as soon as it arrives.
+//
+// Anything written to stderr ends up in the server log (and in
+// /__baremetal/workers/{id}/logs). Never write to stdout outside of
+// send_message(): it would corrupt the stream.
+
+$stdin  = fopen('php://stdin', 'rb');
+$stdout = fopen('php://stdout', 'wb');
+$stderr = fopen('php://stderr', 'wb');
+
+/**
+ * Read exactly $length bytes, or null on EOF.
+ */
+function read_exact($stream, int $length): ?string
+{
+    $data = '';
+    while (strlen($data) < $length) {
+        $chunk = fread($stream, $length - strlen($data));
+        if ($chunk === '' || $chunk === false) {
+            return null;
+        }
+        $data .= $chunk;
+    }
+    return $data;
+}
+
+/**
+ * Write one length-prefixed JSON message to the server.
+ */
+function send_message(array $message): void
+{
+    global $stdout;
+
+    // Bodies that aren't UTF-8 (images, gzip, ...) can't be JSON strings.
+    if (isset($message['body']) && !preg_match('//u', $message['body'])) {
+        $message['body_base64'] = base64_encode($message['body']);
+        $message['body'] = '';
+    }
+
+    $json = json_encode($message, JSON_UNESCAPED_SLASHES | JSON_INVALID_UTF8_SUBSTITUTE);
+    if ($json === false) {
+        // Reads as a 500 response and as an error frame, so the server
+        // isn't left waiting whichever one it expected.
+        $json = json_encode([
+            'type'    => 'error',
+            'error'   => json_last_error_msg(),
+            'status'  => 500,
+            'headers' => (object) [],
+            'body'    => 'Internal Server Error',
+        ]);
+    }
+    fwrite($stdout, pack('N', strlen($json)) . $json);
+    fflush($stdout);
+}
+
+/**
+ * Send a buffered response. When the server allows it ("relay_over"), a
+ * text body bigger than that goes out as a head with "relay" set, then
+ * chunk frames, so the server can pass it on without holding all of it.
+ */
+function send_buffered(array $request, array $response): void
+{
+    $relayOver = (int) ($request['relay_over'] ?? 0);
+    $body = $response['body'];
+    if ($relayOver <= 0 || strlen($body) <= $relayOver || !preg_match('//u', $body)) {
+        send_message($response);
+        return;
+    }
+
+    send_message(['relay' => true, 'body' => ''] + $response);
+    // whole characters only: a chunk must be valid UTF-8 on its own
+    preg_match_all('/.{1,16384}/su', $body, $chunks);
+    foreach ($chunks[0] as $chunk) {
+        send_message(['type' => 'chunk', 'data' => $chunk]);
+    }
+    send_message(['type' => 'end']);
+}
+
+function wants_streaming(array $request): bool
+{
+    foreach ($request['headers'] ?? [] as $name => $values) {
+        if (strtolower((string) $name) === 'x-go-stream') {
+            return (((array) $values)[0] ?? null) === '1';
+        }
+    }
+    return false;
+}
+
+/**
+ * Stream a handler result as frames: a Generator body is sent one chunk
+ * per yield, anything else as a single chunk.
+ */
+function stream_result(array $result): void
+{
+    $headers = ['Content-Type' => ['text/plain; charset=UTF-8']];
+    foreach ($result['headers'] ?? [] as $name => $value) {
+        $headers[$name] = (array) $value;
+    }
+    $body = $result['body'] ?? '';
+
+    send_message(['type' => 'headers', 'status' => $result['status'] ?? 200, 'headers' => $headers]);
+    if ($body instanceof Generator) {
+        foreach ($body as $chunk) {
+            send_message(['type' => 'chunk', 'data' => (string) $chunk]);
+        }
+    } elseif ((string) $body !== '') {
+        send_message(['type' => 'chunk', 'data' => (string) $body]);
+    }
+    send_message(['type' => 'end']);
+}
+
+// -------------------------------------------------------------
+// APP
+// -------------------------------------------------------------
+//
+// app.php returns the request handler: fn(array $request): array
+
+$handler = require __DIR__ . '/app.php';
+
+// -------------------------------------------------------------
+// WORKER LOOP
+// -------------------------------------------------------------
+
+while (true) {
+    $header = read_exact($stdin, 4);
+    if ($header === null) {
+        break; // the server closed our stdin: shut down
+    }
+
+    $length  = unpack('Nlen', $header)['len'];
+    $json    = read_exact($stdin, $length);
+    $request = $json === null ? null : json_decode($json, true);
+    if (!is_array($request)) {
+        fwrite($stderr, "worker: unreadable request\n");
+        break; // the stream is out of sync; let the server restart us
+    }
+    if (isset($request['body_base64'])) {
+        $request['body'] = base64_decode($request['body_base64']);
+        unset($request['body_base64']);
+    }
+
+    $streaming = wants_streaming($request);
+    $relaying  = false;
+
+    try {
+        $result = $handler($request);
+
+        if ($streaming) {
+            stream_result($result);
+            continue;
+        }
+
+        $head = [
+            'id'      => $request['id'] ?? '',
+            'status'  => $result['status'] ?? 200,
+            'headers' => (object) ($result['headers'] ?? []),
+        ];
+        $body = $result['body'] ?? '';
+        if ($body instanceof Generator && ($request['relay_over'] ?? 0) > 0) {
+            // the size is unknown until the end: relay each chunk as it comes
+            send_message($head + ['relay' => true, 'body' => '']);
+            $relaying = true;
+            foreach ($body as $chunk) {
+                send_message(['type' => 'chunk', 'data' => (string) $chunk]);
+            }
+            send_message(['type' => 'end']);
+            continue;
+        }
+        if ($body instanceof Generator) {
+            $body = implode('', iterator_to_array($body, false));
+        }
+        send_buffered($request, $head + ['body' => (string) $body]);
+    } catch (Throwable $e) {
+        fwrite($stderr, 'worker: ' . get_class($e) . ': ' . $e->getMessage() . ' in ' . $e->getFile() . ':' . $e->getLine() . "\n");
+
+        if ($streaming || $relaying) {
+            send_message(['type' => 'error', 'error' => 'Internal Server Error']);
+            continue;
+        }
+        send_message([
+            'id'      => $request['id'] ?? '',
+            'status'  => 500,
+            'headers' => (object) ['Content-Type' => 'text/plain; charset=UTF-8'],
+            'body'    => 'Internal Server Error',
+        ]);
+    }
+}
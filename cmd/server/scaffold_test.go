@@ -0,0 +1,46 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go-php/appserver"
+)
+
+func TestInitCmd(t *testing.T) {
+	dir := t.TempDir()
+	var out strings.Builder
+	if code := initCmd([]string{"-dir", dir}, &out); code != 0 {
+		t.Fatalf("init exit code = %d:\n%s", code, out.String())
+	}
+	for _, f := range []string{"go_appserver.json", "php/worker.php", "php/app.php", "public/assets/app.css"} {
+		if _, err := os.Stat(filepath.Join(dir, f)); err != nil {
+			t.Errorf("%s not created: %v", f, err)
+		}
+	}
+	if problems := appserver.CheckConfig(filepath.Join(dir, "go_appserver.json")); len(problems) > 0 {
+		t.Errorf("generated config has problems: %v", problems)
+	}
+
+	// Existing files are kept unless -force is given.
+	app := filepath.Join(dir, "php", "app.php")
+	if err := os.WriteFile(app, []byte("<?php // mine"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	out.Reset()
+	if code := initCmd([]string{"-dir", dir}, &out); code != 0 || !strings.Contains(out.String(), "exists  php/app.php") {
+		t.Fatalf("second init: code %d, output:\n%s", code, out.String())
+	}
+	if data, _ := os.ReadFile(app); string(data) != "<?php // mine" {
+		t.Fatalf("init overwrote an existing file")
+	}
+
+	if code := initCmd([]string{"-dir", dir, "-force"}, &out); code != 0 {
+		t.Fatalf("init -force exit code = %d", code)
+	}
+	if data, _ := os.ReadFile(app); string(data) == "<?php // mine" {
+		t.Fatalf("init -force kept the existing file")
+	}
+}
@@ -3,12 +3,13 @@ module go-php
 go 1.25.2
 
 require (
+	github.com/BurntSushi/toml v1.5.0
 	github.com/fsnotify/fsnotify v1.9.0
+	github.com/golang-jwt/jwt/v5 v5.3.0
 	github.com/google/uuid v1.6.0
-)
-
-require (
-	github.com/golang-jwt/jwt/v5 v5.3.0 // indirect
-	github.com/gorilla/websocket v1.5.3 // indirect
-	golang.org/x/sys v0.13.0 // indirect
+	github.com/gorilla/websocket v1.5.3
+	github.com/tetratelabs/wazero v1.12.0
+	golang.org/x/crypto v0.45.0
+	golang.org/x/sys v0.44.0
+	gopkg.in/yaml.v3 v3.0.1
 )
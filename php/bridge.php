@@ -59,6 +59,11 @@ function build_server_array(array $payload): array
         $server[$key] = $valueString;
     }
 
+    // CGI-style params from the server (REMOTE_ADDR, HTTPS, SERVER_PORT, ...)
+    foreach ($payload['server'] ?? [] as $key => $value) {
+        $server[$key] = $value;
+    }
+
     return $server;
 }
 
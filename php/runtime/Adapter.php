@@ -0,0 +1,47 @@
+<?php
+
+declare(strict_types=1);
+
+namespace BareMetal\Runtime;
+
+/**
+ * An Adapter runs one framework inside a long-lived worker.
+ *
+ * boot() runs once when the worker starts; handle() once per request with
+ * the superglobals already populated (see globals.php); reset() after each
+ * response has been sent, to drop anything that must not leak into the next
+ * request (the authenticated user, the request object, scoped singletons).
+ */
+interface Adapter
+{
+    public function boot(): void;
+
+    /**
+     * @param array $request the decoded RequestPayload
+     * @return Response
+     */
+    public function handle(array $request): Response;
+
+    public function reset(): void;
+}
+
+/**
+ * What an adapter hands back to the worker loop.
+ */
+final class Response
+{
+    /**
+     * @param array<string, string[]> $headers
+     * @param string[]                $cookies Set-Cookie values
+     * @param string|callable         $body    a string, or a callable that
+     *                                         echoes the body (streamed
+     *                                         responses)
+     */
+    public function __construct(
+        public int $status,
+        public array $headers,
+        public array $cookies,
+        public mixed $body,
+    ) {
+    }
+}
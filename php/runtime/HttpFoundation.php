@@ -0,0 +1,80 @@
+<?php
+
+declare(strict_types=1);
+
+namespace BareMetal\Runtime;
+
+use Symfony\Component\HttpFoundation\BinaryFileResponse;
+use Symfony\Component\HttpFoundation\File\UploadedFile;
+use Symfony\Component\HttpFoundation\Request as SymfonyRequest;
+use Symfony\Component\HttpFoundation\Response as SymfonyResponse;
+use Symfony\Component\HttpFoundation\StreamedResponse;
+
+/**
+ * Conversions between the payload and symfony/http-foundation, which both
+ * Laravel and Symfony build their requests on.
+ */
+final class HttpFoundation
+{
+    /**
+     * Build a request from the populated superglobals. Unlike
+     * Request::createFromGlobals() this takes the body from the payload
+     * (php://input is the worker's protocol stream) and marks uploads as
+     * test files, since is_uploaded_file() is false for files the server
+     * saved.
+     */
+    public static function request(array $request): SymfonyRequest
+    {
+        $body = $request['body'] ?? '';
+        $post = $_POST;
+
+        $method = $_SERVER['REQUEST_METHOD'];
+        if (in_array($method, ['PUT', 'DELETE', 'PATCH'], true)) {
+            $post = request_fields($request, $_SERVER['CONTENT_TYPE'] ?? '');
+        }
+
+        return new SymfonyRequest($_GET, $post, [], $_COOKIE, self::files($request['files'] ?? []), $_SERVER, $body);
+    }
+
+    /**
+     * @return array nested like the field names, with UploadedFile leaves
+     *               (null for a file input left empty)
+     */
+    private static function files(array $uploads): array
+    {
+        $files = [];
+        foreach ($uploads as $upload) {
+            $file = null;
+            if (($upload['error'] ?? UPLOAD_ERR_NO_FILE) !== UPLOAD_ERR_NO_FILE) {
+                $file = new UploadedFile(
+                    $upload['tmp_name'],
+                    $upload['name'],
+                    $upload['type'] !== '' ? $upload['type'] : null,
+                    $upload['error'],
+                    true,
+                );
+            }
+            set_path($files, field_path((string) $upload['field']), $file);
+        }
+        return $files;
+    }
+
+    public static function response(SymfonyResponse $response): Response
+    {
+        $cookies = [];
+        foreach ($response->headers->getCookies() as $cookie) {
+            $cookies[] = (string) $cookie;
+        }
+
+        $body = $response instanceof StreamedResponse || $response instanceof BinaryFileResponse
+            ? static fn () => $response->sendContent()
+            : (string) $response->getContent();
+
+        return new Response(
+            $response->getStatusCode(),
+            $response->headers->allPreserveCaseWithoutCookies(),
+            $cookies,
+            $body,
+        );
+    }
+}
@@ -0,0 +1,84 @@
+<?php
+
+declare(strict_types=1);
+
+namespace BareMetal\Runtime;
+
+use Illuminate\Contracts\Http\Kernel;
+use Illuminate\Foundation\Application;
+use Illuminate\Http\Request;
+use Illuminate\Support\Facades\Facade;
+
+/**
+ * Runs a Laravel application: bootstrap/app.php is loaded once, and each
+ * request goes through the HTTP kernel. Between requests the state Octane
+ * also resets is flushed, so users, sessions and scoped services don't
+ * leak from one request to the next.
+ */
+final class LaravelAdapter implements Adapter
+{
+    private Application $app;
+    private Kernel $kernel;
+    private ?array $pending = null;
+
+    public function __construct(private string $basePath)
+    {
+    }
+
+    public function boot(): void
+    {
+        require_once $this->basePath . '/vendor/autoload.php';
+
+        $this->app = require $this->basePath . '/bootstrap/app.php';
+        $this->kernel = $this->app->make(Kernel::class);
+        $this->kernel->bootstrap();
+    }
+
+    public function handle(array $request): Response
+    {
+        $laravelRequest = Request::createFromBase(HttpFoundation::request($request));
+
+        $response = $this->kernel->handle($laravelRequest);
+        $this->pending = [$laravelRequest, $response];
+
+        return HttpFoundation::response($response);
+    }
+
+    public function reset(): void
+    {
+        $app = $this->app;
+
+        // Terminable middleware and terminating callbacks run once the
+        // response is out, as under FPM.
+        if ($this->pending !== null) {
+            [$request, $response] = $this->pending;
+            $this->pending = null;
+            $this->kernel->terminate($request, $response);
+        }
+
+        if ($app->resolved('auth')) {
+            $app->make('auth')->forgetGuards();
+        }
+        if ($app->resolved('session')) {
+            $session = $app->make('session')->driver();
+            $session->flush();
+            $session->regenerate();
+        }
+        if ($app->resolved('cookie')) {
+            foreach ($app->make('cookie')->getQueuedCookies() as $cookie) {
+                $app->make('cookie')->unqueue($cookie->getName());
+            }
+        }
+        if ($app->resolved('translator')) {
+            $app->make('translator')->setLocale($app->make('config')->get('app.locale'));
+        }
+        if (method_exists($app, 'forgetScopedInstances')) {
+            $app->forgetScopedInstances();
+        }
+
+        $app->forgetInstance('request');
+        Facade::clearResolvedInstance('request');
+        Facade::clearResolvedInstance('session');
+        Facade::clearResolvedInstance('auth');
+    }
+}
@@ -0,0 +1,60 @@
+<?php
+
+declare(strict_types=1);
+
+namespace BareMetal\Runtime;
+
+use Symfony\Component\Dotenv\Dotenv;
+use Symfony\Component\HttpKernel\KernelInterface;
+use Symfony\Component\HttpKernel\TerminableInterface;
+
+/**
+ * Runs a Symfony application: the kernel (App\Kernel by default) is booted
+ * once and handles every request. Symfony resets services tagged
+ * kernel.reset itself at the start of each request after the first, which
+ * is what keeps request state from leaking here.
+ */
+final class SymfonyAdapter implements Adapter
+{
+    private KernelInterface $kernel;
+    private ?array $pending = null;
+
+    public function __construct(private string $basePath, private string $kernelClass = 'App\\Kernel')
+    {
+    }
+
+    public function boot(): void
+    {
+        require_once $this->basePath . '/vendor/autoload.php';
+
+        if (class_exists(Dotenv::class) && is_file($this->basePath . '/.env')) {
+            (new Dotenv())->bootEnv($this->basePath . '/.env');
+        }
+
+        $env = $_SERVER['APP_ENV'] ?? $_ENV['APP_ENV'] ?? 'prod';
+        $debug = (bool) ($_SERVER['APP_DEBUG'] ?? $_ENV['APP_DEBUG'] ?? $env !== 'prod');
+
+        $this->kernel = new ($this->kernelClass)($env, $debug);
+        $this->kernel->boot();
+    }
+
+    public function handle(array $request): Response
+    {
+        $symfonyRequest = HttpFoundation::request($request);
+
+        $response = $this->kernel->handle($symfonyRequest);
+        $this->pending = [$symfonyRequest, $response];
+
+        return HttpFoundation::response($response);
+    }
+
+    public function reset(): void
+    {
+        // kernel.terminate listeners run once the response is out.
+        if ($this->pending !== null && $this->kernel instanceof TerminableInterface) {
+            [$request, $response] = $this->pending;
+            $this->kernel->terminate($request, $response);
+        }
+        $this->pending = null;
+    }
+}
@@ -0,0 +1,259 @@
+<?php
+
+declare(strict_types=1);
+
+namespace BareMetal\Runtime;
+
+/**
+ * Populate $_SERVER, $_GET, $_POST, $_COOKIE, $_FILES and $_REQUEST from a
+ * RequestPayload, the way SAPI would have before running a script.
+ *
+ * $base is the worker's own $_SERVER at startup (environment variables,
+ * argv and so on), which every request starts from so nothing from the
+ * previous request survives.
+ */
+function populate_globals(array $request, array $base): void
+{
+    $_SERVER = server_vars($request, $base);
+
+    $query = (string) parse_url($request['path'] ?? '/', PHP_URL_QUERY);
+    parse_str($query, $_GET);
+
+    // Like PHP itself, only POST bodies fill $_POST.
+    $_POST = [];
+    if ($_SERVER['REQUEST_METHOD'] === 'POST') {
+        $_POST = request_fields($request, $_SERVER['CONTENT_TYPE'] ?? '');
+    }
+
+    $_FILES = files_array($request['files'] ?? []);
+    $_COOKIE = parse_cookies($_SERVER['HTTP_COOKIE'] ?? '');
+    $_REQUEST = array_merge($_GET, $_POST);
+}
+
+function server_vars(array $request, array $base): array
+{
+    $uri = $request['path'] ?? '/';
+    $path = parse_url($uri, PHP_URL_PATH) ?: '/';
+    $script = $base['SCRIPT_FILENAME'] ?? '';
+
+    $server = [
+        'REQUEST_METHOD'  => strtoupper($request['method'] ?? 'GET'),
+        'REQUEST_URI'     => $uri,
+        'QUERY_STRING'    => (string) parse_url($uri, PHP_URL_QUERY),
+        'PATH_INFO'       => $path,
+        'SCRIPT_NAME'     => '/index.php',
+        'PHP_SELF'        => '/index.php' . $path,
+        'SCRIPT_FILENAME' => $script,
+        'REQUEST_TIME'    => time(),
+        'REQUEST_TIME_FLOAT' => microtime(true),
+        'SERVER_PROTOCOL' => 'HTTP/1.1',
+        'SERVER_SOFTWARE' => 'go-php',
+        'GATEWAY_INTERFACE' => 'CGI/1.1',
+    ] + $base;
+
+    foreach ($request['headers'] ?? [] as $name => $values) {
+        $name = (string) $name;
+        // X_Foo would overwrite X-Foo's HTTP_X_FOO, and a client's Proxy
+        // header would become HTTP_PROXY, which HTTP clients obey (httpoxy).
+        if (str_contains($name, '_') || strcasecmp($name, 'Proxy') === 0) {
+            continue;
+        }
+        $value = implode(', ', (array) $values);
+        $key = strtoupper(str_replace('-', '_', $name));
+
+        if ($key === 'CONTENT_TYPE' || $key === 'CONTENT_LENGTH') {
+            $server[$key] = $value;
+            continue;
+        }
+        $server['HTTP_' . $key] = $value;
+    }
+
+    if (isset($server['HTTP_HOST'])) {
+        $host = $server['HTTP_HOST'];
+        $port = null;
+        if (preg_match('/^(.*):(\d+)$/', $host, $m)) {
+            [$host, $port] = [$m[1], $m[2]];
+        }
+        $server['SERVER_NAME'] = trim($host, '[]');
+        if ($port !== null) {
+            $server['SERVER_PORT'] = $port;
+        }
+    }
+
+    $server = array_merge($server, $request['server'] ?? []);
+    if (isset($server['REQUEST_TIME'])) {
+        $server['REQUEST_TIME'] = (int) $server['REQUEST_TIME'];
+        $server['REQUEST_TIME_FLOAT'] = (float) $server['REQUEST_TIME_FLOAT'];
+    }
+
+    // Behind a trusted proxy the client is the last X-Forwarded-For hop
+    // that isn't one of the proxies. Anyone else could claim any address.
+    $trusted = trusted_proxies($base);
+    if (isset($server['REMOTE_ADDR'], $server['HTTP_X_FORWARDED_FOR'])
+        && ip_in_any($server['REMOTE_ADDR'], $trusted)) {
+        $hops = array_map('trim', explode(',', $server['HTTP_X_FORWARDED_FOR']));
+        // BuildPayload appends the direct peer, which we already have.
+        if (end($hops) === $server['REMOTE_ADDR']) {
+            array_pop($hops);
+        }
+        while (($hop = array_pop($hops)) !== null) {
+            if (filter_var($hop, FILTER_VALIDATE_IP) === false) {
+                break;
+            }
+            $server['REMOTE_ADDR'] = $hop;
+            if (!ip_in_any($hop, $trusted)) {
+                break;
+            }
+        }
+    }
+
+    return $server;
+}
+
+/**
+ * The proxies allowed to set the client address through X-Forwarded-For:
+ * comma-separated addresses or CIDR ranges in BAREMETAL_TRUSTED_PROXIES.
+ */
+function trusted_proxies(array $base): array
+{
+    $list = $base['BAREMETAL_TRUSTED_PROXIES'] ?? getenv('BAREMETAL_TRUSTED_PROXIES');
+    if (!is_string($list) || $list === '') {
+        return [];
+    }
+    return array_values(array_filter(array_map('trim', explode(',', $list))));
+}
+
+function ip_in_any(string $ip, array $ranges): bool
+{
+    $addr = @inet_pton($ip);
+    if ($addr === false) {
+        return false;
+    }
+    foreach ($ranges as $range) {
+        [$net, $bits] = str_contains($range, '/') ? explode('/', $range, 2) : [$range, null];
+        $netAddr = @inet_pton($net);
+        if ($netAddr === false || strlen($netAddr) !== strlen($addr)) {
+            continue;
+        }
+        $bits = $bits === null ? strlen($addr) * 8 : min(max((int) $bits, 0), strlen($addr) * 8);
+        $bytes = intdiv($bits, 8);
+        if (substr($addr, 0, $bytes) !== substr($netAddr, 0, $bytes)) {
+            continue;
+        }
+        $rest = $bits % 8;
+        if ($rest === 0) {
+            return true;
+        }
+        $mask = (0xff << (8 - $rest)) & 0xff;
+        if ((ord($addr[$bytes]) & $mask) === (ord($netAddr[$bytes]) & $mask)) {
+            return true;
+        }
+    }
+    return false;
+}
+
+/**
+ * The form fields of a request body: decoded multipart fields (uploads.parse)
+ * or an urlencoded body.
+ */
+function request_fields(array $request, string $contentType): array
+{
+    if (isset($request['form'])) {
+        return form_fields($request['form']);
+    }
+    if (str_starts_with(strtolower($contentType), 'application/x-www-form-urlencoded')) {
+        parse_str($request['body'] ?? '', $fields);
+        return $fields;
+    }
+    return [];
+}
+
+/**
+ * Turn decoded multipart fields into $_POST, honouring PHP's bracket
+ * syntax ("tags[]", "user[name]").
+ *
+ * @param array<string, string[]> $form
+ */
+function form_fields(array $form): array
+{
+    $pairs = [];
+    foreach ($form as $name => $values) {
+        foreach ((array) $values as $value) {
+            $pairs[] = rawurlencode((string) $name) . '=' . rawurlencode((string) $value);
+        }
+    }
+    parse_str(implode('&', $pairs), $post);
+    return $post;
+}
+
+/**
+ * Build $_FILES from the server's upload list, in PHP's own layout: for
+ * "docs[]" that is $_FILES['docs']['name'][0], not $_FILES['docs'][0]['name'].
+ */
+function files_array(array $uploads): array
+{
+    $files = [];
+    foreach ($uploads as $upload) {
+        $path = field_path((string) $upload['field']);
+        $top = array_shift($path);
+
+        foreach (['name', 'type', 'tmp_name', 'error', 'size'] as $key) {
+            $value = $upload[$key] ?? ($key === 'error' ? UPLOAD_ERR_NO_FILE : '');
+            if ($path === []) {
+                $files[$top][$key] = $value;
+                continue;
+            }
+            $files[$top][$key] ??= [];
+            set_path($files[$top][$key], $path, $value);
+        }
+    }
+    return $files;
+}
+
+/**
+ * Split "a[b][]" into ['a', 'b', ''].
+ */
+function field_path(string $field): array
+{
+    $open = strpos($field, '[');
+    if ($open === false) {
+        return [$field];
+    }
+    preg_match_all('/\[([^\]]*)\]/', substr($field, $open), $m);
+    return array_merge([substr($field, 0, $open)], $m[1]);
+}
+
+function set_path(array &$target, array $path, mixed $value): void
+{
+    $key = array_shift($path);
+    if ($path === []) {
+        if ($key === '') {
+            $target[] = $value;
+        } else {
+            $target[$key] = $value;
+        }
+        return;
+    }
+    if ($key === '') {
+        $target[] = [];
+        $key = array_key_last($target);
+    }
+    $target[$key] ??= [];
+    set_path($target[$key], $path, $value);
+}
+
+function parse_cookies(string $header): array
+{
+    $cookies = [];
+    foreach (explode(';', $header) as $pair) {
+        if (!str_contains($pair, '=')) {
+            continue;
+        }
+        [$name, $value] = explode('=', $pair, 2);
+        $name = trim($name);
+        if ($name !== '' && !isset($cookies[$name])) {
+            $cookies[$name] = urldecode(trim($value));
+        }
+    }
+    return $cookies;
+}
@@ -0,0 +1,255 @@
+<?php
+
+declare(strict_types=1);
+
+namespace BareMetal\Runtime;
+
+use Throwable;
+
+// -------------------------------------------------------------
+// FRAMEWORK WORKER
+// -------------------------------------------------------------
+//
+// Runs an existing Laravel or Symfony app under the app server:
+//
+//   "pools": [{ "name": "fast", "workers": 4,
+//               "worker_script": "php/runtime/worker.php",
+//               "php_ini": { "baremetal.adapter": "laravel" } }]
+//
+// baremetal.adapter is "laravel", "symfony" or the class name of your own
+// BareMetal\Runtime\Adapter; left out, it is detected from the app layout.
+// baremetal.root is the app directory (default: the worker's cwd, which is
+// the project or pool root).
+//
+// Each request: the superglobals are rebuilt from the payload, the adapter
+// handles it, the response goes back to the server, then the adapter resets
+// per-request state before the next one.
+
+require __DIR__ . '/Adapter.php';
+require __DIR__ . '/globals.php';
+require __DIR__ . '/HttpFoundation.php';
+require __DIR__ . '/LaravelAdapter.php';
+require __DIR__ . '/SymfonyAdapter.php';
+
+$stdin  = fopen('php://stdin', 'rb');
+$stdout = fopen('php://stdout', 'wb');
+$stderr = fopen('php://stderr', 'wb');
+
+function make_adapter(string $name, string $root): Adapter
+{
+    if ($name === '') {
+        $name = match (true) {
+            is_file($root . '/artisan') => 'laravel',
+            is_file($root . '/bin/console') => 'symfony',
+            default => throw new \RuntimeException("no Laravel or Symfony app found in {$root}; set baremetal.adapter"),
+        };
+    }
+
+    return match (strtolower($name)) {
+        'laravel' => new LaravelAdapter($root),
+        'symfony' => new SymfonyAdapter($root),
+        default   => new $name($root),
+    };
+}
+
+function read_exact($stream, int $length): ?string
+{
+    $data = '';
+    while (strlen($data) < $length) {
+        $chunk = fread($stream, $length - strlen($data));
+        if ($chunk === '' || $chunk === false) {
+            return null;
+        }
+        $data .= $chunk;
+    }
+    return $data;
+}
+
+function send_message(array $message): void
+{
+    global $stdout;
+
+    // Bodies that aren't UTF-8 (images, gzip, ...) can't be JSON strings.
+    if (isset($message['body']) && !preg_match('//u', $message['body'])) {
+        $message['body_base64'] = base64_encode($message['body']);
+        $message['body'] = '';
+    }
+
+    $json = json_encode($message, JSON_UNESCAPED_SLASHES | JSON_INVALID_UTF8_SUBSTITUTE);
+    fwrite($stdout, pack('N', strlen($json)) . $json);
+    fflush($stdout);
+}
+
+/**
+ * Send a buffered response. When the server allows it ("relay_over"), a
+ * text body bigger than that goes out as a head with "relay" set, then
+ * chunk frames, so the server can pass it on without holding all of it.
+ */
+function send_buffered(array $request, array $response): void
+{
+    $relayOver = (int) ($request['relay_over'] ?? 0);
+    $body = $response['body'];
+    if ($relayOver <= 0 || strlen($body) <= $relayOver || !preg_match('//u', $body)) {
+        send_message($response);
+        return;
+    }
+
+    send_message(['relay' => true, 'body' => ''] + $response);
+    // whole characters only: a chunk must be valid UTF-8 on its own
+    preg_match_all('/.{1,16384}/su', $body, $chunks);
+    foreach ($chunks[0] as $chunk) {
+        send_message(['type' => 'chunk', 'data' => $chunk]);
+    }
+    send_message(['type' => 'end']);
+}
+
+function wants_streaming(array $request): bool
+{
+    foreach ($request['headers'] ?? [] as $name => $values) {
+        if (strtolower((string) $name) === 'x-go-stream') {
+            return (((array) $values)[0] ?? null) === '1';
+        }
+    }
+    return false;
+}
+
+/**
+ * Run $fn and return what it echoed. Output goes through a callback rather
+ * than a plain buffer so that even ob_flush() calls can't reach stdout.
+ */
+function capture_output(callable $fn, mixed &$result = null): string
+{
+    $output = '';
+    ob_start(static function (string $buffer) use (&$output): string {
+        $output .= $buffer;
+        return '';
+    });
+    try {
+        $result = $fn();
+    } finally {
+        ob_end_flush();
+    }
+    return $output;
+}
+
+function send_response(array $request, Response $response): void
+{
+    $body = $response->body;
+    if (is_callable($body)) {
+        $body = capture_output($body);
+    }
+
+    $headers = [];
+    foreach ($response->headers as $name => $values) {
+        $headers[$name] = implode(', ', (array) $values);
+    }
+
+    send_buffered($request, [
+        'id'      => $request['id'] ?? '',
+        'status'  => $response->status,
+        'headers' => (object) $headers,
+        'cookies' => $response->cookies,
+        'body'    => (string) $body,
+    ]);
+}
+
+function send_stream(Response $response): void
+{
+    $headers = $response->headers;
+    if ($response->cookies !== []) {
+        $headers['Set-Cookie'] = $response->cookies;
+    }
+    send_message(['type' => 'headers', 'status' => $response->status, 'headers' => (object) $headers]);
+
+    if (is_callable($response->body)) {
+        // Every flush from a streamed response becomes a chunk.
+        ob_start(static function (string $buffer): string {
+            if ($buffer !== '') {
+                send_message(['type' => 'chunk', 'data' => $buffer]);
+            }
+            return '';
+        }, 8192);
+        try {
+            ($response->body)();
+        } finally {
+            ob_end_flush();
+        }
+    } elseif ($response->body !== '') {
+        send_message(['type' => 'chunk', 'data' => (string) $response->body]);
+    }
+    send_message(['type' => 'end']);
+}
+
+// -------------------------------------------------------------
+// BOOT
+// -------------------------------------------------------------
+
+$root = (string) (get_cfg_var('baremetal.root') ?: getcwd());
+try {
+    $adapter = make_adapter((string) (get_cfg_var('baremetal.adapter') ?: ''), $root);
+    $adapter->boot();
+} catch (Throwable $e) {
+    fwrite($stderr, 'PHP Fatal error:  worker: boot failed: ' . $e->getMessage() . ' in ' . $e->getFile() . ':' . $e->getLine() . "\n");
+    exit(255);
+}
+
+$base = $_SERVER;
+
+// -------------------------------------------------------------
+// WORKER LOOP
+// -------------------------------------------------------------
+
+while (true) {
+    $header = read_exact($stdin, 4);
+    if ($header === null) {
+        break;
+    }
+
+    $json = read_exact($stdin, unpack('Nlen', $header)['len']);
+    $request = $json === null ? null : json_decode($json, true);
+    if (!is_array($request)) {
+        fwrite($stderr, "worker: unreadable request\n");
+        break;
+    }
+    if (isset($request['body_base64'])) {
+        $request['body'] = base64_decode($request['body_base64']);
+        unset($request['body_base64']);
+    }
+
+    populate_globals($request, $base);
+    $streaming = wants_streaming($request);
+
+    // Anything echoed outside a response would corrupt the protocol stream.
+    $response = null;
+    try {
+        $stray = capture_output(static fn () => $adapter->handle($request), $response);
+        if ($stray !== '') {
+            fwrite($stderr, 'worker: discarded ' . strlen($stray) . " bytes echoed outside the response\n");
+        }
+    } catch (Throwable $e) {
+        fwrite($stderr, 'worker: ' . get_class($e) . ': ' . $e->getMessage() . ' in ' . $e->getFile() . ':' . $e->getLine() . "\n");
+        $response = new Response(500, ['Content-Type' => ['text/plain; charset=UTF-8']], [], 'Internal Server Error');
+    }
+
+    try {
+        if ($streaming) {
+            send_stream($response);
+        } else {
+            send_response($request, $response);
+        }
+    } catch (Throwable $e) {
+        fwrite($stderr, 'worker: sending response: ' . $e->getMessage() . "\n");
+        if ($streaming) {
+            send_message(['type' => 'error', 'error' => 'Internal Server Error']);
+        } else {
+            send_response($request, new Response(500, ['Content-Type' => ['text/plain; charset=UTF-8']], [], 'Internal Server Error'));
+        }
+    }
+
+    try {
+        $adapter->reset();
+    } catch (Throwable $e) {
+        fwrite($stderr, 'worker: reset failed, exiting: ' . $e->getMessage() . "\n");
+        break; // a worker in an unknown state must not take more requests
+    }
+}
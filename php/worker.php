@@ -74,6 +74,20 @@ function worker_wants_streaming(array $payload): bool
     return false;
 }
 
+/**
+ * Write one length-prefixed JSON message to $stdout.
+ */
+function worker_send($stdout, array $message): bool
+{
+    $json = json_encode($message);
+    if ($json === false) {
+        return false;
+    }
+    fwrite($stdout, pack("N", strlen($json)) . $json);
+    fflush($stdout);
+    return true;
+}
+
 // -------------------------------------------------------------
 // WORKER LOOP
 // -------------------------------------------------------------
@@ -115,6 +129,12 @@ while (true) {
         continue;
     }
 
+    // Binary bodies arrive base64-encoded
+    if (isset($payload['body_base64'])) {
+        $payload['body'] = base64_decode($payload['body_base64']);
+        unset($payload['body_base64']);
+    }
+
     // ----- 3. Decide streaming vs non-streaming -----
     $streaming = worker_wants_streaming($payload);
 
@@ -165,18 +185,31 @@ while (true) {
         'id'      => $payload['id'] ?? null,
         'status'  => $result['status'] ?? 200,
         'headers' => $headersObject,
-        'body'    => $result['body'] ?? '',
+        'body'    => (string) ($result['body'] ?? ''),
     ];
 
-    $outJson = json_encode($response);
-    if ($outJson === false) {
-        fwrite($stderr, "worker: json_encode failed: " . json_last_error_msg() . "\n");
-        continue;
+    // ...and leave the same way, since JSON strings must be UTF-8
+    if (!preg_match('//u', $response['body'])) {
+        $response['body_base64'] = base64_encode($response['body']);
+        $response['body'] = '';
     }
 
-    $outLen = pack("N", strlen($outJson));
+    // Big text bodies may go in chunks after the head if Go asked for it
+    $relayOver = (int) ($payload['relay_over'] ?? 0);
+    if ($relayOver > 0 && strlen($response['body']) > $relayOver) {
+        $body = $response['body'];
+        $response['body'] = '';
+        $response['relay'] = true;
+        worker_send($stdout, $response);
+        preg_match_all('/.{1,16384}/su', $body, $chunks);
+        foreach ($chunks[0] as $chunk) {
+            worker_send($stdout, ['type' => 'chunk', 'data' => $chunk]);
+        }
+        worker_send($stdout, ['type' => 'end']);
+        continue;
+    }
 
-    fwrite($stdout, $outLen);
-    fwrite($stdout, $outJson);
-    fflush($stdout);
+    if (!worker_send($stdout, $response)) {
+        fwrite($stderr, "worker: json_encode failed: " . json_last_error_msg() . "\n");
+    }
 }
@@ -0,0 +1,308 @@
+package server
+
+import (
+	"container/list"
+	"math"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// AdaptiveConfig tunes how RecordLatency moves path prefixes between pools.
+// A prefix whose average latency reaches PromoteMs over at least MinSamples
+// requests is routed to the slow pool; once it drops below DemoteMs it goes
+// back. The gap between the two keeps a prefix near the threshold from
+// flapping. The average is exponentially decayed: a sample's weight falls
+// by Decay for every WindowMs of age, so a route recovers from a bad spell.
+//
+// At most MaxRoutes prefixes are tracked. Past that the least recently
+// seen one that isn't promoted is forgotten, as is any prefix not seen for
+// routeIdleWindows windows, so random paths can't grow the table forever.
+type AdaptiveConfig struct {
+	Disabled   bool    `json:"disabled"`
+	PromoteMs  int     `json:"promote_ms"`  // default 500
+	DemoteMs   int     `json:"demote_ms"`   // default 60% of PromoteMs
+	MinSamples int     `json:"min_samples"` // default 10
+	WindowMs   int     `json:"window_ms"`   // default 60000
+	Decay      float64 `json:"decay"`       // weight kept per WindowMs, 0-1 (default 0.5)
+	MaxRoutes  int     `json:"max_routes"`  // default 1000
+}
+
+// AdaptiveEvent records a prefix being promoted to or demoted from the slow pool.
+type AdaptiveEvent struct {
+	Prefix       string    `json:"prefix"`
+	Action       string    `json:"action"` // "promote" or "demote"
+	AvgLatencyMs float64   `json:"avg_latency_ms"`
+	Samples      float64   `json:"samples"` // decayed sample count
+	At           time.Time `json:"at"`
+}
+
+// AdaptiveState is the current adaptive routing table plus recent events.
+type AdaptiveState struct {
+	Config   AdaptiveConfig  `json:"config"`
+	Promoted []string        `json:"promoted"`
+	Events   []AdaptiveEvent `json:"events"`
+}
+
+const maxAdaptiveEvents = 100
+
+// routeIdleWindows is how many windows a prefix goes unseen before its
+// stats are dropped; by then they have decayed to almost nothing.
+const routeIdleWindows = 10
+
+type routeStats struct {
+	count        float64 // decayed sample count
+	totalLatency float64 // decayed sum, in nanoseconds
+	updated      time.Time
+
+	prefix   string
+	lastSeen time.Time
+	el       *list.Element // in Server.routeLRU
+}
+
+func (c AdaptiveConfig) withDefaults() AdaptiveConfig {
+	if c.PromoteMs <= 0 {
+		c.PromoteMs = 500
+	}
+	if c.DemoteMs <= 0 {
+		c.DemoteMs = c.PromoteMs * 3 / 5
+	}
+	if c.DemoteMs > c.PromoteMs {
+		c.DemoteMs = c.PromoteMs
+	}
+	if c.MinSamples <= 0 {
+		c.MinSamples = 10
+	}
+	if c.WindowMs <= 0 {
+		c.WindowMs = 60_000
+	}
+	if c.Decay <= 0 || c.Decay > 1 {
+		c.Decay = 0.5
+	}
+	if c.MaxRoutes <= 0 {
+		c.MaxRoutes = 1000
+	}
+	return c
+}
+
+// decay ages the samples from updated to now, by Decay per window.
+func (rs *routeStats) decay(now time.Time, cfg AdaptiveConfig) {
+	if !rs.updated.IsZero() && now.After(rs.updated) {
+		windows := float64(now.Sub(rs.updated)) / float64(time.Duration(cfg.WindowMs)*time.Millisecond)
+		f := math.Pow(cfg.Decay, windows)
+		rs.count *= f
+		rs.totalLatency *= f
+	}
+	if now.After(rs.updated) {
+		rs.updated = now
+	}
+}
+
+// routePrefix keys latency by the first path segment: /users/1 -> /users.
+func routePrefix(path string) string {
+	prefix := path
+	if strings.HasPrefix(prefix, "/") {
+		slash := strings.Index(prefix[1:], "/")
+		if slash != -1 {
+			prefix = prefix[:slash+1]
+		}
+	}
+	return prefix
+}
+
+// RecordLatency feeds one request's latency into the adaptive router.
+func (s *Server) RecordLatency(path string, d time.Duration) {
+	cfg := s.slowCfg.Adaptive.withDefaults()
+	if cfg.Disabled {
+		return
+	}
+	prefix := routePrefix(path)
+	now := time.Now()
+
+	s.routeMu.Lock()
+	defer s.routeMu.Unlock()
+
+	rs := s.routeStatsFor(prefix, now, cfg)
+	rs.decay(now, cfg)
+	rs.count++
+	rs.totalLatency += float64(d)
+
+	// Rounded: MinSamples requests in quick succession have decayed a
+	// hair below MinSamples by the last one.
+	if math.Round(rs.count) < float64(cfg.MinSamples) {
+		return
+	}
+	avg := time.Duration(rs.totalLatency / rs.count)
+
+	switch {
+	case avg >= time.Duration(cfg.PromoteMs)*time.Millisecond && !s.hasSlowPrefix(prefix):
+		s.slowCfg.RoutePrefixes = append(s.slowCfg.RoutePrefixes, prefix)
+		if s.promoted == nil {
+			s.promoted = make(map[string]bool)
+		}
+		s.promoted[prefix] = true
+		s.recordAdaptiveEvent(prefix, "promote", avg, rs.count, now)
+		logTo(s.logger).Printf("[adaptive] promoting prefix %q to slow pool (avg=%v, count=%.0f)", prefix, avg, rs.count)
+
+	case avg < time.Duration(cfg.DemoteMs)*time.Millisecond && s.promoted[prefix]:
+		// Build a new slice: IsSlowRequest may still be reading the old one.
+		kept := make([]string, 0, len(s.slowCfg.RoutePrefixes))
+		for _, p := range s.slowCfg.RoutePrefixes {
+			if p != prefix {
+				kept = append(kept, p)
+			}
+		}
+		s.slowCfg.RoutePrefixes = kept
+		delete(s.promoted, prefix)
+		s.recordAdaptiveEvent(prefix, "demote", avg, rs.count, now)
+		logTo(s.logger).Printf("[adaptive] demoting prefix %q back to fast pool (avg=%v, count=%.0f)", prefix, avg, rs.count)
+	}
+}
+
+// routeStatsFor returns prefix's stats, making room for them if they are
+// new, and marks prefix as seen at now. Callers hold routeMu.
+func (s *Server) routeStatsFor(prefix string, now time.Time, cfg AdaptiveConfig) *routeStats {
+	if s.routeStats == nil {
+		s.routeStats = make(map[string]*routeStats)
+	}
+	if s.routeLRU == nil {
+		s.routeLRU = list.New()
+	}
+	if rs := s.routeStats[prefix]; rs != nil {
+		rs.lastSeen = now
+		if rs.el == nil {
+			rs.el = s.routeLRU.PushFront(rs)
+		} else {
+			s.routeLRU.MoveToFront(rs.el)
+		}
+		return rs
+	}
+
+	// Oldest first; promoted prefixes stay, or they could never be demoted.
+	idle := time.Duration(cfg.WindowMs) * time.Millisecond * routeIdleWindows
+	for el := s.routeLRU.Back(); el != nil; {
+		old := el.Value.(*routeStats)
+		prev := el.Prev()
+		full := s.routeLRU.Len() >= cfg.MaxRoutes
+		if !full && now.Sub(old.lastSeen) < idle {
+			break
+		}
+		if !s.promoted[old.prefix] {
+			s.routeLRU.Remove(el)
+			delete(s.routeStats, old.prefix)
+		}
+		el = prev
+	}
+
+	rs := &routeStats{prefix: prefix, lastSeen: now}
+	rs.el = s.routeLRU.PushFront(rs)
+	s.routeStats[prefix] = rs
+	return rs
+}
+
+// recordAdaptiveEvent appends to the event log; callers hold routeMu.
+func (s *Server) recordAdaptiveEvent(prefix, action string, avg time.Duration, samples float64, at time.Time) {
+	s.routeEvents = append(s.routeEvents, AdaptiveEvent{
+		Prefix:       prefix,
+		Action:       action,
+		AvgLatencyMs: float64(avg) / float64(time.Millisecond),
+		Samples:      samples,
+		At:           at,
+	})
+	if n := len(s.routeEvents); n > maxAdaptiveEvents {
+		s.routeEvents = append(s.routeEvents[:0:0], s.routeEvents[n-maxAdaptiveEvents:]...)
+	}
+}
+
+func (s *Server) hasSlowPrefix(prefix string) bool {
+	for _, p := range s.slowCfg.RoutePrefixes {
+		if p == prefix {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Adaptive returns the prefixes currently promoted by RecordLatency and the
+// most recent promotion/demotion events.
+func (s *Server) Adaptive() AdaptiveState {
+	s.routeMu.Lock()
+	defer s.routeMu.Unlock()
+
+	state := AdaptiveState{
+		Config:   s.slowCfg.Adaptive.withDefaults(),
+		Promoted: make([]string, 0, len(s.promoted)),
+		Events:   append([]AdaptiveEvent{}, s.routeEvents...),
+	}
+	for p := range s.promoted {
+		state.Promoted = append(state.Promoted, p)
+	}
+	sort.Strings(state.Promoted)
+	return state
+}
+
+// LearnedRoute is a prefix RecordLatency promoted, with the decayed stats
+// that got it there, as saved across restarts.
+type LearnedRoute struct {
+	Prefix       string    `json:"prefix"`
+	AvgLatencyMs float64   `json:"avg_latency_ms"`
+	Samples      float64   `json:"samples"`
+	Updated      time.Time `json:"updated"`
+}
+
+// LearnedRoutes returns the prefixes currently promoted by RecordLatency,
+// sorted, for RestoreLearnedRoutes to pick up after a restart.
+func (s *Server) LearnedRoutes() []LearnedRoute {
+	s.routeMu.Lock()
+	defer s.routeMu.Unlock()
+
+	routes := make([]LearnedRoute, 0, len(s.promoted))
+	for p := range s.promoted {
+		lr := LearnedRoute{Prefix: p}
+		if rs := s.routeStats[p]; rs != nil && rs.count > 0 {
+			lr.AvgLatencyMs = rs.totalLatency / rs.count / float64(time.Millisecond)
+			lr.Samples = rs.count
+			lr.Updated = rs.updated
+		}
+		routes = append(routes, lr)
+	}
+	sort.Slice(routes, func(i, j int) bool { return routes[i].Prefix < routes[j].Prefix })
+	return routes
+}
+
+// RestoreLearnedRoutes promotes routes again, as if RecordLatency had
+// learned them here. Their samples keep decaying from when they were saved,
+// so a prefix that has been quiet since is demoted by its next fast
+// requests. Prefixes already routed to the slow pool are skipped, as is
+// everything if adaptive routing is disabled.
+func (s *Server) RestoreLearnedRoutes(routes []LearnedRoute) {
+	cfg := s.slowCfg.Adaptive.withDefaults()
+	if cfg.Disabled {
+		return
+	}
+	now := time.Now()
+
+	s.routeMu.Lock()
+	defer s.routeMu.Unlock()
+
+	prefixes := append([]string(nil), s.slowCfg.RoutePrefixes...)
+	for _, lr := range routes {
+		if lr.Prefix == "" || slices.Contains(prefixes, lr.Prefix) {
+			continue
+		}
+		prefixes = append(prefixes, lr.Prefix)
+		if s.promoted == nil {
+			s.promoted = make(map[string]bool)
+		}
+		s.promoted[lr.Prefix] = true
+
+		rs := s.routeStatsFor(lr.Prefix, now, cfg)
+		rs.count = lr.Samples
+		rs.totalLatency = lr.AvgLatencyMs * float64(time.Millisecond) * lr.Samples
+		rs.updated = lr.Updated
+	}
+	// A new slice: IsSlowRequest may still be reading the old one.
+	s.slowCfg.RoutePrefixes = prefixes
+}
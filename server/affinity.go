@@ -0,0 +1,85 @@
+package server
+
+import (
+	"hash/fnv"
+)
+
+// Affinity pins requests that share a session key to the same worker, so
+// per-worker caches (memoized services, opcache-warm code paths) are reused.
+// The key is taken from Key if set, otherwise from the Cookie or Header
+// value. Requests without a key, or whose worker is dead or draining, are
+// left to the pool's balancer.
+type Affinity struct {
+	Cookie string
+	Header string
+	Key    func(req *RequestPayload) string
+}
+
+// enabled reports whether any key source is configured.
+func (a Affinity) enabled() bool {
+	return a.Key != nil || a.Cookie != "" || a.Header != ""
+}
+
+// key returns the session key for req, or "" if it has none.
+func (a Affinity) key(req *RequestPayload) string {
+	if a.Key != nil {
+		return a.Key(req)
+	}
+	if a.Cookie != "" {
+		if v := req.cookie(a.Cookie); len(v) > 0 && v[0] != "" {
+			return v[0]
+		}
+	}
+	if a.Header != "" {
+		if v := req.header(a.Header); len(v) > 0 && v[0] != "" {
+			return v[0]
+		}
+	}
+	return ""
+}
+
+// stickyIndex picks a worker slot for key by rendezvous hashing, so resizing
+// the pool only moves the sessions of slots that were added or removed.
+func stickyIndex(key string, n int) int {
+	best, bestScore := 0, uint64(0)
+	for i := 0; i < n; i++ {
+		h := fnv.New64a()
+		_, _ = h.Write([]byte(key))
+		_, _ = h.Write([]byte{byte(i), byte(i >> 8), byte(i >> 16), byte(i >> 24)})
+		if score := h.Sum64(); i == 0 || score > bestScore {
+			best, bestScore = i, score
+		}
+	}
+	return best
+}
+
+// SetAffinity changes how the pool pins sessions to workers; the zero
+// Affinity turns pinning off.
+func (p *WorkerPool) SetAffinity(a Affinity) {
+	p.mu.Lock()
+	p.affinity = a
+	p.mu.Unlock()
+}
+
+// workerFor returns req's sticky worker when affinity applies and that
+// worker is available, falling back to the balancer otherwise.
+func (p *WorkerPool) workerFor(req *RequestPayload) *Worker {
+	p.mu.Lock()
+	a := p.affinity
+	p.mu.Unlock()
+
+	if a.enabled() {
+		if key := a.key(req); key != "" {
+			p.mu.Lock()
+			var w *Worker
+			if n := len(p.workers); n > 0 {
+				w = p.workers[stickyIndex(key, n)]
+			}
+			p.mu.Unlock()
+			if w.Available() {
+				return w
+			}
+		}
+	}
+	return p.NextWorker()
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestAffinityKey(t *testing.T) {
+	req := &RequestPayload{Headers: map[string][]string{
+		"Cookie":    {"theme=dark; PHPSESSID=abc"},
+		"X-User-Id": {"42"},
+	}}
+
+	cases := []struct {
+		name string
+		a    Affinity
+		want string
+	}{
+		{"cookie", Affinity{Cookie: "PHPSESSID"}, "abc"},
+		{"header", Affinity{Header: "x-user-id"}, "42"},
+		{"cookie before header", Affinity{Cookie: "PHPSESSID", Header: "X-User-Id"}, "abc"},
+		{"missing cookie falls back to header", Affinity{Cookie: "sid", Header: "X-User-Id"}, "42"},
+		{"custom key", Affinity{Cookie: "PHPSESSID", Key: func(*RequestPayload) string { return "k" }}, "k"},
+		{"nothing", Affinity{Cookie: "sid"}, ""},
+	}
+	for _, c := range cases {
+		if got := c.a.key(req); got != c.want {
+			t.Fatalf("%s: key = %q, want %q", c.name, got, c.want)
+		}
+	}
+}
+
+func TestStickyIndexIsStableAcrossResize(t *testing.T) {
+	moved := 0
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("session-%d", i)
+		before, after := stickyIndex(key, 4), stickyIndex(key, 5)
+		if before != after {
+			if after != 4 {
+				t.Fatalf("key %q moved from %d to %d, only moves to the new slot are allowed", key, before, after)
+			}
+			moved++
+		}
+	}
+	if moved == 0 {
+		t.Fatalf("expected some keys to move to the new slot")
+	}
+}
+
+func TestPoolAffinityPinsSessions(t *testing.T) {
+	pool := newFakePool(t, 4, time.Second)
+	pool.SetAffinity(Affinity{Cookie: "sid"})
+
+	req := func(sid string) *RequestPayload {
+		return &RequestPayload{ID: sid, Method: "GET", Path: "/", Headers: map[string][]string{"Cookie": {"sid=" + sid}}}
+	}
+
+	first, err := pool.Dispatch(req("alice"))
+	if err != nil {
+		t.Fatalf("Dispatch: %v", err)
+	}
+	for i := 0; i < 5; i++ {
+		resp, err := pool.Dispatch(req("alice"))
+		if err != nil {
+			t.Fatalf("Dispatch: %v", err)
+		}
+		if string(resp.Body) != string(first.Body) {
+			t.Fatalf("request %d served by %q, want %q", i, resp.Body, first.Body)
+		}
+	}
+
+	// A dead sticky worker hands the session to the balancer.
+	pool.workers[stickyIndex("alice", 4)].markDead()
+	resp, err := pool.Dispatch(req("alice"))
+	if err != nil {
+		t.Fatalf("Dispatch after worker death: %v", err)
+	}
+	if string(resp.Body) == string(first.Body) {
+		t.Fatalf("session stayed on dead worker %q", resp.Body)
+	}
+}
@@ -0,0 +1,166 @@
+package server
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Balancer chooses which worker of a pool serves the next request.
+// Pick is called with the pool's lock held and must return one of the
+// available workers, or nil if none is. Done is called after every request
+// the picked worker served, with its latency and error.
+type Balancer interface {
+	Pick(workers []*Worker) *Worker
+	Done(w *Worker, d time.Duration, err error)
+}
+
+// Balancer names accepted by NewBalancer.
+const (
+	BalanceRoundRobin       = "round_robin"
+	BalanceLeastOutstanding = "least_outstanding"
+	BalanceEWMA             = "ewma"
+)
+
+// NewBalancer returns the named strategy ("" means round robin).
+func NewBalancer(name string) (Balancer, error) {
+	switch name {
+	case "", BalanceRoundRobin:
+		return &RoundRobin{}, nil
+	case BalanceLeastOutstanding:
+		return &LeastOutstanding{}, nil
+	case BalanceEWMA:
+		return NewEWMA(0), nil
+	default:
+		return nil, fmt.Errorf("unknown balancer %q", name)
+	}
+}
+
+// Available reports whether the worker can take new requests.
+func (w *Worker) Available() bool {
+	return w != nil && !w.isDead() && !w.isDraining()
+}
+
+// InFlight is the number of requests currently on the worker.
+func (w *Worker) InFlight() int {
+	return w.getInFlight()
+}
+
+// RoundRobin cycles through the available workers in order.
+type RoundRobin struct {
+	next int
+}
+
+func (b *RoundRobin) Pick(workers []*Worker) *Worker {
+	n := len(workers)
+	for i := 0; i < n; i++ {
+		w := workers[(b.next+i)%n]
+		if w.Available() {
+			b.next = (b.next + i + 1) % n
+			return w
+		}
+	}
+	return nil
+}
+
+func (*RoundRobin) Done(*Worker, time.Duration, error) {}
+
+// LeastOutstanding picks the available worker with the fewest requests in
+// flight, rotating between ties so idle workers share the load.
+type LeastOutstanding struct {
+	next int
+}
+
+func (b *LeastOutstanding) Pick(workers []*Worker) *Worker {
+	n := len(workers)
+	var best *Worker
+	bestIdx, bestLoad := 0, 0
+	for i := 0; i < n; i++ {
+		idx := (b.next + i) % n
+		w := workers[idx]
+		if !w.Available() {
+			continue
+		}
+		if load := w.InFlight(); best == nil || load < bestLoad {
+			best, bestIdx, bestLoad = w, idx, load
+		}
+	}
+	if best != nil {
+		b.next = (bestIdx + 1) % n
+	}
+	return best
+}
+
+func (*LeastOutstanding) Done(*Worker, time.Duration, error) {}
+
+// defaultEWMADecay weights each new latency sample.
+const defaultEWMADecay = 0.3
+
+// EWMA picks the worker with the lowest expected wait: its exponentially
+// weighted average latency times (requests in flight + 1). Workers without a
+// sample yet are tried first. Failed requests count as twice the current
+// average so a misbehaving worker is avoided.
+type EWMA struct {
+	decay float64
+
+	mu  sync.Mutex
+	avg map[*Worker]float64 // seconds
+}
+
+// NewEWMA returns an EWMA balancer; decay in (0, 1] is the weight of each
+// new sample (0 uses the default of 0.3).
+func NewEWMA(decay float64) *EWMA {
+	if decay <= 0 || decay > 1 {
+		decay = defaultEWMADecay
+	}
+	return &EWMA{decay: decay, avg: make(map[*Worker]float64)}
+}
+
+func (b *EWMA) Pick(workers []*Worker) *Worker {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var best *Worker
+	bestScore := 0.0
+	for _, w := range workers {
+		if !w.Available() {
+			continue
+		}
+		avg, seen := b.avg[w]
+		if !seen {
+			if w.InFlight() == 0 {
+				return w
+			}
+			avg = 0
+		}
+		score := avg * float64(w.InFlight()+1)
+		if best == nil || score < bestScore {
+			best, bestScore = w, score
+		}
+	}
+	return best
+}
+
+func (b *EWMA) Done(w *Worker, d time.Duration, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	sample := d.Seconds()
+	prev, seen := b.avg[w]
+	if err != nil {
+		sample = max(sample, 2*prev)
+	}
+	if !seen {
+		b.avg[w] = sample
+		return
+	}
+	b.avg[w] = prev + b.decay*(sample-prev)
+}
+
+// Latency returns the worker's current average latency, if it has one.
+func (b *EWMA) Latency(w *Worker) (time.Duration, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	avg, ok := b.avg[w]
+	return time.Duration(avg * float64(time.Second)), ok
+}
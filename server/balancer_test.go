@@ -0,0 +1,112 @@
+package server
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func newIdleWorkers(n int) []*Worker {
+	ws := make([]*Worker, n)
+	for i := range ws {
+		ws[i] = &Worker{}
+	}
+	return ws
+}
+
+func TestRoundRobinSkipsUnavailable(t *testing.T) {
+	ws := newIdleWorkers(3)
+	ws[1].markDead()
+	b := &RoundRobin{}
+
+	want := []*Worker{ws[0], ws[2], ws[0], ws[2]}
+	for i, w := range want {
+		if got := b.Pick(ws); got != w {
+			t.Fatalf("pick %d: got worker %p, want %p", i, got, w)
+		}
+	}
+
+	ws[0].markDead()
+	ws[2].startDraining()
+	if got := b.Pick(ws); got != nil {
+		t.Fatalf("expected nil when no worker is available, got %p", got)
+	}
+}
+
+func TestLeastOutstandingPrefersIdleWorkers(t *testing.T) {
+	ws := newIdleWorkers(3)
+	ws[0].incrInFlight()
+	ws[0].incrInFlight()
+	ws[1].incrInFlight()
+	b := &LeastOutstanding{}
+
+	if got := b.Pick(ws); got != ws[2] {
+		t.Fatalf("expected idle worker 2")
+	}
+
+	ws[2].incrInFlight()
+	ws[2].incrInFlight()
+	if got := b.Pick(ws); got != ws[1] {
+		t.Fatalf("expected least loaded worker 1")
+	}
+}
+
+func TestEWMAFavoursFastWorkers(t *testing.T) {
+	ws := newIdleWorkers(2)
+	b := NewEWMA(0.5)
+
+	// Unmeasured workers are tried first.
+	if got := b.Pick(ws); got != ws[0] {
+		t.Fatalf("expected first unmeasured worker")
+	}
+	b.Done(ws[0], 100*time.Millisecond, nil)
+	if got := b.Pick(ws); got != ws[1] {
+		t.Fatalf("expected second unmeasured worker")
+	}
+	b.Done(ws[1], 10*time.Millisecond, nil)
+
+	for i := 0; i < 3; i++ {
+		if got := b.Pick(ws); got != ws[1] {
+			t.Fatalf("expected faster worker 1")
+		}
+	}
+
+	// Load on the fast worker eventually tips the balance.
+	for i := 0; i < 20; i++ {
+		ws[1].incrInFlight()
+	}
+	if got := b.Pick(ws); got != ws[0] {
+		t.Fatalf("expected worker 0 once worker 1 is saturated")
+	}
+
+	// Errors push the average up.
+	b.Done(ws[0], time.Millisecond, errors.New("boom"))
+	if avg, _ := b.Latency(ws[0]); avg <= 100*time.Millisecond {
+		t.Fatalf("expected error to raise the average, got %v", avg)
+	}
+}
+
+func TestNewBalancer(t *testing.T) {
+	for _, name := range []string{"", BalanceRoundRobin, BalanceLeastOutstanding, BalanceEWMA} {
+		if _, err := NewBalancer(name); err != nil {
+			t.Fatalf("NewBalancer(%q): %v", name, err)
+		}
+	}
+	if _, err := NewBalancer("random"); err == nil {
+		t.Fatalf("expected error for unknown balancer")
+	}
+}
+
+func TestPoolUsesCustomBalancer(t *testing.T) {
+	pool := newFakePool(t, 2, time.Second)
+	pool.SetBalancer(&LeastOutstanding{})
+	pool.workers[0].incrInFlight()
+
+	resp, err := pool.Dispatch(&RequestPayload{ID: "1", Method: "GET", Path: "/lb"})
+	if err != nil {
+		t.Fatalf("Dispatch: %v", err)
+	}
+	if string(resp.Body) != "w1:/lb" {
+		t.Fatalf("expected idle worker w1 to serve, got %q", resp.Body)
+	}
+}
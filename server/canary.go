@@ -0,0 +1,73 @@
+package server
+
+import (
+	"fmt"
+	"math/rand/v2"
+)
+
+// Canary sends Weight percent of the traffic that would go to the Stable pool
+// to the Canary pool instead. Both pools serve the same routes, typically from
+// different code checkouts.
+type Canary struct {
+	Stable string `json:"stable"`
+	Canary string `json:"canary"`
+	Weight int    `json:"weight"` // 0-100
+}
+
+// SetCanary installs (or replaces) the canary split.
+func (s *Server) SetCanary(c Canary) error {
+	if _, ok := s.pools[c.Stable]; !ok {
+		return fmt.Errorf("canary: unknown stable pool %q", c.Stable)
+	}
+	if _, ok := s.pools[c.Canary]; !ok {
+		return fmt.Errorf("canary: unknown canary pool %q", c.Canary)
+	}
+	if c.Stable == c.Canary {
+		return fmt.Errorf("canary: stable and canary pools must differ")
+	}
+	if c.Weight < 0 || c.Weight > 100 {
+		return fmt.Errorf("canary: weight %d out of range 0-100", c.Weight)
+	}
+
+	s.canaryMu.Lock()
+	s.canary = &c
+	s.canaryMu.Unlock()
+	return nil
+}
+
+// SetCanaryWeight shifts traffic between the stable and canary pools.
+func (s *Server) SetCanaryWeight(weight int) error {
+	if weight < 0 || weight > 100 {
+		return fmt.Errorf("canary: weight %d out of range 0-100", weight)
+	}
+
+	s.canaryMu.Lock()
+	defer s.canaryMu.Unlock()
+	if s.canary == nil {
+		return fmt.Errorf("canary: not configured")
+	}
+	s.canary.Weight = weight
+	return nil
+}
+
+// Canary returns the current canary split, if one is configured.
+func (s *Server) Canary() (Canary, bool) {
+	s.canaryMu.RLock()
+	defer s.canaryMu.RUnlock()
+	if s.canary == nil {
+		return Canary{}, false
+	}
+	return *s.canary, true
+}
+
+// applyCanary diverts a share of the stable pool's traffic to the canary.
+func (s *Server) applyCanary(pool string) string {
+	c, ok := s.Canary()
+	if !ok || pool != c.Stable || c.Weight <= 0 {
+		return pool
+	}
+	if c.Weight >= 100 || rand.IntN(100) < c.Weight {
+		return c.Canary
+	}
+	return pool
+}
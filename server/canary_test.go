@@ -0,0 +1,77 @@
+package server
+
+import "testing"
+
+func newCanaryServer() *Server {
+	return &Server{
+		pools:     map[string]*WorkerPool{"stable": {}, "canary": {}, "admin": {}},
+		poolOrder: []string{"stable", "canary", "admin"},
+		routes:    []PoolRoute{{Prefix: "/admin", Pool: "admin"}},
+	}
+}
+
+func TestCanaryWeightSplitsStableTraffic(t *testing.T) {
+	s := newCanaryServer()
+	req := &RequestPayload{Method: "GET", Path: "/"}
+
+	if got := s.PoolFor(req); got != "stable" {
+		t.Fatalf("PoolFor without canary = %q, want stable", got)
+	}
+
+	if err := s.SetCanary(Canary{Stable: "stable", Canary: "canary", Weight: 100}); err != nil {
+		t.Fatalf("SetCanary: %v", err)
+	}
+	if got := s.PoolFor(req); got != "canary" {
+		t.Fatalf("PoolFor at weight 100 = %q, want canary", got)
+	}
+
+	// Routes pinned to other pools are never diverted.
+	if got := s.PoolFor(&RequestPayload{Method: "GET", Path: "/admin/users"}); got != "admin" {
+		t.Fatalf("PoolFor(/admin) = %q, want admin", got)
+	}
+
+	if err := s.SetCanaryWeight(0); err != nil {
+		t.Fatalf("SetCanaryWeight: %v", err)
+	}
+	for i := 0; i < 100; i++ {
+		if got := s.PoolFor(req); got != "stable" {
+			t.Fatalf("PoolFor at weight 0 = %q, want stable", got)
+		}
+	}
+
+	if err := s.SetCanaryWeight(50); err != nil {
+		t.Fatalf("SetCanaryWeight: %v", err)
+	}
+	canary := 0
+	for i := 0; i < 2000; i++ {
+		if s.PoolFor(req) == "canary" {
+			canary++
+		}
+	}
+	if canary < 800 || canary > 1200 {
+		t.Fatalf("weight 50 sent %d/2000 requests to canary", canary)
+	}
+}
+
+func TestSetCanaryValidation(t *testing.T) {
+	s := newCanaryServer()
+
+	bad := []Canary{
+		{Stable: "nope", Canary: "canary", Weight: 10},
+		{Stable: "stable", Canary: "nope", Weight: 10},
+		{Stable: "stable", Canary: "stable", Weight: 10},
+		{Stable: "stable", Canary: "canary", Weight: 101},
+	}
+	for _, c := range bad {
+		if err := s.SetCanary(c); err == nil {
+			t.Fatalf("SetCanary(%+v) succeeded, want error", c)
+		}
+	}
+
+	if err := s.SetCanaryWeight(10); err == nil {
+		t.Fatalf("SetCanaryWeight without canary should fail")
+	}
+	if _, ok := s.Canary(); ok {
+		t.Fatalf("expected no canary configured")
+	}
+}
@@ -0,0 +1,104 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"time"
+)
+
+// Chaos injects faults into requests on purpose, so timeout handling,
+// retries and clients can be checked before a real outage does it. It is
+// meant for test and staging environments only.
+type Chaos struct {
+	// LatencyPercent of requests wait LatencyMs longer for their response,
+	// inside the request timeout.
+	LatencyPercent int `json:"latency_percent"`
+	LatencyMs      int `json:"latency_ms"`
+	// BrokenPipePercent of requests fail as if the worker's pipe broke,
+	// without reaching it.
+	BrokenPipePercent int `json:"broken_pipe_percent"`
+	// DropFramePercent of streamed chunk frames never reach the client.
+	DropFramePercent int `json:"drop_frame_percent"`
+}
+
+// errChaosBrokenPipe is what an injected broken pipe fails with; it is
+// retried and reported like a real one.
+var errChaosBrokenPipe = crashed(errors.New("chaos: broken pipe"))
+
+func (c Chaos) validate() error {
+	for _, p := range []struct {
+		name  string
+		value int
+	}{
+		{"latency_percent", c.LatencyPercent},
+		{"broken_pipe_percent", c.BrokenPipePercent},
+		{"drop_frame_percent", c.DropFramePercent},
+	} {
+		if p.value < 0 || p.value > 100 {
+			return fmt.Errorf("chaos: %s %d out of range 0-100", p.name, p.value)
+		}
+	}
+	if c.LatencyMs < 0 {
+		return fmt.Errorf("chaos: latency_ms %d is negative", c.LatencyMs)
+	}
+	return nil
+}
+
+// Enabled reports whether c injects anything.
+func (c Chaos) Enabled() bool {
+	return c.LatencyPercent > 0 && c.LatencyMs > 0 || c.BrokenPipePercent > 0 || c.DropFramePercent > 0
+}
+
+// roll reports whether a fault that happens percent of the time happens now.
+func roll(percent int) bool {
+	return percent > 0 && (percent >= 100 || rand.IntN(100) < percent)
+}
+
+// The methods below decide one request's (or frame's) fate. A nil c never
+// injects anything.
+
+func (c *Chaos) delay() time.Duration {
+	if c == nil || !roll(c.LatencyPercent) {
+		return 0
+	}
+	return time.Duration(c.LatencyMs) * time.Millisecond
+}
+
+func (c *Chaos) brokenPipe() bool {
+	return c != nil && roll(c.BrokenPipePercent)
+}
+
+func (c *Chaos) dropFrame() bool {
+	return c != nil && roll(c.DropFramePercent)
+}
+
+// SetChaos starts injecting c's faults into every pool's requests; a zero
+// Chaos stops it.
+func (s *Server) SetChaos(c Chaos) error {
+	if err := c.validate(); err != nil {
+		return err
+	}
+	for _, p := range s.pools {
+		p.SetChaos(c)
+	}
+	return nil
+}
+
+// SetChaos starts injecting c's faults into the pool's requests, for
+// current and future workers; a zero Chaos stops it.
+func (p *WorkerPool) SetChaos(c Chaos) {
+	var cp *Chaos
+	if c.Enabled() {
+		cp = &c
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.cfg.Chaos = cp
+	for _, w := range p.workers {
+		if w != nil {
+			w.chaos.Store(cp)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestChaosBrokenPipe(t *testing.T) {
+	s := &Server{pools: map[string]*WorkerPool{FastPool: newFakePool(t, 2, time.Second)}, poolOrder: []string{FastPool}}
+	if err := s.SetRetryPolicy(RetryPolicy{MaxAttempts: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetChaos(Chaos{BrokenPipePercent: 100}); err != nil {
+		t.Fatalf("SetChaos: %v", err)
+	}
+
+	_, err := s.Dispatch(&RequestPayload{ID: "1", Method: "GET", Path: "/x"})
+	if !IsBrokenPipe(err) {
+		t.Fatalf("Dispatch error = %v, want a broken pipe", err)
+	}
+	if st := s.RetryStats(); st.Retries != 1 || st.Exhausted != 1 {
+		t.Errorf("RetryStats = %+v, want the broken pipe retried once", st)
+	}
+
+	if err := s.SetChaos(Chaos{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Dispatch(&RequestPayload{ID: "2", Method: "GET", Path: "/x"}); err != nil {
+		t.Fatalf("Dispatch after chaos off: %v", err)
+	}
+}
+
+func TestChaosLatencyHitsTimeout(t *testing.T) {
+	p := newFakePool(t, 1, 50*time.Millisecond)
+	p.SetChaos(Chaos{LatencyPercent: 100, LatencyMs: 200})
+
+	_, err := p.Dispatch(&RequestPayload{ID: "1", Method: "GET", Path: "/x"})
+	var timeout *timeoutError
+	if !errors.As(err, &timeout) {
+		t.Fatalf("Dispatch error = %v, want a request timeout", err)
+	}
+}
+
+func TestChaosDropsStreamFrames(t *testing.T) {
+	var buf bytes.Buffer
+	buf.Write(encodeFrame(t, StreamFrame{Type: "headers", Status: 200, Data: "head "}))
+	for range 3 {
+		buf.Write(encodeFrame(t, StreamFrame{Type: "chunk", Data: "chunk "}))
+	}
+	buf.Write(encodeFrame(t, StreamFrame{Type: "end"}))
+
+	w := &Worker{requestTimeout: time.Second}
+	w.transport = NewStreamTransport(nopWriteCloser{Writer: io.Discard}, io.NopCloser(&buf), nil)
+	w.chaos.Store(&Chaos{DropFramePercent: 100})
+
+	rr := httptest.NewRecorder()
+	if err := w.streamInternal(&RequestPayload{}, rr); err != nil {
+		t.Fatalf("streamInternal: %v", err)
+	}
+	if got := rr.Body.String(); got != "head " {
+		t.Errorf("body = %q, want every chunk frame dropped", got)
+	}
+}
+
+func TestSetChaosValidation(t *testing.T) {
+	s := &Server{pools: map[string]*WorkerPool{}}
+	for _, c := range []Chaos{{LatencyPercent: 101}, {BrokenPipePercent: -1}, {LatencyMs: -5}} {
+		if err := s.SetChaos(c); err == nil {
+			t.Errorf("SetChaos(%+v) succeeded", c)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package server
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrDegraded is returned by Dispatch while the server is running without
+// PHP workers (see NewDegradedServer).
+var ErrDegraded = errors.New("server degraded: php workers unavailable")
+
+// initialRecoverBackoff is the first retry delay used while degraded.
+const initialRecoverBackoff = 500 * time.Millisecond
+
+// NewDegradedServer builds a Server whose workers have not been spawned yet.
+// It is meant as a fallback when NewServerWithPools fails (for example because
+// the php binary is missing after a bad upgrade): the caller can keep serving
+// static assets while Dispatch returns ErrDegraded. A background loop keeps
+// trying to spawn every worker, doubling the delay up to maxBackoff, and
+// leaves degraded mode once all of them are running or DrainWorkers is
+// called.
+func NewDegradedServer(pools []PoolConfig, routes []PoolRoute, slowCfg SlowRequestConfig, maxBackoff time.Duration) (*Server, error) {
+	if err := validatePools(pools, routes); err != nil {
+		return nil, err
+	}
+
+	built := make(map[string]*WorkerPool, len(pools))
+	for _, pc := range pools {
+		p, err := newUnstartedPool(pc)
+		if err != nil {
+			return nil, err
+		}
+		built[pc.Name] = p
+	}
+
+	s := newServer(built, poolNames(pools), routes, slowCfg)
+	s.degraded.Store(true)
+	s.stopRecover = make(chan struct{})
+
+	s.recovering.Add(1)
+	go func() {
+		defer s.recovering.Done()
+		s.recoverWorkers(maxBackoff)
+	}()
+
+	return s, nil
+}
+
+// newUnstartedPool creates a pool of workers with no processes attached.
+func newUnstartedPool(cfg PoolConfig) (*WorkerPool, error) {
+	balancer, err := NewBalancer(cfg.Balancer)
+	if err != nil {
+		return nil, err
+	}
+
+	workers := make([]*Worker, 0, cfg.Workers)
+
+	for i := 0; i < cfg.Workers; i++ {
+		w, err := cfg.newWorker()
+		if err != nil {
+			return nil, err
+		}
+		w.markDead()
+		workers = append(workers, w)
+	}
+
+	return &WorkerPool{
+		workers:  workers,
+		balancer: balancer,
+		affinity: cfg.Affinity,
+		cfg:      cfg,
+	}, nil
+}
+
+// Degraded reports whether the server is running without PHP workers.
+func (s *Server) Degraded() bool {
+	return s.degraded.Load()
+}
+
+// recoverWorkers retries spawning unstarted workers with exponential backoff
+// until every worker in every pool is running or stopRecover is closed.
+func (s *Server) recoverWorkers(maxBackoff time.Duration) {
+	if maxBackoff < initialRecoverBackoff {
+		maxBackoff = initialRecoverBackoff
+	}
+	backoff := initialRecoverBackoff
+
+	for {
+		var err error
+		for _, name := range s.poolOrder {
+			if err = s.pools[name].startPending(); err != nil {
+				break
+			}
+		}
+		if err == nil {
+			s.degraded.Store(false)
+			logTo(s.logger).Println("[degraded] php workers started, leaving degraded mode")
+			return
+		}
+
+		logTo(s.logger).Printf("[degraded] failed to start php workers: %v (retrying in %s)", err, backoff)
+		retry := time.NewTimer(backoff)
+		select {
+		case <-retry.C:
+		case <-s.stopRecover:
+			retry.Stop()
+			return
+		}
+
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// startPending spawns every worker in the pool that has never been started.
+func (p *WorkerPool) startPending() error {
+	p.mu.Lock()
+	workers := append([]*Worker(nil), p.workers...)
+	p.mu.Unlock()
+
+	for _, w := range workers {
+		if w == nil || w.started() {
+			continue
+		}
+		if err := w.restart(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// started reports whether a PHP process has ever been attached to w.
+func (w *Worker) started() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.transport != nil
+}
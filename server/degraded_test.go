@@ -0,0 +1,109 @@
+package server
+
+import (
+	"errors"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestNewDegradedServerRejectsDispatch(t *testing.T) {
+	t.Setenv("PATH", t.TempDir()) // no php binary anywhere
+
+	s, err := NewDegradedServer(legacyPools(1, 1, 10, time.Second), nil, SlowRequestConfig{}, time.Hour)
+	if err != nil {
+		t.Fatalf("NewDegradedServer: %v", err)
+	}
+	t.Cleanup(s.DrainWorkers)
+
+	if !s.Degraded() {
+		t.Fatalf("expected server to start degraded")
+	}
+	if !s.Health().Degraded {
+		t.Fatalf("expected health summary to report degraded")
+	}
+
+	if _, err := s.Dispatch(&RequestPayload{Method: "GET", Path: "/"}); !errors.Is(err, ErrDegraded) {
+		t.Fatalf("Dispatch error = %v, want ErrDegraded", err)
+	}
+	if err := s.DispatchStream(&RequestPayload{Method: "GET", Path: "/"}, httptest.NewRecorder()); !errors.Is(err, ErrDegraded) {
+		t.Fatalf("DispatchStream error = %v, want ErrDegraded", err)
+	}
+}
+
+func TestDegradedServerRecoversWhenPHPAppears(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses a shell script as a stand-in php binary")
+	}
+
+	bin := t.TempDir()
+	t.Setenv("PATH", bin)
+
+	s, err := NewDegradedServer(legacyPools(1, 1, 10, time.Second), nil, SlowRequestConfig{}, 50*time.Millisecond)
+	if err != nil {
+		t.Fatalf("NewDegradedServer: %v", err)
+	}
+
+	// "Fix" the PHP install: a fake php that just idles on stdin.
+	script := "#!/bin/sh\nwhile read -r line; do :; done\n"
+	if err := os.WriteFile(filepath.Join(bin, "php"), []byte(script), 0o755); err != nil {
+		t.Fatalf("write fake php: %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for s.Degraded() {
+		if time.Now().After(deadline) {
+			t.Fatalf("server did not leave degraded mode")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	if h := s.Health(); h.Fast.DeadWorkers != 0 || h.Slow.DeadWorkers != 0 {
+		t.Fatalf("expected all workers alive after recovery, got %+v", h)
+	}
+
+	s.pools[FastPool].killAll()
+	s.pools[SlowPool].killAll()
+}
+
+func TestDrainWorkersStopsDegradedRecovery(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses a shell script as a stand-in php binary")
+	}
+
+	bin := t.TempDir()
+	t.Setenv("PATH", bin)
+
+	s, err := NewDegradedServer(legacyPools(1, 1, 10, time.Second), nil, SlowRequestConfig{}, 50*time.Millisecond)
+	if err != nil {
+		t.Fatalf("NewDegradedServer: %v", err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.DrainWorkers()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("DrainWorkers waited out the retry backoff")
+	}
+
+	// PHP appearing after shutdown must not start workers.
+	script := "#!/bin/sh\nwhile read -r line; do :; done\n"
+	if err := os.WriteFile(filepath.Join(bin, "php"), []byte(script), 0o755); err != nil {
+		t.Fatalf("write fake php: %v", err)
+	}
+	time.Sleep(2 * initialRecoverBackoff)
+	for _, name := range s.poolOrder {
+		for _, w := range s.pools[name].workers {
+			if w.started() {
+				t.Fatalf("pool %s started a worker after DrainWorkers", name)
+			}
+		}
+	}
+}
@@ -1,9 +1,124 @@
 package server
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+	"time"
+)
 
 var (
 	ErrWorkerDead = errors.New("worker is dead")
 
 	ErrWorkerDraining = errors.New("worker is draining")
+
+	// ErrWorkerTimeout matches a worker that didn't answer within its
+	// request timeout. It was killed, but the request may have had side
+	// effects.
+	ErrWorkerTimeout = errors.New("worker timeout")
+
+	// ErrWorkerCrashed matches a worker whose process or connection went
+	// away mid-request, after the one retry on a fresh worker.
+	ErrWorkerCrashed = errors.New("worker crashed")
+
+	// ErrFrameTooLarge matches a message from a worker over the size limit.
+	ErrFrameTooLarge = errors.New("frame too large")
+
+	// ErrProtocol matches a message from a worker that isn't valid for the
+	// protocol: an empty or undecodable frame, or an unknown frame type.
+	ErrProtocol = errors.New("worker protocol error")
+)
+
+// Error categories, as reported by ErrorCategory.
+const (
+	CategoryTimeout    = "timeout"
+	CategoryCrashed    = "crashed"
+	CategoryFrameSize  = "frame_too_large"
+	CategoryProtocol   = "protocol"
+	CategoryFatal      = "php_fatal"
+	CategoryOverloaded = "overloaded"
+	CategoryDegraded   = "degraded"
+	CategoryOther      = "other"
 )
+
+// ErrorCategory names the kind of worker error err is, for logs and
+// metrics. Errors this package doesn't know are CategoryOther.
+func ErrorCategory(err error) string {
+	switch {
+	case errors.Is(err, ErrPHPFatal):
+		return CategoryFatal
+	case errors.Is(err, ErrWorkerTimeout):
+		return CategoryTimeout
+	case errors.Is(err, ErrFrameTooLarge):
+		return CategoryFrameSize
+	case errors.Is(err, ErrProtocol):
+		return CategoryProtocol
+	case errors.Is(err, ErrOverloaded):
+		return CategoryOverloaded
+	case errors.Is(err, ErrDegraded):
+		return CategoryDegraded
+	case errors.Is(err, ErrWorkerCrashed), isBrokenPipe(err):
+		return CategoryCrashed
+	}
+	return CategoryOther
+}
+
+// timeoutError is returned when a worker doesn't answer within its request
+// timeout; the worker is killed, but the request may have had side effects.
+// It matches ErrWorkerTimeout.
+type timeoutError struct {
+	op    string // "request", "stream" or "relay"
+	after time.Duration
+}
+
+func (e *timeoutError) Error() string {
+	return fmt.Sprintf("worker %s timeout after %s", e.op, e.after)
+}
+
+func (e *timeoutError) Timeout() bool { return true }
+
+func (e *timeoutError) Is(target error) bool { return target == ErrWorkerTimeout }
+
+// workerError tags err with kind, one of the sentinels above, so callers
+// can match either with errors.Is.
+type workerError struct {
+	kind error
+	err  error
+}
+
+func (e *workerError) Error() string { return e.kind.Error() + ": " + e.err.Error() }
+
+func (e *workerError) Unwrap() []error { return []error{e.kind, e.err} }
+
+// errNotSent matches an error that happened before the request reached a
+// worker, so running it elsewhere can't run it twice.
+var errNotSent = errors.New("request not sent")
+
+// unsentError is err, matching errNotSent as well.
+type unsentError struct{ err error }
+
+func (e *unsentError) Error() string { return e.err.Error() }
+
+func (e *unsentError) Unwrap() error { return e.err }
+
+func (e *unsentError) Is(target error) bool { return target == errNotSent }
+
+// notSent tags err as errNotSent without changing its message.
+func notSent(err error) error {
+	if err == nil || errors.Is(err, errNotSent) {
+		return err
+	}
+	return &unsentError{err: err}
+}
+
+// crashed tags err, a torn-down pipe or connection, as ErrWorkerCrashed.
+func crashed(err error) error {
+	if err == nil || errors.Is(err, ErrWorkerCrashed) {
+		return err
+	}
+	return &workerError{kind: ErrWorkerCrashed, err: err}
+}
+
+// protocolError is an ErrProtocol with a message.
+func protocolError(format string, args ...any) error {
+	return &workerError{kind: ErrProtocol, err: fmt.Errorf(format, args...)}
+}
@@ -0,0 +1,337 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// FastCGI record types and constants (FastCGI spec 1.0).
+const (
+	fcgiVersion      = 1
+	fcgiBeginRequest = 1
+	fcgiEndRequest   = 3
+	fcgiParams       = 4
+	fcgiStdin        = 5
+	fcgiStdout       = 6
+	fcgiStderr       = 7
+
+	fcgiResponder = 1
+	fcgiKeepConn  = 1
+
+	fcgiMaxContent = 65535
+	fcgiRequestID  = 1 // one request per connection at a time
+)
+
+// fastCGITransport sends each request to a FastCGI responder (php-fpm) as
+// CGI params + stdin and turns the CGI response back into payloads/frames.
+type fastCGITransport struct {
+	conn           io.ReadWriteCloser
+	r              *bufio.Reader
+	scriptFilename string
+	logger         *log.Logger // for php-fpm's stderr; nil means the standard logger
+
+	// streaming state for RecvFrame
+	head        []byte
+	headersDone bool
+	queued      []*StreamFrame
+}
+
+// NewFastCGITransport talks FastCGI over conn, running scriptFilename (the
+// absolute path of the front controller on the FastCGI server) for every
+// request.
+func NewFastCGITransport(conn io.ReadWriteCloser, scriptFilename string) WorkerTransport {
+	return newFastCGITransport(conn, scriptFilename, nil)
+}
+
+func newFastCGITransport(conn io.ReadWriteCloser, scriptFilename string, logger *log.Logger) *fastCGITransport {
+	return &fastCGITransport{conn: conn, r: bufio.NewReader(conn), scriptFilename: scriptFilename, logger: logger}
+}
+
+// fastCGIAddress splits "unix:/run/php-fpm.sock" or "127.0.0.1:9000".
+func fastCGIAddress(addr string) (network, address string) {
+	if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+		return "unix", path
+	}
+	return "tcp", addr
+}
+
+func (t *fastCGITransport) Send(req *RequestPayload) error {
+	t.head, t.headersDone, t.queued = nil, false, nil
+
+	var buf bytes.Buffer
+	begin := make([]byte, 8)
+	binary.BigEndian.PutUint16(begin, fcgiResponder)
+	begin[2] = fcgiKeepConn
+	writeRecord(&buf, fcgiBeginRequest, begin)
+
+	writeStream(&buf, fcgiParams, encodeParams(cgiParams(req, t.scriptFilename)))
+	writeStream(&buf, fcgiStdin, req.Body)
+
+	_, err := t.conn.Write(buf.Bytes())
+	return err
+}
+
+func (t *fastCGITransport) Recv() (*ResponsePayload, error) {
+	var out bytes.Buffer
+	for {
+		typ, content, err := t.readRecord()
+		if err != nil {
+			return nil, err
+		}
+		switch typ {
+		case fcgiStdout:
+			if out.Len()+len(content) > maxMessageSize {
+				return nil, errFastCGITooLarge()
+			}
+			out.Write(content)
+		case fcgiEndRequest:
+			status, headers, body := parseCGIResponse(out.Bytes())
+			resp := &ResponsePayload{Status: status, Headers: make(map[string]string, len(headers)), Body: body}
+			for k, vs := range headers {
+				if http.CanonicalHeaderKey(k) == "Set-Cookie" {
+					resp.Cookies = append(resp.Cookies, vs...)
+					continue
+				}
+				resp.Headers[k] = strings.Join(vs, ", ")
+			}
+			return resp, nil
+		}
+	}
+}
+
+func (t *fastCGITransport) RecvFrame() (*StreamFrame, error) {
+	for len(t.queued) == 0 {
+		typ, content, err := t.readRecord()
+		if err != nil {
+			return nil, err
+		}
+
+		switch typ {
+		case fcgiStdout:
+			if t.headersDone {
+				t.queued = append(t.queued, &StreamFrame{Type: "chunk", Data: string(content)})
+				continue
+			}
+			if len(t.head)+len(content) > maxMessageSize {
+				return nil, errFastCGITooLarge()
+			}
+			t.head = append(t.head, content...)
+			if headerEnd(t.head) >= 0 {
+				t.queued = append(t.queued, t.headersFrame())
+			}
+		case fcgiEndRequest:
+			if !t.headersDone {
+				t.queued = append(t.queued, t.headersFrame())
+			}
+			t.queued = append(t.queued, &StreamFrame{Type: "end"})
+		}
+	}
+
+	frame := t.queued[0]
+	t.queued = t.queued[1:]
+	return frame, nil
+}
+
+// errFastCGITooLarge reports a response, or a streamed response's head,
+// over the limit the JSON codec puts on a message.
+func errFastCGITooLarge() error {
+	return &workerError{kind: ErrFrameTooLarge, err: fmt.Errorf("fastcgi: response over %d bytes", maxMessageSize)}
+}
+
+func (t *fastCGITransport) headersFrame() *StreamFrame {
+	status, headers, body := parseCGIResponse(t.head)
+	t.head, t.headersDone = nil, true
+	return &StreamFrame{Type: "headers", Status: status, Headers: headers, Data: string(body)}
+}
+
+func (t *fastCGITransport) Close() error {
+	return t.conn.Close()
+}
+
+// readRecord returns the next record for our request, logging stderr.
+func (t *fastCGITransport) readRecord() (byte, []byte, error) {
+	for {
+		var hdr [8]byte
+		if _, err := io.ReadFull(t.r, hdr[:]); err != nil {
+			return 0, nil, err
+		}
+		if hdr[0] != fcgiVersion {
+			return 0, nil, protocolError("fastcgi: unsupported record version %d", hdr[0])
+		}
+		typ := hdr[1]
+		n := int(binary.BigEndian.Uint16(hdr[4:6]))
+		pad := int(hdr[6])
+
+		content := make([]byte, n+pad)
+		if _, err := io.ReadFull(t.r, content); err != nil {
+			return 0, nil, err
+		}
+		content = content[:n]
+
+		if typ == fcgiStderr {
+			if msg := strings.TrimSpace(string(content)); msg != "" {
+				logTo(t.logger).Printf("[fastcgi] stderr: %s", msg)
+			}
+			continue
+		}
+		return typ, content, nil
+	}
+}
+
+func writeRecord(buf *bytes.Buffer, typ byte, content []byte) {
+	pad := (8 - len(content)%8) % 8
+	hdr := [8]byte{fcgiVersion, typ}
+	binary.BigEndian.PutUint16(hdr[2:4], fcgiRequestID)
+	binary.BigEndian.PutUint16(hdr[4:6], uint16(len(content)))
+	hdr[6] = byte(pad)
+	buf.Write(hdr[:])
+	buf.Write(content)
+	buf.Write(make([]byte, pad))
+}
+
+// writeStream splits data into records and terminates the stream with an
+// empty record.
+func writeStream(buf *bytes.Buffer, typ byte, data []byte) {
+	for len(data) > 0 {
+		n := min(len(data), fcgiMaxContent)
+		writeRecord(buf, typ, data[:n])
+		data = data[n:]
+	}
+	writeRecord(buf, typ, nil)
+}
+
+func encodeParams(params map[string]string) []byte {
+	var buf bytes.Buffer
+	writeLen := func(n int) {
+		if n < 128 {
+			buf.WriteByte(byte(n))
+			return
+		}
+		var b [4]byte
+		binary.BigEndian.PutUint32(b[:], uint32(n)|1<<31)
+		buf.Write(b[:])
+	}
+	for k, v := range params {
+		writeLen(len(k))
+		writeLen(len(v))
+		buf.WriteString(k)
+		buf.WriteString(v)
+	}
+	return buf.Bytes()
+}
+
+// cgiParams builds the CGI/1.1 environment for req.
+func cgiParams(req *RequestPayload, scriptFilename string) map[string]string {
+	uri := req.Path
+	path, query, _ := strings.Cut(uri, "?")
+
+	params := map[string]string{
+		"GATEWAY_INTERFACE": "CGI/1.1",
+		"SERVER_SOFTWARE":   "go-php",
+		"SERVER_PROTOCOL":   "HTTP/1.1",
+		"REQUEST_METHOD":    strings.ToUpper(req.Method),
+		"REQUEST_URI":       uri,
+		"QUERY_STRING":      query,
+		"DOCUMENT_URI":      path,
+		"SCRIPT_FILENAME":   scriptFilename,
+		"SCRIPT_NAME":       "/" + filepath.Base(scriptFilename),
+		"DOCUMENT_ROOT":     filepath.Dir(scriptFilename),
+		"CONTENT_LENGTH":    strconv.Itoa(len(req.Body)),
+	}
+
+	for k, vs := range req.Headers {
+		if len(vs) == 0 {
+			continue
+		}
+		switch http.CanonicalHeaderKey(k) {
+		case "Content-Type":
+			params["CONTENT_TYPE"] = vs[0]
+			continue
+		case "Content-Length":
+			continue
+		case "Proxy":
+			// HTTP_PROXY would point PHP's HTTP clients at the client's
+			// proxy (httpoxy).
+			continue
+		}
+		if strings.Contains(k, "_") {
+			// X_Foo would overwrite X-Foo's HTTP_X_FOO.
+			continue
+		}
+		params["HTTP_"+strings.ToUpper(strings.ReplaceAll(k, "-", "_"))] = strings.Join(vs, ", ")
+	}
+
+	if host := req.header("Host"); len(host) > 0 {
+		name := host[0]
+		if h, port, err := net.SplitHostPort(name); err == nil {
+			name = h
+			params["SERVER_PORT"] = port
+		}
+		params["SERVER_NAME"] = name
+	}
+	// BuildPayload appends the direct client to X-Forwarded-For.
+	if xff := req.header("X-Forwarded-For"); len(xff) > 0 {
+		hops := strings.Split(xff[0], ",")
+		params["REMOTE_ADDR"] = strings.TrimSpace(hops[len(hops)-1])
+	}
+
+	// The server's own view of the connection wins over the guesses above.
+	// DOCUMENT_ROOT describes php-fpm's filesystem, not ours.
+	for k, v := range req.ServerParams {
+		if k != "DOCUMENT_ROOT" {
+			params[k] = v
+		}
+	}
+
+	return params
+}
+
+// headerEnd returns the index just past the CGI header block, or -1.
+func headerEnd(b []byte) int {
+	if i := bytes.Index(b, []byte("\r\n\r\n")); i >= 0 {
+		return i + 4
+	}
+	if i := bytes.Index(b, []byte("\n\n")); i >= 0 {
+		return i + 2
+	}
+	return -1
+}
+
+// parseCGIResponse splits a CGI response into status, headers and body.
+func parseCGIResponse(raw []byte) (int, map[string][]string, []byte) {
+	status := http.StatusOK
+	headers := make(map[string][]string)
+
+	end := headerEnd(raw)
+	if end < 0 {
+		return status, headers, raw
+	}
+
+	for _, line := range strings.Split(string(raw[:end]), "\n") {
+		name, value, ok := strings.Cut(strings.TrimRight(line, "\r"), ":")
+		if !ok {
+			continue
+		}
+		name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+		value = strings.TrimSpace(value)
+
+		if name == "Status" {
+			code, _, _ := strings.Cut(value, " ")
+			if n, err := strconv.Atoi(code); err == nil {
+				status = n
+			}
+			continue
+		}
+		headers[name] = append(headers[name], value)
+	}
+	return status, headers, raw[end:]
+}
@@ -0,0 +1,143 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// ErrPHPFatal matches (via errors.Is) every *FatalError.
+var ErrPHPFatal = errors.New("php fatal error")
+
+// FatalError is returned when PHP died of a fatal error (uncaught exception,
+// parse error, memory exhausted, ...) while handling the request, rather
+// than the worker merely losing its connection.
+type FatalError struct {
+	Message  string   `json:"message"`
+	File     string   `json:"file,omitempty"`
+	Line     int      `json:"line,omitempty"`
+	ExitCode int      `json:"exit_code,omitempty"` // 0 if the process hadn't exited
+	Output   []string `json:"output,omitempty"`    // stderr written during the request
+}
+
+func (e *FatalError) Error() string {
+	if e.File != "" {
+		return fmt.Sprintf("php fatal error: %s in %s on line %d", e.Message, e.File, e.Line)
+	}
+	return "php fatal error: " + e.Message
+}
+
+func (e *FatalError) Is(target error) bool { return target == ErrPHPFatal }
+
+const (
+	// phpFatalExitCode is the status the PHP CLI exits with after a fatal error.
+	phpFatalExitCode = 255
+
+	// fatalGrace bounds how long a failed request waits for the process to
+	// exit and its stderr to drain before deciding whether PHP fataled.
+	fatalGrace = 100 * time.Millisecond
+
+	maxFatalOutput = 20
+)
+
+var (
+	fatalLine  = regexp.MustCompile(`^(?:PHP )?(?:Fatal|Parse) [eE]rror:\s*(.*)$`)
+	fatalWhere = regexp.MustCompile(`^(.*?) in (\S+?)(?: on line |:)(\d+)$`)
+)
+
+// procExit tracks a spawned php process: done is closed once it has been
+// reaped (code then holds its exit status) and stderrDone once everything
+// it wrote to stderr is in the worker's ring.
+type procExit struct {
+	done       chan struct{}
+	stderrDone chan struct{}
+	code       int
+}
+
+func watchProcess(proc *os.Process, stderr *os.File, ring *logRing) *procExit {
+	pe := &procExit{done: make(chan struct{}), stderrDone: make(chan struct{})}
+	go func() {
+		_, _ = io.Copy(ring, stderr)
+		_ = stderr.Close()
+		ring.flush()
+		close(pe.stderrDone)
+	}()
+	go func() {
+		pe.code = -1
+		if st, err := proc.Wait(); err == nil {
+			pe.code = st.ExitCode()
+		}
+		close(pe.done)
+	}()
+	return pe
+}
+
+// parseFatal returns the first PHP fatal error reported in lines.
+func parseFatal(lines []LogLine) *FatalError {
+	for _, l := range lines {
+		m := fatalLine.FindStringSubmatch(l.Line)
+		if m == nil {
+			continue
+		}
+		fe := &FatalError{Message: m[1]}
+		if w := fatalWhere.FindStringSubmatch(m[1]); w != nil {
+			fe.Message, fe.File = w[1], w[2]
+			fe.Line, _ = strconv.Atoi(w[3])
+		}
+		return fe
+	}
+	return nil
+}
+
+// fatal decides whether err, returned for a request that began at since,
+// was caused by PHP dying of a fatal error, judging by what the worker
+// wrote to stderr since then and how its process exited. Timeouts are ours,
+// not PHP's, so they never count.
+func (w *Worker) fatal(since time.Time, err error) *FatalError {
+	var timeout *timeoutError
+	if errors.As(err, &timeout) || w.stderr == nil {
+		return nil
+	}
+
+	exited, code := false, 0
+	if pe := w.exit.Load(); pe != nil {
+		select {
+		case <-pe.done:
+			exited, code = true, pe.code
+			select {
+			case <-pe.stderrDone:
+			case <-time.After(fatalGrace):
+			}
+		case <-time.After(fatalGrace):
+		}
+	}
+
+	var lines []LogLine
+	for _, l := range w.stderr.last(0) {
+		if !l.Time.Before(since) {
+			lines = append(lines, l)
+		}
+	}
+
+	fe := parseFatal(lines)
+	if fe == nil {
+		if !exited || code != phpFatalExitCode {
+			return nil
+		}
+		fe = &FatalError{Message: fmt.Sprintf("worker exited with status %d", code)}
+	}
+	if exited {
+		fe.ExitCode = code
+	}
+	if len(lines) > maxFatalOutput {
+		lines = lines[len(lines)-maxFatalOutput:]
+	}
+	for _, l := range lines {
+		fe.Output = append(fe.Output, l.Line)
+	}
+	return fe
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestParseFatal(t *testing.T) {
+	cases := []struct {
+		line, msg, file string
+		lineNo          int
+	}{
+		{"PHP Fatal error:  Uncaught Exception: boom in /app/src/Kernel.php:42", "Uncaught Exception: boom", "/app/src/Kernel.php", 42},
+		{"PHP Fatal error:  Allowed memory size of 134217728 bytes exhausted (tried to allocate 20480 bytes) in /app/a.php on line 7",
+			"Allowed memory size of 134217728 bytes exhausted (tried to allocate 20480 bytes)", "/app/a.php", 7},
+		{"PHP Parse error:  syntax error, unexpected token \"}\" in /app/routes/web.php on line 12", "syntax error, unexpected token \"}\"", "/app/routes/web.php", 12},
+		{"PHP Fatal Error: Database is down", "Database is down", "", 0},
+	}
+	for _, c := range cases {
+		fe := parseFatal([]LogLine{{Line: "PHP Warning: harmless"}, {Line: c.line}})
+		if fe == nil {
+			t.Fatalf("%q: expected a fatal error", c.line)
+		}
+		if fe.Message != c.msg || fe.File != c.file || fe.Line != c.lineNo {
+			t.Fatalf("%q: got %+v", c.line, fe)
+		}
+	}
+	if fe := parseFatal([]LogLine{{Line: "PHP Warning: Undefined variable $x"}}); fe != nil {
+		t.Fatalf("warnings are not fatal, got %+v", fe)
+	}
+}
+
+func TestWorkerFatalUsesStderrSinceRequestStart(t *testing.T) {
+	w := &Worker{stderr: newLogRing(1)}
+	_, _ = w.stderr.Write([]byte("PHP Fatal error:  old news in /a.php on line 1\n"))
+	start := time.Now()
+
+	if fe := w.fatal(start, io.ErrUnexpectedEOF); fe != nil {
+		t.Fatalf("stderr from before the request should be ignored, got %+v", fe)
+	}
+
+	_, _ = w.stderr.Write([]byte("PHP Fatal error:  Uncaught Error: Call to undefined function foo() in /app/x.php:3\n"))
+	pe := &procExit{done: make(chan struct{}), stderrDone: make(chan struct{}), code: phpFatalExitCode}
+	close(pe.done)
+	close(pe.stderrDone)
+	w.exit.Store(pe)
+
+	fe := w.fatal(start, io.ErrUnexpectedEOF)
+	if fe == nil || fe.File != "/app/x.php" || fe.Line != 3 || fe.ExitCode != phpFatalExitCode {
+		t.Fatalf("unexpected fatal: %+v", fe)
+	}
+	if len(fe.Output) != 1 {
+		t.Fatalf("expected the request's stderr in Output, got %q", fe.Output)
+	}
+	if !errors.Is(fe, ErrPHPFatal) || retryable(fe) {
+		t.Fatalf("fatal errors should match ErrPHPFatal and not be retried")
+	}
+	if w.fatal(start, &timeoutError{op: "request", after: time.Second}) != nil {
+		t.Fatalf("timeouts are never reported as fatal")
+	}
+}
+
+func TestWorkerFatalFromExitCodeAlone(t *testing.T) {
+	w := &Worker{stderr: newLogRing(1)}
+	pe := &procExit{done: make(chan struct{}), stderrDone: make(chan struct{}), code: phpFatalExitCode}
+	close(pe.done)
+	close(pe.stderrDone)
+	w.exit.Store(pe)
+
+	fe := w.fatal(time.Now(), io.ErrUnexpectedEOF)
+	if fe == nil || fe.ExitCode != phpFatalExitCode {
+		t.Fatalf("exit status 255 should count as fatal, got %+v", fe)
+	}
+
+	pe.code = 0
+	if fe := w.fatal(time.Now(), io.ErrUnexpectedEOF); fe != nil {
+		t.Fatalf("a clean exit is not fatal, got %+v", fe)
+	}
+}
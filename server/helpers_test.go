@@ -19,8 +19,7 @@ func newFakeWorker(t *testing.T, label string, timeout time.Duration) *Worker {
 	stdoutR, stdoutW := io.Pipe()
 
 	w := &Worker{
-		stdin:          stdinW,
-		stdout:         stdoutR,
+		transport:      NewStreamTransport(stdinW, stdoutR, nil),
 		maxRequests:    1000,
 		requestTimeout: timeout,
 	}
@@ -72,7 +71,7 @@ func newFakeWorker(t *testing.T, label string, timeout time.Duration) *Worker {
 				Headers: map[string]string{
 					"X-Worker": label,
 				},
-				Body: label + ":" + req.Path,
+				Body: []byte(label + ":" + req.Path),
 			}
 
 			respJSON, err := json.Marshal(&resp)
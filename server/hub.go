@@ -0,0 +1,28 @@
+package server
+
+import "sync/atomic"
+
+// HubStats are gauges and counters for a realtime hub.
+type HubStats struct {
+	Channels  int    `json:"channels"`
+	Clients   int    `json:"clients"`
+	Published uint64 `json:"published"` // messages accepted by Publish
+	Delivered uint64 `json:"delivered"` // messages queued to a client
+	Dropped   uint64 `json:"dropped"`   // messages skipped because a client was backed up
+}
+
+type hubCounters struct {
+	published atomic.Uint64
+	delivered atomic.Uint64
+	dropped   atomic.Uint64
+}
+
+func (c *hubCounters) stats(channels, clients int) HubStats {
+	return HubStats{
+		Channels:  channels,
+		Clients:   clients,
+		Published: c.published.Load(),
+		Delivered: c.delivered.Load(),
+		Dropped:   c.dropped.Load(),
+	}
+}
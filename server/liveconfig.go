@@ -0,0 +1,133 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// SlowRouting is the static part of the slow-request heuristics: prefixes
+// and methods sent to the slow pool, and the body size above which a
+// request is slow. Prefixes promoted by adaptive routing are kept on top.
+type SlowRouting struct {
+	Routes        []string `json:"slow_routes"`
+	Methods       []string `json:"slow_methods"`
+	BodyThreshold int      `json:"slow_body_threshold"`
+}
+
+// SlowRouting returns the configured slow-request rules.
+func (s *Server) SlowRouting() SlowRouting {
+	s.routeMu.Lock()
+	defer s.routeMu.Unlock()
+
+	sr := SlowRouting{
+		Methods:       append([]string(nil), s.slowCfg.Methods...),
+		BodyThreshold: s.slowCfg.BodyThreshold,
+	}
+	for _, p := range s.slowCfg.RoutePrefixes {
+		if !s.promoted[p] {
+			sr.Routes = append(sr.Routes, p)
+		}
+	}
+	return sr
+}
+
+// SetSlowRouting replaces the slow-request rules at runtime.
+func (s *Server) SetSlowRouting(sr SlowRouting) error {
+	if sr.BodyThreshold < 0 {
+		return errors.New("slow_body_threshold must not be negative")
+	}
+	for _, m := range sr.Methods {
+		if strings.TrimSpace(m) == "" {
+			return errors.New("slow_methods: empty method")
+		}
+	}
+
+	s.routeMu.Lock()
+	defer s.routeMu.Unlock()
+
+	prefixes := append([]string(nil), sr.Routes...)
+	for p := range s.promoted {
+		if containsString(prefixes, p) {
+			delete(s.promoted, p) // now configured, so never demoted
+		} else {
+			prefixes = append(prefixes, p)
+		}
+	}
+	s.slowCfg.RoutePrefixes = prefixes
+	s.slowCfg.Methods = append([]string(nil), sr.Methods...)
+	s.slowCfg.BodyThreshold = sr.BodyThreshold
+	return nil
+}
+
+func containsString(list []string, v string) bool {
+	for _, s := range list {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+// PoolRoutes returns the pool routes in effect.
+func (s *Server) PoolRoutes() []PoolRoute {
+	return append([]PoolRoute(nil), s.poolRoutes()...)
+}
+
+func (s *Server) poolRoutes() []PoolRoute {
+	s.routesMu.RLock()
+	defer s.routesMu.RUnlock()
+	return s.routes
+}
+
+// SetPoolRoutes replaces the pool routes at runtime. Every route must
+// target an existing pool.
+func (s *Server) SetPoolRoutes(routes []PoolRoute) error {
+	for i, rt := range routes {
+		if _, ok := s.pools[rt.Pool]; !ok {
+			return fmt.Errorf("pool route %d: unknown pool %q", i, rt.Pool)
+		}
+	}
+	s.routesMu.Lock()
+	s.routes = append([]PoolRoute(nil), routes...)
+	s.routesMu.Unlock()
+	return nil
+}
+
+// LoadShed returns the load-shedding limits in effect (zero when off).
+func (s *Server) LoadShed() LoadShed {
+	if st := s.loadShed.Load(); st != nil {
+		return st.cfg
+	}
+	return LoadShed{}
+}
+
+// timeout is the worker's current request timeout (0 = none).
+func (w *Worker) timeout() time.Duration {
+	if d := w.timeoutUpdate.Load(); d != nil {
+		return *d
+	}
+	return w.requestTimeout
+}
+
+// SetRequestTimeout changes the timeout for requests that start from now
+// on, for every current and future worker in the pool (0 disables it).
+func (p *WorkerPool) SetRequestTimeout(d time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.cfg.RequestTimeout = d
+	for _, w := range p.workers {
+		if w != nil {
+			w.timeoutUpdate.Store(&d)
+		}
+	}
+}
+
+// RequestTimeout returns the pool's current request timeout.
+func (p *WorkerPool) RequestTimeout() time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.cfg.RequestTimeout
+}
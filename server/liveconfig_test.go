@@ -0,0 +1,46 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSetSlowRoutingKeepsAdaptivePromotions(t *testing.T) {
+	s := newServer(map[string]*WorkerPool{}, nil, nil, SlowRequestConfig{RoutePrefixes: []string{"/old"}})
+	s.routeMu.Lock()
+	s.slowCfg.RoutePrefixes = append(s.slowCfg.RoutePrefixes, "/auto")
+	s.promoted = map[string]bool{"/auto": true}
+	s.routeMu.Unlock()
+
+	if err := s.SetSlowRouting(SlowRouting{Routes: []string{"/reports"}, Methods: []string{"POST"}}); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.SlowRouting(); len(got.Routes) != 1 || got.Routes[0] != "/reports" {
+		t.Fatalf("configured routes = %v", got.Routes)
+	}
+	for path, want := range map[string]bool{"/reports/x": true, "/auto/y": true, "/old/z": false} {
+		if got := s.IsSlowRequest(&RequestPayload{Method: "GET", Path: path}); got != want {
+			t.Errorf("IsSlowRequest(%s) = %v, want %v", path, got, want)
+		}
+	}
+	if !s.IsSlowRequest(&RequestPayload{Method: "POST", Path: "/"}) {
+		t.Errorf("slow_methods not applied")
+	}
+	if err := s.SetSlowRouting(SlowRouting{BodyThreshold: -1}); err == nil {
+		t.Errorf("negative threshold should be rejected")
+	}
+}
+
+func TestPoolSetRequestTimeout(t *testing.T) {
+	pool := newFakePool(t, 2, time.Second)
+	pool.SetRequestTimeout(250 * time.Millisecond)
+
+	if got := pool.RequestTimeout(); got != 250*time.Millisecond {
+		t.Fatalf("RequestTimeout = %v", got)
+	}
+	for _, w := range pool.workers {
+		if got := w.timeout(); got != 250*time.Millisecond {
+			t.Fatalf("worker timeout = %v", got)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package server
+
+import "errors"
+
+// DefaultWorkerRSSEstimate is the per-worker memory assumed before any
+// worker has been measured (or when none are running).
+const DefaultWorkerRSSEstimate = 64 << 20
+
+// memoryReserve is the share of the memory budget kept free for the Go
+// process, page cache and spikes.
+const memoryReserve = 0.10
+
+var errMemoryUnsupported = errors.New("memory stats are not supported on this platform")
+
+// MemoryHeadroom compares the workers' memory use with what the machine (or
+// container) can spare.
+type MemoryHeadroom struct {
+	AvailableBytes        uint64 `json:"available_bytes"` // free memory, capped by the cgroup limit
+	WorkerRSSBytes        uint64 `json:"worker_rss_bytes"`
+	PerWorkerBytes        uint64 `json:"per_worker_bytes"` // measured average, or the estimate
+	Workers               int    `json:"workers"`
+	RecommendedMaxWorkers int    `json:"recommended_max_workers"`
+	OverProvisioned       bool   `json:"over_provisioned"`
+}
+
+// ComputeHeadroom works out how many workers fit in memory. rss is the
+// resident memory of the sampled workers that are running now; those are
+// counted as part of the budget since they'd be freed by shrinking a pool.
+// estimate is used as the per-worker size when nothing was sampled.
+func ComputeHeadroom(workers int, rss uint64, sampled int, estimate uint64) (MemoryHeadroom, error) {
+	available, err := availableMemory()
+	if err != nil {
+		return MemoryHeadroom{}, err
+	}
+
+	perWorker := estimate
+	if sampled > 0 {
+		perWorker = rss / uint64(sampled)
+	}
+	if perWorker == 0 {
+		perWorker = DefaultWorkerRSSEstimate
+	}
+
+	budget := float64(available+rss) * (1 - memoryReserve)
+	recommended := int(budget / float64(perWorker))
+
+	return MemoryHeadroom{
+		AvailableBytes:        available,
+		WorkerRSSBytes:        rss,
+		PerWorkerBytes:        perWorker,
+		Workers:               workers,
+		RecommendedMaxWorkers: recommended,
+		OverProvisioned:       workers > recommended,
+	}, nil
+}
+
+// WorkerMemory sums the resident memory of every running worker. workers
+// counts all configured worker slots; sampled those that could be measured.
+func (s *Server) WorkerMemory() (rss uint64, sampled, workers int) {
+	for _, p := range s.pools {
+		p.mu.Lock()
+		list := append([]*Worker(nil), p.workers...)
+		p.mu.Unlock()
+
+		for _, w := range list {
+			workers++
+			pid := w.pid.Load()
+			if pid == 0 || w.isDead() {
+				continue
+			}
+			if n, err := processRSS(int(pid)); err == nil {
+				rss += n
+				sampled++
+			}
+		}
+	}
+	return rss, sampled, workers
+}
+
+// Headroom reports this server's workers against available memory.
+func (s *Server) Headroom() (MemoryHeadroom, error) {
+	rss, sampled, workers := s.WorkerMemory()
+	return ComputeHeadroom(workers, rss, sampled, s.rssEstimate())
+}
+
+// SetWorkerRSSEstimate sets the per-worker size assumed before workers can
+// be measured.
+func (s *Server) SetWorkerRSSEstimate(bytes uint64) {
+	s.rssEstimateBytes.Store(bytes)
+}
+
+func (s *Server) rssEstimate() uint64 {
+	if b := s.rssEstimateBytes.Load(); b > 0 {
+		return b
+	}
+	return DefaultWorkerRSSEstimate
+}
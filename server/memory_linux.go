@@ -0,0 +1,91 @@
+//go:build linux
+
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// availableMemory returns MemAvailable, lowered to the cgroup's remaining
+// allowance when running under a memory limit.
+func availableMemory() (uint64, error) {
+	avail, err := meminfoField("/proc/meminfo", "MemAvailable")
+	if err != nil {
+		return 0, err
+	}
+
+	if limit, usage, ok := cgroupMemory(); ok && limit > usage && limit-usage < avail {
+		avail = limit - usage
+	}
+	return avail, nil
+}
+
+// processRSS returns the resident set size of pid.
+func processRSS(pid int) (uint64, error) {
+	return meminfoField(fmt.Sprintf("/proc/%d/status", pid), "VmRSS")
+}
+
+// meminfoField reads a "Name:   1234 kB" line from a /proc file.
+func meminfoField(path, name string) (uint64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		key, rest, ok := strings.Cut(sc.Text(), ":")
+		if !ok || key != name {
+			continue
+		}
+		fields := strings.Fields(rest)
+		if len(fields) == 0 {
+			break
+		}
+		n, err := strconv.ParseUint(fields[0], 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		if len(fields) > 1 && strings.EqualFold(fields[1], "kB") {
+			n *= 1024
+		}
+		return n, nil
+	}
+	if err := sc.Err(); err != nil {
+		return 0, err
+	}
+	return 0, fmt.Errorf("%s: no %s field", path, name)
+}
+
+// cgroupMemory reads the memory limit and usage of our cgroup (v2, then v1).
+func cgroupMemory() (limit, usage uint64, ok bool) {
+	pairs := [][2]string{
+		{"/sys/fs/cgroup/memory.max", "/sys/fs/cgroup/memory.current"},
+		{"/sys/fs/cgroup/memory/memory.limit_in_bytes", "/sys/fs/cgroup/memory/memory.usage_in_bytes"},
+	}
+	for _, p := range pairs {
+		limit, err := readUintFile(p[0])
+		if err != nil {
+			continue // "max" (no limit) or not mounted
+		}
+		usage, err := readUintFile(p[1])
+		if err != nil {
+			continue
+		}
+		return limit, usage, true
+	}
+	return 0, 0, false
+}
+
+func readUintFile(path string) (uint64, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(strings.TrimSpace(string(raw)), 10, 64)
+}
@@ -0,0 +1,58 @@
+//go:build linux
+
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMeminfoField(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "meminfo")
+	raw := "MemTotal:       16000000 kB\nMemAvailable:    8000000 kB\nHugePages_Total:       0\n"
+	if err := os.WriteFile(path, []byte(raw), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	if got, err := meminfoField(path, "MemAvailable"); err != nil || got != 8000000*1024 {
+		t.Fatalf("MemAvailable = %d, %v", got, err)
+	}
+	if got, err := meminfoField(path, "HugePages_Total"); err != nil || got != 0 {
+		t.Fatalf("HugePages_Total = %d, %v", got, err)
+	}
+	if _, err := meminfoField(path, "Missing"); err == nil {
+		t.Fatalf("expected error for missing field")
+	}
+}
+
+func TestWorkerMemoryAndHeadroom(t *testing.T) {
+	// Pretend the test process is a worker so there is a real RSS to read.
+	live := &Worker{}
+	live.pid.Store(int64(os.Getpid()))
+	s := &Server{pools: map[string]*WorkerPool{
+		FastPool: {workers: []*Worker{live, {}}},
+	}}
+
+	rss, sampled, workers := s.WorkerMemory()
+	if workers != 2 || sampled != 1 || rss == 0 {
+		t.Fatalf("WorkerMemory = %d, %d, %d", rss, sampled, workers)
+	}
+
+	h, err := s.Headroom()
+	if err != nil {
+		t.Fatalf("Headroom: %v", err)
+	}
+	if h.PerWorkerBytes == 0 || h.RecommendedMaxWorkers <= 0 || h.Workers != 2 {
+		t.Fatalf("unexpected headroom: %+v", h)
+	}
+
+	// A worker size bigger than the whole machine can never fit.
+	h, err = ComputeHeadroom(1, 0, 0, 1<<62)
+	if err != nil {
+		t.Fatalf("ComputeHeadroom: %v", err)
+	}
+	if !h.OverProvisioned || h.RecommendedMaxWorkers != 0 {
+		t.Fatalf("expected over-provisioned headroom, got %+v", h)
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package server
+
+func availableMemory() (uint64, error) {
+	return 0, errMemoryUnsupported
+}
+
+func processRSS(pid int) (uint64, error) {
+	return 0, errMemoryUnsupported
+}
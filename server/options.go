@@ -0,0 +1,115 @@
+package server
+
+import (
+	"log"
+	"slices"
+	"time"
+)
+
+// Option configures a Server built by New.
+type Option func(*serverOptions)
+
+type serverOptions struct {
+	pools       []PoolConfig
+	routes      []PoolRoute
+	slowCfg     SlowRequestConfig
+	timeout     time.Duration
+	maxRequests int
+	balancer    string
+	transport   string
+	address     string
+	logger      *log.Logger
+}
+
+// WithPools sets the worker pools. Without it, New builds the classic
+// fast/slow pair with 4 and 2 workers.
+func WithPools(pools ...PoolConfig) Option {
+	return func(o *serverOptions) { o.pools = append(o.pools, pools...) }
+}
+
+// WithPoolRoutes pins path prefixes (and headers, cookies, ...) to pools.
+func WithPoolRoutes(routes ...PoolRoute) Option {
+	return func(o *serverOptions) { o.routes = append(o.routes, routes...) }
+}
+
+// WithSlowRequests sets the heuristics that send requests to the slow pool.
+func WithSlowRequests(cfg SlowRequestConfig) Option {
+	return func(o *serverOptions) { o.slowCfg = cfg }
+}
+
+// WithTimeout is the request timeout for pools that don't set their own.
+func WithTimeout(d time.Duration) Option {
+	return func(o *serverOptions) { o.timeout = d }
+}
+
+// WithMaxRequests recycles workers after n requests, for pools that don't
+// set their own limit.
+func WithMaxRequests(n int) Option {
+	return func(o *serverOptions) { o.maxRequests = n }
+}
+
+// WithBalancer picks workers within pools that don't name their own
+// balancer (see NewBalancer for the names).
+func WithBalancer(name string) Option {
+	return func(o *serverOptions) { o.balancer = name }
+}
+
+// WithTransport reaches workers over transport at address instead of
+// spawning php, for pools that don't set a transport (see PoolConfig).
+func WithTransport(transport, address string) Option {
+	return func(o *serverOptions) { o.transport, o.address = transport, address }
+}
+
+// WithLogger sends the server's and its workers' log output to l instead
+// of the standard logger.
+func WithLogger(l *log.Logger) Option {
+	return func(o *serverOptions) { o.logger = l }
+}
+
+// New starts a server configured by opts. Settings given to individual
+// pools win over the server-wide WithTimeout, WithMaxRequests, WithBalancer,
+// WithTransport and WithLogger.
+func New(opts ...Option) (*Server, error) {
+	var o serverOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	pools := slices.Clone(o.pools)
+	if len(pools) == 0 {
+		pools = legacyPools(4, 2, 0, 0)
+	}
+	for i := range pools {
+		p := &pools[i]
+		if p.RequestTimeout <= 0 {
+			p.RequestTimeout = o.timeout
+		}
+		if p.MaxRequests <= 0 {
+			p.MaxRequests = o.maxRequests
+		}
+		if p.Balancer == "" {
+			p.Balancer = o.balancer
+		}
+		if p.Transport == "" {
+			p.Transport, p.Address = o.transport, o.address
+		}
+		if p.Logger == nil {
+			p.Logger = o.logger
+		}
+	}
+
+	s, err := NewServerWithPools(pools, o.routes, o.slowCfg)
+	if err != nil {
+		return nil, err
+	}
+	s.logger = o.logger
+	return s, nil
+}
+
+// logTo returns l, or the standard logger if l is nil.
+func logTo(l *log.Logger) *log.Logger {
+	if l != nil {
+		return l
+	}
+	return log.Default()
+}
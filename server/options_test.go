@@ -0,0 +1,77 @@
+package server
+
+import (
+	"bytes"
+	"log"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestNewAppliesServerWideOptions(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer c.Close()
+		}
+	}()
+
+	var logs bytes.Buffer
+	logger := log.New(&logs, "", 0)
+	srv, err := New(
+		WithPools(
+			PoolConfig{Name: FastPool, Workers: 2},
+			PoolConfig{Name: "reports", Workers: 1, RequestTimeout: time.Minute, Balancer: BalanceEWMA},
+		),
+		WithPoolRoutes(PoolRoute{Prefix: "/reports", Pool: "reports"}),
+		WithTimeout(3*time.Second),
+		WithMaxRequests(50),
+		WithBalancer(BalanceLeastOutstanding),
+		WithTransport(TransportTCP, ln.Addr().String()),
+		WithSlowRequests(SlowRequestConfig{Adaptive: AdaptiveConfig{MinSamples: 1}}),
+		WithLogger(logger),
+	)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer srv.DrainWorkers()
+
+	fast, reports := srv.Pool(FastPool).cfg, srv.Pool("reports").cfg
+	if fast.RequestTimeout != 3*time.Second || fast.MaxRequests != 50 || fast.Balancer != BalanceLeastOutstanding {
+		t.Errorf("fast pool did not get the server-wide settings: %+v", fast)
+	}
+	if fast.Transport != TransportTCP || fast.Logger != logger {
+		t.Errorf("fast pool transport/logger = %q/%v", fast.Transport, fast.Logger)
+	}
+	if reports.RequestTimeout != time.Minute || reports.Balancer != BalanceEWMA {
+		t.Errorf("pool settings must win over server-wide ones: %+v", reports)
+	}
+	if got := srv.PoolFor(&RequestPayload{Method: "GET", Path: "/reports/daily"}); got != "reports" {
+		t.Errorf("PoolFor(/reports/daily) = %q", got)
+	}
+
+	if w := srv.Pool(FastPool).workers[0]; w.logger != logger {
+		t.Errorf("workers should log to the configured logger")
+	}
+	srv.RecordLatency("/slow-thing", time.Hour)
+	if !bytes.Contains(logs.Bytes(), []byte("promoting")) {
+		t.Errorf("server messages did not reach the logger: %q", logs.String())
+	}
+}
+
+func TestNewRejectsBadOptions(t *testing.T) {
+	if _, err := New(WithPools(PoolConfig{Name: FastPool, Workers: 1}), WithBalancer("coin_flip")); err == nil {
+		t.Fatal("unknown balancer should fail")
+	}
+	if _, err := New(WithPoolRoutes(PoolRoute{Prefix: "/x", Pool: "nope"})); err == nil {
+		t.Fatal("route to an unknown pool should fail")
+	}
+}
@@ -1,18 +1,180 @@
 package server
 
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// RequestPayload and ResponsePayload carry their bodies as bytes. On the
+// wire a body is the JSON string "body" when it is valid UTF-8, which is
+// nearly always, and "body_base64" otherwise, so binary uploads and
+// downloads survive the trip instead of having their invalid bytes
+// replaced. Workers that predate body_base64 can be kept on plain strings
+// with PoolConfig.TextBodies.
 type RequestPayload struct {
 	ID      string              `json:"id"`
 	Method  string              `json:"method"`
 	Path    string              `json:"path"`
 	Headers map[string][]string `json:"headers"`
-	Body    string              `json:"body"`
+	Body    []byte              `json:"body"`
+
+	// ServerParams are CGI-style $_SERVER entries (REMOTE_ADDR, HTTPS,
+	// SERVER_PORT, REQUEST_TIME_FLOAT, DOCUMENT_ROOT, ...).
+	ServerParams map[string]string `json:"server,omitempty"`
+
+	// Form and Files carry a multipart/form-data body already decoded,
+	// for workers that can't parse it themselves (see parse_uploads).
+	// Body is empty then, as php://input is for such requests.
+	Form  map[string][]string `json:"form,omitempty"`
+	Files []UploadedFile      `json:"files,omitempty"`
+
+	// RelayOver, when positive, lets the worker send a body larger than
+	// this many bytes in chunks after the response head (see relay.go).
+	RelayOver int `json:"relay_over,omitempty"`
+
+	priority Priority // set by Server.Dispatch; not sent to PHP
+	pool     string   // set by Server.Dispatch; not sent to PHP
+
+	// Summed over retries by the workers that handled the request
+	// (buffered dispatches only).
+	queueWait  time.Duration
+	workerTime time.Duration
+	worker     uint64 // id of the last worker that handled it
+	restarts   uint64 // that worker's restart count at the time
+	attempts   int
+}
+
+// Timing returns how long r waited for a worker and how long workers then
+// spent on it, summed over retries.
+func (r *RequestPayload) Timing() (queue, worker time.Duration) {
+	return r.queueWait, r.workerTime
+}
+
+// Worker returns the id of the worker that last handled r, as shown on the
+// scoreboard, or 0 if none did (or it was streamed).
+func (r *RequestPayload) Worker() uint64 {
+	return r.worker
+}
+
+// Attempts returns how many times workers took r, more than one when it
+// was retried (buffered dispatches only).
+func (r *RequestPayload) Attempts() int {
+	return r.attempts
+}
+
+// WorkerRestarts returns how many times the worker from Worker had been
+// restarted when it took r.
+func (r *RequestPayload) WorkerRestarts() uint64 {
+	return r.restarts
+}
+
+// Pool returns the name of the pool r was dispatched to, or "" before
+// dispatch.
+func (r *RequestPayload) Pool() string {
+	return r.pool
+}
+
+// UploadedFile is one file from a multipart request, saved to TmpName.
+// The fields mirror an entry of PHP's $_FILES.
+type UploadedFile struct {
+	Field   string `json:"field"` // form field name, e.g. "avatar" or "docs[]"
+	Name    string `json:"name"`  // client-side file name
+	Type    string `json:"type"`
+	TmpName string `json:"tmp_name"`
+	Size    int64  `json:"size"`
+	Error   int    `json:"error"` // a PHP UPLOAD_ERR_* code
 }
 
 type ResponsePayload struct {
 	ID      string            `json:"id"`
 	Status  int               `json:"status"`
 	Headers map[string]string `json:"headers"`
-	Body    string            `json:"body"`
+	Body    []byte            `json:"body"`
+
+	// Cookies holds Set-Cookie values, which can't share one Headers entry.
+	Cookies []string `json:"cookies,omitempty"`
+
+	// Relay is set by the worker on a head whose body follows in chunks.
+	Relay bool `json:"relay,omitempty"`
+
+	relay *bodyRelay // the rest of a relayed body, until read
+}
+
+// requestWire and responseWire are the payloads without their methods, so
+// the marshalers below can encode the other fields the default way.
+type (
+	requestWire  RequestPayload
+	responseWire ResponsePayload
+)
+
+func (r RequestPayload) MarshalJSON() ([]byte, error) {
+	text, b64 := encodeBody(r.Body)
+	return json.Marshal(struct {
+		*requestWire
+		Body       string `json:"body"`
+		BodyBase64 string `json:"body_base64,omitempty"`
+	}{(*requestWire)(&r), text, b64})
+}
+
+func (r *RequestPayload) UnmarshalJSON(data []byte) error {
+	w := struct {
+		*requestWire
+		Body       *string `json:"body"`
+		BodyBase64 *string `json:"body_base64"`
+	}{requestWire: (*requestWire)(r)}
+	if err := json.Unmarshal(data, &w); err != nil {
+		return err
+	}
+	body, err := decodeBody(w.Body, w.BodyBase64)
+	r.Body = body
+	return err
+}
+
+func (r ResponsePayload) MarshalJSON() ([]byte, error) {
+	text, b64 := encodeBody(r.Body)
+	return json.Marshal(struct {
+		*responseWire
+		Body       string `json:"body"`
+		BodyBase64 string `json:"body_base64,omitempty"`
+	}{(*responseWire)(&r), text, b64})
+}
+
+func (r *ResponsePayload) UnmarshalJSON(data []byte) error {
+	w := struct {
+		*responseWire
+		Body       *string `json:"body"`
+		BodyBase64 *string `json:"body_base64"`
+	}{responseWire: (*responseWire)(r)}
+	if err := json.Unmarshal(data, &w); err != nil {
+		return err
+	}
+	body, err := decodeBody(w.Body, w.BodyBase64)
+	r.Body = body
+	return err
+}
+
+// encodeBody picks the wire form of a body: text, or base64 when it isn't
+// valid UTF-8.
+func encodeBody(b []byte) (text, b64 string) {
+	if utf8.Valid(b) {
+		return string(b), ""
+	}
+	return "", base64.StdEncoding.EncodeToString(b)
+}
+
+func decodeBody(text, b64 *string) ([]byte, error) {
+	switch {
+	case b64 != nil && *b64 != "":
+		return base64.StdEncoding.DecodeString(*b64)
+	case text != nil && *text != "":
+		return []byte(*text), nil
+	}
+	return nil, nil
 }
 
 type StreamFrame struct {
@@ -22,3 +184,32 @@ type StreamFrame struct {
 	Data    string              `json:"data,omitempty"`    // for headers (optional) or chunk
 	Error   string              `json:"error,omitempty"`   // optional error message
 }
+
+// header returns the values of the named request header.
+func (r *RequestPayload) header(name string) []string {
+	return r.Headers[http.CanonicalHeaderKey(name)]
+}
+
+// cookie returns the values of the named cookie.
+func (r *RequestPayload) cookie(name string) []string {
+	// http.Request.Cookies skips malformed pairs instead of failing the line.
+	hr := &http.Request{Header: http.Header{"Cookie": r.header("Cookie")}}
+
+	var values []string
+	for _, c := range hr.Cookies() {
+		if c.Name == name {
+			values = append(values, c.Value)
+		}
+	}
+	return values
+}
+
+// query returns the values of the named query-string parameter.
+func (r *RequestPayload) query(name string) []string {
+	_, raw, ok := strings.Cut(r.Path, "?")
+	if !ok {
+		return nil
+	}
+	values, _ := url.ParseQuery(raw) // keeps the pairs that did parse
+	return values[name]
+}
@@ -2,6 +2,11 @@ package server
 
 import (
 	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"path/filepath"
+	"sort"
 	"sync"
 	"time"
 )
@@ -9,70 +14,289 @@ import (
 var ErrNoWorkers = errors.New("no workers available")
 
 type WorkerPool struct {
-	workers []*Worker
-	mu      sync.Mutex
-	next    int
+	workers  []*Worker
+	mu       sync.Mutex
+	balancer Balancer // nil means round robin
+	affinity Affinity
+
+	cfg      PoolConfig // template for workers added by Resize
+	resizeMu sync.Mutex
+}
+
+// PoolConfig describes a named worker pool and how its PHP processes are run.
+type PoolConfig struct {
+	Name           string
+	Workers        int
+	MaxRequests    int
+	RequestTimeout time.Duration
+
+	PHPBinary    string            // defaults to "php"
+	PHPIni       map[string]string // passed to php as -d key=value
+	WorkerScript string            // relative to the project root; defaults to php/worker.php
+	ProjectRoot  string            // worker cwd; defaults to the dir containing go.mod
+
+	// Transport selects how workers are reached: TransportStdio (spawn php,
+	// the default), TransportUnix / TransportTCP (connect to an external
+	// worker at Address speaking the same protocol) or TransportFastCGI
+	// (php-fpm at Address, e.g. "127.0.0.1:9000" or "unix:/run/php-fpm.sock",
+	// running WorkerScript, default public/index.php).
+	Transport string
+	Address   string
+
+	// TextBodies keeps request bodies plain strings on the wire for workers
+	// that don't understand "body_base64" (see JSONCodec.TextBodies).
+	TextBodies bool
+
+	// Balancer is BalanceRoundRobin (default), BalanceLeastOutstanding or
+	// BalanceEWMA; use WorkerPool.SetBalancer for a custom strategy.
+	Balancer string
+
+	// Affinity pins sessions to workers (off by default).
+	Affinity Affinity
+
+	// Logger receives worker output and lifecycle messages; nil means the
+	// standard logger.
+	Logger *log.Logger
+
+	// OnRestart, if set, is called each time one of the pool's workers gets
+	// a fresh process (or reconnects to its backend).
+	OnRestart func(WorkerRestart)
+
+	// Chaos, if set, injects faults into requests (see Server.SetChaos).
+	Chaos *Chaos
+}
+
+// WorkerRestart describes a worker that was just restarted.
+type WorkerRestart struct {
+	Pool      string `json:"pool"`
+	WorkerID  uint64 `json:"worker_id"`
+	PID       int    `json:"pid,omitempty"` // 0 for external workers
+	Restarts  uint64 `json:"restarts"`
+	LastError string `json:"last_error,omitempty"` // what killed the previous process, if known
+}
+
+// phpArgs turns PHPIni into -d flags, sorted so restarts are deterministic.
+func (c PoolConfig) phpArgs() []string {
+	keys := make([]string, 0, len(c.PHPIni))
+	for k := range c.PHPIni {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	args := make([]string, 0, 2*len(keys))
+	for _, k := range keys {
+		args = append(args, "-d", k+"="+c.PHPIni[k])
+	}
+	return args
+}
+
+// newWorker returns an unstarted worker configured for this pool.
+func (c PoolConfig) newWorker() (*Worker, error) {
+	w, err := newUnstartedWorker(c.MaxRequests, c.RequestTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.ProjectRoot != "" {
+		w.baseDir = c.ProjectRoot
+	}
+	w.logger = c.Logger
+	if c.OnRestart != nil {
+		name, notify := c.Name, c.OnRestart
+		w.onRestart = func(ev WorkerRestart) {
+			ev.Pool = name
+			notify(ev)
+		}
+	}
+	w.phpBinary = c.PHPBinary
+	w.phpArgs = c.phpArgs()
+	w.codec = c.codec()
+	w.chaos.Store(c.Chaos)
+	w.script = c.WorkerScript
+
+	script := c.WorkerScript
+	if c.Transport == TransportFastCGI && script == "" {
+		script = filepath.Join("public", "index.php")
+	}
+	if !filepath.IsAbs(script) {
+		script = filepath.Join(w.baseDir, script)
+	}
+	if w.dial, err = c.transportDialer(script); err != nil {
+		return nil, err
+	}
+	return w, nil
 }
 
 // NewPool creates a pool with count workers, each configured
 // with maxRequests and requestTimeout.
 func NewPool(count int, maxRequests int, requestTimeout time.Duration) (*WorkerPool, error) {
-	workers := make([]*Worker, 0, count)
+	return NewPoolFromConfig(PoolConfig{
+		Workers:        count,
+		MaxRequests:    maxRequests,
+		RequestTimeout: requestTimeout,
+	})
+}
+
+// NewPoolFromConfig creates a pool and starts cfg.Workers PHP workers.
+func NewPoolFromConfig(cfg PoolConfig) (*WorkerPool, error) {
+	balancer, err := NewBalancer(cfg.Balancer)
+	if err != nil {
+		return nil, err
+	}
+
+	workers := make([]*Worker, 0, cfg.Workers)
 
-	for i := 0; i < count; i++ {
-		w, err := NewWorker(maxRequests, requestTimeout)
+	for i := 0; i < cfg.Workers; i++ {
+		w, err := cfg.newWorker()
 		if err != nil {
 			return nil, err
 		}
+		if err := w.spawn(); err != nil {
+			for _, started := range workers {
+				started.kill()
+			}
+			return nil, err
+		}
 		workers = append(workers, w)
 	}
 
 	return &WorkerPool{
-		workers: workers,
+		workers:  workers,
+		balancer: balancer,
+		affinity: cfg.Affinity,
+		cfg:      cfg,
 	}, nil
 }
 
+// SetBalancer replaces the pool's load-balancing strategy.
+func (p *WorkerPool) SetBalancer(b Balancer) {
+	p.mu.Lock()
+	p.balancer = b
+	p.mu.Unlock()
+}
+
+func (p *WorkerPool) getBalancer() Balancer {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.balancer == nil {
+		p.balancer = &RoundRobin{}
+	}
+	return p.balancer
+}
+
 func (p *WorkerPool) Dispatch(req *RequestPayload) (*ResponsePayload, error) {
-	w := p.NextWorker()
+	w := p.workerFor(req)
 	if w == nil {
 		return nil, ErrNoWorkers
 	}
+	return p.dispatchOn(w, req)
+}
+
+// dispatchOn serves req on w and reports the outcome to the balancer.
+func (p *WorkerPool) dispatchOn(w *Worker, req *RequestPayload) (*ResponsePayload, error) {
+	start := time.Now()
+	resp, err := w.Handle(req)
+	p.getBalancer().Done(w, time.Since(start), err)
+	w.recordError(err)
+	return resp, err
+}
+
+// workerExcluding is workerFor, but never returns a worker in skip.
+func (p *WorkerPool) workerExcluding(req *RequestPayload, skip map[*Worker]bool) *Worker {
+	if w := p.workerFor(req); w == nil || !skip[w] {
+		return w
+	}
+
+	b := p.getBalancer()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	rest := make([]*Worker, 0, len(p.workers))
+	for _, w := range p.workers {
+		if !skip[w] {
+			rest = append(rest, w)
+		}
+	}
+	if len(rest) == 0 {
+		return nil
+	}
+	return b.Pick(rest)
+}
 
-	return w.Handle(req)
+// Stream serves req as a streamed response on the next worker.
+func (p *WorkerPool) Stream(req *RequestPayload, rw http.ResponseWriter) error {
+	w := p.workerFor(req)
+	if w == nil {
+		// no healthy workers in pool
+		return ErrNoWorkers
+	}
+
+	start := time.Now()
+	err := w.Stream(req, rw)
+	p.getBalancer().Done(w, time.Since(start), err)
+	w.recordError(err)
+	return err
 }
+
 func (p *WorkerPool) Stats() PoolStats {
 	stats := PoolStats{}
 	if p == nil {
 		return stats
 	}
 
-	stats.Workers = len(p.workers)
-	for _, w := range p.workers {
-		if w != nil && w.isDead() {
+	workers := p.list()
+	stats.Workers = len(workers)
+	var wait time.Duration
+	now := time.Now()
+	for i, w := range workers {
+		if w == nil {
+			stats.Details = append(stats.Details, WorkerHealth{Slot: i, State: SlotDead})
+			continue
+		}
+		stats.Details = append(stats.Details, w.health(i, now))
+		if w.isDead() {
 			stats.DeadWorkers++
 		}
+		stats.InFlight += w.InFlight()
+		wait += w.QueueWait()
+	}
+	if stats.Workers > 0 {
+		stats.QueueWaitMs = float64(wait) / float64(time.Millisecond) / float64(stats.Workers)
 	}
 
 	return stats
 }
 
+// list returns a snapshot of the pool's workers, which Resize may change.
+func (p *WorkerPool) list() []*Worker {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]*Worker(nil), p.workers...)
+}
+
+// NextWorker asks the pool's balancer for an available worker.
 func (p *WorkerPool) NextWorker() *Worker {
+	b := p.getBalancer()
+
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
-	n := len(p.workers)
-	if n == 0 {
+	if len(p.workers) == 0 {
 		return nil
 	}
+	return b.Pick(p.workers)
+}
 
-	for i := 0; i < n; i++ {
-		w := p.workers[p.next]
-		p.next = (p.next + 1) % n
-		if w != nil && !w.isDead() && !w.isDraining() {
-			return w
+// killAll terminates every worker process in the pool.
+func (p *WorkerPool) killAll() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, w := range p.workers {
+		if w != nil {
+			w.markDead()
+			w.kill()
 		}
 	}
-	return nil
 }
 
 func (p *WorkerPool) DrainAll() {
@@ -85,14 +309,16 @@ func (p *WorkerPool) DrainAll() {
 	}
 }
 
-// ScaleTo lets you grow/shrink the pool
+// ScaleTo lets you grow/shrink the pool. New workers are made without
+// holding p.mu, so dispatch carries on while they start, and are only
+// added once all of them are up; if one fails, the ones already made are
+// stopped and the pool keeps its size.
 func (p *WorkerPool) ScaleTo(newSize int, factory func() (*Worker, error)) error {
 	p.mu.Lock()
-	defer p.mu.Unlock()
-
 	cur := len(p.workers)
 	switch {
 	case newSize == cur:
+		p.mu.Unlock()
 		return nil
 	case newSize < cur:
 		// mark extras as draining so they shut down after in-flight work
@@ -102,18 +328,26 @@ func (p *WorkerPool) ScaleTo(newSize int, factory func() (*Worker, error)) error
 			}
 		}
 		p.workers = p.workers[:newSize]
-		if p.next >= newSize {
-			p.next = 0
-		}
+		p.mu.Unlock()
 		return nil
-	default: // grow
-		for i := cur; i < newSize; i++ {
-			w, err := factory()
-			if err != nil {
-				return err
+	}
+	p.mu.Unlock()
+
+	// grow
+	added := make([]*Worker, 0, newSize-cur)
+	for range newSize - cur {
+		w, err := factory()
+		if err != nil {
+			for _, w := range added {
+				retireWorker(w)
 			}
-			p.workers = append(p.workers, w)
+			return fmt.Errorf("starting worker %d of %d: %w", len(added)+1, newSize-cur, err)
 		}
-		return nil
+		added = append(added, w)
 	}
+
+	p.mu.Lock()
+	p.workers = append(p.workers, added...)
+	p.mu.Unlock()
+	return nil
 }
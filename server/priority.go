@@ -0,0 +1,165 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Priority orders requests competing for the same worker: when a worker
+// frees up, the oldest waiting request of the highest priority goes next.
+type Priority int
+
+const (
+	PriorityLow Priority = iota - 1
+	PriorityNormal
+	PriorityHigh
+)
+
+func (p Priority) String() string {
+	switch p {
+	case PriorityLow:
+		return "low"
+	case PriorityHigh:
+		return "high"
+	default:
+		return "normal"
+	}
+}
+
+// ParsePriority accepts "low", "normal" (or "") and "high".
+func ParsePriority(s string) (Priority, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "low":
+		return PriorityLow, nil
+	case "", "normal":
+		return PriorityNormal, nil
+	case "high":
+		return PriorityHigh, nil
+	default:
+		return PriorityNormal, fmt.Errorf("unknown priority %q", s)
+	}
+}
+
+// PriorityRule gives matching requests a priority. Matching works like
+// PoolRoute: path prefix, optional methods, and optionally a header, cookie
+// or query parameter (equal to Value when Value is set).
+type PriorityRule struct {
+	Prefix   string   `json:"prefix"`
+	Methods  []string `json:"methods"`
+	Header   string   `json:"header"`
+	Cookie   string   `json:"cookie"`
+	Query    string   `json:"query"`
+	Value    string   `json:"value"`
+	Priority string   `json:"priority"` // "high", "normal" or "low"
+}
+
+// Priorities classifies requests. Rules are checked in order; if none
+// matches, the trusted Header (set by your own proxy, e.g. X-Priority:
+// high) is used, and otherwise the request is normal. Under load shedding,
+// low-priority requests are turned away at half the configured limits and
+// high-priority requests are never shed.
+type Priorities struct {
+	Rules  []PriorityRule `json:"rules"`
+	Header string         `json:"header"`
+}
+
+type priorityRule struct {
+	route    PoolRoute
+	priority Priority
+}
+
+type priorityConfig struct {
+	rules  []priorityRule
+	header string
+}
+
+// SetPriorities installs request priority rules; the zero Priorities makes
+// every request normal.
+func (s *Server) SetPriorities(cfg Priorities) error {
+	pc := &priorityConfig{header: cfg.Header}
+	for i, r := range cfg.Rules {
+		p, err := ParsePriority(r.Priority)
+		if err != nil {
+			return fmt.Errorf("priority rule %d: %w", i, err)
+		}
+		pc.rules = append(pc.rules, priorityRule{
+			route: PoolRoute{
+				Prefix:  r.Prefix,
+				Methods: r.Methods,
+				Header:  r.Header,
+				Cookie:  r.Cookie,
+				Query:   r.Query,
+				Value:   r.Value,
+			},
+			priority: p,
+		})
+	}
+
+	if len(pc.rules) == 0 && pc.header == "" {
+		s.priorities.Store(nil)
+		return nil
+	}
+	s.priorities.Store(pc)
+	return nil
+}
+
+// PriorityOf returns the priority req will be dispatched with.
+func (s *Server) PriorityOf(req *RequestPayload) Priority {
+	pc := s.priorities.Load()
+	if pc == nil {
+		return PriorityNormal
+	}
+
+	method := strings.ToUpper(req.Method)
+	for _, r := range pc.rules {
+		if r.route.matches(req, method) {
+			return r.priority
+		}
+	}
+	if pc.header != "" {
+		if v := req.header(pc.header); len(v) > 0 {
+			if p, err := ParsePriority(v[0]); err == nil {
+				return p
+			}
+		}
+	}
+	return PriorityNormal
+}
+
+// priorityGate is a lock that, when released, hands itself to the oldest
+// waiter of the highest priority instead of whoever grabs it first.
+type priorityGate struct {
+	mu      sync.Mutex
+	held    bool
+	waiters [3][]chan struct{} // indexed by Priority+1
+}
+
+func (g *priorityGate) lock(p Priority) {
+	g.mu.Lock()
+	if !g.held {
+		g.held = true
+		g.mu.Unlock()
+		return
+	}
+	ch := make(chan struct{})
+	idx := int(p - PriorityLow)
+	g.waiters[idx] = append(g.waiters[idx], ch)
+	g.mu.Unlock()
+
+	<-ch // ownership is handed over by unlock
+}
+
+func (g *priorityGate) unlock() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for i := len(g.waiters) - 1; i >= 0; i-- {
+		if q := g.waiters[i]; len(q) > 0 {
+			g.waiters[i] = q[1:]
+			close(q[0])
+			return
+		}
+	}
+	g.held = false
+}
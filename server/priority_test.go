@@ -0,0 +1,127 @@
+package server
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPriorityOf(t *testing.T) {
+	s := &Server{}
+	if err := s.SetPriorities(Priorities{
+		Rules: []PriorityRule{
+			{Prefix: "/checkout", Priority: "high"},
+			{Prefix: "/reports", Query: "export", Priority: "low"},
+		},
+		Header: "X-Priority",
+	}); err != nil {
+		t.Fatalf("SetPriorities: %v", err)
+	}
+
+	cases := []struct {
+		path   string
+		header string
+		want   Priority
+	}{
+		{"/checkout/pay", "", PriorityHigh},
+		{"/reports?export=csv", "", PriorityLow},
+		{"/reports", "", PriorityNormal},
+		{"/feed", "low", PriorityLow},
+		{"/feed", "bogus", PriorityNormal},
+		{"/checkout", "low", PriorityHigh}, // rules win over the header
+	}
+	for _, c := range cases {
+		req := &RequestPayload{Method: "GET", Path: c.path, Headers: map[string][]string{}}
+		if c.header != "" {
+			req.Headers["X-Priority"] = []string{c.header}
+		}
+		if got := s.PriorityOf(req); got != c.want {
+			t.Fatalf("%s (X-Priority=%q): PriorityOf = %v, want %v", c.path, c.header, got, c.want)
+		}
+	}
+
+	if err := s.SetPriorities(Priorities{Rules: []PriorityRule{{Prefix: "/", Priority: "urgent"}}}); err == nil {
+		t.Fatalf("expected an unknown priority to be rejected")
+	}
+}
+
+func TestPriorityGateServesHighFirst(t *testing.T) {
+	var g priorityGate
+	g.lock(PriorityNormal)
+
+	var (
+		mu    sync.Mutex
+		order []Priority
+		wg    sync.WaitGroup
+	)
+	queued := 0
+	enqueue := func(p Priority) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			g.lock(p)
+			mu.Lock()
+			order = append(order, p)
+			mu.Unlock()
+			g.unlock()
+		}()
+		// Wait until the goroutine is queued on the gate.
+		queued++
+		for g.queued() < queued {
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	enqueue(PriorityLow)
+	enqueue(PriorityNormal)
+	enqueue(PriorityHigh)
+	g.unlock()
+	wg.Wait()
+
+	want := []Priority{PriorityHigh, PriorityNormal, PriorityLow}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatalf("served in order %v, want %v", order, want)
+		}
+	}
+}
+
+func TestLoadShedByPriority(t *testing.T) {
+	pool := newFakePool(t, 1, time.Second)
+	s := &Server{pools: map[string]*WorkerPool{FastPool: pool}, poolOrder: []string{FastPool}}
+	if err := s.SetLoadShed(LoadShed{MaxInFlightPerWorker: 4}); err != nil {
+		t.Fatalf("SetLoadShed: %v", err)
+	}
+	if err := s.SetPriorities(Priorities{Header: "X-Priority"}); err != nil {
+		t.Fatalf("SetPriorities: %v", err)
+	}
+	pool.workers[0].incrInFlight()
+	pool.workers[0].incrInFlight()
+
+	req := func(p string) *RequestPayload {
+		return &RequestPayload{ID: p, Method: "GET", Path: "/", Headers: map[string][]string{"X-Priority": {p}}}
+	}
+	if _, err := s.Dispatch(req("normal")); err != nil {
+		t.Fatalf("normal request shed at 2/4 in flight: %v", err)
+	}
+	if _, err := s.Dispatch(req("low")); !errors.Is(err, ErrOverloaded) {
+		t.Fatalf("low request should be shed at half the limit, got %v", err)
+	}
+
+	pool.workers[0].incrInFlight()
+	pool.workers[0].incrInFlight()
+	if _, err := s.Dispatch(req("high")); err != nil {
+		t.Fatalf("high request shed: %v", err)
+	}
+}
+
+func (g *priorityGate) queued() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	n := 0
+	for _, q := range g.waiters {
+		n += len(q)
+	}
+	return n
+}
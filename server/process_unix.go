@@ -0,0 +1,43 @@
+//go:build !windows
+
+package server
+
+import (
+	"errors"
+	"os/exec"
+	"syscall"
+)
+
+// procGroup tracks the process group a worker runs in so the whole tree
+// (php plus anything it forked) can be killed together.
+type procGroup struct {
+	pgid int
+}
+
+// prepareCommand puts the worker in its own process group.
+func prepareCommand(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+func (g *procGroup) attach(cmd *exec.Cmd) error {
+	g.pgid = cmd.Process.Pid
+	return nil
+}
+
+func (g *procGroup) kill(cmd *exec.Cmd) {
+	if g.pgid > 0 {
+		if err := syscall.Kill(-g.pgid, syscall.SIGKILL); err == nil {
+			return
+		}
+	}
+	_ = cmd.Process.Kill()
+}
+
+func (g *procGroup) release() {
+	g.pgid = 0
+}
+
+// isPipeErrno matches the errno values a dead worker surfaces on Unix.
+func isPipeErrno(err error) bool {
+	return errors.Is(err, syscall.EPIPE) || errors.Is(err, syscall.ECONNRESET)
+}
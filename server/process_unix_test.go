@@ -0,0 +1,64 @@
+//go:build !windows
+
+package server
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestIsBrokenPipeWrappedErrno(t *testing.T) {
+	err := &os.PathError{Op: "write", Path: "|1", Err: syscall.EPIPE}
+	if !isBrokenPipe(fmt.Errorf("send request: %w", err)) {
+		t.Fatalf("expected wrapped EPIPE to be treated as broken pipe")
+	}
+
+	if !isBrokenPipe(os.ErrClosed) {
+		t.Fatalf("expected os.ErrClosed to be treated as broken pipe")
+	}
+}
+
+func TestWorkerKillTakesDownProcessGroup(t *testing.T) {
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("sh not available")
+	}
+
+	// The shell forks a child sleep; killing the group must reap both.
+	cmd := exec.Command(sh, "-c", "sleep 30 & wait")
+	prepareCommand(cmd)
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+
+	w := &Worker{cmd: cmd}
+	if err := w.proc.attach(cmd); err != nil {
+		t.Fatalf("attach: %v", err)
+	}
+	pgid := w.proc.pgid
+
+	done := make(chan struct{})
+	go func() {
+		w.kill()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("kill did not return")
+	}
+
+	// Orphaned children are reaped asynchronously by init, so poll briefly.
+	deadline := time.Now().Add(2 * time.Second)
+	for syscall.Kill(-pgid, 0) == nil {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected process group %d to be gone", pgid)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
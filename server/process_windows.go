@@ -0,0 +1,89 @@
+//go:build windows
+
+package server
+
+import (
+	"errors"
+	"os/exec"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// procGroup wraps a Windows job object. Every worker gets its own job
+// configured with KILL_ON_JOB_CLOSE, so terminating the job (or the Go
+// process exiting) takes down php.exe and any children it spawned.
+type procGroup struct {
+	job windows.Handle
+}
+
+// prepareCommand gives the worker its own console process group so a
+// Ctrl+C in the server's console is handled by Go (which drains workers)
+// rather than being delivered straight to every php.exe.
+func prepareCommand(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &windows.SysProcAttr{
+		CreationFlags: windows.CREATE_NEW_PROCESS_GROUP,
+	}
+}
+
+func (g *procGroup) attach(cmd *exec.Cmd) error {
+	job, err := windows.CreateJobObject(nil, nil)
+	if err != nil {
+		return err
+	}
+
+	info := windows.JOBOBJECT_EXTENDED_LIMIT_INFORMATION{
+		BasicLimitInformation: windows.JOBOBJECT_BASIC_LIMIT_INFORMATION{
+			LimitFlags: windows.JOB_OBJECT_LIMIT_KILL_ON_JOB_CLOSE,
+		},
+	}
+	if _, err := windows.SetInformationJobObject(
+		job,
+		windows.JobObjectExtendedLimitInformation,
+		uintptr(unsafe.Pointer(&info)),
+		uint32(unsafe.Sizeof(info)),
+	); err != nil {
+		_ = windows.CloseHandle(job)
+		return err
+	}
+
+	proc, err := windows.OpenProcess(windows.PROCESS_SET_QUOTA|windows.PROCESS_TERMINATE, false, uint32(cmd.Process.Pid))
+	if err != nil {
+		_ = windows.CloseHandle(job)
+		return err
+	}
+	defer windows.CloseHandle(proc)
+
+	if err := windows.AssignProcessToJobObject(job, proc); err != nil {
+		_ = windows.CloseHandle(job)
+		return err
+	}
+
+	g.job = job
+	return nil
+}
+
+func (g *procGroup) kill(cmd *exec.Cmd) {
+	if g.job != 0 {
+		if err := windows.TerminateJobObject(g.job, 1); err == nil {
+			return
+		}
+	}
+	_ = cmd.Process.Kill()
+}
+
+func (g *procGroup) release() {
+	if g.job != 0 {
+		_ = windows.CloseHandle(g.job)
+		g.job = 0
+	}
+}
+
+// isPipeErrno matches the errors Windows returns when the other end of an
+// anonymous pipe has gone away.
+func isPipeErrno(err error) bool {
+	return errors.Is(err, windows.ERROR_BROKEN_PIPE) ||
+		errors.Is(err, windows.ERROR_NO_DATA) ||
+		errors.Is(err, windows.ERROR_PIPE_NOT_CONNECTED) ||
+		errors.Is(err, windows.WSAECONNRESET)
+}
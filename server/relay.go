@@ -0,0 +1,167 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A worker asked to relay (RequestPayload.RelayOver) may answer a large
+// response with a head, a ResponsePayload with Relay set and the status and
+// headers, followed by "chunk" frames and an "end" frame, as a streamed
+// response would be. The worker stays reserved for the request until the
+// rest of the body has been read from it with WriteBodyTo, ReadBody or
+// Discard, or the request timeout runs out.
+
+// bodyRelay is the unread rest of a relayed response.
+type bodyRelay struct {
+	w       *Worker
+	t       WorkerTransport // the connection the head came on
+	timeout time.Duration
+	timer   *time.Timer
+	expired atomic.Bool
+
+	mu   sync.Mutex
+	once sync.Once
+	done []func() // run in order once the body is read or abandoned
+}
+
+// newBodyRelay reserves w's connection for the rest of a relayed body for
+// at most remaining (no limit if timeout is 0), running done when it ends.
+func (w *Worker) newBodyRelay(timeout, remaining time.Duration, done ...func()) *bodyRelay {
+	b := &bodyRelay{w: w, t: w.transport, timeout: timeout, done: done}
+	if timeout > 0 {
+		b.timer = time.AfterFunc(max(remaining, 0), func() {
+			// nobody read the body in time: the frames left on the
+			// connection make it useless, so start over
+			b.expired.Store(true)
+			w.markDead()
+			w.kill()
+			b.finish()
+		})
+	}
+	return b
+}
+
+// then adds f to what runs when the body has been read.
+func (b *bodyRelay) then(f func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.done = append(b.done, f)
+}
+
+func (b *bodyRelay) finish() {
+	b.once.Do(func() {
+		if b.timer != nil {
+			b.timer.Stop()
+		}
+		b.mu.Lock()
+		done := b.done
+		b.mu.Unlock()
+		for _, f := range done {
+			f()
+		}
+	})
+}
+
+// copyTo writes the chunks still to come to dst, flushing after each when
+// dst is an http.Flusher.
+func (b *bodyRelay) copyTo(dst io.Writer) (int64, error) {
+	defer b.finish()
+	flusher, _ := dst.(http.Flusher)
+	chaos := b.w.chaos.Load()
+
+	var n int64
+	var writeErr error // dst failed; the rest is read and dropped
+	for {
+		frame, err := b.t.RecvFrame()
+		if err != nil {
+			b.w.markDead()
+			if b.expired.Load() {
+				return n, &timeoutError{op: "relay", after: b.timeout}
+			}
+			if isBrokenPipe(err) {
+				return n, crashed(err)
+			}
+			return n, err
+		}
+
+		switch frame.Type {
+		case "chunk":
+			if chaos.dropFrame() || frame.Data == "" {
+				continue
+			}
+			if writeErr != nil {
+				continue // keep reading so the worker can be reused
+			}
+			m, err := io.WriteString(dst, frame.Data)
+			n += int64(m)
+			if err != nil {
+				writeErr = err
+				continue
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		case "end":
+			return n, writeErr
+		case "error":
+			b.w.markDead()
+			return n, fmt.Errorf("relay error from worker: %s", frame.Error)
+		default:
+			b.w.markDead()
+			return n, protocolError("unknown relay frame type: %q", frame.Type)
+		}
+	}
+}
+
+// Relayed reports whether part of r's body is still on the worker, to be
+// read with WriteBodyTo, ReadBody or Discard.
+func (r *ResponsePayload) Relayed() bool {
+	return r.relay != nil
+}
+
+// WriteBodyTo writes r's body to dst, relaying the part still on the worker
+// as it arrives, and frees the worker.
+func (r *ResponsePayload) WriteBodyTo(dst io.Writer) (int64, error) {
+	n, err := dst.Write(r.Body)
+	if err != nil || r.relay == nil {
+		if r.relay != nil {
+			r.Discard()
+		}
+		return int64(n), err
+	}
+	relay := r.relay
+	r.relay = nil
+	m, err := relay.copyTo(dst)
+	return int64(n) + m, err
+}
+
+// ReadBody reads the relayed part of r's body into Body, for code that
+// needs all of it. It does nothing when r wasn't relayed.
+func (r *ResponsePayload) ReadBody() error {
+	if r.relay == nil {
+		return nil
+	}
+	relay := r.relay
+	r.relay = nil
+	buf := bytes.NewBuffer(r.Body)
+	_, err := relay.copyTo(buf)
+	r.Body = buf.Bytes()
+	return err
+}
+
+// Discard reads and drops the relayed part of r's body, freeing the worker
+// when the response is answered some other way.
+func (r *ResponsePayload) Discard() {
+	if r.relay == nil {
+		return
+	}
+	relay := r.relay
+	r.relay = nil
+	_, _ = relay.copyTo(io.Discard)
+}
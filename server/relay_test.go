@@ -0,0 +1,65 @@
+package server
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func relayTransport() *scriptedTransport {
+	return &scriptedTransport{reply: func(req *RequestPayload) (*ResponsePayload, error) {
+		return &ResponsePayload{ID: req.ID, Status: 200, Relay: true, Body: []byte("a")}, nil
+	}, frames: []*StreamFrame{{Type: "chunk", Data: "b"}, {Type: "chunk", Data: "c"}, {Type: "end"}}}
+}
+
+func TestRelayedResponse(t *testing.T) {
+	tr := relayTransport()
+	w := NewWorkerWithTransport(tr, 0, time.Second)
+
+	resp, err := w.Handle(&RequestPayload{ID: "1", Path: "/big", RelayOver: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resp.Relayed() || w.getInFlight() != 1 || w.getState() != WorkerBusy {
+		t.Fatalf("relayed=%v in flight=%d state=%v; the worker should stay busy", resp.Relayed(), w.getInFlight(), w.getState())
+	}
+
+	rr := httptest.NewRecorder()
+	n, err := resp.WriteBodyTo(rr)
+	if err != nil || n != 3 || rr.Body.String() != "abc" {
+		t.Fatalf("WriteBodyTo = %d, %v; body %q", n, err, rr.Body.String())
+	}
+	if resp.Relayed() || w.getInFlight() != 0 || w.getState() != WorkerIdle {
+		t.Fatalf("after the body: relayed=%v in flight=%d state=%v", resp.Relayed(), w.getInFlight(), w.getState())
+	}
+}
+
+func TestRelayReadWhenNotAskedFor(t *testing.T) {
+	w := NewWorkerWithTransport(relayTransport(), 0, time.Second)
+
+	resp, err := w.Handle(&RequestPayload{ID: "1", Path: "/big"})
+	if err != nil || resp.Relayed() || string(resp.Body) != "abc" {
+		t.Fatalf("Handle = %q relayed=%v, %v", resp.Body, resp.Relayed(), err)
+	}
+	if w.getInFlight() != 0 {
+		t.Fatalf("in flight = %d", w.getInFlight())
+	}
+}
+
+func TestRelayAbandonedTimesOut(t *testing.T) {
+	tr := relayTransport()
+	w := NewWorkerWithTransport(tr, 0, 50*time.Millisecond)
+
+	resp, err := w.Handle(&RequestPayload{ID: "1", Path: "/big", RelayOver: 1})
+	if err != nil || !resp.Relayed() {
+		t.Fatalf("Handle = %v relayed=%v", err, resp.Relayed())
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for w.getInFlight() != 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if w.getInFlight() != 0 || !w.isDead() || !tr.closed {
+		t.Fatalf("in flight=%d dead=%v closed=%v; an unread relay should free the worker at the timeout", w.getInFlight(), w.isDead(), tr.closed)
+	}
+}
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"fmt"
+	"time"
+)
+
+// retirePoll is how often a draining worker is checked for in-flight
+// requests before its process is stopped or replaced.
+const retirePoll = 50 * time.Millisecond
+
+// Resize grows or shrinks the pool to n workers at runtime. New workers are
+// spawned from the pool's configuration before they are added; removed
+// workers stop taking requests and exit once their in-flight requests have
+// finished.
+func (p *WorkerPool) Resize(n int) error {
+	if n < 1 {
+		return fmt.Errorf("pool needs at least one worker, got %d", n)
+	}
+
+	p.resizeMu.Lock()
+	defer p.resizeMu.Unlock()
+
+	p.mu.Lock()
+	var removed []*Worker
+	if n < len(p.workers) {
+		removed = append(removed, p.workers[n:]...)
+	}
+	p.mu.Unlock()
+
+	if err := p.ScaleTo(n, p.spawnWorker); err != nil {
+		return err
+	}
+	for _, w := range removed {
+		if w != nil {
+			go retireWorker(w)
+		}
+	}
+	return nil
+}
+
+// spawnWorker starts a new worker configured like the rest of the pool. It
+// runs as ScaleTo's factory, without p.mu held.
+func (p *WorkerPool) spawnWorker() (*Worker, error) {
+	w, err := p.cfg.newWorker()
+	if err != nil {
+		return nil, err
+	}
+	if err := w.spawn(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// retireWorker stops a draining worker's process once it is idle.
+func retireWorker(w *Worker) {
+	w.waitIdle()
+	w.markDead()
+
+	w.mu.Lock()
+	if w.transport != nil {
+		_ = w.transport.Close()
+	}
+	w.kill()
+	w.mu.Unlock()
+}
+
+// waitIdle blocks until w has no requests in flight (or has died).
+func (w *Worker) waitIdle() {
+	for !w.isDead() && w.getInFlight() > 0 {
+		time.Sleep(retirePoll)
+	}
+}
+
+// Recycle replaces the worker's process without touching the rest of the
+// pool: the worker stops taking requests, and once its in-flight requests
+// finish, a fresh process is started in its place. It returns immediately.
+func (w *Worker) Recycle() {
+	w.startDraining()
+	go func() {
+		w.waitIdle()
+		if err := w.restart(); err != nil {
+			logTo(w.logger).Printf("[worker %d] recycle failed: %v", w.id, err)
+			w.markDead()
+		}
+	}()
+}
+
+// Drain takes the worker out of rotation: it stops taking requests, and its
+// process exits once its in-flight requests finish. Recycle brings it back.
+func (w *Worker) Drain() {
+	w.startDraining()
+	go retireWorker(w)
+}
+
+// ResizePool resizes the named pool (see WorkerPool.Resize).
+func (s *Server) ResizePool(name string, n int) error {
+	p, ok := s.pools[name]
+	if !ok {
+		return fmt.Errorf("unknown pool %q", name)
+	}
+	return p.Resize(n)
+}
@@ -0,0 +1,124 @@
+package server
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestResizeShrinkRetiresRemovedWorkers(t *testing.T) {
+	pool := newFakePool(t, 3, time.Second)
+	removed := pool.workers[1:]
+
+	if err := pool.Resize(1); err != nil {
+		t.Fatalf("Resize(1): %v", err)
+	}
+	if got := len(pool.workers); got != 1 {
+		t.Fatalf("expected 1 worker, got %d", got)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for _, w := range removed {
+		for !w.isDead() {
+			if time.Now().After(deadline) {
+				t.Fatalf("removed worker was never stopped")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	if err := pool.Resize(0); err == nil {
+		t.Fatalf("Resize(0) should be rejected")
+	}
+}
+
+func TestResizeGrowSpawnsFromPoolConfig(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer c.Close()
+		}
+	}()
+
+	pool := newFakePool(t, 1, time.Second)
+	pool.cfg = PoolConfig{Transport: TransportTCP, Address: ln.Addr().String()}
+	srv := newServer(map[string]*WorkerPool{"fast": pool}, []string{"fast"}, nil, SlowRequestConfig{})
+
+	if err := srv.ResizePool("fast", 3); err != nil {
+		t.Fatalf("ResizePool: %v", err)
+	}
+	if got := len(pool.workers); got != 3 {
+		t.Fatalf("expected 3 workers, got %d", got)
+	}
+	for _, w := range pool.workers[1:] {
+		if w.transport == nil || w.isDead() {
+			t.Fatalf("new worker should be connected and alive")
+		}
+	}
+	if err := srv.ResizePool("missing", 2); err == nil {
+		t.Fatalf("unknown pool should be rejected")
+	}
+}
+
+func TestRecycleAndDrainSingleWorker(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	accepted := make(chan net.Conn, 4)
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- c
+		}
+	}()
+
+	w, err := PoolConfig{Transport: TransportTCP, Address: ln.Addr().String()}.newWorker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.spawn(); err != nil {
+		t.Fatal(err)
+	}
+	<-accepted
+	first := w.transport
+
+	w.Recycle()
+	select {
+	case <-accepted:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("recycle did not reconnect")
+	}
+	waitFor(t, func() bool { return w.Available() })
+	w.mu.Lock()
+	replaced := w.transport != first
+	w.mu.Unlock()
+	if !replaced {
+		t.Fatalf("recycle should replace the transport")
+	}
+
+	w.Drain()
+	waitFor(t, func() bool { return w.isDead() })
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("condition not met in time")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,150 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// RetryPolicy retries buffered requests that never reached a worker: it was
+// dead, draining or couldn't be restarted, or the pipe broke while the
+// request was being sent. A worker that fails after taking the request
+// isn't retried, since PHP may have acted on it. Each retry goes to a different
+// worker, on FallbackPool when it is set. Only idempotent Methods are
+// retried; timeouts never are, since the request may still be running, and
+// neither are PHP fatal errors, which would simply recur.
+// Streamed responses are not retried.
+type RetryPolicy struct {
+	MaxAttempts  int      `json:"max_attempts"` // including the first; <= 1 disables retries
+	Methods      []string `json:"methods"`      // default GET, HEAD, OPTIONS, PUT, DELETE
+	FallbackPool string   `json:"fallback_pool"`
+	BackoffMs    int      `json:"backoff_ms"` // pause before each retry
+}
+
+// RetryStats counts retries since the policy was installed or the counters
+// were reset.
+type RetryStats struct {
+	Retries   uint64 `json:"retries"`   // extra attempts made
+	Recovered uint64 `json:"recovered"` // requests that succeeded on a retry
+	Exhausted uint64 `json:"exhausted"` // requests that failed every attempt
+}
+
+type retryState struct {
+	policy    RetryPolicy
+	retries   atomic.Uint64
+	recovered atomic.Uint64
+	exhausted atomic.Uint64
+}
+
+var idempotentMethods = []string{"GET", "HEAD", "OPTIONS", "TRACE", "PUT", "DELETE"}
+
+// SetRetryPolicy installs p for Dispatch; a MaxAttempts of 1 or less turns
+// retries off. Non-idempotent methods such as POST are rejected.
+func (s *Server) SetRetryPolicy(p RetryPolicy) error {
+	if p.FallbackPool != "" {
+		if _, ok := s.pools[p.FallbackPool]; !ok {
+			return fmt.Errorf("retry: unknown fallback pool %q", p.FallbackPool)
+		}
+	}
+	if len(p.Methods) == 0 {
+		p.Methods = []string{"GET", "HEAD", "OPTIONS", "PUT", "DELETE"}
+	}
+	for _, m := range p.Methods {
+		if !containsFold(idempotentMethods, m) {
+			return fmt.Errorf("retry: method %s is not idempotent", strings.ToUpper(m))
+		}
+	}
+	if p.BackoffMs < 0 {
+		p.BackoffMs = 0
+	}
+
+	if p.MaxAttempts <= 1 {
+		s.retry.Store(nil)
+		return nil
+	}
+	s.retry.Store(&retryState{policy: p})
+	return nil
+}
+
+// RetryStats returns the retry counters, or zeros when no policy is set.
+func (s *Server) RetryStats() RetryStats {
+	st := s.retry.Load()
+	if st == nil {
+		return RetryStats{}
+	}
+	return RetryStats{
+		Retries:   st.retries.Load(),
+		Recovered: st.recovered.Load(),
+		Exhausted: st.exhausted.Load(),
+	}
+}
+
+// ResetRetryStats zeroes the retry counters.
+func (s *Server) ResetRetryStats() {
+	if st := s.retry.Load(); st != nil {
+		st.retries.Store(0)
+		st.recovered.Store(0)
+		st.exhausted.Store(0)
+	}
+}
+
+// retryable reports whether err means the request never reached a
+// worker, so it can safely run elsewhere.
+func retryable(err error) bool {
+	// a fatal or an oversized response would just happen again
+	if errors.Is(err, ErrWorkerTimeout) || errors.Is(err, ErrPHPFatal) || errors.Is(err, ErrFrameTooLarge) {
+		return false
+	}
+	return errors.Is(err, ErrWorkerDead) ||
+		errors.Is(err, ErrWorkerDraining) ||
+		errors.Is(err, ErrNoWorkers) ||
+		errors.Is(err, errNotSent)
+}
+
+// dispatchWithRetry runs req on pool, retrying per the installed policy.
+func (s *Server) dispatchWithRetry(req *RequestPayload, pool *WorkerPool) (*ResponsePayload, error) {
+	st := s.retry.Load()
+	if st == nil || !containsFold(st.policy.Methods, req.Method) {
+		return pool.Dispatch(req)
+	}
+	p := st.policy
+
+	tried := make(map[*Worker]bool, p.MaxAttempts)
+	var lastErr error
+	for attempt := 0; attempt < p.MaxAttempts; attempt++ {
+		target := pool
+		if attempt > 0 {
+			st.retries.Add(1)
+			if p.FallbackPool != "" {
+				target = s.pools[p.FallbackPool]
+			}
+			if p.BackoffMs > 0 {
+				time.Sleep(time.Duration(p.BackoffMs) * time.Millisecond)
+			}
+		}
+
+		w := target.workerExcluding(req, tried)
+		if w == nil {
+			lastErr = ErrNoWorkers
+			continue
+		}
+		tried[w] = true
+
+		resp, err := target.dispatchOn(w, req)
+		if err == nil {
+			if attempt > 0 {
+				st.recovered.Add(1)
+			}
+			return resp, nil
+		}
+		lastErr = err
+		if !retryable(err) {
+			return nil, err
+		}
+	}
+
+	st.exhausted.Add(1)
+	return nil, lastErr
+}
@@ -0,0 +1,138 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+// brokenTransport fails every send as if the worker process had exited.
+type brokenTransport struct{}
+
+func (brokenTransport) Send(*RequestPayload) error       { return io.ErrClosedPipe }
+func (brokenTransport) Recv() (*ResponsePayload, error)  { return nil, io.EOF }
+func (brokenTransport) RecvFrame() (*StreamFrame, error) { return nil, io.EOF }
+func (brokenTransport) Close() error                     { return nil }
+
+// lostReplyTransport takes the request, then loses the connection before
+// the reply.
+type lostReplyTransport struct{ brokenTransport }
+
+func (lostReplyTransport) Send(*RequestPayload) error { return nil }
+
+// newCrashingWorker returns a worker whose process is gone and can't be
+// restarted.
+func newCrashingWorker() *Worker {
+	return &Worker{
+		transport:   brokenTransport{},
+		dial:        func() (WorkerTransport, error) { return nil, errors.New("spawn failed") },
+		maxRequests: 1000,
+	}
+}
+
+func newRetryServer(t *testing.T) *Server {
+	t.Helper()
+	main := newFakePool(t, 2, time.Second)
+	main.workers[0] = newCrashingWorker()
+	main.SetBalancer(&RoundRobin{})
+
+	return &Server{
+		pools:     map[string]*WorkerPool{FastPool: main, "backup": newFakePool(t, 1, time.Second)},
+		poolOrder: []string{FastPool, "backup"},
+	}
+}
+
+func TestDispatchRetriesOnAnotherWorker(t *testing.T) {
+	s := newRetryServer(t)
+	if err := s.SetRetryPolicy(RetryPolicy{MaxAttempts: 2}); err != nil {
+		t.Fatalf("SetRetryPolicy: %v", err)
+	}
+
+	resp, err := s.Dispatch(&RequestPayload{ID: "1", Method: "GET", Path: "/r"})
+	if err != nil {
+		t.Fatalf("Dispatch: %v", err)
+	}
+	if string(resp.Body) != "w1:/r" {
+		t.Fatalf("expected the retry to land on w1, got %q", resp.Body)
+	}
+
+	if st := s.RetryStats(); st.Retries != 1 || st.Recovered != 1 || st.Exhausted != 0 {
+		t.Fatalf("RetryStats = %+v, want 1 retry, 1 recovered", st)
+	}
+}
+
+func TestDispatchRetriesOnFallbackPool(t *testing.T) {
+	s := newRetryServer(t)
+	if err := s.SetRetryPolicy(RetryPolicy{MaxAttempts: 2, FallbackPool: "backup"}); err != nil {
+		t.Fatalf("SetRetryPolicy: %v", err)
+	}
+
+	resp, err := s.Dispatch(&RequestPayload{ID: "1", Method: "GET", Path: "/r"})
+	if err != nil {
+		t.Fatalf("Dispatch: %v", err)
+	}
+	if string(resp.Body) != "w0:/r" || resp.Headers["X-Worker"] != "w0" {
+		t.Fatalf("expected the backup pool's w0 to serve, got %q", resp.Body)
+	}
+}
+
+func TestDispatchDoesNotRetryNonIdempotent(t *testing.T) {
+	s := newRetryServer(t)
+	if err := s.SetRetryPolicy(RetryPolicy{MaxAttempts: 3}); err != nil {
+		t.Fatalf("SetRetryPolicy: %v", err)
+	}
+
+	if _, err := s.Dispatch(&RequestPayload{ID: "1", Method: "POST", Path: "/r"}); err == nil {
+		t.Fatalf("expected POST on a crashed worker to fail")
+	}
+	if st := s.RetryStats(); st.Retries != 0 {
+		t.Fatalf("POST was retried: %+v", st)
+	}
+}
+
+func TestDispatchDoesNotRetryAfterSending(t *testing.T) {
+	s := newRetryServer(t)
+	w := newCrashingWorker()
+	w.transport = lostReplyTransport{}
+	s.pools[FastPool].workers[0] = w
+	if err := s.SetRetryPolicy(RetryPolicy{MaxAttempts: 3}); err != nil {
+		t.Fatalf("SetRetryPolicy: %v", err)
+	}
+
+	if _, err := s.Dispatch(&RequestPayload{ID: "1", Method: "GET", Path: "/r"}); err == nil {
+		t.Fatalf("expected the lost reply to fail the request")
+	}
+	if st := s.RetryStats(); st.Retries != 0 {
+		t.Fatalf("a request PHP may have run was retried: %+v", st)
+	}
+}
+
+func TestDispatchRetryExhausted(t *testing.T) {
+	s := newRetryServer(t)
+	s.pools[FastPool].workers[1] = newCrashingWorker()
+	if err := s.SetRetryPolicy(RetryPolicy{MaxAttempts: 3}); err != nil {
+		t.Fatalf("SetRetryPolicy: %v", err)
+	}
+
+	if _, err := s.Dispatch(&RequestPayload{ID: "1", Method: "GET", Path: "/r"}); err == nil {
+		t.Fatalf("expected an error when every worker has crashed")
+	}
+	if st := s.RetryStats(); st.Exhausted != 1 {
+		t.Fatalf("RetryStats = %+v, want 1 exhausted", st)
+	}
+}
+
+func TestSetRetryPolicyValidation(t *testing.T) {
+	s := newRetryServer(t)
+
+	if err := s.SetRetryPolicy(RetryPolicy{MaxAttempts: 2, Methods: []string{"GET", "POST"}}); err == nil {
+		t.Fatalf("expected POST to be rejected")
+	}
+	if err := s.SetRetryPolicy(RetryPolicy{MaxAttempts: 2, FallbackPool: "nope"}); err == nil {
+		t.Fatalf("expected unknown fallback pool to be rejected")
+	}
+	if retryable(&timeoutError{op: "request", after: time.Second}) {
+		t.Fatalf("timeouts must not be retried")
+	}
+}
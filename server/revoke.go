@@ -0,0 +1,88 @@
+package server
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// UserChannelPrefix is the prefix of per-user realtime channels ("user:42").
+const UserChannelPrefix = "user:"
+
+// UserChannel returns the realtime channel name for a user.
+func UserChannel(userID string) string {
+	return UserChannelPrefix + userID
+}
+
+// RevocationList blocks realtime (WS/SSE) access for users until a deadline,
+// so a banned account can't simply reconnect after its feeds are cut.
+type RevocationList struct {
+	mu    sync.Mutex
+	until map[string]time.Time
+	now   func() time.Time
+}
+
+func NewRevocationList() *RevocationList {
+	return &RevocationList{
+		until: make(map[string]time.Time),
+		now:   time.Now,
+	}
+}
+
+// Revoke blocks userID for ttl from now.
+func (l *RevocationList) Revoke(userID string, ttl time.Duration) time.Time {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	until := l.now().Add(ttl)
+	l.until[userID] = until
+	return until
+}
+
+// Restore lifts a revocation early.
+func (l *RevocationList) Restore(userID string) {
+	l.mu.Lock()
+	delete(l.until, userID)
+	l.mu.Unlock()
+}
+
+// IsRevoked reports whether userID is currently blocked. Expired entries
+// are pruned on lookup.
+func (l *RevocationList) IsRevoked(userID string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	until, ok := l.until[userID]
+	if !ok {
+		return false
+	}
+	if !l.now().Before(until) {
+		delete(l.until, userID)
+		return false
+	}
+	return true
+}
+
+// ChannelRevoked reports whether channel is a per-user channel whose user
+// is currently revoked.
+func (l *RevocationList) ChannelRevoked(channel string) bool {
+	userID, ok := strings.CutPrefix(channel, UserChannelPrefix)
+	return ok && userID != "" && l.IsRevoked(userID)
+}
+
+// Revoked returns every active revocation and its deadline.
+func (l *RevocationList) Revoked() map[string]time.Time {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	out := make(map[string]time.Time, len(l.until))
+	for id, until := range l.until {
+		if now.Before(until) {
+			out[id] = until
+		} else {
+			delete(l.until, id)
+		}
+	}
+	return out
+}
@@ -0,0 +1,40 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRevocationListExpires(t *testing.T) {
+	now := time.Unix(1000, 0)
+	l := NewRevocationList()
+	l.now = func() time.Time { return now }
+
+	l.Revoke("42", time.Minute)
+	if !l.IsRevoked("42") || !l.ChannelRevoked("user:42") {
+		t.Fatalf("expected user 42 to be revoked")
+	}
+	if l.ChannelRevoked("user:7") || l.ChannelRevoked("news") {
+		t.Fatalf("unrelated channels must not be revoked")
+	}
+	if len(l.Revoked()) != 1 {
+		t.Fatalf("expected one active revocation")
+	}
+
+	now = now.Add(time.Minute)
+	if l.IsRevoked("42") {
+		t.Fatalf("expected revocation to expire after TTL")
+	}
+	if len(l.Revoked()) != 0 {
+		t.Fatalf("expected expired revocation to be pruned")
+	}
+}
+
+func TestRevocationListRestore(t *testing.T) {
+	l := NewRevocationList()
+	l.Revoke("42", time.Hour)
+	l.Restore("42")
+	if l.IsRevoked("42") {
+		t.Fatalf("expected Restore to lift the revocation")
+	}
+}
@@ -0,0 +1,216 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// workerPhase tracks where a busy worker is within a single request. It is
+// kept separate from WorkerState so draining/dead transitions are never
+// overwritten by request progress.
+type workerPhase int
+
+const (
+	phaseIdle       workerPhase = iota
+	phaseReading                // request payload is being written to the worker
+	phaseProcessing             // waiting for PHP to produce a response
+	phaseWriting                // relaying response frames to the client
+)
+
+// Scoreboard states, in the spirit of Apache's mod_status.
+const (
+	SlotIdle       = "idle"
+	SlotReading    = "reading"
+	SlotProcessing = "processing"
+	SlotWriting    = "writing"
+	SlotDraining   = "draining"
+	SlotDead       = "dead"
+)
+
+// scoreboardKey is the one-character legend used by the compact view.
+var scoreboardKey = map[string]byte{
+	SlotIdle:       '_',
+	SlotReading:    'R',
+	SlotProcessing: 'P',
+	SlotWriting:    'W',
+	SlotDraining:   'G',
+	SlotDead:       '.',
+}
+
+// ScoreboardSlot is a point-in-time view of a single worker slot.
+type ScoreboardSlot struct {
+	Pool      string  `json:"pool"`
+	Slot      int     `json:"slot"`
+	Worker    uint64  `json:"worker,omitempty"` // worker id
+	State     string  `json:"state"`
+	Path      string  `json:"path,omitempty"`
+	ElapsedMs float64 `json:"elapsed_ms,omitempty"`
+	Requests  uint64  `json:"requests"`
+}
+
+func (w *Worker) beginRequest(path string) {
+	w.stateMu.Lock()
+	w.phase = phaseReading
+	w.curPath = path
+	w.reqStart = time.Now()
+	w.stateMu.Unlock()
+}
+
+func (w *Worker) setPhase(phase workerPhase) {
+	w.stateMu.Lock()
+	w.phase = phase
+	w.stateMu.Unlock()
+}
+
+func (w *Worker) endRequest() {
+	w.stateMu.Lock()
+	w.phase = phaseIdle
+	w.curPath = ""
+	w.reqStart = time.Time{}
+	w.stateMu.Unlock()
+}
+
+// slot snapshots the worker for the scoreboard.
+func (w *Worker) slot(pool string, idx int, now time.Time) ScoreboardSlot {
+	dead := w.isDead()
+
+	w.stateMu.RLock()
+	state, phase, path, start := w.state, w.phase, w.curPath, w.reqStart
+	w.stateMu.RUnlock()
+
+	sl := ScoreboardSlot{
+		Pool:     pool,
+		Slot:     idx,
+		Worker:   w.id,
+		Requests: atomic.LoadUint64(&w.requestCount),
+	}
+
+	switch {
+	case dead || state == WorkerDead:
+		sl.State = SlotDead
+	case phase == phaseReading:
+		sl.State = SlotReading
+	case phase == phaseProcessing:
+		sl.State = SlotProcessing
+	case phase == phaseWriting:
+		sl.State = SlotWriting
+	case state == WorkerDraining:
+		sl.State = SlotDraining
+	default:
+		sl.State = SlotIdle
+	}
+
+	if phase != phaseIdle && !start.IsZero() {
+		sl.Path = path
+		sl.ElapsedMs = float64(now.Sub(start).Microseconds()) / 1000
+	}
+
+	return sl
+}
+
+// scoreboard returns one entry per worker slot in the pool.
+func (p *WorkerPool) scoreboard(name string, now time.Time) []ScoreboardSlot {
+	if p == nil {
+		return nil
+	}
+
+	p.mu.Lock()
+	workers := append([]*Worker(nil), p.workers...)
+	p.mu.Unlock()
+
+	slots := make([]ScoreboardSlot, 0, len(workers))
+	for i, w := range workers {
+		if w == nil {
+			slots = append(slots, ScoreboardSlot{Pool: name, Slot: i, State: SlotDead})
+			continue
+		}
+		slots = append(slots, w.slot(name, i, now))
+	}
+	return slots
+}
+
+// Scoreboard returns the state of every worker slot in every pool.
+func (s *Server) Scoreboard() []ScoreboardSlot {
+	now := time.Now()
+	var slots []ScoreboardSlot
+	for _, name := range s.poolOrder {
+		slots = append(slots, s.pools[name].scoreboard(name, now)...)
+	}
+	return slots
+}
+
+// FormatScoreboard renders slots Apache-style: a one-line legend per pool
+// followed by a line per busy or unhealthy slot.
+func FormatScoreboard(slots []ScoreboardSlot) string {
+	var b strings.Builder
+	var detail strings.Builder
+
+	pool := ""
+	for _, sl := range slots {
+		if sl.Pool != pool {
+			if pool != "" {
+				b.WriteByte('\n')
+			}
+			pool = sl.Pool
+			fmt.Fprintf(&b, "%-6s ", pool)
+		}
+		b.WriteByte(scoreboardKey[sl.State])
+
+		if sl.State != SlotIdle {
+			fmt.Fprintf(&detail, "%s/%d %-10s %8.1fms %6d %s\n",
+				sl.Pool, sl.Slot, sl.State, sl.ElapsedMs, sl.Requests, sl.Path)
+		}
+	}
+	if pool != "" {
+		b.WriteByte('\n')
+	}
+
+	b.WriteString("\n_ idle  R reading  P processing  W writing  G draining  . dead\n")
+	if detail.Len() > 0 {
+		b.WriteByte('\n')
+		b.WriteString(detail.String())
+	}
+	return b.String()
+}
+
+// StartScoreboardFile rewrites path in place with the compact scoreboard
+// every interval, so `watch cat` (or anything that mmaps the file) sees
+// worker states without going through HTTP. Call the returned func to stop.
+func (s *Server) StartScoreboardFile(path string, interval time.Duration) (func(), error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	if interval <= 0 {
+		interval = time.Second
+	}
+
+	stop := make(chan struct{})
+	go func() {
+		defer f.Close()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			data := []byte(FormatScoreboard(s.Scoreboard()))
+			if _, err := f.WriteAt(data, 0); err != nil {
+				logTo(s.logger).Printf("[scoreboard] write %s: %v", path, err)
+			} else if err := f.Truncate(int64(len(data))); err != nil {
+				logTo(s.logger).Printf("[scoreboard] truncate %s: %v", path, err)
+			}
+
+			select {
+			case <-ticker.C:
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	return func() { close(stop) }, nil
+}
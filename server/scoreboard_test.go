@@ -0,0 +1,113 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestScoreboardReportsSlotStates(t *testing.T) {
+	idle := &Worker{}
+	dead := &Worker{}
+	draining := &Worker{}
+	busy := &Worker{}
+
+	dead.markDead()
+	draining.startDraining()
+	busy.beginRequest("/reports/big")
+	busy.setPhase(phaseProcessing)
+
+	s := &Server{
+		pools:     map[string]*WorkerPool{FastPool: &WorkerPool{workers: []*Worker{idle, busy}}, SlowPool: &WorkerPool{workers: []*Worker{dead, draining}}},
+		poolOrder: []string{FastPool, SlowPool},
+	}
+
+	slots := s.Scoreboard()
+	if len(slots) != 4 {
+		t.Fatalf("expected 4 slots, got %d", len(slots))
+	}
+
+	want := []struct {
+		pool  string
+		slot  int
+		state string
+	}{
+		{"fast", 0, SlotIdle},
+		{"fast", 1, SlotProcessing},
+		{"slow", 0, SlotDead},
+		{"slow", 1, SlotDraining},
+	}
+	for i, w := range want {
+		if slots[i].Pool != w.pool || slots[i].Slot != w.slot || slots[i].State != w.state {
+			t.Fatalf("slot %d = %+v, want %s/%d %s", i, slots[i], w.pool, w.slot, w.state)
+		}
+	}
+
+	if slots[1].Path != "/reports/big" {
+		t.Fatalf("expected busy slot path, got %q", slots[1].Path)
+	}
+	if slots[0].Path != "" || slots[0].ElapsedMs != 0 {
+		t.Fatalf("idle slot should not report a request: %+v", slots[0])
+	}
+}
+
+func TestScoreboardResetsAfterRequest(t *testing.T) {
+	w := newFakeWorker(t, "w0", time.Second)
+	if _, err := w.Handle(&RequestPayload{ID: "1", Method: "GET", Path: "/done"}); err != nil {
+		t.Fatalf("Handle: %v", err)
+	}
+
+	sl := w.slot("fast", 0, time.Now())
+	if sl.State != SlotIdle || sl.Path != "" {
+		t.Fatalf("expected idle slot after request, got %+v", sl)
+	}
+	if sl.Requests != 1 {
+		t.Fatalf("expected 1 request served, got %d", sl.Requests)
+	}
+}
+
+func TestFormatScoreboard(t *testing.T) {
+	out := FormatScoreboard([]ScoreboardSlot{
+		{Pool: "fast", Slot: 0, State: SlotIdle},
+		{Pool: "fast", Slot: 1, State: SlotWriting, Path: "/stream/x", ElapsedMs: 12.5},
+		{Pool: "slow", Slot: 0, State: SlotDead},
+	})
+
+	if !strings.Contains(out, "fast   _W\n") {
+		t.Fatalf("missing fast pool legend line:\n%s", out)
+	}
+	if !strings.Contains(out, "slow   .\n") {
+		t.Fatalf("missing slow pool legend line:\n%s", out)
+	}
+	if !strings.Contains(out, "fast/1 writing") || !strings.Contains(out, "/stream/x") {
+		t.Fatalf("missing busy slot detail:\n%s", out)
+	}
+}
+
+func TestStartScoreboardFile(t *testing.T) {
+	s := &Server{
+		pools:     map[string]*WorkerPool{FastPool: &WorkerPool{workers: []*Worker{{}}}, SlowPool: &WorkerPool{}},
+		poolOrder: []string{FastPool, SlowPool},
+	}
+
+	path := filepath.Join(t.TempDir(), "scoreboard")
+	stop, err := s.StartScoreboardFile(path, 10*time.Millisecond)
+	if err != nil {
+		t.Fatalf("StartScoreboardFile: %v", err)
+	}
+	defer stop()
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		data, _ := os.ReadFile(path)
+		if strings.HasPrefix(string(data), "fast   _") {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("scoreboard file not written, got %q", data)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
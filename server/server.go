@@ -1,12 +1,16 @@
 package server
 
 import (
+	"container/list"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/fsnotify/fsnotify"
@@ -14,47 +18,203 @@ import (
 
 // PoolStats describes the state of a worker pool.
 type PoolStats struct {
-	Workers     int `json:"workers"`
-	DeadWorkers int `json:"dead_workers"`
-}
+	Workers     int     `json:"workers"`
+	DeadWorkers int     `json:"dead_workers"`
+	InFlight    int     `json:"in_flight"`     // running or queued requests
+	QueueWaitMs float64 `json:"queue_wait_ms"` // recent average wait for a worker
 
-type routeStats struct {
-	count        uint64
-	totalLatency time.Duration
+	Details []WorkerHealth `json:"worker_details,omitempty"`
 }
 
-// HealthSummary returns the health of the fast and slow pools.
+// HealthSummary returns the health of the fast and slow pools, plus every
+// named pool in Pools.
 type HealthSummary struct {
-	Fast PoolStats `json:"fast_pool"`
-	Slow PoolStats `json:"slow_pool"`
+	Fast     PoolStats            `json:"fast_pool"`
+	Slow     PoolStats            `json:"slow_pool"`
+	Pools    map[string]PoolStats `json:"pools"`
+	Degraded bool                 `json:"degraded,omitempty"`
+	Memory   *MemoryHeadroom      `json:"memory,omitempty"` // nil where memory stats are unsupported
+	Shed     uint64               `json:"shed,omitempty"`   // requests rejected by load shedding
 }
 type SlowRequestConfig struct {
 	RoutePrefixes []string
 	Methods       []string
 	BodyThreshold int
+
+	// Adaptive controls how RecordLatency promotes prefixes to the slow
+	// pool and demotes them again.
+	Adaptive AdaptiveConfig
+}
+
+// Names of the two pools every server has unless configured otherwise.
+const (
+	FastPool = "fast"
+	SlowPool = "slow"
+)
+
+// PoolRoute pins requests to a named pool. A route matches when the path
+// starts with Prefix and, if Methods is non-empty, the method is listed.
+// Header, Cookie or Query additionally require that request header / cookie /
+// query parameter to be present, and equal to Value when Value is set
+// (A/B experiments, X-Priority, ?export=1).
+type PoolRoute struct {
+	Prefix  string
+	Methods []string
+	Header  string
+	Cookie  string
+	Query   string
+	Value   string
+	Pool    string
+}
+
+// ClassifierFunc picks the pool for a request. Returning "" (or a pool that
+// doesn't exist) leaves the decision to the configured routes and heuristics.
+type ClassifierFunc func(req *RequestPayload) string
+
+func (rt PoolRoute) matches(req *RequestPayload, method string) bool {
+	if !strings.HasPrefix(req.Path, rt.Prefix) {
+		return false
+	}
+	if len(rt.Methods) > 0 && !containsFold(rt.Methods, method) {
+		return false
+	}
+	if rt.Header != "" && !valueMatches(req.header(rt.Header), rt.Value) {
+		return false
+	}
+	if rt.Cookie != "" && !valueMatches(req.cookie(rt.Cookie), rt.Value) {
+		return false
+	}
+	if rt.Query != "" && !valueMatches(req.query(rt.Query), rt.Value) {
+		return false
+	}
+	return true
+}
+
+// valueMatches reports whether got satisfies want ("" means any non-empty value).
+func valueMatches(got []string, want string) bool {
+	for _, v := range got {
+		if v != "" && (want == "" || v == want) {
+			return true
+		}
+	}
+	return false
 }
 
 type Server struct {
-	fastPool *WorkerPool
-	slowPool *WorkerPool
-	slowCfg  SlowRequestConfig
+	pools     map[string]*WorkerPool
+	poolOrder []string // pool names in configuration order
+	routes    []PoolRoute
+	routesMu  sync.RWMutex // guards routes, which SetPoolRoutes replaces
+	slowCfg   SlowRequestConfig
+
+	classifier atomic.Pointer[ClassifierFunc]
+
+	routeMu     sync.Mutex // guards slowCfg's routing fields and the fields below
+	routeStats  map[string]*routeStats
+	routeLRU    *list.List      // of *routeStats, most recently seen first
+	promoted    map[string]bool // prefixes added by RecordLatency
+	routeEvents []AdaptiveEvent // newest last, at most maxAdaptiveEvents
+
+	degraded atomic.Bool // set while running without php workers
+
+	// stopRecover is closed by DrainWorkers to stop recoverWorkers, which
+	// recovering tracks; nil unless the server started degraded.
+	stopRecover     chan struct{}
+	stopRecoverOnce sync.Once
+	recovering      sync.WaitGroup
+
+	canaryMu sync.RWMutex
+	canary   *Canary
+
+	shadowMu sync.RWMutex
+	shadow   *shadowState
+
+	retry      atomic.Pointer[retryState]
+	loadShed   atomic.Pointer[shedState]
+	priorities atomic.Pointer[priorityConfig]
+
+	rssEstimateBytes atomic.Uint64 // per-worker memory assumed before workers are measured
 
-	routeMu    sync.Mutex
-	routeStats map[string]*routeStats
+	logger *log.Logger // nil means the standard logger
 }
 
-// NewServer builds fast and slow pools with shared settings.
+// NewServer builds fast and slow pools with shared settings. It predates
+// New and is kept for existing callers:
+//
+//	New(WithPools(...), WithTimeout(requestTimeout), WithSlowRequests(slowCfg))
 func NewServer(fastCount, slowCount, maxRequests int, requestTimeout time.Duration, slowCfg SlowRequestConfig) (*Server, error) {
-	fp, err := NewPool(fastCount, maxRequests, requestTimeout)
-	if err != nil {
-		return nil, err
+	return New(
+		WithPools(legacyPools(fastCount, slowCount, 0, 0)...),
+		WithTimeout(requestTimeout),
+		WithMaxRequests(maxRequests),
+		WithSlowRequests(slowCfg),
+	)
+}
+
+// legacyPools describes the classic fast/slow layout.
+func legacyPools(fastCount, slowCount, maxRequests int, requestTimeout time.Duration) []PoolConfig {
+	return []PoolConfig{
+		{Name: FastPool, Workers: fastCount, MaxRequests: maxRequests, RequestTimeout: requestTimeout},
+		{Name: SlowPool, Workers: slowCount, MaxRequests: maxRequests, RequestTimeout: requestTimeout},
 	}
+}
 
-	sp, err := NewPool(slowCount, maxRequests, requestTimeout)
-	if err != nil {
+// NewServerWithPools starts every configured pool and routes requests to them
+// using the classifier (see SetClassifier) and routes first, then the slow-request heuristics (to the "slow" pool),
+// then the "fast" pool (or the first pool if there is no "fast" pool).
+func NewServerWithPools(pools []PoolConfig, routes []PoolRoute, slowCfg SlowRequestConfig) (*Server, error) {
+	if err := validatePools(pools, routes); err != nil {
 		return nil, err
 	}
 
+	built := make(map[string]*WorkerPool, len(pools))
+	for _, pc := range pools {
+		p, err := NewPoolFromConfig(pc)
+		if err != nil {
+			for _, started := range built {
+				started.killAll()
+			}
+			return nil, fmt.Errorf("pool %q: %w", pc.Name, err)
+		}
+		built[pc.Name] = p
+	}
+
+	return newServer(built, poolNames(pools), routes, slowCfg), nil
+}
+
+func validatePools(pools []PoolConfig, routes []PoolRoute) error {
+	if len(pools) == 0 {
+		return errors.New("no worker pools configured")
+	}
+
+	seen := make(map[string]bool, len(pools))
+	for _, pc := range pools {
+		if pc.Name == "" {
+			return errors.New("worker pool with empty name")
+		}
+		if seen[pc.Name] {
+			return fmt.Errorf("duplicate worker pool %q", pc.Name)
+		}
+		seen[pc.Name] = true
+	}
+
+	for _, rt := range routes {
+		if !seen[rt.Pool] {
+			return fmt.Errorf("route %q references unknown pool %q", rt.Prefix, rt.Pool)
+		}
+	}
+	return nil
+}
+
+func poolNames(pools []PoolConfig) []string {
+	names := make([]string, 0, len(pools))
+	for _, pc := range pools {
+		names = append(names, pc.Name)
+	}
+	return names
+}
+
+func newServer(pools map[string]*WorkerPool, order []string, routes []PoolRoute, slowCfg SlowRequestConfig) *Server {
 	// Apply defaults if caller leaves fields empty.
 	if slowCfg.BodyThreshold <= 0 {
 		slowCfg.BodyThreshold = 2_000_000
@@ -63,32 +223,107 @@ func NewServer(fastCount, slowCount, maxRequests int, requestTimeout time.Durati
 	if len(slowCfg.Methods) == 0 {
 		slowCfg.Methods = []string{"PUT", "DELETE"}
 	}
+	slowCfg.Adaptive = slowCfg.Adaptive.withDefaults()
 
 	return &Server{
-		fastPool:   fp,
-		slowPool:   sp,
+		pools:      pools,
+		poolOrder:  order,
+		routes:     routes,
 		slowCfg:    slowCfg,
 		routeStats: make(map[string]*routeStats),
-	}, nil
+	}
+}
+
+// Pool returns the named pool, or nil if there is none.
+func (s *Server) Pool(name string) *WorkerPool {
+	return s.pools[name]
+}
+
+// PoolNames returns the configured pool names in configuration order.
+func (s *Server) PoolNames() []string {
+	return append([]string(nil), s.poolOrder...)
+}
+
+// PoolFor decides which pool should serve req.
+func (s *Server) PoolFor(req *RequestPayload) string {
+	return s.applyCanary(s.basePool(req))
+}
+
+// SetClassifier installs fn ahead of the configured routes; nil removes it.
+func (s *Server) SetClassifier(fn ClassifierFunc) {
+	if fn == nil {
+		s.classifier.Store(nil)
+		return
+	}
+	s.classifier.Store(&fn)
+}
+
+// basePool picks a pool from the classifier, routes and the slow-request
+// heuristics, before any canary split.
+func (s *Server) basePool(req *RequestPayload) string {
+	if fn := s.classifier.Load(); fn != nil {
+		if name := (*fn)(req); name != "" {
+			if _, ok := s.pools[name]; ok {
+				return name
+			}
+		}
+	}
+
+	method := strings.ToUpper(req.Method)
+	for _, rt := range s.poolRoutes() {
+		if !rt.matches(req, method) {
+			continue
+		}
+		if _, ok := s.pools[rt.Pool]; ok {
+			return rt.Pool
+		}
+	}
+
+	if _, ok := s.pools[SlowPool]; ok && s.IsSlowRequest(req) {
+		return SlowPool
+	}
+	if _, ok := s.pools[FastPool]; ok {
+		return FastPool
+	}
+	if len(s.poolOrder) > 0 {
+		return s.poolOrder[0]
+	}
+	return ""
+}
+
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
 }
 
 // Simple heuristics to decide if a request should go to the "slow" pool. -- driven by SlowRequestConfig
 func (s *Server) IsSlowRequest(r *RequestPayload) bool {
+	// RecordLatency and SetSlowRouting change these concurrently
+	s.routeMu.Lock()
+	prefixes := s.slowCfg.RoutePrefixes
+	threshold := s.slowCfg.BodyThreshold
+	methods := s.slowCfg.Methods
+	s.routeMu.Unlock()
+
 	// Route Prefixes
-	for _, prefix := range s.slowCfg.RoutePrefixes {
+	for _, prefix := range prefixes {
 		if prefix != "" && strings.HasPrefix(r.Path, prefix) {
 			return true
 		}
 	}
 
 	// Body size threshold
-	if s.slowCfg.BodyThreshold > 0 && len(r.Body) > s.slowCfg.BodyThreshold {
+	if threshold > 0 && len(r.Body) > threshold {
 		return true
 	}
 
 	// HTTP methods
 	method := strings.ToUpper(r.Method)
-	for _, m := range s.slowCfg.Methods {
+	for _, m := range methods {
 		if method == strings.ToUpper(m) {
 			return true
 		}
@@ -98,90 +333,70 @@ func (s *Server) IsSlowRequest(r *RequestPayload) bool {
 }
 
 func (s *Server) Health() HealthSummary {
-	return HealthSummary{
-		Fast: s.fastPool.Stats(),
-		Slow: s.slowPool.Stats(),
+	pools := make(map[string]PoolStats, len(s.pools))
+	for name, p := range s.pools {
+		pools[name] = p.Stats()
 	}
-}
-
-func (s *Server) RecordLatency(path string, d time.Duration) {
-	// Use the first path segment as the "prefix" key: /users/1 -> /users
-	prefix := path
-	if strings.HasPrefix(prefix, "/") {
-		slash := strings.Index(prefix[1:], "/")
-		if slash != -1 {
-			prefix = prefix[:slash+1]
-		}
-	}
-
-	s.routeMu.Lock()
-	defer s.routeMu.Unlock()
 
-	rs := s.routeStats[prefix]
-	if rs == nil {
-		rs = &routeStats{}
-		s.routeStats[prefix] = rs
+	summary := HealthSummary{
+		Fast:     s.pools[FastPool].Stats(),
+		Slow:     s.pools[SlowPool].Stats(),
+		Pools:    pools,
+		Degraded: s.Degraded(),
+		Shed:     s.Shed(),
 	}
-
-	rs.count++
-	rs.totalLatency += d
-
-	// Very naive promotion: if avg latency > 500ms and not already in slowCfg.RoutePrefixes, add it
-	if rs.count >= 10 { // need some samples
-		avg := rs.totalLatency / time.Duration(rs.count)
-		if avg > 500*time.Millisecond && !s.hasSlowPrefix(prefix) {
-			s.slowCfg.RoutePrefixes = append(s.slowCfg.RoutePrefixes, prefix)
-			log.Printf("[adaptive] promoting prefix %q to slow pool (avg=%v, count=%d)", prefix, avg, rs.count)
-		}
-
+	if h, err := s.Headroom(); err == nil {
+		summary.Memory = &h
 	}
+	return summary
 }
 
-func (s *Server) hasSlowPrefix(prefix string) bool {
-	for _, p := range s.slowCfg.RoutePrefixes {
-		if p == prefix {
-			return true
-		}
+func (s *Server) Dispatch(req *RequestPayload) (*ResponsePayload, error) {
+	if s.Degraded() {
+		return nil, ErrDegraded
 	}
 
-	return false
-}
-
-func (s *Server) Dispatch(req *RequestPayload) (*ResponsePayload, error) {
-	if s.IsSlowRequest(req) {
-		return s.slowPool.Dispatch(req)
+	name := s.PoolFor(req)
+	pool := s.pools[name]
+	if pool == nil {
+		return nil, ErrNoWorkers
+	}
+	req.priority, req.pool = s.PriorityOf(req), name
+	if err := s.checkLoad(req, name, pool); err != nil {
+		return nil, err
 	}
-	return s.fastPool.Dispatch(req)
+	s.mirror(req, name)
+	return s.dispatchWithRetry(req, pool)
 }
 
 func (s *Server) DispatchStream(req *RequestPayload, rw http.ResponseWriter) error {
-	var pool *WorkerPool
-	if s.IsSlowRequest(req) {
-		pool = s.slowPool
-	} else {
-		pool = s.fastPool
+	if s.Degraded() {
+		return ErrDegraded
 	}
 
-	w := pool.NextWorker() // you may need to add this helper
-	if w == nil {
-		// no healthy workers in pool
+	name := s.PoolFor(req)
+	pool := s.pools[name]
+	if pool == nil {
 		return ErrNoWorkers
 	}
-
-	return w.Stream(req, rw)
+	req.priority, req.pool = s.PriorityOf(req), name
+	if err := s.checkLoad(req, name, pool); err != nil {
+		return err
+	}
+	s.mirror(req, name)
+	return pool.Stream(req, rw)
 }
 
 // -------------------------------------------------------------
 // Hot reload support
 // -------------------------------------------------------------
 
-// markAllWorkersDead forces both pools to recreate workers on next request.
+// markAllWorkersDead forces every pool to recreate workers on next request.
 func (s *Server) markAllWorkersDead() {
-	for _, w := range s.fastPool.workers {
-		w.markDead()
-	}
-	for _, w := range s.slowPool.workers {
-		w.markDead()
+	for _, p := range s.pools {
+		for _, w := range p.list() {
+			w.markDead()
+		}
 	}
 }
 
@@ -189,9 +404,16 @@ func (s *Server) ForceRecycleWorkers() {
 	s.markAllWorkersDead()
 }
 
+// DrainWorkers stops new requests reaching the workers and lets in-flight
+// ones finish. A degraded server stops retrying to start PHP first.
 func (s *Server) DrainWorkers() {
-	s.fastPool.DrainAll()
-	s.slowPool.DrainAll()
+	if s.stopRecover != nil {
+		s.stopRecoverOnce.Do(func() { close(s.stopRecover) })
+		s.recovering.Wait()
+	}
+	for _, p := range s.pools {
+		p.DrainAll()
+	}
 }
 
 // EnableHotReload watches php/ and routes/ under projectRoot and marks all
@@ -214,9 +436,9 @@ func (s *Server) EnableHotReload(projectRoot string) error {
 			continue
 		}
 		if err := watcher.Add(dir); err != nil {
-			log.Println("hot reload: failed to watch", dir, ":", err)
+			logTo(s.logger).Println("hot reload: failed to watch", dir, ":", err)
 		} else {
-			log.Println("hot reload: watching", dir)
+			logTo(s.logger).Println("hot reload: watching", dir)
 		}
 	}
 
@@ -228,7 +450,7 @@ func (s *Server) EnableHotReload(projectRoot string) error {
 					return
 				}
 				if ev.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Remove|fsnotify.Rename) != 0 {
-					log.Println("hot reload: change detected in", ev.Name, "- recycling workers...")
+					logTo(s.logger).Println("hot reload: change detected in", ev.Name, "- recycling workers...")
 					s.markAllWorkersDead()
 				}
 
@@ -236,7 +458,7 @@ func (s *Server) EnableHotReload(projectRoot string) error {
 				if !ok {
 					return
 				}
-				log.Println("hot reload watcher error:", err)
+				logTo(s.logger).Println("hot reload watcher error:", err)
 			}
 		}
 	}()
@@ -1,6 +1,7 @@
 package server
 
 import (
+	"math"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -19,7 +20,7 @@ func TestIsSlowRequestByPrefix(t *testing.T) {
 	req := &RequestPayload{
 		Method: "GET",
 		Path:   "/slow/report",
-		Body:   "",
+		Body:   nil,
 	}
 
 	if !s.IsSlowRequest(req) {
@@ -39,7 +40,7 @@ func TestIsSlowRequestByMethod(t *testing.T) {
 	req := &RequestPayload{
 		Method: "delete", //lower-case should still match
 		Path:   "/anything",
-		Body:   "",
+		Body:   nil,
 	}
 
 	if !s.IsSlowRequest(req) {
@@ -59,7 +60,7 @@ func TestIsSlowRequestByBodyThreshold(t *testing.T) {
 	req := &RequestPayload{
 		Method: "POST",
 		Path:   "/upload",
-		Body:   "0123456789ABCDEF", // 10 bytes
+		Body:   []byte("0123456789ABCDEF"), // 10 bytes
 	}
 
 	if !s.IsSlowRequest(req) {
@@ -72,8 +73,8 @@ func TestDispatchUsesFastAndSlowPools(t *testing.T) {
 	slow := newFakePool(t, 1, time.Second)
 
 	s := &Server{
-		fastPool: fast,
-		slowPool: slow,
+		pools:     map[string]*WorkerPool{FastPool: fast, SlowPool: slow},
+		poolOrder: []string{FastPool, SlowPool},
 		slowCfg: SlowRequestConfig{
 			RoutePrefixes: []string{"/slow"},
 			Methods:       []string{},
@@ -85,14 +86,14 @@ func TestDispatchUsesFastAndSlowPools(t *testing.T) {
 		ID:     "1",
 		Method: "GET",
 		Path:   "/fast",
-		Body:   "",
+		Body:   nil,
 	}
 
 	slowReq := &RequestPayload{
 		ID:     "2",
 		Method: "GET",
 		Path:   "/slow/task",
-		Body:   "",
+		Body:   nil,
 	}
 
 	fastResp, err := s.Dispatch(fastReq)
@@ -100,7 +101,7 @@ func TestDispatchUsesFastAndSlowPools(t *testing.T) {
 		t.Fatalf("Dispatch(fast) error: %v", err)
 	}
 
-	if fastResp.Status != http.StatusOK || fastResp.Body == "" {
+	if fastResp.Status != http.StatusOK || string(fastResp.Body) == "" {
 		t.Fatalf("unexpected fast response: %#v", fastResp)
 	}
 
@@ -109,7 +110,7 @@ func TestDispatchUsesFastAndSlowPools(t *testing.T) {
 		t.Fatalf("Dispatch(slow) error: %v", err)
 	}
 
-	if slowResp.Status != http.StatusOK || slowResp.Body == "" {
+	if slowResp.Status != http.StatusOK || string(slowResp.Body) == "" {
 		t.Fatalf("unexpected slow response: %#v", slowResp)
 	}
 }
@@ -119,8 +120,8 @@ func TestMarkAllWorkersDead(t *testing.T) {
 	slow := newFakePool(t, 1, time.Second)
 
 	s := &Server{
-		fastPool: fast,
-		slowPool: slow,
+		pools:     map[string]*WorkerPool{FastPool: fast, SlowPool: slow},
+		poolOrder: []string{FastPool, SlowPool},
 	}
 
 	s.markAllWorkersDead()
@@ -142,8 +143,8 @@ func TestEnableHotReloadMissingDirs(t *testing.T) {
 	tmp := t.TempDir()
 
 	s := &Server{
-		fastPool: newFakePool(t, 1, time.Second),
-		slowPool: newFakePool(t, 1, time.Second),
+		pools:     map[string]*WorkerPool{FastPool: newFakePool(t, 1, time.Second), SlowPool: newFakePool(t, 1, time.Second)},
+		poolOrder: []string{FastPool, SlowPool},
 	}
 
 	// hot reload should succeed even if the directories are missing
@@ -157,8 +158,8 @@ func TestHealthSummaryAndForceRecycle(t *testing.T) {
 	slow := newFakePool(t, 1, time.Second)
 
 	s := &Server{
-		fastPool: fast,
-		slowPool: slow,
+		pools:     map[string]*WorkerPool{FastPool: fast, SlowPool: slow},
+		poolOrder: []string{FastPool, SlowPool},
 	}
 
 	health := s.Health()
@@ -229,8 +230,8 @@ func TestDispatchStreamWithNoWorkers(t *testing.T) {
 	slow := &WorkerPool{workers: []*Worker{}}
 
 	s := &Server{
-		fastPool: fast,
-		slowPool: slow,
+		pools:     map[string]*WorkerPool{FastPool: fast, SlowPool: slow},
+		poolOrder: []string{FastPool, SlowPool},
 		slowCfg: SlowRequestConfig{
 			RoutePrefixes: []string{"/slow"},
 		},
@@ -336,3 +337,351 @@ func TestRecordLatencyDoesNotPromoteIfBelowThreshold(t *testing.T) {
 		t.Fatalf("expected /fast not to be promoted (too fast)")
 	}
 }
+
+func TestPoolForUsesRoutesThenHeuristics(t *testing.T) {
+	s := &Server{
+		pools: map[string]*WorkerPool{
+			FastPool:  newFakePool(t, 1, time.Second),
+			SlowPool:  newFakePool(t, 1, time.Second),
+			"uploads": newFakePool(t, 1, time.Second),
+		},
+		poolOrder: []string{FastPool, SlowPool, "uploads"},
+		routes: []PoolRoute{
+			{Prefix: "/upload", Methods: []string{"POST"}, Pool: "uploads"},
+		},
+		slowCfg: SlowRequestConfig{RoutePrefixes: []string{"/reports"}},
+	}
+
+	cases := []struct {
+		method, path, want string
+	}{
+		{"post", "/upload/avatar", "uploads"},
+		{"GET", "/upload/avatar", FastPool}, // method not pinned
+		{"GET", "/reports/daily", SlowPool},
+		{"GET", "/", FastPool},
+	}
+	for _, c := range cases {
+		if got := s.PoolFor(&RequestPayload{Method: c.method, Path: c.path}); got != c.want {
+			t.Fatalf("PoolFor(%s %s) = %q, want %q", c.method, c.path, got, c.want)
+		}
+	}
+
+	resp, err := s.Dispatch(&RequestPayload{ID: "1", Method: "POST", Path: "/upload/x"})
+	if err != nil {
+		t.Fatalf("Dispatch: %v", err)
+	}
+	if resp.Headers["X-Worker"] != "w0" || string(resp.Body) != "w0:/upload/x" {
+		t.Fatalf("unexpected response: %#v", resp)
+	}
+}
+
+func TestPoolForWithoutFastPoolFallsBackToFirst(t *testing.T) {
+	s := &Server{
+		pools:     map[string]*WorkerPool{"web": {}, "api": {}},
+		poolOrder: []string{"web", "api"},
+	}
+
+	if got := s.PoolFor(&RequestPayload{Method: "DELETE", Path: "/x"}); got != "web" {
+		t.Fatalf("PoolFor = %q, want web", got)
+	}
+}
+
+func TestHealthIncludesNamedPools(t *testing.T) {
+	s := &Server{
+		pools: map[string]*WorkerPool{
+			FastPool: newFakePool(t, 2, time.Second),
+			"api":    newFakePool(t, 3, time.Second),
+		},
+		poolOrder: []string{FastPool, "api"},
+	}
+
+	h := s.Health()
+	if h.Fast.Workers != 2 || h.Slow.Workers != 0 {
+		t.Fatalf("unexpected fast/slow stats: %#v", h)
+	}
+	if h.Pools["api"].Workers != 3 {
+		t.Fatalf("expected api pool in health, got %#v", h.Pools)
+	}
+}
+
+func TestNewServerWithPoolsValidation(t *testing.T) {
+	cases := []struct {
+		name   string
+		pools  []PoolConfig
+		routes []PoolRoute
+	}{
+		{"no pools", nil, nil},
+		{"empty name", []PoolConfig{{Name: ""}}, nil},
+		{"duplicate", []PoolConfig{{Name: "a"}, {Name: "a"}}, nil},
+		{"unknown route pool", []PoolConfig{{Name: "a"}}, []PoolRoute{{Prefix: "/", Pool: "b"}}},
+	}
+
+	for _, c := range cases {
+		if _, err := NewServerWithPools(c.pools, c.routes, SlowRequestConfig{}); err == nil {
+			t.Fatalf("%s: expected error", c.name)
+		}
+	}
+}
+
+func TestPoolConfigPHPArgs(t *testing.T) {
+	pc := PoolConfig{PHPIni: map[string]string{
+		"memory_limit":   "512M",
+		"opcache.enable": "1",
+	}}
+
+	got := pc.phpArgs()
+	want := []string{"-d", "memory_limit=512M", "-d", "opcache.enable=1"}
+	if len(got) != len(want) {
+		t.Fatalf("phpArgs = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("phpArgs = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestPoolForHeaderAndCookieRoutes(t *testing.T) {
+	s := &Server{
+		pools:     map[string]*WorkerPool{FastPool: {}, "b": {}, "staff": {}},
+		poolOrder: []string{FastPool, "b", "staff"},
+		routes: []PoolRoute{
+			{Prefix: "/", Header: "X-Experiment", Value: "b", Pool: "b"},
+			{Prefix: "/", Cookie: "staff", Pool: "staff"},
+		},
+	}
+
+	cases := []struct {
+		name    string
+		headers map[string][]string
+		want    string
+	}{
+		{"no markers", nil, FastPool},
+		{"experiment b", map[string][]string{"X-Experiment": {"b"}}, "b"},
+		{"experiment a", map[string][]string{"X-Experiment": {"a"}}, FastPool},
+		{"staff cookie", map[string][]string{"Cookie": {"theme=dark; staff=1"}}, "staff"},
+		{"empty staff cookie", map[string][]string{"Cookie": {"staff="}}, FastPool},
+		{"other cookie", map[string][]string{"Cookie": {"staffer=1"}}, FastPool},
+	}
+	for _, c := range cases {
+		req := &RequestPayload{Method: "GET", Path: "/", Headers: c.headers}
+		if got := s.PoolFor(req); got != c.want {
+			t.Fatalf("%s: PoolFor = %q, want %q", c.name, got, c.want)
+		}
+	}
+}
+
+func TestPoolForQueryRoute(t *testing.T) {
+	s := &Server{
+		pools:     map[string]*WorkerPool{FastPool: {}, "export": {}},
+		poolOrder: []string{FastPool, "export"},
+		routes:    []PoolRoute{{Prefix: "/reports", Query: "format", Value: "csv", Pool: "export"}},
+	}
+
+	cases := map[string]string{
+		"/reports":                   FastPool,
+		"/reports?format=html":       FastPool,
+		"/reports?page=2&format=csv": "export",
+		"/other?format=csv":          FastPool,
+	}
+	for path, want := range cases {
+		req := &RequestPayload{Method: "GET", Path: path}
+		if got := s.PoolFor(req); got != want {
+			t.Fatalf("%s: PoolFor = %q, want %q", path, got, want)
+		}
+	}
+}
+
+func TestPoolForClassifier(t *testing.T) {
+	s := &Server{
+		pools:     map[string]*WorkerPool{FastPool: {}, "priority": {}, "pinned": {}},
+		poolOrder: []string{FastPool, "priority", "pinned"},
+		routes:    []PoolRoute{{Prefix: "/pinned", Pool: "pinned"}},
+	}
+	s.SetClassifier(func(req *RequestPayload) string {
+		switch p := req.header("X-Priority"); {
+		case len(p) == 0:
+			return ""
+		case p[0] == "high":
+			return "priority"
+		default:
+			return "missing"
+		}
+	})
+
+	cases := []struct {
+		path     string
+		priority string
+		want     string
+	}{
+		{"/", "", FastPool},
+		{"/", "high", "priority"},
+		{"/pinned", "high", "priority"}, // the classifier runs before routes
+		{"/pinned", "", "pinned"},
+		{"/", "low", FastPool}, // unknown pool falls through
+	}
+	for _, c := range cases {
+		req := &RequestPayload{Method: "GET", Path: c.path, Headers: map[string][]string{}}
+		if c.priority != "" {
+			req.Headers["X-Priority"] = []string{c.priority}
+		}
+		if got := s.PoolFor(req); got != c.want {
+			t.Fatalf("%s priority=%q: PoolFor = %q, want %q", c.path, c.priority, got, c.want)
+		}
+	}
+
+	s.SetClassifier(nil)
+	req := &RequestPayload{Method: "GET", Path: "/", Headers: map[string][]string{"X-Priority": {"high"}}}
+	if got := s.PoolFor(req); got != FastPool {
+		t.Fatalf("after removing classifier: PoolFor = %q, want %q", got, FastPool)
+	}
+}
+
+func TestRecordLatencyDemotesRecoveredPrefix(t *testing.T) {
+	s := &Server{
+		slowCfg: SlowRequestConfig{
+			RoutePrefixes: []string{"/export"},
+			Adaptive:      AdaptiveConfig{PromoteMs: 500, DemoteMs: 200, MinSamples: 5},
+		},
+	}
+
+	for i := 0; i < 5; i++ {
+		s.RecordLatency("/reports/daily", 800*time.Millisecond)
+		s.RecordLatency("/export/all", 10*time.Millisecond)
+	}
+	if !s.IsSlowRequest(&RequestPayload{Method: "GET", Path: "/reports/x"}) {
+		t.Fatalf("expected /reports to be promoted")
+	}
+
+	// Between the thresholds nothing changes (hysteresis).
+	for i := 0; i < 20; i++ {
+		s.RecordLatency("/reports/daily", 300*time.Millisecond)
+	}
+	if !s.IsSlowRequest(&RequestPayload{Method: "GET", Path: "/reports/x"}) {
+		t.Fatalf("expected /reports to stay promoted between thresholds")
+	}
+
+	for i := 0; i < 200; i++ {
+		s.RecordLatency("/reports/daily", 10*time.Millisecond)
+	}
+	if s.IsSlowRequest(&RequestPayload{Method: "GET", Path: "/reports/x"}) {
+		t.Fatalf("expected /reports to be demoted")
+	}
+
+	// Configured prefixes are never demoted.
+	if !s.IsSlowRequest(&RequestPayload{Method: "GET", Path: "/export/all"}) {
+		t.Fatalf("configured slow prefix /export was demoted")
+	}
+
+	state := s.Adaptive()
+	if len(state.Promoted) != 0 {
+		t.Fatalf("Promoted = %v, want none", state.Promoted)
+	}
+	if len(state.Events) != 2 || state.Events[0].Action != "promote" || state.Events[1].Action != "demote" {
+		t.Fatalf("Events = %+v, want promote then demote", state.Events)
+	}
+}
+
+func TestRouteStatsDecay(t *testing.T) {
+	cfg := AdaptiveConfig{WindowMs: 1000, Decay: 0.5}.withDefaults()
+	start := time.Now()
+	rs := &routeStats{count: 8, totalLatency: 8, updated: start}
+
+	rs.decay(start.Add(500*time.Millisecond), cfg)
+	if math.Abs(rs.count-8*math.Sqrt(0.5)) > 1e-9 {
+		t.Fatalf("count = %v after half a window, want 8*0.5^0.5", rs.count)
+	}
+
+	rs.decay(start.Add(3*time.Second), cfg)
+	if math.Abs(rs.count-1) > 1e-9 || math.Abs(rs.totalLatency-1) > 1e-9 {
+		t.Fatalf("count = %v after three windows, want 1", rs.count)
+	}
+
+	rs.decay(start, cfg)
+	if math.Abs(rs.count-1) > 1e-9 || !rs.updated.Equal(start.Add(3*time.Second)) {
+		t.Fatalf("decaying to an earlier time changed the stats: %+v", rs)
+	}
+}
+
+func TestAdaptiveDefaultHysteresis(t *testing.T) {
+	if cfg := (AdaptiveConfig{PromoteMs: 1000}).withDefaults(); cfg.DemoteMs != 600 {
+		t.Fatalf("DemoteMs = %d, want 60%% of promote_ms", cfg.DemoteMs)
+	}
+	if cfg := (AdaptiveConfig{PromoteMs: 100, DemoteMs: 200}).withDefaults(); cfg.DemoteMs != 100 {
+		t.Fatalf("DemoteMs = %d, want it capped at promote_ms", cfg.DemoteMs)
+	}
+}
+
+func TestLearnedRoutesRoundTrip(t *testing.T) {
+	cfg := SlowRequestConfig{
+		RoutePrefixes: []string{"/export"},
+		Adaptive:      AdaptiveConfig{PromoteMs: 500, MinSamples: 5},
+	}
+	s := &Server{slowCfg: cfg}
+	for i := 0; i < 5; i++ {
+		s.RecordLatency("/reports/daily", 800*time.Millisecond)
+		s.RecordLatency("/export/all", 800*time.Millisecond)
+	}
+	saved := s.LearnedRoutes()
+	if len(saved) != 1 || saved[0].Prefix != "/reports" || math.Round(saved[0].Samples) != 5 || math.Abs(saved[0].AvgLatencyMs-800) > 1e-6 {
+		t.Fatalf("LearnedRoutes = %+v, want /reports at 800ms", saved)
+	}
+
+	restarted := &Server{slowCfg: cfg}
+	restarted.RestoreLearnedRoutes(append(saved, LearnedRoute{Prefix: "/export"}))
+	if !restarted.IsSlowRequest(&RequestPayload{Method: "GET", Path: "/reports/x"}) {
+		t.Fatal("restored prefix not routed to the slow pool")
+	}
+	if got := restarted.slowCfg.RoutePrefixes; len(got) != 2 {
+		t.Fatalf("RoutePrefixes = %v, want /export once plus /reports", got)
+	}
+
+	// The restored stats decide demotion like learned ones would.
+	for i := 0; i < 4; i++ {
+		restarted.RecordLatency("/reports/daily", 10*time.Millisecond)
+	}
+	if !restarted.IsSlowRequest(&RequestPayload{Method: "GET", Path: "/reports/x"}) {
+		t.Fatal("restored prefix demoted before its average fell")
+	}
+	for i := 0; i < 100; i++ {
+		restarted.RecordLatency("/reports/daily", 10*time.Millisecond)
+	}
+	if restarted.IsSlowRequest(&RequestPayload{Method: "GET", Path: "/reports/x"}) {
+		t.Fatal("restored prefix not demoted once fast")
+	}
+
+	disabled := &Server{slowCfg: SlowRequestConfig{Adaptive: AdaptiveConfig{Disabled: true}}}
+	disabled.RestoreLearnedRoutes(saved)
+	if len(disabled.slowCfg.RoutePrefixes) != 0 {
+		t.Fatal("disabled adaptive routing restored prefixes")
+	}
+}
+
+func TestRouteStatsBounded(t *testing.T) {
+	s := &Server{slowCfg: SlowRequestConfig{Adaptive: AdaptiveConfig{MaxRoutes: 3, PromoteMs: 100, MinSamples: 1}}}
+	s.RecordLatency("/slow", time.Second)
+	for _, p := range []string{"/a", "/b", "/c", "/d"} {
+		s.RecordLatency(p, time.Millisecond)
+	}
+	if len(s.routeStats) != 3 || s.routeStats["/slow"] == nil || s.routeStats["/a"] != nil || s.routeStats["/d"] == nil {
+		t.Fatalf("routeStats = %v, want the promoted /slow plus the newest two", s.routeStats)
+	}
+
+	cfg := AdaptiveConfig{WindowMs: 1000}.withDefaults()
+	now := time.Now()
+	s.routeStatsFor("/d", now.Add(time.Second), cfg)
+	s.routeStatsFor("/e", now.Add(routeIdleWindows*time.Second), cfg)
+	if s.routeStats["/c"] != nil || s.routeStats["/d"] == nil || s.routeStats["/slow"] == nil {
+		t.Fatalf("routeStats = %v, want idle /c dropped", s.routeStats)
+	}
+}
+
+func TestRecordLatencyDisabled(t *testing.T) {
+	s := &Server{slowCfg: SlowRequestConfig{Adaptive: AdaptiveConfig{Disabled: true}}}
+	for i := 0; i < 20; i++ {
+		s.RecordLatency("/reports/daily", time.Second)
+	}
+	if len(s.slowCfg.RoutePrefixes) != 0 {
+		t.Fatalf("disabled adaptive routing promoted %v", s.slowCfg.RoutePrefixes)
+	}
+}
@@ -0,0 +1,193 @@
+package server
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Shadow mirrors Percent of live requests to Pool in the background. The
+// shadow responses are discarded; only their latency and outcome are kept.
+// Only Methods are mirrored (GET and HEAD by default) so writes aren't
+// replayed, and mirrored requests carry X-Shadow-Request: 1.
+type Shadow struct {
+	Pool        string   `json:"pool"`
+	Percent     int      `json:"percent"` // 0-100
+	Methods     []string `json:"methods"`
+	MaxInFlight int      `json:"max_in_flight"` // extra mirrors are dropped (default: 2 per shadow worker)
+}
+
+// ShadowStats summarises mirrored traffic.
+type ShadowStats struct {
+	Pool         string         `json:"pool"`
+	Percent      int            `json:"percent"`
+	Mirrored     uint64         `json:"mirrored"`
+	Dropped      uint64         `json:"dropped"` // skipped because MaxInFlight was reached
+	Errors       uint64         `json:"errors"`  // worker errors (timeouts, crashes)
+	ByStatus     map[int]uint64 `json:"by_status"`
+	AvgLatencyMs float64        `json:"avg_latency_ms"`
+	MaxLatencyMs float64        `json:"max_latency_ms"`
+}
+
+type shadowState struct {
+	cfg   Shadow
+	slots chan struct{}
+
+	mu           sync.Mutex
+	mirrored     uint64
+	dropped      uint64
+	errors       uint64
+	byStatus     map[int]uint64
+	totalLatency time.Duration
+	maxLatency   time.Duration
+}
+
+// SetShadow starts mirroring traffic to cfg.Pool, resetting the stats.
+func (s *Server) SetShadow(cfg Shadow) error {
+	pool, ok := s.pools[cfg.Pool]
+	if !ok {
+		return fmt.Errorf("shadow: unknown pool %q", cfg.Pool)
+	}
+	if cfg.Percent < 0 || cfg.Percent > 100 {
+		return fmt.Errorf("shadow: percent %d out of range 0-100", cfg.Percent)
+	}
+	if len(cfg.Methods) == 0 {
+		cfg.Methods = []string{"GET", "HEAD"}
+	}
+	if cfg.MaxInFlight <= 0 {
+		cfg.MaxInFlight = 2 * max(len(pool.workers), 1)
+	}
+
+	s.shadowMu.Lock()
+	s.shadow = &shadowState{
+		cfg:      cfg,
+		slots:    make(chan struct{}, cfg.MaxInFlight),
+		byStatus: make(map[int]uint64),
+	}
+	s.shadowMu.Unlock()
+	return nil
+}
+
+// SetShadowPercent changes how much traffic is mirrored, keeping the stats.
+func (s *Server) SetShadowPercent(percent int) error {
+	if percent < 0 || percent > 100 {
+		return fmt.Errorf("shadow: percent %d out of range 0-100", percent)
+	}
+
+	s.shadowMu.Lock()
+	defer s.shadowMu.Unlock()
+	if s.shadow == nil {
+		return fmt.Errorf("shadow: not configured")
+	}
+	s.shadow.mu.Lock()
+	s.shadow.cfg.Percent = percent
+	s.shadow.mu.Unlock()
+	return nil
+}
+
+// ShadowStats reports mirrored traffic so far, if shadowing is configured.
+func (s *Server) ShadowStats() (ShadowStats, bool) {
+	st := s.shadowState()
+	if st == nil {
+		return ShadowStats{}, false
+	}
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	out := ShadowStats{
+		Pool:         st.cfg.Pool,
+		Percent:      st.cfg.Percent,
+		Mirrored:     st.mirrored,
+		Dropped:      st.dropped,
+		Errors:       st.errors,
+		ByStatus:     make(map[int]uint64, len(st.byStatus)),
+		MaxLatencyMs: float64(st.maxLatency.Microseconds()) / 1000,
+	}
+	for code, n := range st.byStatus {
+		out.ByStatus[code] = n
+	}
+	if completed := st.mirrored - st.errors; completed > 0 {
+		out.AvgLatencyMs = float64((st.totalLatency / time.Duration(completed)).Microseconds()) / 1000
+	}
+	return out, true
+}
+
+func (s *Server) shadowState() *shadowState {
+	s.shadowMu.RLock()
+	defer s.shadowMu.RUnlock()
+	return s.shadow
+}
+
+// mirror replays req to the shadow pool in the background when it is
+// sampled. primary is the pool serving the live request; traffic that
+// already goes to the shadow pool is never mirrored.
+func (s *Server) mirror(req *RequestPayload, primary string) {
+	st := s.shadowState()
+	if st == nil {
+		return
+	}
+
+	st.mu.Lock()
+	cfg := st.cfg
+	st.mu.Unlock()
+
+	if cfg.Percent <= 0 || primary == cfg.Pool || !containsFold(cfg.Methods, strings.ToUpper(req.Method)) {
+		return
+	}
+	if cfg.Percent < 100 && rand.IntN(100) >= cfg.Percent {
+		return
+	}
+
+	select {
+	case st.slots <- struct{}{}:
+	default:
+		st.mu.Lock()
+		st.dropped++
+		st.mu.Unlock()
+		return
+	}
+
+	// Copy so the live request can't race with the mirror.
+	shadowReq := *req
+	shadowReq.ID = req.ID + "-shadow"
+	shadowReq.priority = PriorityLow // never ahead of live traffic
+	shadowReq.RelayOver = 0          // its response is thrown away whole
+	shadowReq.Headers = make(map[string][]string, len(req.Headers)+1)
+	for k, v := range req.Headers {
+		shadowReq.Headers[k] = v
+	}
+	shadowReq.Headers["X-Shadow-Request"] = []string{"1"}
+
+	pool := s.pools[cfg.Pool]
+	go func() {
+		defer func() { <-st.slots }()
+
+		start := time.Now()
+		resp, err := pool.Dispatch(&shadowReq)
+		st.record(resp, err, time.Since(start))
+	}()
+}
+
+func (st *shadowState) record(resp *ResponsePayload, err error, d time.Duration) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.mirrored++
+	if err != nil {
+		st.errors++
+		return
+	}
+
+	status := resp.Status
+	if status == 0 {
+		status = 200
+	}
+	st.byStatus[status]++
+	st.totalLatency += d
+	if d > st.maxLatency {
+		st.maxLatency = d
+	}
+}
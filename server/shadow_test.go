@@ -0,0 +1,105 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func waitShadowMirrored(t *testing.T, s *Server, want uint64) ShadowStats {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		st, _ := s.ShadowStats()
+		if st.Mirrored >= want {
+			return st
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %d mirrored requests, stats: %+v", want, st)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestShadowMirrorsSafeMethods(t *testing.T) {
+	s := &Server{
+		pools: map[string]*WorkerPool{
+			FastPool: newFakePool(t, 1, time.Second),
+			"shadow": newFakePool(t, 1, time.Second),
+		},
+		poolOrder: []string{FastPool, "shadow"},
+	}
+	if err := s.SetShadow(Shadow{Pool: "shadow", Percent: 100}); err != nil {
+		t.Fatalf("SetShadow: %v", err)
+	}
+
+	resp, err := s.Dispatch(&RequestPayload{ID: "1", Method: "GET", Path: "/a"})
+	if err != nil || string(resp.Body) != "w0:/a" {
+		t.Fatalf("primary response = %+v, %v", resp, err)
+	}
+	st := waitShadowMirrored(t, s, 1)
+	if st.ByStatus[200] != 1 || st.Errors != 0 {
+		t.Fatalf("unexpected shadow stats: %+v", st)
+	}
+
+	// Writes are not mirrored by default.
+	if _, err := s.Dispatch(&RequestPayload{ID: "2", Method: "POST", Path: "/a"}); err != nil {
+		t.Fatalf("Dispatch: %v", err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if st, _ := s.ShadowStats(); st.Mirrored != 1 {
+		t.Fatalf("POST should not be mirrored, stats: %+v", st)
+	}
+
+	if err := s.SetShadowPercent(0); err != nil {
+		t.Fatalf("SetShadowPercent: %v", err)
+	}
+	if _, err := s.Dispatch(&RequestPayload{ID: "3", Method: "GET", Path: "/a"}); err != nil {
+		t.Fatalf("Dispatch: %v", err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if st, _ := s.ShadowStats(); st.Mirrored != 1 {
+		t.Fatalf("percent 0 should not mirror, stats: %+v", st)
+	}
+}
+
+func TestShadowRecordsErrorsAndDrops(t *testing.T) {
+	s := &Server{
+		pools: map[string]*WorkerPool{
+			FastPool: newFakePool(t, 1, time.Second),
+			"shadow": {}, // no workers: every mirror fails
+		},
+		poolOrder: []string{FastPool, "shadow"},
+	}
+	if err := s.SetShadow(Shadow{Pool: "shadow", Percent: 100, MaxInFlight: 1}); err != nil {
+		t.Fatalf("SetShadow: %v", err)
+	}
+
+	// Fill the only slot so the next mirror is dropped.
+	st := s.shadowState()
+	st.slots <- struct{}{}
+	if _, err := s.Dispatch(&RequestPayload{ID: "1", Method: "GET", Path: "/"}); err != nil {
+		t.Fatalf("Dispatch: %v", err)
+	}
+	<-st.slots
+
+	if _, err := s.Dispatch(&RequestPayload{ID: "2", Method: "GET", Path: "/"}); err != nil {
+		t.Fatalf("Dispatch: %v", err)
+	}
+	stats := waitShadowMirrored(t, s, 1)
+	if stats.Dropped != 1 || stats.Errors != 1 {
+		t.Fatalf("expected 1 dropped and 1 error, got %+v", stats)
+	}
+}
+
+func TestSetShadowValidation(t *testing.T) {
+	s := &Server{pools: map[string]*WorkerPool{FastPool: {}}}
+	if err := s.SetShadow(Shadow{Pool: "missing", Percent: 10}); err == nil {
+		t.Fatalf("expected unknown pool error")
+	}
+	if err := s.SetShadow(Shadow{Pool: FastPool, Percent: 200}); err == nil {
+		t.Fatalf("expected percent range error")
+	}
+	if err := s.SetShadowPercent(5); err == nil {
+		t.Fatalf("expected error when shadow is not configured")
+	}
+}
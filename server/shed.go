@@ -0,0 +1,161 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// ErrOverloaded is returned (wrapped in *OverloadError) when a request is
+// shed instead of being queued for a worker.
+var ErrOverloaded = errors.New("server overloaded")
+
+// LoadShed rejects requests up front when a pool is saturated, instead of
+// queueing them until they all time out. A pool is saturated when it has
+// MaxInFlightPerWorker requests per worker already running or queued, or
+// when requests recently waited longer than MaxQueueWaitMs for a worker.
+// Paths starting with one of Exempt are never shed; with Priorities set,
+// high-priority requests aren't either and low-priority requests are shed
+// at half the limits.
+type LoadShed struct {
+	MaxInFlightPerWorker int      `json:"max_in_flight_per_worker"` // 0 = no limit
+	MaxQueueWaitMs       int      `json:"max_queue_wait_ms"`        // 0 = no limit
+	RetryAfterSeconds    int      `json:"retry_after_seconds"`      // default 1
+	Exempt               []string `json:"exempt"`
+}
+
+// OverloadError says which pool shed the request and when to come back.
+type OverloadError struct {
+	Pool       string
+	Reason     string
+	RetryAfter time.Duration
+}
+
+func (e *OverloadError) Error() string {
+	return fmt.Sprintf("%v: pool %q %s", ErrOverloaded, e.Pool, e.Reason)
+}
+
+func (e *OverloadError) Unwrap() error { return ErrOverloaded }
+
+type shedState struct {
+	cfg  LoadShed
+	shed atomic.Uint64
+}
+
+// queueWaitDecay weights each new sample in a worker's average queue wait.
+const queueWaitDecay = 0.2
+
+// acquire takes the worker's request lock (higher priorities first),
+// recording how long the caller queued behind other requests for it.
+func (w *Worker) acquire(p Priority) time.Duration {
+	start := time.Now()
+	w.gate.lock(p)
+	w.mu.Lock()
+	wait := time.Since(start)
+	prev := float64(w.queueWaitNs.Load())
+	w.queueWaitNs.Store(int64(prev + queueWaitDecay*(float64(wait)-prev)))
+	return wait
+}
+
+// release gives the worker to the next queued request.
+func (w *Worker) release() {
+	w.mu.Unlock()
+	w.gate.unlock()
+}
+
+// QueueWait is the recent average time requests waited for this worker.
+func (w *Worker) QueueWait() time.Duration {
+	return time.Duration(w.queueWaitNs.Load())
+}
+
+// load returns the requests running or queued on the pool's available
+// workers, how many such workers there are, and the shortest recent queue
+// wait among them (the best a new request can expect).
+func (p *WorkerPool) load() (inFlight, workers int, wait time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	wait = -1
+	for _, w := range p.workers {
+		if !w.Available() {
+			continue
+		}
+		workers++
+		inFlight += w.InFlight()
+		if qw := w.QueueWait(); wait < 0 || qw < wait {
+			wait = qw
+		}
+	}
+	return inFlight, workers, max(wait, 0)
+}
+
+// SetLoadShed enables load shedding; the zero LoadShed disables it.
+func (s *Server) SetLoadShed(cfg LoadShed) error {
+	if cfg.MaxInFlightPerWorker < 0 || cfg.MaxQueueWaitMs < 0 {
+		return errors.New("load shed: limits must not be negative")
+	}
+	if cfg.RetryAfterSeconds <= 0 {
+		cfg.RetryAfterSeconds = 1
+	}
+	if cfg.MaxInFlightPerWorker == 0 && cfg.MaxQueueWaitMs == 0 {
+		s.loadShed.Store(nil)
+		return nil
+	}
+	s.loadShed.Store(&shedState{cfg: cfg})
+	return nil
+}
+
+// Shed returns how many requests have been rejected by load shedding.
+func (s *Server) Shed() uint64 {
+	if st := s.loadShed.Load(); st != nil {
+		return st.shed.Load()
+	}
+	return 0
+}
+
+// checkLoad returns an *OverloadError if req should be shed from pool.
+func (s *Server) checkLoad(req *RequestPayload, name string, pool *WorkerPool) error {
+	st := s.loadShed.Load()
+	if st == nil {
+		return nil
+	}
+	for _, prefix := range st.cfg.Exempt {
+		if strings.HasPrefix(req.Path, prefix) {
+			return nil
+		}
+	}
+
+	maxInFlight, maxWait := st.cfg.MaxInFlightPerWorker, time.Duration(st.cfg.MaxQueueWaitMs)*time.Millisecond
+	switch req.priority {
+	case PriorityHigh:
+		return nil
+	case PriorityLow:
+		maxInFlight = (maxInFlight + 1) / 2
+		maxWait /= 2
+	}
+
+	inFlight, workers, wait := pool.load()
+	var reason string
+	switch {
+	case workers == 0:
+		return nil // let dispatch report ErrNoWorkers
+	case maxInFlight > 0 && inFlight >= workers*maxInFlight:
+		reason = fmt.Sprintf("has %d requests in flight on %d workers", inFlight, workers)
+	case maxWait > 0 && wait > maxWait:
+		reason = fmt.Sprintf("queue wait %v exceeds %v", wait.Round(time.Millisecond), maxWait)
+	default:
+		return nil
+	}
+	if req.priority == PriorityLow {
+		reason += " (low priority)"
+	}
+
+	st.shed.Add(1)
+	return &OverloadError{
+		Pool:       name,
+		Reason:     reason,
+		RetryAfter: time.Duration(st.cfg.RetryAfterSeconds) * time.Second,
+	}
+}
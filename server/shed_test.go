@@ -0,0 +1,76 @@
+package server
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestDispatchShedsWhenPoolIsFull(t *testing.T) {
+	pool := newFakePool(t, 2, time.Second)
+	s := &Server{pools: map[string]*WorkerPool{FastPool: pool}, poolOrder: []string{FastPool}}
+	if err := s.SetLoadShed(LoadShed{MaxInFlightPerWorker: 2, RetryAfterSeconds: 5, Exempt: []string{"/up"}}); err != nil {
+		t.Fatalf("SetLoadShed: %v", err)
+	}
+
+	if _, err := s.Dispatch(&RequestPayload{ID: "1", Method: "GET", Path: "/"}); err != nil {
+		t.Fatalf("Dispatch on an idle pool: %v", err)
+	}
+
+	for _, w := range pool.workers {
+		w.incrInFlight()
+		w.incrInFlight()
+	}
+
+	_, err := s.Dispatch(&RequestPayload{ID: "2", Method: "GET", Path: "/"})
+	var overload *OverloadError
+	if !errors.As(err, &overload) || !errors.Is(err, ErrOverloaded) {
+		t.Fatalf("expected an OverloadError, got %v", err)
+	}
+	if overload.Pool != FastPool || overload.RetryAfter != 5*time.Second {
+		t.Fatalf("unexpected overload error: %+v", overload)
+	}
+	if s.Shed() != 1 || s.Health().Shed != 1 {
+		t.Fatalf("Shed = %d, want 1", s.Shed())
+	}
+
+	if _, err := s.Dispatch(&RequestPayload{ID: "3", Method: "GET", Path: "/up"}); err != nil {
+		t.Fatalf("exempt path was shed: %v", err)
+	}
+}
+
+func TestDispatchShedsOnQueueWait(t *testing.T) {
+	pool := newFakePool(t, 1, time.Second)
+	s := &Server{pools: map[string]*WorkerPool{FastPool: pool}, poolOrder: []string{FastPool}}
+	if err := s.SetLoadShed(LoadShed{MaxQueueWaitMs: 100}); err != nil {
+		t.Fatalf("SetLoadShed: %v", err)
+	}
+
+	pool.workers[0].queueWaitNs.Store(int64(250 * time.Millisecond))
+	if _, err := s.Dispatch(&RequestPayload{ID: "1", Method: "GET", Path: "/"}); !errors.Is(err, ErrOverloaded) {
+		t.Fatalf("expected shedding on a 250ms queue wait, got %v", err)
+	}
+
+	pool.workers[0].queueWaitNs.Store(0)
+	if _, err := s.Dispatch(&RequestPayload{ID: "2", Method: "GET", Path: "/"}); err != nil {
+		t.Fatalf("Dispatch after the queue drained: %v", err)
+	}
+}
+
+func TestWorkerQueueWaitIsMeasured(t *testing.T) {
+	w := newFakeWorker(t, "w0", time.Second)
+
+	w.mu.Lock()
+	done := make(chan struct{})
+	go func() {
+		_, _ = w.Handle(&RequestPayload{ID: "1", Method: "GET", Path: "/"})
+		close(done)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	w.mu.Unlock()
+	<-done
+
+	if w.QueueWait() < 5*time.Millisecond {
+		t.Fatalf("QueueWait = %v, expected the blocked request to be counted", w.QueueWait())
+	}
+}
@@ -31,6 +31,7 @@ type SSEHub struct {
 	mu       sync.RWMutex
 	clients  map[string]map[*sseClient]struct{} // channel -> set of clients
 	incoming chan sseEvent
+	counters hubCounters
 }
 
 // NewSSEHub creates a hub and starts its fanout goroutine
@@ -51,9 +52,10 @@ func (h *SSEHub) run() {
 		for c := range subs {
 			select {
 			case c.ch <- ev:
+				h.counters.delivered.Add(1)
 			default:
 				// slow / backed-up clients drop events
-
+				h.counters.dropped.Add(1)
 			}
 		}
 		h.mu.RUnlock()
@@ -87,6 +89,11 @@ func (h *SSEHub) Unsubscribe(channel string, c *sseClient) {
 		return
 	}
 
+	if _, ok := subs[c]; !ok {
+		// already removed (e.g. by CloseChannel)
+		return
+	}
+
 	delete(subs, c)
 	close(c.done)
 	if len(subs) == 0 {
@@ -94,6 +101,21 @@ func (h *SSEHub) Unsubscribe(channel string, c *sseClient) {
 	}
 }
 
+// CloseChannel drops every client subscribed to channel and closes their
+// done channels so the SSE handlers return. It returns the number of
+// clients removed.
+func (h *SSEHub) CloseChannel(channel string) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	subs := h.clients[channel]
+	for c := range subs {
+		close(c.done)
+	}
+	delete(h.clients, channel)
+	return len(subs)
+}
+
 // Publish JSON-encodes payload and broadcasts it to all subscribers
 func (h *SSEHub) Publish(channel, event string, payload any) {
 	data, err := json.Marshal(payload)
@@ -101,9 +123,22 @@ func (h *SSEHub) Publish(channel, event string, payload any) {
 		log.Printf("[sse] marshal error: %v", err)
 		return
 	}
+	h.counters.published.Add(1)
 	h.incoming <- sseEvent{
 		Channel: channel,
 		Event:   event,
 		Data:    data,
 	}
 }
+
+// Stats returns subscription gauges and delivery counters.
+func (h *SSEHub) Stats() HubStats {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	clients := 0
+	for _, subs := range h.clients {
+		clients += len(subs)
+	}
+	return h.counters.stats(len(h.clients), clients)
+}
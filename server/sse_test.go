@@ -25,10 +25,11 @@ func TestSSEHubSubscribeAndPublish(t *testing.T) {
 
 		var data map[string]any
 		if err := json.Unmarshal(ev.Data, &data); err != nil {
-			t.Fatalf("unmarshal error: %v", err)
+			t.Errorf("unmarshal error: %v", err)
+			return
 		}
 		if data["hello"] != "world" {
-			t.Fatalf("expected hello=world, got %v", data["hello"])
+			t.Errorf("expected hello=world, got %v", data["hello"])
 		}
 	}()
 
@@ -84,3 +85,21 @@ func BenchmarkSSEHubPublish(b *testing.B) {
 		hub.Publish("bench", "bench", map[string]string{"msg": "x"})
 	}
 }
+
+func TestSSEHubCloseChannel(t *testing.T) {
+	hub := NewSSEHub()
+
+	client := hub.Subscribe("user:42")
+	if n := hub.CloseChannel("user:42"); n != 1 {
+		t.Fatalf("CloseChannel closed %d clients, want 1", n)
+	}
+
+	select {
+	case <-client.Done():
+	default:
+		t.Fatalf("expected client done channel to be closed")
+	}
+
+	// The handler's deferred Unsubscribe must not double-close.
+	hub.Unsubscribe("user:42", client)
+}
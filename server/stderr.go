@@ -0,0 +1,130 @@
+package server
+
+import (
+	"bytes"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	stderrRingLines = 200  // lines kept per worker
+	maxStderrLine   = 4096 // longer lines are cut
+)
+
+// LogLine is one line a worker wrote to stderr.
+type LogLine struct {
+	Time time.Time `json:"time"`
+	Line string    `json:"line"`
+}
+
+var lastWorkerID atomic.Uint64
+
+func nextWorkerID() uint64 { return lastWorkerID.Add(1) }
+
+// logRing keeps the last stderrRingLines lines written to it and echoes each
+// line to the process log tagged with the worker id. It survives restarts,
+// so the output leading up to a crash is still there afterwards.
+type logRing struct {
+	id     uint64
+	logger *log.Logger // nil means the standard logger
+
+	mu      sync.Mutex
+	lines   []LogLine
+	next    int // slot for the next line once lines is full
+	partial []byte
+}
+
+func newLogRing(id uint64) *logRing {
+	return &logRing{id: id, lines: make([]LogLine, 0, stderrRingLines)}
+}
+
+func (r *logRing) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	data := p
+	for len(data) > 0 {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			r.partial = append(r.partial, data...)
+			if len(r.partial) >= maxStderrLine {
+				r.addLocked(r.partial)
+				r.partial = r.partial[:0]
+			}
+			break
+		}
+		r.partial = append(r.partial, data[:i]...)
+		r.addLocked(r.partial)
+		r.partial = r.partial[:0]
+		data = data[i+1:]
+	}
+	return len(p), nil
+}
+
+// flush records any unterminated last line (e.g. when the process exits).
+func (r *logRing) flush() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.partial) > 0 {
+		r.addLocked(r.partial)
+		r.partial = r.partial[:0]
+	}
+}
+
+func (r *logRing) addLocked(b []byte) {
+	line := string(bytes.TrimRight(b, "\r"))
+	if len(line) > maxStderrLine {
+		line = line[:maxStderrLine]
+	}
+	logTo(r.logger).Printf("[worker %d] %s", r.id, line)
+
+	entry := LogLine{Time: time.Now(), Line: line}
+	if len(r.lines) < stderrRingLines {
+		r.lines = append(r.lines, entry)
+		return
+	}
+	r.lines[r.next] = entry
+	r.next = (r.next + 1) % stderrRingLines
+}
+
+// last returns up to n of the most recent lines, oldest first.
+func (r *logRing) last(n int) []LogLine {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ordered := append(append([]LogLine(nil), r.lines[r.next:]...), r.lines[:r.next]...)
+	if n > 0 && n < len(ordered) {
+		ordered = ordered[len(ordered)-n:]
+	}
+	return ordered
+}
+
+// ID identifies the worker in health output, the scoreboard and the logs API.
+func (w *Worker) ID() uint64 { return w.id }
+
+// Logs returns up to n of the worker's most recent stderr lines (all kept
+// lines if n <= 0).
+func (w *Worker) Logs(n int) []LogLine {
+	if w.stderr == nil {
+		return nil
+	}
+	return w.stderr.last(n)
+}
+
+// Worker finds a worker by ID in any pool.
+func (s *Server) Worker(id uint64) *Worker {
+	for _, name := range s.poolOrder {
+		p := s.pools[name]
+		p.mu.Lock()
+		for _, w := range p.workers {
+			if w != nil && w.id == id {
+				p.mu.Unlock()
+				return w
+			}
+		}
+		p.mu.Unlock()
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLogRingSplitsLinesAndKeepsTail(t *testing.T) {
+	r := newLogRing(7)
+
+	_, _ = r.Write([]byte("PHP Warning: one\nPHP Warn"))
+	_, _ = r.Write([]byte("ing: two\r\npartial"))
+	if got := r.last(0); len(got) != 2 || got[0].Line != "PHP Warning: one" || got[1].Line != "PHP Warning: two" {
+		t.Fatalf("unexpected lines: %+v", got)
+	}
+
+	r.flush()
+	if got := r.last(1); len(got) != 1 || got[0].Line != "partial" {
+		t.Fatalf("flush should record the partial line, got %+v", got)
+	}
+
+	for i := 0; i < stderrRingLines+5; i++ {
+		fmt.Fprintf(r, "line %d\n", i)
+	}
+	got := r.last(0)
+	if len(got) != stderrRingLines {
+		t.Fatalf("ring should hold %d lines, got %d", stderrRingLines, len(got))
+	}
+	if got[0].Line != "line 5" || got[len(got)-1].Line != fmt.Sprintf("line %d", stderrRingLines+4) {
+		t.Fatalf("ring order wrong: first %q last %q", got[0].Line, got[len(got)-1].Line)
+	}
+}
+
+func TestLogRingCutsLongLines(t *testing.T) {
+	r := newLogRing(1)
+	_, _ = r.Write([]byte(strings.Repeat("x", maxStderrLine+10)))
+	got := r.last(0)
+	if len(got) != 1 || len(got[0].Line) != maxStderrLine {
+		t.Fatalf("expected one line cut to %d bytes, got %+v", maxStderrLine, len(got))
+	}
+}
+
+func TestServerWorkerFindsByID(t *testing.T) {
+	pool := newFakePool(t, 2, time.Second)
+	pool.workers[1].id = nextWorkerID()
+	srv := newServer(map[string]*WorkerPool{"default": pool}, []string{"default"}, nil, SlowRequestConfig{})
+
+	if w := srv.Worker(pool.workers[1].id); w != pool.workers[1] {
+		t.Fatalf("expected worker %d", pool.workers[1].id)
+	}
+	if w := srv.Worker(1 << 62); w != nil {
+		t.Fatalf("unknown id should not match")
+	}
+	if logs := pool.workers[1].Logs(10); logs != nil {
+		t.Fatalf("worker without a process should have no logs, got %+v", logs)
+	}
+}
@@ -38,11 +38,8 @@ func TestWorkerStreamErrorFramePropagates(t *testing.T) {
 	}
 	data := encodeFrame(t, errorFrame)
 
-	// stdout: our fake frame stream
-	w.stdout = io.NopCloser(bytes.NewReader(data))
-
-	// stdin: we don't care what gets written, just need a non-nil WriteCloser
-	w.stdin = nopWriteCloser{Writer: io.Discard}
+	// stdin: we don't care what gets written; stdout: our fake frame stream
+	w.transport = NewStreamTransport(nopWriteCloser{Writer: io.Discard}, io.NopCloser(bytes.NewReader(data)), nil)
 
 	rr := httptest.NewRecorder()
 	req := &RequestPayload{} // body doesn't matter for this test
@@ -59,7 +56,6 @@ func TestWorkerStreamErrorFramePropagates(t *testing.T) {
 func TestFrameHeadersMultiValue(t *testing.T) {
 	w := &Worker{
 		requestTimeout: 500 * time.Millisecond,
-		stdin:          nopWriteCloser{Writer: io.Discard},
 	}
 
 	// One headers frame with multi-valued header + a normal end frame.
@@ -80,7 +76,7 @@ func TestFrameHeadersMultiValue(t *testing.T) {
 	buf.Write(encodeFrame(t, headersFrame))
 	buf.Write(encodeFrame(t, endFrame))
 
-	w.stdout = io.NopCloser(bytes.NewReader(buf.Bytes()))
+	w.transport = NewStreamTransport(nopWriteCloser{Writer: io.Discard}, io.NopCloser(bytes.NewReader(buf.Bytes())), nil)
 
 	rr := httptest.NewRecorder()
 	req := &RequestPayload{}
@@ -125,8 +121,7 @@ func TestWorkerStreamHeadersFrameWithEmptyHeaders(t *testing.T) {
 	buf.Write(encodeFrame(t, headersFrame))
 	buf.Write(encodeFrame(t, endFrame))
 
-	w.stdout = io.NopCloser(bytes.NewReader(buf.Bytes()))
-	w.stdin = nopWriteCloser{Writer: io.Discard}
+	w.transport = NewStreamTransport(nopWriteCloser{Writer: io.Discard}, io.NopCloser(bytes.NewReader(buf.Bytes())), nil)
 
 	rr := httptest.NewRecorder()
 	req := &RequestPayload{}
@@ -162,8 +157,7 @@ func TestWorkerStreamHeadersFrameWithEmptyHeaderValues(t *testing.T) {
 	buf.Write(encodeFrame(t, headersFrame))
 	buf.Write(encodeFrame(t, endFrame))
 
-	w.stdout = io.NopCloser(bytes.NewReader(buf.Bytes()))
-	w.stdin = nopWriteCloser{Writer: io.Discard}
+	w.transport = NewStreamTransport(nopWriteCloser{Writer: io.Discard}, io.NopCloser(bytes.NewReader(buf.Bytes())), nil)
 
 	rr := httptest.NewRecorder()
 	req := &RequestPayload{}
@@ -203,8 +197,7 @@ func TestWorkerStreamChunkFrame(t *testing.T) {
 	buf.Write(encodeFrame(t, chunkFrame))
 	buf.Write(encodeFrame(t, endFrame))
 
-	w.stdout = io.NopCloser(bytes.NewReader(buf.Bytes()))
-	w.stdin = nopWriteCloser{Writer: io.Discard}
+	w.transport = NewStreamTransport(nopWriteCloser{Writer: io.Discard}, io.NopCloser(bytes.NewReader(buf.Bytes())), nil)
 
 	rr := httptest.NewRecorder()
 	req := &RequestPayload{}
@@ -237,8 +230,7 @@ func TestWorkerStreamChunkFrameWithoutHeaders(t *testing.T) {
 	buf.Write(encodeFrame(t, chunkFrame))
 	buf.Write(encodeFrame(t, endFrame))
 
-	w.stdout = io.NopCloser(bytes.NewReader(buf.Bytes()))
-	w.stdin = nopWriteCloser{Writer: io.Discard}
+	w.transport = NewStreamTransport(nopWriteCloser{Writer: io.Discard}, io.NopCloser(bytes.NewReader(buf.Bytes())), nil)
 
 	rr := httptest.NewRecorder()
 	req := &RequestPayload{}
@@ -276,8 +268,7 @@ func TestWorkerStreamChunkFrameWithEmptyData(t *testing.T) {
 	buf.Write(encodeFrame(t, chunkFrame))
 	buf.Write(encodeFrame(t, endFrame))
 
-	w.stdout = io.NopCloser(bytes.NewReader(buf.Bytes()))
-	w.stdin = nopWriteCloser{Writer: io.Discard}
+	w.transport = NewStreamTransport(nopWriteCloser{Writer: io.Discard}, io.NopCloser(bytes.NewReader(buf.Bytes())), nil)
 
 	rr := httptest.NewRecorder()
 	req := &RequestPayload{}
@@ -297,8 +288,7 @@ func TestWorkerStreamUnknownFrameType(t *testing.T) {
 	}
 	data := encodeFrame(t, unknownFrame)
 
-	w.stdout = io.NopCloser(bytes.NewReader(data))
-	w.stdin = nopWriteCloser{Writer: io.Discard}
+	w.transport = NewStreamTransport(nopWriteCloser{Writer: io.Discard}, io.NopCloser(bytes.NewReader(data)), nil)
 
 	rr := httptest.NewRecorder()
 	req := &RequestPayload{}
@@ -330,8 +320,7 @@ func TestWorkerStreamHeadersFrameWithoutData(t *testing.T) {
 	buf.Write(encodeFrame(t, headersFrame))
 	buf.Write(encodeFrame(t, endFrame))
 
-	w.stdout = io.NopCloser(bytes.NewReader(buf.Bytes()))
-	w.stdin = nopWriteCloser{Writer: io.Discard}
+	w.transport = NewStreamTransport(nopWriteCloser{Writer: io.Discard}, io.NopCloser(bytes.NewReader(buf.Bytes())), nil)
 
 	rr := httptest.NewRecorder()
 	req := &RequestPayload{}
@@ -22,8 +22,7 @@ func newFakeStreamWorker(t *testing.T, status int, headers map[string][]string,
 	stdoutR, stdoutW := io.Pipe()
 
 	w := &Worker{
-		stdin:          stdinW,
-		stdout:         stdoutR,
+		transport:      NewStreamTransport(stdinW, stdoutR, nil),
 		maxRequests:    100,
 		requestTimeout: 0, // disable timeout for this test
 	}
@@ -109,7 +108,7 @@ func TestWorkerStreamHappyPath(t *testing.T) {
 		Method:  "GET",
 		Path:    "/stream",
 		Headers: map[string][]string{},
-		Body:    "",
+		Body:    nil,
 	}
 
 	rr := httptest.NewRecorder()
@@ -151,9 +150,9 @@ func TestEnableHotReloadHappyPath(t *testing.T) {
 	slow := &Worker{}
 
 	s := &Server{
-		fastPool: &WorkerPool{workers: []*Worker{fast}},
-		slowPool: &WorkerPool{workers: []*Worker{slow}},
-		slowCfg:  SlowRequestConfig{},
+		pools:     map[string]*WorkerPool{FastPool: &WorkerPool{workers: []*Worker{fast}}, SlowPool: &WorkerPool{workers: []*Worker{slow}}},
+		poolOrder: []string{FastPool, SlowPool},
+		slowCfg:   SlowRequestConfig{},
 	}
 
 	if err := s.EnableHotReload(tmp); err != nil {
@@ -0,0 +1,197 @@
+// Package testkit runs fake PHP workers in process, so code built on the
+// server and appserver packages can be tested without PHP installed.
+//
+// A Handler scripts the worker's answers. NewFakeWorker and NewFakePool
+// wire it up over in-memory pipes; Listen serves it on a TCP port for a
+// PoolConfig with Transport server.TransportTCP, which also works from an
+// appserver config.
+package testkit
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"go-php/server"
+)
+
+// Handler decides how the fake worker answers req.
+type Handler func(req *server.RequestPayload) Reply
+
+// Reply is one scripted answer.
+type Reply struct {
+	// Response answers a buffered request. Its ID is filled in.
+	Response *server.ResponsePayload
+	// Frames answer a streamed request instead; an "end" frame is added
+	// unless the last one ends the stream already. After a Response with
+	// Relay set, they are the chunks of its body.
+	Frames []server.StreamFrame
+	// Delay is waited before answering.
+	Delay time.Duration
+	// HangUp closes the connection without answering, the way a crashed
+	// worker does.
+	HangUp bool
+}
+
+// Respond is a Reply with a buffered response.
+func Respond(status int, body string) Reply {
+	return Reply{Response: &server.ResponsePayload{Status: status, Body: []byte(body)}}
+}
+
+// Stream is a Reply with stream frames: a "headers" frame with status,
+// then one "chunk" frame per chunk.
+func Stream(status int, chunks ...string) Reply {
+	frames := []server.StreamFrame{{Type: "headers", Status: status}}
+	for _, c := range chunks {
+		frames = append(frames, server.StreamFrame{Type: "chunk", Data: c})
+	}
+	return Reply{Frames: frames}
+}
+
+// Relay is a Reply whose body follows its head in chunks, the way a worker
+// answers a request with RelayOver set when the body is large.
+func Relay(status int, chunks ...string) Reply {
+	frames := make([]server.StreamFrame, 0, len(chunks))
+	for _, c := range chunks {
+		frames = append(frames, server.StreamFrame{Type: "chunk", Data: c})
+	}
+	return Reply{Response: &server.ResponsePayload{Status: status, Relay: true}, Frames: frames}
+}
+
+// Echo answers every request with 200, an X-Worker header and the body
+// label + ":" + path, so tests can tell which worker served what.
+func Echo(label string) Handler {
+	return func(req *server.RequestPayload) Reply {
+		return Reply{Response: &server.ResponsePayload{
+			Status:  200,
+			Headers: map[string]string{"X-Worker": label},
+			Body:    []byte(label + ":" + req.Path),
+		}}
+	}
+}
+
+// NewFakeWorker returns a worker that h answers over in-memory pipes. It
+// has no request limit or timeout; put it in a pool to change those.
+func NewFakeWorker(t testing.TB, h Handler) *server.Worker {
+	t.Helper()
+	stdinR, stdinW := io.Pipe()
+	stdoutR, stdoutW := io.Pipe()
+	t.Cleanup(func() {
+		_ = stdinW.Close()
+		_ = stdoutR.Close()
+	})
+	go func() {
+		defer stdoutW.Close()
+		defer stdinR.Close()
+		serve(stdinR, stdoutW, h)
+	}()
+	return server.NewWorkerWithTransport(server.NewStreamTransport(stdinW, stdoutR, nil), 0, 0)
+}
+
+// NewFakePool returns a pool of n fake workers answered by h.
+func NewFakePool(t testing.TB, n int, h Handler) *server.WorkerPool {
+	t.Helper()
+	p := new(server.WorkerPool)
+	_ = p.ScaleTo(n, func() (*server.Worker, error) { return NewFakeWorker(t, h), nil })
+	return p
+}
+
+// Listen serves h to every connection on a new loopback TCP port and
+// returns its address. The listener is closed when the test ends.
+func Listen(t testing.TB, h Handler) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				serve(conn, conn, h)
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// serve speaks the worker side of the default (JSON) codec until r ends or
+// h hangs up.
+func serve(r io.Reader, w io.Writer, h Handler) {
+	hdr := make([]byte, 4)
+	for {
+		if _, err := io.ReadFull(r, hdr); err != nil {
+			return
+		}
+		body := make([]byte, binary.BigEndian.Uint32(hdr))
+		if _, err := io.ReadFull(r, body); err != nil {
+			return
+		}
+		var req server.RequestPayload
+		if err := json.Unmarshal(body, &req); err != nil {
+			return
+		}
+
+		reply := h(&req)
+		if reply.Delay > 0 {
+			time.Sleep(reply.Delay)
+		}
+		if reply.HangUp {
+			return
+		}
+
+		var msgs []any
+		switch {
+		case reply.Response != nil && reply.Response.Relay:
+			resp := *reply.Response
+			if resp.ID == "" {
+				resp.ID = req.ID
+			}
+			msgs = append(msgs, &resp)
+			for _, f := range reply.Frames {
+				msgs = append(msgs, f)
+			}
+			msgs = append(msgs, server.StreamFrame{Type: "end"})
+		case reply.Frames != nil:
+			for _, f := range reply.Frames {
+				msgs = append(msgs, f)
+			}
+			if last := reply.Frames[len(reply.Frames)-1].Type; last != "end" && last != "error" {
+				msgs = append(msgs, server.StreamFrame{Type: "end"})
+			}
+		default:
+			resp := reply.Response
+			if resp == nil {
+				resp = &server.ResponsePayload{Status: 200}
+			}
+			if resp.ID == "" {
+				resp.ID = req.ID
+			}
+			msgs = append(msgs, resp)
+		}
+		for _, m := range msgs {
+			if err := write(w, m); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func write(w io.Writer, msg any) error {
+	raw, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	buf := binary.BigEndian.AppendUint32(make([]byte, 0, 4+len(raw)), uint32(len(raw)))
+	_, err = w.Write(append(buf, raw...))
+	return err
+}
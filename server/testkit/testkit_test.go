@@ -0,0 +1,62 @@
+package testkit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go-php/server"
+)
+
+func TestFakePool(t *testing.T) {
+	p := NewFakePool(t, 2, Echo("fake"))
+	resp, err := p.Dispatch(&server.RequestPayload{ID: "r1", Method: "GET", Path: "/hello"})
+	if err != nil {
+		t.Fatalf("Dispatch: %v", err)
+	}
+	if resp.ID != "r1" || resp.Status != http.StatusOK || string(resp.Body) != "fake:/hello" || resp.Headers["X-Worker"] != "fake" {
+		t.Errorf("response = %+v", resp)
+	}
+	if st := p.Stats(); st.Workers != 2 {
+		t.Errorf("pool has %d workers, want 2", st.Workers)
+	}
+}
+
+func TestFakeWorkerStream(t *testing.T) {
+	p := NewFakePool(t, 1, func(req *server.RequestPayload) Reply {
+		return Stream(http.StatusAccepted, "one ", "two")
+	})
+	rr := httptest.NewRecorder()
+	if err := p.Stream(&server.RequestPayload{ID: "s1", Method: "GET", Path: "/events"}, rr); err != nil {
+		t.Fatalf("Stream: %v", err)
+	}
+	if rr.Code != http.StatusAccepted || rr.Body.String() != "one two" {
+		t.Errorf("got %d %q", rr.Code, rr.Body)
+	}
+}
+
+func TestFakeWorkerHangUp(t *testing.T) {
+	w := NewFakeWorker(t, func(req *server.RequestPayload) Reply { return Reply{HangUp: true} })
+	if _, err := w.Handle(&server.RequestPayload{ID: "h1", Method: "GET", Path: "/"}); err == nil {
+		t.Fatal("Handle succeeded against a worker that hung up")
+	}
+}
+
+func TestListen(t *testing.T) {
+	addr := Listen(t, func(req *server.RequestPayload) Reply {
+		return Respond(http.StatusCreated, req.Method+" "+req.Path)
+	})
+	srv, err := server.New(server.WithPools(server.PoolConfig{Name: server.FastPool, Workers: 1, Transport: server.TransportTCP, Address: addr}))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	t.Cleanup(srv.DrainWorkers)
+
+	resp, err := srv.Dispatch(&server.RequestPayload{ID: "l1", Method: "POST", Path: "/orders"})
+	if err != nil {
+		t.Fatalf("Dispatch: %v", err)
+	}
+	if resp.Status != http.StatusCreated || string(resp.Body) != "POST /orders" {
+		t.Errorf("response = %+v", resp)
+	}
+}
@@ -0,0 +1,234 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// WorkerTransport carries requests to a PHP worker and its replies back.
+// Starting and stopping the PHP side is the Worker's job; a transport only
+// moves payloads over an established connection, one request at a time.
+type WorkerTransport interface {
+	// Send writes one request.
+	Send(req *RequestPayload) error
+	// Recv reads the buffered response to the last request.
+	Recv() (*ResponsePayload, error)
+	// RecvFrame reads the next frame of a streamed response.
+	RecvFrame() (*StreamFrame, error)
+	// Close tears down the connection.
+	Close() error
+}
+
+// Codec is the wire format spoken over a byte-stream transport.
+type Codec interface {
+	WriteRequest(w io.Writer, req *RequestPayload) error
+	ReadResponse(r io.Reader) (*ResponsePayload, error)
+	ReadFrame(r io.Reader) (*StreamFrame, error)
+}
+
+// maxMessageSize bounds a single response or stream frame.
+const maxMessageSize = 10 * 1024 * 1024
+
+// frameBufs recycles the buffers messages are encoded into and read into,
+// so a busy worker doesn't allocate a frame-sized slice per message. The
+// decoded values copy what they keep, so a buffer is free again as soon as
+// json has run.
+var frameBufs = sync.Pool{New: func() any { return new(bytes.Buffer) }}
+
+// maxPooledFrame keeps the occasional huge response from pinning its buffer.
+const maxPooledFrame = 1 << 20
+
+func getFrameBuf() *bytes.Buffer {
+	buf := frameBufs.Get().(*bytes.Buffer)
+	buf.Reset()
+	return buf
+}
+
+func putFrameBuf(buf *bytes.Buffer) {
+	if buf.Cap() <= maxPooledFrame {
+		frameBufs.Put(buf)
+	}
+}
+
+// JSONCodec is the default wire format: a 4-byte big-endian length followed
+// by that many bytes of JSON.
+type JSONCodec struct {
+	// TextBodies sends every request body as a "body" string, replacing
+	// bytes that aren't UTF-8 instead of switching to "body_base64", for
+	// workers written before body_base64 existed.
+	TextBodies bool
+}
+
+func (c JSONCodec) WriteRequest(w io.Writer, req *RequestPayload) error {
+	if c.TextBodies && !utf8.Valid(req.Body) {
+		cp := *req
+		cp.Body = bytes.ToValidUTF8(req.Body, []byte("\uFFFD"))
+		req = &cp
+	}
+
+	buf := getFrameBuf()
+	defer putFrameBuf(buf)
+
+	// room for the length, filled in once the JSON is there
+	buf.Write([]byte{0, 0, 0, 0})
+	if err := json.NewEncoder(buf).Encode(req); err != nil {
+		return err
+	}
+	frame := bytes.TrimSuffix(buf.Bytes(), []byte("\n")) // Encode's, Marshal has none
+	binary.BigEndian.PutUint32(frame, uint32(len(frame)-4))
+
+	_, err := w.Write(frame)
+	return err
+}
+
+func (JSONCodec) ReadResponse(r io.Reader) (*ResponsePayload, error) {
+	var resp ResponsePayload
+	if err := readMessage(r, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+func (JSONCodec) ReadFrame(r io.Reader) (*StreamFrame, error) {
+	var frame StreamFrame
+	if err := readMessage(r, &frame); err != nil {
+		return nil, err
+	}
+	return &frame, nil
+}
+
+func readMessage(r io.Reader, v any) error {
+	buf := getFrameBuf()
+	defer putFrameBuf(buf)
+
+	hdr := frameSlice(buf, 4)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return err
+	}
+
+	n := binary.BigEndian.Uint32(hdr)
+	if n == 0 {
+		return protocolError("empty message")
+	}
+	if n > maxMessageSize {
+		return &workerError{kind: ErrFrameTooLarge, err: fmt.Errorf("%d byte message, limit is %d", n, maxMessageSize)}
+	}
+
+	body := frameSlice(buf, int(n))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return &workerError{kind: ErrProtocol, err: err}
+	}
+	return nil
+}
+
+// frameSlice returns n bytes of buf's spare capacity to read into.
+func frameSlice(buf *bytes.Buffer, n int) []byte {
+	buf.Grow(n)
+	return buf.AvailableBuffer()[:n]
+}
+
+// streamTransport runs a Codec over a writer/reader pair: the stdin/stdout
+// pipes of a child process, or both halves of a socket. Reads go through
+// one bufio.Reader for the life of the connection, so a length header and
+// its body usually come from a single read.
+type streamTransport struct {
+	w     io.WriteCloser
+	r     io.ReadCloser
+	br    *bufio.Reader
+	codec Codec
+}
+
+// NewStreamTransport speaks codec (JSONCodec if nil) over w and r.
+func NewStreamTransport(w io.WriteCloser, r io.ReadCloser, codec Codec) WorkerTransport {
+	if codec == nil {
+		codec = JSONCodec{}
+	}
+	return &streamTransport{w: w, r: r, br: bufio.NewReaderSize(r, 32<<10), codec: codec}
+}
+
+func (t *streamTransport) Send(req *RequestPayload) error {
+	return t.codec.WriteRequest(t.w, req)
+}
+
+func (t *streamTransport) Recv() (*ResponsePayload, error) {
+	return t.codec.ReadResponse(t.br)
+}
+
+func (t *streamTransport) RecvFrame() (*StreamFrame, error) {
+	return t.codec.ReadFrame(t.br)
+}
+
+func (t *streamTransport) Close() error {
+	werr := t.w.Close()
+	rerr := t.r.Close()
+	if werr != nil {
+		return werr
+	}
+	if rerr != nil && !errors.Is(rerr, net.ErrClosed) {
+		return rerr
+	}
+	return nil
+}
+
+// Transport kinds accepted in PoolConfig.Transport.
+const (
+	TransportStdio   = "stdio"   // spawn php and talk over its stdin/stdout (default)
+	TransportUnix    = "unix"    // connect to an external worker on a unix socket
+	TransportTCP     = "tcp"     // connect to an external worker over TCP
+	TransportFastCGI = "fastcgi" // send requests to a FastCGI server such as php-fpm
+)
+
+// codec returns the wire format for the pool's stdio and socket workers.
+func (c PoolConfig) codec() Codec {
+	return JSONCodec{TextBodies: c.TextBodies}
+}
+
+// dialTimeout bounds connecting to socket and FastCGI workers.
+const dialTimeout = 5 * time.Second
+
+// transportDialer returns a function that connects a worker to an external
+// backend, or nil for the default stdio transport (spawned process).
+func (c PoolConfig) transportDialer(scriptFilename string) (func() (WorkerTransport, error), error) {
+	switch c.Transport {
+	case "", TransportStdio:
+		return nil, nil
+	case TransportUnix, TransportTCP:
+		if c.Address == "" {
+			return nil, fmt.Errorf("%s transport needs an address", c.Transport)
+		}
+		network := c.Transport
+		return func() (WorkerTransport, error) {
+			conn, err := net.DialTimeout(network, c.Address, dialTimeout)
+			if err != nil {
+				return nil, err
+			}
+			return NewStreamTransport(conn, conn, c.codec()), nil
+		}, nil
+	case TransportFastCGI:
+		if c.Address == "" {
+			return nil, fmt.Errorf("fastcgi transport needs an address")
+		}
+		network, addr := fastCGIAddress(c.Address)
+		return func() (WorkerTransport, error) {
+			conn, err := net.DialTimeout(network, addr, dialTimeout)
+			if err != nil {
+				return nil, err
+			}
+			return newFastCGITransport(conn, scriptFilename, c.Logger), nil
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown transport %q", c.Transport)
+	}
+}
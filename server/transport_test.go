@@ -0,0 +1,506 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// scriptedTransport answers every request from a func, no pipes involved.
+type scriptedTransport struct {
+	last   *RequestPayload
+	reply  func(req *RequestPayload) (*ResponsePayload, error)
+	frames []*StreamFrame
+	closed bool
+}
+
+func (t *scriptedTransport) Send(req *RequestPayload) error {
+	t.last = req
+	return nil
+}
+
+func (t *scriptedTransport) Recv() (*ResponsePayload, error) {
+	return t.reply(t.last)
+}
+
+func (t *scriptedTransport) RecvFrame() (*StreamFrame, error) {
+	if len(t.frames) == 0 {
+		return nil, io.EOF
+	}
+	f := t.frames[0]
+	t.frames = t.frames[1:]
+	return f, nil
+}
+
+func (t *scriptedTransport) Close() error {
+	t.closed = true
+	return nil
+}
+
+func TestWorkerWithScriptedTransport(t *testing.T) {
+	tr := &scriptedTransport{reply: func(req *RequestPayload) (*ResponsePayload, error) {
+		return &ResponsePayload{ID: req.ID, Status: 201, Body: []byte("hi " + req.Path)}, nil
+	}}
+	w := NewWorkerWithTransport(tr, 0, time.Second)
+
+	resp, err := w.Handle(&RequestPayload{ID: "1", Method: "GET", Path: "/x"})
+	if err != nil || resp.Status != 201 || string(resp.Body) != "hi /x" {
+		t.Fatalf("Handle = %+v, %v", resp, err)
+	}
+
+	tr.frames = []*StreamFrame{
+		{Type: "headers", Status: 200, Data: "a"},
+		{Type: "chunk", Data: "b"},
+		{Type: "end"},
+	}
+	rr := httptest.NewRecorder()
+	if err := w.Stream(&RequestPayload{ID: "2", Path: "/s"}, rr); err != nil {
+		t.Fatalf("Stream: %v", err)
+	}
+	if rr.Body.String() != "ab" {
+		t.Fatalf("streamed body = %q", rr.Body.String())
+	}
+}
+
+func TestWorkerWithTransportCannotReconnect(t *testing.T) {
+	tr := &scriptedTransport{reply: func(*RequestPayload) (*ResponsePayload, error) {
+		return nil, io.EOF // looks like the worker went away
+	}}
+	w := NewWorkerWithTransport(tr, 0, time.Second)
+
+	if _, err := w.Handle(&RequestPayload{ID: "1"}); err == nil || !strings.Contains(err.Error(), "cannot be reconnected") {
+		t.Fatalf("expected reconnect error, got %v", err)
+	}
+	if !tr.closed {
+		t.Fatalf("expected broken transport to be closed")
+	}
+}
+
+func TestTCPTransportDial(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+
+	// External worker: echoes the path using the default codec.
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			var req RequestPayload
+			if err := readMessage(conn, &req); err != nil {
+				return
+			}
+			raw, _ := json.Marshal(&ResponsePayload{ID: req.ID, Status: 200, Body: []byte("tcp:" + req.Path)})
+			hdr := make([]byte, 4)
+			binary.BigEndian.PutUint32(hdr, uint32(len(raw)))
+			if _, err := conn.Write(append(hdr, raw...)); err != nil {
+				return
+			}
+		}
+	}()
+
+	w, err := PoolConfig{Transport: TransportTCP, Address: ln.Addr().String()}.newWorker()
+	if err != nil {
+		t.Fatalf("newWorker: %v", err)
+	}
+	if err := w.spawn(); err != nil {
+		t.Fatalf("spawn: %v", err)
+	}
+	defer w.kill()
+
+	for i := 0; i < 2; i++ {
+		resp, err := w.Handle(&RequestPayload{ID: "1", Method: "GET", Path: "/over-tcp"})
+		if err != nil || string(resp.Body) != "tcp:/over-tcp" {
+			t.Fatalf("Handle = %+v, %v", resp, err)
+		}
+	}
+}
+
+func TestPoolConfigTransportDialer(t *testing.T) {
+	if d, err := (PoolConfig{}).transportDialer(""); d != nil || err != nil {
+		t.Fatalf("stdio should spawn a process, got dialer=%v err=%v", d != nil, err)
+	}
+	if _, err := (PoolConfig{Transport: TransportTCP}).transportDialer(""); err == nil {
+		t.Fatalf("expected missing address error")
+	}
+	if _, err := (PoolConfig{Transport: "carrier-pigeon", Address: "x"}).transportDialer(""); err == nil {
+		t.Fatalf("expected unknown transport error")
+	}
+	if network, addr := fastCGIAddress("unix:/run/php-fpm.sock"); network != "unix" || addr != "/run/php-fpm.sock" {
+		t.Fatalf("fastCGIAddress = %s %s", network, addr)
+	}
+}
+
+// fakeFPM reads one FastCGI request from conn and answers with stdout.
+func fakeFPM(t *testing.T, conn net.Conn, stdout string) map[string]string {
+	t.Helper()
+	r := bufio.NewReader(conn)
+	params := map[string]string{}
+	var paramBytes []byte
+
+	for {
+		var hdr [8]byte
+		if _, err := io.ReadFull(r, hdr[:]); err != nil {
+			t.Errorf("read header: %v", err)
+			return nil
+		}
+		n := int(binary.BigEndian.Uint16(hdr[4:6]))
+		content := make([]byte, n+int(hdr[6]))
+		if _, err := io.ReadFull(r, content); err != nil {
+			t.Errorf("read content: %v", err)
+			return nil
+		}
+		content = content[:n]
+
+		if hdr[1] == fcgiParams {
+			paramBytes = append(paramBytes, content...)
+		}
+		if hdr[1] == fcgiStdin && n == 0 {
+			break
+		}
+	}
+
+	for len(paramBytes) > 0 {
+		readLen := func() int {
+			if paramBytes[0] < 128 {
+				n := int(paramBytes[0])
+				paramBytes = paramBytes[1:]
+				return n
+			}
+			n := int(binary.BigEndian.Uint32(paramBytes) &^ (1 << 31))
+			paramBytes = paramBytes[4:]
+			return n
+		}
+		kl, vl := readLen(), readLen()
+		params[string(paramBytes[:kl])] = string(paramBytes[kl : kl+vl])
+		paramBytes = paramBytes[kl+vl:]
+	}
+
+	var out bytes.Buffer
+	writeRecord(&out, fcgiStderr, []byte("PHP Notice: hello"))
+	// split stdout across two records to exercise reassembly
+	half := len(stdout) / 2
+	writeRecord(&out, fcgiStdout, []byte(stdout[:half]))
+	writeRecord(&out, fcgiStdout, []byte(stdout[half:]))
+	writeRecord(&out, fcgiStdout, nil)
+	writeRecord(&out, fcgiEndRequest, make([]byte, 8))
+	if _, err := conn.Write(out.Bytes()); err != nil {
+		t.Errorf("write: %v", err)
+	}
+	return params
+}
+
+func TestFastCGITransportRoundTrip(t *testing.T) {
+	client, srv := net.Pipe()
+	defer client.Close()
+	defer srv.Close()
+
+	tr := NewFastCGITransport(client, "/var/www/public/index.php")
+	req := &RequestPayload{
+		ID: "1", Method: "post", Path: "/users?page=2", Body: []byte("name=x"),
+		Headers: map[string][]string{
+			"Host":            {"example.com:8080"},
+			"Content-Type":    {"application/x-www-form-urlencoded"},
+			"X-Forwarded-For": {"10.0.0.1, 192.0.2.7"},
+			"X-Custom-Thing":  {"yes"},
+		},
+	}
+
+	paramsCh := make(chan map[string]string, 1)
+	go func() {
+		paramsCh <- fakeFPM(t, srv, "Status: 201 Created\r\nContent-Type: text/plain\r\nX-A: 1\r\nX-A: 2\r\nSet-Cookie: a=1; Path=/\r\nSet-Cookie: b=2, c\r\n\r\ncreated")
+	}()
+
+	if err := tr.Send(req); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	resp, err := tr.Recv()
+	if err != nil {
+		t.Fatalf("Recv: %v", err)
+	}
+	if resp.Status != 201 || string(resp.Body) != "created" || resp.Headers["Content-Type"] != "text/plain" || resp.Headers["X-A"] != "1, 2" {
+		t.Fatalf("unexpected response: %+v", resp)
+	}
+	if len(resp.Cookies) != 2 || resp.Cookies[1] != "b=2, c" || resp.Headers["Set-Cookie"] != "" {
+		t.Fatalf("Set-Cookie lines must stay separate: %+v", resp)
+	}
+
+	params := <-paramsCh
+	want := map[string]string{
+		"REQUEST_METHOD":      "POST",
+		"REQUEST_URI":         "/users?page=2",
+		"QUERY_STRING":        "page=2",
+		"SCRIPT_FILENAME":     "/var/www/public/index.php",
+		"SCRIPT_NAME":         "/index.php",
+		"DOCUMENT_ROOT":       "/var/www/public",
+		"CONTENT_LENGTH":      "6",
+		"CONTENT_TYPE":        "application/x-www-form-urlencoded",
+		"SERVER_NAME":         "example.com",
+		"SERVER_PORT":         "8080",
+		"REMOTE_ADDR":         "192.0.2.7",
+		"HTTP_X_CUSTOM_THING": "yes",
+	}
+	for k, v := range want {
+		if params[k] != v {
+			t.Fatalf("param %s = %q, want %q", k, params[k], v)
+		}
+	}
+}
+
+func TestCGIParamsPreferServerParams(t *testing.T) {
+	req := &RequestPayload{
+		Method: "GET", Path: "/",
+		Headers: map[string][]string{
+			"Host":            {"example.com"},
+			"X-Forwarded-For": {"10.0.0.1, 192.0.2.7"},
+		},
+		ServerParams: map[string]string{
+			"REMOTE_ADDR":   "192.0.2.7",
+			"REMOTE_PORT":   "51234",
+			"HTTPS":         "on",
+			"SERVER_PORT":   "443",
+			"DOCUMENT_ROOT": "/srv/app/public",
+		},
+	}
+
+	params := cgiParams(req, "/var/www/public/index.php")
+	if params["REMOTE_PORT"] != "51234" || params["HTTPS"] != "on" || params["SERVER_PORT"] != "443" {
+		t.Fatalf("server params not applied: %v", params)
+	}
+	if params["DOCUMENT_ROOT"] != "/var/www/public" {
+		t.Fatalf("DOCUMENT_ROOT = %q, want php-fpm's root", params["DOCUMENT_ROOT"])
+	}
+}
+
+func TestCGIParamsDropUnsafeHeaders(t *testing.T) {
+	req := &RequestPayload{
+		Method: "GET", Path: "/",
+		Headers: map[string][]string{
+			"Proxy":   {"http://evil.example.com:8080"},
+			"X-User":  {"alice"},
+			"X_User":  {"admin"},
+			"X-Trace": {"1"},
+		},
+	}
+
+	params := cgiParams(req, "/var/www/public/index.php")
+	if v, ok := params["HTTP_PROXY"]; ok {
+		t.Fatalf("HTTP_PROXY = %q, want the Proxy header dropped", v)
+	}
+	if params["HTTP_X_USER"] != "alice" || params["HTTP_X_TRACE"] != "1" {
+		t.Fatalf("params = %v, want X-User from the dashed header only", params)
+	}
+}
+
+func TestFastCGITransportFrames(t *testing.T) {
+	client, srv := net.Pipe()
+	defer client.Close()
+	defer srv.Close()
+
+	tr := NewFastCGITransport(client, "/app/index.php")
+	go fakeFPM(t, srv, "Content-Type: text/html\n\n<p>streamed</p>")
+
+	if err := tr.Send(&RequestPayload{ID: "1", Method: "GET", Path: "/"}); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	var types []string
+	var body strings.Builder
+	for {
+		f, err := tr.RecvFrame()
+		if err != nil {
+			t.Fatalf("RecvFrame: %v", err)
+		}
+		types = append(types, f.Type)
+		body.WriteString(f.Data)
+		if f.Type == "headers" && f.Headers["Content-Type"][0] != "text/html" {
+			t.Fatalf("unexpected headers frame: %+v", f)
+		}
+		if f.Type == "end" {
+			break
+		}
+	}
+	if types[0] != "headers" || body.String() != "<p>streamed</p>" {
+		t.Fatalf("frames = %v, body = %q", types, body.String())
+	}
+}
+
+// replayConn answers every write with the bytes in r.
+type replayConn struct{ r io.Reader }
+
+func (c replayConn) Read(p []byte) (int, error)  { return c.r.Read(p) }
+func (c replayConn) Write(p []byte) (int, error) { return len(p), nil }
+func (c replayConn) Close() error                { return nil }
+
+func TestFastCGITransportLimitsResponseSize(t *testing.T) {
+	var out bytes.Buffer
+	chunk := bytes.Repeat([]byte("x"), fcgiMaxContent)
+	for out.Len() <= maxMessageSize+fcgiMaxContent {
+		writeRecord(&out, fcgiStdout, chunk)
+	}
+	writeRecord(&out, fcgiEndRequest, make([]byte, 8))
+
+	tr := NewFastCGITransport(replayConn{bytes.NewReader(out.Bytes())}, "/app/index.php")
+	if _, err := tr.Recv(); !errors.Is(err, ErrFrameTooLarge) {
+		t.Fatalf("Recv = %v, want ErrFrameTooLarge", err)
+	}
+
+	// A streamed response whose headers never end is cut off too.
+	tr = NewFastCGITransport(replayConn{bytes.NewReader(out.Bytes())}, "/app/index.php")
+	if _, err := tr.RecvFrame(); !errors.Is(err, ErrFrameTooLarge) {
+		t.Fatalf("RecvFrame = %v, want ErrFrameTooLarge", err)
+	}
+}
+
+func TestJSONCodecFramesWithPooledBuffers(t *testing.T) {
+	var wire bytes.Buffer
+	for _, path := range []string{"/a", "/b?x=<y>"} {
+		if err := (JSONCodec{}).WriteRequest(&wire, &RequestPayload{ID: path, Method: "GET", Path: path}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the frame is exactly what json.Marshal gives, with no trailing newline
+	want, _ := json.Marshal(&RequestPayload{ID: "/a", Method: "GET", Path: "/a"})
+	if n := binary.BigEndian.Uint32(wire.Bytes()); int(n) != len(want) || !bytes.Equal(wire.Bytes()[4:4+n], want) {
+		t.Fatalf("frame = %q, want %q", wire.Bytes()[4:4+n], want)
+	}
+
+	// both messages come out of one buffered reader in order
+	tr := NewStreamTransport(nopWriteCloser{Writer: io.Discard}, io.NopCloser(&wire), nil).(*streamTransport)
+	for _, path := range []string{"/a", "/b?x=<y>"} {
+		var got RequestPayload
+		if err := readMessage(tr.br, &got); err != nil {
+			t.Fatal(err)
+		}
+		if got.Path != path {
+			t.Fatalf("path = %q, want %q", got.Path, path)
+		}
+	}
+}
+
+func TestBenchTransportRoundTrips(t *testing.T) {
+	for _, transport := range BenchTransports {
+		t.Run(transport, func(t *testing.T) {
+			res, err := BenchTransport(transport, "json", 40<<10, 5)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.Requests != 5 || res.Elapsed <= 0 {
+				t.Fatalf("result = %+v", res)
+			}
+		})
+	}
+	if _, err := BenchTransport(TransportStdio, "msgpack", 10, 1); err == nil {
+		t.Fatal("unknown codec accepted")
+	}
+}
+
+func BenchmarkTransport(b *testing.B) {
+	for _, transport := range BenchTransports {
+		for _, size := range []int{1 << 10, 32 << 10, 1 << 20} {
+			b.Run(fmt.Sprintf("%s/%dKiB", transport, size>>10), func(b *testing.B) {
+				t, closeFn, err := newBenchPair(transport, "json")
+				if err != nil {
+					b.Fatal(err)
+				}
+				defer closeFn()
+				req := BenchPayload(size)
+				b.SetBytes(int64(size))
+				b.ReportAllocs()
+				b.ResetTimer()
+				for n := 0; n < b.N; n++ {
+					if err := benchRoundTrip(t, req); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkJSONCodec(b *testing.B) {
+	req := BenchPayload(32 << 10)
+	var wire bytes.Buffer
+	if err := (JSONCodec{}).WriteRequest(&wire, req); err != nil {
+		b.Fatal(err)
+	}
+	frame := wire.Bytes()
+
+	b.Run("write", func(b *testing.B) {
+		b.ReportAllocs()
+		for n := 0; n < b.N; n++ {
+			if err := (JSONCodec{}).WriteRequest(io.Discard, req); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("read", func(b *testing.B) {
+		b.ReportAllocs()
+		r := bytes.NewReader(frame)
+		for n := 0; n < b.N; n++ {
+			r.Reset(frame)
+			var got RequestPayload
+			if err := readMessage(r, &got); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestJSONCodecBinaryBodies(t *testing.T) {
+	png := []byte{0x89, 'P', 'N', 'G', 0xff, 0x00}
+
+	var wire bytes.Buffer
+	if err := (JSONCodec{}).WriteRequest(&wire, &RequestPayload{ID: "1", Body: png}); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(wire.Bytes(), []byte(`"body_base64":"iVBOR/8A"`)) {
+		t.Fatalf("binary body not sent as body_base64: %s", wire.Bytes()[4:])
+	}
+	var req RequestPayload
+	if err := readMessage(&wire, &req); err != nil || !bytes.Equal(req.Body, png) {
+		t.Fatalf("round trip = %q, %v", req.Body, err)
+	}
+
+	// text stays a string, so workers that never heard of body_base64 work
+	wire.Reset()
+	if err := (JSONCodec{}).WriteRequest(&wire, &RequestPayload{ID: "2", Body: []byte("a=1")}); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(wire.Bytes(), []byte(`"body":"a=1"`)) || bytes.Contains(wire.Bytes(), []byte("body_base64")) {
+		t.Fatalf("text body: %s", wire.Bytes()[4:])
+	}
+
+	// and TextBodies forces it, at the cost of the invalid bytes
+	wire.Reset()
+	if err := (JSONCodec{TextBodies: true}).WriteRequest(&wire, &RequestPayload{ID: "3", Body: png}); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(wire.Bytes(), []byte("body_base64")) || !bytes.Contains(wire.Bytes(), []byte(`"body":"�PNG�\u0000"`)) {
+		t.Fatalf("TextBodies: %s", wire.Bytes()[4:])
+	}
+
+	resp, err := (JSONCodec{}).ReadResponse(strings.NewReader(frameJSON(`{"id":"4","status":200,"body_base64":"/9j/"}`)))
+	if err != nil || !bytes.Equal(resp.Body, []byte{0xff, 0xd8, 0xff}) {
+		t.Fatalf("response body_base64 = %q, %v", resp.Body, err)
+	}
+}
+
+func frameJSON(s string) string {
+	return string(binary.BigEndian.AppendUint32(nil, uint32(len(s)))) + s
+}
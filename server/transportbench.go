@@ -0,0 +1,212 @@
+package server
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/fcgi"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// BenchTransports are the transports BenchTransport measures. "stdio" uses
+// a pair of OS pipes, as a spawned worker does.
+var BenchTransports = []string{TransportStdio, TransportUnix, TransportTCP, TransportFastCGI}
+
+// BenchCodecs are the wire formats BenchTransport measures on the
+// length-prefixed transports (FastCGI has its own framing).
+var BenchCodecs = []string{"json"}
+
+// TransportBenchResult is one BenchTransport run.
+type TransportBenchResult struct {
+	Transport    string        `json:"transport"`
+	Codec        string        `json:"codec"`
+	PayloadBytes int           `json:"payload_bytes"`
+	Requests     int           `json:"requests"`
+	Elapsed      time.Duration `json:"elapsed_ns"`
+}
+
+// PerRequest is the mean round trip.
+func (r TransportBenchResult) PerRequest() time.Duration {
+	return r.Elapsed / time.Duration(max(r.Requests, 1))
+}
+
+// RequestsPerSecond is the sequential throughput of one worker connection.
+func (r TransportBenchResult) RequestsPerSecond() float64 {
+	return float64(r.Requests) / r.Elapsed.Seconds()
+}
+
+// BenchTransport sends requests round trips with a payloadBytes body over
+// transport to an in-process peer that echoes the body back. Both ends run
+// in this process, so the figures are the Go server's own cost plus the
+// peer's framing, without PHP.
+func BenchTransport(transport, codec string, payloadBytes, requests int) (TransportBenchResult, error) {
+	res := TransportBenchResult{Transport: transport, Codec: codec, PayloadBytes: payloadBytes, Requests: requests}
+	if transport == TransportFastCGI {
+		res.Codec = "fastcgi"
+	}
+	t, closeFn, err := newBenchPair(transport, codec)
+	if err != nil {
+		return res, err
+	}
+	defer closeFn()
+
+	req := BenchPayload(payloadBytes)
+	start := time.Now()
+	for i := range requests {
+		req.ID = strconv.Itoa(i)
+		if err := benchRoundTrip(t, req); err != nil {
+			return res, err
+		}
+	}
+	res.Elapsed = time.Since(start)
+	return res, nil
+}
+
+// BenchPayload is a request with an HTML-like body of n bytes, markup and
+// all, since escaping it is part of what a codec costs.
+func BenchPayload(n int) *RequestPayload {
+	const chunk = `<li class="item"><a href="/items?id=42&amp;page=2">Item</a></li>` + "\n"
+	body := []byte(strings.Repeat(chunk, n/len(chunk)+1)[:n])
+	return &RequestPayload{
+		Method:  "POST",
+		Path:    "/bench",
+		Headers: map[string][]string{"Content-Type": {"text/html"}, "User-Agent": {"bench"}},
+		Body:    body,
+	}
+}
+
+func benchRoundTrip(t WorkerTransport, req *RequestPayload) error {
+	if err := t.Send(req); err != nil {
+		return err
+	}
+	resp, err := t.Recv()
+	if err != nil {
+		return err
+	}
+	if len(resp.Body) != len(req.Body) {
+		return fmt.Errorf("echoed %d bytes, sent %d", len(resp.Body), len(req.Body))
+	}
+	return nil
+}
+
+// newBenchPair connects a client transport to an echo peer.
+func newBenchPair(transport, codec string) (WorkerTransport, func(), error) {
+	if transport != TransportFastCGI && codec != "json" {
+		return nil, nil, fmt.Errorf("unknown codec %q", codec)
+	}
+
+	switch transport {
+	case TransportStdio:
+		stdinR, stdinW, err := os.Pipe()
+		if err != nil {
+			return nil, nil, err
+		}
+		stdoutR, stdoutW, err := os.Pipe()
+		if err != nil {
+			stdinR.Close()
+			stdinW.Close()
+			return nil, nil, err
+		}
+		go func() {
+			defer stdoutW.Close()
+			echoJSON(stdinR, stdoutW)
+		}()
+		t := NewStreamTransport(stdinW, stdoutR, nil)
+		return t, func() { t.Close(); stdinR.Close() }, nil
+
+	case TransportUnix, TransportTCP, TransportFastCGI:
+		network, address, cleanup, err := benchListenAddr(transport)
+		if err != nil {
+			return nil, nil, err
+		}
+		ln, err := net.Listen(network, address)
+		if err != nil {
+			cleanup()
+			return nil, nil, err
+		}
+		if transport == TransportFastCGI {
+			go fcgi.Serve(ln, http.HandlerFunc(echoFastCGI))
+		} else {
+			go func() {
+				for {
+					conn, err := ln.Accept()
+					if err != nil {
+						return
+					}
+					go func() {
+						defer conn.Close()
+						echoJSON(conn, conn)
+					}()
+				}
+			}()
+		}
+
+		conn, err := net.Dial(network, ln.Addr().String())
+		if err != nil {
+			ln.Close()
+			cleanup()
+			return nil, nil, err
+		}
+		var t WorkerTransport
+		if transport == TransportFastCGI {
+			t = NewFastCGITransport(conn, "/bench/index.php")
+		} else {
+			t = NewStreamTransport(conn, conn, nil)
+		}
+		return t, func() { t.Close(); ln.Close(); cleanup() }, nil
+	}
+	return nil, nil, fmt.Errorf("unknown transport %q", transport)
+}
+
+// benchListenAddr picks where a socket transport's peer listens: a fresh
+// unix socket (FastCGI too, as php-fpm is usually reached) or a loopback
+// TCP port.
+func benchListenAddr(transport string) (network, address string, cleanup func(), err error) {
+	if transport == TransportTCP {
+		return "tcp", "127.0.0.1:0", func() {}, nil
+	}
+	dir, err := os.MkdirTemp("", "bench-transport")
+	if err != nil {
+		return "", "", nil, err
+	}
+	return "unix", filepath.Join(dir, "peer.sock"), func() { os.RemoveAll(dir) }, nil
+}
+
+// echoJSON is the worker side of JSONCodec, answering each request with
+// its own body.
+func echoJSON(r io.Reader, w io.Writer) {
+	for {
+		var req RequestPayload
+		if err := readMessage(r, &req); err != nil {
+			return
+		}
+		raw, err := json.Marshal(&ResponsePayload{ID: req.ID, Status: http.StatusOK, Headers: map[string]string{"Content-Type": "text/html"}, Body: req.Body})
+		if err != nil {
+			return
+		}
+		if _, err := w.Write(binary.BigEndian.AppendUint32(nil, uint32(len(raw)))); err != nil {
+			return
+		}
+		if _, err := w.Write(raw); err != nil {
+			return
+		}
+	}
+}
+
+// echoFastCGI reads the whole body before answering, as PHP does; the
+// client writes all of stdin before it reads anything back.
+func echoFastCGI(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return
+	}
+	w.Header().Set("Content-Type", "text/html")
+	_, _ = w.Write(body)
+}
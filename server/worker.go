@@ -1,8 +1,7 @@
 package server
 
 import (
-	"encoding/binary"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -26,25 +25,66 @@ const (
 )
 
 type Worker struct {
+	id             uint64
 	cmd            *exec.Cmd
-	stdin          io.WriteCloser
-	stdout         io.ReadCloser
-	mu             sync.Mutex // protects cmd/stdin/stdout during request I/O
+	pid            atomic.Int64 // current process id (0 if none); readable without mu
+	proc           procGroup    // platform-specific process group / job object
+	exit           atomic.Pointer[procExit]
+	transport      WorkerTransport
+	dial           func() (WorkerTransport, error) // connects to an external backend; nil spawns php
+	mu             sync.Mutex                      // protects cmd/transport during request I/O
+	gate           priorityGate                    // orders requests queued for mu by priority
 	baseDir        string
 	dead           bool
 	deadMu         sync.RWMutex // protects dead flag
 	maxRequests    int
 	requestTimeout time.Duration
+	timeoutUpdate  atomic.Pointer[time.Duration] // set by SetRequestTimeout; wins over requestTimeout
+	chaos          atomic.Pointer[Chaos]         // set by SetChaos; nil when off
 	requestCount   uint64
 
-	stateMu  sync.RWMutex // protects state + inFlight
+	logger    *log.Logger // nil means the standard logger
+	onRestart func(WorkerRestart)
+	phpBinary string   // interpreter to exec; defaults to "php"
+	phpArgs   []string // extra interpreter args, e.g. -d memory_limit=256M
+	script    string   // worker script relative to baseDir; defaults to php/worker.php
+	codec     Codec    // for the php process's pipes; nil means JSONCodec
+
+	stateMu  sync.RWMutex // protects state + inFlight + scoreboard fields
 	state    WorkerState
 	inFlight int
+
+	queueWaitNs atomic.Int64 // moving average of the wait for mu; written under mu
+
+	historyMu sync.Mutex
+	history   workerHistory
+
+	stderr *logRing // last lines of php stderr, kept across restarts
+
+	// scoreboard view of the request currently on this worker
+	phase    workerPhase
+	curPath  string
+	reqStart time.Time
 }
 
 // NewWorker walks up from the current directory to find go.mod,
 // assumes php/worker.php relative to that, and starts a PHP worker.
 func NewWorker(maxRequests int, requestTimeout time.Duration) (*Worker, error) {
+	w, err := newUnstartedWorker(maxRequests, requestTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := w.spawn(); err != nil {
+		return nil, err
+	}
+
+	return w, nil
+}
+
+// newUnstartedWorker resolves the project root and returns a Worker with no
+// process attached yet.
+func newUnstartedWorker(maxRequests int, requestTimeout time.Duration) (*Worker, error) {
 	wd, err := os.Getwd()
 	if err != nil {
 		return nil, err
@@ -62,40 +102,137 @@ func NewWorker(maxRequests int, requestTimeout time.Duration) (*Worker, error) {
 		baseDir = parent
 	}
 
-	workerPath := filepath.Join(baseDir, "php", "worker.php")
+	return &Worker{
+		id:             nextWorkerID(),
+		baseDir:        baseDir,
+		dead:           false,
+		maxRequests:    maxRequests,
+		requestTimeout: requestTimeout,
+		state:          WorkerIdle,
+	}, nil
+}
 
-	cmd := exec.Command("php", workerPath)
-	cmd.Dir = baseDir
+// NewWorkerWithTransport returns a worker that serves requests over an
+// already-connected transport; nothing is spawned or restarted for it.
+func NewWorkerWithTransport(t WorkerTransport, maxRequests int, requestTimeout time.Duration) *Worker {
+	w := &Worker{
+		id:             nextWorkerID(),
+		transport:      t,
+		maxRequests:    maxRequests,
+		requestTimeout: requestTimeout,
+	}
+	w.dial = func() (WorkerTransport, error) {
+		return nil, errors.New("worker transport cannot be reconnected")
+	}
+	return w
+}
+
+// spawn brings up the PHP side of the worker: it connects to the external
+// backend when the pool uses one, otherwise starts a PHP process.
+func (w *Worker) spawn() error {
+	if w.dial != nil {
+		t, err := w.dial()
+		if err != nil {
+			return err
+		}
+		w.transport = t
+		w.markStarted(false)
+		return nil
+	}
+	if err := w.spawnProcess(); err != nil {
+		return err
+	}
+	w.markStarted(false)
+	return nil
+}
+
+// spawnProcess starts the worker script (php/worker.php by default) under
+// baseDir and talks to it over its stdio pipes. The process is placed in its
+// own process group (Unix) or job object (Windows) so kill() also takes down
+// anything PHP forked.
+func (w *Worker) spawnProcess() error {
+	binary := w.phpBinary
+	if binary == "" {
+		binary = "php"
+	}
+
+	script := w.script
+	if script == "" {
+		script = filepath.Join("php", "worker.php")
+	}
+	if !filepath.IsAbs(script) {
+		script = filepath.Join(w.baseDir, script)
+	}
+
+	args := append(append([]string(nil), w.phpArgs...), script)
+	cmd := exec.Command(binary, args...)
+	cmd.Dir = w.baseDir
+	prepareCommand(cmd)
 
 	stdin, err := cmd.StdinPipe()
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	stdout, err := cmd.StdoutPipe()
 	if err != nil {
 		_ = stdin.Close()
-		return nil, err
+		return err
 	}
 
-	cmd.Stderr = log.Writer()
+	// stderr goes through our own pipe so we know when PHP's last words
+	// have been copied into the ring (see Worker.fatal).
+	if w.stderr == nil {
+		w.stderr = newLogRing(w.id)
+		w.stderr.logger = w.logger
+	}
+	stderrR, stderrW, err := os.Pipe()
+	if err != nil {
+		_ = stdin.Close()
+		_ = stdout.Close()
+		return err
+	}
+	cmd.Stderr = stderrW
 
 	if err := cmd.Start(); err != nil {
 		_ = stdin.Close()
 		_ = stdout.Close()
-		return nil, err
+		_ = stderrR.Close()
+		_ = stderrW.Close()
+		return err
 	}
+	_ = stderrW.Close()
 
-	return &Worker{
-		cmd:            cmd,
-		stdin:          stdin,
-		stdout:         stdout,
-		baseDir:        baseDir,
-		dead:           false,
-		maxRequests:    maxRequests,
-		requestTimeout: requestTimeout,
-		state:          WorkerIdle,
-	}, nil
+	if err := w.proc.attach(cmd); err != nil {
+		logTo(w.logger).Printf("[worker] could not attach process group for pid %d: %v", cmd.Process.Pid, err)
+	}
+
+	w.cmd = cmd
+	w.pid.Store(int64(cmd.Process.Pid))
+	w.exit.Store(watchProcess(cmd.Process, stderrR, w.stderr))
+	w.transport = NewStreamTransport(stdin, stdout, w.codec)
+
+	return nil
+}
+
+// kill terminates the worker process (and its children) and reaps it. For
+// external backends it just drops the connection.
+func (w *Worker) kill() {
+	if w.cmd == nil || w.cmd.Process == nil {
+		if w.transport != nil {
+			_ = w.transport.Close()
+		}
+		return
+	}
+
+	w.pid.Store(0)
+	w.proc.kill(w.cmd)
+	if pe := w.exit.Load(); pe != nil {
+		<-pe.done
+	} else {
+		_, _ = w.cmd.Process.Wait()
+	}
+	w.proc.release()
 }
 
 func (w *Worker) isDead() bool {
@@ -168,44 +305,15 @@ func (w *Worker) restart() error {
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
-	if w.stdin != nil {
-		_ = w.stdin.Close()
+	if w.transport != nil {
+		_ = w.transport.Close()
 	}
-	if w.stdout != nil {
-		_ = w.stdout.Close()
-	}
-	if w.cmd != nil && w.cmd.Process != nil {
-		_ = w.cmd.Process.Kill()
-		_, _ = w.cmd.Process.Wait()
-	}
-
-	workerPath := filepath.Join(w.baseDir, "php", "worker.php")
-	cmd := exec.Command("php", workerPath)
-	cmd.Dir = w.baseDir
+	w.kill()
 
-	stdin, err := cmd.StdinPipe()
-	if err != nil {
+	if err := w.spawn(); err != nil {
 		return err
 	}
 
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		_ = stdin.Close()
-		return err
-	}
-
-	cmd.Stderr = log.Writer()
-
-	if err := cmd.Start(); err != nil {
-		_ = stdin.Close()
-		_ = stdout.Close()
-		return err
-	}
-
-	w.cmd = cmd
-	w.stdin = stdin
-	w.stdout = stdout
-
 	w.deadMu.Lock()
 	w.dead = false
 	w.deadMu.Unlock()
@@ -216,8 +324,20 @@ func (w *Worker) restart() error {
 	w.stateMu.Unlock()
 
 	atomic.StoreUint64(&w.requestCount, 0)
+	w.markStarted(true)
+
+	if w.cmd != nil {
+		logTo(w.logger).Println("Restarted PHP worker in", w.baseDir)
+	} else {
+		logTo(w.logger).Println("Reconnected PHP worker")
+	}
 
-	log.Println("Restarted PHP worker in", w.baseDir)
+	if w.onRestart != nil {
+		w.historyMu.Lock()
+		ev := WorkerRestart{WorkerID: w.id, PID: int(w.pid.Load()), Restarts: w.history.restarts, LastError: w.history.lastErr}
+		w.historyMu.Unlock()
+		w.onRestart(ev)
+	}
 
 	return nil
 }
@@ -232,9 +352,13 @@ func (w *Worker) Handle(payload *RequestPayload) (*ResponsePayload, error) {
 		return nil, ErrWorkerDraining
 	}
 
+	if w.chaos.Load().brokenPipe() {
+		return nil, notSent(errChaosBrokenPipe)
+	}
+
 	w.incrInFlight()
 	w.setState(WorkerBusy)
-	defer func() {
+	idle := func() {
 		w.decrInFlight()
 		if w.getInFlight() == 0 && w.isDraining() {
 			// safe to recycle
@@ -242,19 +366,43 @@ func (w *Worker) Handle(payload *RequestPayload) (*ResponsePayload, error) {
 		} else if !w.isDead() {
 			w.setState(WorkerIdle)
 		}
+	}
+	relaying := false
+	defer func() {
+		if !relaying {
+			idle()
+		}
 	}()
 
+	start := time.Now()
+	sent := false // whether an attempt got the request to PHP
+	var lastErr error
 	for attempt := 0; attempt < 2; attempt++ {
 		if w.isDead() {
 			if err := w.restart(); err != nil {
+				if !sent {
+					err = notSent(err)
+				}
 				return nil, err
 			}
 		}
 
 		resp, err := w.handleRequest(payload)
 		if err != nil {
+			// a PHP fatal would just happen again, so don't replay it
+			if fe := w.fatal(start, err); fe != nil {
+				w.markDead()
+				return nil, fe
+			}
+			if errors.Is(err, ErrProtocol) || errors.Is(err, ErrFrameTooLarge) {
+				// the connection is out of step with the worker now
+				w.markDead()
+				return nil, err
+			}
 			if isBrokenPipe(err) {
 				w.markDead()
+				sent = sent || !errors.Is(err, errNotSent)
+				lastErr = err
 				continue
 			}
 			return nil, err
@@ -266,43 +414,63 @@ func (w *Worker) Handle(payload *RequestPayload) (*ResponsePayload, error) {
 			w.markDead()
 		}
 
+		if resp.relay != nil {
+			// busy until the caller has read the rest of the body
+			relaying = true
+			resp.relay.then(idle)
+		}
 		return resp, nil
 	}
 
-	return nil, io.ErrUnexpectedEOF
+	if !sent {
+		return nil, crashed(lastErr)
+	}
+	return nil, crashed(io.ErrUnexpectedEOF)
+}
+
+// IsBrokenPipe reports whether err means the pipe to a PHP worker was torn
+// down (worker exited, crashed or was killed), on any supported platform.
+func IsBrokenPipe(err error) bool {
+	return isBrokenPipe(err)
 }
 
 func isBrokenPipe(err error) bool {
 	if err == nil {
 		return false
 	}
-	errStr := err.Error()
-	return err == io.EOF ||
-		err == io.ErrUnexpectedEOF ||
-		strings.Contains(errStr, "broken pipe") ||
-		strings.Contains(errStr, "write |1:") ||
-		strings.Contains(errStr, "read |0:")
+	return errors.Is(err, ErrWorkerCrashed) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, io.ErrClosedPipe) ||
+		errors.Is(err, os.ErrClosed) ||
+		isPipeErrno(err)
 }
 
 func (w *Worker) handleRequest(payload *RequestPayload) (*ResponsePayload, error) {
-	w.mu.Lock()
-	defer w.mu.Unlock()
-
-	jsonBytes, err := json.Marshal(payload)
-	if err != nil {
-		return nil, err
-	}
-	length := uint32(len(jsonBytes))
+	payload.queueWait += w.acquire(payload.priority)
+	// a relayed response keeps the worker until its body has been read
+	relaying := false
+	defer func() {
+		if !relaying {
+			w.release()
+		}
+	}()
+	payload.worker, payload.restarts = w.id, w.restarts()
+	payload.attempts++
+	start := time.Now()
+	defer func() { payload.workerTime += time.Since(start) }()
 
-	header := make([]byte, 4)
-	binary.BigEndian.PutUint32(header, length)
+	w.beginRequest(payload.Path)
+	defer func() {
+		if !relaying {
+			w.endRequest()
+		}
+	}()
 
-	if _, err := w.stdin.Write(header); err != nil {
-		return nil, err
-	}
-	if _, err := w.stdin.Write(jsonBytes); err != nil {
-		return nil, err
+	if err := w.transport.Send(payload); err != nil {
+		return nil, notSent(err)
 	}
+	w.setPhase(phaseProcessing)
 
 	type result struct {
 		resp *ResponsePayload
@@ -311,52 +479,41 @@ func (w *Worker) handleRequest(payload *RequestPayload) (*ResponsePayload, error
 
 	resCh := make(chan result, 1)
 
+	delay := w.chaos.Load().delay()
 	go func() {
-		// read length header
-		hdr := make([]byte, 4)
-		if _, err := io.ReadFull(w.stdout, hdr); err != nil {
-			resCh <- result{nil, err}
-			return
-		}
-
-		respLen := binary.BigEndian.Uint32(hdr)
-
-		if respLen == 0 || respLen > 10*1024*1024 {
-			resCh <- result{nil, io.ErrUnexpectedEOF}
-			return
-		}
-
-		respJSON := make([]byte, respLen)
-		if _, err := io.ReadFull(w.stdout, respJSON); err != nil {
-			resCh <- result{nil, err}
-			return
+		if delay > 0 {
+			time.Sleep(delay)
 		}
-
-		var resp ResponsePayload
-		if err := json.Unmarshal(respJSON, &resp); err != nil {
-			resCh <- result{nil, err}
-			return
-		}
-
-		resCh <- result{&resp, nil}
+		resp, err := w.transport.Recv()
+		resCh <- result{resp, err}
 	}()
 
-	if w.requestTimeout > 0 {
+	var res result
+	timeout := w.timeout()
+	if timeout > 0 {
 		select {
-		case res := <-resCh:
-			return res.resp, res.err
-		case <-time.After(w.requestTimeout):
+		case res = <-resCh:
+		case <-time.After(timeout):
 			// Kill and mark dead on timeout
 			w.markDead()
-			if w.cmd != nil && w.cmd.Process != nil {
-				_ = w.cmd.Process.Kill()
-				_, _ = w.cmd.Process.Wait()
-			}
-			return nil, fmt.Errorf("worker request timeout after %s", w.requestTimeout)
+			w.kill()
+			return nil, &timeoutError{op: "request", after: timeout}
 		}
+	} else {
+		res = <-resCh
 	}
 
-	res := <-resCh
+	if res.err == nil && res.resp.Relay {
+		relaying = true
+		w.setPhase(phaseWriting)
+		res.resp.relay = w.newBodyRelay(timeout, timeout-time.Since(start), w.endRequest, w.release)
+		if payload.RelayOver <= 0 {
+			// not asked for: the caller expects the whole body
+			if err := res.resp.ReadBody(); err != nil {
+				return nil, err
+			}
+		}
+	}
 	return res.resp, res.err
 }
 
@@ -365,6 +522,9 @@ func (w *Worker) Stream(req *RequestPayload, rw http.ResponseWriter) error {
 	if w.isDead() || w.isDraining() {
 		return ErrWorkerDead
 	}
+	if w.chaos.Load().brokenPipe() {
+		return errChaosBrokenPipe
+	}
 
 	w.incrInFlight()
 	w.setState(WorkerBusy)
@@ -387,18 +547,15 @@ func (w *Worker) Stream(req *RequestPayload, rw http.ResponseWriter) error {
 		resCh <- result{err: w.streamInternal(req, rw)}
 	}()
 
-	if w.requestTimeout > 0 {
+	if timeout := w.timeout(); timeout > 0 {
 		select {
 		case res := <-resCh:
 			return res.err
-		case <-time.After(w.requestTimeout):
+		case <-time.After(timeout):
 			// Kill and mark dead on timeout
 			w.markDead()
-			if w.cmd != nil && w.cmd.Process != nil {
-				_ = w.cmd.Process.Kill()
-				_, _ = w.cmd.Process.Wait()
-			}
-			return fmt.Errorf("worker stream timeout after %s", w.requestTimeout)
+			w.kill()
+			return &timeoutError{op: "stream", after: timeout}
 		}
 	}
 
@@ -408,8 +565,9 @@ func (w *Worker) Stream(req *RequestPayload, rw http.ResponseWriter) error {
 
 // streamInternal performs the actual length-prefixed send/receive under lock.
 func (w *Worker) streamInternal(req *RequestPayload, rw http.ResponseWriter) error {
-	w.mu.Lock()
-	defer w.mu.Unlock()
+	// not timed: this runs on past a stream timeout, racing the caller
+	w.acquire(req.priority)
+	defer w.release()
 
 	if w.isDead() {
 		if err := w.restart(); err != nil {
@@ -417,52 +575,36 @@ func (w *Worker) streamInternal(req *RequestPayload, rw http.ResponseWriter) err
 		}
 	}
 
-	// 1) Encode and send the request as length-prefixed JSON
-	jsonBytes, err := json.Marshal(req)
-	if err != nil {
-		return err
-	}
-	length := uint32(len(jsonBytes))
-
-	header := make([]byte, 4)
-	binary.BigEndian.PutUint32(header, length)
+	w.beginRequest(req.Path)
+	defer w.endRequest()
 
-	if _, err := w.stdin.Write(header); err != nil {
+	// 1) Send the request
+	if err := w.transport.Send(req); err != nil {
 		return err
 	}
-	if _, err := w.stdin.Write(jsonBytes); err != nil {
-		return err
+	w.setPhase(phaseProcessing)
+
+	chaos := w.chaos.Load()
+	if d := chaos.delay(); d > 0 {
+		time.Sleep(d)
 	}
 
 	headersSent := false
 	statusCode := http.StatusOK
 
 	for {
-		// 2) Read 4-byte frame length
-		hdr := make([]byte, 4)
-		if _, err := io.ReadFull(w.stdout, hdr); err != nil {
-			w.markDead()
-			return err
-		}
-
-		frameLen := binary.BigEndian.Uint32(hdr)
-
-		if frameLen == 0 || frameLen > 10*1024*1024 {
-			w.markDead()
-			return io.ErrUnexpectedEOF
-		}
-
-		// 3) Read JSON frame
-		frameJSON := make([]byte, frameLen)
-		if _, err := io.ReadFull(w.stdout, frameJSON); err != nil {
+		// 2) Read the next frame
+		frame, err := w.transport.RecvFrame()
+		if err != nil {
 			w.markDead()
+			if isBrokenPipe(err) {
+				return crashed(err)
+			}
 			return err
 		}
 
-		var frame StreamFrame
-		if err := json.Unmarshal(frameJSON, &frame); err != nil {
-			w.markDead()
-			return err
+		if frame.Type == "headers" || frame.Type == "chunk" {
+			w.setPhase(phaseWriting)
 		}
 
 		switch frame.Type {
@@ -501,6 +643,9 @@ func (w *Worker) streamInternal(req *RequestPayload, rw http.ResponseWriter) err
 			}
 
 		case "chunk":
+			if chaos.dropFrame() {
+				continue
+			}
 			if !headersSent {
 				rw.WriteHeader(statusCode)
 				headersSent = true
@@ -522,7 +667,8 @@ func (w *Worker) streamInternal(req *RequestPayload, rw http.ResponseWriter) err
 			return fmt.Errorf("stream error from worker: %s", frame.Error)
 
 		default:
-			return fmt.Errorf("unknown stream frame type: %q", frame.Type)
+			w.markDead()
+			return protocolError("unknown stream frame type: %q", frame.Type)
 		}
 	}
 }
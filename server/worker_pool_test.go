@@ -1,6 +1,7 @@
 package server
 
 import (
+	"errors"
 	"testing"
 	"time"
 )
@@ -128,6 +129,36 @@ func TestScaleToGrowUsesFactory(t *testing.T) {
 	}
 }
 
+func TestScaleToGrowFailureKeepsSize(t *testing.T) {
+	w1 := &Worker{}
+	pool := &WorkerPool{
+		workers: []*Worker{w1},
+	}
+
+	var created []*Worker
+	factory := func() (*Worker, error) {
+		if len(created) == 2 {
+			return nil, errors.New("spawn failed")
+		}
+		w := &Worker{}
+		created = append(created, w)
+		return w, nil
+	}
+
+	// Grow from 1 -> 4; the third new worker fails
+	if err := pool.ScaleTo(4, factory); err == nil {
+		t.Fatalf("ScaleTo(4) should report the failed spawn")
+	}
+	if got := len(pool.workers); got != 1 || pool.workers[0] != w1 {
+		t.Fatalf("expected the pool to keep its single worker, got %d", got)
+	}
+	for i, w := range created {
+		if !w.isDead() {
+			t.Fatalf("worker %d started before the failure should be stopped", i+1)
+		}
+	}
+}
+
 func TestStatsCountsDeadWorkers(t *testing.T) {
 	w1 := &Worker{}
 	w2 := &Worker{}
@@ -3,6 +3,8 @@ package server
 import (
 	"errors"
 	"io"
+	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 )
@@ -14,7 +16,7 @@ func TestWorkerHandleHappyPath(t *testing.T) {
 		ID:     "abc",
 		Method: "GET",
 		Path:   "/test",
-		Body:   "",
+		Body:   nil,
 	})
 
 	if err != nil {
@@ -25,7 +27,7 @@ func TestWorkerHandleHappyPath(t *testing.T) {
 		t.Fatalf("expected status 200, got %d", resp.Status)
 	}
 
-	if resp.Body != "w0:/test" {
+	if string(resp.Body) != "w0:/test" {
 		t.Fatalf("unexpected response body: %q", resp.Body)
 	}
 }
@@ -50,7 +52,7 @@ func TestWorkerPoolDispatch(t *testing.T) {
 		ID:     "1",
 		Method: "GET",
 		Path:   "/foo",
-		Body:   "",
+		Body:   nil,
 	})
 
 	if err != nil {
@@ -65,8 +67,8 @@ func TestWorkerPoolDispatch(t *testing.T) {
 func TestWorkerTimeoutMarksDead(t *testing.T) {
 	// Build a worker with no responding goroutine to force timeout.
 	w := &Worker{
-		stdin:          nopWriteCloser{Writer: io.Discard}, //writes go nowhere
-		stdout:         nopReadCloser{},                    // reads block/eof
+		// writes go nowhere, reads block/eof
+		transport:      NewStreamTransport(nopWriteCloser{Writer: io.Discard}, nopReadCloser{}, nil),
 		maxRequests:    1000,
 		requestTimeout: time.Millisecond,
 	}
@@ -75,7 +77,7 @@ func TestWorkerTimeoutMarksDead(t *testing.T) {
 		ID:     "1",
 		Method: "GET",
 		Path:   "/timeout",
-		Body:   "",
+		Body:   nil,
 	})
 
 	if err == nil {
@@ -91,3 +93,62 @@ type nopReadCloser struct{}
 
 func (nopReadCloser) Read(p []byte) (int, error) { return 0, io.EOF }
 func (nopReadCloser) Close() error               { return nil }
+
+func TestWorkerErrorCategories(t *testing.T) {
+	stream := func(out string) *Worker {
+		return NewWorkerWithTransport(NewStreamTransport(nopWriteCloser{Writer: io.Discard}, io.NopCloser(strings.NewReader(out)), nil), 0, time.Second)
+	}
+	slow := NewWorkerWithTransport(&scriptedTransport{reply: func(req *RequestPayload) (*ResponsePayload, error) {
+		time.Sleep(50 * time.Millisecond)
+		return &ResponsePayload{ID: req.ID, Status: 200}, nil
+	}}, 0, time.Millisecond)
+	unknownFrame := NewWorkerWithTransport(&scriptedTransport{frames: []*StreamFrame{{Type: "headers", Status: 200}, {Type: "bogus"}}}, 0, time.Second)
+	hungUp := NewWorkerWithTransport(&scriptedTransport{frames: []*StreamFrame{{Type: "headers", Status: 200}}}, 0, time.Second)
+
+	for _, tc := range []struct {
+		name     string
+		w        *Worker
+		stream   bool
+		want     error
+		category string
+	}{
+		{"timeout", slow, false, ErrWorkerTimeout, CategoryTimeout},
+		{"bad json", stream(frameJSON("{nope")), false, ErrProtocol, CategoryProtocol},
+		{"empty message", stream("\x00\x00\x00\x00"), false, ErrProtocol, CategoryProtocol},
+		{"oversized", stream("\xff\xff\xff\xff"), false, ErrFrameTooLarge, CategoryFrameSize},
+		{"unknown frame", unknownFrame, true, ErrProtocol, CategoryProtocol},
+		{"hung up mid-stream", hungUp, true, ErrWorkerCrashed, CategoryCrashed},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var err error
+			if tc.stream {
+				err = tc.w.Stream(&RequestPayload{ID: "1", Path: "/s"}, httptest.NewRecorder())
+			} else {
+				_, err = tc.w.Handle(&RequestPayload{ID: "1", Path: "/x"})
+			}
+			if !errors.Is(err, tc.want) {
+				t.Fatalf("err = %v, want %v", err, tc.want)
+			}
+			if got := ErrorCategory(err); got != tc.category {
+				t.Errorf("ErrorCategory = %q, want %q", got, tc.category)
+			}
+			if !tc.w.isDead() {
+				t.Errorf("worker still alive after %v", err)
+			}
+		})
+	}
+}
+
+func TestCrashedKeepsCause(t *testing.T) {
+	s := &Server{pools: map[string]*WorkerPool{FastPool: newFakePool(t, 1, time.Second)}, poolOrder: []string{FastPool}}
+	if err := s.SetChaos(Chaos{BrokenPipePercent: 100}); err != nil {
+		t.Fatal(err)
+	}
+	_, err := s.Dispatch(&RequestPayload{ID: "1", Method: "GET", Path: "/x"})
+	if !errors.Is(err, ErrWorkerCrashed) || !strings.Contains(err.Error(), "chaos: broken pipe") {
+		t.Fatalf("Dispatch error = %v, want ErrWorkerCrashed with its cause", err)
+	}
+	if errors.Is(err, ErrWorkerTimeout) || ErrorCategory(errors.New("broken pipe")) != CategoryOther {
+		t.Fatal("categories must come from the error's type, not its message")
+	}
+}
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// WorkerHealth is the health detail of one worker slot.
+type WorkerHealth struct {
+	ID            uint64     `json:"id,omitempty"` // for /__baremetal/workers/{id}/logs
+	Slot          int        `json:"slot"`
+	PID           int        `json:"pid,omitempty"` // 0 for external transports or no process
+	State         string     `json:"state"`         // "idle", "busy", "draining" or "dead"
+	UptimeSeconds float64    `json:"uptime_seconds"`
+	Requests      uint64     `json:"requests"` // since the last (re)start
+	Restarts      uint64     `json:"restarts"`
+	LastRestart   *time.Time `json:"last_restart,omitempty"`
+	LastError     string     `json:"last_error,omitempty"`
+	LastErrorAt   *time.Time `json:"last_error_at,omitempty"`
+}
+
+// workerHistory records lifecycle events for health reporting.
+type workerHistory struct {
+	startedAt   time.Time
+	restarts    uint64
+	lastRestart time.Time
+	lastErr     string
+	lastErrAt   time.Time
+}
+
+func (w *Worker) markStarted(restart bool) {
+	now := time.Now()
+	w.historyMu.Lock()
+	w.history.startedAt = now
+	if restart {
+		w.history.restarts++
+		w.history.lastRestart = now
+	}
+	w.historyMu.Unlock()
+}
+
+// restarts returns how many times the worker got a fresh process.
+func (w *Worker) restarts() uint64 {
+	w.historyMu.Lock()
+	defer w.historyMu.Unlock()
+	return w.history.restarts
+}
+
+func (w *Worker) recordError(err error) {
+	if err == nil {
+		return
+	}
+	w.historyMu.Lock()
+	w.history.lastErr = err.Error()
+	w.history.lastErrAt = time.Now()
+	w.historyMu.Unlock()
+}
+
+// health snapshots the worker for HealthSummary.
+func (w *Worker) health(slot int, now time.Time) WorkerHealth {
+	h := WorkerHealth{
+		ID:       w.id,
+		Slot:     slot,
+		PID:      int(w.pid.Load()),
+		Requests: atomic.LoadUint64(&w.requestCount),
+	}
+
+	w.stateMu.RLock()
+	state, inFlight := w.state, w.inFlight
+	w.stateMu.RUnlock()
+	switch {
+	case w.isDead() || state == WorkerDead:
+		h.State = SlotDead
+	case state == WorkerDraining:
+		h.State = SlotDraining
+	case inFlight > 0 || state == WorkerBusy:
+		h.State = "busy"
+	default:
+		h.State = SlotIdle
+	}
+
+	w.historyMu.Lock()
+	hist := w.history
+	w.historyMu.Unlock()
+
+	if !hist.startedAt.IsZero() && h.State != SlotDead {
+		h.UptimeSeconds = now.Sub(hist.startedAt).Seconds()
+	}
+	h.Restarts = hist.restarts
+	if !hist.lastRestart.IsZero() {
+		h.LastRestart = &hist.lastRestart
+	}
+	if hist.lastErr != "" {
+		h.LastError = hist.lastErr
+		h.LastErrorAt = &hist.lastErrAt
+	}
+	return h
+}
@@ -0,0 +1,87 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestPoolStatsWorkerDetails(t *testing.T) {
+	pool := newFakePool(t, 2, time.Second)
+	pool.workers[0].markStarted(false)
+
+	if _, err := pool.dispatchOn(pool.workers[0], &RequestPayload{ID: "1", Method: "GET", Path: "/"}); err != nil {
+		t.Fatalf("dispatch: %v", err)
+	}
+
+	crashed := newCrashingWorker()
+	pool.workers[1] = crashed
+	if _, err := pool.dispatchOn(crashed, &RequestPayload{ID: "2", Method: "GET", Path: "/"}); err == nil {
+		t.Fatalf("expected the crashed worker to fail")
+	}
+
+	stats := pool.Stats()
+	if len(stats.Details) != 2 {
+		t.Fatalf("expected 2 worker entries, got %+v", stats.Details)
+	}
+
+	ok := stats.Details[0]
+	if ok.State != SlotIdle || ok.Requests != 1 || ok.UptimeSeconds <= 0 || ok.LastError != "" {
+		t.Fatalf("unexpected healthy worker entry: %+v", ok)
+	}
+
+	sick := stats.Details[1]
+	if sick.State != SlotDead || sick.LastError != "spawn failed" || sick.LastErrorAt == nil {
+		t.Fatalf("unexpected crashed worker entry: %+v", sick)
+	}
+}
+
+func TestWorkerHealthCountsRestarts(t *testing.T) {
+	w := newFakeWorker(t, "w0", time.Second)
+	w.markStarted(false)
+	w.markStarted(true)
+
+	h := w.health(0, time.Now())
+	if h.Restarts != 1 || h.LastRestart == nil {
+		t.Fatalf("expected one restart, got %+v", h)
+	}
+}
+
+func TestWorkerRestartNotifiesPool(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer c.Close()
+		}
+	}()
+
+	events := make(chan WorkerRestart, 1)
+	cfg := PoolConfig{Name: "reports", Transport: TransportTCP, Address: ln.Addr().String(),
+		OnRestart: func(ev WorkerRestart) { events <- ev }}
+	w, err := cfg.newWorker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.spawn(); err != nil {
+		t.Fatal(err)
+	}
+	defer w.kill()
+
+	w.recordError(errors.New("worker crashed"))
+	if err := w.restart(); err != nil {
+		t.Fatal(err)
+	}
+	ev := <-events
+	if ev.Pool != "reports" || ev.WorkerID != w.id || ev.Restarts != 1 || ev.LastError != "worker crashed" {
+		t.Fatalf("unexpected restart event: %+v", ev)
+	}
+}
@@ -18,8 +18,9 @@ type WSClient struct {
 }
 
 type WSHub struct {
-	mu      sync.RWMutex
-	clients map[string]map[*WSClient]struct{} // channel -> clients
+	mu       sync.RWMutex
+	clients  map[string]map[*WSClient]struct{} // channel -> clients
+	counters hubCounters
 }
 
 func NewWSHub() *WSHub {
@@ -54,6 +55,11 @@ func (h *WSHub) Unsubscribe(channel string, c *WSClient) {
 		return
 	}
 
+	if _, ok := subs[c]; !ok {
+		// already removed (e.g. by CloseChannel)
+		return
+	}
+
 	delete(subs, c)
 	close(c.Send)
 	if len(subs) == 0 {
@@ -61,6 +67,21 @@ func (h *WSHub) Unsubscribe(channel string, c *WSClient) {
 	}
 }
 
+// CloseChannel drops every client subscribed to channel, closing their send
+// channels so connection handlers hang up. It returns the number of clients
+// removed.
+func (h *WSHub) CloseChannel(channel string) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	subs := h.clients[channel]
+	for c := range subs {
+		close(c.Send)
+	}
+	delete(h.clients, channel)
+	return len(subs)
+}
+
 // Publish broadcasts a message to all clients on the given channel.
 func (h *WSHub) Publish(channel, msgType string, payload any) {
 	data, err := json.Marshal(payload)
@@ -69,6 +90,7 @@ func (h *WSHub) Publish(channel, msgType string, payload any) {
 		return
 	}
 
+	h.counters.published.Add(1)
 	ev := WSMessage{
 		Channel: channel,
 		Type:    msgType,
@@ -80,12 +102,24 @@ func (h *WSHub) Publish(channel, msgType string, payload any) {
 	for c := range subs {
 		select {
 		case c.Send <- ev:
-
+			h.counters.delivered.Add(1)
 		default:
 			// client is slow / buffer full, drop message
-
+			h.counters.dropped.Add(1)
 		}
 	}
 
 	h.mu.RUnlock()
 }
+
+// Stats returns subscription gauges and delivery counters.
+func (h *WSHub) Stats() HubStats {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	clients := 0
+	for _, subs := range h.clients {
+		clients += len(subs)
+	}
+	return h.counters.stats(len(h.clients), clients)
+}
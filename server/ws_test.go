@@ -35,10 +35,11 @@ func TestWSHubSubscribeAndPublishSingleClient(t *testing.T) {
 
 		var data map[string]any
 		if err := json.Unmarshal(msg.Data, &data); err != nil {
-			t.Fatalf("unmarshal error: %v", err)
+			t.Errorf("unmarshal error: %v", err)
+			return
 		}
 		if data["foo"] != "bar" {
-			t.Fatalf("expected foo=bar, got %v", data["foo"])
+			t.Errorf("expected foo=bar, got %v", data["foo"])
 		}
 	}()
 
@@ -93,3 +94,31 @@ func BenchmarkWSHubPublishManyClients(b *testing.B) {
 		hub.Publish("bench", "bench", map[string]string{"msg": "x"})
 	}
 }
+
+func TestWSHubCloseChannel(t *testing.T) {
+	hub := NewWSHub()
+
+	a := hub.Subscribe("user:42")
+	b := hub.Subscribe("user:42")
+	other := hub.Subscribe("user:7")
+	defer hub.Unsubscribe("user:7", other)
+
+	if n := hub.CloseChannel("user:42"); n != 2 {
+		t.Fatalf("CloseChannel closed %d clients, want 2", n)
+	}
+
+	if _, ok := <-a.Send; ok {
+		t.Fatalf("expected client a send channel to be closed")
+	}
+	if _, ok := <-b.Send; ok {
+		t.Fatalf("expected client b send channel to be closed")
+	}
+
+	// The handler's deferred Unsubscribe must not double-close.
+	hub.Unsubscribe("user:42", a)
+
+	hub.Publish("user:7", "ping", nil)
+	if msg := <-other.Send; msg.Type != "ping" {
+		t.Fatalf("other channel should still receive messages, got %+v", msg)
+	}
+}